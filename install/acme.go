@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ACME challenge types
+const (
+	challengeHTTP    = "http-01"
+	challengeTLSALPN = "tls-alpn-01"
+	challengeDNS     = "dns-01"
+)
+
+const dnsProviderCheckTimeout = 10 * time.Second
+
+// Let's Encrypt ACME directories
+const (
+	letsEncryptProduction = "https://acme-v02.api.letsencrypt.org/directory"
+	letsEncryptStaging    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// acmeRateLimitWindow is the window of Let's Encrypt's duplicate certificate
+// limit, re-installs within it are offered staging certificates
+const acmeRateLimitWindow = 7 * 24 * time.Hour
+
+// acmeStagingFlag is set by --acme-staging
+var acmeStagingFlag bool
+
+// previousInstallAttempt is when the installer last ran in the install
+// directory, zero when it never did
+var previousInstallAttempt time.Time
+
+// addACMEStagingFlag registers --acme-staging on fs
+func addACMEStagingFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&acmeStagingFlag, "acme-staging", false, "Request certificates from the Let's Encrypt staging directory, which is not trusted by browsers but has far higher rate limits")
+}
+
+// ACMECAServer is the ACME directory Traefik requests certificates from
+func (c Config) ACMECAServer() string {
+	if c.ACMEStaging {
+		return letsEncryptStaging
+	}
+	return letsEncryptProduction
+}
+
+// lastInstallAttempt returns when the installer last ran in dir, judged by the
+// install log and the certificate store an earlier attempt left behind
+func lastInstallAttempt(dir string) time.Time {
+	var last time.Time
+	for _, path := range []string{filepath.Join(dir, installLogName), filepath.Join(dir, "config", "letsencrypt", "acme.json")} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// collectACMEStaging decides between staging and production certificates.
+// Without --acme-staging it only asks when the installer ran here recently.
+func collectACMEStaging(config *Config) {
+	config.ACMEStaging = acmeStagingFlag
+	if acmeStagingFlag {
+		recordFlagAnswer("acme_staging", "true")
+	}
+	if config.ACMEStaging || previousInstallAttempt.IsZero() || time.Since(previousInstallAttempt) > acmeRateLimitWindow {
+		return
+	}
+	infof("The installer already ran in this directory %s ago. Let's Encrypt issues at most 5 production certificates for the same domains per week.\n", time.Since(previousInstallAttempt).Round(time.Minute))
+	config.ACMEStaging = readBool("acme_staging", tr("prompt.acme_staging"), true)
+}
+
+// printStagingNotice explains how to move from staging to production
+// certificates, it is part of the final summary
+func printStagingNotice(config Config) {
+	if !config.ACMEStaging {
+		return
+	}
+	warnf("\nWarning: Let's Encrypt STAGING certificates are in use, browsers show them as untrusted.\n")
+	infoln("To switch to production certificates:")
+	infof("  1. Set caServer to %s in config/traefik/traefik_config.yml\n", letsEncryptProduction)
+	infoln("  2. Delete config/letsencrypt/acme.json, it holds the staging account and certificates")
+	infoln("  3. Restart Traefik, e.g. docker restart traefik")
+}
+
+// TLSALPNChallenge reports whether Let's Encrypt validates over the HTTPS
+// entrypoint instead of HTTP
+func (c Config) TLSALPNChallenge() bool {
+	return c.ACMEChallenge == challengeTLSALPN
+}
+
+// DNSChallenge reports whether Let's Encrypt validates with a TXT record
+// created through the DNS provider's API
+func (c Config) DNSChallenge() bool {
+	return c.ACMEChallenge == challengeDNS
+}
+
+// dnsCredential is an environment variable Traefik passes to its DNS provider
+type dnsCredential struct {
+	Env     string
+	Prompt  string
+	Secret  bool
+	Default string
+}
+
+// dnsProvider is a DNS-01 provider supported by Traefik, Name is its provider
+// code in the certificate resolver
+type dnsProvider struct {
+	Name        string
+	Label       string
+	Credentials []dnsCredential
+	// Check exercises the credentials with a read-only API call
+	Check func(ctx context.Context, credentials map[string]string) error
+}
+
+var dnsProviders = []dnsProvider{
+	{
+		Name:  "cloudflare",
+		Label: "Cloudflare",
+		Credentials: []dnsCredential{
+			{Env: "CF_DNS_API_TOKEN", Prompt: "Enter a Cloudflare API token with Zone:DNS:Edit permission", Secret: true},
+		},
+		Check: func(ctx context.Context, credentials map[string]string) error {
+			var result struct {
+				Success bool `json:"success"`
+				Errors  []struct {
+					Message string `json:"message"`
+				} `json:"errors"`
+			}
+			err := dnsProviderGet(ctx, "https://api.cloudflare.com/client/v4/user/tokens/verify", map[string]string{"Authorization": "Bearer " + credentials["CF_DNS_API_TOKEN"]}, &result)
+			if err == nil && !result.Success {
+				err = fmt.Errorf("the token was rejected")
+				if len(result.Errors) > 0 {
+					err = fmt.Errorf("the token was rejected: %s", result.Errors[0].Message)
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name:  "route53",
+		Label: "Amazon Route 53",
+		Credentials: []dnsCredential{
+			{Env: "AWS_ACCESS_KEY_ID", Prompt: "Enter the AWS access key ID"},
+			{Env: "AWS_SECRET_ACCESS_KEY", Prompt: "Enter the AWS secret access key", Secret: true},
+			{Env: "AWS_REGION", Prompt: "Enter the AWS region", Default: "us-east-1"},
+		},
+		Check: func(ctx context.Context, credentials map[string]string) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://route53.amazonaws.com/2013-04-01/hostedzone?maxitems=1", nil)
+			if err != nil {
+				return err
+			}
+			// Route 53 is a global service signed in us-east-1
+			signAWSRequest(req, credentials["AWS_ACCESS_KEY_ID"], credentials["AWS_SECRET_ACCESS_KEY"], "us-east-1", "route53", time.Now())
+			return dnsProviderDo(req, nil)
+		},
+	},
+	{
+		Name:  "digitalocean",
+		Label: "DigitalOcean",
+		Credentials: []dnsCredential{
+			{Env: "DO_AUTH_TOKEN", Prompt: "Enter a DigitalOcean API token with write scope", Secret: true},
+		},
+		Check: func(ctx context.Context, credentials map[string]string) error {
+			return dnsProviderGet(ctx, "https://api.digitalocean.com/v2/account", map[string]string{"Authorization": "Bearer " + credentials["DO_AUTH_TOKEN"]}, nil)
+		},
+	},
+	{
+		Name:  "hetzner",
+		Label: "Hetzner DNS",
+		Credentials: []dnsCredential{
+			{Env: "HETZNER_API_KEY", Prompt: "Enter a Hetzner DNS API token", Secret: true},
+		},
+		Check: func(ctx context.Context, credentials map[string]string) error {
+			return dnsProviderGet(ctx, "https://dns.hetzner.com/api/v1/zones?per_page=1", map[string]string{"Auth-API-Token": credentials["HETZNER_API_KEY"]}, nil)
+		},
+	},
+	{
+		Name:  "gandiv5",
+		Label: "Gandi LiveDNS",
+		Credentials: []dnsCredential{
+			{Env: "GANDIV5_PERSONAL_ACCESS_TOKEN", Prompt: "Enter a Gandi personal access token with the Manage domain technical configurations permission", Secret: true},
+		},
+		Check: func(ctx context.Context, credentials map[string]string) error {
+			return dnsProviderGet(ctx, "https://api.gandi.net/v5/livedns/domains?per_page=1", map[string]string{"Authorization": "Bearer " + credentials["GANDIV5_PERSONAL_ACCESS_TOKEN"]}, nil)
+		},
+	},
+	{
+		Name:  "linode",
+		Label: "Akamai Linode",
+		Credentials: []dnsCredential{
+			{Env: "LINODE_TOKEN", Prompt: "Enter a Linode personal access token with Domains read/write scope", Secret: true},
+		},
+		Check: func(ctx context.Context, credentials map[string]string) error {
+			return dnsProviderGet(ctx, "https://api.linode.com/v4/domains?page_size=25", map[string]string{"Authorization": "Bearer " + credentials["LINODE_TOKEN"]}, nil)
+		},
+	},
+	{
+		Name:  "vultr",
+		Label: "Vultr",
+		Credentials: []dnsCredential{
+			{Env: "VULTR_API_KEY", Prompt: "Enter a Vultr API key", Secret: true},
+		},
+		Check: func(ctx context.Context, credentials map[string]string) error {
+			return dnsProviderGet(ctx, "https://api.vultr.com/v2/domains?per_page=1", map[string]string{"Authorization": "Bearer " + credentials["VULTR_API_KEY"]}, nil)
+		},
+	},
+}
+
+func findDNSProvider(name string) (dnsProvider, bool) {
+	i := slices.IndexFunc(dnsProviders, func(p dnsProvider) bool { return p.Name == name })
+	if i < 0 {
+		return dnsProvider{}, false
+	}
+	return dnsProviders[i], true
+}
+
+// collectCertificateChallenge asks how Let's Encrypt validates the dashboard
+// certificate. The default is the challenge that works with the chosen ports,
+// DNS-01 when a wildcard certificate was asked for.
+func collectCertificateChallenge(config *Config) {
+	defaultChallenge := challengeHTTP
+	noPort80 := !config.HTTPEnabled() || config.HTTPPort != defaultHTTPPort
+	switch {
+	case noPort80 && config.HTTPSPort == defaultHTTPSPort:
+		defaultChallenge = challengeTLSALPN
+	case noPort80:
+		defaultChallenge = challengeDNS
+	}
+	if validateWildcardChallenge(defaultChallenge) != nil {
+		defaultChallenge = challengeDNS
+	}
+	challenges := []Option{
+		{Value: challengeHTTP, Label: "HTTP-01", Description: "needs port 80 reachable from the internet"},
+		{Value: challengeTLSALPN, Label: "TLS-ALPN-01", Description: "needs port 443 reachable from the internet"},
+		{Value: challengeDNS, Label: "DNS-01", Description: "creates a TXT record through your DNS provider's API, allows wildcards"},
+	}
+	config.ACMEChallenge = readSelectValidated("acme_challenge", tr("prompt.acme_challenge"), challenges, defaultChallenge, func(challenge string) error {
+		if err := config.validateChallenge(challenge); err != nil {
+			return err
+		}
+		return validateWildcardChallenge(challenge)
+	})
+	if config.DNSChallenge() {
+		collectDNSProvider(config)
+		collectWildcardDomain(config)
+	}
+	collectACMEStaging(config)
+}
+
+// validateWildcardChallenge rejects a challenge other than DNS-01 when a
+// wildcard certificate was asked for by a flag, variable or answers file,
+// Let's Encrypt only issues wildcards through a DNS provider
+func validateWildcardChallenge(challenge string) error {
+	if challenge == challengeDNS {
+		return nil
+	}
+	wildcard := false
+	if value, _, ok := presetValue("wildcard_cert"); ok {
+		wildcard, _ = parseBool(value)
+	}
+	if value, _, ok := presetValue("wildcard_domain"); ok && value != "" {
+		wildcard = true
+	}
+	if wildcard {
+		return errors.New("a wildcard certificate needs the dns-01 challenge and a DNS provider. Choose dns-01, or drop the wildcard certificate")
+	}
+	return nil
+}
+
+// collectWildcardDomain asks whether to request a wildcard certificate, which
+// needs DNS-01, and for the domain it covers
+func collectWildcardDomain(config *Config) {
+	config.WildcardDomain = ""
+	// Without a base domain --yes reports it missing, the wildcard below it
+	// has no default then
+	if !readBool("wildcard_cert", tr("prompt.wildcard_cert"), false) || answerMissing("base_domain") {
+		return
+	}
+	input := readValidated("wildcard_domain", tr("prompt.wildcard_domain", config.BaseDomain), "*."+config.BaseDomain, func(s string) error {
+		_, err := validateWildcardDomain(s, config.BaseDomain)
+		return err
+	})
+	config.WildcardDomain, _ = validateWildcardDomain(input, config.BaseDomain)
+}
+
+// validateWildcardDomain returns the domain below the wildcard label, e.g.
+// apps.example.com for *.apps.example.com
+func validateWildcardDomain(input, baseDomain string) (string, error) {
+	domain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(input)), ".")
+	domain = strings.TrimPrefix(domain, "*.")
+	if strings.Contains(domain, "*") {
+		return "", fmt.Errorf("%s is deeper than one level: a wildcard only matches a single label, so *.example.com covers apps.example.com but not a.apps.example.com, and certificate authorities do not issue *.*.example.com. Request the wildcard for the level your resources use, e.g. *.apps.example.com", input)
+	}
+	if domain == "" || strings.Contains(domain, "..") {
+		return "", fmt.Errorf("%s is not a valid domain", input)
+	}
+	if domain != baseDomain && !strings.HasSuffix(domain, "."+baseDomain) {
+		return "", fmt.Errorf("%s is not in %s, the DNS-01 challenge can only create records in the base domain's zone", input, baseDomain)
+	}
+	return domain, nil
+}
+
+// WildcardSANs are the names of the wildcard certificate besides its main
+// domain, including the dashboard when the wildcard does not cover it
+func (c Config) WildcardSANs() []string {
+	sans := []string{"*." + c.WildcardDomain}
+	_, parent, _ := strings.Cut(c.DashboardDomain, ".")
+	if c.DashboardDomain != c.WildcardDomain && parent != c.WildcardDomain {
+		sans = append(sans, c.DashboardDomain)
+	}
+	return sans
+}
+
+// collectDNSProvider asks for the DNS provider and its credentials and
+// optionally checks them against the provider's API
+func collectDNSProvider(config *Config) {
+	options := make([]Option, len(dnsProviders))
+	for i, provider := range dnsProviders {
+		options[i] = Option{Value: provider.Name, Label: provider.Label}
+	}
+
+	for {
+		config.DNSProvider = readSelect("dns_provider", tr("prompt.dns_provider", config.BaseDomain), options, orDefault(config.DNSProvider, options[0].Value))
+		provider, _ := findDNSProvider(config.DNSProvider)
+		config.DNSCredentials = map[string]string{}
+		for _, credential := range provider.Credentials {
+			key := strings.ToLower(credential.Env)
+			if credential.Secret {
+				config.DNSCredentials[credential.Env] = readPassword(key, credential.Prompt)
+			} else {
+				config.DNSCredentials[credential.Env] = readString(key, credential.Prompt, credential.Default)
+			}
+		}
+
+		if !network.allow("DNS provider credential check") {
+			return
+		}
+		if !readBool("dns_provider_check", tr("prompt.dns_provider_check"), true) {
+			return
+		}
+		for {
+			err := runStep(context.Background(), "Checking the "+provider.Label+" credentials", func(ctx context.Context) error {
+				ctx, cancel := context.WithTimeout(ctx, dnsProviderCheckTimeout)
+				defer cancel()
+				return provider.Check(ctx, config.DNSCredentials)
+			})
+			if err == nil {
+				infof("The %s credentials are valid.\n", provider.Label)
+				return
+			}
+
+			errorf("The %s credentials were not accepted: %v\n", provider.Label, err)
+			switch readChoice("dns_provider_check_failed", tr("prompt.dns_provider_check_failed"), []string{"retry", "edit", "continue"}, "edit") {
+			case "retry":
+				continue
+			case "continue":
+				warnf("Warning: the DNS credentials were not verified, certificate issuance fails if they are wrong.\n")
+				return
+			}
+			break
+		}
+	}
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func dnsProviderGet(ctx context.Context, url string, headers map[string]string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return dnsProviderDo(req, out)
+}
+
+// dnsProviderDo sends req and decodes a JSON body into out. Cloudflare
+// reports errors in a 200 response, the others use the status code.
+func dnsProviderDo(req *http.Request, out any) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("%s: the credentials were rejected", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK && out == nil {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("unexpected response from %s: %v", req.URL.Host, err)
+		}
+	}
+	return nil
+}
+
+// signAWSRequest adds a Signature Version 4 Authorization header to a request
+// without a body
+func signAWSRequest(req *http.Request, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	host := req.URL.Host
+	payloadHash := sha256Hex("")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		orDefault(req.URL.EscapedPath(), "/"),
+		req.URL.Query().Encode(),
+		"host:" + host + "\nx-amz-date:" + amzDate + "\n",
+		"host;x-amz-date",
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders=host;x-amz-date, Signature="+signature)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// installedDNSCredentials returns the DNS provider credentials from the
+// traefik service of an installed compose file, with references to .env
+// resolved
+func installedDNSCredentials() map[string]string {
+	data, err := os.ReadFile("docker-compose.yml")
+	if err != nil {
+		return nil
+	}
+	var compose struct {
+		Services struct {
+			Traefik struct {
+				Environment map[string]string `yaml:"environment"`
+			} `yaml:"traefik"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return nil
+	}
+	vars, _ := readEnvFile()
+	credentials := map[string]string{}
+	for _, provider := range dnsProviders {
+		for _, credential := range provider.Credentials {
+			if value, ok := compose.Services.Traefik.Environment[credential.Env]; ok {
+				credentials[credential.Env] = resolveEnvReference(value, vars)
+			}
+		}
+	}
+	return credentials
+}
+
+// dnsSecretValues returns the values of the secret credentials, for redaction
+func dnsSecretValues(credentials map[string]string) []string {
+	var secrets []string
+	for _, provider := range dnsProviders {
+		for _, credential := range provider.Credentials {
+			if value, ok := credentials[credential.Env]; ok && credential.Secret {
+				secrets = append(secrets, value)
+			}
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestCollectWildcardDomainInvalidPreset(t *testing.T) {
+	tests := []struct {
+		name       string
+		flags      map[string]string
+		yes        bool
+		baseDomain string
+	}{
+		{"outside the base domain", map[string]string{"wildcard_cert": "true", "wildcard_domain": "*.example.org"}, false, "example.com"},
+		{"two levels", map[string]string{"wildcard_cert": "true", "wildcard_domain": "*.*.example.com"}, false, "example.com"},
+		{"default without a base domain", map[string]string{"wildcard_cert": "true"}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAnswers(t, tt.flags)
+			acceptDefaults = tt.yes
+			config := Config{BaseDomain: tt.baseDomain}
+			if code := catchExit(t, func() { collectWildcardDomain(&config) }); code != exitInvalidInput {
+				t.Fatalf("exit code %d, want %d", code, exitInvalidInput)
+			}
+		})
+	}
+}
+
+func TestCollectWildcardDomainPreset(t *testing.T) {
+	withAnswers(t, map[string]string{"wildcard_cert": "true", "wildcard_domain": "*.Apps.Example.com."})
+	config := Config{BaseDomain: "example.com"}
+	if code := catchExit(t, func() { collectWildcardDomain(&config) }); code != -1 {
+		t.Fatalf("exited with %d", code)
+	}
+	if config.WildcardDomain != "apps.example.com" {
+		t.Errorf("WildcardDomain = %q, want apps.example.com", config.WildcardDomain)
+	}
+}
+
+func TestCollectWildcardDomainMissingBaseDomain(t *testing.T) {
+	withAnswers(t, map[string]string{"wildcard_cert": "true"})
+	acceptDefaults, collectingAnswers = true, true
+	missingAnswers = []string{"base_domain"}
+	config := Config{}
+	if code := catchExit(t, func() { collectWildcardDomain(&config) }); code != -1 {
+		t.Fatalf("exited with %d", code)
+	}
+	if config.WildcardDomain != "" {
+		t.Errorf("WildcardDomain = %q, want none", config.WildcardDomain)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"time"
+	"unicode"
+)
+
+const (
+	adminTokenFile = "config/secrets/admin-api-token"
+	adminTokenName = "installer provisioning"
+)
+
+// adminTokenActions let provisioning tools manage sites, resources and their
+// targets right after the install
+var adminTokenActions = []string{
+	"listOrgs",
+	"getOrg",
+	"createSite",
+	"listSites",
+	"getSite",
+	"updateSite",
+	"deleteSite",
+	"createResource",
+	"listResources",
+	"getResource",
+	"updateResource",
+	"deleteResource",
+	"createTarget",
+	"listTargets",
+	"getTarget",
+	"updateTarget",
+	"deleteTarget",
+}
+
+// collectAdminAccount asks whether the installer creates the first admin
+// account with the admin email, instead of the setup token web flow
+func collectAdminAccount(config *Config) {
+	if answerMissing("admin_email") {
+		return
+	}
+	config.CreateAdmin = readBool("create_admin", tr("prompt.create_admin", config.AdminEmail), true)
+	if !config.CreateAdmin {
+		return
+	}
+	if readBool("generate_admin_password", tr("prompt.generate_admin_password"), true) {
+		config.AdminPassword = generateAdminPassword()
+		config.AdminPasswordGenerated = true
+	} else {
+		config.AdminPassword = readPasswordValidated("admin_password", tr("prompt.admin_password"), validateAdminPassword)
+	}
+	config.CreateAPIToken = readBool("create_api_token", tr("prompt.create_api_token"), false)
+}
+
+// validateAdminPassword applies Pangolin's password policy
+func validateAdminPassword(password string) error {
+	if len(password) < 8 || len(password) > 128 {
+		return errors.New("the password must be 8 to 128 characters long")
+	}
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			special = true
+		}
+	}
+	if !upper || !lower || !digit || !special {
+		return errors.New("the password needs an uppercase and a lowercase letter, a digit and a special character")
+	}
+	return nil
+}
+
+// generateAdminPassword returns a random password that passes
+// validateAdminPassword
+func generateAdminPassword() string {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789!@#%^*-_=+"
+	for {
+		password := make([]byte, 24)
+		for i := range password {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				panic(fmt.Sprintf("Failed to generate admin password: %v", err))
+			}
+			password[i] = alphabet[n.Int64()]
+		}
+		if validateAdminPassword(string(password)) == nil {
+			return string(password)
+		}
+	}
+}
+
+// setupFirstAdmin creates the admin account collected during the install
+// through the local Pangolin API, or prints the setup token for the web flow.
+// It reports whether an admin account exists afterwards.
+func setupFirstAdmin(config Config) bool {
+	if !config.CreateAdmin {
+		infoln("\n=== Setup Token ===")
+		printSetupToken(config.InstallationContainerType, config.DashboardURL())
+		return false
+	}
+
+	infoln("\n=== Admin Account ===")
+	baseURL, client := localAPIClient(config.DashboardDomain)
+	var setup struct {
+		Complete bool `json:"complete"`
+	}
+	if err := apiRequest(client, http.MethodGet, baseURL+"/api/v1/auth/initial-setup-complete", nil, &setup); err != nil {
+		warnf("Warning: could not ask Pangolin whether an admin account exists: %v\n", err)
+		printSetupToken(config.InstallationContainerType, config.DashboardURL())
+		return false
+	}
+	if setup.Complete {
+		infoln("An admin account already exists, the installer does not create one.")
+		report.skip("admin account creation (an admin already exists)")
+		return true
+	}
+
+	token := findSetupToken(config.InstallationContainerType)
+	if token == "" {
+		infoln("Create the admin account in the web UI instead.")
+		return false
+	}
+	if err := apiRequest(client, http.MethodPut, baseURL+"/api/v1/auth/set-server-admin", map[string]string{
+		"email":      config.AdminEmail,
+		"password":   config.AdminPassword,
+		"setupToken": token,
+	}, nil); err != nil {
+		warnf("Warning: could not create the admin account: %v\n", err)
+		printSetupToken(config.InstallationContainerType, config.DashboardURL())
+		return false
+	}
+
+	admin := &reportAdmin{Email: config.AdminEmail, Created: true}
+	infof("Admin account %s created.\n", config.AdminEmail)
+	if config.AdminPasswordGenerated {
+		admin.Password = config.AdminPassword
+		// Always shown (even with --quiet) but kept out of the install log
+		fmt.Fprintln(consoleOut, tr("summary.admin_password", config.AdminPassword))
+		logf("INFO", "Generated admin password: [redacted]")
+		infoln(tr("summary.admin_password_save"))
+	}
+	if config.CreateAPIToken {
+		if err := createAdminToken(client, baseURL, config, admin); err != nil {
+			warnf("Warning: could not create the API token: %v\n", err)
+			infoln("You can create an API key in the dashboard under Server Admin > API Keys.")
+		}
+	}
+	report.setAdmin(admin)
+	return true
+}
+
+// createAdminToken creates the provisioning API token with the new admin
+// account and writes it to adminTokenFile
+func createAdminToken(client *http.Client, baseURL string, config Config, admin *reportAdmin) error {
+	if err := loginAdmin(client, baseURL, config.AdminEmail, config.AdminPassword); err != nil {
+		return err
+	}
+	token, err := createAPIKey(client, baseURL, adminTokenName, adminTokenActions)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(secretsDir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(adminTokenFile, []byte(token+"\n"), 0600); err != nil {
+		return err
+	}
+	report.fileWritten(adminTokenFile)
+	admin.APIToken = token
+	admin.APITokenFile = adminTokenFile
+	infof("API token written to %s\n", adminTokenFile)
+	if !integrationAPIEnabled("config/config.yml") {
+		warnf("Warning: the integration API is not enabled. Set flags.enable_integration_api: true in config/config.yml\n")
+		warnf("and expose port %d of the pangolin container before using the token.\n", installedPangolinPorts("config/config.yml").Integration)
+	}
+	return nil
+}
+
+// localAPIClient returns the base URL of the Pangolin API on this host and a
+// client with a cookie jar for it. Like stackHealthChecks it goes through
+// Traefik, or to the published port behind an existing reverse proxy.
+func localAPIClient(dashboardDomain string) (string, *http.Client) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create cookie jar: %v", err))
+	}
+	if address, ok := installedProxyAPIAddress("docker-compose.yml"); ok {
+		return "http://" + address, &http.Client{Jar: plainHTTPJar{jar}, Timeout: 15 * time.Second}
+	}
+	_, httpsPort := installedEntrypointPorts("config/traefik/traefik_config.yml")
+	client := localTLSClient(dashboardDomain, httpsPort)
+	client.Jar = jar
+	client.Timeout = 15 * time.Second
+	return "https://" + dashboardDomain, client
+}
+
+// plainHTTPJar keeps the Secure session cookie Pangolin sets for its HTTPS
+// dashboard on the plain HTTP connection to localhost
+type plainHTTPJar struct {
+	http.CookieJar
+}
+
+func (j plainHTTPJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.CookieJar.SetCookies(asHTTPS(u), cookies)
+}
+
+func (j plainHTTPJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.CookieJar.Cookies(asHTTPS(u))
+}
+
+func asHTTPS(u *url.URL) *url.URL {
+	secure := *u
+	secure.Scheme = "https"
+	return &secure
+}
+
+// printFirstLogin tells where to sign in, or where to create the admin
+// account when the installer did not
+func printFirstLogin(config Config, adminReady bool) {
+	if adminReady {
+		infof("\n%s\n%s/auth/login\n", tr("summary.admin_login"), config.DashboardURL())
+		return
+	}
+	infof("\n%s\n%s/auth/initial-setup\n", tr("summary.initial_setup"), config.DashboardURL())
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// promptFlag answers the prompt with key from the command line. The flag is
+// named after the key with dashes, e.g. --admin-email for admin_email.
+type promptFlag struct {
+	key     string
+	section string
+	kind    promptKind
+	usage   string
+}
+
+// promptKind tells yes/no prompts, whose flag works without a value like
+// --enable-ipv6, from the rest
+type promptKind bool
+
+const (
+	promptText promptKind = false
+	promptBool promptKind = true
+)
+
+// Sections group the prompt flags in --help, in the order of the install
+const (
+	sectionInstall    = "Installation"
+	sectionDatabase   = "Database"
+	sectionDomains    = "Domains"
+	sectionAdmin      = "Admin"
+	sectionNetwork    = "Network and certificates"
+	sectionEmail      = "Email"
+	sectionSecurity   = "Security add-ons"
+	sectionContainers = "Containers"
+	sectionExisting   = "Existing installs"
+)
+
+var promptSections = []string{
+	sectionInstall, sectionDatabase, sectionDomains, sectionAdmin, sectionNetwork,
+	sectionEmail, sectionSecurity, sectionContainers, sectionExisting,
+}
+
+// promptFlags are the prompts of an install that can be answered with a flag.
+// Prompts that repeat in a loop (add_domain, tls_passthrough_more) or decide
+// how to proceed after a failed check are left out, a fixed answer would ask
+// them forever.
+var promptFlags = []promptFlag{
+	{"install_dir", sectionInstall, promptText, "Installation directory for a fresh install"},
+	{"use_existing_install", sectionInstall, promptBool, "Use the existing install in " + defaultInstallDir + ""},
+	{"create_install_dir", sectionInstall, promptBool, "Create the installation directory when it does not exist"},
+	{"change_ownership", sectionInstall, promptBool, "Give the installation directory to the user who ran sudo"},
+	{"resume_install", sectionInstall, promptText, "Continue an interrupted install: resume or start-over"},
+	{"enterprise", sectionInstall, promptBool, "Install the Enterprise Edition"},
+	{"edit_files", sectionInstall, promptBool, "Edit a generated file before it is written"},
+	{"redis_password", sectionDatabase, promptText, "Password of the Redis service (used with --redis)"},
+	{"postgresql", sectionDatabase, promptBool, "Use PostgreSQL instead of SQLite"},
+	{"postgresql_external", sectionDatabase, promptBool, "Use an external PostgreSQL server"},
+	{"postgresql_password", sectionDatabase, promptText, "Password of the PostgreSQL user"},
+	{"postgresql_host", sectionDatabase, promptText, "Host of the external PostgreSQL server"},
+	{"postgresql_port", sectionDatabase, promptText, "Port of the external PostgreSQL server"},
+	{"postgresql_database", sectionDatabase, promptText, "Database on the external PostgreSQL server"},
+	{"postgresql_user", sectionDatabase, promptText, "User on the external PostgreSQL server"},
+	{"base_domain", sectionDomains, promptText, "Base domain without a subdomain, e.g. example.com"},
+	{"dashboard_domain", sectionDomains, promptText, "Domain of the Pangolin dashboard (default: pangolin.<base domain>)"},
+	{"reenter_domain", sectionDomains, promptBool, "Enter the domains again when their DNS records do not point at this server"},
+	{"install_type", sectionDomains, promptText, "How HTTPS is provided: traefik or existing-proxy"},
+	{"proxy_dashboard_port", sectionDomains, promptText, "Host port of the dashboard behind an existing proxy"},
+	{"proxy_api_port", sectionDomains, promptText, "Host port of the API and WebSocket behind an existing proxy"},
+	{"proxy_bind_address", sectionDomains, promptText, "IPv4 address the ports behind an existing proxy are published on (default: 127.0.0.1)"},
+	{"wildcard_cert", sectionDomains, promptBool, "Request a wildcard certificate"},
+	{"wildcard_domain", sectionDomains, promptText, "Wildcard domain, e.g. *.example.com"},
+	{"admin_email", sectionAdmin, promptText, "Admin email address"},
+	{"certificate_source", sectionAdmin, promptText, "TLS certificates: letsencrypt, or own to serve existing PEM files"},
+	{"certificate_path", sectionAdmin, promptText, "Absolute path of the own fullchain PEM file, or of a directory of certificates and keys"},
+	{"certificate_key", sectionAdmin, promptText, "Absolute path of the key of the own certificate file"},
+	{"letsencrypt_email", sectionAdmin, promptText, "ACME contact email for Let's Encrypt (default: the admin email)"},
+	{"create_admin", sectionAdmin, promptBool, "Create the admin account with the admin email instead of the setup token web flow"},
+	{"generate_admin_password", sectionAdmin, promptBool, "Generate the password of the admin account"},
+	{"admin_password", sectionAdmin, promptText, "Password of the admin account (with --generate-admin-password=false)"},
+	{"create_api_token", sectionAdmin, promptBool, "Create an API token for provisioning tools with the new admin account"},
+	{"oidc", sectionAdmin, promptBool, "Configure an OIDC identity provider"},
+	{"oidc_name", sectionAdmin, promptText, "Display name of the OIDC provider"},
+	{"oidc_issuer", sectionAdmin, promptText, "Issuer URL of the OIDC provider"},
+	{"oidc_client_id", sectionAdmin, promptText, "OIDC client ID"},
+	{"oidc_client_secret", sectionAdmin, promptText, "OIDC client secret"},
+	{"oidc_scopes", sectionAdmin, promptText, "OIDC scopes"},
+	{"telemetry", sectionInstall, promptBool, "Share anonymous install statistics, if this build collects them, and turn on Pangolin's anonymous usage reporting"},
+	{"install_confirm", sectionInstall, promptText, "At the summary before anything is written: confirm, edit or cancel"},
+	{"install_gerbil", sectionNetwork, promptBool, "Install Gerbil for tunneled connections"},
+	{"wireguard_port", sectionNetwork, promptText, "WireGuard UDP port for Newt sites"},
+	{"public_endpoint", sectionNetwork, promptText, "Host and optional port Newt sites connect to, e.g. the router's address behind NAT"},
+	{"http_mode", sectionNetwork, promptText, "Plain HTTP on port 80: redirect, serve or disable"},
+	{"custom_ports", sectionNetwork, promptBool, "Use other external ports than 80 and 443"},
+	{"http_port", sectionNetwork, promptText, "External HTTP port"},
+	{"https_port", sectionNetwork, promptText, "External HTTPS port"},
+	{"port_conflict", sectionNetwork, promptBool, "Move a port that is already in use on this host to a free one"},
+	{"acme_challenge", sectionNetwork, promptText, "ACME challenge: http-01, tls-alpn-01 or dns-01"},
+	{"dns_provider", sectionNetwork, promptText, "DNS provider for the dns-01 challenge"},
+	{"dns_provider_check", sectionNetwork, promptBool, "Check the DNS provider credentials with a read-only API call"},
+	{"enable_ipv6", sectionNetwork, promptBool, "Enable IPv6 for the container network and Traefik"},
+	{"separate_endpoint", sectionNetwork, promptBool, "Use a separate DNS-only hostname for the tunnel endpoint"},
+	{"gerbil_endpoint", sectionNetwork, promptText, "DNS-only hostname of the tunnel endpoint"},
+	{"timezone", sectionNetwork, promptText, "Time zone of the container logs, e.g. Europe/Berlin"},
+	{"custom_server_ports", sectionNetwork, promptBool, "Change the ports Pangolin listens on inside its container"},
+	{"server_external_port", sectionNetwork, promptText, "Pangolin API and WebSocket port"},
+	{"server_internal_port", sectionNetwork, promptText, "Pangolin internal port"},
+	{"server_next_port", sectionNetwork, promptText, "Pangolin dashboard port"},
+	{"server_integration_port", sectionNetwork, promptText, "Pangolin integration API port"},
+	{"tls_passthrough", sectionNetwork, promptBool, "Pass raw TLS for one hostname through to a backend"},
+	{"tls_passthrough_sni", sectionNetwork, promptText, "Hostname (SNI) to pass through"},
+	{"tls_passthrough_backend", sectionNetwork, promptText, "Backend address (host:port) of the passthrough"},
+	{"external_check", sectionNetwork, promptBool, "Test the dashboard from the internet after the install (see --skip-external-check)"},
+	{"enable_email", sectionEmail, promptBool, "Enable email functionality over SMTP"},
+	{"email_provider", sectionEmail, promptText, "Email provider: gmail, office365, sendgrid, mailgun, postmark, brevo, ses-us-east-1, ses-eu-west-1, fastmail, zoho or other"},
+	{"smtp_host", sectionEmail, promptText, "SMTP host"},
+	{"smtp_port", sectionEmail, promptText, "SMTP port"},
+	{"smtp_security", sectionEmail, promptText, "SMTP security: starttls, tls or none"},
+	{"smtp_user", sectionEmail, promptText, "SMTP username"},
+	{"smtp_pass", sectionEmail, promptText, "SMTP password"},
+	{"no_reply_email", sectionEmail, promptText, "No-reply email address"},
+	{"smtp_test", sectionEmail, promptBool, "Send a test email"},
+	{"smtp_test_recipient", sectionEmail, promptText, "Recipient of the test email"},
+	{"enable_maxmind", sectionSecurity, promptBool, "Download the MaxMind GeoLite2 databases"},
+	{"geoblock", sectionSecurity, promptBool, "Only allow requests from some countries"},
+	{"geoblock_countries", sectionSecurity, promptText, "Comma-separated ISO 3166-1 codes of the allowed countries, e.g. DE,AT,CH"},
+	{"geoblock_scope", sectionSecurity, promptText, "What geo-blocking guards: dashboard or everything (every HTTPS request)"},
+	{"hardened_defaults", sectionSecurity, promptBool, "Rate limit the dashboard and send security headers (HSTS, X-Frame-Options, referrer policy)"},
+	{"rate_limit_average", sectionSecurity, promptText, "Average requests per second and client address allowed to the dashboard"},
+	{"rate_limit_burst", sectionSecurity, promptText, "Requests above the average a client may burst to"},
+	{"docker_secrets", sectionSecurity, promptBool, "Pass the passwords and DNS credentials as Docker secret files instead of environment variables"},
+	{"configure_firewall", sectionSecurity, promptBool, "Open the ports in the active firewall"},
+	{"selinux_label", sectionSecurity, promptBool, "Add :z/:Z to the volume mounts when SELinux is enforcing"},
+	{"selinux_chcon", sectionSecurity, promptBool, "Relabel the install directory with semanage/chcon instead"},
+	{"install_crowdsec", sectionSecurity, promptBool, "Install CrowdSec (used with --crowdsec)"},
+	{"manage_crowdsec", sectionSecurity, promptBool, "Manage CrowdSec yourself"},
+	{"crowdsec_enroll", sectionSecurity, promptBool, "Enroll this instance in the CrowdSec console"},
+	{"crowdsec_enroll_key", sectionSecurity, promptText, "Enrollment key from the CrowdSec console"},
+	{"crowdsec_hub_items", sectionSecurity, promptText, "Comma-separated CrowdSec collections and scenarios to install"},
+	{"install_containers", sectionContainers, promptBool, "Install and start the containers"},
+	{"container_type", sectionContainers, promptText, "Container runtime: docker or podman"},
+	{"configure_unprivileged_ports", sectionContainers, promptBool, "Let Podman containers listen on ports from 80"},
+	{"install_docker", sectionContainers, promptBool, "Install Docker when it is missing"},
+	{"log_rotation", sectionContainers, promptBool, "Limit the size of the container logs"},
+	{"log_max_size", sectionContainers, promptText, "Size of a container log file before it is rotated, e.g. 10m"},
+	{"log_max_file", sectionContainers, promptText, "Rotated log files kept per container"},
+	{"docker_log_defaults", sectionContainers, promptBool, "Also set the log limits as Docker's default in /etc/docker/daemon.json"},
+	{"docker_restart", sectionContainers, promptBool, "Restart Docker to apply the log defaults"},
+	{"auto_updates", sectionContainers, promptText, "Automatic updates: none, watchtower or schedule (systemd timer or cron)"},
+	{"update_schedule", sectionContainers, promptText, "Schedule of the automatic updates, an OnCalendar expression on systemd hosts and a cron expression otherwise"},
+	{"backups", sectionContainers, promptBool, "Set up scheduled backups of the database and configuration"},
+	{"backup_dir", sectionContainers, promptText, "Absolute directory the backup archives are written to"},
+	{"backup_retention", sectionContainers, promptText, "Number of backup archives to keep (1-365)"},
+	{"backup_schedule", sectionContainers, promptText, "Schedule of the backups, an OnCalendar expression on systemd hosts and a cron expression otherwise"},
+	{"install_systemd_unit", sectionContainers, promptBool, "Manage Pangolin with a systemd unit"},
+	{"confirm_detected_values", sectionExisting, promptBool, "Accept the values detected from an existing install"},
+	{"migrate_legacy_layout", sectionExisting, promptBool, "Migrate an install with an older layout"},
+	{"update_maxmind", sectionExisting, promptBool, "Update the MaxMind databases of an existing install"},
+	{"download_maxmind", sectionExisting, promptBool, "Download the MaxMind databases for an existing install"},
+	{"apply_labels", sectionExisting, promptBool, "Recreate the containers to apply the labels"},
+	{"reconfigure", sectionExisting, promptBool, "Ask the install questions again with the current values as defaults"},
+	{"reconfigure_restart", sectionExisting, promptBool, "Recreate the containers after reconfiguring"},
+	{"acme_fix_permissions", sectionExisting, promptBool, "Set the permissions of acme.json to 600"},
+	{"acme_move_corrupt", sectionExisting, promptBool, "Move a corrupt acme.json aside"},
+	{"acme_restart_traefik", sectionExisting, promptBool, "Restart Traefik after repairing acme.json"},
+	{"reconcile_server_ports", sectionExisting, promptBool, "Update the ports to match config.yml"},
+	{"regenerate_file", sectionExisting, promptText, "What to do with generated files changed by hand: overwrite, keep or new (writes <name>.new)"},
+	{"geoblock_reconfigure", sectionExisting, promptText, "What to do with the geo-blocking of an existing install: keep, change or remove"},
+	{"self_update", sectionExisting, promptBool, "Download a newer installer and restart with it"},
+}
+
+// promptFlagAliases are short names for the flags needed on every install
+var promptFlagAliases = map[string]string{
+	"domain": "base_domain",
+	"email":  "admin_email",
+}
+
+// decisionPrompts ask how to proceed after a failed check. Their defaults
+// would repeat the check with the same answers, so --yes does not take them.
+var decisionPrompts = []string{
+	"dns_provider_check_failed",
+	"smtp_test_failed",
+	"oidc_invalid",
+	"postgresql_connect_failed",
+	"edit_again",
+}
+
+var (
+	// promptAnswers are the values of the prompt flags, by prompt key
+	promptAnswers = map[string]string{}
+	// acceptDefaults is set by --yes: prompts without a flag take their
+	// default instead of asking
+	acceptDefaults bool
+	// collectingAnswers is set while collectUserInput runs, prompts --yes
+	// cannot answer are gathered in missingAnswers and reported together
+	collectingAnswers bool
+	missingAnswers    []string
+	// answersWithoutTerminal is set when acceptDefaults comes from a stdin
+	// that is not a terminal instead of --yes
+	answersWithoutTerminal bool
+)
+
+// answerWithoutTerminal answers like --yes when stdin is not a terminal, e.g.
+// under cloud-init. Nobody can type an answer there, and waiting for one would
+// hang the provisioning. --accessible still reads the answers from stdin.
+func answerWithoutTerminal() {
+	if acceptDefaults || nonInteractive || terminal.accessibleFlag || facts.stdinTerminal() {
+		return
+	}
+	acceptDefaults, answersWithoutTerminal = true, true
+	logf("INFO", "stdin is not a terminal, the prompts take their flag or default")
+}
+
+// unattendedBy names what answers the prompts without asking, for errors
+func unattendedBy() string {
+	if answersFile != "" {
+		return "--answers " + answersFile
+	}
+	if answersWithoutTerminal {
+		return "a run without a terminal on stdin (use --accessible to read answers from stdin)"
+	}
+	return "--yes"
+}
+
+func promptFlagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// promptValue is the flag.Value of a prompt flag, it stores the value in
+// promptAnswers. Yes/no prompts are boolean flags, so --enable-ipv6 and
+// --enable-ipv6=false both work.
+type promptValue struct {
+	key     string
+	section string
+	kind    promptKind
+}
+
+func (v promptValue) String() string {
+	return promptAnswers[v.key]
+}
+
+func (v promptValue) Set(value string) error {
+	promptAnswers[v.key] = value
+	return nil
+}
+
+func (v promptValue) IsBoolFlag() bool {
+	return v.kind == promptBool
+}
+
+// addPromptFlags registers --yes and a flag for every prompt of an install on
+// fs, including the credentials of every DNS provider
+func addPromptFlags(fs *flag.FlagSet) {
+	usage := "Answer every prompt without asking: use the prompt flags, else the defaults, and fail listing the prompts that have neither"
+	fs.BoolVar(&acceptDefaults, "yes", false, usage)
+	fs.BoolVar(&acceptDefaults, "defaults", false, "Same as --yes")
+	fs.StringVar(&answersFile, "answers", "", "YAML or JSON file answering the prompts by key, e.g. base_domain: example.com. Implies --yes, flags win over the file")
+
+	flags := slices.Clone(promptFlags)
+	for _, provider := range dnsProviders {
+		for _, credential := range provider.Credentials {
+			flags = append(flags, promptFlag{strings.ToLower(credential.Env), sectionNetwork, promptText, provider.Name + ": " + credential.Prompt})
+		}
+	}
+	for _, pf := range flags {
+		if fs.Lookup(promptFlagName(pf.key)) != nil {
+			continue
+		}
+		fs.Var(promptValue{pf.key, pf.section, pf.kind}, promptFlagName(pf.key), pf.usage)
+	}
+	for alias, key := range promptFlagAliases {
+		target := fs.Lookup(promptFlagName(key)).Value.(promptValue)
+		fs.Var(target, alias, "Same as --"+promptFlagName(key))
+	}
+}
+
+// printFlags prints the flags of fs like PrintDefaults, followed by the prompt
+// flags grouped by section
+func printFlags(fs *flag.FlagSet) {
+	general := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	sections := map[string]*flag.FlagSet{}
+	fs.VisitAll(func(f *flag.Flag) {
+		target := general
+		if v, ok := f.Value.(promptValue); ok {
+			if sections[v.section] == nil {
+				sections[v.section] = flag.NewFlagSet(v.section, flag.ContinueOnError)
+			}
+			target = sections[v.section]
+		}
+		target.Var(f.Value, f.Name, f.Usage)
+		target.Lookup(f.Name).DefValue = f.DefValue
+	})
+
+	out := fs.Output()
+	general.SetOutput(out)
+	general.PrintDefaults()
+	if len(sections) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "\nPrompt flags answer a prompt without asking, the others are still asked (see --yes):")
+	for _, section := range promptSections {
+		if set := sections[section]; set != nil {
+			fmt.Fprintf(out, "\n%s:\n", section)
+			set.SetOutput(out)
+			set.PrintDefaults()
+		}
+	}
+}
+
+// presetAnswer returns the answer to a prompt that is not asked: the value of
+// its flag, environment variable or answers file, or its default with --yes. ok is false when the prompt has to be
+// asked. With --yes and no default, the value is empty and the prompt is
+// reported by checkMissingAnswers.
+func presetAnswer(key, defaultValue string, hasDefault bool) (value string, source answerSource, ok bool) {
+	if value, source, found := presetValue(key); found {
+		return value, source, true
+	}
+	// Going back in the wizard gives the earlier answers again
+	if value, ok := session.replayed(key, defaultValue, hasDefault); ok {
+		return value, sourcePrompt, true
+	}
+	if !acceptDefaults {
+		return "", "", false
+	}
+	if slices.Contains(decisionPrompts, key) {
+		exitf(exitInvalidInput, "Error: %s cannot decide how to proceed at prompt %q after a failed check. Correct the flags or answer it interactively.\n", unattendedBy(), key)
+	}
+	if hasDefault {
+		return defaultValue, sourceDefault, true
+	}
+	if !collectingAnswers {
+		exitf(exitInvalidInput, "Error: %s cannot answer prompt %q, it has no default. %s.\n", unattendedBy(), key, missingAnswerHint([]string{key}))
+	}
+	if !slices.Contains(missingAnswers, key) {
+		missingAnswers = append(missingAnswers, key)
+	}
+	return "", sourceDefault, true
+}
+
+// answerMissing reports whether --yes had no value for the prompt with key
+func answerMissing(key string) bool {
+	return slices.Contains(missingAnswers, key)
+}
+
+// invalidPromptFlag fails the run for a preset value the prompt would reject,
+// naming the flag, variable or file it came from
+func invalidPromptFlag(key, value string, err error) {
+	switch _, source, _ := presetValue(key); source {
+	case sourceEnv:
+		exitf(exitInvalidInput, "Error: invalid value %q for %s: %v\n", value, answerEnvName(key), err)
+	case sourceFile:
+		exitf(exitInvalidInput, "Error: invalid value %q for %s in %s: %v\n", value, key, answersFile, err)
+	case "":
+		if acceptDefaults {
+			exitf(exitInvalidInput, "Error: %s cannot answer prompt %q, its default %q is invalid: %v. %s.\n", unattendedBy(), key, value, err, missingAnswerHint([]string{key}))
+		}
+	}
+	exitf(exitInvalidInput, "Error: invalid value %q for --%s: %v\n", value, promptFlagName(key), err)
+}
+
+// checkMissingAnswers fails listing the prompts --yes could not answer
+func checkMissingAnswers() {
+	if len(missingAnswers) == 0 {
+		return
+	}
+	if answersFile != "" {
+		errorf("Error: the answers file %s has no answer for these prompts, which have no default:\n", answersFile)
+		for _, key := range missingAnswers {
+			errorf("  %s\n", key)
+		}
+	} else {
+		errorf("Error: %s needs a flag or variable for these prompts, which have no default:\n", unattendedBy())
+		for _, key := range missingAnswers {
+			errorf("  %s: --%s or %s\n", key, promptFlagName(key), answerEnvName(key))
+		}
+	}
+	exitf(exitInvalidInput, "%s and run again.\n", missingAnswerHint(missingAnswers))
+}
+
+// missingAnswerHint tells how to answer the prompts with keys
+func missingAnswerHint(keys []string) string {
+	if answersFile != "" {
+		return fmt.Sprintf("Add %s to %s", strings.Join(keys, ", "), answersFile)
+	}
+	flags := make([]string, len(keys))
+	for i, key := range keys {
+		flags[i] = "--" + promptFlagName(key)
+	}
+	return "Pass " + strings.Join(flags, ", ")
+}
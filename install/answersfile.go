@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// answersFile is set by --answers. Its answers fill the prompts no flag
+// answers, and the prompts it leaves out take their default like with --yes.
+var answersFile string
+
+// fileAnswers are the prompt keys whose value in promptAnswers came from
+// answersFile
+var fileAnswers = map[string]bool{}
+
+// answerKeys lists every key an answers file may contain: the prompt flags
+// and the credentials of the DNS providers
+func answerKeys() []string {
+	var keys []string
+	for _, pf := range promptFlags {
+		keys = append(keys, pf.key)
+	}
+	for _, provider := range dnsProviders {
+		for _, credential := range provider.Credentials {
+			keys = append(keys, strings.ToLower(credential.Env))
+		}
+	}
+	return keys
+}
+
+// loadAnswersFile reads answersFile into promptAnswers and turns on --yes.
+// Flags on the command line win over the file. Keys are the prompt keys, e.g.
+// base_domain, or the flag names, e.g. base-domain; lists are joined with
+// commas and a null value leaves the prompt to its default. JSON works too, it
+// is valid YAML.
+func loadAnswersFile() {
+	if answersFile == "" {
+		return
+	}
+	content, err := os.ReadFile(answersFile)
+	if err != nil {
+		exitf(exitInvalidInput, "Error: failed to read the answers file: %v\n", err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		exitf(exitInvalidInput, "Error: the answers file %s is not valid YAML or JSON: %v\n", answersFile, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if len(root.Content) == 0 {
+		exitf(exitInvalidInput, "Error: the answers file %s is empty\n", answersFile)
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		exitf(exitInvalidInput, "Error: the answers file %s must map prompt keys to answers, e.g. base_domain: example.com\n", answersFile)
+	}
+
+	known := answerKeys()
+	var problems []string
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		keyNode, valueNode := doc.Content[i], doc.Content[i+1]
+		key := strings.ReplaceAll(keyNode.Value, "-", "_")
+		if alias, ok := promptFlagAliases[key]; ok {
+			key = alias
+		}
+		if !slices.Contains(known, key) {
+			problems = append(problems, fmt.Sprintf("line %d: unknown key %q", keyNode.Line, keyNode.Value))
+			continue
+		}
+		if valueNode.Tag == "!!null" {
+			// no answer, the prompt takes its default
+			continue
+		}
+		value, err := answerValue(valueNode)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %s: %v", valueNode.Line, keyNode.Value, err))
+			continue
+		}
+		if _, flagged := promptAnswers[key]; flagged {
+			logf("INFO", "answers file: %s is also passed as --%s, the flag wins", key, promptFlagName(key))
+			continue
+		}
+		promptAnswers[key] = value
+		fileAnswers[key] = true
+	}
+	if len(problems) > 0 {
+		errorf("Error: the answers file %s has errors:\n", answersFile)
+		for _, problem := range problems {
+			errorf("  %s\n", problem)
+		}
+		exitf(exitInvalidInput, "The keys are the prompt keys listed in --help with underscores, e.g. base_domain for --base-domain.\n")
+	}
+	acceptDefaults = true
+	logf("INFO", "answers file %s: %d answers", answersFile, len(fileAnswers))
+}
+
+// answerValue is the answer a YAML value stands for: a scalar as written, a
+// list of scalars joined with commas
+func answerValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.SequenceNode:
+		values := make([]string, len(node.Content))
+		for i, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("expected a list of values")
+			}
+			values[i] = item.Value
+		}
+		return strings.Join(values, ","), nil
+	}
+	return "", errors.New("expected a value or a list of values")
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Automatic update mechanisms
+const (
+	updateNone       = "none"
+	updateWatchtower = "watchtower"
+	updateSchedule   = "schedule"
+)
+
+const (
+	systemdUnitDir = "/etc/systemd/system"
+	cronDir        = "/etc/cron.d"
+	// Sunday night, when a restart of the stack disturbs the fewest users
+	defaultUpdateCalendar = "Sun *-*-* 04:00:00"
+	defaultUpdateCron     = "0 4 * * 0"
+)
+
+// collectAutoUpdates asks whether the stack keeps itself updated, with a
+// Watchtower container or a scheduled compose pull, and for the schedule
+func collectAutoUpdates(config *Config) {
+	config.AutoUpdate = readChoice("auto_updates", tr("prompt.auto_updates"), []string{updateNone, updateWatchtower, updateSchedule}, updateNone)
+	if config.AutoUpdate == updateNone {
+		return
+	}
+	// A scheduled pull runs from a systemd timer where there is systemd, and
+	// from cron otherwise. Watchtower reads cron expressions.
+	config.UpdateTimer = config.AutoUpdate == updateSchedule && platform.SystemdUnit && isSystemdHost()
+	if config.UpdateTimer {
+		config.UpdateSchedule = readValidated("update_schedule", tr("prompt.update_schedule", "systemd OnCalendar"), defaultUpdateCalendar, validateOnCalendar)
+	} else {
+		config.UpdateSchedule = readValidated("update_schedule", tr("prompt.update_schedule", "cron"), defaultUpdateCron, validateCronExpression)
+	}
+	infoln("The updates pull newer images of the tags in docker-compose.yml. The Pangolin and Gerbil images are pinned to a version, move them to a new release with the upgrade command.")
+}
+
+// Watchtower reports whether the compose file gets a Watchtower service
+func (c Config) Watchtower() bool {
+	return c.AutoUpdate == updateWatchtower
+}
+
+// WatchtowerSchedule is the update schedule in Watchtower's cron format,
+// which has a leading seconds field
+func (c Config) WatchtowerSchedule() string {
+	if strings.HasPrefix(c.UpdateSchedule, "@") {
+		return c.UpdateSchedule
+	}
+	return "0 " + c.UpdateSchedule
+}
+
+// WatchedContainers are the containers of the stack Watchtower updates,
+// other containers on the host are left alone
+func (c Config) WatchedContainers() []string {
+	containers := []string{"pangolin"}
+	if c.InstallGerbil {
+		containers = append(containers, "gerbil")
+	}
+	if !c.ExternalProxy {
+		containers = append(containers, "traefik")
+	}
+	if c.BundledPostgreSQL() {
+		containers = append(containers, "postgres")
+	}
+	if c.IsRedis {
+		containers = append(containers, "redis")
+	}
+	return containers
+}
+
+var cronMacros = []string{"@hourly", "@daily", "@weekly", "@monthly"}
+
+// cronFields are the bounds and names of the five cron fields
+var cronFields = []struct {
+	name     string
+	min, max int
+	names    []string
+}{
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{"day of week", 0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var cronRangePattern = regexp.MustCompile(`^(\*|[0-9a-z]+(-[0-9a-z]+)?)(/[0-9]+)?$`)
+
+// validateCronExpression accepts five field cron expressions, like
+// "0 4 * * 0", and the @hourly to @monthly macros
+func validateCronExpression(expr string) error {
+	if strings.HasPrefix(expr, "@") {
+		if slices.Contains(cronMacros, expr) {
+			return nil
+		}
+		return fmt.Errorf("%s is not one of %s", expr, strings.Join(cronMacros, ", "))
+	}
+	fields := strings.Fields(strings.ToLower(expr))
+	if len(fields) != len(cronFields) {
+		return errors.New("a cron expression has five fields: minute hour day-of-month month day-of-week, e.g. 0 4 * * 0")
+	}
+	for i, field := range fields {
+		spec := cronFields[i]
+		for _, part := range strings.Split(field, ",") {
+			match := cronRangePattern.FindStringSubmatch(part)
+			if match == nil {
+				return fmt.Errorf("%q is not a valid %s", part, spec.name)
+			}
+			if match[1] == "*" {
+				continue
+			}
+			bounds, _, _ := strings.Cut(part, "/")
+			for _, value := range strings.Split(bounds, "-") {
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					n = slices.Index(spec.names, value)
+					if n < 0 {
+						return fmt.Errorf("%q is not a valid %s", value, spec.name)
+					}
+					n += spec.min
+				}
+				if n < spec.min || n > spec.max {
+					return fmt.Errorf("%s %d is out of range, expected %d to %d", spec.name, n, spec.min, spec.max)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateOnCalendar checks a systemd calendar expression with
+// systemd-analyze, which prints why an expression is invalid
+func validateOnCalendar(expr string) error {
+	out, err := exec.Command("systemd-analyze", "calendar", expr).CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		message := strings.TrimSpace(string(out))
+		if i := strings.LastIndex(message, "\n"); i >= 0 {
+			message = message[i+1:]
+		}
+		return fmt.Errorf("%s is not a valid OnCalendar expression (e.g. daily or Sun *-*-* 04:00:00): %s", expr, message)
+	}
+	return nil
+}
+
+// installUpdateSchedule installs the systemd timer or cron entry that pulls
+// and restarts the stack on the chosen schedule
+func installUpdateSchedule(config Config, installDir string) {
+	if config.AutoUpdate != updateSchedule {
+		return
+	}
+	infoln("\n=== Automatic Updates ===")
+	if !isRoot() {
+		infoln("Skipping the automatic updates: not running as root.")
+		report.skip("automatic updates (requires root)")
+		return
+	}
+	installDir, err := filepath.Abs(installDir)
+	if err != nil {
+		warnf("Warning: could not set up the automatic updates: %v\n", err)
+		return
+	}
+	composeFile := filepath.Join(installDir, "docker-compose.yml")
+	pull, err := composeCommand(context.Background(), config.InstallationContainerType, "-f", composeFile, "pull")
+	if err == nil && pull.Err != nil {
+		err = fmt.Errorf("compose binary not found: %v", pull.Err)
+	}
+	if err != nil {
+		warnf("Warning: could not set up the automatic updates: %v\n", err)
+		return
+	}
+	up, _ := composeCommand(context.Background(), config.InstallationContainerType, "-f", composeFile, "up", "-d")
+	pullLine := pull.Path + " " + strings.Join(pull.Args[1:], " ")
+	upLine := up.Path + " " + strings.Join(up.Args[1:], " ")
+
+	job := scheduledJob{
+		Name:        "pangolin-update",
+		Description: "Pull and restart the Pangolin compose stack in " + installDir,
+		WorkDir:     installDir,
+		Schedule:    config.UpdateSchedule,
+		Timer:       config.UpdateTimer,
+		Commands:    []string{pullLine, upLine},
+	}
+	if err := installScheduledJob(job); err != nil {
+		warnf("Warning: could not set up the automatic updates: %v\n", err)
+		return
+	}
+	if job.Timer {
+		infof("Installed and enabled %s (%s). See the next run with: systemctl list-timers %s\n", job.timerName(), job.Schedule, job.timerName())
+	} else {
+		infof("Installed %s, the stack updates at %s.\n", job.cronPath(), job.Schedule)
+	}
+}
+
+// scheduledJob is a command of the installer that runs on a schedule, from a
+// systemd timer or a cron entry
+type scheduledJob struct {
+	// Name is the base name of the units and the cron file
+	Name        string
+	Description string
+	WorkDir     string
+	Schedule    string
+	Timer       bool
+	Commands    []string
+}
+
+func (j scheduledJob) serviceName() string { return j.Name + ".service" }
+func (j scheduledJob) timerName() string   { return j.Name + ".timer" }
+func (j scheduledJob) cronPath() string    { return filepath.Join(cronDir, j.Name) }
+
+// installScheduledJob writes the cron entry, or the service and timer and
+// enables the timer, and records them for the uninstaller
+func installScheduledJob(job scheduledJob) error {
+	if !job.Timer {
+		entry := fmt.Sprintf(`# Generated by the Pangolin installer
+SHELL=/bin/sh
+PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin
+%s root cd %s && %s
+`, job.Schedule, job.WorkDir, strings.Join(job.Commands, " && "))
+		if err := os.WriteFile(job.cronPath(), []byte(entry), 0644); err != nil {
+			return fmt.Errorf("could not write %s: %v", job.cronPath(), err)
+		}
+		recordExternal(externalResource{Kind: resourceFile, Path: job.cronPath(), Description: "cron entry: " + job.Description})
+		return nil
+	}
+
+	var execLines strings.Builder
+	for _, command := range job.Commands {
+		fmt.Fprintf(&execLines, "ExecStart=%s\n", command)
+	}
+	service := fmt.Sprintf(`# Generated by the Pangolin installer
+[Unit]
+Description=%s
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+WorkingDirectory=%s
+%s`, job.Description, job.WorkDir, execLines.String())
+	timer := fmt.Sprintf(`# Generated by the Pangolin installer
+[Unit]
+Description=%s
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, job.Description, job.Schedule)
+
+	servicePath := filepath.Join(systemdUnitDir, job.serviceName())
+	timerPath := filepath.Join(systemdUnitDir, job.timerName())
+	if err := os.WriteFile(servicePath, []byte(service), 0644); err != nil {
+		return fmt.Errorf("could not write %s: %v", servicePath, err)
+	}
+	if err := os.WriteFile(timerPath, []byte(timer), 0644); err != nil {
+		return fmt.Errorf("could not write %s: %v", timerPath, err)
+	}
+	// The timer is stopped before either unit file is removed
+	recordExternal(externalResource{
+		Kind:        resourceFile,
+		Path:        timerPath,
+		Description: "systemd timer: " + job.Description,
+		PreRemove:   "systemctl disable --now " + job.timerName(),
+	})
+	recordExternal(externalResource{
+		Kind:        resourceFile,
+		Path:        servicePath,
+		Description: "systemd service: " + job.Description,
+		Undo:        "systemctl daemon-reload",
+	})
+
+	if err := run("systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("systemctl daemon-reload failed: %v", err)
+	}
+	if err := run("systemctl", "enable", "--now", job.timerName()); err != nil {
+		return fmt.Errorf("could not enable %s: %v", job.timerName(), err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	// backupScript is generated from the template of the same path, so
+	// --templates-dir can replace it
+	backupScript            = "config/backup.sh"
+	defaultBackupDir        = "/var/backups/pangolin"
+	defaultBackupRetention  = 7
+	defaultBackupCalendar   = "*-*-* 03:00:00"
+	defaultBackupCron       = "0 3 * * *"
+	maxBackupRetentionCount = 365
+)
+
+// collectBackups asks whether the installation is backed up on a schedule,
+// where to and how many archives are kept
+func collectBackups(config *Config) {
+	if !readBool("backups", tr("prompt.backups"), false) {
+		return
+	}
+	config.BackupDir = readValidated("backup_dir", tr("prompt.backup_dir"), defaultBackupDir, validateBackupDir)
+	config.BackupRetention = readIntInRange("backup_retention", tr("prompt.backup_retention"), defaultBackupRetention, 1, maxBackupRetentionCount)
+	config.BackupTimer = platform.SystemdUnit && isSystemdHost()
+	if config.BackupTimer {
+		config.BackupSchedule = readValidated("backup_schedule", tr("prompt.backup_schedule", "systemd OnCalendar"), defaultBackupCalendar, validateOnCalendar)
+	} else {
+		config.BackupSchedule = readValidated("backup_schedule", tr("prompt.backup_schedule", "cron"), defaultBackupCron, validateCronExpression)
+	}
+	if config.ExternalPostgreSQL {
+		warnf("Warning: the database on %s is not part of the backups, back it up with the tools of that server.\n", config.PostgreSQLHost)
+	}
+}
+
+// validateBackupDir accepts absolute paths, the script runs from cron and
+// systemd with another working directory
+func validateBackupDir(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return errors.New("enter an absolute path, e.g. " + defaultBackupDir)
+	}
+	return nil
+}
+
+// Backups reports whether the backup script is generated
+func (c Config) Backups() bool {
+	return c.BackupDir != ""
+}
+
+// installBackupSchedule creates the backup directory and installs the
+// systemd timer or cron entry that runs the backup script
+func installBackupSchedule(config Config, installDir string) {
+	if !config.Backups() {
+		return
+	}
+	infoln("\n=== Scheduled Backups ===")
+	if err := os.MkdirAll(config.BackupDir, 0700); err != nil {
+		warnf("Warning: could not create %s: %v\n", config.BackupDir, err)
+		return
+	}
+	if !isRoot() {
+		infof("Skipping the backup schedule: not running as root. Run %s by hand or from your own scheduler.\n", backupScript)
+		report.skip("backup schedule (requires root)")
+		return
+	}
+	installDir, err := filepath.Abs(installDir)
+	if err != nil {
+		warnf("Warning: could not set up the backup schedule: %v\n", err)
+		return
+	}
+	job := scheduledJob{
+		Name:        "pangolin-backup",
+		Description: "Back up the Pangolin installation in " + installDir,
+		WorkDir:     installDir,
+		Schedule:    config.BackupSchedule,
+		Timer:       config.BackupTimer,
+		Commands:    []string{"/bin/sh " + filepath.Join(installDir, backupScript)},
+	}
+	if err := installScheduledJob(job); err != nil {
+		warnf("Warning: could not set up the backup schedule: %v\n", err)
+		return
+	}
+	if job.Timer {
+		infof("Installed and enabled %s (%s), backups go to %s.\n", job.timerName(), job.Schedule, config.BackupDir)
+	} else {
+		infof("Installed %s (%s), backups go to %s.\n", job.cronPath(), job.Schedule, config.BackupDir)
+	}
+	infof("Keeping the last %d archives. Customize the backup in %s.\n", config.BackupRetention, backupScript)
+}
+
+// runBackupNow runs the backup script of the installation in the current
+// directory once, to prove that it works before the first scheduled run
+func runBackupNow() {
+	infoln("\n=== Backup ===")
+	if _, err := os.Stat(backupScript); err != nil {
+		warnf("Warning: %s does not exist, enable the scheduled backups to generate it.\n", backupScript)
+		report.skip("backup (no backup script)")
+		return
+	}
+	// The script prints the archive it wrote
+	if err := execLogged(exec.Command("/bin/sh", backupScript), true); err != nil {
+		errorf("The backup failed: %v\n", err)
+		return
+	}
+	infof("The backup works, the scheduled runs use the same script.\n")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//go:embed cdnranges.txt
+var embeddedCDNRanges string
+
+const cdnRefreshTimeout = 3 * time.Second
+
+// cdnRange is an edge network of a CDN provider
+type cdnRange struct {
+	Provider string
+	Net      *net.IPNet
+}
+
+type cdnRanges []cdnRange
+
+// provider returns the CDN that ip belongs to, or ""
+func (r cdnRanges) provider(ip net.IP) string {
+	for _, cdn := range r {
+		if cdn.Net.Contains(ip) {
+			return cdn.Provider
+		}
+	}
+	return ""
+}
+
+func parseCDNRanges(text string) (cdnRanges, error) {
+	var ranges cdnRanges
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		provider, cidr, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid CDN range line %q", line)
+		}
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, cdnRange{Provider: provider, Net: ipNet})
+	}
+	return ranges, scanner.Err()
+}
+
+// cdnRangeSources are the lists published by the providers themselves
+var cdnRangeSources = []struct {
+	provider string
+	url      string
+	parse    func(body []byte) ([]string, error)
+}{
+	{"cloudflare", "https://www.cloudflare.com/ips-v4", parseCIDRLines},
+	{"cloudflare", "https://www.cloudflare.com/ips-v6", parseCIDRLines},
+	{"fastly", "https://api.fastly.com/public-ip-list", func(body []byte) ([]string, error) {
+		var list struct {
+			Addresses     []string `json:"addresses"`
+			IPv6Addresses []string `json:"ipv6_addresses"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		return append(list.Addresses, list.IPv6Addresses...), nil
+	}},
+}
+
+func parseCIDRLines(body []byte) ([]string, error) {
+	return strings.Fields(string(body)), nil
+}
+
+// loadCDNRanges fetches the current CDN ranges and falls back to the embedded
+// list for every provider that cannot be fetched
+func loadCDNRanges(ctx context.Context, refresh bool) cdnRanges {
+	fallback, err := parseCDNRanges(embeddedCDNRanges)
+	if err != nil {
+		// The embedded list is part of the build, this is a programming error
+		panic(err)
+	}
+	if !refresh {
+		return fallback
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cdnRefreshTimeout)
+	defer cancel()
+
+	fetched := map[string]cdnRanges{}
+	failed := map[string]bool{}
+	for _, source := range cdnRangeSources {
+		cidrs, err := fetchCDNList(ctx, source.url, source.parse)
+		if err != nil {
+			logf("INFO", "could not refresh %s ranges from %s: %v", source.provider, source.url, err)
+			failed[source.provider] = true
+			continue
+		}
+		for _, cidr := range cidrs {
+			if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+				fetched[source.provider] = append(fetched[source.provider], cdnRange{Provider: source.provider, Net: ipNet})
+			}
+		}
+	}
+
+	var ranges cdnRanges
+	for _, r := range fallback {
+		if failed[r.Provider] || len(fetched[r.Provider]) == 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	for provider, list := range fetched {
+		if !failed[provider] {
+			ranges = append(ranges, list...)
+		}
+	}
+	return ranges
+}
+
+func fetchCDNList(ctx context.Context, url string, parse func([]byte) ([]string, error)) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parse(body)
+}
+
+// cdnProviderOf returns the CDN any of records points at, or ""
+func cdnProviderOf(records dnsRecords, ranges cdnRanges) string {
+	for _, ip := range append(append([]net.IP{}, records.A...), records.AAAA...) {
+		if provider := ranges.provider(ip); provider != "" {
+			return provider
+		}
+	}
+	return ""
+}
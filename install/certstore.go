@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// acmeStorePath is Traefik's certificate store, see storage in traefik_config.yml
+const acmeStorePath = "config/letsencrypt/acme.json"
+
+// acmeResolver is the certificate resolver the installer configures
+const acmeResolver = "letsencrypt"
+
+// traefikDefaultCertName is the common name of the self-signed certificate
+// Traefik serves when it has no certificate for a host
+const traefikDefaultCertName = "TRAEFIK DEFAULT CERT"
+
+// acmeStoreStatus is what checkACMEStore found
+type acmeStoreStatus struct {
+	Exists bool
+	Mode   os.FileMode
+	// Corrupt is the parse error of a file that is not valid JSON
+	Corrupt error
+	// MissingResolver is set when the store has no section for acmeResolver
+	MissingResolver bool
+	Domains         []string
+}
+
+func (s acmeStoreStatus) ok() bool {
+	return !s.Exists || (s.Mode == 0600 && s.Corrupt == nil && !s.MissingResolver)
+}
+
+// checkACMEStore validates that acme.json parses, is only readable by its
+// owner and holds the installer's resolver. Traefik silently falls back to
+// its default certificate when any of these is wrong.
+func checkACMEStore(path string) acmeStoreStatus {
+	var status acmeStoreStatus
+	info, err := os.Stat(path)
+	if err != nil {
+		return status
+	}
+	status.Exists = true
+	status.Mode = info.Mode().Perm()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		status.Corrupt = err
+		return status
+	}
+	if len(data) == 0 {
+		// Traefik writes an empty store on first start
+		return status
+	}
+	var store map[string]struct {
+		Certificates []struct {
+			Domain struct {
+				Main string   `json:"main"`
+				SANs []string `json:"sans"`
+			} `json:"domain"`
+		} `json:"Certificates"`
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		status.Corrupt = err
+		return status
+	}
+	resolver, ok := store[acmeResolver]
+	status.MissingResolver = !ok
+	for _, cert := range resolver.Certificates {
+		for _, domain := range append([]string{cert.Domain.Main}, cert.Domain.SANs...) {
+			if domain != "" && !slices.Contains(status.Domains, domain) {
+				status.Domains = append(status.Domains, domain)
+			}
+		}
+	}
+	return status
+}
+
+var hostRulePattern = regexp.MustCompile("Host(?:SNI)?\\(`([^`]+)`\\)")
+
+// expectedCertificateDomains returns the hosts of the installer's routers and
+// the wildcard domains, which Traefik requests certificates for on start
+func expectedCertificateDomains(dynamicConfigPath string) []string {
+	data, err := os.ReadFile(dynamicConfigPath)
+	if err != nil {
+		return nil
+	}
+	var domains []string
+	for _, match := range hostRulePattern.FindAllStringSubmatch(string(data), -1) {
+		if !slices.Contains(domains, match[1]) {
+			domains = append(domains, match[1])
+		}
+	}
+	var dynamic struct {
+		HTTP struct {
+			Routers map[string]struct {
+				TLS struct {
+					Domains []struct {
+						Main string   `yaml:"main"`
+						SANs []string `yaml:"sans"`
+					} `yaml:"domains"`
+				} `yaml:"tls"`
+			} `yaml:"routers"`
+		} `yaml:"http"`
+	}
+	if err := yaml.Unmarshal(data, &dynamic); err == nil {
+		for _, router := range dynamic.HTTP.Routers {
+			for _, domain := range router.TLS.Domains {
+				for _, name := range append([]string{domain.Main}, domain.SANs...) {
+					if name != "" && !slices.Contains(domains, name) {
+						domains = append(domains, name)
+					}
+				}
+			}
+		}
+	}
+	slices.Sort(domains)
+	return domains
+}
+
+// repairACMEStore reports the problems of acme.json and offers to fix them.
+// It returns whether problems remain.
+func repairACMEStore(containerType SupportedContainer) bool {
+	infoln("\n=== Certificate Store ===")
+	status := checkACMEStore(acmeStorePath)
+	if !status.Exists {
+		infof("%s does not exist yet, Traefik creates it when it requests the first certificate.\n", acmeStorePath)
+		return false
+	}
+	if status.ok() {
+		infof("%s is valid (mode 600, %d certificate domains).\n", acmeStorePath, len(status.Domains))
+		return false
+	}
+
+	restart, remaining := false, false
+	if status.Mode != 0600 {
+		errorf("%s has mode %o, Traefik ignores the store unless it is 600.\n", acmeStorePath, status.Mode)
+		if readBool("acme_fix_permissions", tr("prompt.acme_fix_permissions"), true) {
+			if err := os.Chmod(acmeStorePath, 0600); err != nil {
+				errorf("Error: %v\n", err)
+				remaining = true
+			} else {
+				infoln("Fixed the permissions of acme.json.")
+				restart = true
+			}
+		} else {
+			remaining = true
+		}
+	}
+
+	switch {
+	case status.Corrupt != nil:
+		errorf("%s is corrupt (%v), Traefik serves its default self-signed certificate.\n", acmeStorePath, status.Corrupt)
+		printReissueDomains()
+		if readBool("acme_move_corrupt", tr("prompt.acme_move_corrupt"), true) {
+			backup := fmt.Sprintf("%s.corrupt-%s", acmeStorePath, time.Now().Format("20060102-150405"))
+			if err := os.Rename(acmeStorePath, backup); err != nil {
+				errorf("Error: %v\n", err)
+				return true
+			}
+			if err := os.WriteFile(acmeStorePath, nil, 0600); err != nil {
+				errorf("Error: %v\n", err)
+				return true
+			}
+			infof("Moved the corrupt store to %s.\n", backup)
+			restart = true
+		} else {
+			remaining = true
+		}
+	case status.MissingResolver:
+		warnf("Warning: %s has no %s section, the certificates were issued by another resolver.\n", acmeStorePath, acmeResolver)
+		printReissueDomains()
+	}
+
+	if restart {
+		switch {
+		case containerType == Undefined:
+			infoln("Restart Traefik so it reloads the certificate store.")
+		case readBool("acme_restart_traefik", tr("prompt.acme_restart_traefik"), true):
+			if err := restartContainer("traefik", containerType); err != nil {
+				errorf("Error: %v\n", err)
+			}
+		}
+	}
+	return remaining
+}
+
+func printReissueDomains() {
+	domains := expectedCertificateDomains("config/traefik/dynamic_config.yml")
+	if len(domains) == 0 {
+		return
+	}
+	infoln("Traefik requests new certificates for:")
+	for _, domain := range domains {
+		infof("  %s\n", domain)
+	}
+	infoln("and for every Pangolin resource on its next request.")
+}
+
+// isTraefikDefaultCert reports whether cert is Traefik's self-signed fallback
+func isTraefikDefaultCert(cert *x509.Certificate) bool {
+	return cert.Subject.CommonName == traefikDefaultCertName
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// cleanupStep undoes one side effect of the apply phase
+type cleanupStep struct {
+	description string
+	undo        func() error
+}
+
+// cleanupStack collects the undo functions of the apply phase. While it is
+// armed, SIGINT, SIGTERM and an aborted prompt or spinner unwind it in reverse
+// order and exit non-zero, instead of leaving half-written files and partially
+// started containers behind.
+type cleanupStack struct {
+	mu      sync.Mutex
+	armed   bool
+	steps   []cleanupStep
+	signals chan os.Signal
+}
+
+var cleanups cleanupStack
+
+// arm starts the apply phase and installs the signal handler
+func (c *cleanupStack) arm() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.armed {
+		return
+	}
+	c.armed = true
+	c.signals = make(chan os.Signal, 1)
+	signal.Notify(c.signals, os.Interrupt, syscall.SIGTERM)
+	go func(signals chan os.Signal) {
+		if sig, ok := <-signals; ok {
+			logf("INFO", "received %v during the apply phase", sig)
+			c.abort()
+		}
+	}(c.signals)
+}
+
+// disarm ends the apply phase, drops the pending steps and restores the
+// default signal behavior
+func (c *cleanupStack) disarm() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.armed {
+		return
+	}
+	signal.Stop(c.signals)
+	close(c.signals)
+	c.armed = false
+	c.steps = nil
+}
+
+func (c *cleanupStack) isArmed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.armed
+}
+
+// push registers undo for the side effect that just happened. Outside the
+// apply phase it does nothing, so shared helpers can always call it.
+func (c *cleanupStack) push(description string, undo func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.armed {
+		c.steps = append(c.steps, cleanupStep{description: description, undo: undo})
+	}
+}
+
+// commit keeps everything done so far, for stages the install can resume from
+func (c *cleanupStack) commit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.steps = nil
+}
+
+// abort runs the undo functions in reverse order and exits non-zero
+func (c *cleanupStack) abort() {
+	c.mu.Lock()
+	steps := c.steps
+	c.steps = nil
+	c.mu.Unlock()
+
+	infoln("\nCleaning up…")
+	logf("INFO", "Installation interrupted, running %d cleanup steps", len(steps))
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		infof("  %s\n", step.description)
+		if err := step.undo(); err != nil {
+			warnf("Warning: could not %s: %v\n", step.description, err)
+		}
+	}
+	report.emit("cancelled", "")
+	installLog.close()
+	os.Exit(130)
+}
+
+// removeCreated returns an undo function that removes path, which the apply
+// phase created. Directories are only removed when they are empty.
+func removeCreated(path string) func() error {
+	return func() error {
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+}
+
+func pathExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
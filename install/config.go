@@ -22,10 +22,25 @@ type TraefikConfig struct {
 	CertificatesResolvers struct {
 		LetsEncrypt struct {
 			Acme struct {
-				Email string `yaml:"email"`
+				Email        string    `yaml:"email"`
+				CAServer     string    `yaml:"caServer"`
+				TLSChallenge *struct{} `yaml:"tlsChallenge"`
+				DNSChallenge struct {
+					Provider string `yaml:"provider"`
+				} `yaml:"dnsChallenge"`
 			} `yaml:"acme"`
 		} `yaml:"letsencrypt"`
 	} `yaml:"certificatesResolvers"`
+	EntryPoints map[string]struct {
+		Address string `yaml:"address"`
+		HTTP    struct {
+			Redirections struct {
+				EntryPoint struct {
+					To string `yaml:"to"`
+				} `yaml:"entryPoint"`
+			} `yaml:"redirections"`
+		} `yaml:"http"`
+	} `yaml:"entryPoints"`
 }
 
 // DynamicConfig represents the structure of the dynamic configuration
@@ -42,6 +57,13 @@ type TraefikConfigValues struct {
 	DashboardDomain  string
 	LetsEncryptEmail string
 	BadgerVersion    string
+	HTTPPort         int
+	HTTPSPort        int
+	HTTPMode         string
+	ACMEChallenge    string
+	DNSProvider      string
+	ACMEStaging      bool
+	EnableIPv6       bool
 }
 
 // AppConfig represents the app section of the config.yml
@@ -74,6 +96,29 @@ func ReadTraefikConfig(mainConfigPath string) (*TraefikConfigValues, error) {
 	values := &TraefikConfigValues{
 		BadgerVersion:    mainConfig.Experimental.Plugins.Badger.Version,
 		LetsEncryptEmail: mainConfig.CertificatesResolvers.LetsEncrypt.Acme.Email,
+		HTTPPort:         entryPointPort(mainConfig.EntryPoints["web"].Address, defaultHTTPPort),
+		HTTPSPort:        entryPointPort(mainConfig.EntryPoints["websecure"].Address, defaultHTTPSPort),
+		ACMEChallenge:    challengeHTTP,
+		ACMEStaging:      mainConfig.CertificatesResolvers.LetsEncrypt.Acme.CAServer == letsEncryptStaging,
+		EnableIPv6:       strings.HasPrefix(mainConfig.EntryPoints["websecure"].Address, "[::]"),
+	}
+	if mainConfig.CertificatesResolvers.LetsEncrypt.Acme.TLSChallenge != nil {
+		values.ACMEChallenge = challengeTLSALPN
+	}
+	if provider := mainConfig.CertificatesResolvers.LetsEncrypt.Acme.DNSChallenge.Provider; provider != "" {
+		values.ACMEChallenge = challengeDNS
+		values.DNSProvider = provider
+	}
+	// Installs from before the HTTP modes only redirect the dashboard, which
+	// is what serve does
+	web, ok := mainConfig.EntryPoints["web"]
+	switch {
+	case !ok:
+		values.HTTPMode = httpDisabled
+	case web.HTTP.Redirections.EntryPoint.To != "":
+		values.HTTPMode = httpRedirect
+	default:
+		values.HTTPMode = httpServe
 	}
 
 	return values, nil
@@ -174,7 +219,7 @@ func backupConfig() error {
 	// Backup config directory
 	if _, err := os.Stat("config"); err == nil {
 		cmd := exec.Command("tar", "-czvf", "config.tar.gz", "config")
-		if err := cmd.Run(); err != nil {
+		if err := runCmd(cmd); err != nil {
 			return fmt.Errorf("failed to backup config directory: %v", err)
 		}
 	}
@@ -249,10 +294,11 @@ func CheckAndAddTraefikLogVolume(composePath string) error {
 	var volumes []any
 
 	if existingVolumes, ok := traefik["volumes"].([]any); ok {
-		// Check if volume already exists
+		// Check if volume already exists, installs with an install directory
+		// mount it by absolute path
 		for _, v := range existingVolumes {
-			if v.(string) == logVolume {
-				fmt.Println("Traefik log volume is already configured")
+			if volume, _ := v.(string); volume == logVolume || strings.HasSuffix(volume, "/config/traefik/logs:/var/log/traefik") {
+				infoln("Traefik log volume is already configured")
 				return nil
 			}
 		}
@@ -273,7 +319,7 @@ func CheckAndAddTraefikLogVolume(composePath string) error {
 		return fmt.Errorf("error writing updated compose file: %w", err)
 	}
 
-	fmt.Println("Added traefik log volume and created logs directory")
+	infoln("Added traefik log volume and created logs directory")
 	return nil
 }
 
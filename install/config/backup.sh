@@ -0,0 +1,65 @@
+#!/bin/sh
+# Backs up this Pangolin installation while it keeps running: a consistent
+# copy of the database, the config directory, the compose file and its
+# secrets go into one archive, and archives beyond the retention count are
+# removed. Generated by the Pangolin installer, edit it to fit your setup.
+set -eu
+umask 077
+
+INSTALL_DIR=$(cd "$(dirname "$0")/.." && pwd)
+BACKUP_DIR="${BACKUP_DIR:-{{.BackupDir}}}"
+RETENTION="${RETENTION:-{{.BackupRetention}}}"
+
+if command -v docker >/dev/null 2>&1; then
+	RUNTIME=docker
+else
+	RUNTIME=podman
+fi
+
+STAMP=$(date +%Y%m%d-%H%M%S)
+mkdir -p "$BACKUP_DIR"
+WORK=$(mktemp -d "$BACKUP_DIR/.pangolin-$STAMP.XXXXXX")
+trap 'rm -rf "$WORK"' EXIT
+{{if .BundledPostgreSQL}}
+# pg_dump takes a consistent snapshot without stopping the database
+"$RUNTIME" exec postgres pg_dump -U pangolin --clean --if-exists pangolin >"$WORK/pangolin.sql"
+{{- else if .IsPostgreSQL}}
+# The database runs on {{.PostgreSQLHost}}, back it up with the tools of that
+# server. Only the configuration is archived here.
+{{- else}}
+# SQLite's online backup copies the database consistently while Pangolin
+# writes to it, which copying the file does not
+DB_DIR="{{if .SeparateDataDir}}{{.DataPath "db"}}{{else}}$INSTALL_DIR/config/db{{end}}"
+if command -v sqlite3 >/dev/null 2>&1; then
+	sqlite3 "$DB_DIR/db.sqlite" ".backup '$WORK/db.sqlite'"
+else
+	# Without sqlite3 on the host, the better-sqlite3 module of the Pangolin
+	# image runs the same backup inside the container
+	"$RUNTIME" exec -w /app pangolin node -e "require('better-sqlite3')('/app/config/db/db.sqlite', { readonly: true }).backup('/app/config/db/.backup.sqlite').then(() => process.exit(0), (err) => { console.error(err); process.exit(1) })"
+	mv "$DB_DIR/.backup.sqlite" "$WORK/db.sqlite"
+fi
+{{- end}}
+
+# The live database and the logs are left out, the snapshot above replaces
+# the database
+cd "$INSTALL_DIR"
+set -- config docker-compose.yml
+for extra in .env secrets; do
+	if [ -e "$extra" ]; then
+		set -- "$@" "$extra"
+	fi
+done
+ARCHIVE="$BACKUP_DIR/pangolin-$STAMP.tar.gz"
+tar -czf "$WORK/archive.tar.gz" \
+	--exclude=config/db \
+	--exclude=config/logs \
+	--exclude=config/traefik/logs \
+	"$@" -C "$WORK" $(cd "$WORK" && ls db.sqlite pangolin.sql 2>/dev/null || true)
+mv "$WORK/archive.tar.gz" "$ARCHIVE"
+echo "Backup written to $ARCHIVE"
+
+# The timestamps sort by age, the newest archives are kept
+ls -1 "$BACKUP_DIR"/pangolin-*.tar.gz | sort -r | tail -n +"$((RETENTION + 1))" | while read -r old; do
+	rm -f "$old"
+	echo "Removed $old"
+done
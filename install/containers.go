@@ -2,14 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
-	"os/user"
-	"runtime"
 	"strconv"
 	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func waitForContainer(containerName string, containerType SupportedContainer) error {
@@ -22,7 +23,7 @@ func waitForContainer(containerName string, containerType SupportedContainer) er
 		var out bytes.Buffer
 		cmd.Stdout = &out
 
-		if err := cmd.Run(); err != nil {
+		if err := runCmd(cmd); err != nil {
 			// If the container doesn't exist or there's another error, wait and retry
 			time.Sleep(retryInterval)
 			continue
@@ -41,21 +42,19 @@ func waitForContainer(containerName string, containerType SupportedContainer) er
 }
 
 func installDocker() error {
-	// Detect Linux distribution
-	cmd := exec.Command("cat", "/etc/os-release")
-	output, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to detect Linux distribution: %v", err)
+	if !network.allow("Docker installation") {
+		return fmt.Errorf("Docker cannot be installed offline, install it from local packages first")
+	}
+	distro, distroVersion := facts.distro()
+	if distro == "" {
+		return fmt.Errorf("failed to detect Linux distribution")
 	}
-	osRelease := string(output)
 
 	// Detect system architecture
-	archCmd := exec.Command("uname", "-m")
-	archOutput, err := archCmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to detect system architecture: %v", err)
+	arch := facts.machine()
+	if arch == "" {
+		return fmt.Errorf("failed to detect system architecture")
 	}
-	arch := strings.TrimSpace(string(archOutput))
 
 	// Map architecture to Docker's architecture naming
 	var dockerArch string
@@ -70,7 +69,7 @@ func installDocker() error {
 
 	var installCmd *exec.Cmd
 	switch {
-	case strings.Contains(osRelease, "ID=ubuntu"):
+	case distro == "ubuntu":
 		installCmd = exec.Command("bash", "-c", fmt.Sprintf(`
 			apt-get update &&
 			apt-get install -y apt-transport-https ca-certificates curl gpg &&
@@ -79,7 +78,7 @@ func installDocker() error {
 			apt-get update &&
 			apt-get install -y docker-ce docker-ce-cli containerd.io docker-compose-plugin
 		`, dockerArch))
-	case strings.Contains(osRelease, "ID=debian"):
+	case distro == "debian":
 		installCmd = exec.Command("bash", "-c", fmt.Sprintf(`
 			apt-get update &&
 			apt-get install -y apt-transport-https ca-certificates curl gpg &&
@@ -88,16 +87,9 @@ func installDocker() error {
 			apt-get update &&
 			apt-get install -y docker-ce docker-ce-cli containerd.io docker-compose-plugin
 		`, dockerArch))
-	case strings.Contains(osRelease, "ID=fedora"):
+	case distro == "fedora":
 		// Detect Fedora version to handle DNF 5 changes
-		versionCmd := exec.Command("bash", "-c", "grep VERSION_ID /etc/os-release | cut -d'=' -f2 | tr -d '\"'")
-		versionOutput, err := versionCmd.Output()
-		var fedoraVersion int
-		if err == nil {
-			if v, parseErr := strconv.Atoi(strings.TrimSpace(string(versionOutput))); parseErr == nil {
-				fedoraVersion = v
-			}
-		}
+		fedoraVersion, _ := strconv.Atoi(distroVersion)
 
 		// Use appropriate DNF syntax based on version
 		var repoCmd string
@@ -114,12 +106,12 @@ func installDocker() error {
 			%s &&
 			dnf install -y docker-ce docker-ce-cli containerd.io docker-compose-plugin
 		`, repoCmd))
-	case strings.Contains(osRelease, "ID=opensuse") || strings.Contains(osRelease, "ID=\"opensuse-"):
+	case strings.HasPrefix(distro, "opensuse"):
 		installCmd = exec.Command("bash", "-c", `
 			zypper install -y docker docker-compose &&
 			systemctl enable docker
 		`)
-	case strings.Contains(osRelease, "ID=rhel") || strings.Contains(osRelease, "ID=\"rhel"):
+	case distro == "rhel":
 		installCmd = exec.Command("bash", "-c", `
 			dnf remove -y runc &&
 			dnf -y install yum-utils &&
@@ -127,7 +119,7 @@ func installDocker() error {
 			dnf install -y docker-ce docker-ce-cli containerd.io docker-compose-plugin &&
 			systemctl enable docker
 		`)
-	case strings.Contains(osRelease, "ID=amzn"):
+	case distro == "amzn":
 		installCmd = exec.Command("bash", "-c", `
 			yum update -y &&
 			yum install -y docker &&
@@ -138,85 +130,56 @@ func installDocker() error {
 		return fmt.Errorf("unsupported Linux distribution")
 	}
 
-	installCmd.Stdout = os.Stdout
-	installCmd.Stderr = os.Stderr
-	return installCmd.Run()
+	// The packages come from the distribution's and Docker's repositories
+	return withExitCode(exitNetwork, execLogged(installCmd, true))
 }
 
 func startDockerService() error {
-	switch runtime.GOOS {
-	case "linux":
-		cmd := exec.Command("systemctl", "enable", "--now", "docker")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		return cmd.Run()
-	case "darwin":
-		// On macOS, Docker is usually started via the Docker Desktop application
-		fmt.Println("Please start Docker Desktop manually on macOS.")
+	if !platform.InstallDocker {
+		// Docker Desktop is started as an application
+		infoln("Please start Docker Desktop manually.")
 		return nil
 	}
-	return fmt.Errorf("unsupported operating system for starting Docker service")
+	return execLogged(exec.Command("systemctl", "enable", "--now", "docker"), true)
 }
 
 func isDockerInstalled() bool {
-	return isContainerInstalled("docker")
+	return facts.dockerInstalled()
 }
 
 func isPodmanInstalled() bool {
-	return isContainerInstalled("podman") && isContainerInstalled("podman-compose")
+	return facts.podmanInstalled()
 }
 
 func isContainerInstalled(container string) bool {
 	cmd := exec.Command(container, "--version")
-	if err := cmd.Run(); err != nil {
+	if err := runCmd(cmd); err != nil {
 		return false
 	}
 	return true
 }
 
 func isUserInDockerGroup() bool {
-	if runtime.GOOS == "darwin" {
-		// Docker group is not applicable on macOS
+	if !platform.DockerGroup {
+		// Docker Desktop grants access to the socket without a docker group
 		// So we assume that the user can run Docker commands
 		return true
 	}
 
-	if os.Geteuid() == 0 {
+	if isRoot() {
 		return true // Root user can run Docker commands anyway
 	}
 
-	// Check if the current user is in the docker group
-	if dockerGroup, err := user.LookupGroup("docker"); err == nil {
-		if currentUser, err := user.Current(); err == nil {
-			if currentUserGroupIds, err := currentUser.GroupIds(); err == nil {
-				for _, groupId := range currentUserGroupIds {
-					if groupId == dockerGroup.Gid {
-						return true
-					}
-				}
-			}
-		}
-	}
-
-	// Eventually, if any of the checks fail, we assume the user cannot run Docker commands
-	return false
+	return facts.dockerGroupMember()
 }
 
 // isDockerRunning checks if the Docker daemon is running by using the `docker info` command.
 func isDockerRunning() bool {
-	cmd := exec.Command("docker", "info")
-	if err := cmd.Run(); err != nil {
-		return false
-	}
-	return true
+	return facts.dockerRunning()
 }
 
 func isPodmanRunning() bool {
-	cmd := exec.Command("podman", "info")
-	if err := cmd.Run(); err != nil {
-		return false
-	}
-	return true
+	return facts.podmanRunning()
 }
 
 // detectContainerType detects whether the system is currently using Docker or Podman
@@ -225,7 +188,7 @@ func detectContainerType() SupportedContainer {
 	// Check if we have running containers with podman
 	if isPodmanRunning() {
 		cmd := exec.Command("podman", "ps", "-q")
-		output, err := cmd.Output()
+		output, err := outputCmd(cmd)
 		if err == nil && len(strings.TrimSpace(string(output))) > 0 {
 			return Podman
 		}
@@ -234,7 +197,7 @@ func detectContainerType() SupportedContainer {
 	// Check if we have running containers with docker
 	if isDockerRunning() {
 		cmd := exec.Command("docker", "ps", "-q")
-		output, err := cmd.Output()
+		output, err := outputCmd(cmd)
 		if err == nil && len(strings.TrimSpace(string(output))) > 0 {
 			return Docker
 		}
@@ -252,86 +215,110 @@ func detectContainerType() SupportedContainer {
 	return Undefined
 }
 
-// executeDockerComposeCommandWithArgs executes the appropriate docker command with arguments supplied
-func executeDockerComposeCommandWithArgs(args ...string) error {
-	var cmd *exec.Cmd
-	var useNewStyle bool
+// composeCommand builds a compose command for the given container runtime.
+// Docker prefers the compose plugin and falls back to docker-compose.
+func composeCommand(ctx context.Context, containerType SupportedContainer, args ...string) (*exec.Cmd, error) {
+	switch containerType {
+	case Podman:
+		return exec.CommandContext(ctx, "podman-compose", args...), nil
+	case Docker:
+	default:
+		return nil, fmt.Errorf("unsupported container type: %s", containerType)
+	}
 
 	if !isDockerInstalled() {
-		return fmt.Errorf("docker is not installed")
+		return nil, fmt.Errorf("docker is not installed")
 	}
 
-	checkCmd := exec.Command("docker", "compose", "version")
-	if err := checkCmd.Run(); err == nil {
-		useNewStyle = true
-	} else {
-		checkCmd = exec.Command("docker-compose", "version")
-		if err := checkCmd.Run(); err == nil {
-			useNewStyle = false
-		} else {
-			return fmt.Errorf("neither 'docker compose' nor 'docker-compose' command is available")
-		}
+	switch facts.dockerCompose() {
+	case "plugin":
+		return exec.CommandContext(ctx, "docker", append([]string{"compose"}, args...)...), nil
+	case "standalone":
+		return exec.CommandContext(ctx, "docker-compose", args...), nil
 	}
+	return nil, fmt.Errorf("neither 'docker compose' nor 'docker-compose' command is available")
+}
 
-	if useNewStyle {
-		cmd = exec.Command("docker", append([]string{"compose"}, args...)...)
-	} else {
-		cmd = exec.Command("docker-compose", args...)
+// executeDockerComposeCommandWithArgs executes the appropriate docker command with arguments supplied
+func executeDockerComposeCommandWithArgs(args ...string) error {
+	cmd, err := composeCommand(context.Background(), Docker, args...)
+	if err != nil {
+		return err
 	}
-
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return execLogged(cmd, true)
 }
 
-// pullContainers pulls the containers using the appropriate command.
-func pullContainers(containerType SupportedContainer) error {
-	fmt.Println("Pulling the container images...")
-	if containerType == Podman {
-		if err := run("podman-compose", "-f", "docker-compose.yml", "pull"); err != nil {
-			return fmt.Errorf("failed to pull the containers: %v", err)
-		}
-
-		return nil
+// composeServices returns the service names defined in a compose file in the
+// order they appear
+func composeServices(composePath string) ([]string, error) {
+	data, err := os.ReadFile(composePath)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", composePath, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s is empty", composePath)
+	}
+	services := yamlMapValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("services section not found or invalid")
 	}
 
-	if containerType == Docker {
-		if err := executeDockerComposeCommandWithArgs("-f", "docker-compose.yml", "pull", "--policy", "always"); err != nil {
-			return fmt.Errorf("failed to pull the containers: %v", err)
-		}
+	var names []string
+	for i := 0; i < len(services.Content); i += 2 {
+		names = append(names, services.Content[i].Value)
+	}
+	return names, nil
+}
 
+// pullContainers pulls the image of every service, --parallel-pulls at a
+// time.
+func pullContainers(containerType SupportedContainer) error {
+	if !network.allow("image pull") {
+		infoln("Offline: the stack starts from the images already on this host.")
 		return nil
 	}
 
-	return fmt.Errorf("unsupported container type: %s", containerType)
+	services, err := composeServices("docker-compose.yml")
+	if err != nil {
+		return fmt.Errorf("failed to read services: %v", err)
+	}
+	if err := pullImages(context.Background(), containerType, services); err != nil {
+		return fmt.Errorf("failed to pull the containers: %w", err)
+	}
+	return nil
 }
 
 // startContainers starts the containers using the appropriate command.
 func startContainers(containerType SupportedContainer) error {
-	fmt.Println("Starting containers...")
-
-	if containerType == Podman {
-		if err := run("podman-compose", "-f", "docker-compose.yml", "up", "-d", "--force-recreate"); err != nil {
-			return fmt.Errorf("failed start containers: %v", err)
+	err := runStep(context.Background(), "Starting containers", func(ctx context.Context) error {
+		cmd, err := composeCommand(ctx, containerType, "-f", "docker-compose.yml", "up", "-d", "--force-recreate")
+		if err != nil {
+			return err
 		}
-
-		return nil
+		return runCmd(cmd)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start containers: %v", err)
 	}
 
-	if containerType == Docker {
-		if err := executeDockerComposeCommandWithArgs("-f", "docker-compose.yml", "up", "-d", "--force-recreate"); err != nil {
-			return fmt.Errorf("failed to start containers: %v", err)
-		}
-
-		return nil
-	}
+	return nil
+}
 
-	return fmt.Errorf("unsupported container type: %s", containerType)
+// pushComposeDown registers taking the stack down again, which also removes
+// the networks compose created for it, should the apply phase be interrupted
+func pushComposeDown(containerType SupportedContainer) {
+	cleanups.push("remove the containers and networks", func() error {
+		return stopContainers(containerType)
+	})
 }
 
 // stopContainers stops the containers using the appropriate command.
 func stopContainers(containerType SupportedContainer) error {
-	fmt.Println("Stopping containers...")
+	infoln("Stopping containers...")
 	if containerType == Podman {
 		if err := run("podman-compose", "-f", "docker-compose.yml", "down"); err != nil {
 			return fmt.Errorf("failed to stop containers: %v", err)
@@ -353,7 +340,7 @@ func stopContainers(containerType SupportedContainer) error {
 
 // restartContainer restarts a specific container using the appropriate command.
 func restartContainer(container string, containerType SupportedContainer) error {
-	fmt.Println("Restarting containers...")
+	infoln("Restarting containers...")
 	if containerType == Podman {
 		if err := run("podman-compose", "-f", "docker-compose.yml", "restart"); err != nil {
 			return fmt.Errorf("failed to stop the container \"%s\": %v", container, err)
@@ -372,3 +359,38 @@ func restartContainer(container string, containerType SupportedContainer) error
 
 	return fmt.Errorf("unsupported container type: %s", containerType)
 }
+
+// runCompose runs a compose command against an explicit compose file
+func runCompose(containerType SupportedContainer, composeFile string, args ...string) error {
+	args = append([]string{"-f", composeFile}, args...)
+	switch containerType {
+	case Podman:
+		return run("podman-compose", args...)
+	case Docker:
+		return executeDockerComposeCommandWithArgs(args...)
+	}
+	return fmt.Errorf("unsupported container type: %s", containerType)
+}
+
+// waitForContainerHealthy waits until the container reports a healthy state.
+// Containers without a health check are considered healthy once running.
+func waitForContainerHealthy(ctx context.Context, containerName string, containerType SupportedContainer, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	state := "unknown"
+	for time.Now().Before(deadline) {
+		out, err := outputCmd(exec.CommandContext(ctx, string(containerType), "container", "inspect", "-f",
+			"{{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}}", containerName))
+		if err == nil {
+			state = strings.TrimSpace(string(out))
+			if state == "healthy" || state == "running" {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+	return fmt.Errorf("container %s is %s after %v", containerName, state, timeout)
+}
@@ -2,16 +2,101 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// crowdsecHubItem is a collection or scenario offered during the CrowdSec step
+type crowdsecHubItem struct {
+	Name string
+	// Kind is the cscli item type, e.g. collections or scenarios
+	Kind string
+	// Default items are preselected
+	Default bool
+}
+
+var crowdsecHubItems = []crowdsecHubItem{
+	{Name: "crowdsecurity/traefik", Kind: "collections", Default: true},
+	{Name: "crowdsecurity/http-probing", Kind: "scenarios", Default: true},
+	{Name: "crowdsecurity/linux", Kind: "collections"},
+	{Name: "crowdsecurity/base-http-scenarios", Kind: "collections"},
+	{Name: "crowdsecurity/http-cve", Kind: "collections"},
+}
+
+// collectCrowdsecOptions asks for the optional console enrollment key and the
+// hub items to install on top of the image defaults
+func collectCrowdsecOptions(config *Config) {
+	config.CrowdsecEnrollKey = ""
+	if readBool("crowdsec_enroll", tr("prompt.crowdsec_enroll"), false) {
+		config.CrowdsecEnrollKey = readPassword("crowdsec_enroll_key", tr("prompt.crowdsec_enroll_key"))
+	}
+
+	names := make([]string, len(crowdsecHubItems))
+	var defaults []string
+	for i, item := range crowdsecHubItems {
+		names[i] = item.Name
+		if item.Default {
+			defaults = append(defaults, item.Name)
+		}
+	}
+	config.CrowdsecHubItems = readMultiChoice("crowdsec_hub_items", tr("prompt.crowdsec_hub_items"), names, defaults)
+}
+
+// configureCrowdsecHub installs the selected hub items and enrolls the
+// instance. Failures only warn, CrowdSec works without either.
+func configureCrowdsecHub(config Config) {
+	if (len(config.CrowdsecHubItems) > 0 || config.CrowdsecEnrollKey != "") && !network.allow("CrowdSec hub items and console enrollment") {
+		return
+	}
+	containerType := string(config.InstallationContainerType)
+	installed := 0
+	for _, name := range config.CrowdsecHubItems {
+		kind := "collections"
+		for _, item := range crowdsecHubItems {
+			if item.Name == name {
+				kind = item.Kind
+			}
+		}
+		if err := runCmd(exec.Command(containerType, "exec", "crowdsec", "cscli", kind, "install", name)); err != nil {
+			warnf("Warning: could not install %s: %v\n", name, err)
+			continue
+		}
+		installed++
+	}
+
+	enrolled := false
+	if config.CrowdsecEnrollKey != "" {
+		// The key is passed through the environment so it stays out of the
+		// process list and the install log
+		cmd := exec.Command(containerType, "exec", "-e", "CROWDSEC_ENROLL_KEY", "crowdsec",
+			"sh", "-c", `cscli console enroll --name pangolin-crowdsec --tags docker "$CROWDSEC_ENROLL_KEY"`)
+		cmd.Env = append(os.Environ(), "CROWDSEC_ENROLL_KEY="+config.CrowdsecEnrollKey)
+		if err := runCmd(cmd); err != nil {
+			warnf("Warning: enrolling in the CrowdSec console failed: %v\n", err)
+			infof("Enroll later with: %s exec crowdsec cscli console enroll <key>\n", containerType)
+		} else {
+			enrolled = true
+			infoln("Enrolled in the CrowdSec console, accept the instance at https://app.crowdsec.net to finish.")
+		}
+	}
+
+	if installed == 0 && !enrolled {
+		return
+	}
+	// CrowdSec loads new hub items and the console configuration on start
+	if err := restartContainer("crowdsec", config.InstallationContainerType); err != nil {
+		warnf("Warning: could not restart CrowdSec: %v\n", err)
+	}
+}
+
 func installCrowdsec(config Config, installDir string) error {
 
 	if err := stopContainers(config.InstallationContainerType); err != nil {
@@ -24,65 +109,62 @@ func installCrowdsec(config Config, installDir string) error {
 	}
 
 	if err := createConfigFiles(config); err != nil {
-		fmt.Printf("Error creating config files: %v\n", err)
-		os.Exit(1)
+		fatalf("Error creating config files: %v\n", err)
 	}
 
 	if err := os.MkdirAll("config/crowdsec/db", 0755); err != nil {
-		fmt.Printf("Error creating config files: %v\n", err)
-		os.Exit(1)
+		fatalf("Error creating config files: %v\n", err)
 	}
 	if err := os.MkdirAll("config/crowdsec/acquis.d", 0755); err != nil {
-		fmt.Printf("Error creating config files: %v\n", err)
-		os.Exit(1)
+		fatalf("Error creating config files: %v\n", err)
 	}
 	if err := os.MkdirAll("config/traefik/logs", 0755); err != nil {
-		fmt.Printf("Error creating config files: %v\n", err)
-		os.Exit(1)
+		fatalf("Error creating config files: %v\n", err)
 	}
 
 	setupTraefikLogRotate(installDir)
 
 	if err := copyDockerService("config/crowdsec/docker-compose.yml", "docker-compose.yml", "crowdsec"); err != nil {
-		fmt.Printf("Error copying docker service: %v\n", err)
-		os.Exit(1)
+		fatalf("Error copying docker service: %v\n", err)
+	}
+
+	// The CrowdSec overlays use the namespaced router names, migrate older
+	// configs first so the merge does not duplicate the dashboard routers
+	if changes, err := applyMigrations(); err != nil {
+		return fmt.Errorf("failed to migrate the Traefik config: %v", err)
+	} else if len(changes) > 0 {
+		infof("Migrated %d config keys to the current layout.\n", len(changes))
 	}
 
 	if err := MergeYAML("config/traefik/traefik_config.yml", "config/crowdsec/traefik_config.yml"); err != nil {
-		fmt.Printf("Error copying entry points: %v\n", err)
-		os.Exit(1)
+		fatalf("Error copying entry points: %v\n", err)
 	}
 	// delete the 2nd file
 	if err := os.Remove("config/crowdsec/traefik_config.yml"); err != nil {
-		fmt.Printf("Error removing file: %v\n", err)
-		os.Exit(1)
+		fatalf("Error removing file: %v\n", err)
 	}
 
 	if err := MergeYAML("config/traefik/dynamic_config.yml", "config/crowdsec/dynamic_config.yml"); err != nil {
-		fmt.Printf("Error copying entry points: %v\n", err)
-		os.Exit(1)
+		fatalf("Error copying entry points: %v\n", err)
 	}
 	// delete the 2nd file
 	if err := os.Remove("config/crowdsec/dynamic_config.yml"); err != nil {
-		fmt.Printf("Error removing file: %v\n", err)
-		os.Exit(1)
+		fatalf("Error removing file: %v\n", err)
 	}
 
 	if err := os.Remove("config/crowdsec/docker-compose.yml"); err != nil {
-		fmt.Printf("Error removing file: %v\n", err)
-		os.Exit(1)
+		fatalf("Error removing file: %v\n", err)
 	}
 
 	if err := CheckAndAddTraefikLogVolume("docker-compose.yml"); err != nil {
-		fmt.Printf("Error checking and adding Traefik log volume: %v\n", err)
-		os.Exit(1)
+		fatalf("Error checking and adding Traefik log volume: %v\n", err)
 	}
 
 	// check and add the service dependency of crowdsec to traefik
 	if err := CheckAndAddCrowdsecDependency("docker-compose.yml"); err != nil {
-		fmt.Printf("Error adding crowdsec dependency to traefik: %v\n", err)
-		os.Exit(1)
+		fatalf("Error adding crowdsec dependency to traefik: %v\n", err)
 	}
+	applySELinux("docker-compose.yml", installDir)
 
 	if err := startContainers(config.InstallationContainerType); err != nil {
 		return fmt.Errorf("failed to start containers: %v", err)
@@ -94,6 +176,7 @@ func installCrowdsec(config Config, installDir string) error {
 		return fmt.Errorf("failed to get API key: %v", err)
 	}
 	config.TraefikBouncerKey = apiKey
+	configureCrowdsecHub(config)
 
 	if err := replaceInFile("config/traefik/dynamic_config.yml", "PUT_YOUR_BOUNCER_KEY_HERE_OR_IT_WILL_NOT_WORK", config.TraefikBouncerKey); err != nil {
 		return fmt.Errorf("failed to replace bouncer key: %v", err)
@@ -104,10 +187,66 @@ func installCrowdsec(config Config, installDir string) error {
 	}
 
 	if checkIfTextInFile("config/traefik/dynamic_config.yml", "PUT_YOUR_BOUNCER_KEY_HERE_OR_IT_WILL_NOT_WORK") {
-		fmt.Println("Failed to replace bouncer key! Please retrieve the key and replace it in the config/traefik/dynamic_config.yml file using the following command:")
-		fmt.Printf("	%s exec crowdsec cscli bouncers add traefik-bouncer\n", config.InstallationContainerType)
+		infoln("Failed to replace bouncer key! Please retrieve the key and replace it in the config/traefik/dynamic_config.yml file using the following command:")
+		infof("	%s exec crowdsec cscli bouncers add traefik-bouncer\n", config.InstallationContainerType)
+		return nil
 	}
 
+	verifyCrowdsecBouncer(config)
+	return nil
+}
+
+// crowdsecHandshakeTimeout bounds the wait for the CrowdSec local API to
+// accept the bouncer key after the restart
+const crowdsecHandshakeTimeout = time.Minute
+
+// verifyCrowdsecBouncer checks that the local API accepts the bouncer key,
+// asking it from the Traefik container the way the bouncer plugin does. A
+// rejected key or an unreachable API only warns, with how to fix it: Traefik
+// answers every request with an error while the bouncer cannot check them.
+func verifyCrowdsecBouncer(config Config) {
+	containerType := config.InstallationContainerType
+	err := runStep(context.Background(), "Checking the CrowdSec bouncer key", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, crowdsecHandshakeTimeout)
+		defer cancel()
+		if err := waitForContainerHealthy(ctx, "crowdsec", containerType, crowdsecHandshakeTimeout); err != nil {
+			return err
+		}
+		for {
+			err := crowdsecLapiHandshake(ctx, containerType, config.TraefikBouncerKey)
+			if err == nil {
+				return nil
+			}
+			logf("INFO", "CrowdSec bouncer handshake: %v", err)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(3 * time.Second):
+			}
+		}
+	})
+	if err == nil {
+		infoln("The CrowdSec local API accepts the Traefik bouncer key.")
+		return
+	}
+	warnf("Warning: the Traefik bouncer could not authenticate with CrowdSec: %v\n", err)
+	infoln("Create a new key, put it in crowdsecLapiKey in config/traefik/dynamic_config.yml and restart Traefik:")
+	infof("	%s exec crowdsec cscli bouncers delete traefik-bouncer\n", containerType)
+	infof("	%s exec crowdsec cscli bouncers add traefik-bouncer\n", containerType)
+	infof("	%s restart traefik\n", containerType)
+}
+
+// crowdsecLapiHandshake asks the local API for the decisions of an address
+// with key, from the Traefik container so the network between the two is
+// checked too. The key is passed through the environment to keep it out of
+// the process list and the install log.
+func crowdsecLapiHandshake(ctx context.Context, containerType SupportedContainer, key string) error {
+	cmd := exec.CommandContext(ctx, string(containerType), "exec", "-e", "CROWDSEC_BOUNCER_KEY", "traefik",
+		"sh", "-c", `wget -q -O /dev/null --header "X-Api-Key: $CROWDSEC_BOUNCER_KEY" "http://crowdsec:8080/v1/decisions?ip=127.0.0.1"`)
+	cmd.Env = append(os.Environ(), "CROWDSEC_BOUNCER_KEY="+key)
+	if err := runCmd(cmd); err != nil {
+		return fmt.Errorf("the local API rejected the key or is not reachable: %v", err)
+	}
 	return nil
 }
 
@@ -133,7 +272,7 @@ func GetCrowdSecAPIKey(containerType SupportedContainer) (string, error) {
 	var out bytes.Buffer
 	cmd.Stdout = &out
 
-	if err := cmd.Run(); err != nil {
+	if err := runCmd(cmd); err != nil {
 		return "", fmt.Errorf("executing command: %w", err)
 	}
 
@@ -208,7 +347,7 @@ func CheckAndAddCrowdsecDependency(composePath string) error {
 		return fmt.Errorf("error writing updated compose file: %w", err)
 	}
 
-	fmt.Println("Added dependency of crowdsec to traefik")
+	infoln("Added dependency of crowdsec to traefik")
 	return nil
 }
 
@@ -225,10 +364,10 @@ func setupTraefikLogRotate(installDir string) {
 
 	logPath := filepath.Join(installDir, "config/traefik/logs/access.log")
 
-	if os.Geteuid() != 0 {
-		fmt.Println("\n[logrotate] Skipping automatic logrotate setup: not running as root.")
-		fmt.Println("[logrotate] To prevent unbounded growth of the Traefik access log used by CrowdSec,")
-		fmt.Println("[logrotate] create the file /etc/logrotate.d/pangolin-traefik manually with:")
+	if !isRoot() {
+		infoln("\n[logrotate] Skipping automatic logrotate setup: not running as root.")
+		infoln("[logrotate] To prevent unbounded growth of the Traefik access log used by CrowdSec,")
+		infoln("[logrotate] create the file /etc/logrotate.d/pangolin-traefik manually with:")
 		printLogrotateConfig(logPath)
 		return
 	}
@@ -247,25 +386,26 @@ func setupTraefikLogRotate(installDir string) {
 `, logPath)
 
 	if err := os.MkdirAll(logrotateDir, 0755); err != nil {
-		fmt.Printf("[logrotate] Warning: could not create %s: %v\n", logrotateDir, err)
+		infof("[logrotate] Warning: could not create %s: %v\n", logrotateDir, err)
 		return
 	}
 
 	if err := os.WriteFile(logrotateFile, []byte(config), 0644); err != nil {
-		fmt.Printf("[logrotate] Warning: could not write %s: %v\n", logrotateFile, err)
-		fmt.Println("[logrotate] Set it up manually:")
+		infof("[logrotate] Warning: could not write %s: %v\n", logrotateFile, err)
+		infoln("[logrotate] Set it up manually:")
 		printLogrotateConfig(logPath)
 		return
 	}
 
-	fmt.Printf("[logrotate] Wrote logrotate config to %s\n", logrotateFile)
-	fmt.Println("[logrotate] Traefik access logs will be rotated daily, keeping 7 compressed copies.")
+	infof("[logrotate] Wrote logrotate config to %s\n", logrotateFile)
+	recordExternal(externalResource{Kind: resourceFile, Path: logrotateFile, Description: "logrotate config for Traefik access logs"})
+	infoln("[logrotate] Traefik access logs will be rotated daily, keeping 7 compressed copies.")
 }
 
 // printLogrotateConfig prints a logrotate config block to stdout so users can
 // set it up manually when the installer cannot write to /etc.
 func printLogrotateConfig(logPath string) {
-	fmt.Printf(`
+	infof(`
   %s {
       daily
       rotate 7
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// diagnosticsFile is the default name of the bundle diagnose writes
+const diagnosticsFile = "pangolin-diagnostics.txt"
+
+// diagnoseLogLines is how much of the log of an unhealthy service the report
+// shows
+const diagnoseLogLines = 50
+
+// lowDiskSpace is the free space below which diagnose warns, at half of it
+// diagnose fails
+const lowDiskSpace = 2 << 30
+
+// diagnosedFiles are the configuration files included in the bundle
+var diagnosedFiles = []string{
+	"docker-compose.yml",
+	envFile,
+	"config/config.yml",
+	"config/traefik/traefik_config.yml",
+	"config/traefik/dynamic_config.yml",
+}
+
+// secretLinePattern matches a YAML or env key ending in a word for a
+// credential, for secrets the installer does not know about, e.g. added by hand
+var secretLinePattern = regexp.MustCompile(`(?i)^(\s*-?\s*"?[\w.-]*(?:secret|password|passwd|pass|token|key|connection_string)"?\s*[:=]\s*)\S.*$`)
+
+// envLinePattern matches a variable of .env, which only holds secrets
+var envLinePattern = regexp.MustCompile(`(?m)^(\s*[A-Za-z_][A-Za-z0-9_]*=).*$`)
+
+// diagnosis collects the findings of diagnose for the console and the bundle
+type diagnosis struct {
+	bundle   bytes.Buffer
+	problems int
+}
+
+func (d *diagnosis) section(title string) {
+	infof("\n=== %s ===\n", title)
+	fmt.Fprintf(&d.bundle, "\n=== %s ===\n", title)
+}
+
+func (d *diagnosis) ok(format string, a ...any) {
+	d.line(colors.Success, colors.SuccessGlyph, "OK", fmt.Sprintf(format, a...))
+}
+
+func (d *diagnosis) warn(format string, a ...any) {
+	d.line(colors.Primary, "!", "WARN", fmt.Sprintf(format, a...))
+}
+
+func (d *diagnosis) fail(format string, a ...any) {
+	d.problems++
+	d.line(colors.Error, colors.ErrorGlyph, "FAIL", fmt.Sprintf(format, a...))
+}
+
+func (d *diagnosis) line(color lipgloss.TerminalColor, glyph, level, msg string) {
+	infoln(lipgloss.NewStyle().Foreground(color).Render(glyph + " " + msg))
+	fmt.Fprintf(&d.bundle, "[%s] %s\n", level, msg)
+}
+
+// attach adds content to the bundle only, under a title
+func (d *diagnosis) attach(title string, content []byte) {
+	fmt.Fprintf(&d.bundle, "\n--- %s ---\n", title)
+	d.bundle.Write(content)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		d.bundle.WriteByte('\n')
+	}
+}
+
+// runDiagnose implements the diagnose subcommand
+func runDiagnose(args []string) {
+	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	dirFlag := fs.String("dir", "", "Installation directory (default: the current directory or /opt/pangolin)")
+	out := fs.String("out", diagnosticsFile, "File to write the redacted diagnostics bundle to")
+	addOfflineFlag(fs)
+	addTerminalFlags(fs)
+	addLogFileFlag(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	outPath, err := filepath.Abs(*out)
+	if err != nil {
+		exitf(exitInvalidInput, "Error: invalid --out: %v\n", err)
+	}
+	dir := *dirFlag
+	if dir == "" {
+		var ok bool
+		if dir, ok = locateExistingInstall(); !ok {
+			fatalf("Error: no Pangolin installation found in the current directory or /opt/pangolin\n")
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+	openInstallLog(dir)
+	defer installLog.close()
+
+	var d diagnosis
+	infof("=== Pangolin Diagnostics (%s) ===\n", dir)
+	fmt.Fprintf(&d.bundle, "Pangolin diagnostics, %s\nInstaller: %s\nInstallation: %s\nHost: %s/%s\n",
+		time.Now().UTC().Format(time.RFC3339), orUnknown(pangolinVersion), dir, runtime.GOOS, runtime.GOARCH)
+
+	containerType := detectContainerType()
+	diagnoseContainers(&d, containerType)
+	diagnoseTraefik(&d, containerType)
+	diagnoseDiskSpace(&d, dir, containerType)
+	diagnoseDNS(&d)
+	for _, path := range diagnosedFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if path == envFile {
+			data = envLinePattern.ReplaceAll(data, []byte("${1}[redacted]"))
+		}
+		d.attach(path, data)
+	}
+
+	bundle := scrubSecrets(d.bundle.String(), installedSecrets())
+	if err := os.WriteFile(outPath, []byte(bundle), 0600); err != nil {
+		fatalf("Error writing %s: %v\n", outPath, err)
+	}
+	infof("\nWrote %s with secrets redacted, review it before attaching it to an issue.\n", outPath)
+	if d.problems > 0 {
+		fatalf("Problems found: %d, see above.\n", d.problems)
+	}
+}
+
+// diagnoseContainers reports the state and restart count of every container
+// of the stack and attaches the log of those that are not healthy
+func diagnoseContainers(d *diagnosis, containerType SupportedContainer) {
+	d.section("Containers")
+	if containerType == Undefined {
+		d.fail("Neither Docker nor Podman is running")
+		return
+	}
+	names, _ := stackContainers(containerType)
+	for _, name := range names {
+		out, err := exec.Command(string(containerType), "container", "inspect", "-f",
+			"{{.State.Status}}|{{if .State.Health}}{{.State.Health.Status}}{{end}}|{{.RestartCount}}", name).Output()
+		if err != nil {
+			// Optional components are simply not listed
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(string(out)), "|", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		state, health := fields[0], fields[1]
+		restarts, _ := strconv.Atoi(fields[2])
+		summary := state
+		if health != "" {
+			summary += " (" + health + ")"
+		}
+		summary = fmt.Sprintf("%-10s %s, %d restarts", name, summary, restarts)
+		switch {
+		case state != "running" || health == "unhealthy":
+			d.fail("%s", summary)
+			diagnoseLogs(d, containerType, name)
+		case restarts > 0:
+			d.warn("%s", summary)
+		default:
+			d.ok("%s", summary)
+		}
+	}
+}
+
+// diagnoseLogs shows and attaches the last lines of the log of container
+func diagnoseLogs(d *diagnosis, containerType SupportedContainer, container string) {
+	// Containers log to both streams
+	var out bytes.Buffer
+	cmd := exec.Command(string(containerType), "logs", "--tail", strconv.Itoa(diagnoseLogLines), container)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := runCmd(cmd); err != nil {
+		d.warn("Could not read the logs of %s: %v", container, err)
+		return
+	}
+	muted := lipgloss.NewStyle().Foreground(colors.Muted)
+	for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		infoln(muted.Render("    " + line))
+	}
+	d.attach(fmt.Sprintf("last %d log lines of %s", diagnoseLogLines, container), out.Bytes())
+}
+
+// diagnoseTraefik reports the routers Traefik failed to load and the domains
+// the certificate store has no certificate for
+func diagnoseTraefik(d *diagnosis, containerType SupportedContainer) {
+	d.section("Traefik")
+	if installedBehindExistingProxy() {
+		d.ok("Routing and certificates are managed by your existing reverse proxy")
+		return
+	}
+	if containerType != Undefined {
+		routers, err := fetchTraefikRouters(containerType)
+		if err != nil {
+			d.fail("%v", err)
+		} else {
+			enabled := 0
+			for _, router := range routers {
+				if router.Status == "" || router.Status == "enabled" {
+					enabled++
+					continue
+				}
+				d.fail("Router %s is %s: %s", router.Name, router.Status, strings.Join(router.Errors, "; "))
+			}
+			d.ok("%d of %d routers enabled", enabled, len(routers))
+			for _, conflict := range routerConflicts(routers, installedDashboardDomain()) {
+				d.warn("%s", conflict)
+			}
+		}
+	}
+
+	if pathExists(ownCertDir) {
+		certificates, err := loadCertificateDir(ownCertDir)
+		if err != nil {
+			d.fail("The certificates in %s: %v", ownCertDir, err)
+		}
+		for _, certificate := range certificates {
+			expiry := certificate.Leaf.NotAfter.Format(time.DateOnly)
+			if time.Until(certificate.Leaf.NotAfter) < ownCertExpiryWarning {
+				d.warn("Certificate %s expires on %s, renew it", certificate.CertPath, expiry)
+			} else {
+				d.ok("Certificate %s, expires on %s", certificate.CertPath, expiry)
+			}
+		}
+		return
+	}
+	status := checkACMEStore(acmeStorePath)
+	switch {
+	case !status.Exists:
+		d.warn("%s does not exist yet, Traefik has not requested a certificate", acmeStorePath)
+		return
+	case status.Mode != 0600:
+		d.fail("%s has mode %o, Traefik ignores it unless it is 600 (run the doctor subcommand)", acmeStorePath, status.Mode)
+	case status.Corrupt != nil:
+		d.fail("%s is corrupt: %v (run the doctor subcommand)", acmeStorePath, status.Corrupt)
+		return
+	case status.MissingResolver:
+		d.warn("%s has no %s section", acmeStorePath, acmeResolver)
+	}
+	for _, domain := range expectedCertificateDomains("config/traefik/dynamic_config.yml") {
+		if slices.Contains(status.Domains, domain) {
+			d.ok("Certificate for %s", domain)
+		} else {
+			d.warn("No certificate for %s yet, see the Traefik log", domain)
+		}
+	}
+}
+
+// diagnoseDiskSpace reports the free space of the install and data
+// directories and of the container storage
+func diagnoseDiskSpace(d *diagnosis, dir string, containerType SupportedContainer) {
+	d.section("Disk Space")
+	paths := []string{dir}
+	if state, err := loadInstallState(dir); err == nil && state.DataDir != "" {
+		paths = append(paths, state.DataDir)
+	}
+	if containerType != Undefined {
+		format := "{{.DockerRootDir}}"
+		if containerType == Podman {
+			format = "{{.Store.GraphRoot}}"
+		}
+		if out, err := outputCmd(exec.Command(string(containerType), "info", "-f", format)); err == nil {
+			if root := strings.TrimSpace(string(out)); root != "" {
+				paths = append(paths, root)
+			}
+		}
+	}
+	for _, path := range paths {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(path, &stat); err != nil {
+			d.warn("Could not check the free space of %s: %v", path, err)
+			continue
+		}
+		free, total := stat.Bavail*uint64(stat.Bsize), stat.Blocks*uint64(stat.Bsize)
+		summary := fmt.Sprintf("%s: %s free of %s", path, formatGiB(free), formatGiB(total))
+		switch {
+		case free < lowDiskSpace/2:
+			d.fail("%s", summary)
+		case free < lowDiskSpace:
+			d.warn("%s", summary)
+		default:
+			d.ok("%s", summary)
+		}
+	}
+}
+
+// diagnoseDNS resolves the dashboard domain and the hosts of the installer's
+// routers
+func diagnoseDNS(d *diagnosis) {
+	d.section("DNS")
+	if !network.allow("DNS diagnostics") {
+		d.warn("Skipped, --offline")
+		return
+	}
+	hosts := []string{}
+	if domain := installedDashboardDomain(); domain != "" {
+		hosts = append(hosts, domain)
+	}
+	for _, host := range expectedCertificateDomains("config/traefik/dynamic_config.yml") {
+		if !strings.HasPrefix(host, "*.") && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dnsCheckTimeout)
+	defer cancel()
+	for _, host := range hosts {
+		records, err := lookupRecords(ctx, net.DefaultResolver, host, nil)
+		if err != nil {
+			d.fail("%s does not resolve: %v", host, err)
+			continue
+		}
+		var addresses []string
+		for _, ip := range append(records.A, records.AAAA...) {
+			addresses = append(addresses, ip.String())
+		}
+		d.ok("%s resolves to %s", host, strings.Join(addresses, ", "))
+	}
+}
+
+// scrubSecrets replaces the known secret values in s and the value of every
+// line whose key names a credential
+func scrubSecrets(s string, secrets []string) string {
+	// Longer secrets first, so one containing another is replaced whole
+	slices.SortFunc(secrets, func(a, b string) int { return len(b) - len(a) })
+	for _, secret := range secrets {
+		if secret = strings.TrimSpace(secret); len(secret) >= 4 {
+			s = strings.ReplaceAll(s, secret, "[redacted]")
+		}
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = secretLinePattern.ReplaceAllString(line, "${1}[redacted]")
+	}
+	return strings.Join(lines, "\n")
+}
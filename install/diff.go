@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// maxDiffBytes is the largest file diffed line by line, the LCS table of
+// bigger files would take too much time and memory
+const maxDiffBytes = 256 << 10
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines computes a line based diff of a and b using their longest common
+// subsequence. Config files are small enough for the quadratic table.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// renderFileDiff renders the change from old to new content of path as a
+// colored unified diff. A nil old means the file is created. Occurrences of
+// any secret are replaced before rendering. It returns "" when nothing changed.
+func renderFileDiff(path string, old, new []byte, secrets []string) string {
+	redact := func(s string) string {
+		for _, secret := range secrets {
+			if secret != "" {
+				s = strings.ReplaceAll(s, secret, "[redacted]")
+			}
+		}
+		return s
+	}
+
+	if len(old) > maxDiffBytes || len(new) > maxDiffBytes {
+		return renderLargeFileSummary(path, old, new)
+	}
+
+	ops := diffLines(splitLines(old), splitLines(new))
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	added := lipgloss.NewStyle().Foreground(colors.Success)
+	removed := lipgloss.NewStyle().Foreground(colors.Error)
+	header := lipgloss.NewStyle().Bold(true)
+
+	var b strings.Builder
+	if old == nil {
+		fmt.Fprintln(&b, header.Render("--- /dev/null"))
+	} else {
+		fmt.Fprintln(&b, header.Render("--- "+path))
+	}
+	fmt.Fprintln(&b, header.Render("+++ "+path))
+
+	// Only print lines within diffContext of a change
+	lastPrinted := -1
+	for idx, op := range ops {
+		near := false
+		for k := max(0, idx-diffContext); k <= min(len(ops)-1, idx+diffContext); k++ {
+			if ops[k].kind != ' ' {
+				near = true
+				break
+			}
+		}
+		if !near {
+			continue
+		}
+		if lastPrinted != -1 && idx != lastPrinted+1 {
+			fmt.Fprintln(&b, lipgloss.NewStyle().Foreground(colors.Muted).Render("@@"))
+		}
+		lastPrinted = idx
+
+		line := string(op.kind) + " " + redact(op.line)
+		switch op.kind {
+		case '+':
+			line = added.Render(line)
+		case '-':
+			line = removed.Render(line)
+		}
+		fmt.Fprintln(&b, line)
+	}
+	return b.String()
+}
+
+// configSecrets lists the secret values of config for redaction
+func configSecrets(config Config) []string {
+	secrets := []string{config.Secret, config.EmailSMTPPass, config.IsPostgreSQLPass, config.IsRedisPass, config.TraefikBouncerKey, config.CrowdsecEnrollKey, config.AdminPassword}
+	secrets = append(secrets, dnsSecretValues(config.DNSCredentials)...)
+	secrets = append(secrets, ownCertificateSecrets(config.OwnCertificates)...)
+	if config.OIDC != nil {
+		secrets = append(secrets, config.OIDC.ClientSecret)
+	}
+	if config.IsPostgreSQL {
+		// The password is URL-escaped inside the connection string
+		secrets = append(secrets, config.PostgreSQLConnectionString())
+	}
+	return secrets
+}
+
+// renderLargeFileSummary stands in for the diff of a file above maxDiffBytes.
+// It returns "" when the content is unchanged.
+func renderLargeFileSummary(path string, old, new []byte) string {
+	if old != nil && bytes.Equal(old, new) {
+		return ""
+	}
+	header := lipgloss.NewStyle().Bold(true)
+	if old == nil {
+		return header.Render("+++ "+path) + fmt.Sprintf("\n  new file of %d bytes, too large to diff\n", len(new))
+	}
+	return header.Render("--- "+path) + "\n" + header.Render("+++ "+path) +
+		fmt.Sprintf("\n  file too large to diff (%d bytes before, %d bytes after)\n", len(old), len(new))
+}
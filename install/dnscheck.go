@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+const dnsCheckTimeout = 5 * time.Second
+
+// ipResolver is the subset of *net.Resolver used by the DNS pre-check, so the
+// checks can run against canned responses
+type ipResolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// networkEnv describes how the host reaches the internet
+type networkEnv struct {
+	// IPv4 and IPv6 are the source addresses used for outbound traffic, nil
+	// when there is no route for that family
+	IPv4 net.IP
+	IPv6 net.IP
+	// NAT64Prefix is set when the resolver synthesizes AAAA records (DNS64)
+	NAT64Prefix *net.IPNet
+	// Forwarded is set when the host is reached through port forwarding
+	// (WSL, Docker Desktop), so its own addresses are not the published ones
+	Forwarded bool
+}
+
+// ipv6Only reports whether the host has no IPv4 connectivity of its own
+func (e networkEnv) ipv6Only() bool {
+	return e.IPv4 == nil && e.IPv6 != nil
+}
+
+func (e networkEnv) behindIPv4NAT() bool {
+	return e.IPv4 != nil && (e.Forwarded || e.IPv4.IsPrivate())
+}
+
+// routeSource returns the local address the kernel would use to reach target.
+// Dialing UDP sends no packets. It is a variable so tests can replace it.
+var routeSource = func(network, target string) net.IP {
+	conn, err := net.Dial(network, target)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.IsGlobalUnicast() {
+		return addr.IP
+	}
+	return nil
+}
+
+// detectNetworkEnv finds the outbound addresses of the host and whether the
+// resolver performs DNS64, using the ipv4only.arpa name from RFC 7050
+func detectNetworkEnv(ctx context.Context, resolver ipResolver) networkEnv {
+	env := networkEnv{
+		IPv4: routeSource("udp4", "192.0.2.1:53"),
+		IPv6: routeSource("udp6", "[2001:db8::1]:53"),
+	}
+
+	ips, err := resolver.LookupIP(ctx, "ip6", "ipv4only.arpa")
+	if err == nil {
+		for _, ip := range ips {
+			if ip.To4() == nil && len(ip) == net.IPv6len {
+				env.NAT64Prefix = &net.IPNet{IP: ip.Mask(net.CIDRMask(96, 128)), Mask: net.CIDRMask(96, 128)}
+				break
+			}
+		}
+	}
+	return env
+}
+
+// dnsRecords holds the A and AAAA records of a hostname
+type dnsRecords struct {
+	A    []net.IP
+	AAAA []net.IP
+}
+
+func lookupRecords(ctx context.Context, resolver ipResolver, host string, nat64 *net.IPNet) (dnsRecords, error) {
+	var records dnsRecords
+	var notFound int
+
+	a, err := resolver.LookupIP(ctx, "ip4", host)
+	if err != nil && !isNotFound(err) {
+		return records, err
+	} else if err != nil {
+		notFound++
+	}
+	records.A = a
+
+	aaaa, err := resolver.LookupIP(ctx, "ip6", host)
+	if err != nil && !isNotFound(err) {
+		return records, err
+	} else if err != nil {
+		notFound++
+	}
+	// DNS64 synthesized records only point at the NAT64 gateway
+	for _, ip := range aaaa {
+		if nat64 == nil || !nat64.Contains(ip) {
+			records.AAAA = append(records.AAAA, ip)
+		}
+	}
+
+	if notFound == 2 {
+		return records, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// dnsFinding is a single result of the DNS pre-check
+type dnsFinding struct {
+	Warning bool
+	Message string
+}
+
+// checkDNS validates that host resolves to this server for the address
+// families it actually has, and flags components that need IPv4 when the
+// host is IPv6 only.
+func checkDNS(ctx context.Context, resolver ipResolver, env networkEnv, host string, config Config, cdn cdnRanges) []dnsFinding {
+	var findings []dnsFinding
+	warn := func(msg string) { findings = append(findings, dnsFinding{Warning: true, Message: msg}) }
+	info := func(msg string) { findings = append(findings, dnsFinding{Message: msg}) }
+
+	records, err := lookupRecords(ctx, resolver, host, env.NAT64Prefix)
+	if err != nil {
+		warn("Could not resolve " + host + ": " + err.Error())
+		return findings
+	}
+
+	// Proxied records point at the CDN edge, not at this server
+	if provider := cdnProviderOf(records, cdn); provider != "" {
+		info(host + " is proxied by " + provider + ", its records point at the " + provider + " edge instead of this server.")
+		return findings
+	}
+
+	if env.ipv6Only() {
+		info("This host has no IPv4 connectivity, validating AAAA records.")
+		if env.NAT64Prefix != nil {
+			info("NAT64/DNS64 detected (" + env.NAT64Prefix.String() + "), outbound IPv4 traffic is translated.")
+		}
+		switch {
+		case len(records.AAAA) == 0:
+			warn(host + " has no AAAA record. An IPv6 only server must be published with an AAAA record.")
+		case !containsIP(records.AAAA, env.IPv6):
+			warn(host + " has AAAA records " + joinIPs(records.AAAA) + " but this server is " + env.IPv6.String() + ".")
+		}
+		if len(records.A) > 0 {
+			warn(host + " also has A records " + joinIPs(records.A) + ", IPv4 clients will reach a different machine.")
+		}
+
+		if !config.EnableIPv6 {
+			warn("IPv6 is disabled in the compose network, Pangolin cannot be reached on an IPv6 only host.")
+		}
+		if env.NAT64Prefix == nil {
+			warn("Without NAT64 the host cannot reach IPv4 only services: pulling from docker.io, downloading the Badger plugin and MaxMind databases may fail.")
+		}
+		if config.InstallGerbil {
+			warn("Newt sites and clients on IPv4 only networks cannot reach the WireGuard endpoint of an IPv6 only server.")
+		}
+		info(fmt.Sprintf("Open TCP %s and UDP %d and %d for IPv6 in your firewall (ip6tables, or the IPv6 rules of your provider's security group).", joinPorts(config.EntryPointPorts()), config.GerbilPorts()[0], config.GerbilPorts()[1]))
+		if config.HTTPEnabled() {
+			info("Let's Encrypt HTTP-01 validation works over IPv6 as long as port 80 is open for IPv6.")
+		}
+		return findings
+	}
+
+	if len(records.A) == 0 && len(records.AAAA) == 0 {
+		warn(host + " has no A or AAAA records.")
+		return findings
+	}
+	if env.IPv4 != nil && !env.behindIPv4NAT() && len(records.A) > 0 && !containsIP(records.A, env.IPv4) {
+		warn(host + " has A records " + joinIPs(records.A) + " but this server is " + env.IPv4.String() + ".")
+	}
+	if env.behindIPv4NAT() && len(records.A) > 0 {
+		info("This host is behind IPv4 NAT, make sure " + joinIPs(records.A) + " forwards ports 80 and 443 to it.")
+	}
+	if config.EnableIPv6 && env.IPv6 != nil && len(records.AAAA) == 0 {
+		info(host + " has no AAAA record, IPv6 clients connect over IPv4. Add an AAAA record for " + env.IPv6.String() + " to serve them over IPv6.")
+	}
+	if len(records.AAAA) > 0 {
+		if env.IPv6 == nil {
+			warn(host + " has AAAA records but this host has no IPv6 connectivity. Remove them or IPv6 clients will fail to connect.")
+		} else if !env.Forwarded && !containsIP(records.AAAA, env.IPv6) {
+			warn(host + " has AAAA records " + joinIPs(records.AAAA) + " but this server is " + env.IPv6.String() + ".")
+		}
+	}
+	return findings
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	return slices.ContainsFunc(ips, func(candidate net.IP) bool { return candidate.Equal(ip) })
+}
+
+func joinIPs(ips []net.IP) string {
+	parts := make([]string, len(ips))
+	for i, ip := range ips {
+		parts[i] = ip.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkEndpointFamily validates that the WireGuard endpoint advertised to Newt
+// can be reached over the address families this server has
+func checkEndpointFamily(ctx context.Context, resolver ipResolver, env networkEnv, config Config) []dnsFinding {
+	var findings []dnsFinding
+	warn := func(msg string) { findings = append(findings, dnsFinding{Warning: true, Message: msg}) }
+	info := func(msg string) { findings = append(findings, dnsFinding{Message: msg}) }
+
+	endpoint := strings.Trim(config.TunnelEndpoint(), "[]")
+	if ip := net.ParseIP(endpoint); ip != nil {
+		switch {
+		case ip.To4() == nil && !config.EnableIPv6:
+			warn("The tunnel endpoint " + endpoint + " is an IPv6 address but IPv6 is disabled in the compose network.")
+		case ip.To4() == nil && env.IPv4 != nil:
+			info("The tunnel endpoint is an IPv6 address, Newt sites on IPv4 only networks cannot connect. Use a hostname with A and AAAA records instead.")
+		case ip.To4() != nil && env.ipv6Only():
+			warn("The tunnel endpoint " + endpoint + " is an IPv4 address but this server is IPv6 only.")
+		}
+		return findings
+	}
+	if endpoint == config.DashboardDomain && env.ipv6Only() {
+		// checkDNS already validated the AAAA records of the dashboard domain
+		return findings
+	}
+
+	records, err := lookupRecords(ctx, resolver, endpoint, env.NAT64Prefix)
+	if err != nil {
+		return findings
+	}
+	switch {
+	case env.ipv6Only() && len(records.AAAA) == 0:
+		warn("The tunnel endpoint " + endpoint + " has no AAAA record, Newt cannot reach this IPv6 only server.")
+	case config.EnableIPv6 && env.IPv6 != nil && len(records.AAAA) == 0:
+		info("The tunnel endpoint " + endpoint + " has no AAAA record, Newt connects over IPv4 only.")
+	case env.IPv4 != nil && len(records.A) == 0 && len(records.AAAA) > 0:
+		warn("The tunnel endpoint " + endpoint + " only has AAAA records, Newt sites on IPv4 only networks cannot connect.")
+	}
+	return findings
+}
+
+// collectIPv6 asks whether to enable IPv6 in the compose network, defaulting
+// to yes when the host has a global IPv6 address
+func collectIPv6(config *Config) {
+	hasIPv4, hasIPv6 := facts.hasIPv4(), facts.hasIPv6()
+	logf("INFO", "address families: ipv4=%v ipv6=%v", hasIPv4, hasIPv6)
+	switch {
+	case hasIPv4 && hasIPv6:
+		infoln("This server has IPv4 and IPv6 connectivity.")
+	case hasIPv6:
+		infoln("This server is IPv6 only.")
+	case hasIPv4:
+		infoln("This server has no global IPv6 address.")
+	}
+	config.EnableIPv6 = readBool("enable_ipv6", tr("prompt.enable_ipv6"), hasIPv6)
+	if !config.EnableIPv6 && hasIPv6 && !hasIPv4 {
+		warnf("Warning: without IPv6 Pangolin cannot be reached on an IPv6 only server.\n")
+	}
+}
+
+// checkTunnelEndpoint warns when the WireGuard endpoint hostname is proxied by
+// a CDN, which only forwards HTTP(S), and offers a separate DNS-only hostname
+func checkTunnelEndpoint(config *Config, cdn cdnRanges) {
+	endpoint := config.TunnelEndpoint()
+	provider := proxiedBy(endpoint, cdn)
+	if provider == "" {
+		return
+	}
+
+	warnf("Warning: %s is proxied by %s, but it is also the WireGuard endpoint that Newt connects to over UDP.\n", endpoint, provider)
+	warnf("%s does not proxy UDP, so sites will not be able to connect.\n", provider)
+	infof("  The dashboard (%s) may stay proxied, it only needs HTTPS.\n", config.DashboardDomain)
+	infoln("  The tunnel endpoint must be DNS-only (grey cloud) and point straight at this server.")
+
+	if !readBool("separate_endpoint", tr("prompt.separate_endpoint"), true) {
+		infof("Set %s to DNS-only before connecting sites.\n", endpoint)
+		return
+	}
+
+	defaultEndpoint := ""
+	if config.BaseDomain != "" {
+		defaultEndpoint = "tunnel." + config.BaseDomain
+	}
+	config.GerbilEndpoint = readString("gerbil_endpoint", tr("prompt.gerbil_endpoint"), defaultEndpoint)
+	if config.GerbilEndpoint == "" {
+		config.GerbilEndpoint = endpoint
+		return
+	}
+	if provider := proxiedBy(config.GerbilEndpoint, cdn); provider != "" {
+		warnf("Warning: %s is also proxied by %s. Switch it to DNS-only before connecting sites.\n", config.GerbilEndpoint, provider)
+	} else {
+		infof("Sites will connect to %s. Make sure it has a DNS-only record pointing at this server.\n", config.GerbilEndpoint)
+	}
+}
+
+// proxiedBy returns the CDN host is proxied by, or "" if it is not proxied or
+// cannot be resolved
+func proxiedBy(host string, cdn cdnRanges) string {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsCheckTimeout)
+	defer cancel()
+	records, err := lookupRecords(ctx, net.DefaultResolver, host, nil)
+	if err != nil {
+		return ""
+	}
+	return cdnProviderOf(records, cdn)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// cannedResolver answers LookupIP from a table keyed by network and host, any
+// other name does not exist
+type cannedResolver map[string][]string
+
+func (r cannedResolver) LookupIP(_ context.Context, network, host string) ([]net.IP, error) {
+	answers, ok := r[network+" "+host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	ips := make([]net.IP, len(answers))
+	for i, answer := range answers {
+		ips[i] = net.ParseIP(answer)
+	}
+	return ips, nil
+}
+
+// withRoutes makes routeSource return the given outbound addresses, "" for a
+// family without a route
+func withRoutes(t *testing.T, ipv4, ipv6 string) {
+	t.Helper()
+	saved := routeSource
+	t.Cleanup(func() { routeSource = saved })
+	routeSource = func(network, _ string) net.IP {
+		if network == "udp4" {
+			return net.ParseIP(ipv4)
+		}
+		return net.ParseIP(ipv6)
+	}
+}
+
+const (
+	serverIPv4 = "203.0.113.10"
+	serverIPv6 = "2001:db8::10"
+	// dns64Answer is what a DNS64 resolver returns for ipv4only.arpa
+	dns64Answer = "64:ff9b::c000:aa"
+)
+
+// TestDetectNetworkEnv checks the outbound families and the NAT64 prefix
+// found on a v6-only host behind NAT64, a v6-only host without it and a
+// dual-stack host
+func TestDetectNetworkEnv(t *testing.T) {
+	tests := []struct {
+		name             string
+		ipv4, ipv6       string
+		resolver         cannedResolver
+		ipv6Only, behind bool
+		nat64            string
+	}{
+		{"v6-only with NAT64", "", serverIPv6, cannedResolver{"ip6 ipv4only.arpa": {dns64Answer}}, true, false, "64:ff9b::/96"},
+		{"v6-only", "", serverIPv6, cannedResolver{}, true, false, ""},
+		{"dual-stack", serverIPv4, serverIPv6, cannedResolver{}, false, false, ""},
+		{"dual-stack behind NAT", "192.168.1.10", serverIPv6, cannedResolver{}, false, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withRoutes(t, tt.ipv4, tt.ipv6)
+			env := detectNetworkEnv(context.Background(), tt.resolver)
+			if env.ipv6Only() != tt.ipv6Only || env.behindIPv4NAT() != tt.behind {
+				t.Errorf("ipv6Only %t, behindIPv4NAT %t, want %t and %t", env.ipv6Only(), env.behindIPv4NAT(), tt.ipv6Only, tt.behind)
+			}
+			nat64 := ""
+			if env.NAT64Prefix != nil {
+				nat64 = env.NAT64Prefix.String()
+			}
+			if nat64 != tt.nat64 {
+				t.Errorf("NAT64 prefix %q, want %q", nat64, tt.nat64)
+			}
+		})
+	}
+}
+
+// TestCheckDNS runs the DNS pre-check of the dashboard domain against canned
+// records. AAAA records DNS64 synthesized from an A record must not count as
+// the server's own.
+func TestCheckDNS(t *testing.T) {
+	const host = "pangolin.example.com"
+	_, nat64, _ := net.ParseCIDR("64:ff9b::/96")
+	v6Only := networkEnv{IPv6: net.ParseIP(serverIPv6)}
+	v6OnlyNAT64 := networkEnv{IPv6: net.ParseIP(serverIPv6), NAT64Prefix: nat64}
+	dualStack := networkEnv{IPv4: net.ParseIP(serverIPv4), IPv6: net.ParseIP(serverIPv6)}
+
+	tests := []struct {
+		name     string
+		env      networkEnv
+		records  cannedResolver
+		warnings []string
+		infos    []string
+	}{
+		{
+			name:    "v6-only with NAT64",
+			env:     v6OnlyNAT64,
+			records: cannedResolver{"ip6 " + host: {serverIPv6}},
+			infos:   []string{"no IPv4 connectivity", "NAT64/DNS64 detected (64:ff9b::/96)"},
+		},
+		{
+			name:     "v6-only with NAT64 and only an A record",
+			env:      v6OnlyNAT64,
+			records:  cannedResolver{"ip4 " + host: {"198.51.100.7"}, "ip6 " + host: {"64:ff9b::c633:6407"}},
+			warnings: []string{"has no AAAA record", "also has A records 198.51.100.7"},
+		},
+		{
+			name:     "v6-only without NAT64",
+			env:      v6Only,
+			records:  cannedResolver{"ip6 " + host: {serverIPv6}},
+			warnings: []string{"Without NAT64"},
+		},
+		{
+			name:     "v6-only with another AAAA",
+			env:      v6Only,
+			records:  cannedResolver{"ip6 " + host: {"2001:db8::99"}},
+			warnings: []string{"has AAAA records 2001:db8::99 but this server is " + serverIPv6, "Without NAT64"},
+		},
+		{
+			name:    "dual-stack",
+			env:     dualStack,
+			records: cannedResolver{"ip4 " + host: {serverIPv4}, "ip6 " + host: {serverIPv6}},
+		},
+		{
+			name:    "dual-stack without AAAA",
+			env:     dualStack,
+			records: cannedResolver{"ip4 " + host: {serverIPv4}},
+			infos:   []string{"has no AAAA record, IPv6 clients connect over IPv4"},
+		},
+		{
+			name:     "dual-stack with other records",
+			env:      dualStack,
+			records:  cannedResolver{"ip4 " + host: {"198.51.100.7"}, "ip6 " + host: {"2001:db8::99"}},
+			warnings: []string{"has A records 198.51.100.7", "has AAAA records 2001:db8::99"},
+		},
+		{
+			name:     "no records",
+			env:      dualStack,
+			records:  cannedResolver{},
+			warnings: []string{"Could not resolve " + host},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{DashboardDomain: host, EnableIPv6: true, HTTPPort: defaultHTTPPort, HTTPSPort: defaultHTTPSPort}
+			findings := checkDNS(context.Background(), tt.records, tt.env, host, config, nil)
+			var warnings, infos []string
+			for _, finding := range findings {
+				if finding.Warning {
+					warnings = append(warnings, finding.Message)
+				} else {
+					infos = append(infos, finding.Message)
+				}
+			}
+			if len(warnings) != len(tt.warnings) {
+				t.Errorf("warnings %q, want %q", warnings, tt.warnings)
+			}
+			for _, want := range tt.warnings {
+				if !containsSubstring(warnings, want) {
+					t.Errorf("no warning %q in %q", want, warnings)
+				}
+			}
+			for _, want := range tt.infos {
+				if !containsSubstring(infos, want) {
+					t.Errorf("no info %q in %q", want, infos)
+				}
+			}
+		})
+	}
+}
+
+func containsSubstring(messages []string, want string) bool {
+	for _, message := range messages {
+		if strings.Contains(message, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// secretFile is a sensitive value written to its own file in secretsDir and
+// mounted into the service that needs it under /run/secrets
+type secretFile struct {
+	// Name is the name of the secret in the compose file
+	Name string
+	// Env is the variable the value is passed in without Docker secrets
+	Env     string
+	Value   string
+	Service string
+}
+
+// Path is where the file is written, relative to the install directory
+func (s secretFile) Path() string {
+	return path.Join(secretsDir, s.Name)
+}
+
+// Target is where the services read the secret
+func (s secretFile) Target() string {
+	return "/run/secrets/" + s.Name
+}
+
+// composeSecrets are the secrets of the compose file, passed as secret files
+// with DockerSecrets and as .env variables without
+func (c Config) composeSecrets() []secretFile {
+	var secrets []secretFile
+	add := func(env, value, service string) {
+		secrets = append(secrets, secretFile{Name: strings.ToLower(env), Env: env, Value: value, Service: service})
+	}
+	if c.BundledPostgreSQL() {
+		add(envPostgresPassword, c.IsPostgreSQLPass, "postgres")
+	}
+	if c.IsRedis {
+		add(envRedisPassword, c.IsRedisPass, "redis")
+	}
+	if c.DNSChallenge() {
+		for _, credential := range dnsProviderCredentials(c.DNSProvider) {
+			if value, ok := c.DNSCredentials[credential.Env]; ok && credential.Secret {
+				add(credential.Env, value, "traefik")
+			}
+		}
+	}
+	return secrets
+}
+
+// SecretFiles are the secret files of the compose file, none without
+// DockerSecrets
+func (c Config) SecretFiles() []secretFile {
+	if !c.DockerSecrets {
+		return nil
+	}
+	return c.composeSecrets()
+}
+
+// ServiceSecrets are the names of the secrets mounted into service
+func (c Config) ServiceSecrets(service string) []string {
+	var names []string
+	for _, secret := range c.SecretFiles() {
+		if secret.Service == service {
+			names = append(names, secret.Name)
+		}
+	}
+	return names
+}
+
+// collectDockerSecrets asks whether to pass the secrets of the compose file as
+// Docker secrets, when there are any
+func collectDockerSecrets(config *Config) {
+	if len(config.composeSecrets()) == 0 {
+		return
+	}
+	config.DockerSecrets = readBool("docker_secrets", tr("prompt.docker_secrets"), false)
+}
+
+// renderSecretFiles returns the secret files of config, readable only by
+// their owner
+func renderSecretFiles(config Config) []renderedFile {
+	var files []renderedFile
+	for _, secret := range config.SecretFiles() {
+		files = append(files, renderedFile{Path: secret.Path(), Content: []byte(secret.Value), Mode: 0600})
+	}
+	return files
+}
+
+// printSecretFiles lists the Docker secrets of the install in the summary
+func printSecretFiles(config Config, installDir string) {
+	secrets := config.SecretFiles()
+	if len(secrets) == 0 {
+		return
+	}
+	infoln("\nDocker secrets (mode 600, mounted under /run/secrets):")
+	for _, secret := range secrets {
+		infof("  %-20s %s (%s)\n", secret.Name, filepath.Join(installDir, secret.Path()), secret.Service)
+	}
+}
+
+// installedSecretFiles returns the values of the secret files of the
+// installation, for redaction
+func installedSecretFiles() []string {
+	entries, err := os.ReadDir(secretsDir)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if data, err := os.ReadFile(filepath.Join(secretsDir, entry.Name())); err == nil {
+			values = append(values, string(data))
+		}
+	}
+	return values
+}
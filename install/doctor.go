@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// doctorTimeout bounds the checks, the external WireGuard probe takes
+	// longest
+	doctorTimeout = externalCheckTimeout + 15*time.Second
+	// certificateRenewDays is the remaining validity below which a certificate
+	// counts as not renewed. Traefik renews 30 days before expiry.
+	certificateRenewDays = 14
+	sqliteHeader         = "SQLite format 3\x00"
+)
+
+// runDoctor implements the doctor subcommand
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	dirFlag := fs.String("dir", "", "Installation directory (default: the current directory or /opt/pangolin)")
+	addOfflineFlag(fs)
+	addExternalCheckFlag(fs)
+	addTerminalFlags(fs)
+	addLogFileFlag(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	dir := *dirFlag
+	if dir == "" {
+		var ok bool
+		if dir, ok = locateExistingInstall(); !ok {
+			fatalf("Error: no Pangolin installation found in the current directory or /opt/pangolin\n")
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+	openInstallLog(dir)
+	defer installLog.close()
+
+	infof("=== Pangolin Doctor (%s) ===\n", dir)
+	config, err := installedConfig(dir)
+	if err != nil {
+		warnf("Warning: could not read the installation: %v\n", err)
+	}
+	containerType := config.InstallationContainerType
+	checks := []preflightCheck{
+		checkFunc{"containers", func(context.Context) checkResult { return checkStackContainers(containerType) }},
+		checkFunc{"config", func(context.Context) checkResult { return checkInstalledConfig() }},
+		&dnsCheck{config: config, ranges: make(chan cdnRanges, 1)},
+		checkFunc{"tls", func(ctx context.Context) checkResult { return checkLocalCertificate(ctx, config) }},
+		checkFunc{"wireguard", func(ctx context.Context) checkResult { return checkWireGuardPort(ctx, config) }},
+		checkFunc{"database", func(ctx context.Context) checkResult { return checkDatabase(ctx, config) }},
+	}
+	results := runChecks(context.Background(), checks, doctorTimeout)
+	printCheckResults(checks, results)
+
+	failed := 0
+	for _, result := range results {
+		if result.Status == checkFailed {
+			failed++
+		}
+	}
+	if !config.ExternalProxy && repairACMEStore(containerType) {
+		failed++
+	}
+	if failed > 0 {
+		fatalf("\nProblems remain, see above.\n")
+	}
+	infoln("\nNo problems found.")
+}
+
+// checkStackContainers checks that the container of every service in the
+// compose file is running and not unhealthy
+func checkStackContainers(containerType SupportedContainer) checkResult {
+	if containerType == Undefined {
+		return failedCheck("neither Docker nor Podman is running")
+	}
+	services, err := composeServices("docker-compose.yml")
+	if err != nil {
+		return failedCheck("%v", err)
+	}
+	var result checkResult
+	var broken []string
+	for _, name := range services {
+		out, err := exec.Command(string(containerType), "container", "inspect", "-f",
+			"{{.State.Status}}{{if .State.Health}} ({{.State.Health.Status}}){{end}}", name).Output()
+		state := strings.TrimSpace(string(out))
+		if err != nil {
+			state = "missing"
+		}
+		if !strings.HasPrefix(state, "running") || strings.Contains(state, "unhealthy") {
+			broken = append(broken, name)
+			result.Findings = append(result.Findings, dnsFinding{Warning: true,
+				Message: fmt.Sprintf("%s is %s, see docker logs %s", name, state, name)})
+		}
+	}
+	if len(broken) > 0 {
+		result.Status, result.Detail = checkFailed, fmt.Sprintf("%d of %d not running: %s", len(broken), len(services), strings.Join(broken, ", "))
+		return result
+	}
+	return passedCheck("%d running: %s", len(services), strings.Join(services, ", "))
+}
+
+// checkInstalledConfig checks the generated files against the schema of the
+// programs reading them
+func checkInstalledConfig() checkResult {
+	problems := checkInstalledFiles(func(path string) ([]byte, bool) {
+		content, err := os.ReadFile(path)
+		return content, err == nil
+	})
+	if len(problems) == 0 {
+		return passedCheck("config.yml, docker-compose.yml and the Traefik files are valid")
+	}
+	result := failedCheck("%d files are invalid, see below", len(problems))
+	for _, problem := range problems {
+		result.Findings = append(result.Findings, dnsFinding{Warning: true, Message: problem})
+	}
+	return result
+}
+
+// checkLocalCertificate checks the certificate Traefik serves for the
+// dashboard on this host: issued by Let's Encrypt, trusted and not about to
+// expire
+func checkLocalCertificate(ctx context.Context, config Config) checkResult {
+	if config.ExternalProxy {
+		return skippedCheck("handled by your existing reverse proxy")
+	}
+	if config.DashboardDomain == "" {
+		return failedCheck("no dashboard_url in config/config.yml")
+	}
+	port := config.HTTPSPort
+	if port == 0 {
+		port = defaultHTTPSPort
+	}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 5 * time.Second},
+		Config:    &tls.Config{ServerName: config.DashboardDomain, InsecureSkipVerify: true},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return failedCheck("could not connect to Traefik on TCP %d: %v", port, err)
+	}
+	defer conn.Close()
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return failedCheck("Traefik served no certificate")
+	}
+	leaf := certs[0]
+	if isTraefikDefaultCert(leaf) {
+		return failedCheck("Traefik serves its self-signed default certificate, Let's Encrypt has not issued one, check docker logs traefik")
+	}
+	days := int(time.Until(leaf.NotAfter).Hours() / 24)
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{DNSName: config.DashboardDomain, Intermediates: intermediates})
+	switch {
+	case days < 0:
+		return failedCheck("the certificate expired on %s", leaf.NotAfter.Format("2006-01-02"))
+	case days < certificateRenewDays:
+		return failedCheck("the certificate expires in %d days (%s) and was not renewed, check docker logs traefik", days, leaf.NotAfter.Format("2006-01-02"))
+	case config.ACMEStaging:
+		return passedCheck("valid until %s (%d days), staging, not trusted by browsers", leaf.NotAfter.Format("2006-01-02"), days)
+	case verifyErr != nil:
+		return failedCheck("browsers reject the certificate: %v", verifyErr)
+	}
+	return passedCheck("valid until %s (%d days), issued by %s", leaf.NotAfter.Format("2006-01-02"), days, strings.TrimSpace(strings.Join(leaf.Issuer.Organization, " ")+" "+leaf.Issuer.CommonName))
+}
+
+// checkWireGuardPort checks that Gerbil publishes the WireGuard port and,
+// unless disabled, that it is reachable from the internet
+func checkWireGuardPort(ctx context.Context, config Config) checkResult {
+	if !config.InstallGerbil {
+		return skippedCheck("Gerbil is not installed")
+	}
+	if config.InstallationContainerType == Undefined {
+		return failedCheck("neither Docker nor Podman is running")
+	}
+	port := config.GerbilPorts()[0]
+	out, err := exec.CommandContext(ctx, string(config.InstallationContainerType), "port", "gerbil", fmt.Sprintf("%d/udp", port)).Output()
+	if err != nil || len(bytes.TrimSpace(out)) == 0 {
+		return failedCheck("gerbil does not publish UDP %d, is it running?", port)
+	}
+	if skipExternalCheck || !network.allow("WireGuard reachability check") {
+		return passedCheck("gerbil publishes UDP %d", port)
+	}
+	check := checkExternalUDP(ctx, config)
+	if !check.OK {
+		result := failedCheck("%s", check.Detail)
+		if check.Hint != "" {
+			result.Findings = []dnsFinding{{Warning: true, Message: check.Hint}}
+		}
+		return result
+	}
+	return passedCheck("%s", check.Detail)
+}
+
+// checkDatabase checks that Pangolin's database accepts connections: the
+// bundled postgres service, the external server or the SQLite file
+func checkDatabase(ctx context.Context, config Config) checkResult {
+	switch {
+	case config.BundledPostgreSQL():
+		if config.InstallationContainerType == Undefined {
+			return failedCheck("neither Docker nor Podman is running")
+		}
+		out, err := exec.CommandContext(ctx, string(config.InstallationContainerType), "exec", "postgres", "pg_isready", "-U", "pangolin", "-d", "pangolin").CombinedOutput()
+		if err != nil {
+			return failedCheck("postgres does not accept connections: %s", strings.TrimSpace(string(out)))
+		}
+		return passedCheck("postgres accepts connections")
+	case config.ExternalPostgreSQL:
+		if !network.allow("PostgreSQL connection test") {
+			return skippedCheck("--offline")
+		}
+		address := net.JoinHostPort(config.PostgreSQLHost, strconv.Itoa(config.PostgreSQLPort))
+		ctx, cancel := context.WithTimeout(ctx, postgresConnectTimeout)
+		defer cancel()
+		usedTLS, err := testPostgreSQL(ctx, config.PostgreSQLHost, config.PostgreSQLPort, config.PostgreSQLDatabase, config.PostgreSQLUser, config.IsPostgreSQLPass)
+		if err != nil {
+			return failedCheck("could not connect to %s: %v", address, err)
+		}
+		if usedTLS {
+			return passedCheck("connected to %s over TLS", address)
+		}
+		return passedCheck("connected to %s", address)
+	}
+
+	path := filepath.Join("config", "db", "db.sqlite")
+	if config.SeparateDataDir() {
+		path = filepath.Join(config.DataPath("db"), "db.sqlite")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return failedCheck("%v, Pangolin creates it on its first start", err)
+	}
+	defer f.Close()
+	header := make([]byte, len(sqliteHeader))
+	if _, err := f.Read(header); err != nil || string(header) != sqliteHeader {
+		return failedCheck("%s is not a SQLite database", path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return failedCheck("%v", err)
+	}
+	return passedCheck("SQLite database %s (%.1f MB)", path, float64(info.Size())/(1<<20))
+}
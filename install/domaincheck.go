@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// checkDomainRecords resolves the domains just entered and compares them
+// with the public addresses of this server, so a typo or a missing record is
+// caught before anything else is asked. It returns whether the user wants to
+// enter the domains again.
+func checkDomainRecords(config Config) bool {
+	if answerMissing("base_domain") || config.DashboardDomain == "" {
+		return false
+	}
+	if !network.allow("domain DNS check") {
+		return false
+	}
+
+	var public publicAddresses
+	var findings []dnsFinding
+	runStep(context.Background(), "Checking the DNS records of "+config.DashboardDomain, func(ctx context.Context) error {
+		public = discoverPublicAddresses(ctx)
+		ctx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+		defer cancel()
+		findings = domainFindings(ctx, net.DefaultResolver, config, public, loadCDNRanges(ctx, false))
+		return nil
+	})
+	logf("INFO", "public addresses: ipv4=%v ipv6=%v", public.IPv4, public.IPv6)
+
+	warnings := 0
+	for _, finding := range findings {
+		if finding.Warning {
+			warnf("Warning: %s\n", finding.Message)
+			warnings++
+		} else {
+			infoln(finding.Message)
+		}
+	}
+	if warnings == 0 {
+		return false
+	}
+	// Preset domains would only be read again unchanged
+	for _, key := range []string{"base_domain", "dashboard_domain"} {
+		if _, _, ok := presetValue(key); ok {
+			return false
+		}
+	}
+	return readBool("reenter_domain", tr("prompt.reenter_domain"), false)
+}
+
+// domainFindings checks that the dashboard domain points at public, is not
+// proxied by a CDN, and whether resources below the base domains resolve to
+// this server through a wildcard record
+func domainFindings(ctx context.Context, resolver ipResolver, config Config, public publicAddresses, cdn cdnRanges) []dnsFinding {
+	var findings []dnsFinding
+	warn := func(format string, a ...any) {
+		findings = append(findings, dnsFinding{Warning: true, Message: fmt.Sprintf(format, a...)})
+	}
+	info := func(format string, a ...any) {
+		findings = append(findings, dnsFinding{Message: fmt.Sprintf(format, a...)})
+	}
+
+	if public.IPv4 == nil && public.IPv6 == nil {
+		info("The public address of this server could not be detected, the DNS records are not compared with it.")
+	}
+	host := config.DashboardDomain
+	records, err := lookupRecords(ctx, resolver, host, nil)
+	switch {
+	case isNotFound(err):
+		warn("%s has no A or AAAA record. Create one pointing at %s.", host, publicTargets(public))
+	case err != nil:
+		warn("Could not resolve %s: %v", host, err)
+	default:
+		if provider := cdnProviderOf(records, cdn); provider != "" {
+			warn("%s is proxied by %s. Its proxy only passes HTTP, so Newt sites cannot reach WireGuard through it: make the record DNS-only, or use a separate DNS-only hostname for the tunnel endpoint.", host, provider)
+			break
+		}
+		findings = append(findings, recordMismatches(host, records, public)...)
+	}
+
+	// Resources get names below the base domains, a wildcard record is the
+	// usual way to point them all here
+	label := make([]byte, 6)
+	rand.Read(label)
+	for _, domain := range config.BaseDomains() {
+		probe := "pangolin-check-" + hex.EncodeToString(label) + "." + domain
+		records, err := lookupRecords(ctx, resolver, probe, nil)
+		switch {
+		case err != nil:
+			info("*.%s has no wildcard record, create a record for every resource below %s or a wildcard pointing at %s.", domain, domain, publicTargets(public))
+		case cdnProviderOf(records, cdn) != "":
+			info("*.%s is proxied by %s, resources below %s are reached through its proxy.", domain, cdnProviderOf(records, cdn), domain)
+		default:
+			findings = append(findings, recordMismatches("*."+domain, records, public)...)
+		}
+	}
+	return findings
+}
+
+// recordMismatches compares the records of host with the public addresses.
+// A family that could not be detected is not compared.
+func recordMismatches(host string, records dnsRecords, public publicAddresses) []dnsFinding {
+	var findings []dnsFinding
+	warn := func(format string, a ...any) {
+		findings = append(findings, dnsFinding{Warning: true, Message: fmt.Sprintf(format, a...)})
+	}
+	if public.IPv4 != nil {
+		switch {
+		case len(records.A) == 0:
+			warn("%s has no A record, IPv4 clients cannot reach it. Add one for %s.", host, public.IPv4)
+		case !containsIP(records.A, public.IPv4):
+			warn("%s has A records %s, but the public IPv4 address of this server is %s.", host, joinIPs(records.A), public.IPv4)
+		}
+	}
+	switch {
+	case len(records.AAAA) == 0:
+	case public.IPv6 == nil && public.IPv4 != nil:
+		warn("%s has AAAA records %s, but this server has no public IPv6 address. IPv6 clients will fail to connect.", host, joinIPs(records.AAAA))
+	case public.IPv6 != nil && !containsIP(records.AAAA, public.IPv6):
+		warn("%s has AAAA records %s, but the public IPv6 address of this server is %s.", host, joinIPs(records.AAAA), public.IPv6)
+	}
+	return findings
+}
+
+// publicTargets lists the detected public addresses for a record to point at
+func publicTargets(public publicAddresses) string {
+	var targets []string
+	for _, ip := range []net.IP{public.IPv4, public.IPv6} {
+		if ip != nil {
+			targets = append(targets, ip.String())
+		}
+	}
+	if len(targets) == 0 {
+		return "this server's public address"
+	}
+	return strings.Join(targets, " and ")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// pangolinDomain is an entry of the domains section in config.yml
+type pangolinDomain struct {
+	Key        string
+	BaseDomain string
+	Wildcard   bool
+}
+
+// PangolinDomains lists the domains section of config.yml: the base domain,
+// a wildcard subdomain and the additional domains
+func (c Config) PangolinDomains() []pangolinDomain {
+	domains := []pangolinDomain{{BaseDomain: c.BaseDomain, Wildcard: c.WildcardDomain != "" && c.WildcardDomain == c.BaseDomain}}
+	if c.WildcardDomain != "" && c.WildcardDomain != c.BaseDomain {
+		domains = append(domains, pangolinDomain{BaseDomain: c.WildcardDomain, Wildcard: true})
+	}
+	for _, domain := range c.AdditionalDomains {
+		domains = append(domains, pangolinDomain{BaseDomain: domain})
+	}
+	for i := range domains {
+		domains[i].Key = fmt.Sprintf("domain%d", i+1)
+	}
+	return domains
+}
+
+// BaseDomains are the base domain followed by the additional domains
+func (c Config) BaseDomains() []string {
+	return append([]string{c.BaseDomain}, c.AdditionalDomains...)
+}
+
+// validateDomain normalizes a base domain and rejects anything that is not a
+// plain hostname, e.g. a URL or a wildcard
+func validateDomain(input string) (string, error) {
+	domain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(input)), ".")
+	if strings.ContainsFunc(domain, unicode.IsSpace) {
+		return "", errors.New(tr("input.domain_spaces", input))
+	}
+	if strings.Contains(domain, "://") || strings.ContainsAny(domain, "/:*@") {
+		return "", errors.New(tr("input.domain_not_a_domain", input))
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", errors.New(tr("input.domain_not_fqdn", input))
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", errors.New(tr("input.domain_invalid", input))
+		}
+	}
+	return domain, nil
+}
+
+// collectAdditionalDomains asks for further base domains to register besides
+// the primary one
+func collectAdditionalDomains(config *Config) {
+	config.AdditionalDomains = nil
+	if reconfiguring && len(installedSettings.AdditionalDomains) > 0 {
+		config.AdditionalDomains = slices.Clone(installedSettings.AdditionalDomains)
+		infof("Keeping the additional domains %s.\n", strings.Join(config.AdditionalDomains, ", "))
+	}
+	for readBool("add_domain", tr("prompt.add_domain"), false) {
+		domain := readDomain("additional_domain", tr("prompt.additional_domain"), "")
+		if slices.Contains(config.BaseDomains(), domain) {
+			warnf("%s was already added.\n", domain)
+			continue
+		}
+		config.AdditionalDomains = append(config.AdditionalDomains, domain)
+	}
+}
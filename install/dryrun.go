@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// exitChangesPending is the exit code of a dry run that found changes to
+// make, above the failure codes in exitcodes.go
+const exitChangesPending = 10
+
+// dryRun is set by --dry-run. execLogged records commands with side effects
+// instead of running them, read-only commands still run so the plan reflects
+// the real state.
+var dryRun bool
+
+// recordedCommands are the commands a dry run skipped, in order
+var recordedCommands []string
+
+// readOnlyVerbs are container runtime subcommands that only inspect state
+var readOnlyVerbs = []string{"inspect", "ps", "ls", "images", "version", "info", "logs", "config", "top", "port", "df"}
+
+// runtimeNouns are the object types docker and podman subcommands act on,
+// the verb follows them
+var runtimeNouns = []string{"container", "image", "network", "volume", "system", "builder", "plugin", "context", "secret", "manifest", "pod"}
+
+// runtimeValueFlags are the global and compose flags that take a value
+var runtimeValueFlags = []string{"-f", "--file", "-p", "--project-name", "--project-directory", "--env-file", "--profile", "--context", "-c", "--host", "-H", "--log-level", "--config"}
+
+// dryRunDir is set by --dry-run-dir: a fresh dry run also writes the
+// rendered files there
+var dryRunDir string
+
+// addDryRunFlag registers --dry-run on fs
+func addDryRunFlag(fs *flag.FlagSet, usage string) {
+	fs.BoolVar(&dryRun, "dry-run", false, usage)
+}
+
+func addDryRunDirFlag(fs *flag.FlagSet) {
+	fs.StringVar(&dryRunDir, "dry-run-dir", "", "With --dry-run on a fresh install, also write the rendered files to this directory for review (they contain the generated secrets)")
+}
+
+// sideEffectFree reports whether a command may run during a dry run
+func sideEffectFree(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch filepath.Base(args[0]) {
+	case "docker", "podman":
+		return slices.Contains(readOnlyVerbs, runtimeVerb(args[1:], true))
+	case "docker-compose", "podman-compose":
+		return slices.Contains(readOnlyVerbs, runtimeVerb(args[1:], false))
+	case "systemctl":
+		return len(args) > 1 && slices.Contains([]string{"is-active", "is-enabled", "status", "show"}, args[1])
+	case "getenforce", "sestatus", "uname", "id":
+		return true
+	}
+	return false
+}
+
+// runtimeVerb returns the subcommand of docker or podman arguments: the first
+// word after the global flags, compose and their flags, and a noun like
+// container. --version is the version verb. Anything else, like exec, run or
+// cp, is returned as is and counts as a side effect.
+func runtimeVerb(args []string, nouns bool) string {
+	compose := !nouns
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--version" || (arg == "-v" && i == 0):
+			return "version"
+		case strings.HasPrefix(arg, "-"):
+			if slices.Contains(runtimeValueFlags, arg) {
+				i++
+			}
+		case arg == "compose" && !compose:
+			compose = true
+		case nouns && !compose && slices.Contains(runtimeNouns, arg):
+			nouns = false
+		case arg == "config" && !compose:
+			// docker config is the swarm object, not compose config
+			return ""
+		default:
+			return arg
+		}
+	}
+	return ""
+}
+
+// recordCommand notes a command skipped by the dry run
+func recordCommand(args []string) {
+	recordedCommands = append(recordedCommands, strings.Join(args, " "))
+	logf("INFO", "dry-run: skipped %s", strings.Join(args, " "))
+}
+
+// printDryRunCommands lists the commands the dry run skipped
+func printDryRunCommands() {
+	infoln("\nCommands that would run:")
+	if len(recordedCommands) == 0 {
+		infoln("  none")
+	}
+	for _, command := range recordedCommands {
+		infof("  %s\n", command)
+	}
+}
+
+// exitDryRun ends a dry run, with exitChangesPending when there are changes
+func exitDryRun(changesPending bool) {
+	if changesPending {
+		infoln("\nDry run: changes are pending, nothing was changed.")
+		installLog.close()
+		osExit(exitChangesPending)
+	}
+	infoln("\nDry run: no changes are pending.")
+}
+
+// runReconfigureDryRun shows what re-running the installer on an existing
+// install would change: the pending config migrations and the restarts.
+// Without an install it runs a fresh dry run.
+func runReconfigureDryRun() {
+	dir, ok := dryRunInstall()
+	if !ok {
+		runFreshDryRun()
+		return
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+	openInstallLog(dir)
+	defer installLog.close()
+
+	migrated, changes, err := planMigrations()
+	if err != nil {
+		fatalf("Error planning the config migrations: %v\n", err)
+	}
+	infof("=== Reconfigure Dry Run (%s) ===\n", dir)
+	printConfigChanges(changes, migrationNotes(changes))
+
+	infoln("\nFiles:")
+	if len(migrated) == 0 {
+		infoln("  none")
+	}
+	paths := make([]string, 0, len(migrated))
+	for path := range migrated {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	secrets := installedSecrets()
+	for _, path := range paths {
+		old, _ := os.ReadFile(path)
+		infof("\n%s", renderFileDiff(path, old, migrated[path], secrets))
+	}
+
+	infoln("\nRestarts:")
+	restarts := migrationRestarts(changes)
+	switch {
+	case migrated["docker-compose.yml"] != nil:
+		infoln("  all containers are recreated (compose up -d)")
+	case len(restarts) == 0:
+		infoln("  none")
+	}
+	for _, container := range restarts {
+		infof("  %s\n", container)
+	}
+	exitDryRun(len(changes) > 0)
+}
+
+// dryRunInstall finds the install a dry run reconfigures like
+// findOrSelectInstallDirectory does: a preset install_dir is the only place to
+// look, else the current directory and /opt/pangolin
+func dryRunInstall() (string, bool) {
+	dir, _, ok := presetValue("install_dir")
+	if !ok {
+		return locateExistingInstall()
+	}
+	dir, err := filepath.Abs(expandHome(dir))
+	if err != nil {
+		fatalf("Error resolving path: %v\n", err)
+	}
+	return dir, hasExistingInstall(dir)
+}
+
+// runFreshDryRun asks every question of a fresh install and shows the files
+// it would write, with the secrets redacted, and the actions it would take.
+// Nothing is written and no container is touched, except the copy of the
+// files in --dry-run-dir.
+func runFreshDryRun() {
+	dir, _, ok := presetValue("install_dir")
+	if !ok {
+		dir = defaultInstallDir
+	}
+	installDir, err := filepath.Abs(expandHome(dir))
+	if err != nil {
+		fatalf("Error resolving path: %v\n", err)
+	}
+	infof("=== Install Dry Run (%s) ===\n", installDir)
+	plan := planInstall(installDir)
+	printPlanSummary(plan, true)
+	printAnswerProvenance()
+	if dryRunDir != "" {
+		if err := writeDryRunFiles(dryRunDir, plan.Files); err != nil {
+			fatalf("Error writing the rendered files: %v\n", err)
+		}
+		infof("\nThe rendered files were written to %s.\n", dryRunDir)
+		warnf("They contain the generated secrets and passwords, delete them after the review.\n")
+	}
+	infof("To install, run the installer again without --dry-run, or review and apply a plan made with: installer plan --dir %s\n", installDir)
+	exitDryRun(true)
+}
+
+// writeDryRunFiles writes files below dir, which only its owner may read
+func writeDryRunFiles(dir string, files []renderedFile) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		mode := file.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := os.WriteFile(path, file.Content, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
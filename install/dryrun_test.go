@@ -0,0 +1,318 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fakeCommands puts scripts named like the container runtimes and system
+// tools first on PATH. Each appends its command line to the returned log, so
+// a test can tell which commands really ran.
+func fakeCommands(t *testing.T) (log string) {
+	t.Helper()
+	dir := t.TempDir()
+	log = filepath.Join(dir, "commands.log")
+	script := "#!/bin/sh\necho \"$(basename \"$0\") $*\" >> " + log + "\n"
+	for _, name := range []string{"docker", "podman", "docker-compose", "podman-compose", "systemctl", "getenforce", "sestatus", "uname", "id", "crontab", "chown"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+// ranCommands returns the command lines the fake commands logged
+func ranCommands(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// withDryRun turns on --dry-run with an empty list of recorded commands
+func withDryRun(t *testing.T) {
+	t.Helper()
+	savedDryRun, savedRecorded := dryRun, recordedCommands
+	t.Cleanup(func() { dryRun, recordedCommands = savedDryRun, savedRecorded })
+	dryRun, recordedCommands = true, nil
+}
+
+func TestDryRunRecordsSideEffects(t *testing.T) {
+	tests := []struct {
+		args []string
+		runs bool
+	}{
+		{[]string{"docker", "compose", "up", "-d"}, false},
+		{[]string{"docker", "compose", "-f", "docker-compose.yml", "pull"}, false},
+		{[]string{"docker", "rm", "-f", "pangolin"}, false},
+		{[]string{"docker", "volume", "rm", "pangolin-data"}, false},
+		{[]string{"docker", "network", "rm", "pangolin"}, false},
+		{[]string{"docker", "exec", "crowdsec", "cscli", "hub", "update"}, false},
+		{[]string{"podman", "compose", "down"}, false},
+		{[]string{"podman-compose", "up", "-d"}, false},
+		{[]string{"systemctl", "enable", "--now", "pangolin.service"}, false},
+		{[]string{"systemctl", "daemon-reload"}, false},
+		{[]string{"crontab", "-"}, false},
+		{[]string{"chown", "-R", "1000:1000", "/opt/pangolin"}, false},
+		{[]string{"docker", "ps", "-a"}, true},
+		{[]string{"docker", "container", "inspect", "pangolin"}, true},
+		{[]string{"docker", "compose", "-f", "docker-compose.yml", "ps"}, true},
+		{[]string{"docker", "compose", "config", "-q"}, true},
+		{[]string{"docker", "images"}, true},
+		{[]string{"docker", "version"}, true},
+		{[]string{"docker", "info"}, true},
+		{[]string{"docker-compose", "--version"}, true},
+		{[]string{"podman", "network", "ls"}, true},
+		{[]string{"systemctl", "is-active", "docker"}, true},
+		{[]string{"systemctl", "show", "docker"}, true},
+		{[]string{"getenforce"}, true},
+		{[]string{"uname", "-r"}, true},
+		{[]string{"id", "-u"}, true},
+		// exec, run and cp act inside a container whatever command follows
+		{[]string{"docker", "exec", "crowdsec", "ls", "/etc"}, false},
+		{[]string{"docker", "exec", "crowdsec", "cscli", "config", "show"}, false},
+		{[]string{"docker", "exec", "traefik", "wget", "-qO-", "http://localhost:8080/api/rawdata"}, false},
+		{[]string{"docker", "run", "--rm", "alpine", "ls"}, false},
+		{[]string{"docker", "cp", "pangolin:/app/config/db", "ps"}, false},
+		{[]string{"podman", "container", "exec", "crowdsec", "ps"}, false},
+		{[]string{"docker", "compose", "-f", "docker-compose.yml", "run", "pangolin", "ls"}, false},
+		{[]string{"docker", "compose", "exec", "pangolin", "inspect"}, false},
+		{[]string{"podman-compose", "-f", "docker-compose.yml", "exec", "crowdsec", "ps"}, false},
+		{[]string{"docker", "volume", "create", "ls"}, false},
+		{[]string{"docker", "config", "create", "inspect", "-"}, false},
+		{[]string{"docker", "-H", "unix:///run/docker.sock", "ps"}, true},
+		{[]string{"docker", "container", "ls", "-a"}, true},
+		{[]string{"docker", "image", "inspect", "-f", "{{.Id}}", "fosrl/pangolin"}, true},
+		{[]string{"podman-compose", "-f", "docker-compose.yml", "logs", "pangolin"}, true},
+		{[]string{"podman", "--version"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			log := fakeCommands(t)
+			withDryRun(t)
+			if got := sideEffectFree(tt.args); got != tt.runs {
+				t.Errorf("sideEffectFree = %v, want %v", got, tt.runs)
+			}
+			if err := execLogged(exec.Command(tt.args[0], tt.args[1:]...), false); err != nil {
+				t.Fatal(err)
+			}
+
+			ran := ranCommands(t, log)
+			line := strings.Join(tt.args, " ")
+			if tt.runs {
+				if !slices.Equal(ran, []string{line}) {
+					t.Errorf("ran %q, want %q", ran, line)
+				}
+				if len(recordedCommands) != 0 {
+					t.Errorf("recorded %q", recordedCommands)
+				}
+				return
+			}
+			if len(ran) != 0 {
+				t.Errorf("ran %q under --dry-run", ran)
+			}
+			if len(recordedCommands) != 1 || !strings.HasSuffix(recordedCommands[0], strings.Join(tt.args[1:], " ")) {
+				t.Errorf("recorded %q, want %q", recordedCommands, line)
+			}
+		})
+	}
+}
+
+func TestExecLoggedRunsWithoutDryRun(t *testing.T) {
+	log := fakeCommands(t)
+	savedDryRun := dryRun
+	t.Cleanup(func() { dryRun = savedDryRun })
+	dryRun = false
+	if err := execLogged(exec.Command("docker", "compose", "up", "-d"), false); err != nil {
+		t.Fatal(err)
+	}
+	if ran := ranCommands(t, log); !slices.Equal(ran, []string{"docker compose up -d"}) {
+		t.Errorf("ran %q", ran)
+	}
+}
+
+// existingInstall writes the files of a fresh install into a temporary
+// directory, with CrowdSec merged in like installCrowdsec does, and changes
+// into it
+func existingInstall(t *testing.T, crowdsec bool) string {
+	t.Helper()
+	config := answeredConfig(t, nil)
+	dir := t.TempDir()
+	config.InstallDir = dir
+	t.Chdir(dir)
+	if err := createConfigFiles(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveFile("config/docker-compose.yml", "docker-compose.yml"); err != nil {
+		t.Fatal(err)
+	}
+	if !crowdsec {
+		return dir
+	}
+	config.DoCrowdsecInstall = true
+	if err := createConfigFiles(config); err != nil {
+		t.Fatal(err)
+	}
+	steps := []func() error{
+		func() error {
+			return copyDockerService("config/crowdsec/docker-compose.yml", "docker-compose.yml", "crowdsec")
+		},
+		func() error {
+			return MergeYAML("config/traefik/traefik_config.yml", "config/crowdsec/traefik_config.yml")
+		},
+		func() error {
+			return MergeYAML("config/traefik/dynamic_config.yml", "config/crowdsec/dynamic_config.yml")
+		},
+		func() error { return os.Remove("config/crowdsec/traefik_config.yml") },
+		func() error { return os.Remove("config/crowdsec/dynamic_config.yml") },
+		func() error { return os.Remove("config/crowdsec/docker-compose.yml") },
+		func() error { return CheckAndAddTraefikLogVolume("docker-compose.yml") },
+		func() error { return CheckAndAddCrowdsecDependency("docker-compose.yml") },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// legacyBaseDomain moves the base domain of config/config.yml back below app,
+// where early releases had it
+func legacyBaseDomain(t *testing.T) {
+	t.Helper()
+	content, err := os.ReadFile("config/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		t.Fatal(err)
+	}
+	doc := root.Content[0]
+	domains := yamlDeleteKey(doc, "domains")
+	yamlSetKey(yamlMapValue(doc, "app"), "base_domain", yamlMapValue(domains.Content[1], "base_domain"))
+	out, err := yaml.Marshal(&root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("config/config.yml", out, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// treeState maps every path below dir to its mode and content
+func treeState(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	state := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		content := ""
+		if !d.IsDir() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			content = string(data)
+		}
+		state[path] = info.Mode().String() + "\n" + content
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+// TestDryRunPaths runs the upgrade, uninstall, reconfigure and
+// --remove-crowdsec dry runs on an existing install with fake container
+// runtimes. Only read-only commands may run, and the install must be left as
+// it was.
+func TestDryRunPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		crowdsec bool
+		run      func(dir string)
+		exit     int
+	}{
+		{"upgrade", false, func(dir string) {
+			runUpgrade([]string{"--dir", dir, "--dry-run", "--offline", "--version", "v1.12.1"})
+		}, exitChangesPending},
+		{"uninstall", false, func(dir string) {
+			runUninstall([]string{"--dir", dir, "--dry-run", "--confirm", installedDashboardDomain()})
+		}, exitChangesPending},
+		{"reconfigure", false, func(string) { runReconfigureDryRun() }, exitChangesPending},
+		{"remove-crowdsec", true, func(string) { runRemoveCrowdsec() }, exitChangesPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := existingInstall(t, tt.crowdsec)
+			withAnswers(t, nil)
+			acceptDefaults = true
+			savedLog, savedVersion, savedPhrases := logFilePath, upgradeVersion, confirmPhrases
+			t.Cleanup(func() { logFilePath, upgradeVersion, confirmPhrases = savedLog, savedVersion, savedPhrases })
+			logFilePath = filepath.Join(t.TempDir(), installLogName)
+			log := fakeCommands(t)
+			withDryRun(t)
+			if tt.name == "reconfigure" {
+				// a fresh install has no config migration pending
+				legacyBaseDomain(t)
+			}
+			before := treeState(t, dir)
+
+			if code := catchExit(t, func() { tt.run(dir) }); code != tt.exit {
+				t.Fatalf("exit code %d, want %d", code, tt.exit)
+			}
+
+			for _, line := range ranCommands(t, log) {
+				if !sideEffectFree(strings.Fields(line)) {
+					t.Errorf("ran %q under --dry-run", line)
+				}
+			}
+			if len(recordedCommands) == 0 && tt.name == "uninstall" {
+				t.Error("the uninstall recorded no commands")
+			}
+			after := treeState(t, dir)
+			for path, state := range after {
+				if before[path] != state {
+					t.Errorf("%s was written", path)
+				}
+			}
+			for path := range before {
+				if _, ok := after[path]; !ok {
+					t.Errorf("%s was removed", path)
+				}
+			}
+		})
+	}
+}
+
+// fakeRunningStack is fakeCommands with a running container, so the docker
+// runtime is detected
+func fakeRunningStack(t *testing.T) (log string) {
+	t.Helper()
+	log = fakeCommands(t)
+	script := "#!/bin/sh\necho \"$(basename \"$0\") $*\" >> " + log + "\nif [ \"$*\" = \"ps -q\" ]; then echo 0123456789ab; fi\n"
+	if err := os.WriteFile(filepath.Join(filepath.Dir(log), "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
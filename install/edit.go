@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// editorCommand returns the editor for hand edits: $VISUAL, $EDITOR or the
+// first of vi, vim and nano that is installed
+func editorCommand() ([]string, error) {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields, nil
+		}
+	}
+	for _, editor := range []string{"vi", "vim", "nano"} {
+		if path, err := exec.LookPath(editor); err == nil {
+			return []string{path}, nil
+		}
+	}
+	return nil, fmt.Errorf("no editor found, set $EDITOR")
+}
+
+// offerFileEdits lets the user tweak rendered files in an editor before they
+// are written. It returns the paths that were edited. Accessible and
+// non-interactive mode skip the offer.
+func offerFileEdits(files []renderedFile) []string {
+	if nonInteractive || isAccessibleMode() {
+		return nil
+	}
+	if !readBool("edit_files", tr("prompt.edit_files"), false) {
+		return nil
+	}
+
+	const done = "done"
+	options := []string{done}
+	for _, file := range files {
+		options = append(options, file.Path)
+	}
+	var edited []string
+	for {
+		choice := readChoice("edit_file", tr("prompt.edit_file"), options, done)
+		if choice == done {
+			return edited
+		}
+		i := slices.IndexFunc(files, func(file renderedFile) bool { return file.Path == choice })
+		if editRenderedFile(&files[i]) && !slices.Contains(edited, choice) {
+			edited = append(edited, choice)
+		}
+	}
+}
+
+// editRenderedFile opens file in the editor until the result validates or the
+// user discards the edit. It reports whether the content changed.
+func editRenderedFile(file *renderedFile) bool {
+	editor, err := editorCommand()
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return false
+	}
+	tmp, err := os.CreateTemp("", "pangolin-*-"+filepath.Base(file.Path))
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return false
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	content := file.Content
+	for {
+		if err := os.WriteFile(tmp.Name(), content, 0600); err != nil {
+			errorf("Error: %v\n", err)
+			return false
+		}
+		cmd := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := execLogged(cmd, false); err != nil {
+			errorf("Error: the editor exited: %v\n", err)
+			return false
+		}
+		if content, err = os.ReadFile(tmp.Name()); err != nil {
+			errorf("Error: %v\n", err)
+			return false
+		}
+		if string(content) == string(file.Content) {
+			infof("%s is unchanged.\n", file.Path)
+			return false
+		}
+		err := validateRenderedFile(file.Path, content)
+		if err == nil {
+			file.Content = content
+			infof("%s will be written with your changes.\n", file.Path)
+			return true
+		}
+		errorf("Error: %s is not valid: %v\n", file.Path, err)
+		if !readBool("edit_again", tr("prompt.edit_again"), true) {
+			return false
+		}
+	}
+}
+
+// validateRenderedFile checks an edited config against its schema. Compose
+// files are also checked with compose config when a runtime is available.
+func validateRenderedFile(path string, content []byte) error {
+	if ext := filepath.Ext(path); ext != ".yml" && ext != ".yaml" {
+		return nil
+	}
+	if err := checkRenderedFile(path, content); err != nil {
+		err.content = content
+		return err
+	}
+	if filepath.Base(path) != "docker-compose.yml" {
+		return nil
+	}
+	containerType := detectContainerType()
+	if containerType == Undefined {
+		return nil
+	}
+	tmp, err := os.CreateTemp("", "pangolin-compose-*.yml")
+	if err != nil {
+		return nil
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return nil
+	}
+	tmp.Close()
+	cmd, err := composeCommand(context.Background(), containerType, "-f", tmp.Name(), "config", "-q")
+	if err != nil {
+		return nil
+	}
+	if out, err := outputCmd(cmd); err != nil {
+		return fmt.Errorf("compose config rejected it: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// recordEditedFiles notes the hash of hand-edited files in the state file,
+// so later runs can tell the edits apart from the generated content
+func recordEditedFiles(files []renderedFile, edited []string) {
+	if len(edited) == 0 {
+		return
+	}
+	state, err := loadInstallState(".")
+	if err != nil {
+		logf("WARN", "could not read %s: %v", installStateFile, err)
+		return
+	}
+	if state.Edited == nil {
+		state.Edited = fileManifest{}
+	}
+	for _, file := range files {
+		if slices.Contains(edited, file.Path) {
+			state.Edited[installedPath(file.Path)] = hashBytes(file.Content)
+		}
+	}
+	if err := state.save("."); err != nil {
+		logf("WARN", "could not write %s: %v", installStateFile, err)
+		return
+	}
+	logf("INFO", "recorded hand-edited files: %s", strings.Join(edited, ", "))
+}
+
+// installedPath maps the path of a rendered file to where it ends up, the
+// compose file moves to the install root
+func installedPath(path string) string {
+	if path == "config/docker-compose.yml" {
+		return "docker-compose.yml"
+	}
+	return path
+}
+
+// warnEditedFiles points out hand-edited files among the files an upgrade
+// rewrites
+func warnEditedFiles(files []renderedFile) {
+	state, err := loadInstallState(".")
+	if err != nil || len(state.Edited) == 0 {
+		return
+	}
+	changed, _ := state.Edited.drift()
+	for _, file := range files {
+		if _, ok := state.Edited[file.Path]; !ok {
+			continue
+		}
+		note := "it was edited by hand before the first start"
+		if slices.Contains(changed, file.Path) {
+			note += " and has changed since"
+		}
+		warnf("Warning: the upgrade rewrites %s, %s. Check the diff above keeps your edits.\n", file.Path, note)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP security modes
+const (
+	smtpSTARTTLS = "starttls"
+	smtpTLS      = "tls"
+	smtpNone     = "none"
+)
+
+const smtpTestTimeout = 15 * time.Second
+
+// smtpHostSuggestions are the relays of common mail providers
+var smtpHostSuggestions = []string{
+	"smtp.gmail.com",
+	"smtp.office365.com",
+	"smtp.sendgrid.net",
+	"smtp.mailgun.org",
+	"smtp.postmarkapp.com",
+	"smtp-relay.brevo.com",
+	"smtp.fastmail.com",
+	"smtp.zoho.com",
+	"email-smtp.us-east-1.amazonaws.com",
+	"email-smtp.eu-west-1.amazonaws.com",
+}
+
+// smtpProviderOther is the email provider whose SMTP settings are entered
+const smtpProviderOther = "other"
+
+// smtpProvider is a mail provider whose relay settings are known
+type smtpProvider struct {
+	Option
+	Host     string
+	Port     int
+	Security string
+}
+
+var smtpProviders = []smtpProvider{
+	{Option{"gmail", "Gmail", "needs an app password"}, "smtp.gmail.com", 587, smtpSTARTTLS},
+	{Option{"office365", "Microsoft 365", "needs SMTP AUTH enabled for the mailbox"}, "smtp.office365.com", 587, smtpSTARTTLS},
+	{Option{"sendgrid", "SendGrid", "the user is apikey"}, "smtp.sendgrid.net", 587, smtpSTARTTLS},
+	{Option{"mailgun", "Mailgun", ""}, "smtp.mailgun.org", 587, smtpSTARTTLS},
+	{Option{"postmark", "Postmark", "the user and password are the server token"}, "smtp.postmarkapp.com", 587, smtpSTARTTLS},
+	{Option{"brevo", "Brevo", ""}, "smtp-relay.brevo.com", 587, smtpSTARTTLS},
+	{Option{"ses-us-east-1", "Amazon SES (us-east-1)", "SMTP credentials, not access keys"}, "email-smtp.us-east-1.amazonaws.com", 587, smtpSTARTTLS},
+	{Option{"ses-eu-west-1", "Amazon SES (eu-west-1)", "SMTP credentials, not access keys"}, "email-smtp.eu-west-1.amazonaws.com", 587, smtpSTARTTLS},
+	{Option{"fastmail", "Fastmail", "needs an app password"}, "smtp.fastmail.com", 465, smtpTLS},
+	{Option{"zoho", "Zoho Mail", ""}, "smtp.zoho.com", 465, smtpTLS},
+}
+
+// smtpProviderFor is the provider whose relay is host, other when none is
+func smtpProviderFor(host string) string {
+	for _, provider := range smtpProviders {
+		if provider.Host == host {
+			return provider.Value
+		}
+	}
+	return smtpProviderOther
+}
+
+// EmailSMTPImplicitTLS reports whether the SMTP connection starts with TLS,
+// STARTTLS is negotiated by Pangolin on its own
+func (c Config) EmailSMTPImplicitTLS() bool {
+	return c.EmailSMTPSecurity == smtpTLS
+}
+
+// collectEmailSettings asks for the SMTP settings and offers to verify them by
+// sending a real message. For a known provider only the credentials are asked.
+func collectEmailSettings(config *Config) {
+	config.EmailSMTPPort = 587
+	options := make([]Option, 0, len(smtpProviders)+1)
+	for _, provider := range smtpProviders {
+		options = append(options, provider.Option)
+	}
+	options = append(options, Option{Value: smtpProviderOther, Label: "Other", Description: "enter the SMTP host, port and security"})
+	for {
+		known := false
+		picked := readSelect("email_provider", tr("prompt.email_provider"), options, smtpProviderFor(config.EmailSMTPHost))
+		for _, provider := range smtpProviders {
+			if provider.Value == picked {
+				config.EmailSMTPHost, config.EmailSMTPPort, config.EmailSMTPSecurity = provider.Host, provider.Port, provider.Security
+				known = true
+			}
+		}
+		smtp := newFormGroup(tr("form.smtp"))
+		var host, security *string
+		var port *int
+		if !known {
+			host = smtp.text("smtp_host", tr("prompt.smtp_host"), config.EmailSMTPHost, nil, withSuggestions(smtpHostSuggestions))
+			port = smtp.intInRange("smtp_port", tr("prompt.smtp_port"), config.EmailSMTPPort, 1, 65535)
+			security = smtp.choice("smtp_security", tr("prompt.smtp_security"), plainOptions([]string{smtpSTARTTLS, smtpTLS, smtpNone}), func() string {
+				if *port == 465 {
+					return smtpTLS
+				}
+				return smtpSTARTTLS
+			})
+		}
+		user := smtp.text("smtp_user", tr("prompt.smtp_user"), config.EmailSMTPUser, nil)
+		pass := smtp.password("smtp_pass", tr("prompt.smtp_pass"))
+		noReply := smtp.text("no_reply_email", tr("prompt.no_reply_email"), config.EmailNoReply, validateEmail)
+		smtp.run()
+		if !known {
+			config.EmailSMTPHost, config.EmailSMTPPort, config.EmailSMTPSecurity = *host, *port, *security
+		}
+		config.EmailSMTPUser, config.EmailSMTPPass, config.EmailNoReply = *user, *pass, *noReply
+
+		if !network.allow("SMTP test email") {
+			return
+		}
+		if !readBool("smtp_test", tr("prompt.smtp_test"), true) {
+			return
+		}
+		to := readEmail("smtp_test_recipient", tr("prompt.smtp_test_recipient"), config.AdminEmail)
+
+		for {
+			err := runStep(context.Background(), "Sending a test email to "+to, func(ctx context.Context) error {
+				ctx, cancel := context.WithTimeout(ctx, smtpTestTimeout)
+				defer cancel()
+				return sendTestEmail(ctx, *config, to)
+			})
+			if err == nil {
+				infof("Test email sent to %s. Check the inbox (and the spam folder) to confirm delivery.\n", to)
+				return
+			}
+
+			errorf("Sending the test email failed: %v\n", err)
+			switch readChoice("smtp_test_failed", tr("prompt.smtp_test_failed"), []string{"retry", "edit", "continue"}, "edit") {
+			case "retry":
+				continue
+			case "continue":
+				warnf("Warning: the SMTP settings were not verified, invites and password resets may fail to send.\n")
+				return
+			}
+			break
+		}
+	}
+}
+
+// sendTestEmail connects with the configured security mode and sends a short
+// message, returning the SMTP error as reported by the server
+func sendTestEmail(ctx context.Context, config Config, to string) error {
+	if config.EmailNoReply == "" {
+		return errors.New("a no-reply address is required as the sender")
+	}
+	address := net.JoinHostPort(config.EmailSMTPHost, strconv.Itoa(config.EmailSMTPPort))
+	tlsConfig := &tls.Config{ServerName: config.EmailSMTPHost}
+
+	var conn net.Conn
+	var err error
+	if config.EmailSMTPSecurity == smtpTLS {
+		conn, err = dialTLS(ctx, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, config.EmailSMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Hello(smtpHelloName(config.DashboardDomain)); err != nil {
+		return err
+	}
+	if config.EmailSMTPSecurity == smtpSTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS, choose tls or none", address)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if config.EmailSMTPUser != "" {
+		// Many servers only offer AUTH once the connection is encrypted
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s does not offer authentication on this connection, try starttls or tls", address)
+		}
+		if err := client.Auth(smtp.PlainAuth("", config.EmailSMTPUser, config.EmailSMTPPass, config.EmailSMTPHost)); err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(config.EmailNoReply); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	message := strings.Join([]string{
+		"From: " + config.EmailNoReply,
+		"To: " + to,
+		"Subject: Pangolin installer test email",
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"",
+		"This message was sent by the Pangolin installer to verify the SMTP settings for " + config.DashboardDomain + ".",
+		"",
+	}, "\r\n")
+	if _, err := w.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func smtpHelloName(domain string) string {
+	if domain == "" {
+		return "localhost"
+	}
+	return domain
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// collectPublicEndpoint asks how Newt sites reach this server from the
+// internet. Behind NAT that is the router's address, or a hostname pointing at
+// it, with the WireGuard port forwarded to this server.
+func collectPublicEndpoint(config *Config) {
+	if answerMissing("base_domain") {
+		return
+	}
+	infoln("\n=== Public Endpoint ===")
+
+	var publicIP net.IP
+	if network.allow("public IP detection") {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsCheckTimeout)
+		publicIP = detectPublicIP(ctx)
+		cancel()
+	}
+	local := routeSource("udp4", "192.0.2.1:53")
+	behindNAT := !platform.PublicAddress || (local != nil && local.IsPrivate())
+	logf("INFO", "public endpoint: public=%v local=%v nat=%v", publicIP, local, behindNAT)
+	switch {
+	case publicIP != nil && behindNAT:
+		infof("This server is behind NAT: its address is %s, the internet sees %s.\n", local, publicIP)
+	case publicIP != nil:
+		infof("The public IP of this server is %s.\n", publicIP)
+	case behindNAT && local != nil:
+		infof("This server is behind NAT, its address is %s.\n", local)
+	}
+	if behindNAT {
+		infof("Forward UDP port %d on the router to this server and enter the router's public address, or a hostname pointing at it.\n", config.WireGuardPort)
+	}
+
+	defaultEndpoint := net.JoinHostPort(config.DashboardDomain, strconv.Itoa(config.WireGuardPort))
+	value := readValidated("public_endpoint", tr("prompt.public_endpoint"), defaultEndpoint, func(s string) error {
+		_, port, err := parseEndpoint(s)
+		if err == nil && port != 0 && port != config.WireGuardPort {
+			err = validateUDPPort(port)
+		}
+		return err
+	})
+	host, port, _ := parseEndpoint(value)
+	if port != 0 && port != config.WireGuardPort {
+		// Pangolin hands sites the port Gerbil listens on, so the router must
+		// forward the same port number
+		config.WireGuardPort = port
+		infof("Gerbil listens on UDP port %d, forward that same port on the router.\n", port)
+	}
+	if host != config.DashboardDomain {
+		config.GerbilEndpoint = host
+	}
+	if publicIP != nil {
+		for _, finding := range checkPublicIP(context.Background(), net.DefaultResolver, publicIP, config.BaseDomain, host) {
+			warnf("Warning: %s\n", finding)
+		}
+	}
+}
+
+// parseEndpoint splits an endpoint into a hostname or IP and a port, which is
+// 0 when it was left out. IPv6 addresses with a port need brackets.
+func parseEndpoint(endpoint string) (string, int, error) {
+	host, portText := endpoint, ""
+	if strings.HasPrefix(endpoint, "[") || strings.Count(endpoint, ":") == 1 {
+		var err error
+		host, portText, err = net.SplitHostPort(endpoint)
+		if err != nil {
+			return "", 0, errors.New("enter a hostname or IP address, optionally followed by :port, e.g. vpn.example.com:51820 or [2001:db8::1]:51820")
+		}
+	}
+	port := 0
+	if portText != "" {
+		n, err := strconv.Atoi(portText)
+		if err != nil || n < 1 || n > 65535 {
+			return "", 0, fmt.Errorf("%q is not a port, expected 1 to 65535", portText)
+		}
+		port = n
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+			return "", 0, fmt.Errorf("%s is not a public address, sites on the internet cannot reach it", host)
+		}
+		return ip.String(), port, nil
+	}
+	domain, err := validateDomain(host)
+	if err != nil {
+		return "", 0, err
+	}
+	return domain, port, nil
+}
+
+// checkPublicIP compares the detected public IP with the A records of the
+// base domain and of the endpoint, or with the endpoint address itself
+func checkPublicIP(ctx context.Context, resolver ipResolver, publicIP net.IP, baseDomain, endpoint string) []string {
+	if ip := net.ParseIP(endpoint); ip != nil {
+		if ip.To4() != nil && !ip.Equal(publicIP) {
+			return []string{fmt.Sprintf("The endpoint %s is not the detected public IP %s of this server.", endpoint, publicIP)}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+	var findings []string
+	hosts := []string{baseDomain}
+	if endpoint != baseDomain {
+		hosts = append(hosts, endpoint)
+	}
+	for _, host := range hosts {
+		records, err := lookupRecords(ctx, resolver, host, nil)
+		if err != nil || len(records.A) == 0 {
+			continue
+		}
+		if slices.ContainsFunc(records.A, publicIP.Equal) {
+			continue
+		}
+		addresses := make([]string, len(records.A))
+		for i, ip := range records.A {
+			addresses[i] = ip.String()
+		}
+		finding := fmt.Sprintf("%s resolves to %s, but the public IP of this server is %s.", host, strings.Join(addresses, ", "), publicIP)
+		if host == endpoint {
+			finding += " Sites cannot connect until the record points at this server or its router."
+		}
+		findings = append(findings, finding)
+	}
+	return findings
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// answerEnvPrefix namespaces the environment variables answering prompts, e.g.
+// PANGOLIN_INSTALL_DASHBOARD_DOMAIN for dashboard_domain
+const answerEnvPrefix = "PANGOLIN_INSTALL_"
+
+func answerEnvName(key string) string {
+	return answerEnvPrefix + strings.ToUpper(key)
+}
+
+// envAnswer returns the value of the environment variable of the prompt with
+// key. An empty variable counts as unset, CI systems often define every
+// variable of a pipeline.
+func envAnswer(key string) (string, bool) {
+	value := os.Getenv(answerEnvName(key))
+	return value, value != ""
+}
+
+// presetValue returns the answer given before the prompt is shown and where
+// it came from. A flag wins over the environment, the environment over the
+// answers file.
+func presetValue(key string) (string, answerSource, bool) {
+	value, found := promptAnswers[key]
+	if found && !fileAnswers[key] {
+		return value, sourceFlag, true
+	}
+	if value, ok := envAnswer(key); ok {
+		return value, sourceEnv, true
+	}
+	if found {
+		return value, sourceFile, true
+	}
+	return "", "", false
+}
+
+// presetsActive reports whether any prompt is answered before it is shown, by
+// a flag, the environment or the answers file
+func presetsActive() bool {
+	if len(promptAnswers) > 0 || answersFile != "" {
+		return true
+	}
+	return slices.ContainsFunc(answerKeys(), func(key string) bool {
+		_, ok := envAnswer(key)
+		return ok
+	})
+}
+
+// checkAnswerEnvironment warns about PANGOLIN_INSTALL_ variables that answer
+// no prompt, a typo would otherwise be asked for or take the default silently
+func checkAnswerEnvironment() {
+	known := answerKeys()
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		key, ok := strings.CutPrefix(name, answerEnvPrefix)
+		if !ok || slices.Contains(known, strings.ToLower(key)) {
+			continue
+		}
+		warnf("Warning: %s matches no prompt and is ignored\n", name)
+	}
+}
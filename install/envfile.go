@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envFile holds the secrets docker-compose.yml references with ${VAR}, and
+// those of config/config.yml, which compose passes on to Pangolin. Compose
+// reads it from the project directory, so it sits next to the compose file
+// and is kept out of version control by the .gitignore written with it.
+const (
+	envFile       = ".env"
+	gitignoreFile = ".gitignore"
+)
+
+const envFileHeader = "# Secrets referenced from docker-compose.yml and config/config.yml. Keep this file out of version control.\n"
+
+// envVar is a variable of the .env file
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// Names of the secrets that are not DNS provider credentials
+const (
+	envPostgresPassword = "POSTGRES_PASSWORD"
+	envRedisPassword    = "REDIS_PASSWORD"
+)
+
+// Names of the secrets of config.yml. Pangolin reads the first three from its
+// environment before config.yml, the OIDC client secret is only read back by
+// the installer.
+const (
+	envServerSecret       = "SERVER_SECRET"
+	envSMTPPass           = "EMAIL_SMTP_PASS"
+	envPostgresConnection = "POSTGRES_CONNECTION_STRING"
+	envOIDCClientSecret   = "OIDC_CLIENT_SECRET"
+)
+
+// configSecrets are the secrets of config.yml. They are in .env with
+// DockerSecrets too, Pangolin has no _FILE variants for them.
+func (c Config) configSecrets() []envVar {
+	vars := []envVar{{envServerSecret, c.Secret}}
+	if c.EnableEmail {
+		vars = append(vars, envVar{envSMTPPass, c.EmailSMTPPass})
+	}
+	if c.IsPostgreSQL {
+		vars = append(vars, envVar{envPostgresConnection, c.PostgreSQLConnectionString()})
+	}
+	if c.OIDC != nil {
+		vars = append(vars, envVar{envOIDCClientSecret, c.OIDC.ClientSecret})
+	}
+	return vars
+}
+
+// PangolinEnvironment is the environment of the pangolin service: the
+// secrets of config.yml it reads, referring to .env
+func (c Config) PangolinEnvironment() []envVar {
+	var env []envVar
+	for _, v := range c.configSecrets() {
+		if v.Name != envOIDCClientSecret {
+			env = append(env, envVar{v.Name, "${" + v.Name + "}"})
+		}
+	}
+	return env
+}
+
+// EnvSecrets are the secrets of config.yml and of the compose file, in the
+// order they are written to .env. With DockerSecrets those of the compose
+// file are secret files instead.
+func (c Config) EnvSecrets() []envVar {
+	vars := c.configSecrets()
+	if c.DockerSecrets {
+		return vars
+	}
+	for _, secret := range c.composeSecrets() {
+		vars = append(vars, envVar{secret.Env, secret.Value})
+	}
+	return vars
+}
+
+// DNSEnvironment is the environment of the traefik service for the DNS-01
+// challenge. Secret credentials refer to .env, or with DockerSecrets to their
+// secret file through the _FILE variant lego reads.
+func (c Config) DNSEnvironment() []envVar {
+	var env []envVar
+	for _, credential := range dnsProviderCredentials(c.DNSProvider) {
+		value, ok := c.DNSCredentials[credential.Env]
+		if !ok {
+			continue
+		}
+		switch {
+		case credential.Secret && c.DockerSecrets:
+			secret := secretFile{Name: strings.ToLower(credential.Env)}
+			env = append(env, envVar{credential.Env + "_FILE", secret.Target()})
+			continue
+		case credential.Secret:
+			value = "${" + credential.Env + "}"
+		}
+		env = append(env, envVar{credential.Env, value})
+	}
+	return env
+}
+
+func dnsProviderCredentials(name string) []dnsCredential {
+	provider, ok := findDNSProvider(name)
+	if !ok {
+		return nil
+	}
+	return provider.Credentials
+}
+
+// renderEnvFiles returns .env with the secrets of config, or the secret files
+// with DockerSecrets, and the .gitignore that excludes them
+func renderEnvFiles(config Config) []renderedFile {
+	files := []renderedFile{
+		{Path: envFile, Content: formatEnvFile(config.EnvSecrets()), Mode: 0600},
+		{Path: gitignoreFile, Content: []byte(envFile + "\n" + secretsDir + "/\n")},
+	}
+	return append(files, renderSecretFiles(config)...)
+}
+
+func formatEnvFile(vars []envVar) []byte {
+	var out bytes.Buffer
+	out.WriteString(envFileHeader)
+	for _, v := range vars {
+		fmt.Fprintf(&out, "%s=%s\n", v.Name, quoteEnvValue(v.Value))
+	}
+	return out.Bytes()
+}
+
+// quoteEnvValue quotes a value so compose reads it back unchanged. Single
+// quotes are literal, values containing one are double quoted with $, " and
+// \ escaped.
+func quoteEnvValue(value string) string {
+	if !strings.Contains(value, "'") {
+		return "'" + value + "'"
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// readEnvFile parses the .env file of the installation in the current
+// directory, it is empty when there is none
+func readEnvFile() (map[string]string, error) {
+	vars := map[string]string{}
+	data, err := os.ReadFile(envFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return vars, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			continue
+		}
+		vars[strings.TrimSpace(name)] = unquoteEnvValue(strings.TrimSpace(value))
+	}
+	return vars, scanner.Err()
+}
+
+func unquoteEnvValue(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1]
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		replacer := strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\$`, `$`, `\n`, "\n")
+		return replacer.Replace(value[1 : len(value)-1])
+	}
+	return value
+}
+
+// envReference matches a compose value that is only a ${VAR} reference
+var envReference = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// resolveEnvReference returns the value a ${VAR} reference stands for in
+// vars, other values are returned unchanged
+func resolveEnvReference(value string, vars map[string]string) string {
+	if m := envReference.FindStringSubmatch(value); m != nil {
+		return vars[m[1]]
+	}
+	return value
+}
+
+// requirepassArg matches the Redis password on the redis-server command line
+var requirepassArg = regexp.MustCompile(`(--requirepass\s+)(\S+)`)
+
+// inlineSecrets finds the secrets an older installer wrote into the compose
+// document instead of .env. With move set they are replaced by ${VAR}
+// references, the returned variables hold the values for .env.
+func inlineSecrets(root *yaml.Node, move bool) []envVar {
+	services := yamlMapValue(root, "services")
+	var found []envVar
+	add := func(name, value string) {
+		if !slices.ContainsFunc(found, func(v envVar) bool { return v.Name == name }) {
+			found = append(found, envVar{name, value})
+		}
+	}
+	reference := func(name string) string { return "${" + name + "}" }
+
+	if env := yamlMapValue(yamlMapValue(services, "postgres"), "environment"); env != nil {
+		if value := yamlMapValue(env, envPostgresPassword); value != nil && !envReference.MatchString(value.Value) {
+			add(envPostgresPassword, value.Value)
+			if move {
+				value.Value, value.Style = reference(envPostgresPassword), 0
+			}
+		}
+	}
+
+	redis := yamlMapValue(services, "redis")
+	if command := yamlMapValue(redis, "command"); command != nil && command.Kind == yaml.ScalarNode {
+		if m := requirepassArg.FindStringSubmatch(command.Value); m != nil && !envReference.MatchString(m[2]) {
+			add(envRedisPassword, m[2])
+			if move {
+				command.Value = requirepassArg.ReplaceAllLiteralString(command.Value, m[1]+reference(envRedisPassword))
+			}
+		}
+	}
+	if test := yamlMapValue(yamlMapValue(redis, "healthcheck"), "test"); test != nil && test.Kind == yaml.SequenceNode {
+		for i := 0; i+1 < len(test.Content); i++ {
+			if value := test.Content[i+1]; test.Content[i].Value == "-a" && !envReference.MatchString(value.Value) {
+				add(envRedisPassword, value.Value)
+				if move {
+					value.Value = reference(envRedisPassword)
+				}
+			}
+		}
+	}
+
+	if env := yamlMapValue(yamlMapValue(services, "traefik"), "environment"); env != nil {
+		for _, provider := range dnsProviders {
+			for _, credential := range provider.Credentials {
+				value := yamlMapValue(env, credential.Env)
+				if value == nil || !credential.Secret || envReference.MatchString(value.Value) {
+					continue
+				}
+				add(credential.Env, value.Value)
+				if move {
+					value.Value, value.Style = reference(credential.Env), yaml.DoubleQuotedStyle
+				}
+			}
+		}
+	}
+	return found
+}
+
+// inlineConfigSecrets finds the secrets an older installer wrote into the
+// config.yml document. With move set the server secret is removed, Pangolin
+// falls back to SERVER_SECRET, and the others are replaced by ${VAR}
+// references.
+func inlineConfigSecrets(root *yaml.Node, move bool) []envVar {
+	var found []envVar
+	inline := func(value *yaml.Node) bool {
+		return value != nil && value.Kind == yaml.ScalarNode && value.Value != "" && !envReference.MatchString(value.Value)
+	}
+	add := func(parent *yaml.Node, key, name string) {
+		value := yamlMapValue(parent, key)
+		if !inline(value) {
+			return
+		}
+		found = append(found, envVar{name, value.Value})
+		switch {
+		case !move:
+		case name == envServerSecret:
+			yamlDeleteKey(parent, key)
+		default:
+			value.Value, value.Style = "${"+name+"}", yaml.DoubleQuotedStyle
+		}
+	}
+	add(yamlMapValue(root, "server"), "secret", envServerSecret)
+	add(yamlMapValue(root, "email"), "smtp_pass", envSMTPPass)
+	add(yamlMapValue(root, "postgres"), "connection_string", envPostgresConnection)
+	// The installer writes one identity provider
+	if providers := yamlMapValue(root, "identity_providers"); providers != nil && providers.Kind == yaml.SequenceNode && len(providers.Content) > 0 {
+		add(providers.Content[0], "client_secret", envOIDCClientSecret)
+	}
+	return found
+}
+
+// passPangolinSecrets adds the ${VAR} references of the config.yml secrets
+// Pangolin reads from its environment to the pangolin service
+func passPangolinSecrets(root *yaml.Node, vars []envVar) bool {
+	vars = slices.DeleteFunc(slices.Clone(vars), func(v envVar) bool { return v.Name == envOIDCClientSecret })
+	pangolin := yamlMapValue(yamlMapValue(root, "services"), "pangolin")
+	if pangolin == nil || len(vars) == 0 {
+		return false
+	}
+	env := yamlMapValue(pangolin, "environment")
+	if env == nil {
+		env = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		yamlSetKey(pangolin, "environment", env)
+	}
+	changed := false
+	for _, v := range vars {
+		reference := "${" + v.Name + "}"
+		switch env.Kind {
+		case yaml.SequenceNode:
+			entry := v.Name + "=" + reference
+			if !slices.ContainsFunc(env.Content, func(n *yaml.Node) bool { return n.Value == entry }) {
+				env.Content = append(env.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: entry})
+				changed = true
+			}
+		case yaml.MappingNode:
+			if value := yamlMapValue(env, v.Name); value == nil || value.Value != reference {
+				yamlSetKey(env, v.Name, &yaml.Node{Kind: yaml.ScalarNode, Value: reference, Style: yaml.DoubleQuotedStyle})
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// installedInlineSecrets lists the names of the secrets in the installed
+// docker-compose.yml and config/config.yml that are not in .env yet
+func installedInlineSecrets() []string {
+	var names []string
+	if doc, err := readComposeDocument(); err == nil {
+		for _, v := range inlineSecrets(doc.Content[0], false) {
+			names = append(names, v.Name)
+		}
+	}
+	if doc, err := readYAMLDocument("config/config.yml"); err == nil {
+		for _, v := range inlineConfigSecrets(doc.Content[0], false) {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
+func readComposeDocument() (*yaml.Node, error) {
+	return readYAMLDocument("docker-compose.yml")
+}
+
+func readYAMLDocument(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return &doc, nil
+}
+
+// migrateInlineSecrets moves the secrets of docker-compose.yml and
+// config/config.yml into .env, keeping any variables .env already has, and
+// makes sure .gitignore excludes it. The pangolin service gets the config.yml
+// secrets it reads from its environment.
+func migrateInlineSecrets() error {
+	data, err := os.ReadFile("docker-compose.yml")
+	if err != nil {
+		return err
+	}
+	doc, err := readComposeDocument()
+	if err != nil {
+		return err
+	}
+	configData, err := os.ReadFile("config/config.yml")
+	if err != nil {
+		return err
+	}
+	configDoc, err := readYAMLDocument("config/config.yml")
+	if err != nil {
+		return err
+	}
+	fromCompose := inlineSecrets(doc.Content[0], true)
+	fromConfig := inlineConfigSecrets(configDoc.Content[0], true)
+	moved := append(fromCompose, fromConfig...)
+	if len(moved) == 0 {
+		return nil
+	}
+
+	existing, err := readEnvFile()
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", envFile, err)
+	}
+	var vars []envVar
+	for name, value := range existing {
+		if !slices.ContainsFunc(moved, func(v envVar) bool { return v.Name == name }) {
+			vars = append(vars, envVar{name, value})
+		}
+	}
+	slices.SortFunc(vars, func(a, b envVar) int { return strings.Compare(a.Name, b.Name) })
+	vars = append(vars, moved...)
+	if err := os.WriteFile(envFile, formatEnvFile(vars), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(envFile, 0600); err != nil {
+		return err
+	}
+	if err := ensureGitignored(envFile); err != nil {
+		return err
+	}
+
+	if len(fromConfig) > 0 {
+		content, err := MarshalYAMLWithIndent(configDoc, detectIndent(configData))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile("config/config.yml", content, 0644); err != nil {
+			return err
+		}
+	}
+	if passed := passPangolinSecrets(doc.Content[0], fromConfig); len(fromCompose) == 0 && !passed {
+		return nil
+	}
+	compose, err := MarshalYAMLWithIndent(doc, detectIndent(data))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("docker-compose.yml", compose, 0644)
+}
+
+// ensureGitignored adds name to the .gitignore of the installation unless it
+// is already listed
+func ensureGitignored(name string) error {
+	data, err := os.ReadFile(gitignoreFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == name || line == "/"+name {
+			return nil
+		}
+	}
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	return os.WriteFile(gitignoreFile, append(data, name+"\n"...), 0644)
+}
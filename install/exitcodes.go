@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Exit codes of a failed run, so provisioning such as cloud-init can tell a
+// failure worth retrying from one that needs a change. They are listed in
+// --help.
+const (
+	exitError = 1
+	// exitInvalidInput is an invalid flag or answer, or a prompt nothing
+	// answered. Flag parsing errors exit with 2 too.
+	exitInvalidInput = 2
+	// exitPreflight is a check of the host that failed before anything was
+	// changed, e.g. a port in use or a missing privilege
+	exitPreflight = 3
+	// exitNetwork is a failed download or image pull, trying again later
+	// can succeed
+	exitNetwork = 4
+	// exitContainers is a failure of Docker, Podman or compose
+	exitContainers = 5
+	// exitHealthTimeout is a stack that did not become healthy in time
+	exitHealthTimeout = 6
+)
+
+// exitCodeUsage documents the exit codes for --help
+var exitCodeUsage = strings.TrimLeft(`
+Exit codes:
+  0  success, or cancelled at a prompt
+  1  any other failure
+  2  invalid flags or answers, or prompts without an answer (listed)
+  3  a pre-flight check failed, nothing was changed
+  4  a download or image pull failed, retrying later can succeed
+  5  Docker, Podman or compose failed or is missing
+  6  the stack did not become healthy within --health-timeout
+  10 --dry-run found changes to make
+`, "\n")
+
+// exitCodeError is an error that sets the exit code of the run
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+// withExitCode makes err exit the run with code, unless an error it wraps
+// already carries a code
+func withExitCode(code int, err error) error {
+	if err == nil || exitCodeOf(err, 0) != 0 {
+		return err
+	}
+	return &exitCodeError{code: code, err: err}
+}
+
+// exitCodeOf returns the exit code err carries, or fallback
+func exitCodeOf(err error, fallback int) int {
+	var coded *exitCodeError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return fallback
+}
+
+// exitf prints an error pointing at the install log and exits with code
+func exitf(code int, format string, a ...any) {
+	errorf(format, a...)
+	report.fail(code, strings.TrimSpace(fmt.Sprintf(format, a...)))
+	if installLog.path != "" {
+		fmt.Fprintf(os.Stderr, "See %s for details.\n", installLog.path)
+	}
+	logf("ERROR", "exit code %d", code)
+	installLog.close()
+	osExit(code)
+}
+
+// osExit ends the run, tests replace it to observe the exit code
+var osExit = os.Exit
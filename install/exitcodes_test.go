@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+// exitCalled is the panic value of osExit while a test observes exits
+type exitCalled int
+
+// catchExit runs f and returns the code it exited with, or -1 when it returned
+func catchExit(t *testing.T, f func()) (code int) {
+	t.Helper()
+	saved := osExit
+	osExit = func(code int) { panic(exitCalled(code)) }
+	defer func() {
+		osExit = saved
+		if r := recover(); r != nil {
+			exited, ok := r.(exitCalled)
+			if !ok {
+				panic(r)
+			}
+			code = int(exited)
+		}
+	}()
+	f()
+	return -1
+}
+
+// withAnswers presets the prompts with flag values for one test and restores
+// the answer state afterwards
+func withAnswers(t *testing.T, flags map[string]string) {
+	t.Helper()
+	savedAnswers, savedYes, savedNonInteractive := promptAnswers, acceptDefaults, nonInteractive
+	savedMissing, savedCollecting, savedFile := missingAnswers, collectingAnswers, answersFile
+	t.Cleanup(func() {
+		promptAnswers, acceptDefaults, nonInteractive = savedAnswers, savedYes, savedNonInteractive
+		missingAnswers, collectingAnswers, answersFile = savedMissing, savedCollecting, savedFile
+	})
+	promptAnswers = map[string]string{}
+	for key, value := range flags {
+		promptAnswers[key] = value
+	}
+	missingAnswers, collectingAnswers, answersFile = nil, false, ""
+}
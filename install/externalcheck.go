@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// externalCheckAPI probes the host from several nodes on the internet
+	externalCheckAPI = "https://check-host.net"
+	// externalCheckTimeout bounds the whole step so a blocked network only
+	// delays the summary
+	externalCheckTimeout = 45 * time.Second
+	externalCheckNodes   = 3
+)
+
+var skipExternalCheck bool
+
+func addExternalCheckFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&skipExternalCheck, "skip-external-check", false, "Do not ask "+externalCheckAPI+" to test whether the dashboard is reachable from the internet")
+}
+
+// externalCheck is the outcome of one connectivity check
+type externalCheck struct {
+	Name   string
+	OK     bool
+	Detail string
+	// Hint tells how to fix a failed check
+	Hint string
+}
+
+// runExternalCheck asks the external checker to fetch the dashboard and probe
+// the WireGuard port, checks the certificate served at the public address and
+// summarizes the results
+func runExternalCheck(config Config) {
+	if skipExternalCheck {
+		report.skip("external connectivity check (disabled by flag)")
+		return
+	}
+	if !network.allow("external connectivity check") {
+		return
+	}
+	infoln("\n=== External Connectivity Check ===")
+	if !readBool("external_check", tr("prompt.external_check", externalCheckAPI, config.DashboardDomain), true) {
+		report.skip("external connectivity check (declined)")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), externalCheckTimeout)
+	defer cancel()
+
+	type result struct {
+		index int
+		check externalCheck
+	}
+	checks := []func(context.Context) externalCheck{
+		func(ctx context.Context) externalCheck { return checkExternalHTTPS(ctx, config) },
+		func(ctx context.Context) externalCheck { return checkServedCertificate(ctx, config) },
+	}
+	if config.InstallGerbil {
+		checks = append(checks, func(ctx context.Context) externalCheck { return checkExternalUDP(ctx, config) })
+	}
+	results := make([]externalCheck, len(checks))
+	done := make(chan result)
+	for i, check := range checks {
+		go func() { done <- result{i, check(ctx)} }()
+	}
+	err := runStep(ctx, "Testing the dashboard from the internet", func(context.Context) error {
+		for range checks {
+			r := <-done
+			results[r.index] = r.check
+		}
+		return nil
+	})
+	if err != nil {
+		warnf("Warning: the external check did not finish: %v\n", err)
+		return
+	}
+
+	for _, check := range results {
+		status := "PASS"
+		if !check.OK {
+			status = "FAIL"
+		}
+		logf("INFO", "external check %s: %s %s", check.Name, status, check.Detail)
+		infof("  %s  %-12s %s\n", status, check.Name, check.Detail)
+		if !check.OK && check.Hint != "" {
+			infof("        %-12s %s\n", "", check.Hint)
+		}
+	}
+}
+
+// checkExternalHTTPS fetches the dashboard from the checker's nodes
+func checkExternalHTTPS(ctx context.Context, config Config) externalCheck {
+	check := externalCheck{Name: "HTTPS"}
+	results, err := checkHost(ctx, "http", config.DashboardURL())
+	if err != nil {
+		check.Detail = "could not run the check: " + err.Error()
+		return check
+	}
+	var reached, failed []string
+	for node, raw := range results {
+		// Each result is [success, seconds, message, status code, address]
+		var rows [][]any
+		if json.Unmarshal(raw, &rows) != nil || len(rows) == 0 || len(rows[0]) < 3 {
+			continue
+		}
+		row := rows[0]
+		message := fmt.Sprint(row[2])
+		if len(row) > 3 && row[3] != nil {
+			message = fmt.Sprintf("%v %v", row[3], message)
+		}
+		if success, _ := row[0].(float64); success == 1 {
+			reached = append(reached, message)
+		} else {
+			failed = append(failed, shortNode(node)+": "+message)
+		}
+	}
+	switch {
+	case len(reached) > 0:
+		check.OK = true
+		check.Detail = fmt.Sprintf("%s answered %s from %d of %d locations", config.DashboardURL(), reached[0], len(reached), len(results))
+	case len(failed) > 0:
+		check.Detail = strings.Join(failed, ", ")
+		check.Hint = httpsHint(strings.ToLower(check.Detail), config)
+	default:
+		check.Detail = "no location finished the check in time"
+		check.Hint = httpsHint("timed out", config)
+	}
+	return check
+}
+
+func httpsHint(failure string, config Config) string {
+	switch {
+	case strings.Contains(failure, "resolve") || strings.Contains(failure, "name"):
+		return "DNS: point an A record for " + config.DashboardDomain + " at this server's public address."
+	case strings.Contains(failure, "refused"):
+		return fmt.Sprintf("Nothing accepts connections on TCP %d at the public address: check the NAT port forwarding and that the gerbil container runs.", config.HTTPSPort)
+	case strings.Contains(failure, "certificate") || strings.Contains(failure, "ssl"):
+		return "TLS: the certificate was rejected, see the certificate check."
+	default:
+		return fmt.Sprintf("Firewall: allow TCP %s in the host firewall and your provider's security group, and forward them when behind NAT.", joinPorts(config.EntryPointPorts()))
+	}
+}
+
+// checkServedCertificate reads the certificate served for the dashboard at
+// the public address its DNS record points at
+func checkServedCertificate(ctx context.Context, config Config) externalCheck {
+	check := externalCheck{Name: "Certificate"}
+	ips, err := net.DefaultResolver.LookupHost(ctx, config.DashboardDomain)
+	if err != nil || len(ips) == 0 {
+		check.Detail = "could not resolve " + config.DashboardDomain
+		check.Hint = "DNS: point an A record for " + config.DashboardDomain + " at this server's public address."
+		return check
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	conn, err := dialTLS(dialCtx, "tcp", net.JoinHostPort(ips[0], strconv.Itoa(config.HTTPSPort)), &tls.Config{ServerName: config.DashboardDomain, InsecureSkipVerify: true})
+	if err != nil {
+		check.Detail = fmt.Sprintf("could not connect to %s: %v", ips[0], err)
+		check.Hint = "NAT: servers behind NAT often cannot reach their own public address, rely on the HTTPS check instead."
+		return check
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		check.Detail = "no certificate was served"
+		return check
+	}
+	leaf := certs[0]
+	issuer := leaf.Issuer.CommonName
+	if len(leaf.Issuer.Organization) > 0 {
+		issuer = leaf.Issuer.Organization[0] + " " + issuer
+	}
+	check.Detail = fmt.Sprintf("issued by %s, valid until %s", strings.TrimSpace(issuer), leaf.NotAfter.Format("2006-01-02"))
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{DNSName: config.DashboardDomain, Intermediates: intermediates})
+	switch {
+	case strings.Contains(leaf.Subject.CommonName, "TRAEFIK DEFAULT CERT"):
+		check.Detail = "Traefik serves its self-signed default certificate"
+		check.Hint = fmt.Sprintf("ACME: Let's Encrypt has not issued a certificate yet. It needs TCP %d reachable for the HTTP challenge, check docker logs traefik.", config.HTTPPort)
+	case config.ACMEStaging:
+		check.OK = true
+		check.Detail += " (staging, not trusted by browsers)"
+	case verifyErr != nil:
+		check.Hint = "TLS: browsers will reject the certificate: " + verifyErr.Error()
+	default:
+		check.OK = true
+	}
+	return check
+}
+
+// checkExternalUDP probes the WireGuard port. WireGuard ignores packets
+// without a valid handshake, so silence means open or filtered and only an
+// ICMP port unreachable proves the port closed.
+func checkExternalUDP(ctx context.Context, config Config) externalCheck {
+	port := config.GerbilPorts()[0]
+	check := externalCheck{Name: "WireGuard"}
+	results, err := checkHost(ctx, "udp", net.JoinHostPort(config.DashboardDomain, strconv.Itoa(port)))
+	if err != nil {
+		check.Detail = "could not run the check: " + err.Error()
+		return check
+	}
+	var silent, closed int
+	for _, raw := range results {
+		var rows []map[string]any
+		if json.Unmarshal(raw, &rows) != nil || len(rows) == 0 {
+			continue
+		}
+		if _, ok := rows[0]["error"]; ok {
+			closed++
+		} else {
+			silent++
+		}
+	}
+	switch {
+	case closed > 0:
+		check.Detail = fmt.Sprintf("UDP %d was refused from %d of %d locations", port, closed, len(results))
+		check.Hint = fmt.Sprintf("Firewall: allow UDP %d and forward it when behind NAT, Newt sites cannot connect otherwise.", port)
+	case silent > 0:
+		check.OK = true
+		check.Detail = fmt.Sprintf("UDP %d appears open (no rejection from %d locations, WireGuard does not answer probes)", port, silent)
+	default:
+		check.Detail = "no location finished the check in time"
+	}
+	return check
+}
+
+// checkHost starts a check of kind (http, tcp or udp) for target and polls
+// until every node reported or ctx ends. The results are keyed by node.
+func checkHost(ctx context.Context, kind, target string) (map[string]json.RawMessage, error) {
+	var started struct {
+		OK        int    `json:"ok"`
+		RequestID string `json:"request_id"`
+		Error     string `json:"error"`
+	}
+	query := url.Values{"host": {target}, "max_nodes": {strconv.Itoa(externalCheckNodes)}}
+	if err := checkHostGet(ctx, externalCheckAPI+"/check-"+kind+"?"+query.Encode(), &started); err != nil {
+		return nil, err
+	}
+	if started.OK != 1 || started.RequestID == "" {
+		return nil, fmt.Errorf("the checker refused the request: %s", orUnknown(started.Error))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+		var results map[string]json.RawMessage
+		if err := checkHostGet(ctx, externalCheckAPI+"/check-result/"+started.RequestID, &results); err != nil {
+			return nil, err
+		}
+		finished := make(map[string]json.RawMessage, len(results))
+		for node, raw := range results {
+			if string(raw) != "null" {
+				finished[node] = raw
+			}
+		}
+		if len(results) > 0 && len(finished) == len(results) {
+			return finished, nil
+		}
+	}
+}
+
+func checkHostGet(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return errors.New("unexpected response from " + req.URL.Host)
+	}
+	return nil
+}
+
+// shortNode turns us1.node.check-host.net into us1
+func shortNode(node string) string {
+	name, _, _ := strings.Cut(node, ".")
+	return name
+}
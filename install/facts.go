@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// factsProvider answers every question the installer asks about the host.
+// Detection goes through it so that a captured fingerprint can stand in for
+// the real host when reproducing a bug report.
+type factsProvider interface {
+	goos() string
+	goarch() string
+	// machine is the kernel architecture as reported by uname -m
+	machine() string
+	// distro returns ID and VERSION_ID from /etc/os-release
+	distro() (id, version string)
+	euid() int
+	dockerInstalled() bool
+	dockerRunning() bool
+	dockerGroupMember() bool
+	// dockerCompose is "plugin", "standalone" or "" when compose is missing
+	dockerCompose() string
+	podmanInstalled() bool
+	podmanRunning() bool
+	toolVersions() map[string]string
+	cgroupVersion() int
+	// selinux is the output of getenforce, "" when SELinux is not present
+	selinux() string
+	apparmor() bool
+	systemd() bool
+	firewall() string
+	hasIPv4() bool
+	hasIPv6() bool
+	stdinTerminal() bool
+	term() string
+	// timezone is the IANA zone of the host, "" when it cannot be told
+	timezone() string
+	// wsl is the WSL version of the Linux host, 0 outside WSL
+	wsl() int
+	// wireguard reports whether the kernel has WireGuard, loaded, built in or
+	// as a module it can load
+	wireguard() bool
+}
+
+// facts is the active provider, replaced by --simulate-fingerprint
+var facts factsProvider = liveFacts{}
+
+func isRoot() bool {
+	return facts.euid() == 0
+}
+
+// liveFacts detects everything on the running host. Nothing is cached since
+// the installer changes the host while it runs (e.g. installing Docker).
+type liveFacts struct{}
+
+func (liveFacts) goos() string   { return runtime.GOOS }
+func (liveFacts) goarch() string { return runtime.GOARCH }
+func (liveFacts) euid() int      { return os.Geteuid() }
+
+func (liveFacts) machine() string {
+	out, err := outputCmd(exec.Command("uname", "-m"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (liveFacts) distro() (string, string) {
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return "", ""
+	}
+	var id, version string
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "ID":
+			id = value
+		case "VERSION_ID":
+			version = value
+		}
+	}
+	return id, version
+}
+
+func (liveFacts) dockerInstalled() bool { return isContainerInstalled("docker") }
+func (liveFacts) podmanInstalled() bool {
+	return isContainerInstalled("podman") && isContainerInstalled("podman-compose")
+}
+func (liveFacts) dockerRunning() bool { return runCmd(exec.Command("docker", "info")) == nil }
+func (liveFacts) podmanRunning() bool { return runCmd(exec.Command("podman", "info")) == nil }
+
+func (liveFacts) dockerGroupMember() bool {
+	dockerGroup, err := user.LookupGroup("docker")
+	if err != nil {
+		return false
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return false
+	}
+	groupIds, err := currentUser.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, groupId := range groupIds {
+		if groupId == dockerGroup.Gid {
+			return true
+		}
+	}
+	return false
+}
+
+func (liveFacts) dockerCompose() string {
+	if runCmd(exec.Command("docker", "compose", "version")) == nil {
+		return "plugin"
+	}
+	if runCmd(exec.Command("docker-compose", "version")) == nil {
+		return "standalone"
+	}
+	return ""
+}
+
+func (liveFacts) toolVersions() map[string]string {
+	versions := map[string]string{}
+	for name, args := range map[string][]string{
+		"docker":         {"docker", "version", "--format", "{{.Server.Version}}"},
+		"docker compose": {"docker", "compose", "version", "--short"},
+		"docker-compose": {"docker-compose", "version", "--short"},
+		"podman":         {"podman", "version", "--format", "{{.Version}}"},
+		"podman-compose": {"podman-compose", "version"},
+	} {
+		if out, err := outputCmd(exec.Command(args[0], args[1:]...)); err == nil {
+			lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+			versions[name] = strings.TrimSpace(lines[len(lines)-1])
+		}
+	}
+	return versions
+}
+
+func (liveFacts) wireguard() bool {
+	if _, err := os.Stat("/sys/module/wireguard"); err == nil {
+		return true
+	}
+	if out, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		builtin, _ := os.ReadFile("/lib/modules/" + strings.TrimSpace(string(out)) + "/modules.builtin")
+		if strings.Contains(string(builtin), "/wireguard.ko") {
+			return true
+		}
+	}
+	return runCmd(exec.Command("modinfo", "wireguard")) == nil
+}
+
+func (liveFacts) cgroupVersion() int {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		return 2
+	}
+	if _, err := os.Stat("/sys/fs/cgroup"); err == nil {
+		return 1
+	}
+	return 0
+}
+
+func (liveFacts) selinux() string {
+	out, err := outputCmd(exec.Command("getenforce"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (liveFacts) apparmor() bool {
+	data, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	return err == nil && strings.TrimSpace(string(data)) == "Y"
+}
+
+func (liveFacts) systemd() bool {
+	_, err := os.Stat("/run/systemd/system")
+	return err == nil
+}
+
+func (liveFacts) firewall() string {
+	if out, err := outputCmd(exec.Command("ufw", "status")); err == nil && strings.Contains(string(out), "Status: active") {
+		return "ufw"
+	}
+	if out, err := outputCmd(exec.Command("firewall-cmd", "--state")); err == nil && strings.TrimSpace(string(out)) == "running" {
+		return "firewalld"
+	}
+	return ""
+}
+
+func (liveFacts) hasIPv4() bool       { return routeSource("udp4", "192.0.2.1:53") != nil }
+func (liveFacts) hasIPv6() bool       { return routeSource("udp6", "[2001:db8::1]:53") != nil }
+func (liveFacts) stdinTerminal() bool { return term.IsTerminal(int(os.Stdin.Fd())) }
+func (liveFacts) term() string        { return os.Getenv("TERM") }
+func (liveFacts) timezone() string    { return hostTimezone() }
+
+func (liveFacts) wsl() int {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return 0
+	}
+	return wslVersion(string(data))
+}
+
+const fingerprintFormat = 1
+
+// environmentFacts is a captured fingerprint. It holds no hostnames, addresses
+// or user names so it can be attached to a public bug report.
+type environmentFacts struct {
+	Format           int               `json:"format"`
+	InstallerVersion string            `json:"installerVersion"`
+	CapturedAt       time.Time         `json:"capturedAt"`
+	OS               string            `json:"os"`
+	Arch             string            `json:"arch"`
+	Machine          string            `json:"machine"`
+	DistroID         string            `json:"distroId"`
+	DistroVersion    string            `json:"distroVersion"`
+	Root             bool              `json:"root"`
+	DockerInstalled  bool              `json:"dockerInstalled"`
+	DockerRunning    bool              `json:"dockerRunning"`
+	DockerGroup      bool              `json:"dockerGroup"`
+	DockerCompose    string            `json:"dockerCompose"`
+	PodmanInstalled  bool              `json:"podmanInstalled"`
+	PodmanRunning    bool              `json:"podmanRunning"`
+	Versions         map[string]string `json:"versions"`
+	CgroupVersion    int               `json:"cgroupVersion"`
+	SELinux          string            `json:"selinux"`
+	AppArmor         bool              `json:"apparmor"`
+	Systemd          bool              `json:"systemd"`
+	Firewall         string            `json:"firewall"`
+	IPv4             bool              `json:"ipv4"`
+	IPv6             bool              `json:"ipv6"`
+	StdinTerminal    bool              `json:"stdinTerminal"`
+	Term             string            `json:"term"`
+	Timezone         string            `json:"timezone,omitempty"`
+	WSL              int               `json:"wsl,omitempty"`
+	WireGuard        bool              `json:"wireguard"`
+}
+
+func (f *environmentFacts) goos() string    { return f.OS }
+func (f *environmentFacts) goarch() string  { return f.Arch }
+func (f *environmentFacts) machine() string { return f.Machine }
+func (f *environmentFacts) distro() (string, string) {
+	return f.DistroID, f.DistroVersion
+}
+func (f *environmentFacts) euid() int {
+	if f.Root {
+		return 0
+	}
+	return 1000
+}
+func (f *environmentFacts) dockerInstalled() bool           { return f.DockerInstalled }
+func (f *environmentFacts) dockerRunning() bool             { return f.DockerRunning }
+func (f *environmentFacts) dockerGroupMember() bool         { return f.DockerGroup }
+func (f *environmentFacts) dockerCompose() string           { return f.DockerCompose }
+func (f *environmentFacts) podmanInstalled() bool           { return f.PodmanInstalled }
+func (f *environmentFacts) podmanRunning() bool             { return f.PodmanRunning }
+func (f *environmentFacts) toolVersions() map[string]string { return f.Versions }
+func (f *environmentFacts) cgroupVersion() int              { return f.CgroupVersion }
+func (f *environmentFacts) selinux() string                 { return f.SELinux }
+func (f *environmentFacts) apparmor() bool                  { return f.AppArmor }
+func (f *environmentFacts) systemd() bool                   { return f.Systemd }
+func (f *environmentFacts) firewall() string                { return f.Firewall }
+func (f *environmentFacts) hasIPv4() bool                   { return f.IPv4 }
+func (f *environmentFacts) hasIPv6() bool                   { return f.IPv6 }
+func (f *environmentFacts) stdinTerminal() bool             { return f.StdinTerminal }
+func (f *environmentFacts) term() string                    { return f.Term }
+func (f *environmentFacts) timezone() string                { return f.Timezone }
+func (f *environmentFacts) wsl() int                        { return f.WSL }
+func (f *environmentFacts) wireguard() bool                 { return f.WireGuard }
+
+// captureFacts snapshots every answer of provider
+func captureFacts(provider factsProvider) *environmentFacts {
+	id, version := provider.distro()
+	return &environmentFacts{
+		Format:           fingerprintFormat,
+		InstallerVersion: pangolinVersion,
+		CapturedAt:       time.Now().UTC(),
+		OS:               provider.goos(),
+		Arch:             provider.goarch(),
+		Machine:          provider.machine(),
+		DistroID:         id,
+		DistroVersion:    version,
+		Root:             provider.euid() == 0,
+		DockerInstalled:  provider.dockerInstalled(),
+		DockerRunning:    provider.dockerRunning(),
+		DockerGroup:      provider.dockerGroupMember(),
+		DockerCompose:    provider.dockerCompose(),
+		PodmanInstalled:  provider.podmanInstalled(),
+		PodmanRunning:    provider.podmanRunning(),
+		Versions:         provider.toolVersions(),
+		CgroupVersion:    provider.cgroupVersion(),
+		SELinux:          provider.selinux(),
+		AppArmor:         provider.apparmor(),
+		Systemd:          provider.systemd(),
+		Firewall:         provider.firewall(),
+		IPv4:             provider.hasIPv4(),
+		IPv6:             provider.hasIPv6(),
+		StdinTerminal:    provider.stdinTerminal(),
+		Term:             provider.term(),
+		Timezone:         provider.timezone(),
+		WSL:              provider.wsl(),
+		WireGuard:        provider.wireguard(),
+	}
+}
+
+// runFingerprint implements the fingerprint subcommand
+func runFingerprint(args []string) {
+	fs := flag.NewFlagSet("fingerprint", flag.ExitOnError)
+	out := fs.String("out", "pangolin-fingerprint.json", "File to write the fingerprint to")
+	addTerminalFlags(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	fingerprint := captureFacts(liveFacts{})
+	data, err := json.MarshalIndent(fingerprint, "", "  ")
+	if err != nil {
+		fatalf("Error encoding the fingerprint: %v\n", err)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0644); err != nil {
+		fatalf("Error writing %s: %v\n", *out, err)
+	}
+	infof("%s/%s %s %s, docker=%v compose=%q podman=%v, cgroup v%d, selinux=%q apparmor=%v\n",
+		fingerprint.OS, fingerprint.Machine, fingerprint.DistroID, fingerprint.DistroVersion,
+		fingerprint.DockerRunning, fingerprint.DockerCompose, fingerprint.PodmanRunning,
+		fingerprint.CgroupVersion, fingerprint.SELinux, fingerprint.AppArmor)
+	infof("Fingerprint written to %s. It contains no hostnames, addresses or credentials and can be attached to a bug report.\n", *out)
+}
+
+// addSimulateFlag registers --simulate-fingerprint on fs
+func addSimulateFlag(fs *flag.FlagSet) {
+	fs.Func("simulate-fingerprint", "Developer option: replace all host detection with the facts of a captured fingerprint file", func(path string) error {
+		fingerprint, err := loadFingerprint(path)
+		if err != nil {
+			return err
+		}
+		simulateFacts(fingerprint)
+		warnf("Simulating the environment captured in %s. Detection results are canned, commands still run on this host.\n", path)
+		return nil
+	})
+}
+
+func loadFingerprint(path string) (*environmentFacts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fingerprint environmentFacts
+	if err := json.Unmarshal(data, &fingerprint); err != nil {
+		return nil, fmt.Errorf("%s is not a fingerprint: %v", path, err)
+	}
+	if fingerprint.Format != fingerprintFormat {
+		return nil, fmt.Errorf("%s uses fingerprint format %d, this installer supports format %d", path, fingerprint.Format, fingerprintFormat)
+	}
+	return &fingerprint, nil
+}
+
+// simulateFacts makes fingerprint the active provider. Connectivity is
+// simulated with documentation addresses since the real ones are not captured.
+func simulateFacts(fingerprint *environmentFacts) {
+	facts = fingerprint
+	routeSource = func(network, target string) net.IP {
+		switch {
+		case network == "udp4" && fingerprint.IPv4:
+			return net.ParseIP("192.0.2.10")
+		case network == "udp6" && fingerprint.IPv6:
+			return net.ParseIP("2001:db8::10")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// resourceFirewallRule is a firewall rule opened by the installer
+const resourceFirewallRule = "firewall-rule"
+
+// firewallPort is a host port the stack publishes
+type firewallPort struct {
+	Port  string
+	Proto string
+}
+
+func (p firewallPort) String() string {
+	return p.Port + "/" + p.Proto
+}
+
+// publishedPorts returns the host ports published in a compose file, so
+// custom ports (e.g. a different WireGuard port) are picked up automatically
+func publishedPorts(composePath string) ([]firewallPort, error) {
+	data, err := os.ReadFile(composePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []firewallPort
+	inPorts := false
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if before, _, ok := strings.Cut(trimmed, "#"); ok {
+			trimmed = strings.TrimSpace(before)
+		}
+		switch {
+		case trimmed == "ports:":
+			inPorts = true
+			continue
+		case !inPorts:
+			continue
+		case !strings.HasPrefix(trimmed, "-"):
+			inPorts = false
+			continue
+		}
+
+		entry := strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")), `"'`)
+		if isLoopbackBinding(entry) {
+			continue
+		}
+		proto := "tcp"
+		if spec, p, ok := strings.Cut(entry, "/"); ok {
+			entry, proto = spec, p
+		}
+		// host[:ip]:container, the host port is the second to last element
+		parts := strings.Split(entry, ":")
+		host := parts[0]
+		if len(parts) >= 2 {
+			host = parts[len(parts)-2]
+		}
+		port := firewallPort{Port: host, Proto: proto}
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// printExternalPorts lists the ports to open in the provider's firewall or
+// forward on the router
+func printExternalPorts(composePath string) {
+	ports, err := publishedPorts(composePath)
+	if err != nil || len(ports) == 0 {
+		return
+	}
+	names := make([]string, len(ports))
+	for i, port := range ports {
+		names[i] = port.String()
+	}
+	infof("\nPorts to open externally: %s\n", strings.Join(names, ", "))
+}
+
+// activeFirewall returns "ufw", "firewalld" or "" if neither is active
+func activeFirewall() string {
+	return facts.firewall()
+}
+
+// firewallCommands returns the commands that permanently open and close port
+func firewallCommands(firewall string, port firewallPort) (open, close string) {
+	switch firewall {
+	case "ufw":
+		return "ufw allow " + port.String(), "ufw delete allow " + port.String()
+	case "firewalld":
+		return "firewall-cmd --permanent --add-port=" + port.String(),
+			"firewall-cmd --permanent --remove-port=" + port.String() + " && firewall-cmd --reload"
+	}
+	return "", ""
+}
+
+// configureFirewall lists the ports the stack needs and offers to open them
+// in ufw or firewalld. Opened rules are recorded so uninstall can close them.
+func configureFirewall(composePath string) {
+	infoln("\n=== Firewall ===")
+	ports, err := publishedPorts(composePath)
+	if err != nil {
+		warnf("Warning: could not read the published ports: %v\n", err)
+		return
+	}
+	if len(ports) == 0 {
+		return
+	}
+
+	names := make([]string, len(ports))
+	for i, port := range ports {
+		names[i] = port.String()
+	}
+	infof("Pangolin needs the following ports to be reachable: %s\n", strings.Join(names, ", "))
+
+	if !platform.Firewall {
+		infof("On %s the host firewall is not managed, forward these ports to this machine and allow them in its firewall.\n", platform.Name)
+		report.skip("firewall configuration (" + platform.Name + ")")
+		return
+	}
+
+	firewall := ""
+	if isRoot() {
+		firewall = activeFirewall()
+	}
+	if firewall == "" {
+		infoln("No active ufw or firewalld was found. Open these ports in your firewall and in your provider's security group.")
+		report.skip("firewall configuration (no supported firewall active)")
+		return
+	}
+
+	if !readBool("configure_firewall", tr("prompt.configure_firewall", firewall), true) {
+		infoln("Remember to open the ports manually before accessing the dashboard.")
+		return
+	}
+
+	for _, port := range ports {
+		open, close := firewallCommands(firewall, port)
+		if err := run("bash", "-c", open); err != nil {
+			warnf("Warning: %s failed: %v\n", open, err)
+			continue
+		}
+		logf("INFO", "firewall rule opened: %s", open)
+		recordExternal(externalResource{Kind: resourceFirewallRule, Description: open, Undo: close})
+	}
+	if firewall == "firewalld" {
+		if err := run("firewall-cmd", "--reload"); err != nil {
+			warnf("Warning: firewall-cmd --reload failed: %v\n", err)
+		}
+	}
+	infof("Opened %s in %s.\n", strings.Join(names, ", "), firewall)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/charmbracelet/huh"
+)
+
+// question is a prompt that can be asked on its own or as a field of a
+// formGroup
+type question interface {
+	// name is the key and the prompt, for --non-interactive errors
+	name() (key, prompt string)
+	// preset answers the question from its flag, environment variable,
+	// answers file or --yes, reporting whether it did
+	preset() bool
+	// field is the huh field the answer is typed into
+	field() huh.Field
+	// record logs and prints the answer typed into field
+	record()
+}
+
+// ask answers q from its preset or asks it on a screen of its own
+func ask(q question) {
+	if q.preset() {
+		return
+	}
+	requireInteractive(q.name())
+	err := runField(q.field())
+	handleAbort(err)
+	q.record()
+}
+
+// formGroup collects related questions, e.g. all SMTP settings. With
+// --grouped-forms they are shown on one screen with tab moving between the
+// fields, otherwise, and always in accessible mode, they are asked one after
+// the other like any other prompt.
+type formGroup struct {
+	title   string
+	entries []formEntry
+}
+
+// formEntry creates its question when it is asked, so a default can follow an
+// earlier answer of the group, and copies the answer out afterwards
+type formEntry struct {
+	build func() question
+	done  func()
+}
+
+func newFormGroup(title string) *formGroup {
+	return &formGroup{title: title}
+}
+
+// text adds a question read like readValidated
+func (g *formGroup) text(key, prompt, defaultValue string, validate func(string) error, opts ...fieldOption) *string {
+	var p *textPrompt
+	var result string
+	g.entries = append(g.entries, formEntry{
+		build: func() question {
+			p = newTextPrompt(key, prompt, defaultValue, validate, opts...)
+			return p
+		},
+		done: func() { result = p.value },
+	})
+	return &result
+}
+
+// password adds a question read like readPassword
+func (g *formGroup) password(key, prompt string) *string {
+	return g.text(key, prompt, "", nil,
+		withEchoMode(huh.EchoModePassword),
+		withMaskedTranscript(),
+		withRawInput(),
+		withRequiredMessage(tr("input.password_required")))
+}
+
+// intInRange adds a question read like readIntInRange
+func (g *formGroup) intInRange(key, prompt string, defaultValue, min, max int) *int {
+	var p *textPrompt
+	result := defaultValue
+	g.entries = append(g.entries, formEntry{
+		build: func() question {
+			p = newTextPrompt(key, prompt, strconv.Itoa(defaultValue), validateIntInRange(min, max))
+			return p
+		},
+		done: func() {
+			if n, err := strconv.Atoi(p.value); err == nil {
+				result = n
+			}
+		},
+	})
+	return &result
+}
+
+// choice adds a question read like readSelect. defaultValue is called when
+// the question is asked one by one, after the questions before it, and when
+// the screen is drawn in grouped mode.
+func (g *formGroup) choice(key, prompt string, options []Option, defaultValue func() string) *string {
+	var p *selectPrompt
+	var result string
+	g.entries = append(g.entries, formEntry{
+		build: func() question {
+			p = newSelectPrompt(key, prompt, options, defaultValue(), nil)
+			return p
+		},
+		done: func() { result = p.value },
+	})
+	return &result
+}
+
+// run asks the questions of the group. Preset questions are answered first
+// and only the rest are shown.
+func (g *formGroup) run() {
+	if !terminal.grouped || isAccessibleMode() {
+		for _, entry := range g.entries {
+			ask(entry.build())
+			entry.done()
+		}
+		return
+	}
+
+	var pending []question
+	var pendingEntries []formEntry
+	var fields []huh.Field
+	for _, entry := range g.entries {
+		q := entry.build()
+		if q.preset() {
+			// Later defaults follow the preset answer
+			entry.done()
+			continue
+		}
+		requireInteractive(q.name())
+		pending = append(pending, q)
+		pendingEntries = append(pendingEntries, entry)
+		fields = append(fields, q.field())
+	}
+	if len(pending) == 0 {
+		return
+	}
+	handleAbort(runForm(huh.NewForm(huh.NewGroup(fields...).Title(g.title))))
+	for i, q := range pending {
+		q.record()
+		pendingEntries[i].done()
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/charmbracelet/huh"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed countries.txt
+var embeddedCountries string
+
+// geoBlockMiddleware is the Traefik middleware that rejects requests from
+// outside the allowed countries
+const geoBlockMiddleware = "installer-geoblock"
+
+// Where the allowed countries are enforced
+const (
+	geoBlockScopeDashboard  = "dashboard"
+	geoBlockScopeEverything = "everything"
+)
+
+// Choices for the geo-blocking of an existing install
+const (
+	geoBlockKeep   = "keep"
+	geoBlockChange = "change"
+	geoBlockRemove = "remove"
+)
+
+// country is an ISO 3166-1 alpha-2 code and the English name
+type country struct {
+	Code string
+	Name string
+}
+
+func parseCountries(text string) []country {
+	var countries []country
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		code, name, _ := strings.Cut(line, " ")
+		countries = append(countries, country{Code: code, Name: name})
+	}
+	return countries
+}
+
+var countries = parseCountries(embeddedCountries)
+
+// parseCountryCodes turns a comma or space separated list into upper case
+// country codes, rejecting codes that are not in ISO 3166-1
+func parseCountryCodes(list string) ([]string, error) {
+	var codes []string
+	for _, code := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		code = strings.ToUpper(code)
+		if !slices.ContainsFunc(countries, func(c country) bool { return c.Code == code }) {
+			return nil, errors.New(tr("geoblock.invalid_country", code))
+		}
+		if !slices.Contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return nil, errors.New(tr("geoblock.no_country"))
+	}
+	return codes, nil
+}
+
+// readCountries lets the user pick countries from a filterable list.
+// Accessible mode, preset answers and --yes take comma-separated codes
+// instead.
+func readCountries(key, prompt string, defaults []string) []string {
+	if installed, ok := installedAnswers[key]; ok {
+		defaults = strings.Split(installed, ",")
+	}
+	if _, _, preset := presetValue(key); preset || acceptDefaults || nonInteractive || isAccessibleMode() {
+		answer := readValidated(key, prompt, strings.Join(defaults, ","), func(s string) error {
+			_, err := parseCountryCodes(s)
+			return err
+		})
+		codes, _ := parseCountryCodes(answer)
+		return codes
+	}
+
+	values := defaults
+	options := make([]huh.Option[string], len(countries))
+	for i, c := range countries {
+		options[i] = huh.NewOption(c.Code+"  "+c.Name, c.Code).Selected(slices.Contains(defaults, c.Code))
+	}
+	field := huh.NewMultiSelect[string]().
+		Title(prompt).
+		Description("Type / to filter, space to select").
+		Options(options...).
+		Filterable(true).
+		Height(14).
+		Validate(func(values []string) error {
+			if len(values) == 0 {
+				return errors.New(tr("geoblock.no_country"))
+			}
+			return nil
+		}).
+		Value(&values)
+	err := runField(field)
+	handleAbort(err)
+	logAnswer(key, prompt, strings.Join(values, ","), sourcePrompt, false)
+	session.answered(sessionAnswer{Key: key, Prompt: prompt, Value: strings.Join(values, ",")})
+	fmt.Fprintf(consoleOut, "%s: %s\n", prompt, strings.Join(values, ", "))
+	return values
+}
+
+// GeoBlockDashboard reports whether the geo-blocking middleware is attached
+// to the dashboard routers, rather than to the whole HTTPS entry point
+func (c Config) GeoBlockDashboard() bool {
+	return len(c.GeoBlockCountries) > 0 && !c.GeoBlockEverything
+}
+
+// collectGeoBlock asks for the countries requests are allowed from
+func collectGeoBlock(config *Config) {
+	if !readBool("geoblock", tr("prompt.geoblock"), false) {
+		return
+	}
+	explainGeoBlock()
+	config.GeoBlockCountries = readCountries("geoblock_countries", tr("prompt.geoblock_countries"), nil)
+	scope := readChoice("geoblock_scope", tr("prompt.geoblock_scope"), []string{geoBlockScopeDashboard, geoBlockScopeEverything}, geoBlockScopeDashboard)
+	config.GeoBlockEverything = scope == geoBlockScopeEverything
+}
+
+func explainGeoBlock() {
+	infoln(tr("geoblock.explain"))
+}
+
+// installedGeoBlock returns the allowed countries of the installed
+// geo-blocking middleware, and whether it guards the whole entry point
+func installedGeoBlock() ([]string, bool) {
+	data, err := os.ReadFile("config/traefik/dynamic_config.yml")
+	if err != nil {
+		return nil, false
+	}
+	var dynamic struct {
+		HTTP struct {
+			Middlewares map[string]struct {
+				Plugin struct {
+					Geoblock struct {
+						Countries []string `yaml:"countries"`
+					} `yaml:"geoblock"`
+				} `yaml:"plugin"`
+			} `yaml:"middlewares"`
+		} `yaml:"http"`
+	}
+	if err := yaml.Unmarshal(data, &dynamic); err != nil {
+		return nil, false
+	}
+	countries := dynamic.HTTP.Middlewares[geoBlockMiddleware].Plugin.Geoblock.Countries
+	static, err := os.ReadFile("config/traefik/traefik_config.yml")
+	return countries, err == nil && bytes.Contains(static, []byte(geoBlockMiddleware+"@file"))
+}
+
+// reconfigureGeoBlock adds, changes or removes the geo-blocking of an
+// existing install
+func reconfigureGeoBlock(containerType SupportedContainer) {
+	if installedBehindExistingProxy() {
+		return
+	}
+	infoln("\n" + tr("geoblock.section"))
+	current, everything := installedGeoBlock()
+	config := Config{GeoBlockCountries: current, GeoBlockEverything: everything}
+	if len(current) > 0 {
+		scope := geoBlockScopeDashboard
+		if everything {
+			scope = geoBlockScopeEverything
+		}
+		infof("Requests are allowed from %s (%s scope).\n", strings.Join(current, ", "), scope)
+		switch readChoice("geoblock_reconfigure", tr("prompt.geoblock_reconfigure"), []string{geoBlockKeep, geoBlockChange, geoBlockRemove}, geoBlockKeep) {
+		case geoBlockKeep:
+			return
+		case geoBlockRemove:
+			config = Config{}
+		case geoBlockChange:
+			explainGeoBlock()
+			config.GeoBlockCountries = readCountries("geoblock_countries", tr("prompt.geoblock_countries"), current)
+			scope = readChoice("geoblock_scope", tr("prompt.geoblock_scope"), []string{geoBlockScopeDashboard, geoBlockScopeEverything}, scope)
+			config.GeoBlockEverything = scope == geoBlockScopeEverything
+		}
+	} else {
+		collectGeoBlock(&config)
+		if len(config.GeoBlockCountries) == 0 {
+			return
+		}
+	}
+
+	migrations, err := geoBlockMigrations(config)
+	if err != nil {
+		errorf("%s\n", tr("geoblock.prepare_failed", err))
+		return
+	}
+	if !applyReviewedMigrations(migrations, "the geo-blocking") {
+		return
+	}
+	if containerType == Undefined {
+		infoln(tr("geoblock.restart"))
+		return
+	}
+	if err := restartContainer("traefik", containerType); err != nil {
+		errorf("%s\n", tr("geoblock.restart_failed", err))
+		return
+	}
+	if err := waitForStackHealthy(containerType, installedDashboardDomain()); err != nil {
+		errorf("%s\n", tr("geoblock.unhealthy", err))
+	}
+}
+
+// geoBlockMigrations rewrite the installed Traefik configs to the
+// geo-blocking of config, none when it has no countries. The plugin and
+// middleware are taken from the templates so both paths write the same.
+func geoBlockMigrations(config Config) ([]configMigration, error) {
+	var plugin, middleware *yaml.Node
+	if len(config.GeoBlockCountries) > 0 {
+		static, err := renderTemplateDocument("config/traefik/traefik_config.yml", config)
+		if err != nil {
+			return nil, err
+		}
+		plugin = yamlMapValue(yamlMapValue(yamlMapValue(static, "experimental"), "plugins"), "geoblock")
+		dynamic, err := renderTemplateDocument("config/traefik/dynamic_config.yml", config)
+		if err != nil {
+			return nil, err
+		}
+		middleware = yamlMapValue(yamlMapValue(yamlMapValue(dynamic, "http"), "middlewares"), geoBlockMiddleware)
+		if plugin == nil || middleware == nil {
+			return nil, fmt.Errorf("the Traefik templates do not define the geoblock plugin and %s middleware", geoBlockMiddleware)
+		}
+	}
+
+	static := func(root *yaml.Node) []configChange {
+		var changes []configChange
+		plugins := yamlMapValue(yamlMapValue(root, "experimental"), "plugins")
+		if plugins != nil && yamlDeleteKey(plugins, "geoblock") != nil {
+			changes = append(changes, configChange{Kind: "removed", Key: "experimental.plugins.geoblock"})
+		}
+		entryPoints := yamlMapValue(root, "entryPoints")
+		for i := 0; entryPoints != nil && i+1 < len(entryPoints.Content); i += 2 {
+			name, http := entryPoints.Content[i].Value, yamlMapValue(entryPoints.Content[i+1], "http")
+			if middlewares := yamlMapValue(http, "middlewares"); middlewares != nil && removeMiddlewareReference(middlewares, geoBlockMiddleware) {
+				changes = append(changes, configChange{Kind: "removed", Key: "entryPoints." + name + ".http.middlewares." + geoBlockMiddleware})
+				if len(middlewares.Content) == 0 {
+					yamlDeleteKey(http, "middlewares")
+				}
+			}
+		}
+		if plugin == nil {
+			return changes
+		}
+		if plugins != nil {
+			yamlSetKey(plugins, "geoblock", plugin)
+			changes = append(changes, configChange{Kind: "added", Key: "experimental.plugins.geoblock"})
+		}
+		if http := yamlMapValue(yamlMapValue(entryPoints, "websecure"), "http"); config.GeoBlockEverything && http != nil {
+			middlewares := yamlMapValue(http, "middlewares")
+			if middlewares == nil {
+				middlewares = &yaml.Node{Kind: yaml.SequenceNode}
+				// Keep middlewares in front of tls like the template
+				http.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: "middlewares"}, middlewares}, http.Content...)
+			}
+			middlewares.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: geoBlockMiddleware + "@file"}}, middlewares.Content...)
+			changes = append(changes, configChange{Kind: "added", Key: "entryPoints.websecure.http.middlewares." + geoBlockMiddleware})
+		}
+		return changes
+	}
+
+	dynamic := func(root *yaml.Node) []configChange {
+		var changes []configChange
+		http := yamlMapValue(root, "http")
+		middlewares := yamlMapValue(http, "middlewares")
+		if middlewares != nil && yamlDeleteKey(middlewares, geoBlockMiddleware) != nil {
+			changes = append(changes, configChange{Kind: "removed", Key: "http.middlewares." + geoBlockMiddleware})
+		}
+		routers := yamlMapValue(http, "routers")
+		for i := 0; routers != nil && i+1 < len(routers.Content); i += 2 {
+			name, router := routers.Content[i].Value, routers.Content[i+1]
+			list := yamlMapValue(router, "middlewares")
+			if list != nil && removeMiddlewareReference(list, geoBlockMiddleware) {
+				changes = append(changes, configChange{Kind: "removed", Key: "http.routers." + name + ".middlewares." + geoBlockMiddleware})
+			}
+			if middleware == nil || config.GeoBlockEverything || !strings.HasPrefix(name, "installer-") || list == nil {
+				continue
+			}
+			list.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: geoBlockMiddleware}}, list.Content...)
+			changes = append(changes, configChange{Kind: "added", Key: "http.routers." + name + ".middlewares." + geoBlockMiddleware})
+		}
+		if middleware != nil && middlewares != nil {
+			yamlSetKey(middlewares, geoBlockMiddleware, middleware)
+			changes = append(changes, configChange{Kind: "added", Key: "http.middlewares." + geoBlockMiddleware})
+		}
+		return changes
+	}
+
+	return []configMigration{
+		{Name: "geoblock", File: "config/traefik/traefik_config.yml", Apply: static, Restart: "traefik"},
+		{Name: "geoblock", File: "config/traefik/dynamic_config.yml", Apply: dynamic, Restart: "traefik"},
+	}, nil
+}
+
+// renderTemplateDocument renders the template at path for config and parses
+// the result
+func renderTemplateDocument(path string, config Config) (*yaml.Node, error) {
+	content, _, err := readTemplate(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(path).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %v", path, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, config); err != nil {
+		return nil, fmt.Errorf("failed to execute template %s: %v", path, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(out.Bytes(), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse the rendered %s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s renders an empty document", path)
+	}
+	return doc.Content[0], nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The hardened HTTP defaults attached to the dashboard router
+const (
+	rateLimitMiddleware       = "installer-ratelimit"
+	securityHeadersMiddleware = "installer-security-headers"
+	hardenedRouter            = "installer-dashboard"
+	defaultRateLimitAverage   = 100
+	defaultRateLimitBurst     = 200
+)
+
+var hardeningMiddlewares = []string{rateLimitMiddleware, securityHeadersMiddleware}
+
+// collectHardening asks whether the dashboard gets a rate limit and security
+// headers, and for the limits
+func collectHardening(config *Config, enabled bool, average, burst int) {
+	config.HardenedHTTP = readBool("hardened_defaults", tr("prompt.hardened_defaults"), enabled)
+	if !config.HardenedHTTP {
+		return
+	}
+	config.RateLimitAverage = readIntInRange("rate_limit_average", tr("prompt.rate_limit_average"), average, 1, 10000)
+	config.RateLimitBurst = readIntInRange("rate_limit_burst", tr("prompt.rate_limit_burst"), max(burst, config.RateLimitAverage), config.RateLimitAverage, 100000)
+}
+
+// installedHardening returns the rate limit of the installed hardened
+// defaults, ok is false when they are not installed
+func installedHardening() (average, burst int, ok bool) {
+	data, err := os.ReadFile("config/traefik/dynamic_config.yml")
+	if err != nil {
+		return 0, 0, false
+	}
+	var dynamic struct {
+		HTTP struct {
+			Middlewares map[string]struct {
+				RateLimit struct {
+					Average int `yaml:"average"`
+					Burst   int `yaml:"burst"`
+				} `yaml:"rateLimit"`
+			} `yaml:"middlewares"`
+		} `yaml:"http"`
+	}
+	if err := yaml.Unmarshal(data, &dynamic); err != nil {
+		return 0, 0, false
+	}
+	limit, ok := dynamic.HTTP.Middlewares[rateLimitMiddleware]
+	return limit.RateLimit.Average, limit.RateLimit.Burst, ok
+}
+
+// reconfigureHardening turns the hardened HTTP defaults of an existing
+// install on or off, or changes the rate limit. Traefik watches the dynamic
+// config, so no restart is needed.
+func reconfigureHardening() {
+	if installedBehindExistingProxy() {
+		return
+	}
+	infoln("\n=== Hardened HTTP Defaults ===")
+	average, burst, installed := installedHardening()
+	if installed {
+		infof("The dashboard is rate limited to %d requests per second (burst %d) and sends security headers.\n", average, burst)
+	} else {
+		average, burst = defaultRateLimitAverage, defaultRateLimitBurst
+	}
+	var config Config
+	if traefikConfig, err := ReadTraefikConfig("config/traefik/traefik_config.yml"); err == nil {
+		config.ACMEStaging = traefikConfig.ACMEStaging
+	}
+	collectHardening(&config, installed, average, burst)
+	if !config.HardenedHTTP && !installed {
+		return
+	}
+
+	migrations, err := hardeningMigrations(config)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		return
+	}
+	if applyReviewedMigrations(migrations, "the hardened HTTP defaults") {
+		infoln("Traefik applies the change to the dynamic config without a restart.")
+	}
+}
+
+// hardeningMigrations rewrite the installed Traefik configs to the hardened
+// defaults of config. Every reference to the middlewares is removed first,
+// also from entry points and routers they were added to by hand, so turning
+// them off never leaves a reference Traefik cannot resolve.
+func hardeningMigrations(config Config) ([]configMigration, error) {
+	var definitions []*yaml.Node
+	if config.HardenedHTTP {
+		dynamic, err := renderTemplateDocument("config/traefik/dynamic_config.yml", config)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range hardeningMiddlewares {
+			definition := yamlMapValue(yamlMapValue(yamlMapValue(dynamic, "http"), "middlewares"), name)
+			if definition == nil {
+				return nil, fmt.Errorf("the Traefik templates do not define the %s middleware", name)
+			}
+			definitions = append(definitions, definition)
+		}
+	}
+
+	static := func(root *yaml.Node) []configChange {
+		var changes []configChange
+		entryPoints := yamlMapValue(root, "entryPoints")
+		for i := 0; entryPoints != nil && i+1 < len(entryPoints.Content); i += 2 {
+			name, http := entryPoints.Content[i].Value, yamlMapValue(entryPoints.Content[i+1], "http")
+			middlewares := yamlMapValue(http, "middlewares")
+			for _, middleware := range hardeningMiddlewares {
+				if middlewares != nil && removeMiddlewareReference(middlewares, middleware) {
+					changes = append(changes, configChange{Kind: "removed", Key: "entryPoints." + name + ".http.middlewares." + middleware})
+				}
+			}
+			if middlewares != nil && len(middlewares.Content) == 0 {
+				yamlDeleteKey(http, "middlewares")
+			}
+		}
+		return changes
+	}
+
+	dynamic := func(root *yaml.Node) []configChange {
+		var changes []configChange
+		http := yamlMapValue(root, "http")
+		middlewares := yamlMapValue(http, "middlewares")
+		for _, middleware := range hardeningMiddlewares {
+			if middlewares != nil && yamlDeleteKey(middlewares, middleware) != nil {
+				changes = append(changes, configChange{Kind: "removed", Key: "http.middlewares." + middleware})
+			}
+		}
+		routers := yamlMapValue(http, "routers")
+		for i := 0; routers != nil && i+1 < len(routers.Content); i += 2 {
+			name, router := routers.Content[i].Value, routers.Content[i+1]
+			list := yamlMapValue(router, "middlewares")
+			for _, middleware := range hardeningMiddlewares {
+				if list != nil && removeMiddlewareReference(list, middleware) {
+					changes = append(changes, configChange{Kind: "removed", Key: "http.routers." + name + ".middlewares." + middleware})
+				}
+			}
+			if name != hardenedRouter || !config.HardenedHTTP || list == nil {
+				continue
+			}
+			// After the geo-blocking, before Badger, like the template
+			at := 0
+			if len(list.Content) > 0 && list.Content[0].Value == geoBlockMiddleware {
+				at = 1
+			}
+			for j, middleware := range hardeningMiddlewares {
+				list.Content = slices.Insert(list.Content, at+j, &yaml.Node{Kind: yaml.ScalarNode, Value: middleware})
+				changes = append(changes, configChange{Kind: "added", Key: "http.routers." + name + ".middlewares." + middleware})
+			}
+		}
+		for i, definition := range definitions {
+			if middlewares == nil {
+				break
+			}
+			yamlSetKey(middlewares, hardeningMiddlewares[i], definition)
+			changes = append(changes, configChange{Kind: "added", Key: "http.middlewares." + hardeningMiddlewares[i]})
+		}
+		return changes
+	}
+
+	return []configMigration{
+		{Name: "hardened-http", File: "config/traefik/traefik_config.yml", Apply: static},
+		{Name: "hardened-http", File: "config/traefik/dynamic_config.yml", Apply: dynamic},
+	}, nil
+}
+
+// removeMiddlewareReference drops name from a middleware list, with or
+// without a provider suffix such as @file
+func removeMiddlewareReference(list *yaml.Node, name string) bool {
+	if list.Kind != yaml.SequenceNode {
+		return false
+	}
+	before := len(list.Content)
+	list.Content = slices.DeleteFunc(list.Content, func(item *yaml.Node) bool {
+		reference, _, _ := strings.Cut(item.Value, "@")
+		return reference == name
+	})
+	return len(list.Content) != before
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHealthTimeout = 5 * time.Minute
+	healthPollInterval   = 2 * time.Second
+	// healthLogLines is how much of a failing container's log is shown
+	healthLogLines = 50
+)
+
+var healthTimeoutFlag *time.Duration
+
+func healthTimeout() time.Duration {
+	if healthTimeoutFlag != nil && *healthTimeoutFlag > 0 {
+		return scaledTimeout(*healthTimeoutFlag)
+	}
+	return scaledTimeout(defaultHealthTimeout)
+}
+
+// healthCheck is a single readiness probe against the local stack
+type healthCheck struct {
+	container string
+	probe     func(ctx context.Context) error
+}
+
+// waitForStackHealthy polls the Traefik ping endpoint and the Pangolin API
+// through localhost until both answer or the timeout expires. On failure the
+// tail of the failing container's log is written to the terminal and the
+// install log.
+func waitForStackHealthy(containerType SupportedContainer, dashboardDomain string) error {
+	timeout := healthTimeout()
+	checks := stackHealthChecks(dashboardDomain)
+
+	var failing healthCheck
+	var lastErr error
+	err := runStep(context.Background(), fmt.Sprintf("Waiting for the stack to become healthy (up to %s)", timeout), func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		for {
+			lastErr = nil
+			for _, check := range checks {
+				if err := check.probe(ctx); err != nil {
+					failing, lastErr = check, err
+					break
+				}
+			}
+			if lastErr == nil {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return withExitCode(exitHealthTimeout, fmt.Errorf("%s is not healthy after %s: %v", failing.container, timeout, lastErr))
+			case <-time.After(healthPollInterval):
+			}
+		}
+	})
+	if err != nil {
+		dumpContainerLogs(containerType, failing.container)
+		return err
+	}
+	return nil
+}
+
+// stackHealthChecks probes through Traefik, or Pangolin's published port
+// directly when an existing reverse proxy sits in front of it
+func stackHealthChecks(dashboardDomain string) []healthCheck {
+	if address, ok := installedProxyAPIAddress("docker-compose.yml"); ok {
+		return []healthCheck{
+			{container: "pangolin", probe: func(ctx context.Context) error {
+				return probeURL(ctx, plainClient(), "http://"+address+"/api/v1/", dashboardDomain)
+			}},
+		}
+	}
+	httpPort, httpsPort := installedEntrypointPorts("config/traefik/traefik_config.yml")
+	traefikProbe := func(ctx context.Context) error {
+		return probeURL(ctx, plainClient(), fmt.Sprintf("http://127.0.0.1:%d/ping", httpPort), "")
+	}
+	if values, err := ReadTraefikConfig("config/traefik/traefik_config.yml"); err == nil && values.HTTPMode == httpDisabled {
+		// Without the web entry point ping is only served on Traefik's
+		// internal one, a TLS handshake on the HTTPS port shows it is up
+		traefikProbe = func(ctx context.Context) error { return probeTLS(ctx, httpsPort) }
+	}
+	return []healthCheck{
+		{container: "traefik", probe: traefikProbe},
+		{container: "pangolin", probe: func(ctx context.Context) error {
+			// Pangolin is only reachable through Traefik. The certificate may
+			// not be issued yet, so do not verify it.
+			return probeURL(ctx, localTLSClient(dashboardDomain, httpsPort), "https://"+dashboardDomain+"/api/v1/", dashboardDomain)
+		}},
+	}
+}
+
+func probeURL(ctx context.Context, client *http.Client, url, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if host != "" {
+		req.Host = host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// probeTLS completes a TLS handshake with the port on localhost
+func probeTLS(ctx context.Context, port int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	dialer := &tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func plainClient() *http.Client {
+	return &http.Client{
+		// The ping endpoint shares the entry point with the HTTPS redirect
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// localTLSClient connects to the HTTPS port on localhost regardless of what
+// the dashboard domain resolves to
+func localTLSClient(serverName string, port int) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &http.Client{
+		Transport: logTransport(&http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, fmt.Sprintf("127.0.0.1:%d", port))
+			},
+			TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+		}),
+	}
+}
+
+// dumpContainerLogs shows the last lines of a container's log
+func dumpContainerLogs(containerType SupportedContainer, container string) {
+	if container == "" {
+		return
+	}
+	// Containers log to both streams
+	var out bytes.Buffer
+	cmd := exec.Command(string(containerType), "logs", "--tail", fmt.Sprint(healthLogLines), container)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := runCmd(cmd); err != nil {
+		errorf("Could not read the logs of %s: %v\n", container, err)
+		return
+	}
+	errorf("\nLast %d log lines of %s:\n", healthLogLines, container)
+	for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+		errorf("  %s\n", line)
+	}
+}
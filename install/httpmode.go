@@ -0,0 +1,52 @@
+package main
+
+import "errors"
+
+// How the web entry point handles plain HTTP
+const (
+	httpRedirect = "redirect"
+	httpServe    = "serve"
+	httpDisabled = "disable"
+)
+
+// collectHTTPMode asks whether port 80 redirects to HTTPS, also serves
+// resources over HTTP or is not published at all
+func collectHTTPMode(config *Config) {
+	config.HTTPMode = readChoice("http_mode", tr("prompt.http_mode"), []string{httpRedirect, httpServe, httpDisabled}, httpRedirect)
+	if !config.HTTPEnabled() {
+		infoln("Port 80 stays closed, Let's Encrypt validates over TLS-ALPN-01 on port 443 or DNS-01 instead.")
+	}
+}
+
+// HTTPEnabled reports whether Traefik listens for plain HTTP
+func (c Config) HTTPEnabled() bool {
+	return c.HTTPMode != httpDisabled
+}
+
+// RedirectHTTP reports whether the web entry point redirects every request
+// to HTTPS. ACME HTTP-01 challenges and ping take precedence over it.
+func (c Config) RedirectHTTP() bool {
+	return c.HTTPMode == httpRedirect
+}
+
+// DashboardRedirectRouter reports whether the dashboard needs its own HTTP to
+// HTTPS redirect router, the entry point redirection makes it redundant
+func (c Config) DashboardRedirectRouter() bool {
+	return c.HTTPEnabled() && !c.RedirectHTTP()
+}
+
+// EntryPointPorts are the TCP ports Traefik publishes
+func (c Config) EntryPointPorts() []int {
+	if !c.HTTPEnabled() {
+		return []int{c.HTTPSPort}
+	}
+	return []int{c.HTTPPort, c.HTTPSPort}
+}
+
+// validateChallenge rejects an ACME challenge the HTTP mode cannot serve
+func (c Config) validateChallenge(challenge string) error {
+	if challenge == challengeHTTP && !c.HTTPEnabled() {
+		return errors.New("HTTP-01 challenges are answered on port 80, which is disabled. Choose tls-alpn-01 (port 443) or dns-01 (a DNS record), or keep HTTP enabled")
+	}
+	return nil
+}
@@ -2,39 +2,71 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
+	"math"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/charmbracelet/huh"
-	"golang.org/x/term"
 )
 
-// pangolinTheme is the custom theme using brand colors
-var pangolinTheme = ThemePangolin()
+// nonInteractive is set by --non-interactive. When true, any attempt to show a
+// prompt fails the run instead of waiting for input.
+var nonInteractive bool
 
-// isAccessibleMode checks if we should use accessible mode (simple prompts)
-// This is true for: non-TTY, TERM=dumb, or ACCESSIBLE env var set
-func isAccessibleMode() bool {
-	// Check if stdin is not a terminal (piped input, CI, etc.)
-	if !term.IsTerminal(int(os.Stdin.Fd())) {
-		return true
+// requireInteractive exits with an explanation when a prompt would be shown
+// while running with --non-interactive.
+func requireInteractive(key, prompt string) {
+	if !nonInteractive {
+		return
 	}
-	// Check for dumb terminal
-	if os.Getenv("TERM") == "dumb" {
-		return true
+
+	exitf(exitInvalidInput, "Error: prompt %q requires input but --non-interactive is set\n  Prompt: %s\n  Sources consulted:\n%s  Pass one of them, or --yes to take the defaults.\n", key, prompt, consultedSources(key))
+}
+
+// consultedSources lists where presetValue looked for an answer to the prompt
+// with key, one indented line each. Only the prompts in answerKeys have a flag
+// and can be answered by the answers file.
+func consultedSources(key string) string {
+	var sources []string
+	answerable := slices.Contains(answerKeys(), key)
+	if answerable {
+		sources = append(sources, "--"+promptFlagName(key)+" (not passed)")
 	}
-	// Check for explicit accessible mode request
-	if os.Getenv("ACCESSIBLE") != "" {
-		return true
+	sources = append(sources, answerEnvName(key)+" (not set)")
+	if answerable && answersFile != "" {
+		sources = append(sources, fmt.Sprintf("--answers %s (no %s key)", answersFile, key))
 	}
-	return false
+	var b strings.Builder
+	for _, source := range sources {
+		fmt.Fprintf(&b, "    %s\n", source)
+	}
+	return b.String()
+}
+
+// isAccessibleMode reports whether to use plain prompts, see resolveTerminal
+func isAccessibleMode() bool {
+	return terminal.accessible
 }
 
-// handleAbort checks if the error is a user abort (Ctrl+C) and exits if so
+// handleAbort checks if the error is a user abort (Ctrl+C) and exits if so.
+// A request for the previous question unwinds the wizard, see wizardSession.
 func handleAbort(err error) {
+	if errors.Is(err, errGoBack) || session.backRequested {
+		session.goBack()
+	}
 	if err != nil && errors.Is(err, huh.ErrUserAborted) {
-		fmt.Println("\nInstallation cancelled.")
+		if cleanups.isArmed() {
+			cleanups.abort()
+		}
+		fmt.Fprintln(consoleOut, "\n"+tr("input.cancelled"))
+		logf("INFO", "Installation cancelled by user")
+		report.emit("cancelled", "")
+		installLog.close()
 		os.Exit(0)
 	}
 }
@@ -42,167 +74,633 @@ func handleAbort(err error) {
 // runField runs a single field with the Pangolin theme, handling accessible mode
 func runField(field huh.Field) error {
 	if isAccessibleMode() {
-		return field.RunAccessible(os.Stdout, os.Stdin)
+		return field.RunAccessible(consoleOut, os.Stdin)
 	}
-	form := huh.NewForm(huh.NewGroup(field)).WithTheme(pangolinTheme)
-	return form.Run()
+	return runForm(huh.NewForm(huh.NewGroup(field)))
 }
 
-func readString(prompt string, defaultValue string) string {
-	var value string
+// fieldOption customizes a prompt read with readValidated
+type fieldOption func(*fieldOptions)
 
-	title := prompt
-	if defaultValue != "" {
-		title = fmt.Sprintf("%s (default: %s)", prompt, defaultValue)
-	}
+type fieldOptions struct {
+	echoMode    huh.EchoMode
+	suggestions []string
+	masked      bool
+	required    string
+	normalize   func(string) string
+}
 
-	input := huh.NewInput().
-		Title(title).
-		Value(&value)
+// normalizeText strips the zero-width and other non-printable characters
+// that pasted values carry, and the surrounding whitespace
+func normalizeText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || unicode.IsPrint(r) {
+			return r
+		}
+		return -1
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// trimNewline only strips the line break a password manager may paste along,
+// spaces can be part of a password
+func trimNewline(s string) string {
+	return strings.TrimRight(s, "\r\n")
+}
+
+// withEchoMode sets how typed characters are shown, e.g. huh.EchoModePassword
+func withEchoMode(mode huh.EchoMode) fieldOption {
+	return func(o *fieldOptions) { o.echoMode = mode }
+}
+
+// withSuggestions offers completions as the user types
+func withSuggestions(suggestions []string) fieldOption {
+	return func(o *fieldOptions) { o.suggestions = suggestions }
+}
+
+// withMaskedTranscript keeps the answer out of the echo line, the install
+// log and the answer records
+func withMaskedTranscript() fieldOption {
+	return func(o *fieldOptions) { o.masked = true }
+}
+
+// withRawInput only strips trailing line breaks instead of normalizing the
+// answer with normalizeText
+func withRawInput() fieldOption {
+	return func(o *fieldOptions) { o.normalize = trimNewline }
+}
+
+// withRequiredMessage replaces the error shown for an empty answer when there
+// is no default
+func withRequiredMessage(msg string) fieldOption {
+	return func(o *fieldOptions) { o.required = msg }
+}
 
-	// If no default value, this field is required
-	if defaultValue == "" {
-		input = input.Validate(func(s string) error {
-			if s == "" {
-				return fmt.Errorf("this field is required")
+// readValidated is the single text prompt every string reader is built on.
+// Answers are normalized first. An empty answer takes defaultValue, or is
+// rejected when there is none. Other answers must pass validate, a nil
+// validate accepts anything. The field asks again until the answer is
+// accepted.
+func readValidated(key, prompt, defaultValue string, validate func(string) error, opts ...fieldOption) string {
+	p := newTextPrompt(key, prompt, defaultValue, validate, opts...)
+	ask(p)
+	return p.value
+}
+
+// textPrompt is a text question, asked on its own by readValidated or
+// together with others by a formGroup
+type textPrompt struct {
+	key, prompt  string
+	defaultValue string
+	options      fieldOptions
+	check        func(string) error
+	value        string
+}
+
+func newTextPrompt(key, prompt, defaultValue string, validate func(string) error, opts ...fieldOption) *textPrompt {
+	p := &textPrompt{
+		key:          key,
+		prompt:       prompt,
+		defaultValue: installedDefault(key, defaultValue),
+		options:      fieldOptions{echoMode: huh.EchoModeNormal, required: tr("input.required"), normalize: normalizeText},
+	}
+	for _, opt := range opts {
+		opt(&p.options)
+	}
+	p.check = func(s string) error {
+		s = p.options.normalize(s)
+		if s == backAnswer && isAccessibleMode() && session.requestBack() {
+			return nil
+		}
+		if s == "" {
+			if p.defaultValue == "" {
+				return errors.New(p.options.required)
 			}
 			return nil
-		})
+		}
+		if validate == nil {
+			return nil
+		}
+		return validate(s)
 	}
+	return p
+}
 
-	err := runField(input)
-	handleAbort(err)
+func (p *textPrompt) name() (string, string) { return p.key, p.prompt }
+
+func (p *textPrompt) shown(value string) string {
+	if p.options.masked {
+		return "********"
+	}
+	return value
+}
 
+func (p *textPrompt) preset() bool {
+	value, source, ok := presetAnswer(p.key, p.defaultValue, p.defaultValue != "")
+	if !ok {
+		return false
+	}
+	// A default is checked too, --yes would otherwise take one the prompt
+	// rejects, e.g. a wildcard below a base domain nothing answered
+	if source != sourceDefault || value != "" {
+		if err := p.check(value); err != nil {
+			invalidPromptFlag(p.key, value, err)
+		}
+	}
+	value = p.options.normalize(value)
 	if value == "" {
-		value = defaultValue
+		value, source = p.defaultValue, sourceDefault
+	}
+	logAnswer(p.key, p.prompt, value, source, p.options.masked)
+	fmt.Fprintf(consoleOut, "%s: %s\n", p.prompt, p.shown(value))
+	p.value = value
+	return true
+}
+
+func (p *textPrompt) field() huh.Field {
+	title := p.prompt
+	if p.defaultValue != "" {
+		title = tr("input.with_default", p.prompt, p.shown(p.defaultValue))
+	}
+	input := huh.NewInput().
+		Title(title).
+		Value(&p.value).
+		EchoMode(p.options.echoMode).
+		Validate(p.check)
+	if len(p.options.suggestions) > 0 {
+		input = input.Suggestions(p.options.suggestions)
+	}
+	return input
+}
+
+func (p *textPrompt) record() {
+	p.value = p.options.normalize(p.value)
+	source := sourcePrompt
+	if p.value == "" {
+		p.value, source = p.defaultValue, sourceDefault
 	}
+	logAnswer(p.key, p.prompt, p.value, source, p.options.masked)
+	session.answered(sessionAnswer{Key: p.key, Prompt: p.prompt, Value: p.value, Default: source == sourceDefault, Masked: p.options.masked})
 
 	// Print the answer so it remains visible in terminal history (skip in accessible mode as it already shows)
 	if !isAccessibleMode() {
-		fmt.Printf("%s: %s\n", prompt, value)
+		fmt.Fprintf(consoleOut, "%s: %s\n", p.prompt, p.shown(p.value))
 	}
+}
 
-	return value
+func readString(key, prompt string, defaultValue string) string {
+	return readValidated(key, prompt, defaultValue, nil)
 }
 
-func readPassword(prompt string) string {
-	var value string
+// readDomain reads a domain name, asking again until it is valid
+func readDomain(key, prompt, defaultValue string) string {
+	domain, _ := validateDomain(readValidated(key, prompt, defaultValue, func(s string) error {
+		_, err := validateDomain(s)
+		return err
+	}))
+	return domain
+}
 
-	for {
-		input := huh.NewInput().
-			Title(prompt).
-			Value(&value).
-			EchoMode(huh.EchoModePassword).
-			Validate(func(s string) error {
-				if s == "" {
-					return fmt.Errorf("password is required")
-				}
-				return nil
-			})
+// readEmail reads an email address, asking again until it looks valid
+func readEmail(key, prompt, defaultValue string) string {
+	return readValidated(key, prompt, defaultValue, validateEmail)
+}
 
-		err := runField(input)
-		handleAbort(err)
+// validateEmail rejects answers that cannot be a single address. Whether the
+// mailbox exists is up to the mail server.
+func validateEmail(s string) error {
+	if strings.ContainsFunc(s, unicode.IsSpace) {
+		return errors.New(tr("input.email_spaces", s))
+	}
+	local, domain, ok := strings.Cut(s, "@")
+	if !ok || local == "" || strings.Contains(domain, "@") || !strings.Contains(strings.Trim(domain, "."), ".") {
+		return errors.New(tr("input.email_invalid", s))
+	}
+	return nil
+}
 
-		if value != "" {
-			// Print confirmation without revealing the password
-			if !isAccessibleMode() {
-				fmt.Printf("%s: %s\n", prompt, "********")
-			}
-			return value
-		}
+// readStringSuggest is readString with suggestions offered as the user types.
+// Accessible mode takes plain text.
+func readStringSuggest(key, prompt, defaultValue string, suggestions []string) string {
+	return readValidated(key, prompt, defaultValue, nil, withSuggestions(suggestions))
+}
+
+func readPassword(key, prompt string) string {
+	return readPasswordValidated(key, prompt, nil)
+}
+
+// readPasswordValidated is readPassword for a new password that must pass
+// validate
+func readPasswordValidated(key, prompt string, validate func(string) error) string {
+	return readValidated(key, prompt, "", validate,
+		withEchoMode(huh.EchoModePassword),
+		withMaskedTranscript(),
+		withRawInput(),
+		withRequiredMessage(tr("input.password_required")))
+}
+
+// parseBool is the single place that turns a typed or configured answer into
+// a boolean. It accepts y/yes/true/1/on and n/no/false/0/off in any case, and
+// the Yes and No of the selected language.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "y", "yes", "true", "1", "on", strings.ToLower(tr("input.yes")):
+		return true, nil
+	case "n", "no", "false", "0", "off", strings.ToLower(tr("input.no")):
+		return false, nil
 	}
+	return false, errors.New(tr("input.invalid_bool", s))
 }
 
-func readBool(prompt string, defaultValue bool) bool {
-	var value = defaultValue
+// confirmField returns the field for a yes/no prompt. Accessible mode reads a
+// line and parses it with parseBool, where the huh confirm only knows y/n.
+// answered is cleared when an empty line accepts the default.
+func confirmField(prompt string, value, answered *bool) huh.Field {
+	*answered = true
+	if !isAccessibleMode() {
+		return huh.NewConfirm().
+			Title(prompt).
+			Value(value).
+			Affirmative(tr("input.yes")).
+			Negative(tr("input.no"))
+	}
 
-	confirm := huh.NewConfirm().
-		Title(prompt).
-		Value(&value).
-		Affirmative("Yes").
-		Negative("No")
+	opts := "[y/N]"
+	if *value {
+		opts = "[Y/n]"
+	}
+	var answer string
+	return huh.NewInput().
+		Title(prompt + " " + opts).
+		Value(&answer).
+		Validate(func(s string) error {
+			if strings.TrimSpace(s) == "" {
+				*answered = false
+				return nil
+			}
+			if strings.TrimSpace(s) == backAnswer && session.requestBack() {
+				return nil
+			}
+			*answered = true
+			parsed, err := parseBool(s)
+			if err == nil {
+				*value = parsed
+			}
+			return err
+		})
+}
+
+// readBool asks a yes/no question. A reconfigure offers the installed answer
+// instead of defaultValue, going back in the wizard the previous one.
+func readBool(key, prompt string, defaultValue bool) bool {
+	defaultValue = installedBoolDefault(key, defaultValue)
+	if value, ok := presetBool(key, prompt, defaultValue); ok {
+		return value
+	}
+	requireInteractive(key, prompt)
 
-	err := runField(confirm)
+	var value = defaultValue
+	var answered bool
+
+	err := runField(confirmField(prompt, &value, &answered))
 	handleAbort(err)
+	source := sourcePrompt
+	if !answered {
+		source = sourceDefault
+	}
+	logAnswer(key, prompt, strconv.FormatBool(value), source, false)
+	session.answered(sessionAnswer{Key: key, Prompt: prompt, Value: strconv.FormatBool(value), Default: !answered})
 
 	// Print the answer so it remains visible in terminal history
 	if !isAccessibleMode() {
-		answer := "No"
+		answer := tr("input.no")
 		if value {
-			answer = "Yes"
+			answer = tr("input.yes")
 		}
-		fmt.Printf("%s: %s\n", prompt, answer)
+		fmt.Fprintf(consoleOut, "%s: %s\n", prompt, answer)
 	}
 
 	return value
 }
 
-func readBoolNoDefault(prompt string) bool {
-	var value bool
+// presetBool answers a yes/no prompt from its flag or, with --yes, from
+// defaultValue
+func presetBool(key, prompt string, defaultValue bool) (bool, bool) {
+	answer, source, ok := presetAnswer(key, strconv.FormatBool(defaultValue), true)
+	if !ok {
+		return false, false
+	}
+	value, err := parseBool(answer)
+	if err != nil {
+		invalidPromptFlag(key, answer, err)
+	}
+	logAnswer(key, prompt, strconv.FormatBool(value), source, false)
+	shown := tr("input.no")
+	if value {
+		shown = tr("input.yes")
+	}
+	fmt.Fprintf(consoleOut, "%s: %s\n", prompt, shown)
+	return value, true
+}
+
+func readInt(key, prompt string, defaultValue int) int {
+	return readIntInRange(key, prompt, defaultValue, math.MinInt, math.MaxInt)
+}
+
+// readIntInRange reads a number between min and max inclusive
+func readIntInRange(key, prompt string, defaultValue, min, max int) int {
+	return readIntValidated(key, prompt, defaultValue, min, max, nil)
+}
+
+// readIntValidated is readIntInRange for a number that must also pass
+// validate, e.g. a port that must differ from the ones read before it
+func readIntValidated(key, prompt string, defaultValue, min, max int, validate func(int) error) int {
+	inRange := validateIntInRange(min, max)
+	value := readValidated(key, prompt, strconv.Itoa(defaultValue), func(s string) error {
+		if err := inRange(s); err != nil || validate == nil {
+			return err
+		}
+		n, _ := strconv.Atoi(s)
+		return validate(n)
+	})
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return result
+}
+
+// validateIntInRange accepts numbers between min and max inclusive
+func validateIntInRange(min, max int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New(tr("input.invalid_number"))
+		}
+		if n < min || n > max {
+			return errors.New(tr("input.number_range", min, max))
+		}
+		return nil
+	}
+}
+
+// Option is a choice of readSelect and readMultiSelect. Value is what flags,
+// answers files and the log use, Label what the user sees, with an optional
+// Description.
+type Option struct {
+	Value       string
+	Label       string
+	Description string
+}
+
+// plainOptions are options labeled with their values
+func plainOptions(values []string) []Option {
+	options := make([]Option, len(values))
+	for i, value := range values {
+		options[i] = Option{Value: value, Label: value}
+	}
+	return options
+}
+
+func optionValues(options []Option) []string {
+	values := make([]string, len(options))
+	for i, option := range options {
+		values[i] = option.Value
+	}
+	return values
+}
+
+// huhOptions turns options into the options of a select. huh shows no
+// description per option, so it follows the label.
+func huhOptions(options []Option, selected []string) []huh.Option[string] {
+	choices := make([]huh.Option[string], len(options))
+	for i, option := range options {
+		label := orDefault(option.Label, option.Value)
+		if option.Description != "" {
+			label += " – " + option.Description
+		}
+		choices[i] = huh.NewOption(label, option.Value).Selected(slices.Contains(selected, option.Value))
+	}
+	return choices
+}
+
+// optionLabels are the labels of the options with values, for the answer
+// printed after a select
+func optionLabels(options []Option, values []string) string {
+	labels := make([]string, len(values))
+	for i, value := range values {
+		labels[i] = value
+		for _, option := range options {
+			if option.Value == value && option.Label != "" {
+				labels[i] = option.Label
+			}
+		}
+	}
+	return strings.Join(labels, ", ")
+}
+
+// readChoice lets the user pick one of options
+func readChoice(key, prompt string, options []string, defaultValue string) string {
+	return readSelectValidated(key, prompt, plainOptions(options), defaultValue, nil)
+}
+
+// readChoiceValidated is readChoice with a check of the picked option, its
+// error is shown below the select until another option is picked
+func readChoiceValidated(key, prompt string, options []string, defaultValue string, validate func(string) error) string {
+	return readSelectValidated(key, prompt, plainOptions(options), defaultValue, validate)
+}
+
+// readSelect lets the user pick one of options from a list
+func readSelect(key, prompt string, options []Option, defaultValue string) string {
+	return readSelectValidated(key, prompt, options, defaultValue, nil)
+}
+
+// readSelectValidated is readSelect with a check of the picked option, its
+// error is shown below the select until another option is picked
+func readSelectValidated(key, prompt string, options []Option, defaultValue string, validate func(string) error) string {
+	p := newSelectPrompt(key, prompt, options, defaultValue, validate)
+	ask(p)
+	return p.value
+}
+
+// selectPrompt is a question picking one of options, asked on its own by
+// readSelect or together with others by a formGroup
+type selectPrompt struct {
+	key, prompt  string
+	options      []Option
+	defaultValue string
+	validate     func(string) error
+	value        string
+}
+
+func newSelectPrompt(key, prompt string, options []Option, defaultValue string, validate func(string) error) *selectPrompt {
+	if validate == nil {
+		validate = func(string) error { return nil }
+	}
+	defaultValue = installedDefault(key, defaultValue)
+	return &selectPrompt{key: key, prompt: prompt, options: options, defaultValue: defaultValue, validate: validate, value: defaultValue}
+}
+
+func (p *selectPrompt) name() (string, string) { return p.key, p.prompt }
+
+func (p *selectPrompt) preset() bool {
+	value, source, ok := presetAnswer(p.key, p.defaultValue, true)
+	if !ok {
+		return false
+	}
+	p.value = value
+	if value == "" && source == sourceDefault {
+		return true
+	}
+	if values := optionValues(p.options); !slices.Contains(values, value) {
+		invalidPromptFlag(p.key, value, errors.New(tr("input.not_an_option", strings.Join(values, ", "))))
+	}
+	if err := p.validate(value); err != nil {
+		invalidPromptFlag(p.key, value, err)
+	}
+	logAnswer(p.key, p.prompt, value, source, false)
+	fmt.Fprintf(consoleOut, "%s: %s\n", p.prompt, optionLabels(p.options, []string{value}))
+	return true
+}
 
-	confirm := huh.NewConfirm().
+func (p *selectPrompt) field() huh.Field {
+	options := huhOptions(p.options, nil)
+	// Accessible mode has no back key, it offers going back as an option
+	if isAccessibleMode() && session.canGoBack() {
+		options = append(options, huh.NewOption(tr("input.go_back"), goBackValue))
+	}
+	return huh.NewSelect[string]().
+		Title(p.prompt).
+		Options(options...).
+		Value(&p.value).
+		Validate(func(value string) error {
+			if value == goBackValue && session.requestBack() {
+				return nil
+			}
+			return p.validate(value)
+		})
+}
+
+func (p *selectPrompt) record() {
+	logAnswer(p.key, p.prompt, p.value, sourcePrompt, false)
+	session.answered(sessionAnswer{Key: p.key, Prompt: p.prompt, Value: p.value, Default: p.value == p.defaultValue})
+
+	// Print the answer so it remains visible in terminal history
+	if !isAccessibleMode() {
+		fmt.Fprintf(consoleOut, "%s: %s\n", p.prompt, optionLabels(p.options, []string{p.value}))
+	}
+}
+
+// readMultiChoice lets the user pick any number of options, defaults are
+// preselected
+func readMultiChoice(key, prompt string, options []string, defaults []string) []string {
+	return readMultiSelect(key, prompt, plainOptions(options), defaults)
+}
+
+// readMultiSelect lets the user pick any number of options from a list,
+// defaults are preselected. Presets list the values separated by commas.
+func readMultiSelect(key, prompt string, options []Option, defaults []string) []string {
+	if previous, ok := previousAnswer(key); ok {
+		defaults = strings.Split(previous, ",")
+	}
+	known := optionValues(options)
+	if answer, source, ok := presetAnswer(key, strings.Join(defaults, ","), true); ok {
+		var values []string
+		for _, value := range strings.Split(answer, ",") {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			if !slices.Contains(known, value) {
+				invalidPromptFlag(key, answer, errors.New(tr("input.unknown_option", value, strings.Join(known, ", "))))
+			}
+			values = append(values, value)
+		}
+		logAnswer(key, prompt, strings.Join(values, ","), source, false)
+		fmt.Fprintf(consoleOut, "%s: %s\n", prompt, optionLabels(options, values))
+		return values
+	}
+	requireInteractive(key, prompt)
+
+	var values []string
+	multiSelect := huh.NewMultiSelect[string]().
 		Title(prompt).
-		Value(&value).
-		Affirmative("Yes").
-		Negative("No")
+		Options(huhOptions(options, defaults)...).
+		Value(&values)
 
-	err := runField(confirm)
+	err := runField(multiSelect)
 	handleAbort(err)
+	logAnswer(key, prompt, strings.Join(values, ","), sourcePrompt, false)
+	session.answered(sessionAnswer{Key: key, Prompt: prompt, Value: strings.Join(values, ","), Default: slices.Equal(values, defaults)})
 
 	// Print the answer so it remains visible in terminal history
 	if !isAccessibleMode() {
-		answer := "No"
-		if value {
-			answer = "Yes"
-		}
-		fmt.Printf("%s: %s\n", prompt, answer)
+		fmt.Fprintf(consoleOut, "%s: %s\n", prompt, optionLabels(options, values))
 	}
 
-	return value
+	return values
 }
 
-func readInt(prompt string, defaultValue int) int {
-	var value string
+// confirmPhrases are the phrases passed with --confirm. They stand in for
+// typed confirmations so irreversible operations can be automated.
+var confirmPhrases []string
+
+// addConfirmFlag registers the repeatable --confirm flag on fs
+func addConfirmFlag(fs *flag.FlagSet) {
+	fs.Func("confirm", "Confirm an irreversible operation by passing the phrase it asks for (repeatable)", func(phrase string) error {
+		confirmPhrases = append(confirmPhrases, phrase)
+		return nil
+	})
+}
+
+// confirmedByFlag reports whether phrase was passed with --confirm
+func confirmedByFlag(phrase string) bool {
+	return phrase != "" && slices.Contains(confirmPhrases, phrase)
+}
 
-	title := fmt.Sprintf("%s (default: %d)", prompt, defaultValue)
+// readConfirmation guards an irreversible operation by requiring phrase to be
+// typed exactly (or passed with --confirm). Anything else declines, and so
+// does --yes, which only takes defaults.
+func readConfirmation(key, prompt, phrase string) bool {
+	if confirmedByFlag(phrase) {
+		logf("INFO", "confirmation %s: provided via --confirm", key)
+		return true
+	}
+	if acceptDefaults {
+		logf("INFO", "confirmation %s: declined (--yes does not confirm, pass --confirm %q)", key, phrase)
+		fmt.Fprintf(consoleOut, "%s: %s\n", prompt, tr("input.not_confirmed_yes", phrase))
+		return false
+	}
+	requireInteractive(key, prompt)
 
+	// Accessible mode only prints the title, so the phrase goes there too
+	title := prompt
+	if isAccessibleMode() {
+		title = prompt + " " + tr("input.confirm_hint", phrase)
+	}
+	var value string
 	input := huh.NewInput().
 		Title(title).
-		Value(&value).
-		Validate(func(s string) error {
-			if s == "" {
-				return nil
-			}
-			_, err := strconv.Atoi(s)
-			if err != nil {
-				return fmt.Errorf("please enter a valid number")
-			}
-			return nil
-		})
+		Description(tr("input.confirm_description", phrase)).
+		Value(&value)
 
 	err := runField(input)
 	handleAbort(err)
 
-	if value == "" {
-		// Print the answer so it remains visible in terminal history
+	if normalizeText(value) != phrase {
+		logf("INFO", "confirmation %s: declined (typed phrase did not match)", key)
 		if !isAccessibleMode() {
-			fmt.Printf("%s: %d\n", prompt, defaultValue)
+			fmt.Fprintf(consoleOut, "%s: %s\n", prompt, tr("input.not_confirmed"))
 		}
-		return defaultValue
+		return false
 	}
 
-	result, err := strconv.Atoi(value)
-	if err != nil {
-		if !isAccessibleMode() {
-			fmt.Printf("%s: %d\n", prompt, defaultValue)
-		}
-		return defaultValue
+	mechanism := "interactive prompt"
+	if isAccessibleMode() {
+		mechanism = "accessible prompt"
 	}
-
-	// Print the answer so it remains visible in terminal history
+	logf("INFO", "confirmation %s: provided via %s", key, mechanism)
 	if !isAccessibleMode() {
-		fmt.Printf("%s: %d\n", prompt, result)
+		fmt.Fprintf(consoleOut, "%s: %s\n", prompt, tr("input.confirmed"))
 	}
-
-	return result
+	return true
 }
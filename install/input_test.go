@@ -0,0 +1,490 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestValidators feeds every validator a prompt is read with through
+// readValidated answers it must accept and answers it must reject
+func TestValidators(t *testing.T) {
+	dashboard := []string{"pangolin.example.com"}
+	existing := []TLSPassthrough{{SNI: "git.example.com", Backend: "10.0.0.2:443"}}
+	domain := func(s string) error {
+		_, err := validateDomain(s)
+		return err
+	}
+	wildcard := func(s string) error {
+		_, err := validateWildcardDomain(s, "example.com")
+		return err
+	}
+	port := validateIntInRange(1, 65535)
+	portNot := func(validate func(int) error) func(string) error {
+		return func(s string) error {
+			if err := port(s); err != nil {
+				return err
+			}
+			n, _ := strconv.Atoi(s)
+			return validate(n)
+		}
+	}
+	httpPort := 8080
+	apiPort := 3000
+
+	tests := []struct {
+		name     string
+		validate func(string) error
+		accept   []string
+		reject   []string
+	}{
+		{"domain", domain,
+			[]string{"example.com", "Example.COM.", "a-b.example.co.uk", " example.com "},
+			[]string{"example", "https://example.com", "*.example.com", "exa mple.com", "-a.example.com", "a..com", "user@example.com", "example.com:443"}},
+		{"wildcard domain", wildcard,
+			[]string{"*.example.com", "example.com", "*.apps.example.com", "*.APPS.example.com."},
+			[]string{"*.*.example.com", "*.example.org", "*.", "", "a..example.com", "badexample.com"}},
+		{"email", validateEmail,
+			[]string{"admin@example.com", "a.b+c@sub.example.org"},
+			[]string{"admin", "admin@example", "admin@@example.com", "a b@example.com", "@example.com", "admin@.com."}},
+		{"number in range", validateIntInRange(1, 365),
+			[]string{"1", "30", "365"},
+			[]string{"0", "366", "-1", "ten", "1.5", ""}},
+		{"port", port,
+			[]string{"1", "443", "65535"},
+			[]string{"0", "65536", "http"}},
+		{"wireguard port", portNot(validateUDPPort),
+			[]string{"51820", "51821"},
+			[]string{"21820", "0"}},
+		{"https port", portNot(func(p int) error { return validateHostPorts(map[int]string{51820: "Gerbil"}, httpPort, p) }),
+			[]string{"443", "8443"},
+			[]string{"8080", "51820"}},
+		{"proxy api port", portNot(func(p int) error { return validateHostPorts(nil, apiPort+1, p) }),
+			[]string{"3000", "8000"},
+			[]string{"3001"}},
+		{"server port", portNot(func(p int) error {
+			ports := pangolinPorts{External: 3000, Internal: 3001}
+			return validateServerPorts(append(ports.fields()[:2], serverPortField{"dashboard", "server_next_port", &p}))
+		}),
+			[]string{"3002", "4000"},
+			[]string{"3000", "3001"}},
+		{"proxy bind address", validateProxyBindAddress,
+			[]string{"127.0.0.1", "0.0.0.0", "10.0.0.5"},
+			[]string{"localhost", "::1", "10.0.0", "256.1.1.1"}},
+		{"passthrough sni", func(s string) error { return validatePassthroughSNI(s, existing, dashboard) },
+			[]string{"vpn.example.com", "*.internal.example.com", "VPN.example.com"},
+			[]string{"git.example.com", "GIT.example.com", "pangolin.example.com", "*.example.com", "not a host", "example", "https://vpn.example.com"}},
+		{"passthrough backend", validatePassthroughBackend,
+			[]string{"10.0.0.2:443", "backend.lan:8443", "[fd00::2]:443"},
+			[]string{"10.0.0.2", "backend.lan:", "backend.lan"}},
+		{"admin password", validateAdminPassword,
+			[]string{"Passw0rd!", "Correct-Horse-Battery-9"},
+			[]string{"Pa0!", "password1!", "PASSWORD1!", "Password!!", "Password12"}},
+		{"cron expression", validateCronExpression,
+			[]string{"0 4 * * 0", "*/15 * * * *", "0 3 1-7 * mon", "@daily", "30 2 * jan,jul *"},
+			[]string{"0 4 * *", "60 4 * * 0", "0 24 * * *", "@fortnightly", "0 4 * * funday", "a b c d e"}},
+		{"backup dir", validateBackupDir,
+			[]string{"/var/backups/pangolin", "/backups"},
+			[]string{"backups", "./backups", "~/backups"}},
+		{"log size", validateLogSize,
+			[]string{"10m", "1g", "500k"},
+			[]string{"10", "0m", "10mb", "m", "10 m"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, value := range tt.accept {
+				if err := tt.validate(value); err != nil {
+					t.Errorf("rejected %q: %v", value, err)
+				}
+			}
+			for _, value := range tt.reject {
+				if err := tt.validate(value); err == nil {
+					t.Errorf("accepted %q", value)
+				}
+			}
+		})
+	}
+}
+
+// TestReadValidatedInvalidPreset checks that a preset the validator rejects
+// exits the run instead of asking again, from each source
+func TestReadValidatedInvalidPreset(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{"flag", func(t *testing.T) { promptAnswers["admin_email"] = "admin" }},
+		{"environment", func(t *testing.T) { t.Setenv(answerEnvName("admin_email"), "admin") }},
+		{"answers file", func(t *testing.T) {
+			promptAnswers["admin_email"] = "admin"
+			fileAnswers["admin_email"] = true
+			t.Cleanup(func() { delete(fileAnswers, "admin_email") })
+			answersFile = "answers.yml"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAnswers(t, nil)
+			tt.setup(t)
+			code := catchExit(t, func() { readEmail("admin_email", "Admin email", "") })
+			if code != exitInvalidInput {
+				t.Fatalf("exit code %d, want %d", code, exitInvalidInput)
+			}
+		})
+	}
+}
+
+// TestReadIntValidatedPreset checks the extra validation of numbers read with
+// readIntValidated
+func TestReadIntValidatedPreset(t *testing.T) {
+	withAnswers(t, map[string]string{"wireguard_port": "21820"})
+	code := catchExit(t, func() {
+		readIntValidated("wireguard_port", "WireGuard port", defaultWireGuardPort, 1, 65535, validateUDPPort)
+	})
+	if code != exitInvalidInput {
+		t.Fatalf("exit code %d, want %d", code, exitInvalidInput)
+	}
+
+	withAnswers(t, map[string]string{"wireguard_port": "51821"})
+	if got := readIntValidated("wireguard_port", "WireGuard port", defaultWireGuardPort, 1, 65535, validateUDPPort); got != 51821 {
+		t.Errorf("got %d, want 51821", got)
+	}
+}
+
+// TestParseBool checks every spelling a yes/no answer accepts, in any case and
+// with surrounding whitespace, and that anything else is rejected with the
+// answer in the error
+func TestParseBool(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+		ok   bool
+	}{
+		{"y", true, true},
+		{"yes", true, true},
+		{"true", true, true},
+		{"1", true, true},
+		{"on", true, true},
+		{"n", false, true},
+		{"no", false, true},
+		{"false", false, true},
+		{"0", false, true},
+		{"off", false, true},
+		{"Y", true, true},
+		{"YeS", true, true},
+		{"TRUE", true, true},
+		{"On", true, true},
+		{"N", false, true},
+		{"No", false, true},
+		{"False", false, true},
+		{"OFF", false, true},
+		{" yes", true, true},
+		{"no\n", false, true},
+		{"\ttrue\t", true, true},
+		{"", false, false},
+		{" ", false, false},
+		{"ja", false, false},
+		{"yess", false, false},
+		{"2", false, false},
+		{"-1", false, false},
+		{"y es", false, false},
+		{"enable", false, false},
+	}
+	for _, tt := range tests {
+		got, err := parseBool(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseBool(%q) error %v, want ok %t", tt.in, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			if want := strconv.Quote(tt.in); !strings.Contains(err.Error(), want) {
+				t.Errorf("parseBool(%q) error %q does not name the answer", tt.in, err)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBool(%q) = %t, want %t", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestReadBool checks where a yes/no prompt answered without asking takes its
+// value from: a preset, else the installed answer, else the default
+func TestReadBool(t *testing.T) {
+	savedInstalled, savedConsole := installedAnswers, consoleOut
+	t.Cleanup(func() { installedAnswers, consoleOut = savedInstalled, savedConsole })
+	consoleOut = io.Discard
+
+	tests := []struct {
+		name      string
+		preset    map[string]string
+		installed map[string]string
+		def       bool
+		want      bool
+	}{
+		{"default", nil, nil, true, true},
+		{"default no", nil, nil, false, false},
+		{"preset", map[string]string{"enterprise": "on"}, nil, false, true},
+		{"preset over installed", map[string]string{"enterprise": "no"}, map[string]string{"enterprise": "true"}, false, false},
+		{"installed over default", nil, map[string]string{"enterprise": "true"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAnswers(t, tt.preset)
+			acceptDefaults = true
+			installedAnswers = tt.installed
+			if got := readBool("enterprise", "Enterprise", tt.def); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid preset", func(t *testing.T) {
+		withAnswers(t, map[string]string{"enterprise": "maybe"})
+		installedAnswers = nil
+		if code := catchExit(t, func() { readBool("enterprise", "Enterprise", false) }); code != exitInvalidInput {
+			t.Fatalf("exit code %d, want %d", code, exitInvalidInput)
+		}
+	})
+}
+
+// TestReadStringSuggestUnattended checks when a prompt read without asking
+// takes its default and when a missing answer fails the run: --yes takes the
+// default or collects the prompt as missing, --non-interactive never takes a
+// default on its own
+func TestReadStringSuggestUnattended(t *testing.T) {
+	savedInstalled, savedConsole := installedAnswers, consoleOut
+	t.Cleanup(func() { installedAnswers, consoleOut = savedInstalled, savedConsole })
+	consoleOut = io.Discard
+	suggestions := []string{"UTC", "Europe/Berlin"}
+
+	tests := []struct {
+		name           string
+		yes            bool
+		nonInteractive bool
+		collecting     bool
+		flags          map[string]string
+		installed      map[string]string
+		def            string
+		want           string
+		source         answerSource
+		exit           int
+		missing        bool
+	}{
+		{name: "yes takes the default", yes: true, def: "UTC", want: "UTC", source: sourceDefault, exit: -1},
+		{name: "yes takes the installed answer", yes: true, installed: map[string]string{"timezone": "Europe/Berlin"}, want: "Europe/Berlin", source: sourceDefault, exit: -1},
+		{name: "yes prefers a flag", yes: true, flags: map[string]string{"timezone": "Asia/Tokyo"}, def: "UTC", want: "Asia/Tokyo", source: sourceFlag, exit: -1},
+		{name: "yes without a default", yes: true, exit: exitInvalidInput},
+		{name: "yes collects a missing answer", yes: true, collecting: true, want: "", source: sourceDefault, exit: -1, missing: true},
+		{name: "non-interactive ignores the default", nonInteractive: true, def: "UTC", exit: exitInvalidInput},
+		{name: "non-interactive without a default", nonInteractive: true, exit: exitInvalidInput},
+		{name: "non-interactive with a flag", nonInteractive: true, flags: map[string]string{"timezone": "Asia/Tokyo"}, want: "Asia/Tokyo", source: sourceFlag, exit: -1},
+		{name: "both take the default", yes: true, nonInteractive: true, def: "UTC", want: "UTC", source: sourceDefault, exit: -1},
+		{name: "both without a default", yes: true, nonInteractive: true, exit: exitInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAnswers(t, tt.flags)
+			resetAnswerRecords(t)
+			acceptDefaults, nonInteractive, collectingAnswers = tt.yes, tt.nonInteractive, tt.collecting
+			installedAnswers = tt.installed
+
+			var got string
+			code := catchExit(t, func() { got = readStringSuggest("timezone", "Time zone", tt.def, suggestions) })
+			if code != tt.exit {
+				t.Fatalf("exit code %d, want %d", code, tt.exit)
+			}
+			if answerMissing("timezone") != tt.missing {
+				t.Errorf("missing %t, want %t", answerMissing("timezone"), tt.missing)
+			}
+			if code != -1 {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			records := answerRecords()
+			if len(records) != 1 || records[0].Source != tt.source {
+				t.Errorf("recorded %v, want one answer from %s", records, tt.source)
+			}
+		})
+	}
+}
+
+// TestTextPromptRequired checks the validation of a typed answer: an empty
+// one takes the default and is rejected without one, suggestions only
+// complete and restrict nothing
+func TestTextPromptRequired(t *testing.T) {
+	savedInstalled := installedAnswers
+	t.Cleanup(func() { installedAnswers = savedInstalled })
+	installedAnswers = nil
+
+	tests := []struct {
+		def, in string
+		ok      bool
+	}{
+		{"UTC", "", true},
+		{"UTC", "   ", true},
+		{"", "", false},
+		{"", " \t", false},
+		{"", "​", false},
+		{"", "UTC", true},
+		{"", "Mars/Olympus_Mons", true},
+	}
+	for _, tt := range tests {
+		p := newTextPrompt("timezone", "Time zone", tt.def, nil, withSuggestions([]string{"UTC"}))
+		if err := p.check(tt.in); (err == nil) != tt.ok {
+			t.Errorf("default %q, answer %q: error %v, want ok %t", tt.def, tt.in, err, tt.ok)
+		}
+	}
+
+	p := newTextPrompt("admin_password", "Password", "", nil, withRequiredMessage("a password is required"))
+	if err := p.check(""); err == nil || err.Error() != "a password is required" {
+		t.Errorf("error %v, want the required message", err)
+	}
+}
+
+// TestReadConfirmation checks that only the phrase passed with --confirm
+// confirms without a terminal: --yes declines and --non-interactive exits
+func TestReadConfirmation(t *testing.T) {
+	savedPhrases, savedConsole := confirmPhrases, consoleOut
+	t.Cleanup(func() { confirmPhrases, consoleOut = savedPhrases, savedConsole })
+	consoleOut = io.Discard
+
+	tests := []struct {
+		name          string
+		phrases       []string
+		yes, noninter bool
+		want          bool
+		wantExit      int
+	}{
+		{"--confirm", []string{"example.com"}, false, false, true, -1},
+		{"--confirm with --yes", []string{"other", "example.com"}, true, false, true, -1},
+		{"--confirm with --non-interactive", []string{"example.com"}, false, true, true, -1},
+		{"--yes", nil, true, false, false, -1},
+		{"--yes with another phrase", []string{"example"}, true, false, false, -1},
+		{"--non-interactive", nil, false, true, false, exitInvalidInput},
+		{"--yes with --non-interactive", nil, true, true, false, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAnswers(t, nil)
+			acceptDefaults, nonInteractive, confirmPhrases = tt.yes, tt.noninter, tt.phrases
+			var got bool
+			code := catchExit(t, func() { got = readConfirmation("uninstall_delete_data", "Delete?", "example.com") })
+			if code != tt.wantExit {
+				t.Fatalf("exit code %d, want %d", code, tt.wantExit)
+			}
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConfirmationPrompts runs every irreversible operation on an existing
+// install without its phrase. --non-interactive must exit asking for the
+// typed confirmation, and --yes must decline it and change nothing.
+func TestConfirmationPrompts(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		crowdsec bool
+		answers  map[string]string
+		run      func(t *testing.T, dir string)
+	}{
+		{"upgrade", "confirm_upgrade", false, nil, func(t *testing.T, dir string) {
+			runUpgrade([]string{"--dir", dir, "--offline", "--version", "v1.12.1"})
+		}},
+		{"rollback", "confirm_rollback", false, nil, func(t *testing.T, dir string) {
+			runRollback([]string{"--dir", dir})
+		}},
+		{"remove-crowdsec", "confirm_remove_crowdsec", true, nil, func(t *testing.T, dir string) {
+			runRemoveCrowdsec()
+		}},
+		{"uninstall", "uninstall_delete_data", false, map[string]string{"uninstall_stack": "false", "uninstall_external": "false", "uninstall_images": "false"}, func(t *testing.T, dir string) {
+			runUninstall([]string{"--dir", dir})
+		}},
+	}
+	for _, tt := range tests {
+		for _, yes := range []bool{false, true} {
+			name := tt.name + "/non-interactive"
+			if yes {
+				name = tt.name + "/yes"
+			}
+			t.Run(name, func(t *testing.T) {
+				dir := existingInstall(t, tt.crowdsec)
+				withAnswers(t, tt.answers)
+				savedLog, savedVersion, savedPhrases := logFilePath, upgradeVersion, confirmPhrases
+				t.Cleanup(func() { logFilePath, upgradeVersion, confirmPhrases = savedLog, savedVersion, savedPhrases })
+				logFilePath, confirmPhrases = filepath.Join(t.TempDir(), installLogName), nil
+				fakeRunningStack(t)
+				if tt.name == "rollback" {
+					if _, err := takeSnapshot(dir, "test", Undefined); err != nil {
+						t.Fatal(err)
+					}
+				}
+				before := treeState(t, dir)
+				acceptDefaults, nonInteractive = yes, !yes
+
+				code := catchExit(t, func() { tt.run(t, dir) })
+				if !yes {
+					log, _ := os.ReadFile(logFilePath)
+					if code != exitInvalidInput || !strings.Contains(string(log), strconv.Quote(tt.key)+" requires input") {
+						t.Fatalf("exit code %d without asking for %s:\n%s", code, tt.key, log)
+					}
+					return
+				}
+				if code != -1 {
+					t.Fatalf("exit code %d", code)
+				}
+				after := treeState(t, dir)
+				for path, state := range after {
+					if before[path] != state {
+						t.Errorf("%s was written", path)
+					}
+				}
+				for path := range before {
+					if _, ok := after[path]; !ok {
+						t.Errorf("%s was removed", path)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestForceOverwriteConfirmation checks that --force-overwrite replaces a
+// changed file only after the confirmation, and asks file by file otherwise
+func TestForceOverwriteConfirmation(t *testing.T) {
+	savedForce, savedPhrases, savedConsole := forceOverwrite, confirmPhrases, consoleOut
+	t.Cleanup(func() { forceOverwrite, confirmPhrases, consoleOut = savedForce, savedPhrases, savedConsole })
+	consoleOut = io.Discard
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("docker-compose.yml", []byte("# edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := []renderedFile{{Path: "config/docker-compose.yml", Content: []byte("services: {}\n")}}
+
+	tests := []struct {
+		name    string
+		phrases []string
+		want    string
+	}{
+		{"--confirm", []string{forceOverwritePhrase}, "config/docker-compose.yml"},
+		{"--yes", nil, "docker-compose.yml.new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAnswers(t, nil)
+			acceptDefaults, forceOverwrite, confirmPhrases = true, true, tt.phrases
+			resolved := resolveOverwrites(files)
+			if len(resolved) != 1 || resolved[0].Path != tt.want {
+				t.Fatalf("resolved to %+v, want %s", resolved, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// minInstallSpace is the free space the install and data directories need for
+// the database, certificates and logs. Images live in the runtime's storage.
+const minInstallSpace = 1 << 30
+
+// dataDir is set by --data-dir and holds the database and Redis volumes
+// instead of the install directory
+var dataDir string
+
+func addDataDirFlag(fs *flag.FlagSet) {
+	fs.Func("data-dir", "Directory for the database and Redis volumes, e.g. on another disk (default: the install directory)", func(dir string) error {
+		abs, err := filepath.Abs(expandHome(dir))
+		if err != nil {
+			return err
+		}
+		dataDir = abs
+		return nil
+	})
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		fatalf("Error getting home directory: %v\n", err)
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// HostPath is the host side of a bind mount of rel, a path inside the install
+// directory. Configs rendered without an install directory keep the
+// relative ./rel of older installers.
+func (c Config) HostPath(rel string) string {
+	if c.InstallDir == "" {
+		return "./" + rel
+	}
+	return filepath.Join(c.InstallDir, rel)
+}
+
+// DataPath is the host side of the bind mount of the data volume rel
+func (c Config) DataPath(rel string) string {
+	if c.DataDir == "" {
+		return c.HostPath(rel)
+	}
+	return filepath.Join(c.DataDir, rel)
+}
+
+// SeparateDataDir reports whether the data volumes live outside the install
+// directory, a SQLite database is then mounted from there too
+func (c Config) SeparateDataDir() bool {
+	return c.DataDir != "" && c.DataDir != c.InstallDir
+}
+
+// checkInstallPath verifies that dir, or the closest parent that exists when
+// it has not been created yet, is writable and has minInstallSpace free
+func checkInstallPath(dir string) error {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", existing)
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return err
+		}
+		existing = parent
+	}
+	if err := syscall.Access(existing, 2); err != nil {
+		return fmt.Errorf("%s is not writable: %v", existing, err)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(existing, &stat); err != nil {
+		logf("WARN", "could not check the free space of %s: %v", existing, err)
+		return nil
+	}
+	if free := stat.Bavail * uint64(stat.Bsize); free < minInstallSpace {
+		return fmt.Errorf("only %s free on the filesystem of %s, at least %s needed", formatGiB(free), dir, formatGiB(minInstallSpace))
+	}
+	return nil
+}
+
+func formatGiB(n uint64) string {
+	return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+}
+
+// prepareDataDir checks and creates the --data-dir directory. Only the
+// containers and root need to read it.
+func prepareDataDir(installDir string) string {
+	if dataDir == "" || dataDir == installDir {
+		return ""
+	}
+	if err := checkInstallPath(dataDir); err != nil {
+		exitf(exitPreflight, "Error: cannot use %s as data directory: %v\n", dataDir, err)
+	}
+	if err := os.MkdirAll(dataDir, 0750); err != nil {
+		fatalf("Error creating data directory: %v\n", err)
+	}
+	infof("Data directory: %s\n", dataDir)
+	return dataDir
+}
+
+// recordDataDir notes a separate data directory in the state file
+func recordDataDir(dir string) {
+	if dir == "" {
+		return
+	}
+	state, err := loadInstallState(".")
+	if err != nil {
+		logf("WARN", "could not read %s: %v", installStateFile, err)
+		return
+	}
+	state.DataDir = dir
+	if err := state.save("."); err != nil {
+		logf("WARN", "could not write %s: %v", installStateFile, err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Labels on every container, network and volume the installer creates, so
+// cleanup and discovery never depend on names a user may reuse
+const (
+	labelManaged = "pangolin.installer.managed"
+	labelStack   = "pangolin.installer.stack"
+	labelVersion = "pangolin.installer.version"
+)
+
+// installerStack is the compose project name of the generated stack
+const installerStack = "pangolin"
+
+// legacyContainerNames are the container names of installs made before the
+// labels existed
+var legacyContainerNames = []string{"pangolin", "gerbil", "traefik", "crowdsec", "postgres", "redis"}
+
+// installerLabels returns the labels for new resources. The version is the
+// installer that first created the resource.
+func installerLabels() [][2]string {
+	return [][2]string{{labelManaged, "true"}, {labelStack, installerStack}, {labelVersion, pangolinVersion}}
+}
+
+// labelComposeResources adds the installer labels to every service, network
+// and volume of a compose file that does not carry them yet. Labels in both
+// the list and the map syntax are supported.
+func labelComposeResources(root *yaml.Node) []configChange {
+	var changes []configChange
+	for _, section := range []string{"services", "networks", "volumes"} {
+		resources := yamlMapValue(root, section)
+		if resources == nil || resources.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(resources.Content); i += 2 {
+			name, resource := resources.Content[i].Value, resources.Content[i+1]
+			if resource.Kind != yaml.MappingNode {
+				// A network or volume without options is written as "name:"
+				if resource.Tag != "!!null" {
+					continue
+				}
+				*resource = yaml.Node{Kind: yaml.MappingNode}
+			}
+			if external := yamlMapValue(resource, "external"); external != nil && external.Value == "true" {
+				continue
+			}
+			if addLabels(resource) {
+				changes = append(changes, configChange{Kind: "added", Key: section + "." + name + ".labels"})
+			}
+		}
+	}
+	return changes
+}
+
+// addLabels sets the missing installer labels of a compose resource
+func addLabels(resource *yaml.Node) bool {
+	labels := yamlMapValue(resource, "labels")
+	if labels == nil {
+		labels = &yaml.Node{Kind: yaml.MappingNode}
+		yamlSetKey(resource, "labels", labels)
+	}
+
+	added := false
+	for _, label := range installerLabels() {
+		key, value := label[0], label[1]
+		switch labels.Kind {
+		case yaml.SequenceNode:
+			if !hasListLabel(labels, key) {
+				labels.Content = append(labels.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key + "=" + value})
+				added = true
+			}
+		case yaml.MappingNode:
+			if yamlMapValue(labels, key) == nil {
+				yamlSetKey(labels, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.DoubleQuotedStyle})
+				added = true
+			}
+		}
+	}
+	return added
+}
+
+func hasListLabel(labels *yaml.Node, key string) bool {
+	for _, item := range labels.Content {
+		if name, _, _ := strings.Cut(item.Value, "="); name == key {
+			return true
+		}
+	}
+	return false
+}
+
+// managedFilter selects the resources of the installer's stack
+func managedFilter() []string {
+	return []string{"--filter", "label=" + labelManaged + "=true", "--filter", "label=" + labelStack + "=" + installerStack}
+}
+
+// managedResources lists the names of the labeled containers, networks or
+// volumes. The runtime does the filtering so unlabeled resources are never
+// returned, whatever they are called.
+func managedResources(containerType SupportedContainer, kind string) ([]string, error) {
+	args := []string{kind, "ls"}
+	format := "{{.Name}}"
+	if kind == "container" {
+		args = append(args, "-a")
+		format = "{{.Names}}"
+	}
+	args = append(append(args, managedFilter()...), "--format", format)
+	out, err := outputCmd(exec.Command(string(containerType), args...))
+	if err != nil {
+		return nil, fmt.Errorf("could not list %ss: %v", kind, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// stackContainers returns the installer's containers, by label or, for
+// installs made before the labels existed, by their well-known names
+func stackContainers(containerType SupportedContainer) (names []string, labeled bool) {
+	names, err := managedResources(containerType, "container")
+	if err == nil && len(names) > 0 {
+		return names, true
+	}
+	return legacyContainerNames, false
+}
+
+// removeManagedResources removes labeled containers, networks and volumes left
+// behind after compose down, e.g. by services removed from the compose file
+func removeManagedResources(containerType SupportedContainer, kinds []string, summary *uninstallSummary) {
+	for _, kind := range kinds {
+		names, err := managedResources(containerType, kind)
+		if err != nil {
+			summary.failed(kind+"s", err)
+			continue
+		}
+		for _, name := range names {
+			args := []string{kind, "rm", name}
+			if kind == "container" {
+				args = []string{kind, "rm", "-f", name}
+			}
+			if err := runCmd(exec.Command(string(containerType), args...)); err != nil {
+				summary.failed(kind+" "+name, err)
+			} else {
+				summary.remove(kind + " " + name)
+			}
+		}
+	}
+}
+
+// labelExistingInstall labels the resources of an install made before the
+// labels existed. Containers pick the labels up when they are recreated.
+func labelExistingInstall(containerType SupportedContainer) {
+	changes, err := applyComposeLabels()
+	if err != nil {
+		warnf("Warning: could not label the resources in docker-compose.yml: %v\n", err)
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	infoln("\n=== Resource Labels ===")
+	infof("Added the %s labels to %d resources in docker-compose.yml.\n", labelManaged, len(changes))
+	if containerType == Undefined {
+		infoln("Recreate the containers with compose up -d so they carry the labels.")
+		return
+	}
+	if readBool("apply_labels", tr("prompt.apply_labels"), true) {
+		if err := startContainers(containerType); err != nil {
+			errorf("Error: %v\n", err)
+		}
+	} else {
+		report.skip("container relabel (declined)")
+	}
+	infoln("Networks keep their old labels until they are recreated, e.g. by compose down followed by up -d.")
+}
+
+// applyComposeLabels runs only the label migration against docker-compose.yml
+func applyComposeLabels() ([]configChange, error) {
+	var migrations []configMigration
+	for _, migration := range configMigrations {
+		if migration.Name == "resource-labels" {
+			migrations = append(migrations, migration)
+		}
+	}
+	return applyMigrationSet(migrations)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeRuntime puts a docker first on PATH that keeps containers, networks and
+// volumes in an inventory of "kind name labels" lines. ls applies the label
+// filters like the real runtime, rm removes the line. Every call is appended
+// to the returned log.
+func fakeRuntime(t *testing.T, inventory []string) (log, store string) {
+	t.Helper()
+	dir := t.TempDir()
+	log, store = filepath.Join(dir, "commands.log"), filepath.Join(dir, "inventory")
+	if err := os.WriteFile(store, []byte(strings.Join(inventory, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := `#!/bin/sh
+echo "docker $*" >> ` + log + `
+kind=$1 action=$2
+shift 2
+case $action in
+ls)
+	filters=
+	while [ $# -gt 0 ]; do
+		if [ "$1" = --filter ]; then filters="$filters ${2#label=}"; shift; fi
+		shift
+	done
+	while read k name labels; do
+		[ "$k" = "$kind" ] || continue
+		ok=1
+		for f in $filters; do
+			case ",$labels," in *",$f,"*) ;; *) ok=0 ;; esac
+		done
+		[ $ok = 1 ] && echo "$name"
+	done < ` + store + `
+	;;
+rm)
+	for name; do
+		[ "$name" = -f ] && continue
+		grep -v "^$kind $name " ` + store + ` > ` + store + `.new
+		mv ` + store + `.new ` + store + `
+	done
+	;;
+esac
+exit 0
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log, store
+}
+
+// TestRemoveManagedResources checks that uninstall removes the containers,
+// networks and volumes carrying the installer labels, whatever they are
+// called, and leaves unlabeled ones alone even with the stack's names
+func TestRemoveManagedResources(t *testing.T) {
+	const managed = labelManaged + "=true," + labelStack + "=" + installerStack
+	log, store := fakeRuntime(t, []string{
+		"container pangolin " + managed,
+		"container gerbil-renamed " + managed,
+		"container traefik -",
+		"container other-app app=other",
+		"container other-stack " + labelManaged + "=true," + labelStack + "=other",
+		"network pangolin_default " + managed,
+		"network pangolin -",
+		"volume pangolin-data " + managed,
+		"volume pangolin-db -",
+		"volume unrelated " + labelManaged + "=false," + labelStack + "=" + installerStack,
+	})
+
+	var summary uninstallSummary
+	removeManagedResources(Docker, []string{"container", "network", "volume"}, &summary)
+
+	want := []string{"container pangolin", "container gerbil-renamed", "network pangolin_default", "volume pangolin-data"}
+	if !slices.Equal(summary.removed, want) {
+		t.Errorf("removed %q, want %q", summary.removed, want)
+	}
+	if len(summary.kept) > 0 {
+		t.Errorf("failed %q", summary.kept)
+	}
+
+	data, err := os.ReadFile(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		kind, rest, _ := strings.Cut(line, " ")
+		name, _, _ := strings.Cut(rest, " ")
+		left = append(left, kind+" "+name)
+	}
+	wantLeft := []string{"container traefik", "container other-app", "container other-stack", "network pangolin", "volume pangolin-db", "volume unrelated"}
+	if !slices.Equal(left, wantLeft) {
+		t.Errorf("left %q, want %q", left, wantLeft)
+	}
+
+	// Only the listed resources were ever passed to rm
+	for _, line := range ranCommands(t, log) {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[2] != "rm" {
+			continue
+		}
+		if name := fields[len(fields)-1]; !slices.Contains(want, fields[1]+" "+name) {
+			t.Errorf("ran %q on an unlabeled resource", line)
+		}
+	}
+}
+
+// TestStackContainers checks that the installer's containers are found by
+// label and, for an install made before the labels existed, by name
+func TestStackContainers(t *testing.T) {
+	fakeRuntime(t, []string{
+		"container renamed-pangolin " + labelManaged + "=true," + labelStack + "=" + installerStack,
+		"container traefik -",
+	})
+	if names, labeled := stackContainers(Docker); !labeled || !slices.Equal(names, []string{"renamed-pangolin"}) {
+		t.Errorf("got %q labeled %t, want the labeled container", names, labeled)
+	}
+
+	fakeRuntime(t, []string{"container traefik -"})
+	if names, labeled := stackContainers(Docker); labeled || !slices.Equal(names, legacyContainerNames) {
+		t.Errorf("got %q labeled %t, want the legacy names", names, labeled)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// legacyMove relocates a single file into the current layout
+type legacyMove struct {
+	From string
+	To   string
+}
+
+// legacyLayout describes a directory layout written by an older installer and
+// how to map it onto the current one. All paths are relative to the install
+// directory.
+type legacyLayout struct {
+	Name        string
+	Description string
+	// Markers must all exist for the layout to match
+	Markers     []string
+	ComposeFile string
+	// Moves whose source does not exist are skipped (e.g. acme.json before the
+	// first certificate was issued)
+	Moves []legacyMove
+	// ServiceRenames maps old compose service names to the current ones
+	ServiceRenames map[string]string
+	// PathRewrites maps old host paths in compose volumes to the current ones
+	PathRewrites map[string]string
+}
+
+// legacyLayouts are the historical layouts the installer knows how to migrate
+var legacyLayouts = []legacyLayout{
+	{
+		Name:        "script-v1",
+		Description: "script-based installer with config/pangolin.yml and ACME storage under config/traefik/acme",
+		Markers:     []string{"docker-compose.yml", "config/pangolin.yml"},
+		ComposeFile: "docker-compose.yml",
+		Moves: []legacyMove{
+			{From: "config/pangolin.yml", To: "config/config.yml"},
+			{From: "config/traefik/acme/acme.json", To: "config/letsencrypt/acme.json"},
+		},
+		ServiceRenames: map[string]string{
+			"pangolin-server":  "pangolin",
+			"pangolin-gerbil":  "gerbil",
+			"pangolin-traefik": "traefik",
+		},
+		PathRewrites: map[string]string{
+			"./config/pangolin.yml": "./config/config.yml",
+			"./config/traefik/acme": "./config/letsencrypt",
+		},
+	},
+	{
+		Name:        "flat",
+		Description: "script-based installer with every file in the install directory and a docker-compose.yaml",
+		Markers:     []string{"docker-compose.yaml", "config.yml", "traefik_config.yml"},
+		ComposeFile: "docker-compose.yaml",
+		Moves: []legacyMove{
+			{From: "config.yml", To: "config/config.yml"},
+			{From: "traefik_config.yml", To: "config/traefik/traefik_config.yml"},
+			{From: "dynamic_config.yml", To: "config/traefik/dynamic_config.yml"},
+			{From: "letsencrypt/acme.json", To: "config/letsencrypt/acme.json"},
+			{From: "key", To: "config/key"},
+		},
+		PathRewrites: map[string]string{
+			"./config.yml":         "./config/config.yml",
+			"./traefik_config.yml": "./config/traefik/traefik_config.yml",
+			"./dynamic_config.yml": "./config/traefik/dynamic_config.yml",
+			"./letsencrypt":        "./config/letsencrypt",
+			"./key":                "./config/key",
+		},
+	},
+}
+
+// legacyCandidateFiles are file names reported when a directory looks like a
+// Pangolin install but matches none of the known layouts
+var legacyCandidateFiles = []string{
+	"config.yml", "pangolin.yml", "traefik_config.yml", "dynamic_config.yml",
+	"acme.json", "key", "docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml",
+}
+
+func (l legacyLayout) matches(dir string) bool {
+	for _, marker := range l.Markers {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// detectLegacyLayout returns the known legacy layout found in dir, if any
+func detectLegacyLayout(dir string) (*legacyLayout, bool) {
+	if hasExistingInstall(dir) {
+		return nil, false
+	}
+	for i := range legacyLayouts {
+		if legacyLayouts[i].matches(dir) {
+			return &legacyLayouts[i], true
+		}
+	}
+	return nil, false
+}
+
+// looksLikePangolinInstall reports whether dir has a compose file that runs
+// Pangolin, regardless of layout
+func looksLikePangolinInstall(dir string) bool {
+	for _, name := range []string{"docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml"} {
+		if checkIfTextInFile(filepath.Join(dir, name), "fosrl/pangolin") {
+			return true
+		}
+	}
+	return false
+}
+
+// offerLegacyMigration migrates a legacy layout in dir after confirmation and
+// returns true when dir now holds a current install. A Pangolin install in a
+// layout we do not recognize is reported and the run is aborted.
+func offerLegacyMigration(dir string) bool {
+	layout, ok := detectLegacyLayout(dir)
+	if !ok {
+		if !hasExistingInstall(dir) && looksLikePangolinInstall(dir) {
+			printLegacyReport(dir)
+			fatalf("Error: %s contains a Pangolin installation in a layout this installer does not recognize. Please migrate it manually.\n", dir)
+		}
+		return false
+	}
+
+	infoln("\n=== Legacy Installation Detected ===")
+	infof("Found an installation at %s created by an older installer (%s): %s\n", dir, layout.Name, layout.Description)
+	infoln("Migrating will:")
+	for _, move := range layout.Moves {
+		if _, err := os.Stat(filepath.Join(dir, move.From)); err == nil {
+			infof("  move %s -> %s\n", move.From, move.To)
+		}
+	}
+	infof("  rewrite %s as docker-compose.yml\n", layout.ComposeFile)
+	infoln("  restart the stack and wait for it to become healthy")
+	infoln("Every file is backed up before it is changed.")
+
+	if !readBool("migrate_legacy_layout", tr("prompt.migrate_legacy_layout"), true) {
+		fatalf("Error: the installer cannot manage a legacy layout without migrating it.\n")
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+	if err := migrateLegacyLayout(*layout); err != nil {
+		fatalf("Error migrating legacy installation: %v\n", err)
+	}
+	return true
+}
+
+func printLegacyReport(dir string) {
+	warnf("\nFound the following Pangolin related files in %s:\n", dir)
+	_ = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if d.IsDir() && strings.Count(rel, string(filepath.Separator)) >= 2 {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && slices.Contains(legacyCandidateFiles, d.Name()) {
+			warnf("  %s\n", rel)
+		}
+		return nil
+	})
+
+	for _, name := range []string{"docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		var compose struct {
+			Services map[string]any `yaml:"services"`
+		}
+		if yaml.Unmarshal(data, &compose) == nil && len(compose.Services) > 0 {
+			services := make([]string, 0, len(compose.Services))
+			for service := range compose.Services {
+				services = append(services, service)
+			}
+			slices.Sort(services)
+			warnf("Services in %s: %s\n", name, strings.Join(services, ", "))
+		}
+	}
+}
+
+// migrateLegacyLayout converts the legacy layout in the current directory
+func migrateLegacyLayout(layout legacyLayout) error {
+	containerType := detectContainerType()
+
+	backupDir := filepath.Join("backups", fmt.Sprintf("legacy-%s-%s", layout.Name, time.Now().Format("20060102-150405")))
+	infof("Backing up legacy files to %s...\n", backupDir)
+	touched := []string{layout.ComposeFile}
+	for _, move := range layout.Moves {
+		if _, err := os.Stat(move.From); err == nil {
+			touched = append(touched, move.From)
+		}
+	}
+	for _, path := range touched {
+		dst := filepath.Join(backupDir, path)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return fmt.Errorf("failed to create backup directory: %v", err)
+		}
+		if err := copyFile(path, dst); err != nil {
+			return fmt.Errorf("failed to back up %s: %v", path, err)
+		}
+	}
+
+	if containerType != Undefined {
+		infoln("Stopping the legacy stack...")
+		if err := runCompose(containerType, layout.ComposeFile, "down"); err != nil {
+			warnf("Warning: could not stop the legacy stack: %v\n", err)
+		}
+	}
+
+	for _, move := range layout.Moves {
+		if _, err := os.Stat(move.From); err != nil {
+			continue
+		}
+		if _, err := os.Stat(move.To); err == nil {
+			return fmt.Errorf("cannot move %s: %s already exists (backup in %s)", move.From, move.To, backupDir)
+		}
+		if err := os.MkdirAll(filepath.Dir(move.To), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", filepath.Dir(move.To), err)
+		}
+		if err := os.Rename(move.From, move.To); err != nil {
+			return fmt.Errorf("failed to move %s: %v", move.From, err)
+		}
+		report.fileWritten(move.To)
+		infof("Moved %s -> %s\n", move.From, move.To)
+	}
+
+	if err := rewriteLegacyCompose(layout, layout.ComposeFile, "docker-compose.yml"); err != nil {
+		return fmt.Errorf("failed to rewrite compose file (backup in %s): %v", backupDir, err)
+	}
+	if layout.ComposeFile != "docker-compose.yml" {
+		if err := os.Remove(layout.ComposeFile); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", layout.ComposeFile, err)
+		}
+	}
+	report.fileWritten("docker-compose.yml")
+	infoln("Rewrote docker-compose.yml")
+
+	if containerType == Undefined {
+		warnf("Warning: neither Docker nor Podman is running, start the stack manually to verify the migration.\n")
+		report.skip("legacy migration health check (no container runtime)")
+		return nil
+	}
+
+	if err := startContainers(containerType); err != nil {
+		return fmt.Errorf("%v (backup in %s)", err, backupDir)
+	}
+	err := runStep(context.Background(), "Waiting for pangolin to become healthy", func(ctx context.Context) error {
+		return waitForContainerHealthy(ctx, "pangolin", containerType, healthTimeout())
+	})
+	if err != nil {
+		return fmt.Errorf("the migrated stack did not become healthy: %v (backup in %s)", err, backupDir)
+	}
+
+	infoln("Migration complete, the stack is healthy.")
+	return nil
+}
+
+// rewriteLegacyCompose renames services and rewrites host paths of the compose
+// file at src and writes the result to dst. Comments are preserved.
+func rewriteLegacyCompose(layout legacyLayout, src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("error parsing %s: %v", src, err)
+	}
+	if len(doc.Content) == 0 {
+		return fmt.Errorf("%s is empty", src)
+	}
+
+	services := yamlMapValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return fmt.Errorf("services section not found or invalid")
+	}
+
+	rename := func(name string) string {
+		if renamed, ok := layout.ServiceRenames[name]; ok {
+			return renamed
+		}
+		return name
+	}
+
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		key, service := services.Content[i], services.Content[i+1]
+		key.Value = rename(key.Value)
+
+		if name := yamlMapValue(service, "container_name"); name != nil {
+			name.Value = rename(name.Value)
+		}
+		if mode := yamlMapValue(service, "network_mode"); mode != nil {
+			if target, ok := strings.CutPrefix(mode.Value, "service:"); ok {
+				mode.Value = "service:" + rename(target)
+			}
+		}
+		if deps := yamlMapValue(service, "depends_on"); deps != nil {
+			switch deps.Kind {
+			case yaml.SequenceNode:
+				for _, dep := range deps.Content {
+					dep.Value = rename(dep.Value)
+				}
+			case yaml.MappingNode:
+				for j := 0; j < len(deps.Content); j += 2 {
+					deps.Content[j].Value = rename(deps.Content[j].Value)
+				}
+			}
+		}
+		if volumes := yamlMapValue(service, "volumes"); volumes != nil && volumes.Kind == yaml.SequenceNode {
+			for _, volume := range volumes.Content {
+				volume.Value = rewriteVolumeHostPath(volume.Value, layout.PathRewrites)
+			}
+		}
+	}
+
+	out, err := MarshalYAMLWithIndent(&doc, 2)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, out, 0644)
+}
+
+// rewriteVolumeHostPath rewrites the host side of a "host:container[:mode]"
+// volume string
+func rewriteVolumeHostPath(volume string, rewrites map[string]string) string {
+	host, rest, ok := strings.Cut(volume, ":")
+	if !ok {
+		return volume
+	}
+	for old, replacement := range rewrites {
+		if host == old || strings.HasPrefix(host, old+"/") {
+			return replacement + strings.TrimPrefix(host, old) + ":" + rest
+		}
+	}
+	return volume
+}
+
+// yamlMapValue returns the value node stored under key in a mapping node
+func yamlMapValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// locales holds one catalog per language, a flat JSON object of message IDs.
+// en is the reference, the others may leave out messages.
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+const fallbackLanguage = "en"
+
+var (
+	catalogs = loadCatalogs()
+	language = fallbackLanguage
+)
+
+func loadCatalogs() map[string]map[string]string {
+	entries, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[string]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := locales.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("locales/%s: %v", entry.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))] = catalog
+	}
+	return catalogs
+}
+
+// languages lists the languages with a catalog
+func languages() []string {
+	var names []string
+	for name := range catalogs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// selectLanguage switches to the language of --lang, or of LC_ALL,
+// LC_MESSAGES or LANG. A language without a catalog in the environment falls
+// back to English, one passed with --lang is an error.
+func selectLanguage(name string) error {
+	if name != "" {
+		if _, ok := catalogs[name]; !ok {
+			return fmt.Errorf("unsupported language %q (expected one of %s)", name, strings.Join(languages(), ", "))
+		}
+		language = name
+		return nil
+	}
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		// de_DE.UTF-8, zh_CN or fr
+		lang, _, _ := strings.Cut(value, "_")
+		lang, _, _ = strings.Cut(lang, ".")
+		if _, ok := catalogs[strings.ToLower(lang)]; ok {
+			language = strings.ToLower(lang)
+		} else {
+			language = fallbackLanguage
+		}
+		return nil
+	}
+	language = fallbackLanguage
+	return nil
+}
+
+// tr returns the message id in the selected language, falling back to
+// English, formatted with args when there are any
+func tr(id string, args ...any) string {
+	message, ok := catalogs[language][id]
+	if !ok {
+		message, ok = catalogs[fallbackLanguage][id]
+	}
+	if !ok {
+		message = id
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
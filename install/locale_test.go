@@ -0,0 +1,169 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// formatVerb matches the fmt verbs of a message
+var formatVerb = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+// messageVerbs returns the sorted fmt verbs of message
+func messageVerbs(message string) []string {
+	verbs := formatVerb.FindAllString(message, -1)
+	slices.Sort(verbs)
+	return verbs
+}
+
+// TestCatalogsComplete checks that every locale translates every message of
+// the English catalog, and nothing else, with the same fmt verbs
+func TestCatalogsComplete(t *testing.T) {
+	reference := catalogs[fallbackLanguage]
+	for _, lang := range languages() {
+		t.Run(lang, func(t *testing.T) {
+			catalog := catalogs[lang]
+			for id, message := range reference {
+				translated, ok := catalog[id]
+				if !ok {
+					t.Errorf("%s is missing", id)
+					continue
+				}
+				if want, got := messageVerbs(message), messageVerbs(translated); !slices.Equal(want, got) {
+					t.Errorf("%s has the verbs %q, want %q", id, got, want)
+				}
+			}
+			for id := range catalog {
+				if _, ok := reference[id]; !ok {
+					t.Errorf("%s is not in the %s catalog", id, fallbackLanguage)
+				}
+			}
+		})
+	}
+}
+
+// promptPaths are the functions whose messages are shown while the questions
+// are asked, by file. A file without a list is checked as a whole.
+var promptPaths = map[string][]string{
+	"input.go":    nil,
+	"geoblock.go": {"parseCountryCodes", "readCountries", "collectGeoBlock", "explainGeoBlock", "reconfigureGeoBlock"},
+	"main.go":     {"main", "findOrSelectInstallDirectory", "prepareContainerRuntime", "askUserInput"},
+}
+
+// messageCalls are the calls whose string literal arguments reach the user
+var messageCalls = []string{"infoln", "errorf", "fmt.Errorf", "errors.New"}
+
+// callName returns the name of the function call calls, with its package
+func callName(call *ast.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		if pkg, ok := fun.X.(*ast.Ident); ok {
+			return pkg.Name + "." + fun.Sel.Name
+		}
+	}
+	return ""
+}
+
+// literalWords returns the string literals of expr, also inside a
+// concatenation, that contain words once the fmt verbs are removed
+func literalWords(expr ast.Expr) []string {
+	var found []string
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			// the arguments of tr and other calls are not shown as is
+			return false
+		case *ast.BasicLit:
+			if n.Kind != token.STRING {
+				return false
+			}
+			value, err := strconv.Unquote(n.Value)
+			if err == nil && strings.ContainsFunc(formatVerb.ReplaceAllString(value, ""), unicode.IsLetter) {
+				found = append(found, value)
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// TestPromptPathsTranslated checks that the messages printed and the errors
+// returned on the prompt paths come from the catalog, so a new English
+// literal there fails here instead of reaching every language untranslated
+func TestPromptPathsTranslated(t *testing.T) {
+	fset := token.NewFileSet()
+	for file, functions := range promptPaths {
+		parsed, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checked := map[string]bool{}
+		for _, decl := range parsed.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || (functions != nil && !slices.Contains(functions, fn.Name.Name)) {
+				continue
+			}
+			checked[fn.Name.Name] = true
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || !slices.Contains(messageCalls, callName(call)) {
+					return true
+				}
+				for _, arg := range call.Args {
+					for _, literal := range literalWords(arg) {
+						t.Errorf("%s: %s passes %q, move it to locales/ and use tr", fset.Position(call.Pos()), callName(call), literal)
+					}
+				}
+				return true
+			})
+		}
+		for _, fn := range functions {
+			if !checked[fn] {
+				t.Errorf("%s has no function %s, update promptPaths", file, fn)
+			}
+		}
+	}
+}
+
+// TestCatalogHasUsedMessages checks that every message ID passed to tr is in
+// the English catalog, tr would print the bare ID otherwise
+func TestCatalogHasUsedMessages(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(parsed, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || callName(call) != "tr" || len(call.Args) == 0 {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			id, _ := strconv.Unquote(lit.Value)
+			if _, ok := catalogs[fallbackLanguage][id]; !ok {
+				t.Errorf("%s: %s is not in locales/%s.json", fset.Position(lit.Pos()), id, fallbackLanguage)
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const installLogName = "pangolin-install.log"
+
+var (
+	verboseFlag *bool
+	quietFlag   *bool
+	// logFilePath is set by --log-file and replaces installLogName in the
+	// install directory
+	logFilePath string
+)
+
+// addLogFileFlag registers --log-file on fs. The path is resolved when the
+// flag is parsed, before the installer changes into the install directory.
+func addLogFileFlag(fs *flag.FlagSet) {
+	fs.Func("log-file", "Write the install log to this file instead of "+installLogName+" in the install directory", func(value string) error {
+		path, err := filepath.Abs(expandHome(value))
+		logFilePath = path
+		return err
+	})
+}
+
+// installLogger writes timestamped lines to pangolin-install.log. Lines logged
+// before the install directory is known are buffered and flushed on open.
+type installLogger struct {
+	mu      sync.Mutex
+	file    *os.File
+	path    string
+	pending []string
+}
+
+var installLog = &installLogger{}
+
+func (l *installLogger) open(dir string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	path := filepath.Join(dir, installLogName)
+	if logFilePath != "" {
+		path = logFilePath
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.path = path
+
+	// Start every run with a header so appended runs are easy to tell apart
+	ts := time.Now().Format(time.RFC3339)
+	fmt.Fprintf(l.file, "%s INFO  === Installer run started (version %s) ===\n", ts, pangolinVersion)
+	fmt.Fprintf(l.file, "%s INFO  Arguments: %s\n", ts, strings.Join(os.Args[1:], " "))
+
+	for _, line := range l.pending {
+		fmt.Fprint(l.file, line)
+	}
+	l.pending = nil
+	return nil
+}
+
+func (l *installLogger) write(level, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ts := time.Now().Format(time.RFC3339)
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(msg, "\n"), "\n") {
+		fmt.Fprintf(&b, "%s %-5s %s\n", ts, level, line)
+	}
+
+	if l.file == nil {
+		l.pending = append(l.pending, b.String())
+		return
+	}
+	fmt.Fprint(l.file, b.String())
+}
+
+func (l *installLogger) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// openInstallLog starts writing the install log into dir, or to --log-file
+func openInstallLog(dir string) {
+	if err := installLog.open(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not open the install log: %v\n", err)
+	}
+}
+
+func logf(level, format string, a ...any) {
+	installLog.write(level, fmt.Sprintf(format, a...))
+}
+
+func isQuiet() bool {
+	return quietFlag != nil && *quietFlag
+}
+
+func isVerbose() bool {
+	return verboseFlag != nil && *verboseFlag
+}
+
+// debugf records details for debugging a failed install, such as the
+// templates rendered and the HTTP requests made. Only --verbose prints them.
+func debugf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	logf("DEBUG", "%s", msg)
+	if isVerbose() && !isQuiet() {
+		fmt.Fprintln(consoleOut, lipgloss.NewStyle().Foreground(colors.Muted).Render(strings.TrimRight(msg, "\n")))
+	}
+}
+
+// infof prints informational output, which --quiet suppresses
+func infof(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	logf("INFO", "%s", msg)
+	if !isQuiet() {
+		fmt.Fprint(consoleOut, msg)
+	}
+}
+
+func infoln(a ...any) {
+	infof("%s", fmt.Sprintln(a...))
+}
+
+// warnf prints a warning, which --quiet suppresses
+func warnf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	logf("WARN", "%s", msg)
+	report.warn(msg)
+	if !isQuiet() {
+		fmt.Fprint(consoleOut, msg)
+	}
+}
+
+// errorf prints an error to stderr regardless of --quiet
+func errorf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	logf("ERROR", "%s", msg)
+	fmt.Fprint(os.Stderr, msg)
+}
+
+// fatalf prints an error pointing at the install log and exits, see exitf
+// for the failures with their own exit code
+func fatalf(format string, a ...any) {
+	exitf(exitError, format, a...)
+}
+
+// logAnswer records the answer given to a prompt. Secret values are redacted.
+func logAnswer(key, prompt, value string, source answerSource, secret bool) {
+	logValue := value
+	if secret {
+		logValue = "[redacted]"
+	}
+	logf("INFO", "prompt %s: %s -> %s (from %s)", key, prompt, logValue, source)
+	recordAnswer(key, value, source, secret)
+}
+
+// execLogged runs cmd and records the command line, exit code and stderr in
+// the install log. When live is set the output is attached to the terminal
+// (unless --quiet); --verbose mirrors the output of every command.
+func execLogged(cmd *exec.Cmd, live bool) error {
+	if dryRun && !sideEffectFree(cmd.Args) {
+		recordCommand(cmd.Args)
+		return nil
+	}
+	show := (live && !isQuiet()) || isVerbose()
+
+	var stderr bytes.Buffer
+	cmd.Stdout = teeWriters(cmd.Stdout, show, consoleOut)
+	cmd.Stderr = teeWriters(cmd.Stderr, show, os.Stderr, &stderr)
+
+	logf("INFO", "exec: %s", strings.Join(cmd.Args, " "))
+	start := time.Now()
+	err := cmd.Run()
+
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	logf("INFO", "exit: %d after %s", exitCode, time.Since(start).Round(time.Millisecond))
+	if err != nil && cmd.ProcessState == nil {
+		logf("ERROR", "exec failed: %v", err)
+	}
+	if stderr.Len() > 0 {
+		logf("INFO", "stderr:\n%s", stderr.String())
+	}
+
+	return err
+}
+
+func teeWriters(existing io.Writer, show bool, terminal io.Writer, extra ...io.Writer) io.Writer {
+	var writers []io.Writer
+	if existing != nil {
+		writers = append(writers, existing)
+	}
+	if show {
+		writers = append(writers, terminal)
+	}
+	writers = append(writers, extra...)
+
+	switch len(writers) {
+	case 0:
+		return nil
+	case 1:
+		return writers[0]
+	}
+	return io.MultiWriter(writers...)
+}
+
+// runCmd runs cmd silently (apart from --verbose) and logs it
+func runCmd(cmd *exec.Cmd) error {
+	return execLogged(cmd, false)
+}
+
+// outputCmd runs cmd, logs it and returns its stdout
+func outputCmd(cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := execLogged(cmd, false)
+	return out.Bytes(), err
+}
+
+// loggedTransport records every HTTP request and its outcome in the install
+// log. The query is left out, it may carry a license key.
+type loggedTransport struct {
+	base http.RoundTripper
+}
+
+// logTransport wraps base to log its requests
+func logTransport(base http.RoundTripper) http.RoundTripper {
+	return &loggedTransport{base: base}
+}
+
+func (t *loggedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		debugf("http: %s %s failed after %s: %v", req.Method, target, elapsed, err)
+		return nil, err
+	}
+	debugf("http: %s %s -> %s after %s", req.Method, target, resp.Status, elapsed)
+	return resp, nil
+}
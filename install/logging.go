@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rotation of the json-file container logs, lowered on small disks
+const (
+	defaultLogMaxSize   = "10m"
+	defaultLogMaxFile   = 3
+	smallDiskLogMaxSize = "5m"
+	smallDiskLogMaxFile = 2
+	smallDiskSize       = 20 << 30
+)
+
+const (
+	dockerDaemonConfig = "/etc/docker/daemon.json"
+	// dockerRootDir holds the container logs of a default Docker engine
+	dockerRootDir = "/var/lib/docker"
+)
+
+var logSizePattern = regexp.MustCompile(`^[1-9][0-9]*[kmg]$`)
+
+// collectLogRotation asks for the size limits of the container logs, which
+// every compose service gets, and whether Docker applies them to all
+// containers
+func collectLogRotation(config *Config) {
+	maxSize, maxFile := defaultLogMaxSize, defaultLogMaxFile
+	if total, ok := filesystemSize(dockerRootDir); ok && total < smallDiskSize {
+		maxSize, maxFile = smallDiskLogMaxSize, smallDiskLogMaxFile
+		infof("The disk holding the container logs has only %s, the suggested limits are lowered.\n", formatGiB(total))
+	}
+	if !readBool("log_rotation", tr("prompt.log_rotation"), true) {
+		warnf("Warning: the container logs grow without limit and can fill the disk.\n")
+		return
+	}
+	config.LogMaxSize = readValidated("log_max_size", tr("prompt.log_max_size"), maxSize, validateLogSize)
+	config.LogMaxFile = readIntInRange("log_max_file", tr("prompt.log_max_file"), maxFile, 1, 100)
+	config.DockerLogDefaults = readBool("docker_log_defaults", tr("prompt.docker_log_defaults", dockerDaemonConfig), false)
+}
+
+// validateLogSize accepts the max-size values of the json-file driver
+func validateLogSize(size string) error {
+	if !logSizePattern.MatchString(size) {
+		return errors.New("enter a size like 10m: a number followed by k, m or g")
+	}
+	return nil
+}
+
+// filesystemSize returns the size of the filesystem holding path, or of its
+// closest existing parent
+func filesystemSize(path string) (uint64, bool) {
+	for {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(path, &stat); err == nil {
+			return stat.Blocks * uint64(stat.Bsize), true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0, false
+		}
+		path = parent
+	}
+}
+
+// installedLogRotation reads the log limits of the pangolin service of an
+// installed compose file, empty when it has none
+func installedLogRotation(composePath string) (maxSize string, maxFile int) {
+	data, err := os.ReadFile(composePath)
+	if err != nil {
+		return "", 0
+	}
+	var compose struct {
+		Services struct {
+			Pangolin struct {
+				Logging struct {
+					Options struct {
+						MaxSize string `yaml:"max-size"`
+						MaxFile string `yaml:"max-file"`
+					} `yaml:"options"`
+				} `yaml:"logging"`
+			} `yaml:"pangolin"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return "", 0
+	}
+	options := compose.Services.Pangolin.Logging.Options
+	maxFile, _ = strconv.Atoi(options.MaxFile)
+	return options.MaxSize, maxFile
+}
+
+// applyDockerLogDefaults writes the log limits to daemon.json, where they
+// apply to every container created afterwards, and restarts Docker after
+// confirmation
+func applyDockerLogDefaults(config Config) {
+	if !config.DockerLogDefaults {
+		return
+	}
+	if config.InstallationContainerType != Docker || !platform.InstallDocker {
+		report.skip("Docker log defaults (only for a Docker engine run by systemd)")
+		return
+	}
+	infoln("\n=== Docker Log Defaults ===")
+
+	daemon := map[string]any{}
+	data, err := os.ReadFile(dockerDaemonConfig)
+	existed := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		warnf("Warning: could not read %s: %v\n", dockerDaemonConfig, err)
+		return
+	}
+	if existed {
+		if err := json.Unmarshal(data, &daemon); err != nil {
+			warnf("Warning: %s is not valid JSON, leaving it unchanged: %v\n", dockerDaemonConfig, err)
+			return
+		}
+	}
+	driver, _ := daemon["log-driver"].(string)
+	if driver != "" && driver != "json-file" && driver != "local" {
+		warnf("Warning: Docker logs with the %s driver, which has no max-size and max-file options. Leaving %s unchanged.\n", driver, dockerDaemonConfig)
+		return
+	}
+	options, _ := daemon["log-opts"].(map[string]any)
+	if options["max-size"] == config.LogMaxSize && options["max-file"] == strconv.Itoa(config.LogMaxFile) {
+		infof("%s already limits the logs this way.\n", dockerDaemonConfig)
+		return
+	}
+	if options == nil {
+		options = map[string]any{}
+	}
+	options["max-size"] = config.LogMaxSize
+	options["max-file"] = strconv.Itoa(config.LogMaxFile)
+	daemon["log-opts"] = options
+	if driver == "" {
+		daemon["log-driver"] = "json-file"
+	}
+
+	warnf("Docker must restart to read %s. Containers that are running stop and start again, unless live-restore is enabled.\n", dockerDaemonConfig)
+	if !readBool("docker_restart", tr("prompt.docker_restart"), true) {
+		report.skip("Docker log defaults (restart declined)")
+		return
+	}
+	content, err := json.MarshalIndent(daemon, "", "  ")
+	if err != nil {
+		warnf("Warning: could not encode %s: %v\n", dockerDaemonConfig, err)
+		return
+	}
+	backup := dockerDaemonConfig + ".pangolin-backup"
+	if existed {
+		if err := os.WriteFile(backup, data, 0644); err != nil {
+			warnf("Warning: could not back up %s: %v\n", dockerDaemonConfig, err)
+			return
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dockerDaemonConfig), 0755); err != nil {
+		warnf("Warning: could not create %s: %v\n", filepath.Dir(dockerDaemonConfig), err)
+		return
+	}
+	if err := os.WriteFile(dockerDaemonConfig, append(content, '\n'), 0644); err != nil {
+		warnf("Warning: could not write %s: %v\n", dockerDaemonConfig, err)
+		return
+	}
+	if existed {
+		recordExternal(externalResource{
+			Kind:        resourceFile,
+			Description: "Docker log defaults in " + dockerDaemonConfig,
+			Undo:        "mv " + backup + " " + dockerDaemonConfig + " && systemctl restart docker",
+		})
+	} else {
+		recordExternal(externalResource{
+			Kind:        resourceFile,
+			Path:        dockerDaemonConfig,
+			Description: "Docker log defaults",
+			Undo:        "systemctl restart docker",
+		})
+	}
+
+	if err := execLogged(exec.Command("systemctl", "restart", "docker"), true); err != nil {
+		warnf("Warning: could not restart Docker: %v\n", err)
+		return
+	}
+	for range 10 {
+		if isDockerRunning() {
+			infof("Docker restarted with logs limited to %s x %d per container.\n", config.LogMaxSize, config.LogMaxFile)
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+	warnf("Warning: Docker is not running after the restart, check journalctl -u docker and %s.\n", dockerDaemonConfig)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"embed"
 	"encoding/base64"
@@ -9,11 +11,11 @@ import (
 	"io"
 	"io/fs"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 	"text/template"
@@ -27,6 +29,27 @@ var (
 	badgerVersion   string
 )
 
+// TunnelEndpoint is the hostname sites use to reach Gerbil
+func (c Config) TunnelEndpoint() string {
+	if c.GerbilEndpoint != "" {
+		// Pangolin appends the port, so an IPv6 address needs brackets
+		if ip := net.ParseIP(c.GerbilEndpoint); ip != nil && ip.To4() == nil {
+			return "[" + c.GerbilEndpoint + "]"
+		}
+		return c.GerbilEndpoint
+	}
+	return c.DashboardDomain
+}
+
+// EntryPointAddress is the listen address of a Traefik entrypoint, the
+// dual-stack wildcard when IPv6 is enabled
+func (c Config) EntryPointAddress(port int) string {
+	if c.EnableIPv6 {
+		return fmt.Sprintf("[::]:%d", port)
+	}
+	return fmt.Sprintf(":%d", port)
+}
+
 func loadVersions(config *Config) {
 	config.PangolinVersion = pangolinVersion
 	config.GerbilVersion = gerbilVersion
@@ -44,23 +67,78 @@ type Config struct {
 	BaseDomain                string
 	DashboardDomain           string
 	EnableIPv6                bool
+	Timezone                  string
+	HTTPPort                  int
+	HTTPSPort                 int
+	WireGuardPort             int
+	ACMEChallenge             string
+	ACMEStaging               bool
+	DNSProvider               string
+	DNSCredentials            map[string]string
+	WildcardDomain            string
+	CertificateSource         string
+	OwnCertificates           []ownCertificate
+	AdditionalDomains         []string
+	ExternalProxy             bool
+	ProxyAPIPort              int
+	ProxyDashboardPort        int
+	ProxyBindAddress          string
+	PangolinPorts             pangolinPorts
+	AdminEmail                string
 	LetsEncryptEmail          string
 	EnableEmail               bool
 	EmailSMTPHost             string
 	EmailSMTPPort             int
 	EmailSMTPUser             string
 	EmailSMTPPass             string
+	EmailSMTPSecurity         string
 	EmailNoReply              string
 	InstallGerbil             bool
+	GerbilEndpoint            string
+	TLSPassthroughs           []TLSPassthrough
+	OIDC                      *OIDCProvider
 	TraefikBouncerKey         string
 	DoCrowdsecInstall         bool
+	CrowdsecEnrollKey         string
+	CrowdsecHubItems          []string
 	EnableMaxMind             bool
 	Secret                    string
 	IsEnterprise              bool
-    IsPostgreSQL              bool
+	IsPostgreSQL              bool
 	IsPostgreSQLPass          string
-    IsRedis                   bool
+	ExternalPostgreSQL        bool
+	PostgreSQLHost            string
+	PostgreSQLPort            int
+	PostgreSQLDatabase        string
+	PostgreSQLUser            string
+	PostgreSQLTLS             bool
+	IsRedis                   bool
 	IsRedisPass               string
+	DockerSecrets             bool
+	CreateAdmin               bool
+	AdminPassword             string
+	AdminPasswordGenerated    bool
+	CreateAPIToken            bool
+	GeoBlockCountries         []string
+	GeoBlockEverything        bool
+	HardenedHTTP              bool
+	RateLimitAverage          int
+	RateLimitBurst            int
+	HTTPMode                  string
+	LogMaxSize                string
+	LogMaxFile                int
+	DockerLogDefaults         bool
+	AutoUpdate                string
+	UpdateSchedule            string
+	UpdateTimer               bool
+	BackupDir                 string
+	BackupRetention           int
+	BackupSchedule            string
+	BackupTimer               bool
+	Telemetry                 bool
+	// InstallDir and DataDir are the absolute host paths of the bind mounts
+	InstallDir string
+	DataDir    string
 }
 
 type SupportedContainer string
@@ -71,32 +149,134 @@ const (
 	Undefined SupportedContainer = "undefined"
 )
 
-var redisFlag *bool
+var (
+	redisFlag *bool
+)
+
+const defaultInstallDir = "/opt/pangolin"
 
 func main() {
+	// Every client without a transport of its own sends through this one
+	http.DefaultTransport = logTransport(http.DefaultTransport)
+
+	// Subcommands parse their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "status":
+			runStatus(os.Args[2:])
+			return
+		case "plan":
+			runPlan(os.Args[2:])
+			return
+		case "apply":
+			runApply(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "fingerprint":
+			runFingerprint(os.Args[2:])
+			return
+		case "upgrade":
+			runUpgrade(os.Args[2:])
+			return
+		case "rollback":
+			runRollback(os.Args[2:])
+			return
+		case "uninstall":
+			runUninstall(os.Args[2:])
+			return
+		case "tunnel":
+			runTunnel(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "diagnose":
+			runDiagnose(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		}
+	}
 
 	crowdsecFlag := flag.Bool("crowdsec", false, "Enable the CrowdSec installation prompt")
+	removeCrowdsecFlag := flag.Bool("remove-crowdsec", false, "Remove CrowdSec from an existing installation (combine with --dry-run to preview)")
 	redisFlag = flag.Bool("redis", false, "Install Redis as caching solution. Required for HA. Not required for the Enterprise version.")
+	noUpdateCheckFlag := flag.Bool("no-update-check", false, "Do not check for a newer installer release at startup")
+	addOfflineFlag(flag.CommandLine)
+	addNoTelemetryFlag(flag.CommandLine)
+	addSkipCheckFlag(flag.CommandLine)
+	addForceFlag(flag.CommandLine)
+	addParallelPullsFlag(flag.CommandLine)
+	flag.BoolVar(&nonInteractive, "non-interactive", false, "Fail instead of prompting whenever input would be required")
+	verboseFlag = flag.Bool("verbose", false, "Mirror the output of every executed command and the debug lines of the install log, e.g. template renders and HTTP requests, to the terminal")
+	addLogFileFlag(flag.CommandLine)
+	outputFlag = flag.String("output", "text", "Output format: text, or json or yaml to print a machine-readable result document to stdout")
+	redactSecretsFlag = flag.Bool("redact-secrets", false, "Replace the passwords, tokens and keys in the --output document with "+redactedValue)
+	healthTimeoutFlag = flag.Duration("health-timeout", defaultHealthTimeout, "How long to wait for the stack to become healthy after starting it")
+	quietFlag = flag.Bool("quiet", false, "Only print prompts and errors (everything is still written to the install log)")
+	addConfirmFlag(flag.CommandLine)
+	addSELinuxFlag(flag.CommandLine)
+	addSimulateFlag(flag.CommandLine)
+	addACMEStagingFlag(flag.CommandLine)
+	addDryRunFlag(flag.CommandLine, "Change nothing and show what the installer would do: the files and actions of a fresh install, or what re-running it on an existing install would change")
+	addDryRunDirFlag(flag.CommandLine)
+	addTerminalFlags(flag.CommandLine)
+	addPromptFlags(flag.CommandLine)
+	addTemplatesFlag(flag.CommandLine)
+	addForceOverwriteFlag(flag.CommandLine)
+	addDataDirFlag(flag.CommandLine)
+	addExternalCheckFlag(flag.CommandLine)
+	runBackupNowFlag := flag.Bool("run-backup-now", false, "Run the backup script once after the installation, or on an existing install, to prove that it works")
+	dumpTemplatesFlag := flag.String("dump-templates", "", "Write the built-in templates to this directory and exit, as a starting point for --templates-dir")
+	flag.Usage = printUsage
 	flag.Parse()
+	resolveTerminal()
+	loadAnswersFile()
+	checkAnswerEnvironment()
+	answerWithoutTerminal()
+
+	if *dumpTemplatesFlag != "" {
+		if err := dumpTemplates(*dumpTemplatesFlag); err != nil {
+			fatalf("Error writing the templates: %v\n", err)
+		}
+		return
+	}
 
-	// print a banner about prerequisites - opening port 80, 443, 51820, and 21820 on the VPS and firewall and pointing your domain to the VPS IP with a records. Docs are at http://localhost:3000/Getting%20Started/dns-networking
-
-	fmt.Println("Welcome to the Pangolin installer!")
-	fmt.Println("This installer will help you set up Pangolin on your server.")
-	fmt.Println("\nPlease make sure you have the following prerequisites:")
-	fmt.Println("- Open TCP ports 80 and 443 and UDP ports 51820 and 21820 on your VPS and firewall.")
-	fmt.Println("\nLets get started!")
+	switch *outputFlag {
+	case "text":
+	case "json", "yaml":
+		// Keep stdout clean for the result document
+		consoleOut = os.Stderr
+	default:
+		exitf(exitInvalidInput, "Error: unsupported --output format %q (expected text, json or yaml)\n", *outputFlag)
+	}
+	if *removeCrowdsecFlag {
+		runRemoveCrowdsec()
+		return
+	}
+	if dryRun {
+		runReconfigureDryRun()
+		return
+	}
 
-	if os.Geteuid() == 0 { // WE NEED TO BE SUDO TO CHECK THIS
-		for _, p := range []int{80, 443} {
-			if err := checkPortsAvailable(p); err != nil {
-				fmt.Fprintln(os.Stderr, err)
+	// print a banner about prerequisites - opening port 80, 443, 51820, and 21820 on the VPS and firewall and pointing your domain to the VPS IP with a records. Docs are at http://localhost:3000/Getting%20Started/dns-networking
 
-				fmt.Printf("Please close any services on ports 80/443 in order to run the installation smoothly. If you already have the Pangolin stack running, shut them down before proceeding.\n")
-				os.Exit(1)
-			}
-		}
+	infoln(tr("install.welcome"))
+	infoln(tr("install.welcome_intro"))
+	infoln("\n" + tr("install.prerequisites"))
+	infoln(tr("install.prerequisite_ports"))
+	infoln("\n" + tr("install.get_started"))
+	resolvePlatform()
+
+	if *noUpdateCheckFlag {
+		report.skip("installer update check (disabled by flag)")
+	} else if network.allow("installer update check") {
+		checkForInstallerUpdate()
 	}
+	checkSystemRequirements()
 
 	var config Config
 	var alreadyInstalled = false
@@ -104,158 +284,260 @@ func main() {
 	// Determine installation directory
 	installDir := findOrSelectInstallDirectory()
 	if err := os.Chdir(installDir); err != nil {
-		fmt.Printf("Error changing to installation directory: %v\n", err)
-		os.Exit(1)
+		fatalf("Error changing to installation directory: %v\n", err)
 	}
+	previousInstallAttempt = lastInstallAttempt(installDir)
+	openInstallLog(installDir)
+	defer installLog.close()
+	checkStorageSpeed(installDir)
+	checkSELinux()
+
+	// A fresh install that stopped early already wrote config.yml, its state
+	// file tells it apart from a completed install
+	_, statErr := os.Stat("config/config.yml")
+	_, resumeErr := os.Stat(resumeStateFile)
+	if statErr != nil || resumeErr == nil {
+		progress := offerResume()
+		if progress.done(stageAnswers) {
+			config = progress.config
+			report.skip("prompts (resumed)")
+		} else {
+			dataPath := prepareDataDir(installDir)
+			config = collectResumableInput(progress)
+			for {
+				config.InstallDir, config.DataDir = installDir, dataPath
+				runPreflight(&config, installDir)
+
+				loadVersions(&config)
+				config.DoCrowdsecInstall = false
+				edit := confirmInstall(config, progress.wizard)
+				if edit < 0 {
+					break
+				}
+				config = editResumableInput(progress, edit)
+			}
+			config.Secret = generateRandomSecretKey()
+			progress.complete(stageAnswers, config)
+		}
+		report.setConfig(config)
 
-	// check if there is already a config file
-	if _, err := os.Stat("config/config.yml"); err != nil {
-		config = collectUserInput()
-
-		loadVersions(&config)
-		config.DoCrowdsecInstall = false
-		config.Secret = generateRandomSecretKey()
+		if !progress.done(stageFiles) {
+			infoln("\n" + tr("install.section_files"))
 
-		fmt.Println("\n=== Generating Configuration Files ===")
+			dirs, files, err := renderConfigFiles(config)
+			if err != nil {
+				fatalf("Error creating config files: %v\n", err)
+			}
+			generated := generatedManifest(files)
+			edited := offerFileEdits(files)
+			files = resolveOverwrites(files)
+			cleanups.arm()
+			if err := writeRenderedFiles(dirs, files); err != nil {
+				fatalf("Error creating config files: %v\n", err)
+			}
 
-		if err := createConfigFiles(config); err != nil {
-			fmt.Printf("Error creating config files: %v\n", err)
-			os.Exit(1)
-		}
+			if writesFile(files, "config/docker-compose.yml") {
+				composeCreated := !pathExists("docker-compose.yml")
+				if err := moveFile("config/docker-compose.yml", "docker-compose.yml"); err != nil {
+					fatalf("Error moving docker-compose.yml: %v\n", err)
+				}
+				if composeCreated {
+					cleanups.push("remove docker-compose.yml", removeCreated("docker-compose.yml"))
+				}
+				report.fileRemoved("config/docker-compose.yml")
+				report.fileWritten("docker-compose.yml")
+			}
+			recordEditedFiles(files, edited)
+			recordGeneratedFiles(generated)
+			recordDataDir(config.DataDir)
+			applySELinux("docker-compose.yml", installDir, config.DataDir)
 
-		if err := moveFile("config/docker-compose.yml", "docker-compose.yml"); err != nil {
-			fmt.Printf("Error moving docker-compose.yml: %v\n", err)
-			os.Exit(1)
-		}
+			infoln("\n" + tr("install.files_created"))
 
-		fmt.Println("\nConfiguration files created successfully!")
+			configureFirewall("docker-compose.yml")
+			if config.ExternalProxy {
+				printProxyExamples(config)
+			}
 
-		// Download MaxMind Country / ASN database if requested
-		if config.EnableMaxMind {
-			fmt.Println("\n=== Downloading MaxMind Country and ASN Databases ===")
-			if err := downloadMaxMindDatabase(); err != nil {
-				fmt.Printf("Error downloading MaxMind databases: %v\n", err)
-				fmt.Println("You can download it manually later if needed.")
+			// Download MaxMind Country / ASN database if requested
+			if config.EnableMaxMind {
+				infoln("\n" + tr("install.section_maxmind_download"))
+				if err := downloadMaxMindDatabase(); err != nil {
+					errorf("%s\n", tr("install.maxmind_download_failed", err))
+					infoln(tr("install.maxmind_download_later"))
+				}
 			}
+			progress.complete(stageFiles, config)
 		}
 
-		fmt.Println("\n=== Starting installation ===")
+		infoln("\n" + tr("install.section_start"))
 
-		if readBool("Would you like to install and start the containers?", true) {
+		if config.InstallationContainerType != "" || readBool("install_containers", tr("prompt.install_containers"), true) {
 
-			config.InstallationContainerType = podmanOrDocker()
+			if config.InstallationContainerType == "" {
+				config.InstallationContainerType = podmanOrDocker()
+				progress.complete(stageFiles, config)
+			}
 
-			if !isDockerInstalled() && runtime.GOOS == "linux" && config.InstallationContainerType == Docker {
-				if readBool("Docker is not installed. Would you like to install it?", true) {
+			if !progress.done(stageDocker) && !isDockerInstalled() && platform.InstallDocker && config.InstallationContainerType == Docker {
+				if readBool("install_docker", tr("prompt.install_docker"), true) {
 					if err := installDocker(); err != nil {
-						fmt.Printf("Error installing Docker: %v\n", err)
-						return
+						exitf(exitCodeOf(err, exitPreflight), "%s\n", tr("install.docker_install_failed", err))
 					}
+					recordExternal(externalResource{Kind: resourcePackage, Description: "Docker engine"})
 
 					// try to start docker service but ignore errors
 					if err := startDockerService(); err != nil {
-						fmt.Println("Error starting Docker service:", err)
+						errorf("%s\n", tr("install.docker_start_failed", err))
 					} else {
-						fmt.Println("Docker service started successfully!")
+						infoln(tr("install.docker_started"))
 					}
 					// wait 10 seconds for docker to start checking if docker is running every 2 seconds
-					fmt.Println("Waiting for Docker to start...")
+					infoln(tr("install.docker_waiting"))
 					for range 5 {
 						if isDockerRunning() {
-							fmt.Println("Docker is running!")
+							infoln(tr("install.docker_running"))
 							break
 						}
-						fmt.Println("Docker is not running yet, waiting...")
+						infoln(tr("install.docker_not_yet"))
 						time.Sleep(2 * time.Second)
 					}
 					if !isDockerRunning() {
-						fmt.Println("Docker is still not running after 10 seconds. Please check the installation.")
-						os.Exit(1)
+						exitf(exitContainers, "%s\n", tr("install.docker_timeout"))
 					}
-					fmt.Println("Docker installed successfully!")
+					infoln(tr("install.docker_installed"))
 				}
 			}
+			if !progress.done(stageDocker) {
+				applyDockerLogDefaults(config)
+			}
+			progress.complete(stageDocker, config)
 
-			if err := pullContainers(config.InstallationContainerType); err != nil {
-				fmt.Println("Error: ", err)
-				return
+			if !progress.done(stageImages) {
+				if err := pullContainers(config.InstallationContainerType); err != nil {
+					exitf(exitCodeOf(err, exitContainers), "Error: %v\n", err)
+				}
+				progress.complete(stageImages, config)
 			}
 
+			cleanups.arm()
+			pushComposeDown(config.InstallationContainerType)
 			if err := startContainers(config.InstallationContainerType); err != nil {
-				fmt.Println("Error: ", err)
-				return
+				exitf(exitContainers, "Error: %v\n", err)
+			}
+			report.collectContainers(config.InstallationContainerType)
+
+			if err := waitForStackHealthy(config.InstallationContainerType, config.DashboardDomain); err != nil {
+				exitf(exitCodeOf(err, exitContainers), "Error: %v\n", err)
 			}
+			progress.complete(stageStack, config)
+			cleanups.disarm()
+			if len(config.TLSPassthroughs) > 0 {
+				if err := verifySNIRouting(config.DashboardDomain, config.TLSPassthroughs); err != nil {
+					warnf("Warning: %v\n", err)
+				}
+			}
+
+			offerSystemdUnit(config.InstallationContainerType, installDir)
+			installUpdateSchedule(config, installDir)
+			installBackupSchedule(config, installDir)
+			installCertificateWatcher(config, installDir)
+		} else {
+			report.skip("container start (declined)")
 		}
+		cleanups.disarm()
 
 	} else {
 		alreadyInstalled = true
-		fmt.Println("Looks like you already installed Pangolin!")
+		infoln(tr("install.already_installed"))
+		reconfigured := offerReconfigure(installDir)
+		labelExistingInstall(detectContainerType())
+		if !installedBehindExistingProxy() {
+			repairACMEStore(detectContainerType())
+		}
+		reconcileServerPorts(detectContainerType())
 
 		// Check if MaxMind database exists and offer to update it
-		fmt.Println("\n=== MaxMind Database Update ===")
+		infoln("\n" + tr("install.section_maxmind_update"))
 		if _, err := os.Stat("config/GeoLite2-Country.mmdb"); err == nil {
-			fmt.Println("MaxMind GeoLite2 Country database found.")
-			if readBool("Would you like to update the MaxMind databases (Country and ASN) to the latest version?", false) {
+			infoln(tr("install.maxmind_found"))
+			if readBool("update_maxmind", tr("prompt.update_maxmind"), false) {
 				if err := downloadMaxMindDatabase(); err != nil {
-					fmt.Printf("Error updating MaxMind database: %v\n", err)
-					fmt.Println("You can try updating it manually later if needed.")
+					errorf("%s\n", tr("install.maxmind_update_failed", err))
+					infoln(tr("install.maxmind_update_later"))
 				}
 			}
 		} else {
-			fmt.Println("MaxMind GeoLite2 Country and ASN databases not found.")
-			if readBool("Would you like to download the MaxMind GeoLite2 databases for blocking functionality?", false) {
+			infoln(tr("install.maxmind_missing"))
+			if readBool("download_maxmind", tr("prompt.download_maxmind"), false) {
 				if err := downloadMaxMindDatabase(); err != nil {
-					fmt.Printf("Error downloading MaxMind database: %v\n", err)
-					fmt.Println("You can try downloading it manually later if needed.")
+					errorf("%s\n", tr("install.maxmind_download_failed", err))
+					infoln(tr("install.maxmind_retry_later"))
 				}
 				// Now you need to update your config file accordingly to enable geoblocking
-				fmt.Print("Please remember to update your config/config.yml file to enable geoblocking! \n\n")
+				infof("Please remember to update your config/config.yml file to enable geoblocking! \n\n")
 				// add   maxmind_db_path: "./config/GeoLite2-Country.mmdb" under server
 				// add   maxmind_asn_path: "./config/GeoLite2-ASN.mmdb" under server
-				fmt.Println("Add the following lines under the 'server' section:")
-				fmt.Println("  maxmind_db_path: \"./config/GeoLite2-Country.mmdb\"")
-				fmt.Println("  maxmind_asn_path: \"./config/GeoLite2-ASN.mmdb\"")
+				infoln(tr("install.maxmind_config"))
+				infof("  maxmind_db_path: %q\n", "./config/GeoLite2-Country.mmdb")
+				infof("  maxmind_asn_path: %q\n", "./config/GeoLite2-ASN.mmdb")
 			}
 		}
+		if !reconfigured {
+			reconfigureGeoBlock(detectContainerType())
+			reconfigureHardening()
+		}
 	}
 
-	if *crowdsecFlag && !checkIsCrowdsecInstalledInCompose() {
-		fmt.Println("\n=== CrowdSec Install ===")
+	if *crowdsecFlag && (config.ExternalProxy || installedBehindExistingProxy()) {
+		infoln(tr("install.crowdsec_existing_proxy"))
+		report.skip("CrowdSec (existing reverse proxy)")
+	} else if *crowdsecFlag && !checkIsCrowdsecInstalledInCompose() {
+		infoln("\n" + tr("install.section_crowdsec"))
 		// check if crowdsec is installed
-		if readBool("Would you like to install CrowdSec?", false) {
-			fmt.Println("This installer constitutes a minimal viable CrowdSec deployment. CrowdSec will add extra complexity to your Pangolin installation and may not work to the best of its abilities out of the box. Users are expected to implement configuration adjustments on their own to achieve the best security posture. Consult the CrowdSec documentation for detailed configuration instructions.")
+		if readBool("install_crowdsec", tr("prompt.install_crowdsec"), false) {
+			infoln(tr("install.crowdsec_disclaimer"))
 
 			// BUG: crowdsec installation will be skipped if the user chooses to install on the first installation.
-			if readBool("Are you willing to manage CrowdSec?", false) {
+			if readBool("manage_crowdsec", tr("prompt.manage_crowdsec"), false) {
 				if config.DashboardDomain == "" {
 					traefikConfig, err := ReadTraefikConfig("config/traefik/traefik_config.yml")
 					if err != nil {
-						fmt.Printf("Error reading config: %v\n", err)
-						return
+						fatalf("Error reading config: %v\n", err)
 					}
 					appConfig, err := ReadAppConfig("config/config.yml")
 					if err != nil {
-						fmt.Printf("Error reading config: %v\n", err)
-						return
+						fatalf("Error reading config: %v\n", err)
 					}
 
 					parsedURL, err := url.Parse(appConfig.DashboardURL)
 					if err != nil {
-						fmt.Printf("Error parsing URL: %v\n", err)
-						return
+						fatalf("Error parsing URL: %v\n", err)
 					}
 
 					config.DashboardDomain = parsedURL.Hostname()
 					config.LetsEncryptEmail = traefikConfig.LetsEncryptEmail
 					config.BadgerVersion = traefikConfig.BadgerVersion
+					config.HTTPPort = traefikConfig.HTTPPort
+					config.HTTPSPort = traefikConfig.HTTPSPort
+					config.HTTPMode = traefikConfig.HTTPMode
+					config.LogMaxSize, config.LogMaxFile = installedLogRotation("docker-compose.yml")
+					config.ACMEChallenge = traefikConfig.ACMEChallenge
+					if config.OwnCertificates = installedOwnCertificates(); len(config.OwnCertificates) > 0 {
+						config.CertificateSource = certificateOwn
+					}
+					config.ACMEStaging = traefikConfig.ACMEStaging
+					config.DNSProvider = traefikConfig.DNSProvider
+					config.EnableIPv6 = traefikConfig.EnableIPv6
+					config.PangolinPorts = installedPangolinPorts("config/config.yml")
 
 					// print the values and check if they are right
-					fmt.Println("Detected values:")
-					fmt.Printf("Dashboard Domain: %s\n", config.DashboardDomain)
-					fmt.Printf("Let's Encrypt Email: %s\n", config.LetsEncryptEmail)
-					fmt.Printf("Badger Version: %s\n", config.BadgerVersion)
+					infoln(tr("install.detected_values"))
+					infof("Dashboard Domain: %s\n", config.DashboardDomain)
+					infof("Let's Encrypt Email: %s\n", config.LetsEncryptEmail)
+					infof("Badger Version: %s\n", config.BadgerVersion)
 
-					if !readBool("Are these values correct?", true) {
+					if !readBool("confirm_detected_values", tr("prompt.confirm_detected_values"), true) {
 						config = collectUserInput()
 					}
 				}
@@ -264,47 +546,202 @@ func main() {
 				detectedType := detectContainerType()
 				if detectedType == Undefined {
 					// If detection fails, prompt the user
-					fmt.Println("Unable to detect container type from existing installation.")
+					infoln(tr("install.container_type_unknown"))
 					config.InstallationContainerType = podmanOrDocker()
 				} else {
 					config.InstallationContainerType = detectedType
-					fmt.Printf("Detected container type: %s\n", config.InstallationContainerType)
+					infof("Detected container type: %s\n", config.InstallationContainerType)
 				}
 
+				collectCrowdsecOptions(&config)
 				config.DoCrowdsecInstall = true
 				err := installCrowdsec(config, installDir)
 				if err != nil {
-					fmt.Printf("Error installing CrowdSec: %v\n", err)
-					return
+					fatalf("Error installing CrowdSec: %v\n", err)
 				}
 
-				fmt.Println("CrowdSec installed successfully!")
+				infoln(tr("install.crowdsec_installed"))
 			}
 		}
 	}
 
+	// Check if containers were started during this installation
+	containersStarted := false
+	adminReady := false
 	if !alreadyInstalled || config.DoCrowdsecInstall {
-		// Setup Token Section
-		fmt.Println("\n=== Setup Token ===")
-
-		// Check if containers were started during this installation
-		containersStarted := false
 		if (isDockerInstalled() && config.InstallationContainerType == Docker) ||
 			(isPodmanInstalled() && config.InstallationContainerType == Podman) {
-			// Try to fetch and display the token if containers are running
+			// Create the admin account, or fetch and display the setup token,
+			// if containers are running
 			containersStarted = true
-			printSetupToken(config.InstallationContainerType, config.DashboardDomain)
+			adminReady = setupFirstAdmin(config)
 		}
 
 		// If containers weren't started or token wasn't found, show instructions
 		if !containersStarted {
-			showSetupTokenInstructions(config.InstallationContainerType, config.DashboardDomain)
+			infoln("\n" + tr("install.section_setup_token"))
+			showSetupTokenInstructions(config.InstallationContainerType, config.DashboardURL())
+		}
+	}
+
+	if alreadyInstalled {
+		if config.DashboardDomain == "" {
+			config.DashboardDomain = installedDashboardDomain()
+		}
+		if config.HTTPSPort == 0 {
+			_, config.HTTPSPort = installedEntrypointPorts("config/traefik/traefik_config.yml")
+		}
+		if traefikConfig, err := ReadTraefikConfig("config/traefik/traefik_config.yml"); err == nil {
+			config.ACMEStaging = traefikConfig.ACMEStaging
+		}
+		if config.DashboardDomain != "" {
+			offerStatusToken(installedDashboardURL())
 		}
 	}
 
-	fmt.Println("\nInstallation complete!")
+	if *runBackupNowFlag {
+		runBackupNow()
+	}
+
+	clearInstallProgress()
+	telemetrySent := sendTelemetry(config)
+	infoln("\n" + tr("summary.complete"))
+	if len(config.AdditionalDomains) > 0 {
+		infoln(tr("summary.domains", strings.Join(config.BaseDomains(), ", ")))
+	}
+
+	printFirstLogin(config, adminReady)
+	printStagingNotice(config)
+	printSecretFiles(config, installDir)
+	printExternalPorts("docker-compose.yml")
+	if containersStarted && !alreadyInstalled {
+		runExternalCheck(config)
+	}
+	saveAnswerProvenance()
+	printAnswerProvenance()
+	infoln("\n" + tr("summary.log_written", installLog.path))
 
-	fmt.Printf("\nTo complete the initial setup, please visit:\nhttps://%s/auth/initial-setup\n", config.DashboardDomain)
+	report.setConfig(config)
+	<-telemetrySent
+	report.emit("success", "")
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(out, "Usage: %s [flags] [--dry-run]\n", name)
+	fmt.Fprintf(out, "       %s --remove-crowdsec [--dry-run]\n", name)
+	fmt.Fprintf(out, "       %s --dump-templates <dir>\n", name)
+	fmt.Fprintf(out, "       %s status [--remote <url> --token-file <path>]\n", name)
+	fmt.Fprintf(out, "       %s plan [--out plan.bin] [--dir <path>]\n", name)
+	fmt.Fprintf(out, "       %s apply [--offline] <plan.bin>\n", name)
+	fmt.Fprintf(out, "       %s verify [--dir <path>]\n", name)
+	fmt.Fprintf(out, "       %s fingerprint [--out <file>]\n", name)
+	fmt.Fprintf(out, "       %s upgrade [--dir <path>] [--version vX.Y.Z] [--dry-run] [--yes] [--output json]\n", name)
+	fmt.Fprintf(out, "       %s rollback [--dir <path>] [--snapshot <file>] [--dry-run] [--yes]\n", name)
+	fmt.Fprintf(out, "       %s uninstall [--dir <path>] [--confirm <domain>] [--keep <artifacts>] [--dry-run]\n", name)
+	fmt.Fprintf(out, "       %s tunnel [--host <user@server>] [--domain <domain>] [--local-port <port>] [--connect]\n", name)
+	fmt.Fprintf(out, "       %s doctor [--dir <path>] [--skip-external-check] [--offline]\n", name)
+	fmt.Fprintf(out, "       %s diagnose [--dir <path>] [--out <file>]\n", name)
+	fmt.Fprintf(out, "       %s config get [--dir <path>] [--provenance] [key...]\n\nFlags:\n", name)
+	printFlags(flag.CommandLine)
+	fmt.Fprintf(out, `
+Examples:
+  Interactive install:
+    sudo ./installer
+
+  Unattended install of a test server, with the defaults for everything else:
+    sudo ./installer --yes --domain test.example.com --email me@example.com
+
+  Provision unattended and read the admin password and an API token from the result:
+    sudo ./installer --yes --domain example.com --email me@example.com --create-api-token --output json > result.json
+
+  Hand the generated files, services and ports to orchestration tooling without the secrets:
+    sudo ./installer --yes --domain example.com --email me@example.com --output yaml --redact-secrets > result.yml
+
+  Provision from an answers file (YAML or JSON keyed like the prompt flags, e.g. base_domain):
+    sudo ./installer --answers answers.yml
+
+  Answer prompts from the environment, e.g. in a CI pipeline (PANGOLIN_INSTALL_ and the prompt key):
+    PANGOLIN_INSTALL_BASE_DOMAIN=example.com PANGOLIN_INSTALL_ADMIN_EMAIL=me@example.com sudo -E ./installer --yes
+
+  Pre-answer a few prompts and be asked for the rest:
+    sudo ./installer --admin-email admin@example.com --install-crowdsec=false
+
+  Install to /srv/pangolin with the database on another disk:
+    sudo ./installer --install-dir /srv/pangolin --data-dir /mnt/data/pangolin
+
+  Keep local changes to the generated files across installer runs:
+    ./installer --dump-templates templates       (then edit and trim templates/)
+    sudo ./installer --templates-dir templates
+
+  Re-run over an install with hand-edited files, overwriting them:
+    sudo ./installer --force-overwrite
+
+  Upgrade to a specific Pangolin release, after a report of what changes:
+    sudo ./installer upgrade --version v1.12.0
+
+  Undo the last upgrade from the snapshot it took:
+    sudo ./installer rollback
+
+  Start over, keeping the certificates and the database for the next install:
+    sudo ./installer uninstall --keep certificates,database
+
+  Change the answers of an existing install, starting from its current values:
+    cd /opt/pangolin && sudo ./installer --reconfigure
+
+  Find out which flag, variable or file supplied an answer of the install:
+    ./installer config get --provenance base_domain
+
+  Show the state of the local stack, or of a remote instance:
+    ./installer status
+    ./installer status --remote https://api.example.com --token-file status-api-token
+
+  Review a plan, then apply exactly that plan later:
+    ./installer plan --out plan.bin
+    sudo ./installer apply plan.bin
+
+  Review what an upgrade to this installer's versions changes, e.g. for a change ticket:
+    ./installer upgrade --dry-run --output json > upgrade-report.json
+
+  Capture this host's environment for a bug report, and replay it elsewhere:
+    ./installer fingerprint --out fingerprint.json
+    ./installer plan --simulate-fingerprint fingerprint.json
+
+  Reach the dashboard over SSH before DNS and certificates work:
+    ./installer tunnel                                  (on the server, prints the command)
+    ./installer tunnel --domain pangolin.example.com --host admin@203.0.113.10 --connect
+
+  Check the running deployment end to end, and repair a broken acme.json:
+    sudo ./installer doctor
+
+  Check a broken stack and write a redacted pangolin-diagnostics.txt to attach to an issue:
+    sudo ./installer diagnose
+
+  Review the files a fresh install would write, without writing them or touching containers:
+    ./installer --dry-run --dry-run-dir ./review
+
+  Remove CrowdSec from an existing install, after reviewing the changes:
+    sudo ./installer --remove-crowdsec --dry-run
+    sudo ./installer --remove-crowdsec
+
+  Plain prompts without colors, e.g. for a screen reader:
+    sudo ./installer --accessible --no-color
+
+  Related questions, like the SMTP settings, on one screen each:
+    sudo ./installer --grouped-forms
+
+  CI / automation (never waits for input, fails listing the prompts answers.yml leaves unanswered):
+    sudo ./installer --non-interactive --no-update-check --answers answers.yml
+
+  cloud-init user-data, retrying downloads that failed (exit code 4):
+    until ./installer --yes --domain example.com --email me@example.com; do [ $? -eq 4 ] || exit 1; sleep 30; done
+
+Without a terminal on stdin the prompts are answered like --yes, and the run
+exits with code 2 listing the prompts that need a flag instead of waiting.
+
+`)
+	fmt.Fprint(out, exitCodeUsage)
 }
 
 func hasExistingInstall(dir string) bool {
@@ -314,79 +751,94 @@ func hasExistingInstall(dir string) bool {
 }
 
 func findOrSelectInstallDirectory() string {
-	const defaultInstallDir = "/opt/pangolin"
-
 	// Get current working directory
 	cwd, err := os.Getwd()
 	if err != nil {
-		fmt.Printf("Error getting current directory: %v\n", err)
-		os.Exit(1)
+		fatalf("Error getting current directory: %v\n", err)
 	}
 
-	// 1. Check current directory for existing install
-	if hasExistingInstall(cwd) {
-		fmt.Printf("Found existing Pangolin installation in current directory: %s\n", cwd)
-		return cwd
-	}
+	// A preset install_dir is the only place to look for an existing install
+	if dir, _, ok := presetValue("install_dir"); ok {
+		dir, err := filepath.Abs(expandHome(dir))
+		if err != nil {
+			fatalf("Error resolving path: %v\n", err)
+		}
+		if hasExistingInstall(dir) {
+			infof("Found existing Pangolin installation at: %s\n", dir)
+			return dir
+		}
+		if offerLegacyMigration(dir) {
+			return dir
+		}
+	} else {
+		// 1. Check current directory for existing install
+		if hasExistingInstall(cwd) {
+			infof("Found existing Pangolin installation in current directory: %s\n", cwd)
+			return cwd
+		}
 
-	// 2. Check default location (/opt/pangolin) for existing install
-	if cwd != defaultInstallDir && hasExistingInstall(defaultInstallDir) {
-		fmt.Printf("\nFound existing Pangolin installation at: %s\n", defaultInstallDir)
-		if readBool(fmt.Sprintf("Would you like to use the existing installation at %s?", defaultInstallDir), true) {
-			return defaultInstallDir
+		// 2. Check default location (/opt/pangolin) for existing install
+		if cwd != defaultInstallDir && hasExistingInstall(defaultInstallDir) {
+			infof("\nFound existing Pangolin installation at: %s\n", defaultInstallDir)
+			if readBool("use_existing_install", tr("prompt.use_existing_install", defaultInstallDir), true) {
+				return defaultInstallDir
+			}
 		}
-	}
 
-	// 3. No existing install found, prompt for installation directory
-	fmt.Println("\n=== Installation Directory ===")
-	fmt.Println("No existing Pangolin installation detected.")
+		// 3. Check both locations for layouts written by older installers
+		for _, dir := range []string{cwd, defaultInstallDir} {
+			if offerLegacyMigration(dir) {
+				return dir
+			}
+		}
+	}
 
-	installDir := readString("Enter the installation directory", defaultInstallDir)
+	// 4. No existing install found, prompt for installation directory
+	infoln("\n" + tr("install.section_install_dir"))
+	infoln(tr("install.no_existing_install"))
 
-	// Expand ~ to home directory if present
-	if strings.HasPrefix(installDir, "~") {
-		home, err := os.UserHomeDir()
+	var installDir string
+	for {
+		installDir, err = filepath.Abs(expandHome(readString("install_dir", tr("prompt.install_dir"), defaultInstallDir)))
 		if err != nil {
-			fmt.Printf("Error getting home directory: %v\n", err)
-			os.Exit(1)
+			fatalf("Error resolving path: %v\n", err)
 		}
-		installDir = filepath.Join(home, installDir[1:])
-	}
-
-	// Convert to absolute path
-	absPath, err := filepath.Abs(installDir)
-	if err != nil {
-		fmt.Printf("Error resolving path: %v\n", err)
-		os.Exit(1)
+		err = checkInstallPath(installDir)
+		if err == nil {
+			break
+		}
+		// A flag or default would fail the same way again
+		if _, _, ok := presetValue("install_dir"); ok || acceptDefaults || nonInteractive {
+			exitf(exitPreflight, "%s\n", tr("install.dir_unusable", installDir, err))
+		}
+		errorf("%s\n", tr("install.dir_unusable", installDir, err))
 	}
-	installDir = absPath
 
 	// Check if directory exists
 	if _, err := os.Stat(installDir); os.IsNotExist(err) {
 		// Directory doesn't exist, create it
-		if readBool(fmt.Sprintf("Directory %s does not exist. Create it?", installDir), true) {
+		if readBool("create_install_dir", tr("prompt.create_install_dir", installDir), true) {
 			if err := os.MkdirAll(installDir, 0755); err != nil {
-				fmt.Printf("Error creating directory: %v\n", err)
-				os.Exit(1)
+				fatalf("Error creating directory: %v\n", err)
 			}
-			fmt.Printf("Created directory: %s\n", installDir)
+			infof("Created directory: %s\n", installDir)
 
 			// Offer to change ownership if running via sudo
 			changeDirectoryOwnership(installDir)
 		} else {
-			fmt.Println("Installation cancelled.")
+			infoln(tr("install.cancelled"))
 			os.Exit(0)
 		}
 	}
 
-	fmt.Printf("Installation directory: %s\n", installDir)
+	infof("Installation directory: %s\n", installDir)
 	return installDir
 }
 
 func changeDirectoryOwnership(dir string) {
 	// Check if we're running via sudo by looking for SUDO_USER
 	sudoUser := os.Getenv("SUDO_USER")
-	if sudoUser == "" || os.Geteuid() != 0 {
+	if sudoUser == "" || !isRoot() {
 		return
 	}
 
@@ -397,29 +849,35 @@ func changeDirectoryOwnership(dir string) {
 		return
 	}
 
-	fmt.Printf("\nRunning as root via sudo (original user: %s)\n", sudoUser)
-	if readBool(fmt.Sprintf("Would you like to change ownership of %s to user '%s'? This makes it easier to manage config files without sudo.", dir, sudoUser), true) {
+	infof("\nRunning as root via sudo (original user: %s)\n", sudoUser)
+	if readBool("change_ownership", tr("prompt.change_ownership", dir, sudoUser), true) {
 		uid, err := strconv.Atoi(sudoUID)
 		if err != nil {
-			fmt.Printf("Warning: Could not parse SUDO_UID: %v\n", err)
+			warnf("Warning: Could not parse SUDO_UID: %v\n", err)
 			return
 		}
 		gid, err := strconv.Atoi(sudoGID)
 		if err != nil {
-			fmt.Printf("Warning: Could not parse SUDO_GID: %v\n", err)
+			warnf("Warning: Could not parse SUDO_GID: %v\n", err)
 			return
 		}
 
 		if err := os.Chown(dir, uid, gid); err != nil {
-			fmt.Printf("Warning: Could not change ownership: %v\n", err)
+			warnf("Warning: Could not change ownership: %v\n", err)
 		} else {
-			fmt.Printf("Changed ownership of %s to %s\n", dir, sudoUser)
+			infof("Changed ownership of %s to %s\n", dir, sudoUser)
 		}
 	}
 }
 
 func podmanOrDocker() SupportedContainer {
-	inputContainer := readString("Would you like to run Pangolin as Docker or Podman containers?", "docker")
+	chosenContainer := readContainerType()
+	prepareContainerRuntime(chosenContainer)
+	return chosenContainer
+}
+
+func readContainerType() SupportedContainer {
+	inputContainer := readString("container_type", tr("prompt.container_type"), "docker")
 
 	chosenContainer := Docker
 	if strings.EqualFold(inputContainer, "docker") {
@@ -427,25 +885,29 @@ func podmanOrDocker() SupportedContainer {
 	} else if strings.EqualFold(inputContainer, "podman") {
 		chosenContainer = Podman
 	} else {
-		fmt.Printf("Unrecognized container type: %s. Valid options are 'docker' or 'podman'.\n", inputContainer)
-		os.Exit(1)
+		exitf(exitInvalidInput, "Unrecognized container type: %s. Valid options are 'docker' or 'podman'.\n", inputContainer)
 	}
+	return chosenContainer
+}
 
+// prepareContainerRuntime checks that the chosen runtime is usable and offers
+// the host configuration it needs
+func prepareContainerRuntime(chosenContainer SupportedContainer) {
 	switch chosenContainer {
 	case Podman:
 		if !isPodmanInstalled() {
-			fmt.Println("Podman or podman-compose is not installed. Please install both manually. Automated installation will be available in a later release.")
-			os.Exit(1)
+			exitf(exitContainers, "%s\n", tr("install.podman_missing"))
 		}
 
-		if err := exec.Command("bash", "-c", "cat /etc/sysctl.d/99-podman.conf 2>/dev/null | grep 'net.ipv4.ip_unprivileged_port_start=' || cat /etc/sysctl.conf 2>/dev/null | grep 'net.ipv4.ip_unprivileged_port_start='").Run(); err != nil {
-			fmt.Println("Would you like to configure ports >= 80 as unprivileged ports? This enables podman containers to listen on low-range ports.")
-			fmt.Println("Pangolin will experience startup issues if this is not configured, because it needs to listen on port 80/443 by default.")
-			approved := readBool("The installer is about to execute \"echo 'net.ipv4.ip_unprivileged_port_start=80' > /etc/sysctl.d/99-podman.conf && sysctl --system\". Approve?", true)
+		if !platform.LinuxPreflight {
+			logf("INFO", "skipping the unprivileged port check on %s", platform.Name)
+		} else if err := runCmd(exec.Command("bash", "-c", "cat /etc/sysctl.d/99-podman.conf 2>/dev/null | grep 'net.ipv4.ip_unprivileged_port_start=' || cat /etc/sysctl.conf 2>/dev/null | grep 'net.ipv4.ip_unprivileged_port_start='")); err != nil {
+			infoln(tr("install.unprivileged_ports"))
+			infoln(tr("install.unprivileged_ports_needed"))
+			approved := readBool("configure_unprivileged_ports", tr("prompt.configure_unprivileged_ports"), true)
 			if approved {
-				if os.Geteuid() != 0 {
-					fmt.Println("You need to run the installer as root for such a configuration.")
-					os.Exit(1)
+				if !isRoot() {
+					exitf(exitPreflight, "%s\n", tr("install.unprivileged_ports_root"))
 				}
 
 				// Podman containers are not able to listen on privileged ports. The official recommendation is to
@@ -453,126 +915,189 @@ func podmanOrDocker() SupportedContainer {
 				// Linux only.
 
 				if err := run("bash", "-c", "echo 'net.ipv4.ip_unprivileged_port_start=80' > /etc/sysctl.d/99-podman.conf && sysctl --system"); err != nil {
-					fmt.Printf("Error configuring unprivileged ports: %v\n", err)
-					os.Exit(1)
+					exitf(exitPreflight, "Error configuring unprivileged ports: %v\n", err)
 				}
+				recordExternal(externalResource{
+					Kind:        resourceFile,
+					Path:        "/etc/sysctl.d/99-podman.conf",
+					Description: "unprivileged port configuration for Podman",
+					Undo:        "sysctl --system",
+				})
 			} else {
-				fmt.Println("You need to configure port forwarding or adjust the listening ports before running pangolin.")
+				infoln(tr("install.unprivileged_ports_declined"))
 			}
 		} else {
-			fmt.Println("Unprivileged ports have been configured.")
+			infoln(tr("install.unprivileged_ports_configured"))
 		}
 
 	case Docker:
 		// check if docker is not installed and the user is root
 		if !isDockerInstalled() {
-			if os.Geteuid() != 0 {
-				fmt.Println("Docker is not installed. Please install Docker manually or run this installer as root.")
-				os.Exit(1)
+			if !platform.InstallDocker {
+				exitf(exitContainers, "%s\n", tr("install.docker_missing_desktop"))
+			}
+			if !isRoot() {
+				exitf(exitContainers, "%s\n", tr("install.docker_missing"))
 			}
 		}
 
 		// check if the user is in the docker group (linux only)
 		if !isUserInDockerGroup() {
-			fmt.Println("You are not in the docker group.")
-			fmt.Println("The installer will not be able to run docker commands without running it as root.")
-			os.Exit(1)
+			errorf("%s\n", tr("install.docker_group"))
+			exitf(exitPreflight, "%s\n", tr("install.docker_group_needed"))
 		}
 	default:
 		// This shouldn't happen unless there's a third container runtime.
 		os.Exit(1)
 	}
-
-	return chosenContainer
 }
 
+// collectUserInput runs the wizard, letting the user go back to earlier
+// questions, see wizardSession
 func collectUserInput() Config {
+	return runWizard(askUserInput, nil, nil)
+}
+
+func askUserInput() Config {
 	config := Config{}
+	collectingAnswers = true
+	defer func() { collectingAnswers = false }()
 
 	// Basic configuration
-	fmt.Println("\n=== Basic Configuration ===")
+	infoln("\n" + tr("install.section_basic"))
 
-	config.IsEnterprise = readBoolNoDefault("Do you want to install the Enterprise version of Pangolin? The EE is free for personal use or for businesses making less than 100k USD annually.")
-    if config.IsEnterprise {
-        if *redisFlag {
+	config.IsEnterprise = readBool("enterprise", tr("prompt.enterprise"), false)
+	if config.IsEnterprise {
+		if *redisFlag || installedSettings.IsRedis {
 			config.IsRedis = true
-            config.IsRedisPass = readPassword("Enter a unique password for the Redis service.")
-        }
-    }
+			recordFlagAnswer("redis", "true")
+			config.IsRedisPass = readPassword("redis_password", tr("prompt.redis_password"))
+		}
+	}
 
-    config.IsPostgreSQL = readBool("Do you want to use PostgreSQL (not recommended for most users)?", false)
+	config.IsPostgreSQL = readBool("postgresql", tr("prompt.postgresql"), false)
 	if config.IsPostgreSQL {
-		config.IsPostgreSQLPass = readPassword("Enter a unique password for the PostgreSQL pangolin user.")
+		config.ExternalPostgreSQL = readBool("postgresql_external", tr("prompt.postgresql_external"), false)
+		if config.ExternalPostgreSQL {
+			collectExternalPostgreSQL(&config)
+		} else {
+			config.IsPostgreSQLPass = readPassword("postgresql_password", tr("prompt.postgresql_password"))
+		}
 	}
 
-	config.BaseDomain = readString("Enter your base domain (no subdomain e.g. example.com)", "")
+	for {
+		config.BaseDomain = readDomain("base_domain", tr("prompt.base_domain"), config.BaseDomain)
+		collectAdditionalDomains(&config)
 
-	// Set default dashboard domain after base domain is collected
-	defaultDashboardDomain := ""
-	if config.BaseDomain != "" {
-		defaultDashboardDomain = "pangolin." + config.BaseDomain
+		// Set default dashboard domain after base domain is collected
+		defaultDashboardDomain := ""
+		if config.BaseDomain != "" {
+			defaultDashboardDomain = "pangolin." + config.BaseDomain
+		}
+		// Without a base domain --yes has no default to offer, it is reported
+		// missing instead
+		if !answerMissing("base_domain") {
+			config.DashboardDomain = readDomain("dashboard_domain", tr("prompt.dashboard_domain"), defaultDashboardDomain)
+		}
+		if !checkDomainRecords(config) {
+			break
+		}
+	}
+	collectInstallType(&config)
+	// A reconfigured install has its admin account already
+	if !reconfiguring {
+		config.AdminEmail = readEmail("admin_email", tr("prompt.admin_email"), "")
+	}
+	if !config.ExternalProxy {
+		collectCertificateSource(&config)
+	}
+	if !config.ExternalProxy && !config.OwnCertificate() && !answerMissing("admin_email") {
+		config.LetsEncryptEmail = readEmail("letsencrypt_email", tr("prompt.letsencrypt_email"), config.AdminEmail)
+	}
+	if !reconfiguring {
+		collectAdminAccount(&config)
+	}
+	config.InstallGerbil = readBool("install_gerbil", tr("prompt.install_gerbil"), true)
+	if config.InstallGerbil {
+		collectWireGuardPort(&config)
+		collectPublicEndpoint(&config)
+	}
+	if !config.ExternalProxy {
+		collectHTTPMode(&config)
+		collectEntrypointPorts(&config)
+		if !config.OwnCertificate() {
+			collectCertificateChallenge(&config)
+		}
 	}
-	config.DashboardDomain = readString("Enter the domain for the Pangolin dashboard", defaultDashboardDomain)
-	config.LetsEncryptEmail = readString("Enter email for Let's Encrypt certificates", "")
-	config.InstallGerbil = readBool("Do you want to use Gerbil to allow tunneled connections", true)
 
 	// Email configuration
-	fmt.Println("\n=== Email Configuration ===")
-	config.EnableEmail = readBool("Enable email functionality (SMTP)", false)
+	infoln("\n" + tr("install.section_email"))
+	config.EnableEmail = readBool("enable_email", tr("prompt.enable_email"), false)
 
 	if config.EnableEmail {
-		config.EmailSMTPHost = readString("Enter SMTP host", "")
-		config.EmailSMTPPort = readInt("Enter SMTP port (default 587)", 587)
-		config.EmailSMTPUser = readString("Enter SMTP username", "")
-		config.EmailSMTPPass = readPassword("Enter SMTP password")
-		config.EmailNoReply = readString("Enter no-reply email address (often the same as SMTP username)", "")
+		collectEmailSettings(&config)
 	}
 
 	// Validate required fields
+	checkMissingAnswers()
 	if config.BaseDomain == "" {
-		fmt.Println("Error: Domain name is required")
-		os.Exit(1)
+		exitf(exitInvalidInput, "Error: Domain name is required\n")
 	}
-	if config.LetsEncryptEmail == "" {
-		fmt.Println("Error: Let's Encrypt email is required")
-		os.Exit(1)
+	if config.LetsEncryptEmail == "" && !config.ExternalProxy && !config.OwnCertificate() {
+		exitf(exitInvalidInput, "Error: Let's Encrypt email is required\n")
 	}
 	if config.EnableEmail && config.EmailNoReply == "" {
-		fmt.Println("Error: No-reply email address is required when email is enabled")
-		os.Exit(1)
+		exitf(exitInvalidInput, "Error: No-reply email address is required when email is enabled\n")
 	}
 
 	// Advanced configuration
 
-	fmt.Println("\n=== Advanced Configuration ===")
-
-	config.EnableIPv6 = readBool("Is your server IPv6 capable?", true)
-	config.EnableMaxMind = readBool("Do you want to download the MaxMind GeoLite2 Country and ASN databases for blocking functionality?", true)
+	infoln("\n" + tr("install.section_advanced"))
+
+	collectIPv6(&config)
+	collectTimezone(&config)
+	collectLogRotation(&config)
+	collectAutoUpdates(&config)
+	collectBackups(&config)
+	collectPangolinPorts(&config)
+	collectDockerSecrets(&config)
+	config.EnableMaxMind = readBool("enable_maxmind", tr("prompt.enable_maxmind"), true)
+	if !config.ExternalProxy {
+		collectGeoBlock(&config)
+		collectHardening(&config, true, defaultRateLimitAverage, defaultRateLimitBurst)
+		collectTLSPassthroughs(&config)
+	}
+	collectOIDCProvider(&config)
+	collectTelemetry(&config)
+	checkMissingAnswers()
 
 	if config.DashboardDomain == "" {
-		fmt.Println("Error: Dashboard Domain name is required")
-		os.Exit(1)
+		exitf(exitInvalidInput, "Error: Dashboard Domain name is required\n")
 	}
 
 	return config
 }
 
-func createConfigFiles(config Config) error {
-	if err := os.MkdirAll("config", 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %v", err)
-	}
-	if err := os.MkdirAll("config/letsencrypt", 0755); err != nil {
-		return fmt.Errorf("failed to create letsencrypt directory: %v", err)
-	}
-	if err := os.MkdirAll("config/db", 0755); err != nil {
-		return fmt.Errorf("failed to create db directory: %v", err)
-	}
-	if err := os.MkdirAll("config/logs", 0755); err != nil {
-		return fmt.Errorf("failed to create logs directory: %v", err)
+// renderedFile is a config template rendered for a specific Config
+type renderedFile struct {
+	Path    string
+	Content []byte
+	// Mode is the permission of the written file, 0644 when unset
+	Mode os.FileMode `json:",omitempty"`
+}
+
+// renderConfigFiles renders the embedded templates without touching the disk.
+// It returns the directories to create and the files to write, in walk order.
+func renderConfigFiles(config Config) ([]string, []renderedFile, error) {
+	dirs := []string{"config", "config/letsencrypt", "config/db", "config/logs"}
+	if config.SeparateDataDir() && !config.IsPostgreSQL {
+		dirs = append(dirs, config.DataPath("db"))
 	}
+	var files []renderedFile
+	var overridden []string
 
 	// Walk through all embedded files
-	err := fs.WalkDir(configFiles, "config", func(path string, d fs.DirEntry, walkErr error) (err error) {
+	err := fs.WalkDir(configFiles, "config", func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
@@ -590,24 +1115,32 @@ func createConfigFiles(config Config) error {
 			return nil
 		}
 
+		if config.ExternalProxy && strings.HasPrefix(path, "config/traefik") {
+			return nil
+		}
+
+		if !config.Backups() && path == backupScript {
+			return nil
+		}
+
 		// skip .DS_Store
 		if strings.Contains(path, ".DS_Store") {
 			return nil
 		}
 
 		if d.IsDir() {
-			// Create directory
-			if err := os.MkdirAll(path, 0755); err != nil {
-				return fmt.Errorf("failed to create directory %s: %v", path, err)
-			}
+			dirs = append(dirs, path)
 			return nil
 		}
 
-		// Read the template file
-		content, err := configFiles.ReadFile(path)
+		// Read the template file, or its replacement from --templates-dir
+		content, overrideUsed, err := readTemplate(path)
 		if err != nil {
 			return fmt.Errorf("failed to read %s: %v", path, err)
 		}
+		if overrideUsed {
+			overridden = append(overridden, path)
+		}
 
 		// Parse template
 		tmpl, err := template.New(d.Name()).Parse(string(content))
@@ -615,31 +1148,73 @@ func createConfigFiles(config Config) error {
 			return fmt.Errorf("failed to parse template %s: %v", path, err)
 		}
 
-		// Ensure parent directory exists
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			return fmt.Errorf("failed to create parent directory for %s: %v", path, err)
-		}
-
-		// Create output file
-		outFile, err := os.Create(path)
-		if err != nil {
-			return fmt.Errorf("failed to create %s: %v", path, err)
-		}
-		defer func() {
-			if cerr := outFile.Close(); cerr != nil && err == nil {
-				err = cerr
-			}
-		}()
-
 		// Execute template
-		if err := tmpl.Execute(outFile, config); err != nil {
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, config); err != nil {
 			return fmt.Errorf("failed to execute template %s: %v", path, err)
 		}
+		files = append(files, renderedFile{Path: path, Content: out.Bytes()})
+		debugf("rendered %s (%d bytes)", path, out.Len())
 
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("error walking config files: %v", err)
+		return nil, nil, fmt.Errorf("error walking config files: %v", err)
+	}
+	printTemplateOverrides(overridden)
+	if !config.DoCrowdsecInstall {
+		files = append(files, renderEnvFiles(config)...)
+		files = append(files, renderOwnCertificates(config)...)
+		if config.ExternalProxy {
+			files = append(files, renderProxyExamples(config)...)
+		}
+	}
+	if err := validateRenderedFiles(files, overridden); err != nil {
+		return nil, nil, err
+	}
+
+	return dirs, files, nil
+}
+
+func createConfigFiles(config Config) error {
+	dirs, files, err := renderConfigFiles(config)
+	if err != nil {
+		return err
+	}
+	return writeRenderedFiles(dirs, files)
+}
+
+func writeRenderedFiles(dirs []string, files []renderedFile) error {
+	for _, dir := range dirs {
+		created := !pathExists(dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %v", dir, err)
+		}
+		if created {
+			cleanups.push("remove directory "+dir, removeCreated(dir))
+		}
+	}
+
+	for _, file := range files {
+		// Ensure parent directory exists
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+			return fmt.Errorf("failed to create parent directory for %s: %v", file.Path, err)
+		}
+		mode := file.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		created := !pathExists(file.Path)
+		if err := os.WriteFile(file.Path, file.Content, mode); err != nil {
+			return fmt.Errorf("failed to create %s: %v", file.Path, err)
+		}
+		if err := os.Chmod(file.Path, mode); err != nil {
+			return fmt.Errorf("failed to set the permissions of %s: %v", file.Path, err)
+		}
+		if created {
+			cleanups.push("remove "+file.Path, removeCreated(file.Path))
+		}
+		report.fileWritten(file.Path)
 	}
 
 	return nil
@@ -678,13 +1253,31 @@ func moveFile(src, dst string) error {
 	return os.Remove(src)
 }
 
-func printSetupToken(containerType SupportedContainer, dashboardDomain string) {
-	fmt.Println("Waiting for Pangolin to generate setup token...")
+func printSetupToken(containerType SupportedContainer, dashboardURL string) {
+	token := findSetupToken(containerType)
+	if token == "" {
+		return
+	}
+	// Always shown (even with --quiet) but kept out of the install log
+	fmt.Fprintln(consoleOut, tr("summary.setup_token", token))
+	logf("INFO", "Setup token: [redacted]")
+	infoln("")
+	infoln(tr("summary.setup_token_usage"))
+	infof("%s/auth/initial-setup\n", dashboardURL)
+	infoln("")
+	infoln(tr("summary.setup_token_save"))
+}
 
+// findSetupToken waits for Pangolin and reads the setup token from its logs.
+// It warns and returns "" when there is none.
+func findSetupToken(containerType SupportedContainer) string {
 	// Wait for Pangolin to be healthy
-	if err := waitForContainer("pangolin", containerType); err != nil {
-		fmt.Println("Warning: Pangolin container did not become healthy in time.")
-		return
+	err := runStep(context.Background(), "Waiting for Pangolin to generate setup token", func(ctx context.Context) error {
+		return waitForContainerHealthy(ctx, "pangolin", containerType, scaledTimeout(150*time.Second))
+	})
+	if err != nil {
+		warnf("Warning: Pangolin container did not become healthy in time.\n")
+		return ""
 	}
 
 	// Give a moment for the setup token to be generated
@@ -697,10 +1290,10 @@ func printSetupToken(containerType SupportedContainer, dashboardDomain string) {
 	} else {
 		cmd = exec.Command("podman", "logs", "pangolin")
 	}
-	output, err := cmd.Output()
+	output, err := outputCmd(cmd)
 	if err != nil {
-		fmt.Println("Warning: Could not fetch Pangolin logs to find setup token.")
-		return
+		warnf("Warning: Could not fetch Pangolin logs to find setup token.\n")
+		return ""
 	}
 
 	// Parse for setup token
@@ -714,57 +1307,51 @@ func printSetupToken(containerType SupportedContainer, dashboardDomain string) {
 					// Extract token after "Token:"
 					tokenStart := strings.Index(trimmedLine, "Token:")
 					if tokenStart != -1 {
-						token := strings.TrimSpace(trimmedLine[tokenStart+6:])
-						fmt.Printf("Setup token: %s\n", token)
-						fmt.Println("")
-						fmt.Println("This token is required to register the first admin account in the web UI at:")
-						fmt.Printf("https://%s/auth/initial-setup\n", dashboardDomain)
-						fmt.Println("")
-						fmt.Println("Save this token securely. It will be invalid after the first admin is created.")
-						return
+						return strings.TrimSpace(trimmedLine[tokenStart+6:])
 					}
 				}
 			}
 		}
 	}
-	fmt.Println("Warning: Could not find a setup token in Pangolin logs.")
+	warnf("Warning: Could not find a setup token in Pangolin logs.\n")
+	return ""
 }
 
-func showSetupTokenInstructions(containerType SupportedContainer, dashboardDomain string) {
-	fmt.Println("\n=== Setup Token Instructions ===")
-	fmt.Println("To get your setup token, you need to:")
-	fmt.Println("")
-	fmt.Println("1. Start the containers")
+func showSetupTokenInstructions(containerType SupportedContainer, dashboardURL string) {
+	infoln("\n=== " + tr("summary.instructions_title") + " ===")
+	infoln(tr("summary.instructions_intro"))
+	infoln("")
+	infoln("1. " + tr("summary.instructions_start"))
 	switch containerType {
 	case Docker:
-		fmt.Println("   docker compose up -d")
+		infoln("   docker compose up -d")
 	case Podman:
-		fmt.Println("   podman-compose up -d")
+		infoln("   podman-compose up -d")
 	}
 
-	fmt.Println("")
-	fmt.Println("2. Wait for the Pangolin container to start and generate the token")
-	fmt.Println("")
-	fmt.Println("3. Check the container logs for the setup token")
+	infoln("")
+	infoln("2. " + tr("summary.instructions_wait"))
+	infoln("")
+	infoln("3. " + tr("summary.instructions_logs"))
 	switch containerType {
 	case Docker:
-		fmt.Println("   docker logs pangolin | grep -A 2 -B 2 'SETUP TOKEN'")
+		infoln("   docker logs pangolin | grep -A 2 -B 2 'SETUP TOKEN'")
 	case Podman:
-		fmt.Println("   podman logs pangolin | grep -A 2 -B 2 'SETUP TOKEN'")
-	}
-
-	fmt.Println("")
-	fmt.Println("4. Look for output like")
-	fmt.Println("   === SETUP TOKEN GENERATED ===")
-	fmt.Println("   Token: [your-token-here]")
-	fmt.Println("   Use this token on the initial setup page")
-	fmt.Println("")
-	fmt.Println("5. Use the token to complete initial setup at")
-	fmt.Printf("   https://%s/auth/initial-setup\n", dashboardDomain)
-	fmt.Println("")
-	fmt.Println("The setup token is required to register the first admin account.")
-	fmt.Println("Save it securely - it will be invalid after the first admin is created.")
-	fmt.Println("================================")
+		infoln("   podman logs pangolin | grep -A 2 -B 2 'SETUP TOKEN'")
+	}
+
+	infoln("")
+	infoln("4. " + tr("summary.instructions_look"))
+	infoln("   === SETUP TOKEN GENERATED ===")
+	infoln("   Token: [your-token-here]")
+	infoln("   Use this token on the initial setup page")
+	infoln("")
+	infoln("5. " + tr("summary.instructions_use"))
+	infof("   %s/auth/initial-setup\n", dashboardURL)
+	infoln("")
+	infoln(tr("summary.instructions_required"))
+	infoln(tr("summary.instructions_save"))
+	infoln("================================")
 }
 
 func generateRandomSecretKey() string {
@@ -778,29 +1365,14 @@ func generateRandomSecretKey() string {
 
 // Run external commands with stdio/stderr attached.
 func run(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-func checkPortsAvailable(port int) error {
-	addr := fmt.Sprintf(":%d", port)
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("ERROR: port %d is occupied or cannot be bound: %w", port, err)
-	}
-	if closeErr := ln.Close(); closeErr != nil {
-		fmt.Fprintf(os.Stderr,
-			"WARNING: failed to close test listener on port %d: %v\n",
-			port, closeErr,
-		)
-	}
-	return nil
+	return execLogged(exec.Command(name, args...), true)
 }
 
 func downloadMaxMindDatabase() error {
-	fmt.Println("Downloading MaxMind GeoLite2 Country and ASN databases...")
+	if !network.allow("MaxMind database download") {
+		return fmt.Errorf("offline, copy GeoLite2-Country.mmdb and GeoLite2-ASN.mmdb into config/ by hand")
+	}
+	infoln("Downloading MaxMind GeoLite2 Country and ASN databases...")
 
 	// Download the GeoLite2 Country databases
 	if err := run("curl", "-L", "-o", "GeoLite2-Country.tar.gz",
@@ -830,12 +1402,12 @@ func downloadMaxMindDatabase() error {
 
 	// Clean up the downloaded files
 	if err := run("sh", "-c", "rm -rf GeoLite2-Country.tar.gz GeoLite2-Country_*"); err != nil {
-		fmt.Printf("Warning: failed to clean up temporary country files: %v\n", err)
+		warnf("Warning: failed to clean up temporary country files: %v\n", err)
 	}
 	if err := run("sh", "-c", "rm -rf GeoLite2-ASN.tar.gz GeoLite2-ASN_*"); err != nil {
-		fmt.Printf("Warning: failed to clean up temporary ASN files: %v\n", err)
+		warnf("Warning: failed to clean up temporary ASN files: %v\n", err)
 	}
 
-	fmt.Println("MaxMind GeoLite2 Country and ASN database downloaded successfully!")
+	infoln("MaxMind GeoLite2 Country and ASN database downloaded successfully!")
 	return nil
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// fileManifest maps a path to the hex sha256 of its content. An empty hash
+// records that the file did not exist.
+type fileManifest map[string]string
+
+// maxHashWorkers bounds the files hashed in parallel
+var maxHashWorkers = min(runtime.NumCPU(), 8)
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashFile returns the hash of the file at path, or "" if it does not exist.
+// The content is streamed so large files are never held in memory.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildManifest hashes the current on-disk state of paths with a bounded
+// worker pool
+func buildManifest(paths []string) (fileManifest, error) {
+	manifest := make(fileManifest, len(paths))
+	var mu sync.Mutex
+	var firstErr error
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range maxHashWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				hash, err := hashFile(path)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				manifest[path] = hash
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return manifest, nil
+}
+
+// drift returns the sorted paths whose on-disk state no longer matches the
+// manifest. Every file is hashed again, a size and modification time can be
+// kept by a rewrite and prove nothing.
+func (m fileManifest) drift() ([]string, error) {
+	paths := make([]string, 0, len(m))
+	for path := range m {
+		paths = append(paths, path)
+	}
+	actual, err := buildManifest(paths)
+	if err != nil {
+		return nil, err
+	}
+	return m.changed(actual), nil
+}
+
+// changed returns the sorted paths whose hash differs between m and other,
+// including the paths only one of them has
+func (m fileManifest) changed(other fileManifest) []string {
+	var changed []string
+	for path, expected := range m {
+		if actual, ok := other[path]; !ok || actual != expected {
+			changed = append(changed, path)
+		}
+	}
+	for path := range other {
+		if _, ok := m[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
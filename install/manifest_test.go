@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// syntheticRules writes n Traefik rule files of a few KiB across nested
+// directories below config/traefik/rules of a new install directory, like a
+// large hand-written dynamic config directory, and returns the directory
+func syntheticRules(tb testing.TB, n int) string {
+	tb.Helper()
+	root := tb.TempDir()
+	for i := range n {
+		dir := filepath.Join(root, "config", "traefik", "rules", fmt.Sprintf("%02d", i%50))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		rule := fmt.Sprintf("http:\n  routers:\n    app-%d:\n      rule: Host(`app-%d.example.com`)\n      service: app-%d\n", i, i, i)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("app-%d.yml", i)), bytes.Repeat([]byte(rule), 32), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return root
+}
+
+// rulePath is the path of rule file i written by syntheticRules
+func rulePath(i int) string {
+	return fmt.Sprintf("config/traefik/rules/%02d/app-%d.yml", i%50, i)
+}
+
+// TestManifestDrift checks that the drift check finds a changed, a removed
+// and a created file, also a rewrite that keeps the size and modification
+// time
+func TestManifestDrift(t *testing.T) {
+	root := syntheticRules(t, 20)
+	var paths []string
+	for i := range 20 {
+		paths = append(paths, filepath.Join(root, rulePath(i)))
+	}
+	missing := filepath.Join(filepath.Dir(paths[0]), "new.yml")
+	manifest, err := buildManifest(append(slices.Clone(paths), missing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest[missing] != "" {
+		t.Fatalf("a missing file hashed to %q", manifest[missing])
+	}
+	if drifted, err := manifest.drift(); err != nil || len(drifted) > 0 {
+		t.Fatalf("drift of an unchanged tree: %q, %v", drifted, err)
+	}
+
+	info, err := os.Stat(paths[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(paths[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths[3], bytes.ToUpper(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(paths[3], info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(paths[7]); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(missing, []byte("http: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	drifted, err := manifest.drift()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{paths[3], paths[7], missing}
+	slices.Sort(want)
+	if !slices.Equal(drifted, want) {
+		t.Errorf("drifted %q, want %q", drifted, want)
+	}
+}
+
+// TestPlanManifest checks that a plan covers the rule files below the Traefik
+// config directory, without its logs, and that apply sees a rule file that
+// was changed, removed or added since
+func TestPlanManifest(t *testing.T) {
+	root := syntheticRules(t, 10)
+	logs := filepath.Join(root, "config", "traefik", "logs")
+	if err := os.MkdirAll(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(logs, "access.log"), []byte("GET /\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := []renderedFile{{Path: "docker-compose.yml"}, {Path: "config/traefik/dynamic_config.yml"}}
+	manifest, err := planManifest(root, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 12 || manifest[rulePath(4)] == "" || manifest["docker-compose.yml"] != "" {
+		t.Fatalf("manifest %v", manifest)
+	}
+	if _, ok := manifest["config/traefik/logs/access.log"]; ok {
+		t.Error("the Traefik logs are in the manifest")
+	}
+
+	if err := os.WriteFile(filepath.Join(logs, "access.log"), []byte("GET /api\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, rulePath(2)), []byte("http: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, rulePath(5))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "config", "traefik", "rules", "extra.yml"), []byte("http: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	current, err := planManifest(root, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{rulePath(2), rulePath(5), "config/traefik/rules/extra.yml"}
+	slices.Sort(want)
+	if drifted := manifest.changed(current); !slices.Equal(drifted, want) {
+		t.Errorf("drifted %q, want %q", drifted, want)
+	}
+}
+
+// BenchmarkPlanManifest walks and hashes an install with several thousand
+// rule files, like plan does
+func BenchmarkPlanManifest(b *testing.B) {
+	root := syntheticRules(b, 5000)
+	for b.Loop() {
+		if _, err := planManifest(root, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkApplyDrift checks the same install for drift like apply does,
+// every file is read again
+func BenchmarkApplyDrift(b *testing.B) {
+	root := syntheticRules(b, 5000)
+	manifest, err := planManifest(root, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for b.Loop() {
+		current, err := planManifest(root, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if drifted := manifest.changed(current); len(drifted) > 0 {
+			b.Fatalf("drifted %q", drifted)
+		}
+	}
+}
+
+// BenchmarkRenderLargeFileDiff renders the change of a file above
+// maxDiffBytes, which must not build the line by line diff
+func BenchmarkRenderLargeFileDiff(b *testing.B) {
+	old := bytes.Repeat([]byte("      rule: Host(`app.example.com`)\n"), 2*maxDiffBytes/36)
+	new := append(slices.Clone(old), "      service: app\n"...)
+	for b.Loop() {
+		if renderFileDiff("config/traefik/rules/large.yml", old, new, nil) == "" {
+			b.Fatal("no summary of a changed file")
+		}
+	}
+}
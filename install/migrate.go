@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configChange is a single key change made by a config migration
+type configChange struct {
+	Migration string `json:"migration"`
+	File      string `json:"file"`
+	// Kind is added, removed or renamed
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	NewKey string `json:"newKey,omitempty"`
+}
+
+func (c configChange) String() string {
+	if c.Kind == "renamed" {
+		return fmt.Sprintf("%s: %s -> %s", c.File, c.Key, c.NewKey)
+	}
+	return fmt.Sprintf("%s: %s %s", c.File, c.Kind, c.Key)
+}
+
+// configMigration brings a config file written by an older installer up to
+// date. Apply mutates the parsed document and returns what it changed, an
+// empty result means the migration does not apply.
+type configMigration struct {
+	Name  string
+	File  string
+	Apply func(root *yaml.Node) []configChange
+	// Restart is a container that only picks up the change after a restart
+	Restart string
+	// Note is shown in the upgrade report when the migration applies
+	Note string
+}
+
+var configMigrations = []configMigration{
+	{
+		// Early releases had a single base domain under app
+		Name: "domains-section",
+		File: "config/config.yml",
+		Apply: func(root *yaml.Node) []configChange {
+			app := yamlMapValue(root, "app")
+			if app == nil || yamlMapValue(app, "base_domain") == nil || yamlMapValue(root, "domains") != nil {
+				return nil
+			}
+			value := yamlDeleteKey(app, "base_domain")
+			domain := &yaml.Node{Kind: yaml.MappingNode}
+			yamlSetKey(domain, "base_domain", value)
+			domains := &yaml.Node{Kind: yaml.MappingNode}
+			yamlSetKey(domains, "domain1", domain)
+			yamlSetKey(root, "domains", domains)
+			return []configChange{{Kind: "renamed", Key: "app.base_domain", NewKey: "domains.domain1.base_domain"}}
+		},
+	},
+	{
+		// Unprefixed router names collided with routers Pangolin manages at runtime
+		Name:    "namespaced-routers",
+		File:    "config/traefik/dynamic_config.yml",
+		Apply:   namespaceTraefikNames,
+		Restart: "traefik",
+		Note:    "Traefik is restarted once so the renamed routers replace the old ones. Update any custom configuration that references the old names (e.g. badger@file).",
+	},
+	{
+		Name:    "namespaced-entrypoint-middlewares",
+		File:    "config/traefik/traefik_config.yml",
+		Apply:   namespaceEntrypointMiddlewares,
+		Restart: "traefik",
+	},
+	{
+		// Cleanup and discovery filter on labels instead of container names
+		Name:  "resource-labels",
+		File:  "docker-compose.yml",
+		Apply: labelComposeResources,
+		Note:  "Networks keep their old labels until they are recreated, e.g. by compose down followed by up -d.",
+	},
+}
+
+// applyMigrations writes the result of planMigrations to disk
+func applyMigrations() ([]configChange, error) {
+	return applyMigrationSet(configMigrations)
+}
+
+func applyMigrationSet(migrations []configMigration) ([]configChange, error) {
+	migrated, changes, err := planMigrationSet(migrations)
+	if err != nil {
+		return nil, err
+	}
+	for path, content := range migrated {
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// applyReviewedMigrations shows the diff of migrations, backs up the config
+// and writes the changed files. It reports whether anything was written,
+// subject names the change in messages.
+func applyReviewedMigrations(migrations []configMigration, subject string) bool {
+	migrated, changes, err := planMigrationSet(migrations)
+	if err != nil {
+		errorf("Error: could not update %s: %v\n", subject, err)
+		return false
+	}
+	unchanged := true
+	for path, content := range migrated {
+		if old, _ := os.ReadFile(path); !bytes.Equal(old, content) {
+			unchanged = false
+			infof("\n%s", renderFileDiff(path, old, content, nil))
+		}
+	}
+	if unchanged {
+		infof("Nothing to change, %s is already configured this way.\n", subject)
+		return false
+	}
+	printConfigChanges(changes, nil)
+	if err := backupConfig(); err != nil {
+		errorf("Error: backup failed, %s was not changed: %v\n", subject, err)
+		return false
+	}
+	for path, content := range migrated {
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			fatalf("Error writing %s: %v\n", path, err)
+		}
+		report.fileWritten(path)
+	}
+	return true
+}
+
+// migrationNotes returns the notes of the migrations that made changes
+func migrationNotes(changes []configChange) []string {
+	var notes []string
+	for _, migration := range configMigrations {
+		if migration.Note != "" && slices.ContainsFunc(changes, func(c configChange) bool { return c.Migration == migration.Name }) {
+			notes = append(notes, migration.Note)
+		}
+	}
+	return notes
+}
+
+// migrationRestarts returns the containers to restart for changes
+func migrationRestarts(changes []configChange) []string {
+	var containers []string
+	for _, migration := range configMigrations {
+		if migration.Restart == "" || slices.Contains(containers, migration.Restart) {
+			continue
+		}
+		if slices.ContainsFunc(changes, func(c configChange) bool { return c.Migration == migration.Name }) {
+			containers = append(containers, migration.Restart)
+		}
+	}
+	return containers
+}
+
+// planMigrations runs every migration against the files in the current
+// directory without writing anything. It returns the migrated content of each
+// changed file and the changes made.
+func planMigrations() (map[string][]byte, []configChange, error) {
+	return planMigrationSet(configMigrations)
+}
+
+func planMigrationSet(migrations []configMigration) (map[string][]byte, []configChange, error) {
+	docs := map[string]*yaml.Node{}
+	indents := map[string]int{}
+	var changes []configChange
+
+	for _, migration := range migrations {
+		doc, ok := docs[migration.File]
+		if !ok {
+			data, err := os.ReadFile(migration.File)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, nil, err
+			}
+			doc = &yaml.Node{}
+			if err := yaml.Unmarshal(data, doc); err != nil {
+				return nil, nil, fmt.Errorf("error parsing %s: %v", migration.File, err)
+			}
+			if len(doc.Content) == 0 {
+				continue
+			}
+			docs[migration.File] = doc
+			indents[migration.File] = detectIndent(data)
+		}
+
+		for _, change := range migration.Apply(doc.Content[0]) {
+			change.Migration = migration.Name
+			change.File = migration.File
+			changes = append(changes, change)
+		}
+	}
+
+	migrated := map[string][]byte{}
+	for _, change := range changes {
+		if _, done := migrated[change.File]; done {
+			continue
+		}
+		out, err := MarshalYAMLWithIndent(docs[change.File], indents[change.File])
+		if err != nil {
+			return nil, nil, err
+		}
+		migrated[change.File] = out
+	}
+	return migrated, changes, nil
+}
+
+// detectIndent returns the indentation width of the first nested key so that
+// migrated files keep their formatting
+func detectIndent(data []byte) int {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		trimmed := strings.TrimLeft(string(line), " ")
+		if width := len(line) - len(trimmed); width > 0 && trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return width
+		}
+	}
+	return 2
+}
+
+// yamlDeleteKey removes key from a mapping node and returns its value
+func yamlDeleteKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := node.Content[i+1]
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+// yamlSetKey sets key in a mapping node, appending it when it is missing
+func yamlSetKey(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
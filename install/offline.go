@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"net"
+)
+
+// networkAccess is the permission to reach the network. Every component that
+// talks to a remote service asks it first, so --offline skips all of them the
+// same way instead of letting each one time out.
+type networkAccess struct {
+	offline bool
+}
+
+var network networkAccess
+
+// addOfflineFlag registers --offline on fs
+func addOfflineFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&network.offline, "offline", false, "Never access the network: skip update checks, DNS checks, registry lookups, provider API calls and image pulls")
+}
+
+// allow reports whether feature may use the network. In offline mode it
+// records feature as "skipped (offline)" and returns false.
+func (n networkAccess) allow(feature string) bool {
+	// The checks of replayed answers ran when they were first given
+	if session.replaying() {
+		return false
+	}
+	if !n.offline {
+		return true
+	}
+	report.skip(feature + " (offline)")
+	return false
+}
+
+// dialContext opens the connections to remote hosts that do not go through
+// http.DefaultTransport, e.g. SMTP, PostgreSQL and STUN. Tests replace it to
+// catch network access.
+var dialContext = (&net.Dialer{}).DialContext
+
+// dialTLS connects with dialContext and completes the TLS handshake
+func dialTLS(ctx context.Context, network, address string, config *tls.Config) (*tls.Conn, error) {
+	conn, err := dialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
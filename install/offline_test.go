@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// panicTransport fails the test on any HTTP request
+type panicTransport struct{}
+
+func (panicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	panic("HTTP request to " + req.URL.Host + " under --offline")
+}
+
+// withVersions sets the image versions a release build injects with -ldflags
+func withVersions(t *testing.T) {
+	t.Helper()
+	saved := []string{pangolinVersion, gerbilVersion, badgerVersion}
+	t.Cleanup(func() { pangolinVersion, gerbilVersion, badgerVersion = saved[0], saved[1], saved[2] })
+	pangolinVersion, gerbilVersion, badgerVersion = "1.12.0", "1.2.1", "v1.2.0"
+}
+
+// TestOfflineDryRun runs a complete fresh dry run with --offline while every
+// way to reach the network panics: HTTP requests, DNS lookups and the dials of
+// SMTP, PostgreSQL and STUN. The route lookup sends no packets and is stubbed.
+// Container runtimes are fake commands, only read-only ones may run.
+func TestOfflineDryRun(t *testing.T) {
+	log := fakeCommands(t)
+	withAnswers(t, nil)
+	withDryRun(t)
+	withVersions(t)
+
+	savedTransport, savedResolver, savedDial, savedArgs := http.DefaultTransport, net.DefaultResolver, dialContext, os.Args
+	savedOffline, savedConsole, savedRoute := network.offline, consoleOut, routeSource
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		http.DefaultTransport, net.DefaultResolver, dialContext, os.Args = savedTransport, savedResolver, savedDial, savedArgs
+		network.offline, consoleOut, routeSource = savedOffline, savedConsole, savedRoute
+		os.Chdir(cwd)
+	})
+	http.DefaultTransport = panicTransport{}
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(_ context.Context, _, address string) (net.Conn, error) {
+			panic("DNS lookup through " + address + " under --offline")
+		},
+	}
+	routeSource = func(string, string) net.IP { return nil }
+	dialContext = func(_ context.Context, network, address string) (net.Conn, error) {
+		panic("dial of " + network + " " + address + " under --offline")
+	}
+
+	installDir := filepath.Join(t.TempDir(), "pangolin")
+	os.Args = []string{"installer", "--dry-run", "--offline", "--yes",
+		"--domain", "example.com", "--email", "admin@example.com", "--install-dir", installDir,
+		"--enable-email", "--smtp-host", "smtp.example.com", "--smtp-user", "user", "--smtp-pass", "secret", "--no-reply-email", "noreply@example.com",
+		"--acme-challenge", "dns-01", "--dns-provider", "cloudflare", "--cf-dns-api-token", "token", "--dns-provider-check",
+		"--postgresql", "--postgresql-external", "--postgresql-host", "db.example.com", "--postgresql-password", "secret",
+		"--oidc", "--external-check", "--enable-maxmind", "--geoblock", "--geoblock-countries", "DE,AT"}
+	if code := catchExit(t, main); code != exitChangesPending {
+		t.Fatalf("exit code %d, want %d", code, exitChangesPending)
+	}
+
+	for _, line := range ranCommands(t, log) {
+		if !sideEffectFree(strings.Fields(line)) {
+			t.Errorf("ran %q under --dry-run", line)
+		}
+	}
+	if pathExists(installDir) {
+		t.Errorf("the dry run created %s", installDir)
+	}
+}
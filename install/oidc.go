@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const oidcDiscoveryTimeout = 10 * time.Second
+
+// OIDCProvider is an identity provider written to config.yml so single sign-on
+// is available at first boot
+type OIDCProvider struct {
+	Name             string
+	Issuer           string
+	ClientID         string
+	ClientSecret     string
+	Scopes           string
+	AuthorizationURL string
+	TokenURL         string
+}
+
+// oidcDiscovery is the part of the discovery document the installer uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// collectOIDCProvider asks for an OIDC provider and validates its issuer
+// against the discovery document
+func collectOIDCProvider(config *Config) {
+	if !readBool("oidc", tr("prompt.oidc"), false) {
+		return
+	}
+	if !network.allow("OIDC provider") {
+		warnf("Warning: the issuer cannot be validated while offline, add the identity provider in the dashboard later.\n")
+		return
+	}
+
+	provider := OIDCProvider{Scopes: "openid profile email"}
+	for {
+		provider.Name = readString("oidc_name", tr("prompt.oidc_name"), provider.Name)
+		provider.Issuer = strings.TrimSuffix(readString("oidc_issuer", tr("prompt.oidc_issuer"), provider.Issuer), "/")
+		provider.ClientID = readString("oidc_client_id", tr("prompt.oidc_client_id"), provider.ClientID)
+		provider.ClientSecret = readPassword("oidc_client_secret", tr("prompt.oidc_client_secret"))
+		provider.Scopes = readString("oidc_scopes", tr("prompt.oidc_scopes"), provider.Scopes)
+
+		for {
+			var discovery *oidcDiscovery
+			err := runStep(context.Background(), "Fetching the OIDC discovery document", func(ctx context.Context) error {
+				var err error
+				discovery, err = fetchOIDCDiscovery(ctx, provider.Issuer)
+				return err
+			})
+			if err == nil {
+				provider.AuthorizationURL = discovery.AuthorizationEndpoint
+				provider.TokenURL = discovery.TokenEndpoint
+				config.OIDC = &provider
+				infof("%s validated, users can sign in with %s at first boot.\n", provider.Issuer, provider.Name)
+				infoln("Copy the redirect URI from the identity provider settings in the dashboard into the provider's client configuration.")
+				return
+			}
+
+			warnf("Warning: %v\n", err)
+			switch readChoice("oidc_invalid", tr("prompt.oidc_invalid"), []string{"edit", "retry", "skip"}, "edit") {
+			case "retry":
+				continue
+			case "skip":
+				infoln("Skipping the identity provider, it can be added in the dashboard later.")
+				return
+			}
+			break
+		}
+	}
+}
+
+// fetchOIDCDiscovery validates the issuer URL and returns its discovery document
+func fetchOIDCDiscovery(ctx context.Context, issuer string) (*oidcDiscovery, error) {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%q is not a valid issuer URL", issuer)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("the issuer must use https, got %s", issuer)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oidcDiscoveryTimeout)
+	defer cancel()
+	discoveryURL := issuer + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch %s: %v", discoveryURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", discoveryURL, resp.Status)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("%s is not a discovery document: %v", discoveryURL, err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("the discovery document names issuer %q, expected %q", discovery.Issuer, issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" {
+		return nil, fmt.Errorf("the discovery document has no authorization or token endpoint")
+	}
+	return &discovery, nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The certificate sources offered in the TLS section
+const (
+	certificateLetsEncrypt = "letsencrypt"
+	certificateOwn         = "own"
+)
+
+// ownCertDir holds the copies of the certificates Traefik serves, below the
+// config/traefik mount so the file provider sees them as /etc/traefik/certs
+const ownCertDir = "config/traefik/certs"
+
+// ownCertExpiryWarning is how long before expiry a certificate is reported
+const ownCertExpiryWarning = 30 * 24 * time.Hour
+
+// ownCertificate is a certificate and key the user provides instead of Let's
+// Encrypt. The files are read when they are entered and copied to ownCertDir
+// as Name.crt and Name.key.
+type ownCertificate struct {
+	Name     string
+	CertPath string
+	KeyPath  string
+	CertPEM  []byte
+	KeyPEM   []byte
+	Leaf     *x509.Certificate
+	// Intermediates are the other certificates of the chain file
+	Intermediates *x509.CertPool
+}
+
+// OwnCertificate reports whether Traefik serves the user's certificates and
+// ACME is disabled
+func (c Config) OwnCertificate() bool {
+	return c.CertificateSource == certificateOwn
+}
+
+// collectCertificateSource asks whether Let's Encrypt issues the certificates
+// or Traefik serves existing ones, and for those
+func collectCertificateSource(config *Config) {
+	sources := []Option{
+		{Value: certificateLetsEncrypt, Label: "Let's Encrypt", Description: "certificates are issued and renewed automatically"},
+		{Value: certificateOwn, Label: "Own certificate files", Description: "existing fullchain and key PEM files, renewed by you"},
+	}
+	config.CertificateSource = readSelect("certificate_source", tr("prompt.certificate_source"), sources, orDefault(config.CertificateSource, certificateLetsEncrypt))
+	config.OwnCertificates = nil
+	if config.OwnCertificate() {
+		collectOwnCertificates(config)
+	}
+}
+
+// collectOwnCertificates asks for a certificate file and its key, or for a
+// directory of certificates, and warns about those browsers will reject
+func collectOwnCertificates(config *Config) {
+	path := readValidated("certificate_path", tr("prompt.certificate_path"), "", validateCertificatePath)
+	if answerMissing("certificate_path") {
+		return
+	}
+	var certificates []ownCertificate
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		certificates, _ = loadCertificateDir(path)
+	} else {
+		keyPath := readValidated("certificate_key", tr("prompt.certificate_key"), defaultKeyPath(path), func(s string) error {
+			if !filepath.IsAbs(s) {
+				return errors.New("enter an absolute path")
+			}
+			_, err := loadOwnCertificate(path, s)
+			return err
+		})
+		if answerMissing("certificate_key") {
+			return
+		}
+		certificate, _ := loadOwnCertificate(path, keyPath)
+		certificates = []ownCertificate{certificate}
+	}
+	config.OwnCertificates = nameCertificates(certificates)
+	for _, warning := range ownCertificateWarnings(*config, time.Now()) {
+		warnf("Warning: %s\n", warning)
+	}
+}
+
+// validateCertificatePath accepts a directory holding valid certificates and
+// their keys, or a file with a certificate chain
+func validateCertificatePath(s string) error {
+	if !filepath.IsAbs(s) {
+		return errors.New("enter an absolute path")
+	}
+	info, err := os.Stat(s)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		_, err := loadCertificateDir(s)
+		return err
+	}
+	data, err := os.ReadFile(s)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(data); block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("%s is not a PEM certificate", s)
+	}
+	return nil
+}
+
+// defaultKeyPath guesses the key next to the certificate at certPath:
+// privkey.pem next to a certbot fullchain.pem, else name.key for name.crt
+func defaultKeyPath(certPath string) string {
+	dir, base := filepath.Split(certPath)
+	candidates := []string{strings.TrimSuffix(base, filepath.Ext(base)) + ".key"}
+	if base == "fullchain.pem" || base == "cert.pem" {
+		candidates = append([]string{"privkey.pem"}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if path := filepath.Join(dir, candidate); pathExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadOwnCertificate reads a certificate chain and its key and checks that
+// they belong together and that the certificate is valid now
+func loadOwnCertificate(certPath, keyPath string) (ownCertificate, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return ownCertificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return ownCertificate{}, err
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return ownCertificate{}, fmt.Errorf("%s and %s: %v", certPath, keyPath, err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return ownCertificate{}, fmt.Errorf("%s: %v", certPath, err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range pair.Certificate[1:] {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(cert)
+		}
+	}
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		return ownCertificate{}, fmt.Errorf("the certificate in %s expired on %s", certPath, leaf.NotAfter.Format(time.DateOnly))
+	case now.Before(leaf.NotBefore):
+		return ownCertificate{}, fmt.Errorf("the certificate in %s is not valid before %s", certPath, leaf.NotBefore.Format(time.DateOnly))
+	}
+	return ownCertificate{
+		CertPath:      certPath,
+		KeyPath:       keyPath,
+		CertPEM:       certPEM,
+		KeyPEM:        keyPEM,
+		Leaf:          leaf,
+		Intermediates: intermediates,
+	}, nil
+}
+
+// loadCertificateDir loads the certificates of dir and of its immediate
+// subdirectories: fullchain.pem with privkey.pem as certbot writes them to
+// live/<domain>, or name.crt, name.pem or name.cer with name.key
+func loadCertificateDir(dir string) ([]ownCertificate, error) {
+	dirs := []string{dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if info, err := os.Stat(filepath.Join(dir, entry.Name())); err == nil && info.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	var certificates []ownCertificate
+	for _, dir := range dirs {
+		if fullchain := filepath.Join(dir, "fullchain.pem"); pathExists(fullchain) && pathExists(filepath.Join(dir, "privkey.pem")) {
+			certificate, err := loadOwnCertificate(fullchain, filepath.Join(dir, "privkey.pem"))
+			if err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, certificate)
+			continue
+		}
+		files, _ := os.ReadDir(dir)
+		for _, file := range files {
+			ext := filepath.Ext(file.Name())
+			if file.IsDir() || (ext != ".crt" && ext != ".pem" && ext != ".cer") {
+				continue
+			}
+			keyPath := filepath.Join(dir, strings.TrimSuffix(file.Name(), ext)+".key")
+			if !pathExists(keyPath) {
+				continue
+			}
+			certificate, err := loadOwnCertificate(filepath.Join(dir, file.Name()), keyPath)
+			if err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("%s has no certificate with its key, expected fullchain.pem and privkey.pem or name.crt and name.key", dir)
+	}
+	return certificates, nil
+}
+
+// nameCertificates names the copies after the first name each certificate
+// covers, *.example.com becomes wildcard.example.com
+func nameCertificates(certificates []ownCertificate) []ownCertificate {
+	used := map[string]int{}
+	for i := range certificates {
+		leaf := certificates[i].Leaf
+		name := leaf.Subject.CommonName
+		if len(leaf.DNSNames) > 0 {
+			name = leaf.DNSNames[0]
+		}
+		name = strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+				return r
+			case r >= 'A' && r <= 'Z':
+				return r + 'a' - 'A'
+			}
+			return -1
+		}, strings.Replace(name, "*", "wildcard", 1))
+		name = orDefault(strings.Trim(name, ".-"), "certificate")
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%s-%d", name, used[name])
+		}
+		certificates[i].Name = name
+	}
+	return certificates
+}
+
+// ownCertificateWarnings are what browsers and Newt sites will object to:
+// chains that do not lead to a trusted root, certificates expiring soon, and a
+// dashboard domain none of them covers
+func ownCertificateWarnings(config Config, now time.Time) []string {
+	var warnings []string
+	covered := false
+	for _, certificate := range config.OwnCertificates {
+		leaf := certificate.Leaf
+		if _, err := leaf.Verify(x509.VerifyOptions{Intermediates: certificate.Intermediates, CurrentTime: now}); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s does not chain to a trusted root (%v). Browsers and Newt sites reject it unless they trust its CA.", certificate.CertPath, err))
+		}
+		if leaf.NotAfter.Sub(now) < ownCertExpiryWarning {
+			warnings = append(warnings, fmt.Sprintf("%s expires on %s, renew it soon.", certificate.CertPath, leaf.NotAfter.Format(time.DateOnly)))
+		}
+		if leaf.VerifyHostname(config.DashboardDomain) == nil {
+			covered = true
+		}
+	}
+	if !covered && config.DashboardDomain != "" && len(config.OwnCertificates) > 0 {
+		warnings = append(warnings, fmt.Sprintf("None of the certificates covers %s, Traefik serves the first one for it.", config.DashboardDomain))
+	}
+	return warnings
+}
+
+// renderOwnCertificates are the copies of the certificates in ownCertDir
+func renderOwnCertificates(config Config) []renderedFile {
+	var files []renderedFile
+	for _, certificate := range config.OwnCertificates {
+		files = append(files,
+			renderedFile{Path: filepath.Join(ownCertDir, certificate.Name+".crt"), Content: certificate.CertPEM},
+			renderedFile{Path: filepath.Join(ownCertDir, certificate.Name+".key"), Content: certificate.KeyPEM, Mode: 0600})
+	}
+	return files
+}
+
+// ownCertificateSecrets are the lines of the keys, for redaction
+func ownCertificateSecrets(certificates []ownCertificate) []string {
+	var secrets []string
+	for _, certificate := range certificates {
+		for _, line := range strings.Split(string(certificate.KeyPEM), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "-----") {
+				secrets = append(secrets, line)
+			}
+		}
+	}
+	return secrets
+}
+
+// installedOwnCertificates loads the copies of an install serving its own
+// certificates, nil for one using Let's Encrypt
+func installedOwnCertificates() []ownCertificate {
+	if !pathExists(ownCertDir) {
+		return nil
+	}
+	certificates, err := loadCertificateDir(ownCertDir)
+	if err != nil {
+		logf("WARN", "could not load the certificates in %s: %v", ownCertDir, err)
+		return nil
+	}
+	for i, certificate := range certificates {
+		certificates[i].Name = strings.TrimSuffix(filepath.Base(certificate.CertPath), filepath.Ext(certificate.CertPath))
+	}
+	return certificates
+}
+
+// ownCertificateWatcher is the systemd path unit that copies renewed
+// certificates to ownCertDir
+const ownCertificateWatcher = "pangolin-certificates"
+
+// installCertificateWatcher keeps the copies of the certificates current: a
+// systemd path unit watches the directories of the originals and copies them
+// again when they change. Traefik's file provider reloads the certificates
+// when dynamic_config.yml is touched.
+func installCertificateWatcher(config Config, installDir string) {
+	if !config.OwnCertificate() || len(config.OwnCertificates) == 0 {
+		return
+	}
+	infoln("\n=== Certificate Renewal ===")
+	installDir, err := filepath.Abs(installDir)
+	if err != nil {
+		warnf("Warning: could not set up the certificate watcher: %v\n", err)
+		return
+	}
+	var commands, watched []string
+	for _, certificate := range config.OwnCertificates {
+		certificateDest := filepath.Join(installDir, ownCertDir, certificate.Name+".crt")
+		if certificate.CertPath == certificateDest {
+			continue
+		}
+		commands = append(commands,
+			fmt.Sprintf("install -m 0644 %s %s", certificate.CertPath, certificateDest),
+			fmt.Sprintf("install -m 0600 %s %s", certificate.KeyPath, filepath.Join(installDir, ownCertDir, certificate.Name+".key")))
+		for _, path := range []string{certificate.CertPath, certificate.KeyPath} {
+			if dir := filepath.Dir(path); !slices.Contains(watched, dir) {
+				watched = append(watched, dir)
+			}
+		}
+	}
+	if len(commands) == 0 {
+		return
+	}
+	commands = append(commands, "touch "+filepath.Join(installDir, "config/traefik/dynamic_config.yml"))
+
+	if !platform.SystemdUnit || !isSystemdHost() || !isRoot() {
+		infoln("Traefik serves copies of the certificates. After renewing them, copy them again with:")
+		for _, command := range commands {
+			infof("	%s\n", command)
+		}
+		report.skip("certificate watcher (needs root and systemd)")
+		return
+	}
+	if err := writeCertificateWatcher(installDir, commands, watched); err != nil {
+		warnf("Warning: could not set up the certificate watcher: %v\n", err)
+		return
+	}
+	infof("Installed and enabled %s.path, renewed certificates in %s are copied to %s.\n", ownCertificateWatcher, strings.Join(watched, ", "), ownCertDir)
+}
+
+// writeCertificateWatcher writes the service running commands and the path
+// unit starting it when one of watched changes, and enables the path unit
+func writeCertificateWatcher(installDir string, commands, watched []string) error {
+	var execLines strings.Builder
+	for _, command := range commands {
+		name, args, _ := strings.Cut(command, " ")
+		if path, err := exec.LookPath(name); err == nil {
+			name = path
+		}
+		fmt.Fprintf(&execLines, "ExecStart=%s %s\n", name, args)
+	}
+	service := fmt.Sprintf(`# Generated by the Pangolin installer
+[Unit]
+Description=Copy renewed certificates to the Pangolin install in %s
+
+[Service]
+Type=oneshot
+%s`, installDir, execLines.String())
+	var pathLines strings.Builder
+	for _, dir := range watched {
+		fmt.Fprintf(&pathLines, "PathChanged=%s\n", dir)
+	}
+	path := fmt.Sprintf(`# Generated by the Pangolin installer
+[Unit]
+Description=Watch the certificates of the Pangolin install in %s
+
+[Path]
+%sUnit=%s.service
+
+[Install]
+WantedBy=multi-user.target
+`, installDir, pathLines.String(), ownCertificateWatcher)
+
+	servicePath := filepath.Join(systemdUnitDir, ownCertificateWatcher+".service")
+	pathPath := filepath.Join(systemdUnitDir, ownCertificateWatcher+".path")
+	if err := os.WriteFile(servicePath, []byte(service), 0644); err != nil {
+		return fmt.Errorf("could not write %s: %v", servicePath, err)
+	}
+	if err := os.WriteFile(pathPath, []byte(path), 0644); err != nil {
+		return fmt.Errorf("could not write %s: %v", pathPath, err)
+	}
+	// The path unit is stopped before either unit file is removed
+	recordExternal(externalResource{
+		Kind:        resourceFile,
+		Path:        pathPath,
+		Description: "systemd path unit watching the certificates",
+		PreRemove:   "systemctl disable --now " + ownCertificateWatcher + ".path",
+	})
+	recordExternal(externalResource{
+		Kind:        resourceFile,
+		Path:        servicePath,
+		Description: "systemd service copying renewed certificates",
+		Undo:        "systemctl daemon-reload",
+	})
+
+	if err := run("systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("systemctl daemon-reload failed: %v", err)
+	}
+	if err := run("systemctl", "enable", "--now", ownCertificateWatcher+".path"); err != nil {
+		return fmt.Errorf("could not enable %s.path: %v", ownCertificateWatcher, err)
+	}
+	return nil
+}
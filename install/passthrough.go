@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Passthrough routers are TCP routers, which Traefik always evaluates before
+// the HTTP routers of the dashboard. Exact names rank above wildcards so a
+// specific passthrough wins over a broader one.
+const (
+	passthroughExactPriority    = 200
+	passthroughWildcardPriority = 100
+)
+
+// TLSPassthrough forwards raw TLS for an SNI name on port 443 to a backend
+// that terminates TLS itself
+type TLSPassthrough struct {
+	SNI     string
+	Backend string
+}
+
+// Name is the router and service name in the Traefik dynamic config
+func (p TLSPassthrough) Name() string {
+	name := strings.NewReplacer("*.", "wildcard-", ".", "-").Replace(p.SNI)
+	return installerNamePrefix + "passthrough-" + name
+}
+
+// Rule is the HostSNI rule of the router
+func (p TLSPassthrough) Rule() string {
+	if domain, ok := strings.CutPrefix(p.SNI, "*."); ok {
+		return "HostSNIRegexp(`^[^.]+\\." + regexp.QuoteMeta(domain) + "$`)"
+	}
+	return "HostSNI(`" + p.SNI + "`)"
+}
+
+func (p TLSPassthrough) Priority() int {
+	if strings.HasPrefix(p.SNI, "*.") {
+		return passthroughWildcardPriority
+	}
+	return passthroughExactPriority
+}
+
+// matches reports whether the passthrough would capture connections for host
+func (p TLSPassthrough) matches(host string) bool {
+	if domain, ok := strings.CutPrefix(p.SNI, "*."); ok {
+		label, rest, found := strings.Cut(host, ".")
+		return found && label != "" && strings.EqualFold(rest, domain)
+	}
+	return strings.EqualFold(p.SNI, host)
+}
+
+var hostnamePattern = regexp.MustCompile(`^(\*\.)?([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?\.)+[a-zA-Z]{2,}$`)
+
+// reservedHosts are the names Traefik must keep terminating TLS for
+func reservedHosts(config Config) []string {
+	return []string{config.DashboardDomain}
+}
+
+// validatePassthroughSNI rejects SNI names that are invalid, already used or
+// would take connections away from the dashboard
+func validatePassthroughSNI(sni string, existing []TLSPassthrough, reserved []string) error {
+	if !hostnamePattern.MatchString(sni) {
+		return fmt.Errorf("%q is not a valid hostname", sni)
+	}
+	candidate := TLSPassthrough{SNI: strings.ToLower(sni)}
+	for _, host := range reserved {
+		if candidate.matches(host) {
+			return fmt.Errorf("%s would capture %s, which Traefik serves itself", sni, host)
+		}
+	}
+	for _, other := range existing {
+		if strings.EqualFold(other.SNI, sni) {
+			return fmt.Errorf("%s is already passed through to %s", sni, other.Backend)
+		}
+	}
+	return nil
+}
+
+// validatePassthroughBackend accepts a host:port backend
+func validatePassthroughBackend(backend string) error {
+	if _, port, err := net.SplitHostPort(backend); err != nil || port == "" {
+		return fmt.Errorf("backend %q must be host:port", backend)
+	}
+	return nil
+}
+
+// collectTLSPassthroughs asks for the hostnames whose TLS traffic is forwarded
+// as-is instead of being terminated by Traefik
+func collectTLSPassthroughs(config *Config) {
+	if !readBool("tls_passthrough", tr("prompt.tls_passthrough"), false) {
+		return
+	}
+	infoln("Passthrough backends terminate TLS themselves. Wildcards such as *.example.com match one subdomain level.")
+	if reconfiguring && len(installedSettings.TLSPassthroughs) > 0 {
+		config.TLSPassthroughs = slices.Clone(installedSettings.TLSPassthroughs)
+		for _, passthrough := range config.TLSPassthroughs {
+			infof("Keeping the passthrough of %s to %s.\n", passthrough.SNI, passthrough.Backend)
+		}
+		if !readBool("tls_passthrough_more", tr("prompt.tls_passthrough_more"), false) {
+			return
+		}
+	}
+	for {
+		sni := readValidated("tls_passthrough_sni", tr("prompt.tls_passthrough_sni"), "", func(s string) error {
+			return validatePassthroughSNI(s, config.TLSPassthroughs, reservedHosts(*config))
+		})
+		candidate := TLSPassthrough{
+			SNI:     strings.ToLower(sni),
+			Backend: readValidated("tls_passthrough_backend", tr("prompt.tls_passthrough_backend"), "", validatePassthroughBackend),
+		}
+		config.TLSPassthroughs = append(config.TLSPassthroughs, candidate)
+		infof("%s will be passed through to %s.\n", candidate.SNI, candidate.Backend)
+		if !readBool("tls_passthrough_more", tr("prompt.tls_passthrough_more"), false) {
+			return
+		}
+	}
+}
+
+// runVerify implements the verify subcommand
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	dirFlag := fs.String("dir", "", "Installation directory (default: the current directory or /opt/pangolin)")
+	addTerminalFlags(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	dir := *dirFlag
+	if dir == "" {
+		var ok bool
+		if dir, ok = locateExistingInstall(); !ok {
+			fatalf("Error: no Pangolin installation found in the current directory or /opt/pangolin\n")
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+
+	domain := installedDashboardDomain()
+	if domain == "" {
+		fatalf("Error: could not read the dashboard domain from %s\n", filepath.Join(dir, "config/config.yml"))
+	}
+	if installedBehindExistingProxy() {
+		infof("%s is served by an existing reverse proxy, there is no Traefik routing to verify.\n", domain)
+		return
+	}
+	passthroughs, err := installedPassthroughs("config/traefik/dynamic_config.yml")
+	if err != nil {
+		warnf("Warning: could not read the passthrough routers: %v\n", err)
+	}
+	failed := false
+	if err := verifySNIRouting(domain, passthroughs); err != nil {
+		errorf("Error: %v\n", err)
+		failed = true
+	}
+	if containerType := detectContainerType(); containerType == Undefined {
+		warnf("Warning: neither Docker nor Podman is running, skipping the Traefik router check.\n")
+	} else if err := verifyDashboardRouters(containerType, domain); err != nil {
+		errorf("Error: %v\n", err)
+		failed = true
+	}
+	if failed {
+		fatalf("Verification failed.\n")
+	}
+}
+
+// verifySNIRouting handshakes with port 443 for the dashboard and for every
+// passthrough name. The dashboard must be answered by Traefik and each
+// passthrough by a different certificate, its backend.
+func verifySNIRouting(dashboardDomain string, passthroughs []TLSPassthrough) error {
+	infoln("\n=== TLS Routing ===")
+	_, httpsPort := installedEntrypointPorts("config/traefik/traefik_config.yml")
+	dashboardCert, err := handshakeLocal(dashboardDomain, httpsPort)
+	if err != nil {
+		return fmt.Errorf("TLS handshake for %s failed: %v", dashboardDomain, err)
+	}
+	failed := false
+	if isTraefikDefaultCert(dashboardCert) {
+		failed = true
+		errorf("%s: answered with Traefik's default self-signed certificate, no Let's Encrypt certificate is loaded. Check acme.json with the doctor subcommand and the Traefik logs.\n", dashboardDomain)
+	} else {
+		infof("%s: answered by Traefik (%s)\n", dashboardDomain, certLabel(dashboardCert))
+	}
+
+	for _, passthrough := range passthroughs {
+		sni := passthrough.SNI
+		if domain, ok := strings.CutPrefix(sni, "*."); ok {
+			sni = "installer-check." + domain
+		}
+		cert, err := handshakeLocal(sni, httpsPort)
+		switch {
+		case err != nil:
+			failed = true
+			errorf("%s: handshake failed, is %s reachable from Traefik? (%v)\n", sni, passthrough.Backend, err)
+		case bytes.Equal(cert.Raw, dashboardCert.Raw):
+			failed = true
+			errorf("%s: answered by Traefik instead of %s, the passthrough router is not matching\n", sni, passthrough.Backend)
+		default:
+			infof("%s: passed through to %s (%s)\n", sni, passthrough.Backend, certLabel(cert))
+		}
+	}
+	if failed {
+		return fmt.Errorf("TLS routing is not working as configured")
+	}
+	return nil
+}
+
+// handshakeLocal performs a TLS handshake with the local HTTPS port for
+// serverName and returns the leaf certificate
+func handshakeLocal(serverName string, port int) (*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dialer := tls.Dialer{Config: &tls.Config{ServerName: serverName, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate presented")
+	}
+	return certs[0], nil
+}
+
+func certLabel(cert *x509.Certificate) string {
+	if len(cert.DNSNames) > 0 {
+		return "certificate for " + strings.Join(cert.DNSNames, ", ")
+	}
+	return "certificate " + cert.Subject.CommonName
+}
+
+// installedPassthroughs reads the passthrough routers written by the installer
+func installedPassthroughs(path string) ([]TLSPassthrough, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dynamic struct {
+		TCP struct {
+			Routers map[string]struct {
+				Rule    string `yaml:"rule"`
+				Service string `yaml:"service"`
+			} `yaml:"routers"`
+			Services map[string]struct {
+				LoadBalancer struct {
+					Servers []struct {
+						Address string `yaml:"address"`
+					} `yaml:"servers"`
+				} `yaml:"loadBalancer"`
+			} `yaml:"services"`
+		} `yaml:"tcp"`
+	}
+	if err := yaml.Unmarshal(data, &dynamic); err != nil {
+		return nil, err
+	}
+
+	exact := regexp.MustCompile("^HostSNI\\(`([^`]+)`\\)$")
+	wildcard := regexp.MustCompile("^HostSNIRegexp\\(`\\^\\[\\^\\.\\]\\+\\\\\\.(.+)\\$`\\)$")
+	var passthroughs []TLSPassthrough
+	for name, router := range dynamic.TCP.Routers {
+		if !strings.HasPrefix(strings.TrimPrefix(name, installerNamePrefix), "passthrough-") {
+			continue
+		}
+		var sni string
+		if m := exact.FindStringSubmatch(router.Rule); m != nil {
+			sni = m[1]
+		} else if m := wildcard.FindStringSubmatch(router.Rule); m != nil {
+			sni = "*." + strings.ReplaceAll(m[1], `\.`, ".")
+		} else {
+			continue
+		}
+		var backend string
+		if servers := dynamic.TCP.Services[router.Service].LoadBalancer.Servers; len(servers) > 0 {
+			backend = servers[0].Address
+		}
+		passthroughs = append(passthroughs, TLSPassthrough{SNI: sni, Backend: backend})
+	}
+	return passthroughs, nil
+}
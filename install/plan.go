@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const planFormat = 1
+
+// installPlan is everything apply needs to reproduce a reviewed install
+type installPlan struct {
+	Format           int            `json:"format"`
+	InstallerVersion string         `json:"installerVersion"`
+	CreatedAt        time.Time      `json:"createdAt"`
+	Dir              string         `json:"dir"`
+	Config           Config         `json:"config"`
+	Dirs             []string       `json:"dirs"`
+	Files            []renderedFile `json:"files"`
+	Actions          []planAction   `json:"actions"`
+	// Manifest is the on-disk state of every planned file and of the Traefik
+	// config directory when the plan was made
+	Manifest fileManifest `json:"manifest"`
+	// Edited are the files changed in an editor while planning
+	Edited []string `json:"edited,omitempty"`
+	// Generated are the hashes of the files before they were edited
+	Generated fileManifest `json:"generated,omitempty"`
+}
+
+type planAction struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+}
+
+const (
+	actionDownloadMaxMind   = "download-maxmind"
+	actionDockerLogDefaults = "docker-log-defaults"
+	actionPullImages        = "pull-images"
+	actionStartContainers   = "start-containers"
+	actionUpdateSchedule    = "update-schedule"
+	actionBackupSchedule    = "backup-schedule"
+)
+
+// planEnvelope carries the serialized plan with its hash so that apply can
+// detect a corrupted or edited artifact
+type planEnvelope struct {
+	Plan   []byte `json:"plan"`
+	SHA256 string `json:"sha256"`
+}
+
+// runPlan implements the plan subcommand
+func runPlan(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	out := fs.String("out", "plan.bin", "File to write the plan artifact to")
+	dir := fs.String("dir", defaultInstallDir, "Installation directory the plan targets")
+	redisFlag = fs.Bool("redis", false, "Install Redis as caching solution. Required for HA. Not required for the Enterprise version.")
+	fs.BoolVar(&nonInteractive, "non-interactive", false, "Fail instead of prompting whenever input would be required")
+	addOfflineFlag(fs)
+	addNoTelemetryFlag(fs)
+	verboseFlag = fs.Bool("verbose", false, "List every answer with its source (prompt, default, flag, environment or answers file) in the summary")
+	addSimulateFlag(fs)
+	addACMEStagingFlag(fs)
+	addTerminalFlags(fs)
+	addPromptFlags(fs)
+	addTemplatesFlag(fs)
+	addDataDirFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: installer plan [--out plan.bin] [--dir <path>] [flags]")
+		printFlags(fs)
+	}
+	fs.Parse(args)
+	resolveTerminal()
+	loadAnswersFile()
+	checkAnswerEnvironment()
+	answerWithoutTerminal()
+
+	installDir, err := filepath.Abs(*dir)
+	if err != nil {
+		fatalf("Error resolving path: %v\n", err)
+	}
+	plan := planInstall(installDir)
+	printPlanSummary(plan, true)
+	printAnswerProvenance()
+
+	if err := writePlan(*out, plan); err != nil {
+		fatalf("Error writing plan: %v\n", err)
+	}
+	infof("\nPlan written to %s\n", *out)
+	warnf("The plan contains the generated secrets and passwords. Review the summary above, not the artifact, in public places.\n")
+	infof("Apply exactly this plan with: installer apply %s\n", *out)
+}
+
+// planInstall asks the questions of a fresh install in installDir and renders
+// its files, without changing anything on disk. The plan subcommand and a
+// fresh --dry-run review the result.
+func planInstall(installDir string) installPlan {
+	if hasExistingInstall(installDir) {
+		exitf(exitPreflight, "Error: %s already contains an installation, plans and dry runs of a fresh install need an empty directory\n", installDir)
+	}
+	if err := checkInstallPath(installDir); err != nil {
+		exitf(exitPreflight, "Error: cannot install to %s: %v\n", installDir, err)
+	}
+	if dataDir != "" && dataDir != installDir {
+		if err := checkInstallPath(dataDir); err != nil {
+			exitf(exitPreflight, "Error: cannot use %s as data directory: %v\n", dataDir, err)
+		}
+	}
+
+	config := collectUserInput()
+	config.InstallDir = installDir
+	if dataDir != installDir {
+		config.DataDir = dataDir
+	}
+	loadVersions(&config)
+	config.Secret = generateRandomSecretKey()
+
+	var actions []planAction
+	if config.EnableMaxMind {
+		actions = append(actions, planAction{actionDownloadMaxMind, "Download the MaxMind GeoLite2 Country and ASN databases"})
+	}
+	if readBool("install_containers", tr("prompt.install_containers"), true) {
+		config.InstallationContainerType = readContainerType()
+		if config.DockerLogDefaults && config.InstallationContainerType == Docker {
+			actions = append(actions, planAction{actionDockerLogDefaults, "Set the log limits as Docker's default in " + dockerDaemonConfig + " and restart Docker"})
+		}
+		actions = append(actions,
+			planAction{actionPullImages, fmt.Sprintf("Pull the container images with %s", config.InstallationContainerType)},
+			planAction{actionStartContainers, fmt.Sprintf("Start the containers with %s", config.InstallationContainerType)},
+		)
+		if config.AutoUpdate == updateSchedule {
+			actions = append(actions, planAction{actionUpdateSchedule, "Install the automatic updates at " + config.UpdateSchedule})
+		}
+		if config.Backups() {
+			actions = append(actions, planAction{actionBackupSchedule, "Install the backups to " + config.BackupDir + " at " + config.BackupSchedule})
+		}
+	}
+
+	dirs, files, err := renderConfigFiles(config)
+	if err != nil {
+		fatalf("Error rendering config files: %v\n", err)
+	}
+	// The compose file lives in the install root
+	for i := range files {
+		if files[i].Path == "config/docker-compose.yml" {
+			files[i].Path = "docker-compose.yml"
+		}
+	}
+
+	generated := generatedManifest(files)
+	edited := offerFileEdits(files)
+
+	plan := installPlan{
+		Format:           planFormat,
+		InstallerVersion: pangolinVersion,
+		CreatedAt:        time.Now().UTC(),
+		Dir:              installDir,
+		Config:           config,
+		Dirs:             dirs,
+		Files:            files,
+		Actions:          actions,
+		Edited:           edited,
+		Generated:        generated,
+	}
+
+	manifest, err := planManifest(installDir, files)
+	if err != nil {
+		fatalf("Error hashing %s: %v\n", installDir, err)
+	}
+	plan.Manifest = manifest
+	return plan
+}
+
+// planManifest hashes the planned files and every file below the Traefik
+// config directory of installDir, by their path relative to it. Traefik
+// mounts the whole directory, so hand-written rule files next to the
+// generated ones must not change between plan and apply either. Its logs
+// are left out, they grow all the time.
+func planManifest(installDir string, files []renderedFile) (fileManifest, error) {
+	var rels []string
+	planned := make(map[string]bool, len(files))
+	for _, file := range files {
+		rels = append(rels, file.Path)
+		planned[file.Path] = true
+	}
+	root := filepath.Join(installDir, "config", "traefik")
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(installDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case slices.Contains(snapshotExcludes, rel):
+			return filepath.SkipDir
+		case !d.IsDir() && !planned[rel]:
+			rels = append(rels, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(rels))
+	for i, rel := range rels {
+		paths[i] = filepath.Join(installDir, filepath.FromSlash(rel))
+	}
+	hashes, err := buildManifest(paths)
+	if err != nil {
+		return nil, err
+	}
+	manifest := make(fileManifest, len(rels))
+	for i, rel := range rels {
+		manifest[rel] = hashes[paths[i]]
+	}
+	return manifest, nil
+}
+
+// runApply implements the apply subcommand
+func runApply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: installer apply [--offline] <plan.bin>")
+		fs.PrintDefaults()
+	}
+	healthTimeoutFlag = fs.Duration("health-timeout", defaultHealthTimeout, "How long to wait for the stack to become healthy after starting it")
+	addSELinuxFlag(fs)
+	addOfflineFlag(fs)
+	addParallelPullsFlag(fs)
+	addExternalCheckFlag(fs)
+	addNoTelemetryFlag(fs)
+	addTerminalFlags(fs)
+	addLogFileFlag(fs)
+	fs.Parse(args)
+	resolveTerminal()
+	resolvePlatform()
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	plan, err := readPlan(fs.Arg(0))
+	if err != nil {
+		fatalf("Error reading plan: %v\n", err)
+	}
+	if plan.InstallerVersion != pangolinVersion {
+		exitf(exitInvalidInput, "Error: the plan was created by installer %s but this is installer %s\n", plan.InstallerVersion, pangolinVersion)
+	}
+
+	if err := os.MkdirAll(plan.Dir, 0755); err != nil {
+		fatalf("Error creating directory: %v\n", err)
+	}
+	if err := os.Chdir(plan.Dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+
+	current, err := planManifest(plan.Dir, plan.Files)
+	if err != nil {
+		fatalf("Error checking %s: %v\n", plan.Dir, err)
+	}
+	drifted := plan.Manifest.changed(current)
+	if len(drifted) > 0 {
+		errorf("Error: %s changed since the plan was created:\n", plan.Dir)
+		for _, path := range drifted {
+			errorf("  %s\n", path)
+		}
+		exitf(exitPreflight, "Create a new plan against the current state.\n")
+	}
+
+	openInstallLog(plan.Dir)
+	defer installLog.close()
+	checkStorageSpeed(plan.Dir)
+	checkSELinux()
+
+	printPlanSummary(*plan, false)
+	config := plan.Config
+	report.setConfig(config)
+
+	infoln("\n=== Applying Plan ===")
+	cleanups.arm()
+	defer cleanups.disarm()
+	if err := writeRenderedFiles(plan.Dirs, plan.Files); err != nil {
+		fatalf("Error creating config files: %v\n", err)
+	}
+	recordEditedFiles(plan.Files, plan.Edited)
+	recordGeneratedFiles(plan.Generated)
+	recordDataDir(config.DataDir)
+	applySELinux("docker-compose.yml", plan.Dir, config.DataDir)
+	if config.ExternalProxy {
+		printProxyExamples(config)
+	}
+
+	adminReady := false
+	for _, action := range plan.Actions {
+		switch action.Kind {
+		case actionDownloadMaxMind:
+			if err := downloadMaxMindDatabase(); err != nil {
+				errorf("Error downloading MaxMind databases: %v\n", err)
+				infoln("You can download it manually later if needed.")
+			}
+		case actionDockerLogDefaults:
+			applyDockerLogDefaults(config)
+		case actionPullImages:
+			prepareContainerRuntime(config.InstallationContainerType)
+			if err := pullContainers(config.InstallationContainerType); err != nil {
+				exitf(exitCodeOf(err, exitContainers), "Error: %v\n", err)
+			}
+		case actionStartContainers:
+			pushComposeDown(config.InstallationContainerType)
+			if err := startContainers(config.InstallationContainerType); err != nil {
+				exitf(exitContainers, "Error: %v\n", err)
+			}
+			report.collectContainers(config.InstallationContainerType)
+			if err := waitForStackHealthy(config.InstallationContainerType, config.DashboardDomain); err != nil {
+				exitf(exitCodeOf(err, exitContainers), "Error: %v\n", err)
+			}
+			adminReady = setupFirstAdmin(config)
+			cleanups.commit()
+		case actionUpdateSchedule:
+			installUpdateSchedule(config, plan.Dir)
+		case actionBackupSchedule:
+			installBackupSchedule(config, plan.Dir)
+		default:
+			fatalf("Error: unknown plan action %q\n", action.Kind)
+		}
+	}
+
+	telemetrySent := sendTelemetry(config)
+	infoln("\n" + tr("summary.plan_applied"))
+	printFirstLogin(config, adminReady)
+	printStagingNotice(config)
+	printSecretFiles(config, plan.Dir)
+	printExternalPorts("docker-compose.yml")
+	if slices.ContainsFunc(plan.Actions, func(action planAction) bool { return action.Kind == actionStartContainers }) {
+		runExternalCheck(config)
+	}
+	<-telemetrySent
+	report.emit("success", "")
+}
+
+// printPlanSummary prints the human readable plan. Diffs are only shown while
+// planning, apply has already verified that nothing changed since.
+func printPlanSummary(plan installPlan, withDiff bool) {
+	infoln("\n=== Plan ===")
+	infof("Installer version: %s\n", plan.InstallerVersion)
+	infof("Directory: %s\n", plan.Dir)
+	infof("Dashboard: %s\n", plan.Config.DashboardURL())
+	infof("Domains: %s\n", strings.Join(plan.Config.BaseDomains(), ", "))
+
+	infoln("\nFiles:")
+	for _, file := range plan.Files {
+		verb := "create"
+		if plan.Manifest[file.Path] != "" {
+			verb = "replace"
+		}
+		infof("  %-8s %s\n", verb, file.Path)
+	}
+	if withDiff {
+		secrets := configSecrets(plan.Config)
+		for _, file := range plan.Files {
+			var old []byte
+			if plan.Manifest[file.Path] != "" {
+				old, _ = os.ReadFile(filepath.Join(plan.Dir, file.Path))
+			}
+			if diff := renderFileDiff(file.Path, old, file.Content, secrets); diff != "" {
+				infof("\n%s", diff)
+			}
+		}
+	}
+
+	infoln("\nActions:")
+	if len(plan.Actions) == 0 {
+		infoln("  none")
+	}
+	for i, action := range plan.Actions {
+		infof("  %d. %s\n", i+1, action.Description)
+	}
+}
+
+func writePlan(path string, plan installPlan) error {
+	payload, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	envelope, err := json.MarshalIndent(planEnvelope{Plan: payload, SHA256: hashBytes(payload)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The plan holds secrets
+	return os.WriteFile(path, envelope, 0600)
+}
+
+func readPlan(path string) (*installPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var envelope planEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("%s is not a plan file: %v", path, err)
+	}
+	if hashBytes(envelope.Plan) != envelope.SHA256 {
+		return nil, fmt.Errorf("%s is corrupted or was modified after it was created", path)
+	}
+
+	var plan installPlan
+	if err := json.Unmarshal(envelope.Plan, &plan); err != nil {
+		return nil, fmt.Errorf("%s is not a plan file: %v", path, err)
+	}
+	if plan.Format != planFormat {
+		return nil, fmt.Errorf("%s uses plan format %d, this installer supports format %d", path, plan.Format, planFormat)
+	}
+	return &plan, nil
+}
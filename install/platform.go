@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// platformCapabilities is what the installer can do on the host platform. It
+// is decided once by resolvePlatform and consulted by the later steps.
+type platformCapabilities struct {
+	Name string
+	// InstallDocker is set when Docker can be installed through the package
+	// manager and started with systemctl
+	InstallDocker bool
+	// SystemdUnit is set when a pangolin.service unit can start the stack on boot
+	SystemdUnit bool
+	// Firewall is set when ufw or firewalld on the host guard the published ports
+	Firewall bool
+	// LinuxPreflight enables the SELinux, port and Podman sysctl checks
+	LinuxPreflight bool
+	// PublicAddress is set when the DNS records should point at the host's own
+	// addresses, rather than at a router or Windows host forwarding to it
+	PublicAddress bool
+	// DockerGroup is set when membership in the docker group grants access to
+	// the Docker socket
+	DockerGroup bool
+	// Caveats are printed at startup
+	Caveats []string
+}
+
+// linuxPlatform is a regular Linux server, the default until resolvePlatform
+var linuxPlatform = platformCapabilities{
+	Name:           "Linux",
+	InstallDocker:  true,
+	SystemdUnit:    true,
+	Firewall:       true,
+	LinuxPreflight: true,
+	PublicAddress:  true,
+	DockerGroup:    true,
+}
+
+var platform = linuxPlatform
+
+// detectPlatform decides the capabilities of the host described by provider.
+// Platforms the installer cannot set up Pangolin on return an error.
+func detectPlatform(provider factsProvider) (platformCapabilities, error) {
+	switch goos := provider.goos(); {
+	case goos == "linux" && provider.wsl() == 1:
+		return platformCapabilities{}, fmt.Errorf("WSL 1 cannot run containers, convert the distribution with wsl --set-version <distro> 2 and run the installer again")
+	case goos == "linux" && provider.wsl() == 2:
+		caveats := []string{
+			"Ports published inside WSL are reachable from Windows, but only from other machines with mirrored networking or a netsh portproxy rule, and the Windows firewall must allow them.",
+			"The pangolin.service unit and the ufw/firewalld rules are skipped, start the stack with docker compose up -d.",
+		}
+		if !provider.systemd() {
+			caveats = append(caveats, "systemd is not enabled in this distribution. Installing Docker needs it: add [boot] systemd=true to /etc/wsl.conf and run wsl --shutdown, or use Docker Desktop's WSL integration.")
+		}
+		return platformCapabilities{
+			Name:           "WSL 2",
+			InstallDocker:  provider.systemd(),
+			LinuxPreflight: true,
+			DockerGroup:    true,
+			Caveats:        caveats,
+		}, nil
+	case goos == "linux":
+		return linuxPlatform, nil
+	case goos == "darwin":
+		return platformCapabilities{
+			Name: "macOS",
+			Caveats: []string{
+				"macOS is supported for evaluation with Docker Desktop. Install and start Docker Desktop yourself, the installer does not install Docker on macOS.",
+				"Docker Desktop publishes the ports on this Mac. Forward 80, 443 and the WireGuard ports from your router to reach it from the internet.",
+			},
+		}, nil
+	default:
+		return platformCapabilities{}, fmt.Errorf("%s/%s is not supported. Pangolin runs on Linux servers, WSL 2 and macOS with Docker Desktop can be used for evaluation", goos, provider.goarch())
+	}
+}
+
+// resolvePlatform detects the platform at startup, printing its caveats, and
+// exits when it is unsupported
+func resolvePlatform() {
+	detected, err := detectPlatform(facts)
+	if err != nil {
+		exitf(exitPreflight, "Error: %v\n", err)
+	}
+	platform = detected
+	logf("INFO", "platform: %s", platform.Name)
+	if len(platform.Caveats) == 0 {
+		return
+	}
+	warnf("\nRunning on %s:\n", platform.Name)
+	for _, caveat := range platform.Caveats {
+		warnf("  - %s\n", caveat)
+	}
+}
+
+// wslVersion tells WSL 1 from WSL 2 by the kernel release, 0 outside WSL.
+// WSL 1 reports a Microsoft kernel, WSL 2 a microsoft-standard-WSL2 one.
+func wslVersion(osrelease string) int {
+	switch {
+	case strings.Contains(osrelease, "WSL2"), strings.Contains(osrelease, "microsoft-standard"):
+		return 2
+	case strings.Contains(strings.ToLower(osrelease), "microsoft"):
+		return 1
+	}
+	return 0
+}
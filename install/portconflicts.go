@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// hostPort is a port the stack publishes on the host. Port points at the
+// setting it comes from, nil for a port that cannot be changed.
+type hostPort struct {
+	Name   string
+	Proto  string
+	Number int
+	Port   *int
+}
+
+func (p hostPort) String() string {
+	return fmt.Sprintf("%d/%s", p.Number, p.Proto)
+}
+
+// hostPorts are the ports config publishes on the host
+func hostPorts(config *Config) []hostPort {
+	var ports []hostPort
+	switch {
+	case config.ExternalProxy:
+		ports = append(ports,
+			hostPort{"dashboard", "tcp", config.ProxyDashboardPort, &config.ProxyDashboardPort},
+			hostPort{"API", "tcp", config.ProxyAPIPort, &config.ProxyAPIPort})
+	default:
+		if config.HTTPEnabled() {
+			ports = append(ports, hostPort{"HTTP", "tcp", config.HTTPPort, &config.HTTPPort})
+		}
+		ports = append(ports, hostPort{"HTTPS", "tcp", config.HTTPSPort, &config.HTTPSPort})
+	}
+	if config.InstallGerbil {
+		gerbil := config.GerbilPorts()
+		if config.WireGuardPort == 0 {
+			config.WireGuardPort = gerbil[0]
+		}
+		ports = append(ports,
+			hostPort{"WireGuard", "udp", gerbil[0], &config.WireGuardPort},
+			hostPort{"WireGuard client", "udp", gerbil[1], nil})
+	}
+	return ports
+}
+
+// portInUse reports whether something on the host is bound to port
+func portInUse(proto string, port int) bool {
+	address := net.JoinHostPort("", strconv.Itoa(port))
+	if proto == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err == nil {
+			conn.Close()
+		}
+		return errors.Is(err, syscall.EADDRINUSE)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err == nil {
+		listener.Close()
+	}
+	return errors.Is(err, syscall.EADDRINUSE)
+}
+
+// portProcess is the process holding a port. Pid is 0 for a socket of the
+// kernel, e.g. of a WireGuard interface.
+type portProcess struct {
+	Name string
+	Pid  int
+}
+
+func (p portProcess) String() string {
+	switch {
+	case p.Name == "":
+		return "an unknown process"
+	case p.Pid == 0:
+		return p.Name
+	}
+	return fmt.Sprintf("%s (pid %d)", p.Name, p.Pid)
+}
+
+// portOwner finds the process bound to port from the socket tables in /proc,
+// falling back to ss for sockets of other network namespaces
+func portOwner(proto string, port int) portProcess {
+	if inode, ok := socketInode(proto, port); ok {
+		if inode == "0" {
+			return portProcess{Name: "the kernel"}
+		}
+		if owner, ok := inodeOwner(inode); ok {
+			return owner
+		}
+	}
+	return ssPortOwner(proto, port)
+}
+
+// socketInode looks up the inode of the listening socket on port in
+// /proc/net. UDP sockets of the kernel, like those of WireGuard interfaces,
+// have inode 0.
+func socketInode(proto string, port int) (string, bool) {
+	// TCP_LISTEN and UDP's unconnected state
+	state := "0A"
+	if proto == "udp" {
+		state = "07"
+	}
+	suffix := fmt.Sprintf(":%04X", port)
+	for _, table := range []string{proto, proto + "6"} {
+		file, err := os.Open(filepath.Join("/proc/net", table))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 9 && strings.HasSuffix(fields[1], suffix) && fields[3] == state {
+				file.Close()
+				return fields[9], true
+			}
+		}
+		file.Close()
+	}
+	return "", false
+}
+
+// inodeOwner finds the process with a file descriptor for the socket inode
+func inodeOwner(inode string) (portProcess, bool) {
+	target := "socket:[" + inode + "]"
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if link, err := os.Readlink(fd); err != nil || link != target {
+			continue
+		}
+		pidDir := filepath.Dir(filepath.Dir(fd))
+		pid, _ := strconv.Atoi(filepath.Base(pidDir))
+		comm, _ := os.ReadFile(filepath.Join(pidDir, "comm"))
+		return portProcess{Name: strings.TrimSpace(string(comm)), Pid: pid}, true
+	}
+	return portProcess{}, false
+}
+
+var ssUserPattern = regexp.MustCompile(`users:\(\("([^"]+)",pid=(\d+)`)
+
+// ssPortOwner asks ss for the process bound to port
+func ssPortOwner(proto string, port int) portProcess {
+	flag := "-t"
+	if proto == "udp" {
+		flag = "-u"
+	}
+	out, err := exec.Command("ss", "-Hlnp", flag, fmt.Sprintf("sport = :%d", port)).Output()
+	if err != nil {
+		return portProcess{}
+	}
+	match := ssUserPattern.FindStringSubmatch(string(out))
+	if match == nil {
+		return portProcess{}
+	}
+	pid, _ := strconv.Atoi(match[2])
+	return portProcess{Name: match[1], Pid: pid}
+}
+
+// webServers are the services that commonly hold ports 80 and 443
+var webServers = []string{"nginx", "apache2", "httpd", "caddy", "lighttpd", "haproxy", "traefik"}
+
+// portRemedy suggests how to free port from owner
+func portRemedy(port hostPort, owner portProcess) string {
+	switch {
+	case slices.Contains([]string{"docker-proxy", "rootlessport", "conmon", "pasta", "slirp4netns"}, owner.Name):
+		return fmt.Sprintf("A container publishes it, find it with docker ps --filter publish=%d and stop it.", port.Number)
+	case owner.Name == "the kernel" && port.Proto == "udp":
+		return "A WireGuard interface may use it, wg show lists them. Remove it with ip link delete <interface>."
+	case slices.Contains(webServers, owner.Name):
+		return fmt.Sprintf("Stop it with systemctl disable --now %s, or install Pangolin behind it as an existing reverse proxy.", owner.Name)
+	case owner.Pid != 0:
+		return fmt.Sprintf("Stop %s, or find out what started it with systemctl status %d.", owner.Name, owner.Pid)
+	}
+	return fmt.Sprintf("Find the process with ss -lnp 'sport = :%d'.", port.Number)
+}
+
+// alternatePort is a free port to use instead of port, not one of taken
+func alternatePort(port hostPort, taken []int) int {
+	candidate := port.Number + 1
+	switch port.Number {
+	case defaultHTTPPort:
+		candidate = 8080
+	case defaultHTTPSPort:
+		candidate = 8443
+	}
+	for ; candidate <= 65535; candidate++ {
+		if !slices.Contains(taken, candidate) && !portInUse(port.Proto, candidate) {
+			return candidate
+		}
+	}
+	return 0
+}
+
+// resolvePortConflicts finds the ports config publishes that something on
+// the host already holds, tells who holds them and how to free them, and
+// offers to move the stack to a free port instead. Declined conflicts fail
+// the ports pre-flight check.
+func resolvePortConflicts(config *Config) {
+	if !isRoot() || slices.Contains(skippedChecks, "ports") {
+		return
+	}
+	ports := hostPorts(config)
+	var taken []int
+	for _, port := range ports {
+		taken = append(taken, port.Number)
+	}
+	for _, port := range ports {
+		if !portInUse(port.Proto, port.Number) {
+			continue
+		}
+		owner := portOwner(port.Proto, port.Number)
+		logf("INFO", "port %s is held by %s", port, owner)
+		warnf("Warning: the %s port %s is in use by %s.\n", port.Name, port, owner)
+		infoln(portRemedy(port, owner))
+		if port.Port == nil {
+			infof("Gerbil needs %s for client connections, it cannot be changed.\n", port)
+			continue
+		}
+		alternate := alternatePort(port, taken)
+		if alternate == 0 || !readBool("port_conflict", tr("prompt.port_conflict", alternate, port.Name, port), false) {
+			continue
+		}
+		*port.Port = alternate
+		taken = append(taken, alternate)
+		logf("INFO", "moved the %s port from %d to %d", port.Name, port.Number, alternate)
+		switch port.Name {
+		case "HTTP", "HTTPS":
+			warnEntrypointForwards(*config)
+		case "WireGuard":
+			infof("Newt sites connect to UDP %d, forward it to this server when it is behind NAT.\n", alternate)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default Traefik entrypoint ports
+const (
+	defaultHTTPPort  = 80
+	defaultHTTPSPort = 443
+)
+
+// WireGuard ports of Gerbil: sites connect to the first, clients to the second
+const (
+	defaultWireGuardPort = 51820
+	clientWireGuardPort  = 21820
+)
+
+// GerbilPorts are the UDP ports the Gerbil container publishes
+func (c Config) GerbilPorts() []int {
+	port := c.WireGuardPort
+	if port == 0 {
+		port = defaultWireGuardPort
+	}
+	return []int{port, clientWireGuardPort}
+}
+
+// collectWireGuardPort asks for the UDP port Newt sites connect to. A port in
+// use on the host is found by the pre-flight, see resolvePortConflicts.
+func collectWireGuardPort(config *Config) {
+	config.WireGuardPort = installedWireGuardPort("config/config.yml")
+	config.WireGuardPort = readIntValidated("wireguard_port", tr("prompt.wireguard_port"), config.WireGuardPort, 1, 65535, validateUDPPort)
+}
+
+// validateUDPPort rejects the client WireGuard port
+func validateUDPPort(port int) error {
+	if port == clientWireGuardPort {
+		return fmt.Errorf("port %d is used by Gerbil for client connections, choose a different port", port)
+	}
+	return nil
+}
+
+// installedWireGuardPort reads gerbil.start_port from config.yml, falling back
+// to the default
+func installedWireGuardPort(configPath string) int {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return defaultWireGuardPort
+	}
+	var config struct {
+		Gerbil struct {
+			StartPort int `yaml:"start_port"`
+		} `yaml:"gerbil"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil || config.Gerbil.StartPort == 0 {
+		return defaultWireGuardPort
+	}
+	return config.Gerbil.StartPort
+}
+
+// DashboardURL is the public URL of the dashboard, including the HTTPS port
+// when it is not the default
+func (c Config) DashboardURL() string {
+	if c.HTTPSPort == 0 || c.HTTPSPort == defaultHTTPSPort {
+		return "https://" + c.DashboardDomain
+	}
+	return "https://" + net.JoinHostPort(c.DashboardDomain, strconv.Itoa(c.HTTPSPort))
+}
+
+// collectEntrypointPorts asks for the external HTTP and HTTPS ports Traefik
+// listens on and warns about the ACME challenges that need port forwards
+func collectEntrypointPorts(config *Config) {
+	config.HTTPPort = defaultHTTPPort
+	config.HTTPSPort = defaultHTTPSPort
+	if !readBool("custom_ports", tr("prompt.custom_ports"), false) {
+		return
+	}
+
+	reserved := map[int]string{}
+	if config.InstallGerbil {
+		for _, port := range config.GerbilPorts() {
+			reserved[port] = "Gerbil"
+		}
+	}
+	var earlier []int
+	if config.HTTPEnabled() {
+		config.HTTPPort = readIntValidated("http_port", tr("prompt.http_port"), config.HTTPPort, 1, 65535, func(port int) error {
+			return validateHostPorts(reserved, port)
+		})
+		earlier = append(earlier, config.HTTPPort)
+	}
+	config.HTTPSPort = readIntValidated("https_port", tr("prompt.https_port"), config.HTTPSPort, 1, 65535, func(port int) error {
+		return validateHostPorts(reserved, append(earlier, port)...)
+	})
+	warnEntrypointForwards(*config)
+}
+
+// warnEntrypointForwards warns about the ACME challenges that need port
+// forwards when the entry points do not listen on 80 and 443
+func warnEntrypointForwards(config Config) {
+	if !config.HTTPEnabled() || config.HTTPPort == defaultHTTPPort {
+		return
+	}
+	warnf("Warning: Let's Encrypt HTTP-01 challenges always connect to port 80. They only succeed if port 80 on %s is forwarded to port %d here.\n", config.DashboardDomain, config.HTTPPort)
+	if config.HTTPSPort == defaultHTTPSPort {
+		infoln("TLS-ALPN-01 validates over port 443 instead and does not need port 80.")
+	} else {
+		warnf("Warning: TLS-ALPN-01 challenges always connect to port 443, so it needs a forward from 443 to %d as well.\n", config.HTTPSPort)
+		infoln("DNS-01 validates with a DNS record and needs no forwarded port.")
+	}
+}
+
+// joinPorts lists ports as "80 and 443"
+func joinPorts(ports []int) string {
+	names := make([]string, len(ports))
+	for i, port := range ports {
+		names[i] = strconv.Itoa(port)
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// validateHostPorts rejects ports that collide with each other or with ports
+// reserved by other services. Listeners already running on the host are found
+// by the pre-flight, see resolvePortConflicts.
+func validateHostPorts(reserved map[int]string, ports ...int) error {
+	seen := map[int]bool{}
+	for _, port := range ports {
+		if seen[port] {
+			return fmt.Errorf("port %d is used twice, choose different ports", port)
+		}
+		seen[port] = true
+		if owner, ok := reserved[port]; ok {
+			return fmt.Errorf("port %d is used by %s, choose a different port", port, owner)
+		}
+	}
+	return nil
+}
+
+// installedEntrypointPorts reads the HTTP and HTTPS ports of an installed
+// Traefik, falling back to the defaults
+func installedEntrypointPorts(traefikConfigPath string) (httpPort, httpsPort int) {
+	values, err := ReadTraefikConfig(traefikConfigPath)
+	if err != nil {
+		return defaultHTTPPort, defaultHTTPSPort
+	}
+	return values.HTTPPort, values.HTTPSPort
+}
+
+// entryPointPort parses the port of a Traefik entrypoint address like ":443"
+// or "[::]:443"
+func entryPointPort(address string, def int) int {
+	i := strings.LastIndex(address, ":")
+	if n, err := strconv.Atoi(address[i+1:]); i >= 0 && err == nil {
+		return n
+	}
+	return def
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const postgresConnectTimeout = 5 * time.Second
+
+// PostgreSQLConnectionString is the connection string written to config.yml,
+// either for the bundled postgres service or the external server
+func (c Config) PostgreSQLConnectionString() string {
+	if !c.ExternalPostgreSQL {
+		u := url.URL{Scheme: "postgresql", User: url.UserPassword("pangolin", c.IsPostgreSQLPass), Host: "postgres:5432", Path: "/pangolin"}
+		return u.String()
+	}
+	u := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(c.PostgreSQLUser, c.IsPostgreSQLPass),
+		Host:   net.JoinHostPort(c.PostgreSQLHost, strconv.Itoa(c.PostgreSQLPort)),
+		Path:   "/" + c.PostgreSQLDatabase,
+	}
+	if c.PostgreSQLTLS {
+		u.RawQuery = "sslmode=require"
+	}
+	return u.String()
+}
+
+// BundledPostgreSQL reports whether the postgres service is part of the stack
+func (c Config) BundledPostgreSQL() bool {
+	return c.IsPostgreSQL && !c.ExternalPostgreSQL
+}
+
+// collectExternalPostgreSQL asks for the connection settings of an existing
+// server and tests them before the installation continues
+func collectExternalPostgreSQL(config *Config) {
+	config.PostgreSQLPort = 5432
+	config.PostgreSQLDatabase = "pangolin"
+	config.PostgreSQLUser = "pangolin"
+
+	for {
+		connection := newFormGroup(tr("form.postgresql"))
+		host := connection.text("postgresql_host", tr("prompt.postgresql_host"), config.PostgreSQLHost, nil)
+		port := connection.intInRange("postgresql_port", tr("prompt.postgresql_port"), config.PostgreSQLPort, 1, 65535)
+		database := connection.text("postgresql_database", tr("prompt.postgresql_database"), config.PostgreSQLDatabase, nil)
+		user := connection.text("postgresql_user", tr("prompt.postgresql_user"), config.PostgreSQLUser, nil)
+		password := connection.password("postgresql_password", tr("prompt.postgresql_external_password"))
+		connection.run()
+		config.PostgreSQLHost, config.PostgreSQLPort, config.PostgreSQLDatabase = *host, *port, *database
+		config.PostgreSQLUser, config.IsPostgreSQLPass = *user, *password
+		if config.PostgreSQLHost == "" {
+			fatalf("Error: PostgreSQL host is required\n")
+		}
+		if !network.allow("PostgreSQL connection test") {
+			return
+		}
+
+		for {
+			address := net.JoinHostPort(config.PostgreSQLHost, strconv.Itoa(config.PostgreSQLPort))
+			var usedTLS bool
+			err := runStep(context.Background(), "Connecting to PostgreSQL at "+address, func(ctx context.Context) error {
+				ctx, cancel := context.WithTimeout(ctx, postgresConnectTimeout)
+				defer cancel()
+				var err error
+				usedTLS, err = testPostgreSQL(ctx, config.PostgreSQLHost, config.PostgreSQLPort, config.PostgreSQLDatabase, config.PostgreSQLUser, config.IsPostgreSQLPass)
+				return err
+			})
+			if err == nil {
+				config.PostgreSQLTLS = usedTLS
+				infof("Connected to %s as %s.\n", address, config.PostgreSQLUser)
+				return
+			}
+
+			errorf("Could not connect to PostgreSQL: %v\n", err)
+			switch readChoice("postgresql_connect_failed", tr("prompt.postgresql_connect_failed"), []string{"retry", "edit", "continue"}, "retry") {
+			case "retry":
+				continue
+			case "continue":
+				warnf("Warning: continuing without a working PostgreSQL connection, Pangolin will not start until %s is reachable.\n", address)
+				return
+			}
+			break
+		}
+	}
+}
+
+// testPostgreSQL opens a connection and authenticates, which is enough to know
+// that the server is reachable, the credentials are valid and the database
+// exists. TLS is used when the server offers it and reported back.
+func testPostgreSQL(ctx context.Context, host string, port int, database, user, password string) (bool, error) {
+	conn, err := dialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// SSLRequest, answered with a single S or N
+	if _, err := conn.Write(pgMessage(0, binary.BigEndian.AppendUint32(nil, 80877103))); err != nil {
+		return false, err
+	}
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return false, err
+	}
+	usedTLS := answer[0] == 'S'
+	var rw io.ReadWriter = conn
+	if usedTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return true, fmt.Errorf("TLS handshake failed: %v", err)
+		}
+		rw = tlsConn
+	}
+
+	startup := binary.BigEndian.AppendUint32(nil, 196608) // protocol 3.0
+	for _, param := range []string{"user", user, "database", database, "application_name", "pangolin-installer"} {
+		startup = append(append(startup, param...), 0)
+	}
+	startup = append(startup, 0)
+	if _, err := rw.Write(pgMessage(0, startup)); err != nil {
+		return usedTLS, err
+	}
+
+	reader := bufio.NewReader(rw)
+	var scram *scramClient
+	for {
+		kind, body, err := readPGMessage(reader)
+		if err != nil {
+			return usedTLS, err
+		}
+		switch kind {
+		case 'E':
+			return usedTLS, pgError(body)
+		case 'Z':
+			rw.Write(pgMessage('X', nil))
+			return usedTLS, nil
+		case 'R':
+			if len(body) < 4 {
+				return usedTLS, errors.New("malformed authentication request")
+			}
+			var reply []byte
+			switch code := binary.BigEndian.Uint32(body); code {
+			case 0: // AuthenticationOk, wait for ReadyForQuery
+				continue
+			case 3: // cleartext
+				reply = append([]byte(password), 0)
+			case 5: // md5
+				inner := md5.Sum([]byte(password + user))
+				outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), body[4:8]...))
+				reply = append([]byte("md5"+hex.EncodeToString(outer[:])), 0)
+			case 10: // SASL
+				mechanisms := strings.Split(strings.TrimRight(string(body[4:]), "\x00"), "\x00")
+				if !slices.Contains(mechanisms, "SCRAM-SHA-256") {
+					return usedTLS, fmt.Errorf("unsupported SASL mechanisms %v", mechanisms)
+				}
+				scram = newSCRAMClient(password)
+				first := scram.clientFirst()
+				reply = append([]byte("SCRAM-SHA-256\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(first)))...)
+				reply = append(reply, first...)
+			case 11: // SASLContinue
+				if scram == nil {
+					return usedTLS, errors.New("unexpected SASL continuation")
+				}
+				final, err := scram.clientFinal(string(body[4:]))
+				if err != nil {
+					return usedTLS, err
+				}
+				reply = []byte(final)
+			case 12: // SASLFinal
+				if scram == nil || !scram.verifyServer(string(body[4:])) {
+					return usedTLS, errors.New("the server signature did not match")
+				}
+				continue
+			default:
+				return usedTLS, fmt.Errorf("unsupported authentication method %d", code)
+			}
+			if _, err := rw.Write(pgMessage('p', reply)); err != nil {
+				return usedTLS, err
+			}
+		}
+		// ParameterStatus, BackendKeyData and notices are not needed
+	}
+}
+
+// pgMessage frames a protocol message, kind 0 is used for untyped startup messages
+func pgMessage(kind byte, body []byte) []byte {
+	var msg []byte
+	if kind != 0 {
+		msg = append(msg, kind)
+	}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(body)+4))
+	return append(msg, body...)
+}
+
+func readPGMessage(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length < 4 || length > 1<<20 {
+		return 0, nil, fmt.Errorf("invalid message length %d", length)
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// pgError turns an ErrorResponse into an error with the message and SQLSTATE
+func pgError(body []byte) error {
+	var message, code string
+	for _, field := range bytes.Split(body, []byte{0}) {
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case 'M':
+			message = string(field[1:])
+		case 'C':
+			code = string(field[1:])
+		}
+	}
+	return fmt.Errorf("%s (SQLSTATE %s)", message, code)
+}
+
+// scramClient implements the client side of SCRAM-SHA-256 (RFC 7677) without
+// channel binding
+type scramClient struct {
+	password        string
+	nonce           string
+	clientFirstBare string
+	authMessage     string
+	saltedPassword  []byte
+}
+
+func newSCRAMClient(password string) *scramClient {
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	return &scramClient{password: password, nonce: base64.StdEncoding.EncodeToString(nonce)}
+}
+
+func (s *scramClient) clientFirst() string {
+	// The user name is taken from the startup message
+	s.clientFirstBare = "n=,r=" + s.nonce
+	return "n,," + s.clientFirstBare
+}
+
+func (s *scramClient) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	var iterations int
+	for _, attr := range strings.Split(serverFirst, ",") {
+		key, value, _ := strings.Cut(attr, "=")
+		switch key {
+		case "r":
+			nonce = value
+		case "s":
+			salt = value
+		case "i":
+			iterations, _ = strconv.Atoi(value)
+		}
+	}
+	if !strings.HasPrefix(nonce, s.nonce) || iterations <= 0 {
+		return "", errors.New("invalid SCRAM server challenge")
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM salt: %v", err)
+	}
+
+	s.saltedPassword, err = pbkdf2.Key(sha256.New, s.password, saltBytes, iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	clientKey := hmacSHA256(s.saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=biws,r=" + nonce
+	s.authMessage = s.clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	proof := hmacSHA256(storedKey[:], s.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verifyServer(serverFinal string) bool {
+	signature, ok := strings.CutPrefix(serverFinal, "v=")
+	if !ok {
+		return false
+	}
+	expected := hmacSHA256(hmacSHA256(s.saltedPassword, "Server Key"), s.authMessage)
+	return signature == base64.StdEncoding.EncodeToString(expected)
+}
+
+func hmacSHA256(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
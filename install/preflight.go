@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// preflightTimeout bounds all pre-flight checks together, a check still
+// running then fails as timed out
+const preflightTimeout = 20 * time.Second
+
+// minMemory is the memory below which the stack runs out of it under load
+const minMemory = 1 << 30
+
+// checkStatus is the outcome of a pre-flight check
+type checkStatus int
+
+const (
+	checkPassed checkStatus = iota
+	checkFailed
+	checkSkipped
+)
+
+// checkResult is what a pre-flight check found. A Blocking failure stops the
+// install before anything is changed, other failures are warnings.
+type checkResult struct {
+	Status   checkStatus
+	Detail   string
+	Blocking bool
+	// Findings are printed below the results table
+	Findings []dnsFinding
+}
+
+func passedCheck(format string, a ...any) checkResult {
+	return checkResult{Status: checkPassed, Detail: fmt.Sprintf(format, a...)}
+}
+
+func failedCheck(format string, a ...any) checkResult {
+	return checkResult{Status: checkFailed, Detail: fmt.Sprintf(format, a...)}
+}
+
+func skippedCheck(format string, a ...any) checkResult {
+	return checkResult{Status: checkSkipped, Detail: fmt.Sprintf(format, a...)}
+}
+
+// preflightCheck is a check of the host that runs before the install changes
+// anything. Run must return once ctx is done and must not prompt, checks run
+// concurrently.
+type preflightCheck interface {
+	Name() string
+	Run(ctx context.Context) checkResult
+}
+
+// checkFunc turns a function into a preflightCheck
+type checkFunc struct {
+	name string
+	run  func(ctx context.Context) checkResult
+}
+
+func (c checkFunc) Name() string                        { return c.name }
+func (c checkFunc) Run(ctx context.Context) checkResult { return c.run(ctx) }
+
+// preflightCheckNames are the checks --skip-check accepts
+var preflightCheckNames = []string{"ports", "disk", "memory", "runtime", "dns", "wireguard", "cgnat"}
+
+// skippedChecks are the names passed with --skip-check
+var skippedChecks []string
+
+func addSkipCheckFlag(fs *flag.FlagSet) {
+	fs.Func("skip-check", "Skip a pre-flight check by name (repeatable): "+strings.Join(preflightCheckNames, ", "), func(name string) error {
+		if !slices.Contains(preflightCheckNames, name) {
+			return fmt.Errorf("unknown check %q, expected one of %s", name, strings.Join(preflightCheckNames, ", "))
+		}
+		skippedChecks = append(skippedChecks, name)
+		return nil
+	})
+}
+
+// runChecks runs checks concurrently and returns their results in the same
+// order. A check that panics or is still running after timeout fails without
+// affecting the others.
+func runChecks(ctx context.Context, checks []preflightCheck, timeout time.Duration) []checkResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Buffered, so a check finishing after the timeout does not block forever
+	pending := make([]chan checkResult, len(checks))
+	for i, check := range checks {
+		pending[i] = make(chan checkResult, 1)
+		if slices.Contains(skippedChecks, check.Name()) {
+			pending[i] <- skippedCheck("skipped by --skip-check")
+			continue
+		}
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logf("ERROR", "pre-flight check %s panicked: %v\n%s", check.Name(), r, debug.Stack())
+					pending[i] <- failedCheck("the check crashed: %v", r)
+				}
+			}()
+			pending[i] <- check.Run(ctx)
+		}()
+	}
+
+	results := make([]checkResult, len(checks))
+	for i, ch := range pending {
+		select {
+		case results[i] = <-ch:
+		case <-ctx.Done():
+			select {
+			case results[i] = <-ch:
+			default:
+				results[i] = failedCheck("timed out after %s", timeout)
+			}
+		}
+	}
+	return results
+}
+
+// printCheckResults renders the results as a table of name, status and detail
+func printCheckResults(checks []preflightCheck, results []checkResult) {
+	width := 0
+	for _, check := range checks {
+		width = max(width, len(check.Name()))
+	}
+	for i, check := range checks {
+		result := results[i]
+		color, glyph := colors.Success, colors.SuccessGlyph
+		switch result.Status {
+		case checkFailed:
+			color, glyph = colors.Error, colors.ErrorGlyph
+		case checkSkipped:
+			color, glyph = colors.Muted, "–"
+		}
+		status := lipgloss.NewStyle().Foreground(color).Render(glyph)
+		infof("  %s %-*s  %s\n", status, width, check.Name(), result.Detail)
+		logf("INFO", "pre-flight check %s: %d %s", check.Name(), result.Status, result.Detail)
+		if result.Status == checkSkipped {
+			report.skip("pre-flight check " + check.Name() + " (" + result.Detail + ")")
+		}
+	}
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			if finding.Warning {
+				warnf("Warning: %s\n", finding.Message)
+			} else {
+				infoln(finding.Message)
+			}
+		}
+	}
+}
+
+// runPreflight checks the host against the answers before anything is
+// written and exits when a blocking check failed
+func runPreflight(config *Config, installDir string) {
+	infoln("\n=== Pre-flight Checks ===")
+	resolvePortConflicts(config)
+	dns := &dnsCheck{config: *config, ranges: make(chan cdnRanges, 1)}
+	checks := []preflightCheck{
+		checkFunc{"ports", func(context.Context) checkResult { return checkHostPorts(*config) }},
+		checkFunc{"disk", func(context.Context) checkResult { return checkDiskSpace(installDir) }},
+		checkFunc{"memory", func(context.Context) checkResult { return checkMemory() }},
+		checkFunc{"runtime", func(ctx context.Context) checkResult {
+			return checkContainerRuntime(ctx, config.InstallationContainerType)
+		}},
+		dns,
+	}
+	results := runChecks(context.Background(), checks, preflightTimeout)
+	printCheckResults(checks, results)
+
+	blocking := 0
+	for _, result := range results {
+		if result.Status == checkFailed && result.Blocking {
+			blocking++
+		}
+	}
+	if blocking > 0 {
+		exitf(exitPreflight, "Error: pre-flight checks failed, nothing was changed. Fix the problems above, or skip a check with --skip-check=<name>.\n")
+	}
+
+	// Offering a separate endpoint prompts, so it follows the table
+	if config.InstallGerbil && !config.ExternalProxy {
+		select {
+		case cdn := <-dns.ranges:
+			checkTunnelEndpoint(config, cdn)
+		default:
+		}
+	}
+}
+
+// checkHostPorts fails when a port the stack publishes is already bound
+func checkHostPorts(config Config) checkResult {
+	if !isRoot() {
+		return skippedCheck("requires root")
+	}
+	var busy, free []string
+	for _, port := range hostPorts(&config) {
+		if portInUse(port.Proto, port.Number) {
+			busy = append(busy, fmt.Sprintf("%s by %s", port, portOwner(port.Proto, port.Number)))
+		} else {
+			free = append(free, port.String())
+		}
+	}
+	if len(busy) > 0 {
+		result := failedCheck("in use: %s, stop the services on them (or a running Pangolin stack)", strings.Join(busy, ", "))
+		result.Blocking = true
+		return result
+	}
+	return passedCheck("%s free", strings.Join(free, ", "))
+}
+
+// checkDiskSpace fails when the filesystem of dir has less than
+// minInstallSpace free
+func checkDiskSpace(dir string) checkResult {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return skippedCheck("could not check %s: %v", dir, err)
+	}
+	free := stat.Bavail * uint64(stat.Bsize)
+	if free < minInstallSpace {
+		result := failedCheck("only %s free on %s, at least %s needed", formatGiB(free), dir, formatGiB(minInstallSpace))
+		result.Blocking = true
+		return result
+	}
+	return passedCheck("%s free on %s", formatGiB(free), dir)
+}
+
+// checkMemory warns when the host has less than minMemory
+func checkMemory() checkResult {
+	total, ok := memoryTotal()
+	if !ok {
+		return skippedCheck("cannot read /proc/meminfo")
+	}
+	if total < minMemory {
+		return failedCheck("%s of memory, Pangolin needs at least %s", formatGiB(total), formatGiB(minMemory))
+	}
+	return passedCheck("%s of memory", formatGiB(total))
+}
+
+// memoryTotal reads MemTotal from /proc/meminfo
+func memoryTotal() (uint64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kib, err := strconv.ParseUint(fields[1], 10, 64)
+			return kib << 10, err == nil
+		}
+	}
+	return 0, false
+}
+
+// checkContainerRuntime pings the Docker or Podman daemon the stack runs on,
+// or the one installed when none was chosen yet
+func checkContainerRuntime(ctx context.Context, containerType SupportedContainer) checkResult {
+	if containerType == "" || containerType == Undefined {
+		switch {
+		case isDockerInstalled():
+			containerType = Docker
+		case isPodmanInstalled():
+			containerType = Podman
+		default:
+			return skippedCheck("neither Docker nor Podman is installed yet")
+		}
+	}
+	switch {
+	case containerType == Docker && !isDockerInstalled():
+		return skippedCheck("Docker is not installed yet")
+	case containerType == Podman && !isPodmanInstalled():
+		return failedCheck("Podman is not installed")
+	}
+	format := "{{.ServerVersion}}"
+	if containerType == Podman {
+		format = "{{.Version.Version}}"
+	}
+	out, err := exec.CommandContext(ctx, string(containerType), "info", "--format", format).CombinedOutput()
+	if err != nil {
+		return failedCheck("%s does not respond: %s", containerType, firstLine(string(out), err))
+	}
+	if version := strings.TrimSpace(string(out)); version != "" {
+		return passedCheck("%s %s responds", containerType, version)
+	}
+	return passedCheck("%s responds", containerType)
+}
+
+// firstLine is the first line of out, or err when out is empty
+func firstLine(out string, err error) string {
+	if line, _, _ := strings.Cut(strings.TrimSpace(out), "\n"); line != "" {
+		return line
+	}
+	return err.Error()
+}
+
+// dnsCheck is the DNS pre-check of the dashboard, the additional domains and
+// the tunnel endpoint. The CDN ranges it loaded are sent on ranges for the
+// tunnel endpoint prompt.
+type dnsCheck struct {
+	config Config
+	ranges chan cdnRanges
+}
+
+func (c *dnsCheck) Name() string { return "dns" }
+
+func (c *dnsCheck) Run(ctx context.Context) checkResult {
+	if c.config.ExternalProxy {
+		return skippedCheck("handled by your existing reverse proxy")
+	}
+	if !network.allow("DNS pre-check") {
+		return skippedCheck("--offline")
+	}
+	cdn := loadCDNRanges(ctx, true)
+	c.ranges <- cdn
+
+	ctx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+	env := detectNetworkEnv(ctx, net.DefaultResolver)
+	env.Forwarded = !platform.PublicAddress
+	logf("INFO", "network: ipv4=%v ipv6=%v nat64=%v", env.IPv4, env.IPv6, env.NAT64Prefix)
+
+	// Resources are created below the additional domains, so their apex is
+	// what is checked
+	hosts := append([]string{c.config.DashboardDomain}, c.config.AdditionalDomains...)
+	var findings []dnsFinding
+	for _, host := range hosts {
+		findings = append(findings, checkDNS(ctx, net.DefaultResolver, env, host, c.config, cdn)...)
+	}
+	if c.config.InstallGerbil {
+		findings = append(findings, checkEndpointFamily(ctx, net.DefaultResolver, env, c.config)...)
+	}
+
+	warnings := 0
+	for _, finding := range findings {
+		if finding.Warning {
+			warnings++
+		}
+	}
+	var result checkResult
+	if warnings > 0 {
+		result = failedCheck("%d problems with the records of %s, see below", warnings, strings.Join(hosts, ", "))
+	} else {
+		result = passedCheck("%s resolve as expected", strings.Join(hosts, ", "))
+	}
+	result.Findings = findings
+	return result
+}
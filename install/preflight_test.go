@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunChecks runs fake checks that pass, fail, panic, skip and hang. A
+// check that panics or outlives the timeout fails on its own, the others keep
+// their results and their order.
+func TestRunChecks(t *testing.T) {
+	savedSkipped := skippedChecks
+	t.Cleanup(func() { skippedChecks = savedSkipped })
+	skippedChecks = []string{"skipped"}
+
+	// stuck ignores ctx, it only returns once the test is done
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	checks := []preflightCheck{
+		checkFunc{"passes", func(context.Context) checkResult { return passedCheck("fine") }},
+		checkFunc{"panics", func(context.Context) checkResult { panic("boom") }},
+		checkFunc{"fails", func(context.Context) checkResult {
+			return checkResult{Status: checkFailed, Detail: "broken", Blocking: true}
+		}},
+		checkFunc{"waits", func(ctx context.Context) checkResult {
+			<-ctx.Done()
+			return failedCheck("cancelled: %v", ctx.Err())
+		}},
+		checkFunc{"stuck", func(context.Context) checkResult {
+			<-release
+			return passedCheck("too late")
+		}},
+		checkFunc{"skipped", func(context.Context) checkResult {
+			t.Error("a skipped check ran")
+			return checkResult{}
+		}},
+		checkFunc{"slow", func(context.Context) checkResult {
+			time.Sleep(10 * time.Millisecond)
+			return passedCheck("slow but in time")
+		}},
+	}
+
+	const timeout = 200 * time.Millisecond
+	start := time.Now()
+	results := runChecks(context.Background(), checks, timeout)
+	if elapsed := time.Since(start); elapsed > 5*timeout {
+		t.Errorf("runChecks took %s with a timeout of %s", elapsed, timeout)
+	}
+
+	want := []struct {
+		status   checkStatus
+		detail   string
+		blocking bool
+	}{
+		{checkPassed, "fine", false},
+		{checkFailed, "the check crashed: boom", false},
+		{checkFailed, "broken", true},
+		// returns as the deadline passes, either detail is right
+		{checkFailed, "", false},
+		{checkFailed, "timed out after 200ms", false},
+		{checkSkipped, "skipped by --skip-check", false},
+		{checkPassed, "slow but in time", false},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results for %d checks", len(results), len(want))
+	}
+	for i, w := range want {
+		got := results[i]
+		if got.Status != w.status || !strings.Contains(got.Detail, w.detail) || got.Blocking != w.blocking {
+			t.Errorf("%s: got %+v, want status %d, detail %q, blocking %t", checks[i].Name(), got, w.status, w.detail, w.blocking)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// answerSource is where the value of a configuration answer came from
+type answerSource string
+
+const (
+	sourcePrompt  answerSource = "prompt"
+	sourceDefault answerSource = "default"
+	sourceFlag    answerSource = "flag"
+	sourceFile    answerSource = "answers file"
+	sourceEnv     answerSource = "environment"
+)
+
+// answerRecord is one resolved answer. Secret values are never stored.
+type answerRecord struct {
+	Key    string       `json:"key"`
+	Value  string       `json:"value,omitempty"`
+	Source answerSource `json:"source"`
+	// Origin names the flag, variable or answers file of the source
+	Origin string `json:"origin,omitempty"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+func (a answerRecord) String() string {
+	origin := cmp.Or(a.Origin, string(a.Source))
+	if a.Secret {
+		return fmt.Sprintf("%s = [hidden] (from %s)", a.Key, origin)
+	}
+	return fmt.Sprintf("%s = %s (from %s)", a.Key, a.Value, origin)
+}
+
+// answerOrigin names where an answer from source came from, e.g.
+// PANGOLIN_INSTALL_BASE_DOMAIN for the environment
+func answerOrigin(key string, source answerSource) string {
+	switch source {
+	case sourceFlag:
+		return "--" + promptFlagName(key)
+	case sourceEnv:
+		return answerEnvName(key)
+	case sourceFile:
+		return "answers file " + answersFile
+	}
+	return ""
+}
+
+// answers are the answers of this run in the order they were resolved. A key
+// answered twice (e.g. in a retry loop) keeps its last value.
+var answers struct {
+	mu      sync.Mutex
+	records []answerRecord
+}
+
+// recordAnswer notes the value and source of an answer, logAnswer calls it
+// for every prompt
+func recordAnswer(key, value string, source answerSource, secret bool) {
+	record := answerRecord{Key: key, Value: value, Source: source, Origin: answerOrigin(key, source), Secret: secret}
+	if secret {
+		record.Value = ""
+	}
+
+	answers.mu.Lock()
+	defer answers.mu.Unlock()
+	for i, existing := range answers.records {
+		if existing.Key == key {
+			answers.records = append(answers.records[:i], answers.records[i+1:]...)
+			break
+		}
+	}
+	answers.records = append(answers.records, record)
+}
+
+// recordFlagAnswer notes an answer supplied by a command line flag instead of
+// a prompt
+func recordFlagAnswer(key, value string) {
+	logf("INFO", "flag %s -> %s", key, value)
+	recordAnswer(key, value, sourceFlag, false)
+}
+
+func answerRecords() []answerRecord {
+	answers.mu.Lock()
+	defer answers.mu.Unlock()
+	return append([]answerRecord(nil), answers.records...)
+}
+
+// printAnswerProvenance lists every answer with its source, part of the
+// final summary with --verbose. Without it only the answers taken from the
+// environment are listed, a variable left over in the shell is easy to miss.
+func printAnswerProvenance() {
+	records := answerRecords()
+	if !isVerbose() {
+		records = slices.DeleteFunc(records, func(record answerRecord) bool { return record.Source != sourceEnv })
+	}
+	if len(records) == 0 {
+		return
+	}
+	if isVerbose() {
+		infoln("\n=== Answers ===")
+	} else {
+		infoln("\n=== Answers from the environment ===")
+	}
+	for _, record := range records {
+		infof("  %s\n", record)
+	}
+}
+
+// saveAnswerProvenance keeps the answers of this run in the state file of the
+// install directory in the current working directory, for config get. An
+// answer that took the installed value as its default keeps the source it
+// had when it was first given.
+func saveAnswerProvenance() {
+	state, err := loadInstallState(".")
+	if err != nil {
+		logf("WARN", "could not read %s: %v", installStateFile, err)
+		return
+	}
+	state.Answers = mergeAnswerRecords(state.Answers, answerRecords())
+	if err := state.save("."); err != nil {
+		logf("WARN", "could not write %s: %v", installStateFile, err)
+	}
+}
+
+// mergeAnswerRecords updates the installed records with those of this run
+func mergeAnswerRecords(installed, current []answerRecord) []answerRecord {
+	merged := slices.Clone(installed)
+	for _, record := range current {
+		i := slices.IndexFunc(merged, func(existing answerRecord) bool { return existing.Key == record.Key })
+		switch {
+		case i < 0:
+			merged = append(merged, record)
+		case record.Source == sourceDefault && record.Value == merged[i].Value && record.Secret == merged[i].Secret:
+			// the installed answer was offered again and kept
+		default:
+			merged[i] = record
+		}
+	}
+	return merged
+}
+
+// runConfig implements the config subcommand, config get prints the answers
+// an install was made with
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "get" {
+		exitf(exitInvalidInput, "Usage: %s config get [--dir <path>] [--provenance] [key...]\n", filepath.Base(os.Args[0]))
+	}
+	fs := flag.NewFlagSet("config get", flag.ExitOnError)
+	dirFlag := fs.String("dir", "", "Installation directory (default: the current directory or /opt/pangolin)")
+	provenance := fs.Bool("provenance", false, "Show where every answer came from: a prompt, its default, a flag, the environment or the answers file")
+	addTerminalFlags(fs)
+	fs.Parse(args[1:])
+	resolveTerminal()
+
+	dir := *dirFlag
+	if dir == "" {
+		var ok bool
+		if dir, ok = locateExistingInstall(); !ok {
+			fatalf("Error: no Pangolin installation found in the current directory or /opt/pangolin\n")
+		}
+	}
+	state, err := loadInstallState(dir)
+	if err != nil {
+		fatalf("Error reading %s: %v\n", installStateFile, err)
+	}
+	if len(state.Answers) == 0 {
+		fatalf("Error: %s records no answers, they are kept from the next installer run on\n", dir)
+	}
+	if err := printConfigAnswers(os.Stdout, state.Answers, fs.Args(), *provenance); err != nil {
+		exitf(exitInvalidInput, "Error: %v\n", err)
+	}
+}
+
+// printConfigAnswers prints the records with keys, all of them without keys,
+// one key = value line each. Secret values are never printed.
+func printConfigAnswers(w io.Writer, records []answerRecord, keys []string, provenance bool) error {
+	if len(keys) > 0 {
+		var selected []answerRecord
+		for _, key := range keys {
+			i := slices.IndexFunc(records, func(record answerRecord) bool { return record.Key == key })
+			if i < 0 {
+				return fmt.Errorf("the install records no answer for %s", key)
+			}
+			selected = append(selected, records[i])
+		}
+		records = selected
+	}
+	for _, record := range records {
+		switch {
+		case provenance:
+			fmt.Fprintln(w, record)
+		case record.Secret:
+			fmt.Fprintf(w, "%s = [hidden]\n", record.Key)
+		default:
+			fmt.Fprintf(w, "%s = %s\n", record.Key, record.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// resetAnswerRecords clears the answers recorded so far for one test
+func resetAnswerRecords(t *testing.T) {
+	t.Helper()
+	saved := answerRecords()
+	answers.records = nil
+	t.Cleanup(func() { answers.records = saved })
+}
+
+func TestAnswerProvenance(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T)
+		secret bool
+		want   answerRecord
+		line   string
+	}{
+		{"flag", func(t *testing.T) { promptAnswers["base_domain"] = "flag.example.com" }, false,
+			answerRecord{Key: "base_domain", Value: "flag.example.com", Source: sourceFlag, Origin: "--base-domain"},
+			"base_domain = flag.example.com (from --base-domain)"},
+		{"environment", func(t *testing.T) { t.Setenv("PANGOLIN_INSTALL_BASE_DOMAIN", "env.example.com") }, false,
+			answerRecord{Key: "base_domain", Value: "env.example.com", Source: sourceEnv, Origin: "PANGOLIN_INSTALL_BASE_DOMAIN"},
+			"base_domain = env.example.com (from PANGOLIN_INSTALL_BASE_DOMAIN)"},
+		{"answers file", func(t *testing.T) {
+			promptAnswers["base_domain"] = "file.example.com"
+			fileAnswers["base_domain"] = true
+			t.Cleanup(func() { delete(fileAnswers, "base_domain") })
+			answersFile, acceptDefaults = "answers.yml", true
+		}, false,
+			answerRecord{Key: "base_domain", Value: "file.example.com", Source: sourceFile, Origin: "answers file answers.yml"},
+			"base_domain = file.example.com (from answers file answers.yml)"},
+		{"flag wins over the environment", func(t *testing.T) {
+			promptAnswers["base_domain"] = "flag.example.com"
+			t.Setenv("PANGOLIN_INSTALL_BASE_DOMAIN", "env.example.com")
+		}, false,
+			answerRecord{Key: "base_domain", Value: "flag.example.com", Source: sourceFlag, Origin: "--base-domain"},
+			"base_domain = flag.example.com (from --base-domain)"},
+		{"default", func(t *testing.T) { acceptDefaults = true }, false,
+			answerRecord{Key: "base_domain", Value: "default.example.com", Source: sourceDefault},
+			"base_domain = default.example.com (from default)"},
+		{"secret", func(t *testing.T) { t.Setenv("PANGOLIN_INSTALL_BASE_DOMAIN", "secret.example.com") }, true,
+			answerRecord{Key: "base_domain", Source: sourceEnv, Origin: "PANGOLIN_INSTALL_BASE_DOMAIN", Secret: true},
+			"base_domain = [hidden] (from PANGOLIN_INSTALL_BASE_DOMAIN)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAnswers(t, nil)
+			resetAnswerRecords(t)
+			tt.setup(t)
+			var opts []fieldOption
+			if tt.secret {
+				opts = append(opts, withMaskedTranscript())
+			}
+			readValidated("base_domain", "Base domain", "default.example.com", nil, opts...)
+
+			records := answerRecords()
+			if len(records) != 1 {
+				t.Fatalf("recorded %d answers, want 1", len(records))
+			}
+			if records[0] != tt.want {
+				t.Errorf("recorded %+v, want %+v", records[0], tt.want)
+			}
+			if got := records[0].String(); got != tt.line {
+				t.Errorf("String() = %q, want %q", got, tt.line)
+			}
+		})
+	}
+}
+
+func TestMergeAnswerRecords(t *testing.T) {
+	installed := []answerRecord{
+		{Key: "base_domain", Value: "example.com", Source: sourceEnv, Origin: "PANGOLIN_INSTALL_BASE_DOMAIN"},
+		{Key: "http_mode", Value: "redirect", Source: sourceDefault},
+	}
+	current := []answerRecord{
+		{Key: "base_domain", Value: "example.com", Source: sourceDefault},
+		{Key: "http_mode", Value: "serve", Source: sourcePrompt},
+		{Key: "timezone", Value: "UTC", Source: sourceFlag, Origin: "--timezone"},
+	}
+	merged := mergeAnswerRecords(installed, current)
+	want := []answerRecord{installed[0], current[1], current[2]}
+	if len(merged) != len(want) {
+		t.Fatalf("merged %d records, want %d", len(merged), len(want))
+	}
+	for i := range want {
+		if merged[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, merged[i], want[i])
+		}
+	}
+}
+
+func TestPrintConfigAnswers(t *testing.T) {
+	records := []answerRecord{
+		{Key: "base_domain", Value: "example.com", Source: sourceEnv, Origin: "PANGOLIN_INSTALL_BASE_DOMAIN"},
+		{Key: "admin_password", Source: sourceFlag, Origin: "--admin-password", Secret: true},
+	}
+	tests := []struct {
+		name       string
+		keys       []string
+		provenance bool
+		want       string
+	}{
+		{"all", nil, false, "base_domain = example.com\nadmin_password = [hidden]\n"},
+		{"provenance", nil, true, "base_domain = example.com (from PANGOLIN_INSTALL_BASE_DOMAIN)\nadmin_password = [hidden] (from --admin-password)\n"},
+		{"one key", []string{"admin_password"}, true, "admin_password = [hidden] (from --admin-password)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := printConfigAnswers(&b, records, tt.keys, tt.provenance); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+	if err := printConfigAnswers(&strings.Builder{}, records, []string{"unknown"}, false); err == nil {
+		t.Error("an unknown key was accepted")
+	}
+}
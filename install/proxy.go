@@ -0,0 +1,239 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Install types
+const (
+	installTypeTraefik       = "traefik"
+	installTypeExistingProxy = "existing-proxy"
+)
+
+// defaultProxyBindAddress publishes Pangolin to a reverse proxy on this host
+const defaultProxyBindAddress = "127.0.0.1"
+
+// proxyExamplesDir holds the example reverse proxy configs, in the install
+// directory
+const proxyExamplesDir = "proxy-examples"
+
+// collectInstallType asks whether the bundled Traefik terminates TLS or an
+// existing reverse proxy on the host does
+func collectInstallType(config *Config) {
+	installType := readChoice("install_type", tr("prompt.install_type"), []string{installTypeTraefik, installTypeExistingProxy}, installTypeTraefik)
+	if installType != installTypeExistingProxy {
+		return
+	}
+	config.ExternalProxy = true
+	infoln("Traefik will not be installed. Your reverse proxy terminates TLS for the dashboard domain and forwards to Pangolin's ports.")
+	infoln("Resources that Pangolin proxies through tunnels need Traefik, so this mode only serves the dashboard and API.")
+
+	config.ProxyAPIPort = defaultPangolinPorts.External
+	config.ProxyDashboardPort = defaultPangolinPorts.Next
+	config.ProxyDashboardPort = readIntInRange("proxy_dashboard_port", tr("prompt.proxy_dashboard_port"), config.ProxyDashboardPort, 1, 65535)
+	config.ProxyAPIPort = readIntValidated("proxy_api_port", tr("prompt.proxy_api_port"), config.ProxyAPIPort, 1, 65535, func(port int) error {
+		return validateHostPorts(nil, config.ProxyDashboardPort, port)
+	})
+	config.ProxyBindAddress = readValidated("proxy_bind_address", tr("prompt.proxy_bind_address"), config.ProxyBind(), validateProxyBindAddress)
+	if !net.ParseIP(config.ProxyBindAddress).IsLoopback() {
+		warnf("Warning: the ports answer plain HTTP on %s, only let the reverse proxy reach them.\n", config.ProxyBindAddress)
+	}
+}
+
+// validateProxyBindAddress accepts the IPv4 address the ports are published
+// on: loopback for a proxy on this host, a private or the unspecified address
+// for one on another host
+func validateProxyBindAddress(s string) error {
+	if ip := net.ParseIP(s); ip == nil || ip.To4() == nil {
+		return errors.New("enter an IPv4 address, e.g. 127.0.0.1 for a proxy on this host or 0.0.0.0 for one on another host")
+	}
+	return nil
+}
+
+// ProxyBind is the address the dashboard and API ports are published on
+// behind an existing reverse proxy
+func (c Config) ProxyBind() string {
+	return cmp.Or(c.ProxyBindAddress, defaultProxyBindAddress)
+}
+
+// proxyUpstream is the address the example configs forward to, this host's
+// loopback when the ports are published on every address
+func (c Config) proxyUpstream() string {
+	if bind := c.ProxyBind(); bind != "0.0.0.0" {
+		return bind
+	}
+	return defaultProxyBindAddress
+}
+
+// printProxyExamples prints upstream configs for nginx and Caddy that forward
+// the dashboard domain to the published ports
+func printProxyExamples(config Config) {
+	infoln("\n=== Reverse Proxy Configuration ===")
+	infof("Point your reverse proxy at Pangolin. Example for nginx:\n\n%s\n", nginxExample(config))
+	infof("Example for Caddy:\n\n%s\n", caddyExample(config))
+	infof("The API under /api/v1 carries WebSockets: forward the Upgrade and Connection headers, and allow idle connections of an hour.\n")
+	infof("These examples and one for HAProxy are in %s/.\n", proxyExamplesDir)
+}
+
+// renderProxyExamples are the example configs written to proxyExamplesDir
+func renderProxyExamples(config Config) []renderedFile {
+	return []renderedFile{
+		{Path: proxyExamplesDir + "/nginx.conf", Content: []byte(nginxExample(config))},
+		{Path: proxyExamplesDir + "/Caddyfile", Content: []byte(caddyExample(config))},
+		{Path: proxyExamplesDir + "/haproxy.cfg", Content: []byte(haproxyExample(config))},
+	}
+}
+
+func nginxExample(config Config) string {
+	return fmt.Sprintf(`map $http_upgrade $connection_upgrade {
+    default upgrade;
+    ''      close;
+}
+
+server {
+    listen 443 ssl;
+    listen [::]:443 ssl;
+    http2 on;
+    server_name %[1]s;
+
+    ssl_certificate     /etc/letsencrypt/live/%[1]s/fullchain.pem;
+    ssl_certificate_key /etc/letsencrypt/live/%[1]s/privkey.pem;
+
+    proxy_set_header Host $host;
+    proxy_set_header X-Real-IP $remote_addr;
+    proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+    proxy_set_header X-Forwarded-Proto $scheme;
+
+    location /api/v1 {
+        proxy_pass http://%[4]s:%[2]d;
+        proxy_http_version 1.1;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $connection_upgrade;
+        proxy_read_timeout 1h;
+    }
+
+    location / {
+        proxy_pass http://%[4]s:%[3]d;
+    }
+}
+`, config.DashboardDomain, config.ProxyAPIPort, config.ProxyDashboardPort, config.proxyUpstream())
+}
+
+func caddyExample(config Config) string {
+	return fmt.Sprintf(`%s {
+    handle /api/v1* {
+        reverse_proxy %[4]s:%[2]d
+    }
+    handle {
+        reverse_proxy %[4]s:%[3]d
+    }
+}
+`, config.DashboardDomain, config.ProxyAPIPort, config.ProxyDashboardPort, config.proxyUpstream())
+}
+
+func haproxyExample(config Config) string {
+	return fmt.Sprintf(`frontend pangolin
+    bind :443 ssl crt /etc/haproxy/certs/%[1]s.pem alpn h2,http/1.1
+    mode http
+    option forwardfor
+    http-request set-header X-Forwarded-Proto https
+    acl pangolin_host hdr(host) -i %[1]s
+    acl pangolin_api path_beg /api/v1
+    use_backend pangolin_api if pangolin_host pangolin_api
+    use_backend pangolin_dashboard if pangolin_host
+
+backend pangolin_api
+    mode http
+    # WebSockets stay open while idle
+    timeout tunnel 1h
+    server pangolin %[4]s:%[2]d
+
+backend pangolin_dashboard
+    mode http
+    server pangolin %[4]s:%[3]d
+`, config.DashboardDomain, config.ProxyAPIPort, config.ProxyDashboardPort, config.proxyUpstream())
+}
+
+// installedBehindExistingProxy reports whether the installed stack runs
+// without the bundled Traefik
+func installedBehindExistingProxy() bool {
+	content, err := os.ReadFile("docker-compose.yml")
+	if err != nil {
+		return false
+	}
+	var compose struct {
+		Services map[string]any `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(content, &compose); err != nil {
+		return false
+	}
+	_, ok := compose.Services["traefik"]
+	return !ok
+}
+
+// installedProxyAPIPort returns the host port publishing Pangolin's API when
+// the stack runs behind an existing reverse proxy
+func installedProxyAPIPort(composePath string) (int, bool) {
+	return installedProxyPort(composePath, installedPangolinPorts("config/config.yml").External)
+}
+
+// installedProxyAPIAddress returns the address this host reaches Pangolin's
+// API on when the stack runs behind an existing reverse proxy
+func installedProxyAPIAddress(composePath string) (string, bool) {
+	port, bind, ok := installedProxyBinding(composePath, installedPangolinPorts("config/config.yml").External)
+	if !ok {
+		return "", false
+	}
+	config := Config{ProxyBindAddress: bind}
+	return net.JoinHostPort(config.proxyUpstream(), strconv.Itoa(port)), true
+}
+
+// installedProxyPort returns the host port publishing containerPort of the
+// pangolin service when the stack runs behind an existing reverse proxy
+func installedProxyPort(composePath string, containerPort int) (int, bool) {
+	port, _, ok := installedProxyBinding(composePath, containerPort)
+	return port, ok
+}
+
+// installedProxyBinding returns the host port and address publishing
+// containerPort of the pangolin service behind an existing reverse proxy
+func installedProxyBinding(composePath string, containerPort int) (int, string, bool) {
+	content, err := os.ReadFile(composePath)
+	if err != nil {
+		return 0, "", false
+	}
+	var compose struct {
+		Services map[string]struct {
+			Ports []string `yaml:"ports"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(content, &compose); err != nil {
+		return 0, "", false
+	}
+	if _, ok := compose.Services["traefik"]; ok {
+		return 0, "", false
+	}
+	for _, entry := range compose.Services["pangolin"].Ports {
+		parts := strings.Split(entry, ":")
+		if len(parts) == 3 && parts[2] == strconv.Itoa(containerPort) {
+			if port, err := strconv.Atoi(parts[1]); err == nil {
+				return port, parts[0], true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// isLoopbackBinding reports whether a compose port entry only listens on
+// localhost, such ports never need a firewall rule
+func isLoopbackBinding(entry string) bool {
+	return strings.HasPrefix(entry, "127.") || strings.HasPrefix(entry, "[::1]") || strings.HasPrefix(entry, "localhost:")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// publicIPService answers with the address a request comes from
+const publicIPService = "https://api.ipify.org"
+
+// publicIPServices are asked together, so neither an outage of one nor a
+// wrong answer decides the public address. The HTTP services are asked over
+// IPv4 and IPv6, the STUN servers answer with the mapped address of a UDP
+// socket, the one WireGuard traffic leaves through.
+var (
+	publicIPServices   = []string{publicIPService, "https://checkip.amazonaws.com", "https://icanhazip.com"}
+	publicIPv6Services = []string{"https://api6.ipify.org", "https://ipv6.icanhazip.com"}
+	stunServers        = []string{"stun.cloudflare.com:3478", "stun.l.google.com:19302"}
+)
+
+// publicAddresses are the addresses the internet sees this server as, nil
+// for a family it has no connectivity with
+type publicAddresses struct {
+	IPv4 net.IP
+	IPv6 net.IP
+}
+
+// detectPublicIP returns the public IPv4 address of this server, nil when
+// no service can be reached. It is a variable so tests can replace it.
+var detectPublicIP = func(ctx context.Context) net.IP {
+	return discoverPublicAddresses(ctx).IPv4
+}
+
+// discoverPublicAddresses asks every public IP service at once and takes the
+// address most of them agree on for each family
+func discoverPublicAddresses(ctx context.Context) publicAddresses {
+	ctx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	votes := map[string]int{}
+	ask := func(source string, lookup func() (net.IP, error)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ip, err := lookup()
+			if err != nil {
+				logf("INFO", "public IP from %s: %v", source, err)
+				return
+			}
+			logf("INFO", "public IP from %s: %s", source, ip)
+			mu.Lock()
+			votes[ip.String()]++
+			mu.Unlock()
+		}()
+	}
+	for _, url := range publicIPServices {
+		ask(url, func() (net.IP, error) { return httpPublicIP(ctx, "tcp4", url) })
+	}
+	for _, url := range publicIPv6Services {
+		ask(url, func() (net.IP, error) { return httpPublicIP(ctx, "tcp6", url) })
+	}
+	for _, server := range stunServers {
+		ask("stun:"+server, func() (net.IP, error) { return stunPublicIP(ctx, "udp4", server) })
+		ask("stun6:"+server, func() (net.IP, error) { return stunPublicIP(ctx, "udp6", server) })
+	}
+	wg.Wait()
+
+	var addresses publicAddresses
+	best := map[bool]int{}
+	for address, count := range votes {
+		ip := net.ParseIP(address)
+		v4 := ip.To4() != nil
+		if count <= best[v4] {
+			continue
+		}
+		best[v4] = count
+		if v4 {
+			addresses.IPv4 = ip.To4()
+		} else {
+			addresses.IPv6 = ip
+		}
+	}
+	if len(votes) > 2 {
+		logf("WARN", "the public IP services disagree: %v", votes)
+	}
+	return addresses
+}
+
+// httpPublicIP asks url for the address of a connection over network, tcp4
+// or tcp6
+func httpPublicIP(ctx context.Context, network, url string) (net.IP, error) {
+	client := &http.Client{Transport: logTransport(&http.Transport{
+		DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return dialContext(ctx, network, address)
+		},
+	})}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, errors.New("the answer is not an address")
+	}
+	return ip, nil
+}
+
+// STUN binding requests (RFC 5389)
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunMappedAddress   = 0x0001
+	stunXORMapped       = 0x0020
+)
+
+// stunPublicIP sends a binding request to server and returns the mapped
+// address of the answer
+func stunPublicIP(ctx context.Context, network, server string) (net.IP, error) {
+	conn, err := dialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(dnsCheckTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	rand.Read(request[8:20])
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	response := make([]byte, 1500)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, err
+	}
+	return parseSTUNResponse(response[:n], request[8:20])
+}
+
+// parseSTUNResponse reads the (XOR-)MAPPED-ADDRESS of a binding response to
+// the request with transaction
+func parseSTUNResponse(response, transaction []byte) (net.IP, error) {
+	if len(response) < 20 || binary.BigEndian.Uint16(response[0:]) != stunBindingResponse ||
+		binary.BigEndian.Uint32(response[4:]) != stunMagicCookie || !bytes.Equal(response[8:20], transaction) {
+		return nil, errors.New("not a STUN binding response")
+	}
+	attributes := response[20:min(len(response), 20+int(binary.BigEndian.Uint16(response[2:])))]
+	var mapped net.IP
+	for len(attributes) >= 4 {
+		kind, length := binary.BigEndian.Uint16(attributes[0:]), int(binary.BigEndian.Uint16(attributes[2:]))
+		if len(attributes) < 4+length {
+			break
+		}
+		value := attributes[4 : 4+length]
+		size := net.IPv4len
+		if length > 1 && value[1] == 2 {
+			size = net.IPv6len
+		}
+		if length >= 4+size {
+			address := append([]byte{}, value[4:4+size]...)
+			switch kind {
+			case stunXORMapped:
+				// The address is XORed with the cookie and, for IPv6, the
+				// transaction ID following it
+				key := response[4:20]
+				for i := range address {
+					address[i] ^= key[i]
+				}
+				return net.IP(address), nil
+			case stunMappedAddress:
+				mapped = net.IP(address)
+			}
+		}
+		padded := 4 + (length+3)&^3
+		if padded > len(attributes) {
+			break
+		}
+		attributes = attributes[padded:]
+	}
+	if mapped == nil {
+		return nil, errors.New("the response has no mapped address")
+	}
+	return mapped, nil
+}
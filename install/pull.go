@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	defaultParallelPulls = 3
+	// pullAttempts bounds the retries of a pull that failed transiently
+	pullAttempts = 3
+	// plainPullInterval is how often accessible, quiet and verbose mode
+	// print the pulls still running
+	plainPullInterval = 15 * time.Second
+)
+
+// parallelPulls is set by --parallel-pulls
+var parallelPulls = defaultParallelPulls
+
+// addParallelPullsFlag registers --parallel-pulls on fs
+func addParallelPullsFlag(fs *flag.FlagSet) {
+	fs.IntVar(&parallelPulls, "parallel-pulls", defaultParallelPulls, "How many container images to pull at the same time")
+}
+
+// transientPullError matches the output of pulls that may succeed when they
+// are tried again, a missing image or denied access fail right away
+var transientPullError = regexp.MustCompile(`(?i)timeout|timed out|connection reset|connection refused|temporary failure|unexpected eof|no such host|network is unreachable|too many requests|toomanyrequests|50[234] `)
+
+type pullState int
+
+const (
+	pullWaiting pullState = iota
+	pullRunning
+	pullDone
+	pullFailed
+	pullCancelled
+)
+
+// imagePull is the progress of the image of one compose service
+type imagePull struct {
+	service  string
+	state    pullState
+	attempt  int
+	started  time.Time
+	finished time.Time
+	// last is the last line the pull printed, e.g. a layer download
+	last string
+	err  error
+}
+
+func (p *imagePull) elapsed() time.Duration {
+	end := p.finished
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(p.started).Round(time.Second)
+}
+
+// pullProgress is shared by the workers and the display
+type pullProgress struct {
+	mu    sync.Mutex
+	pulls []*imagePull
+}
+
+func (p *pullProgress) update(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn()
+}
+
+// cancelWaiting marks the pulls a failure kept from starting as cancelled,
+// once the workers have stopped
+func (p *pullProgress) cancelWaiting() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pull := range p.pulls {
+		if pull.state == pullWaiting {
+			pull.state = pullCancelled
+		}
+	}
+}
+
+// lastLineWriter keeps the last line written to it as the status of a pull.
+// Compose redraws its progress with carriage returns, which end a line too.
+type lastLineWriter struct {
+	progress *pullProgress
+	pull     *imagePull
+	partial  []byte
+}
+
+func (w *lastLineWriter) Write(data []byte) (int, error) {
+	w.progress.mu.Lock()
+	defer w.progress.mu.Unlock()
+	w.partial = append(w.partial, data...)
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(w.partial[:i])); line != "" {
+			w.pull.last = line
+		}
+		w.partial = w.partial[i+1:]
+	}
+	return len(data), nil
+}
+
+// pullImages pulls the images of services with a pool of --parallel-pulls
+// workers. The first pull that fails for good cancels the others.
+func pullImages(ctx context.Context, containerType SupportedContainer, services []string) error {
+	workers := min(max(parallelPulls, 1), len(services))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progress := &pullProgress{}
+	for _, service := range services {
+		progress.pulls = append(progress.pulls, &imagePull{service: service})
+	}
+	logf("INFO", "pulling %d images, %d at a time", len(services), workers)
+	start := time.Now()
+
+	jobs := make(chan *imagePull)
+	go func() {
+		defer close(jobs)
+		for _, pull := range progress.pulls {
+			select {
+			case jobs <- pull:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var failure error
+	var failOnce sync.Once
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pull := range jobs {
+				if err := runImagePull(ctx, containerType, progress, pull); err != nil {
+					failOnce.Do(func() {
+						failure = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	title := fmt.Sprintf("Pulling %d images, %d at a time", len(services), workers)
+	if isAccessibleMode() || isQuiet() || isVerbose() {
+		showPlainPullProgress(title, progress, finished)
+	} else if showPullStatusLines(title, progress, finished) {
+		cancel()
+		<-finished
+		handleAbort(huh.ErrUserAborted)
+	}
+
+	progress.mu.Lock()
+	for _, pull := range progress.pulls {
+		switch pull.state {
+		case pullDone:
+			logf("INFO", "pull: %s done in %s", pull.service, pull.elapsed())
+		case pullFailed:
+			logf("ERROR", "pull: %s failed after %s: %v", pull.service, pull.elapsed(), pull.err)
+		case pullCancelled:
+			logf("INFO", "pull: %s cancelled", pull.service)
+		}
+	}
+	progress.mu.Unlock()
+	logf("INFO", "pulls finished in %s", time.Since(start).Round(time.Second))
+	return failure
+}
+
+// runImagePull pulls the image of one service and retries transient
+// failures, it returns an error when the pull failed for good
+func runImagePull(ctx context.Context, containerType SupportedContainer, progress *pullProgress, pull *imagePull) error {
+	progress.update(func() {
+		pull.state = pullRunning
+		pull.started = time.Now()
+	})
+	var err error
+	transient := false
+	for attempt := 1; attempt <= pullAttempts; attempt++ {
+		progress.update(func() { pull.attempt = attempt })
+		err = pullImage(ctx, containerType, progress, pull)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		progress.mu.Lock()
+		transient = transientPullError.MatchString(pull.last) || transientPullError.MatchString(err.Error())
+		progress.mu.Unlock()
+		if !transient || attempt == pullAttempts {
+			break
+		}
+		logf("WARN", "pull: %s failed, trying again: %v", pull.service, err)
+		select {
+		case <-time.After(time.Duration(attempt) * 2 * time.Second):
+		case <-ctx.Done():
+		}
+	}
+
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	pull.finished = time.Now()
+	switch {
+	case err == nil:
+		pull.state = pullDone
+	case ctx.Err() != nil:
+		pull.state = pullCancelled
+		return nil
+	default:
+		pull.state = pullFailed
+		if pull.last != "" {
+			err = fmt.Errorf("%v: %s", err, pull.last)
+		}
+		pull.err = err
+		err = fmt.Errorf("%s: %v", pull.service, err)
+		if transient {
+			return withExitCode(exitNetwork, err)
+		}
+		return withExitCode(exitContainers, err)
+	}
+	return nil
+}
+
+func pullImage(ctx context.Context, containerType SupportedContainer, progress *pullProgress, pull *imagePull) error {
+	args := []string{"-f", "docker-compose.yml", "pull"}
+	if containerType == Docker {
+		args = append(args, "--policy", "always")
+	}
+	cmd, err := composeCommand(ctx, containerType, append(args, pull.service)...)
+	if err != nil {
+		return err
+	}
+	// A cancelled pull must not wait for children that hold its output open
+	cmd.WaitDelay = 2 * time.Second
+	output := &lastLineWriter{progress: progress, pull: pull}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	return runCmd(cmd)
+}
+
+// status describes a pull as one line, without the glyph
+func (p *imagePull) status(width int) string {
+	name := fmt.Sprintf("%-*s", width, p.service)
+	switch p.state {
+	case pullWaiting:
+		return name + "  waiting"
+	case pullRunning:
+		status := fmt.Sprintf("%s  %s", name, p.elapsed())
+		if p.attempt > 1 {
+			status += fmt.Sprintf(" (attempt %d/%d)", p.attempt, pullAttempts)
+		}
+		if p.last != "" {
+			status += "  " + p.last
+		}
+		return status
+	case pullDone:
+		return fmt.Sprintf("%s  pulled in %s", name, p.elapsed())
+	case pullFailed:
+		return fmt.Sprintf("%s  failed after %s: %v", name, p.elapsed(), p.err)
+	default:
+		return name + "  cancelled"
+	}
+}
+
+func (p *pullProgress) nameWidth() int {
+	width := 0
+	for _, pull := range p.pulls {
+		width = max(width, len(pull.service))
+	}
+	return width
+}
+
+// showPlainPullProgress prints a line whenever a pull ends and lists the
+// pulls still running every plainPullInterval, for screen readers and logs
+func showPlainPullProgress(title string, progress *pullProgress, finished <-chan struct{}) {
+	infof("%s…\n", title)
+	reported := make(map[*imagePull]bool)
+	printFinished := func() {
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+		for _, pull := range progress.pulls {
+			if pull.state != pullWaiting && pull.state != pullRunning && !reported[pull] {
+				reported[pull] = true
+				infof("%s\n", pull.status(0))
+			}
+		}
+	}
+	ticker := time.NewTicker(plainPullInterval)
+	defer ticker.Stop()
+	poll := time.NewTicker(500 * time.Millisecond)
+	defer poll.Stop()
+	for {
+		select {
+		case <-finished:
+			progress.cancelWaiting()
+			printFinished()
+			return
+		case <-poll.C:
+			printFinished()
+		case <-ticker.C:
+			progress.mu.Lock()
+			var running []string
+			for _, pull := range progress.pulls {
+				if pull.state == pullRunning {
+					running = append(running, fmt.Sprintf("%s (%s)", pull.service, pull.elapsed()))
+				}
+			}
+			progress.mu.Unlock()
+			if len(running) > 0 {
+				infof("Still pulling: %s\n", strings.Join(running, ", "))
+			}
+		}
+	}
+}
+
+type pullsDoneMsg struct{}
+
+// pullModel redraws one status line per image while the pulls run
+type pullModel struct {
+	spinner  spinner.Model
+	title    string
+	progress *pullProgress
+	done     bool
+	aborted  bool
+}
+
+func (m pullModel) Init() tea.Cmd {
+	return m.spinner.Tick
+}
+
+func (m pullModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			m.aborted = true
+			return m, tea.Quit
+		}
+	case pullsDoneMsg:
+		m.done = true
+		return m, tea.Quit
+	}
+
+	var cmd tea.Cmd
+	m.spinner, cmd = m.spinner.Update(msg)
+	return m, cmd
+}
+
+func (m pullModel) View() string {
+	if m.done || m.aborted {
+		return ""
+	}
+	m.progress.mu.Lock()
+	defer m.progress.mu.Unlock()
+	width := m.progress.nameWidth()
+	lines := []string{fmt.Sprintf("%s %s…", m.spinner.View(), m.title)}
+	for _, pull := range m.progress.pulls {
+		lines = append(lines, "  "+pullLine(pull, width, m.spinner.View()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pullLine renders a pull with the glyph of its state
+func pullLine(pull *imagePull, width int, running string) string {
+	switch pull.state {
+	case pullDone:
+		return stepResult(pull.status(width), nil)
+	case pullFailed, pullCancelled:
+		return stepResult(pull.status(width), errors.New(pull.status(width)))
+	case pullRunning:
+		return running + " " + pull.status(width)
+	default:
+		return lipgloss.NewStyle().Faint(true).Render("  " + pull.status(width))
+	}
+}
+
+// showPullStatusLines shows the pulls as status lines until they finish and
+// leaves the final state of each image on the terminal. It reports whether
+// the user pressed Ctrl+C.
+func showPullStatusLines(title string, progress *pullProgress, finished <-chan struct{}) bool {
+	model := pullModel{
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(lipgloss.NewStyle().Foreground(colors.Primary)),
+		),
+		title:    title,
+		progress: progress,
+	}
+	program := tea.NewProgram(model, tea.WithOutput(consoleOut), tea.WithoutSignalHandler())
+	go func() {
+		<-finished
+		program.Send(pullsDoneMsg{})
+	}()
+	final, err := program.Run()
+	if err != nil {
+		logf("WARN", "pull status failed: %v", err)
+	}
+	if m, ok := final.(pullModel); ok && m.aborted {
+		return true
+	}
+
+	progress.cancelWaiting()
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	width := progress.nameWidth()
+	for _, pull := range progress.pulls {
+		fmt.Fprintln(consoleOut, pullLine(pull, width, ""))
+	}
+	return false
+}
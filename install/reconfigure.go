@@ -0,0 +1,641 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// reconfiguring is set while the questions of an install are asked again
+	// for an existing one
+	reconfiguring bool
+	// installedSettings is the configuration of the existing install while
+	// reconfiguring. Lists such as the extra domains start from it.
+	installedSettings Config
+	// installedAnswers are the answers the installed files stand for, by
+	// prompt key. They replace the defaults of the prompts while
+	// reconfiguring.
+	installedAnswers map[string]string
+)
+
+// installedDefault returns the old answer to the prompt with key when going
+// back to it or while reconfiguring, defaultValue otherwise
+func installedDefault(key, defaultValue string) string {
+	if value, ok := previousAnswer(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// previousAnswer is the old answer of the question gone back to in the
+// wizard, else the answer of the existing install
+func previousAnswer(key string) (string, bool) {
+	if value, ok := session.previous(key); ok {
+		return value, true
+	}
+	value, ok := installedAnswers[key]
+	return value, ok
+}
+
+// installedBoolDefault is installedDefault for a yes/no prompt
+func installedBoolDefault(key string, defaultValue bool) bool {
+	if value, err := parseBool(installedDefault(key, strconv.FormatBool(defaultValue))); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// offerReconfigure asks the questions of an install again for the existing
+// one in the current directory, with its current values as the defaults. Only
+// the files the changed answers affect are rewritten, and only the parts of
+// them the answers change, so hand edits survive. It reports whether the
+// install was installedSettings.
+func offerReconfigure(installDir string) bool {
+	if !readBool("reconfigure", tr("prompt.reconfigure"), false) {
+		return false
+	}
+	installed, err := installedConfig(installDir)
+	if err != nil {
+		fatalf("Error reading the installed configuration: %v\n", err)
+	}
+
+	installedAnswers = installedAnswerValues(installed)
+	reconfiguring, installedSettings = true, installed
+	config := collectUserInput()
+	reconfiguring, installedSettings, installedAnswers = false, Config{}, nil
+
+	// What the questions do not cover stays as installed
+	config.InstallationContainerType = installed.InstallationContainerType
+	config.PangolinVersion, config.GerbilVersion, config.BadgerVersion = installed.PangolinVersion, installed.GerbilVersion, installed.BadgerVersion
+	config.Secret = installed.Secret
+	config.InstallDir, config.DataDir = installed.InstallDir, installed.DataDir
+
+	_, before, err := renderConfigFiles(installed)
+	if err != nil {
+		fatalf("Error rendering the installed configuration: %v\n", err)
+	}
+	dirs, after, err := renderConfigFiles(config)
+	if err != nil {
+		fatalf("Error creating config files: %v\n", err)
+	}
+	files := reconcileRenderedFiles(before, after)
+	if len(files) == 0 {
+		infoln("\nThe answers did not change any file.")
+		return true
+	}
+	if err := writeRenderedFiles(dirs, files); err != nil {
+		fatalf("Error writing config files: %v\n", err)
+	}
+	recordGeneratedFiles(generatedManifest(after))
+
+	containerType := installed.InstallationContainerType
+	if containerType == Undefined {
+		infoln("Recreate the containers with compose up -d to apply the changes.")
+		return true
+	}
+	if readBool("reconfigure_restart", tr("prompt.reconfigure_restart"), true) {
+		if err := startContainers(containerType); err != nil {
+			exitf(exitContainers, "Error: %v\n", err)
+		}
+		if err := waitForStackHealthy(containerType, config.DashboardDomain); err != nil {
+			exitf(exitCodeOf(err, exitContainers), "Error: %v\n", err)
+		}
+	} else {
+		report.skip("container restart after reconfiguring (declined)")
+	}
+	return true
+}
+
+// reconcileRenderedFiles returns the files to write for a reconfigure. before
+// is what the installed answers render, after what the new answers render.
+// Files the answers do not change are left alone. The others are merged into
+// the file on disk: the keys the answers change are updated, everything else
+// is kept, including keys and comments added by hand. A file that cannot be
+// merged is offered for overwriting like on any re-run.
+func reconcileRenderedFiles(before, after []renderedFile) []renderedFile {
+	previous := map[string][]byte{}
+	for _, file := range before {
+		previous[file.Path] = file.Content
+	}
+
+	var files []renderedFile
+	for _, file := range after {
+		old, rendered := previous[file.Path]
+		if rendered && bytes.Equal(old, file.Content) {
+			continue
+		}
+		file.Path = installedPath(file.Path)
+		current, err := os.ReadFile(file.Path)
+		switch {
+		case err != nil || bytes.Equal(current, old):
+			infof("Writing %s\n", file.Path)
+			files = append(files, file)
+			continue
+		case bytes.Equal(current, file.Content):
+			continue
+		}
+
+		if rendered && isYAMLFile(file.Path) {
+			merged, conflicts, err := mergeYAMLChanges(old, file.Content, current)
+			if err == nil && len(conflicts) == 0 {
+				infof("Updating %s, keeping the changes made by hand\n", file.Path)
+				file.Content = merged
+				files = append(files, file)
+				continue
+			}
+			if err != nil {
+				conflicts = []string{err.Error()}
+			}
+			warnf("\n%s cannot be updated in place:\n", file.Path)
+			for _, conflict := range conflicts {
+				warnf("  %s\n", conflict)
+			}
+		}
+		files = append(files, resolveOverwrites([]renderedFile{file})...)
+	}
+	for _, file := range before {
+		if !writesFile(after, file.Path) && pathExists(installedPath(file.Path)) {
+			infof("%s is no longer used with these answers, remove it by hand once nothing needs it.\n", installedPath(file.Path))
+		}
+	}
+	return files
+}
+
+func isYAMLFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// mergeYAMLChanges applies the changes from base to target onto current,
+// which is base with edits made by hand. Mappings are merged by key, lists of
+// values by item, anything else is replaced whole. A change to a value that
+// was also edited by hand is a conflict, described in the returned list.
+func mergeYAMLChanges(base, target, current []byte) ([]byte, []string, error) {
+	var baseDoc, targetDoc, currentDoc yaml.Node
+	for _, doc := range []struct {
+		node    *yaml.Node
+		content []byte
+	}{{&baseDoc, base}, {&targetDoc, target}, {&currentDoc, current}} {
+		if err := yaml.Unmarshal(doc.content, doc.node); err != nil {
+			return nil, nil, err
+		}
+		if len(doc.node.Content) == 0 {
+			return nil, nil, errors.New("the file is empty")
+		}
+	}
+	var conflicts []string
+	mergeYAMLNode(nil, baseDoc.Content[0], targetDoc.Content[0], currentDoc.Content[0], &conflicts)
+	if len(conflicts) > 0 {
+		return nil, conflicts, nil
+	}
+
+	merged, err := MarshalYAMLWithIndent(&currentDoc, detectIndent(current))
+	return merged, nil, err
+}
+
+// mergeYAMLNode updates current in place with the changes from base to target
+func mergeYAMLNode(path []string, base, target, current *yaml.Node, conflicts *[]string) {
+	if yamlEqual(base, target) || yamlEqual(current, target) {
+		return
+	}
+	at := strings.Join(path, ".")
+	switch {
+	case base.Kind == yaml.MappingNode && target.Kind == yaml.MappingNode && current.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(target.Content); i += 2 {
+			key, value := target.Content[i].Value, target.Content[i+1]
+			keyPath := append(slices.Clone(path), key)
+			baseValue, currentValue := yamlMapValue(base, key), yamlMapValue(current, key)
+			switch {
+			case baseValue != nil && currentValue != nil:
+				mergeYAMLNode(keyPath, baseValue, value, currentValue, conflicts)
+			case baseValue == nil && currentValue == nil:
+				current.Content = append(current.Content, target.Content[i], value)
+			case baseValue == nil && !yamlEqual(currentValue, value):
+				*conflicts = append(*conflicts, fmt.Sprintf("%s was added by hand with a different value", strings.Join(keyPath, ".")))
+			case baseValue != nil && currentValue == nil:
+				*conflicts = append(*conflicts, fmt.Sprintf("%s was removed by hand, the new answers change it", strings.Join(keyPath, ".")))
+			}
+		}
+		for i := 0; i+1 < len(base.Content); i += 2 {
+			key := base.Content[i].Value
+			if yamlMapValue(target, key) != nil {
+				continue
+			}
+			currentValue := yamlMapValue(current, key)
+			if currentValue == nil {
+				continue
+			}
+			if !yamlEqual(currentValue, base.Content[i+1]) {
+				*conflicts = append(*conflicts, fmt.Sprintf("%s was edited by hand, the new answers remove it", strings.Join(append(slices.Clone(path), key), ".")))
+				continue
+			}
+			yamlDeleteKey(current, key)
+		}
+	case base.Kind == yaml.SequenceNode && target.Kind == yaml.SequenceNode && current.Kind == yaml.SequenceNode && yamlScalarList(base) && yamlScalarList(target):
+		var removed, added []*yaml.Node
+		for _, item := range base.Content {
+			if !slices.ContainsFunc(target.Content, func(n *yaml.Node) bool { return yamlEqual(n, item) }) {
+				removed = append(removed, item)
+			}
+		}
+		for _, item := range target.Content {
+			if !slices.ContainsFunc(base.Content, func(n *yaml.Node) bool { return yamlEqual(n, item) }) {
+				added = append(added, item)
+			}
+		}
+		// A replaced item keeps its position, e.g. a port mapping
+		for _, item := range removed {
+			i := slices.IndexFunc(current.Content, func(n *yaml.Node) bool { return yamlEqual(n, item) })
+			switch {
+			case i == -1:
+				continue
+			case len(added) > 0:
+				setYAMLValue(current.Content[i], added[0])
+				added = added[1:]
+			default:
+				current.Content = slices.Delete(current.Content, i, i+1)
+			}
+		}
+		for _, item := range added {
+			if !slices.ContainsFunc(current.Content, func(n *yaml.Node) bool { return yamlEqual(n, item) }) {
+				current.Content = append(current.Content, item)
+			}
+		}
+	case yamlEqual(current, base):
+		setYAMLValue(current, target)
+	default:
+		*conflicts = append(*conflicts, fmt.Sprintf("%s was edited by hand, the new answers change it", orUnknown(at)))
+	}
+}
+
+// setYAMLValue replaces the value of node with that of value, keeping the
+// comments of node
+func setYAMLValue(node, value *yaml.Node) {
+	head, line, foot := node.HeadComment, node.LineComment, node.FootComment
+	*node = *value
+	node.HeadComment, node.LineComment, node.FootComment = head, line, foot
+}
+
+func yamlScalarList(node *yaml.Node) bool {
+	for _, item := range node.Content {
+		if item.Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
+
+// yamlEqual compares the values of two nodes, ignoring comments and style.
+// The order of mapping keys does not matter.
+func yamlEqual(a, b *yaml.Node) bool {
+	if a.Kind == yaml.AliasNode {
+		return yamlEqual(a.Alias, b)
+	}
+	if b.Kind == yaml.AliasNode {
+		return yamlEqual(a, b.Alias)
+	}
+	if a.Kind != b.Kind || len(a.Content) != len(b.Content) {
+		return false
+	}
+	switch a.Kind {
+	case yaml.ScalarNode:
+		return a.Value == b.Value && a.ShortTag() == b.ShortTag()
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(a.Content); i += 2 {
+			other := yamlMapValue(b, a.Content[i].Value)
+			if other == nil || !yamlEqual(a.Content[i+1], other) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := range a.Content {
+		if !yamlEqual(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// installedConfig reads the answers an install in the current directory was
+// made with from its files. Values that cannot be read keep their zero value,
+// they render the same before and after a reconfigure and so change nothing.
+func installedConfig(installDir string) (Config, error) {
+	config := Config{InstallDir: installDir, InstallationContainerType: detectContainerType()}
+	if state, err := loadInstallState("."); err == nil && state.DataDir != "" {
+		config.DataDir = state.DataDir
+	}
+
+	data, err := os.ReadFile("config/config.yml")
+	if err != nil {
+		return config, err
+	}
+	var app struct {
+		App struct {
+			DashboardURL string `yaml:"dashboard_url"`
+			Telemetry    struct {
+				AnonymousUsage bool `yaml:"anonymous_usage"`
+			} `yaml:"telemetry"`
+		} `yaml:"app"`
+		Domains yaml.Node `yaml:"domains"`
+		Gerbil  struct {
+			BaseEndpoint string `yaml:"base_endpoint"`
+		} `yaml:"gerbil"`
+		Server struct {
+			Secret        string `yaml:"secret"`
+			MaxMindDBPath string `yaml:"maxmind_db_path"`
+		} `yaml:"server"`
+		Email *struct {
+			SMTPHost   string `yaml:"smtp_host"`
+			SMTPPort   int    `yaml:"smtp_port"`
+			SMTPUser   string `yaml:"smtp_user"`
+			SMTPPass   string `yaml:"smtp_pass"`
+			SMTPSecure bool   `yaml:"smtp_secure"`
+			NoReply    string `yaml:"no_reply"`
+		} `yaml:"email"`
+		Postgres struct {
+			ConnectionString string `yaml:"connection_string"`
+		} `yaml:"postgres"`
+		IdentityProviders []struct {
+			Name             string `yaml:"name"`
+			Issuer           string `yaml:"issuer"`
+			ClientID         string `yaml:"client_id"`
+			ClientSecret     string `yaml:"client_secret"`
+			AuthorizationURL string `yaml:"authorization_url"`
+			TokenURL         string `yaml:"token_url"`
+			Scopes           string `yaml:"scopes"`
+		} `yaml:"identity_providers"`
+	}
+	if err := yaml.Unmarshal(data, &app); err != nil {
+		return config, fmt.Errorf("error parsing config/config.yml: %v", err)
+	}
+	if u, err := url.Parse(app.App.DashboardURL); err == nil {
+		config.DashboardDomain = u.Hostname()
+	}
+	config.Telemetry = app.App.Telemetry.AnonymousUsage
+	// The secrets of config.yml are ${VAR} references to .env, older installs
+	// have them inline
+	env, _ := readEnvFile()
+	config.Secret = app.Server.Secret
+	if config.Secret == "" {
+		config.Secret = env[envServerSecret]
+	}
+	config.EnableMaxMind = app.Server.MaxMindDBPath != ""
+	for i := 0; i+1 < len(app.Domains.Content); i += 2 {
+		domain := app.Domains.Content[i+1]
+		baseDomain := yamlMapValue(domain, "base_domain")
+		if baseDomain == nil {
+			continue
+		}
+		wildcard := yamlMapValue(domain, "prefer_wildcard_cert") != nil && yamlMapValue(domain, "prefer_wildcard_cert").Value == "true"
+		switch {
+		case config.BaseDomain == "":
+			config.BaseDomain = baseDomain.Value
+			if wildcard {
+				config.WildcardDomain = baseDomain.Value
+			}
+		case wildcard && config.WildcardDomain == "":
+			config.WildcardDomain = baseDomain.Value
+		default:
+			config.AdditionalDomains = append(config.AdditionalDomains, baseDomain.Value)
+		}
+	}
+	if app.Email != nil {
+		config.EnableEmail = true
+		config.EmailSMTPHost, config.EmailSMTPPort = app.Email.SMTPHost, app.Email.SMTPPort
+		config.EmailSMTPUser, config.EmailSMTPPass = app.Email.SMTPUser, resolveEnvReference(app.Email.SMTPPass, env)
+		config.EmailNoReply = app.Email.NoReply
+		config.EmailSMTPSecurity = smtpSTARTTLS
+		if app.Email.SMTPSecure {
+			config.EmailSMTPSecurity = smtpTLS
+		}
+	}
+	if len(app.IdentityProviders) > 0 {
+		idp := app.IdentityProviders[0]
+		config.OIDC = &OIDCProvider{Name: idp.Name, Issuer: idp.Issuer, ClientID: idp.ClientID, ClientSecret: resolveEnvReference(idp.ClientSecret, env),
+			Scopes: idp.Scopes, AuthorizationURL: idp.AuthorizationURL, TokenURL: idp.TokenURL}
+	}
+	if connection := resolveEnvReference(app.Postgres.ConnectionString, env); connection != "" {
+		readInstalledPostgreSQL(&config, connection)
+	}
+	config.WireGuardPort = installedWireGuardPort("config/config.yml")
+	config.PangolinPorts = installedPangolinPorts("config/config.yml")
+
+	doc, err := readComposeDocument()
+	if err != nil {
+		return config, err
+	}
+	services := yamlMapValue(doc.Content[0], "services")
+	if image := yamlMapValue(yamlMapValue(services, "pangolin"), "image"); image != nil {
+		_, _, tag := parseImageRef(image.Value)
+		tag, config.IsEnterprise = strings.CutPrefix(tag, "ee-")
+		tag, postgres := strings.CutPrefix(tag, "postgresql-")
+		config.IsPostgreSQL = config.IsPostgreSQL || postgres
+		config.PangolinVersion = tag
+	}
+	if gerbil := yamlMapValue(services, "gerbil"); gerbil != nil {
+		config.InstallGerbil = true
+		if image := yamlMapValue(gerbil, "image"); image != nil {
+			_, _, config.GerbilVersion = parseImageRef(image.Value)
+		}
+	}
+	config.IsRedis = yamlMapValue(services, "redis") != nil
+	if command := yamlMapValue(yamlMapValue(services, "watchtower"), "command"); command != nil {
+		config.AutoUpdate = updateWatchtower
+		for i, arg := range command.Content {
+			if arg.Value == "--schedule" && i+1 < len(command.Content) {
+				config.UpdateSchedule = strings.TrimPrefix(command.Content[i+1].Value, "0 ")
+			}
+		}
+	}
+	if tz := yamlMapValue(yamlMapValue(yamlMapValue(services, "pangolin"), "environment"), "TZ"); tz != nil {
+		config.Timezone = tz.Value
+	}
+	config.LogMaxSize, config.LogMaxFile = installedLogRotation("docker-compose.yml")
+	config.DockerSecrets = pathExists(secretsDir)
+	if config.IsPostgreSQLPass == "" {
+		config.IsPostgreSQLPass = env[envPostgresPassword]
+	}
+	config.IsRedisPass = env[envRedisPassword]
+	if config.InstallGerbil && app.Gerbil.BaseEndpoint != "" && app.Gerbil.BaseEndpoint != config.DashboardDomain {
+		config.GerbilEndpoint = app.Gerbil.BaseEndpoint
+	}
+
+	config.ExternalProxy = installedBehindExistingProxy()
+	if config.ExternalProxy {
+		config.ProxyDashboardPort, config.ProxyAPIPort = installedProxyPorts("docker-compose.yml")
+		_, config.ProxyBindAddress, _ = installedProxyBinding("docker-compose.yml", installedPangolinPorts("config/config.yml").Next)
+		return config, nil
+	}
+	traefik, err := ReadTraefikConfig("config/traefik/traefik_config.yml")
+	if err != nil {
+		return config, err
+	}
+	config.LetsEncryptEmail, config.BadgerVersion = traefik.LetsEncryptEmail, traefik.BadgerVersion
+	config.HTTPPort, config.HTTPSPort, config.HTTPMode = traefik.HTTPPort, traefik.HTTPSPort, traefik.HTTPMode
+	config.ACMEChallenge, config.ACMEStaging = traefik.ACMEChallenge, traefik.ACMEStaging
+	config.DNSProvider, config.DNSCredentials = traefik.DNSProvider, installedDNSCredentials()
+	if config.OwnCertificates = installedOwnCertificates(); len(config.OwnCertificates) > 0 {
+		config.CertificateSource = certificateOwn
+	}
+	config.EnableIPv6 = traefik.EnableIPv6
+	config.GeoBlockCountries, config.GeoBlockEverything = installedGeoBlock()
+	config.RateLimitAverage, config.RateLimitBurst, config.HardenedHTTP = installedHardening()
+	if passthroughs, err := installedPassthroughs("config/traefik/dynamic_config.yml"); err == nil {
+		config.TLSPassthroughs = passthroughs
+	}
+	return config, nil
+}
+
+// readInstalledPostgreSQL fills the PostgreSQL settings of config from the
+// connection string of config.yml
+func readInstalledPostgreSQL(config *Config, connection string) {
+	config.IsPostgreSQL = true
+	u, err := url.Parse(connection)
+	if err != nil {
+		return
+	}
+	config.IsPostgreSQLPass, _ = u.User.Password()
+	if u.Host == "postgres:5432" {
+		return
+	}
+	config.ExternalPostgreSQL = true
+	config.PostgreSQLUser = u.User.Username()
+	config.PostgreSQLDatabase = strings.TrimPrefix(u.Path, "/")
+	config.PostgreSQLTLS = u.Query().Get("sslmode") == "require"
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		config.PostgreSQLHost = u.Host
+		return
+	}
+	config.PostgreSQLHost = host
+	config.PostgreSQLPort, _ = strconv.Atoi(port)
+}
+
+// installedAnswerValues are the answers that reproduce config, by prompt key.
+// Prompts that only matter for a fresh install, or that send something, are
+// declined by default.
+func installedAnswerValues(config Config) map[string]string {
+	answers := map[string]string{
+		"edit_files":         "false",
+		"smtp_test":          "false",
+		"dns_provider_check": "false",
+		"external_check":     "false",
+	}
+	set := func(key, value string) {
+		if value != "" {
+			answers[key] = value
+		}
+	}
+	setInt := func(key string, value int) {
+		if value != 0 {
+			answers[key] = strconv.Itoa(value)
+		}
+	}
+	setBool := func(key string, value bool) {
+		answers[key] = strconv.FormatBool(value)
+	}
+
+	setBool("enterprise", config.IsEnterprise)
+	set("redis_password", config.IsRedisPass)
+	setBool("postgresql", config.IsPostgreSQL)
+	setBool("postgresql_external", config.ExternalPostgreSQL)
+	set("postgresql_password", config.IsPostgreSQLPass)
+	set("postgresql_host", config.PostgreSQLHost)
+	setInt("postgresql_port", config.PostgreSQLPort)
+	set("postgresql_database", config.PostgreSQLDatabase)
+	set("postgresql_user", config.PostgreSQLUser)
+
+	set("base_domain", config.BaseDomain)
+	set("dashboard_domain", config.DashboardDomain)
+	set("install_type", installTypeTraefik)
+	if config.ExternalProxy {
+		set("install_type", installTypeExistingProxy)
+	}
+	setInt("proxy_dashboard_port", config.ProxyDashboardPort)
+	setInt("proxy_api_port", config.ProxyAPIPort)
+	set("proxy_bind_address", config.ProxyBindAddress)
+	setBool("wildcard_cert", config.WildcardDomain != "")
+	set("wildcard_domain", config.WildcardDomain)
+	set("certificate_source", cmp.Or(config.CertificateSource, certificateLetsEncrypt))
+	if config.OwnCertificate() {
+		if abs, err := filepath.Abs(ownCertDir); err == nil {
+			set("certificate_path", abs)
+		}
+	}
+	set("letsencrypt_email", config.LetsEncryptEmail)
+
+	setBool("install_gerbil", config.InstallGerbil)
+	setInt("wireguard_port", config.WireGuardPort)
+	setBool("separate_endpoint", config.GerbilEndpoint != "")
+	set("gerbil_endpoint", config.GerbilEndpoint)
+	if config.WireGuardPort != 0 {
+		set("public_endpoint", net.JoinHostPort(cmp.Or(config.GerbilEndpoint, config.DashboardDomain), strconv.Itoa(config.WireGuardPort)))
+	}
+	set("http_mode", config.HTTPMode)
+	setBool("custom_ports", (config.HTTPPort != 0 && config.HTTPPort != defaultHTTPPort) || (config.HTTPSPort != 0 && config.HTTPSPort != defaultHTTPSPort))
+	setInt("http_port", config.HTTPPort)
+	setInt("https_port", config.HTTPSPort)
+	set("acme_challenge", config.ACMEChallenge)
+	setBool("acme_staging", config.ACMEStaging)
+	set("dns_provider", config.DNSProvider)
+	for env, value := range config.DNSCredentials {
+		set(strings.ToLower(env), value)
+	}
+	setBool("enable_ipv6", config.EnableIPv6)
+	set("timezone", config.Timezone)
+	setBool("custom_server_ports", config.PangolinPorts.Custom())
+	ports := config.ServerPorts()
+	setInt("server_external_port", ports.External)
+	setInt("server_internal_port", ports.Internal)
+	setInt("server_next_port", ports.Next)
+	setInt("server_integration_port", ports.Integration)
+	setBool("tls_passthrough", len(config.TLSPassthroughs) > 0)
+
+	setBool("enable_email", config.EnableEmail)
+	set("smtp_host", config.EmailSMTPHost)
+	setInt("smtp_port", config.EmailSMTPPort)
+	set("smtp_security", config.EmailSMTPSecurity)
+	set("smtp_user", config.EmailSMTPUser)
+	set("smtp_pass", config.EmailSMTPPass)
+	set("no_reply_email", config.EmailNoReply)
+
+	setBool("enable_maxmind", config.EnableMaxMind)
+	setBool("geoblock", len(config.GeoBlockCountries) > 0)
+	set("geoblock_countries", strings.Join(config.GeoBlockCountries, ","))
+	set("geoblock_scope", geoBlockScopeDashboard)
+	if config.GeoBlockEverything {
+		set("geoblock_scope", geoBlockScopeEverything)
+	}
+	setBool("hardened_defaults", config.HardenedHTTP)
+	setInt("rate_limit_average", config.RateLimitAverage)
+	setInt("rate_limit_burst", config.RateLimitBurst)
+	setBool("docker_secrets", config.DockerSecrets)
+	set("auto_updates", config.AutoUpdate)
+	set("update_schedule", config.UpdateSchedule)
+	setBool("log_rotation", config.LogMaxSize != "")
+	set("log_max_size", config.LogMaxSize)
+	setInt("log_max_file", config.LogMaxFile)
+
+	setBool("oidc", config.OIDC != nil)
+	if oidc := config.OIDC; oidc != nil {
+		set("oidc_name", oidc.Name)
+		set("oidc_issuer", oidc.Issuer)
+		set("oidc_client_id", oidc.ClientID)
+		set("oidc_client_secret", oidc.ClientSecret)
+		set("oidc_scopes", oidc.Scopes)
+	}
+	setBool("telemetry", config.Telemetry)
+	return answers
+}
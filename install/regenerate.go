@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"slices"
+	"strings"
+)
+
+// forceOverwrite is set by --force-overwrite and replaces changed files
+// without showing them one by one, once the overwrite is confirmed
+var forceOverwrite bool
+
+// forceOverwritePhrase is typed (or passed with --confirm) to let
+// --force-overwrite replace changed files
+const forceOverwritePhrase = "overwrite"
+
+func addForceOverwriteFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&forceOverwrite, "force-overwrite", false, "Overwrite generated files that were changed since the installer wrote them, after a single typed confirmation (or --confirm "+forceOverwritePhrase+")")
+}
+
+// Choices for a generated file that was changed on disk
+const (
+	regenerateOverwrite = "overwrite"
+	regenerateKeep      = "keep"
+	regenerateNew       = "new"
+)
+
+// generatedManifest hashes the rendered files by the path they are installed
+// at, before any hand edits
+func generatedManifest(files []renderedFile) fileManifest {
+	manifest := make(fileManifest, len(files))
+	for _, file := range files {
+		manifest[installedPath(file.Path)] = hashBytes(file.Content)
+	}
+	return manifest
+}
+
+// resolveOverwrites decides what happens to rendered files that would replace
+// an existing file the previous run did not generate, mostly hand edits. It
+// shows the diff and offers to overwrite, keep the file, or write the new
+// content next to it as <name>.new. Files that are new, unchanged, or still
+// as generated are returned unchanged. With --force-overwrite a single
+// confirmation replaces all of them, declining it asks file by file.
+func resolveOverwrites(files []renderedFile) []renderedFile {
+	state, err := loadInstallState(".")
+	if err != nil {
+		logf("WARN", "could not read %s: %v", installStateFile, err)
+		state = &installState{}
+	}
+	changed := func(file renderedFile) bool {
+		path := installedPath(file.Path)
+		current, err := os.ReadFile(path)
+		return err == nil && string(current) != string(file.Content) && state.Generated[path] != hashBytes(current)
+	}
+	if forceOverwrite {
+		var paths []string
+		for _, file := range files {
+			if changed(file) {
+				paths = append(paths, installedPath(file.Path))
+			}
+		}
+		if len(paths) == 0 || readConfirmation("force_overwrite", tr("prompt.force_overwrite", strings.Join(paths, ", ")), forceOverwritePhrase) {
+			return files
+		}
+	}
+
+	var resolved []renderedFile
+	var secrets []string
+	for _, file := range files {
+		if !changed(file) {
+			resolved = append(resolved, file)
+			continue
+		}
+		path := installedPath(file.Path)
+		current, _ := os.ReadFile(path)
+
+		if secrets == nil {
+			secrets = installedSecrets()
+		}
+		if _, ok := state.Generated[path]; ok {
+			warnf("\n%s was changed since the installer wrote it. Regenerating it changes:\n", path)
+		} else {
+			warnf("\n%s may have been changed by hand, the installer has no record of writing it. Regenerating it changes:\n", path)
+		}
+		infof("%s", renderFileDiff(path, current, file.Content, secrets))
+
+		options := []string{regenerateOverwrite, regenerateKeep, regenerateNew}
+		switch readChoice("regenerate_file", tr("prompt.regenerate_file", path, path+".new"), options, regenerateNew) {
+		case regenerateOverwrite:
+			resolved = append(resolved, file)
+		case regenerateKeep:
+			infof("Keeping %s.\n", path)
+			report.skip("regenerating " + path + " (kept)")
+		case regenerateNew:
+			file.Path = path + ".new"
+			infof("Writing the new version to %s, merge it into %s by hand.\n", file.Path, path)
+			resolved = append(resolved, file)
+		}
+	}
+	return resolved
+}
+
+// writesFile reports whether files still writes the file at path
+func writesFile(files []renderedFile, path string) bool {
+	return slices.ContainsFunc(files, func(file renderedFile) bool { return file.Path == path })
+}
+
+// recordGeneratedFiles notes the hashes of manifest in the state file, so the
+// next run can tell hand edits from what the installer wrote
+func recordGeneratedFiles(manifest fileManifest) {
+	state, err := loadInstallState(".")
+	if err != nil {
+		logf("WARN", "could not read %s: %v", installStateFile, err)
+		return
+	}
+	if state.Generated == nil {
+		state.Generated = fileManifest{}
+	}
+	for path, hash := range manifest {
+		state.Generated[path] = hash
+	}
+	if err := state.save("."); err != nil {
+		logf("WARN", "could not write %s: %v", installStateFile, err)
+	}
+}
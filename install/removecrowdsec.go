@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// crowdsecLogVolume is the Traefik access log mount added for CrowdSec
+const crowdsecLogVolume = "./config/traefik/logs:/var/log/traefik"
+
+// removeCrowdsecPhrase is typed (or passed with --confirm) to remove CrowdSec
+const removeCrowdsecPhrase = "remove crowdsec"
+
+// crowdsecRemoval strips CrowdSec from the compose file and both Traefik
+// configs. It runs through the migration machinery so the changes can be
+// previewed and written the same way.
+var crowdsecRemoval = []configMigration{
+	{Name: "remove-crowdsec", File: "docker-compose.yml", Apply: removeCrowdsecCompose},
+	{Name: "remove-crowdsec", File: "config/traefik/traefik_config.yml", Apply: removeCrowdsecStatic, Restart: "traefik"},
+	{Name: "remove-crowdsec", File: "config/traefik/dynamic_config.yml", Apply: removeCrowdsecDynamic, Restart: "traefik"},
+}
+
+func removeCrowdsecCompose(root *yaml.Node) []configChange {
+	var changes []configChange
+	services := yamlMapValue(root, "services")
+	if yamlDeleteKey(services, "crowdsec") != nil {
+		changes = append(changes, configChange{Kind: "removed", Key: "services.crowdsec"})
+	}
+	if traefik := yamlMapValue(services, "traefik"); traefik != nil {
+		if dependsOn := yamlMapValue(traefik, "depends_on"); dependsOn != nil && removeYAMLEntry(dependsOn, "crowdsec") {
+			changes = append(changes, configChange{Kind: "removed", Key: "services.traefik.depends_on.crowdsec"})
+			if len(dependsOn.Content) == 0 {
+				yamlDeleteKey(traefik, "depends_on")
+			}
+		}
+		if volumes := yamlMapValue(traefik, "volumes"); volumes != nil && removeYAMLEntry(volumes, crowdsecLogVolume) {
+			changes = append(changes, configChange{Kind: "removed", Key: "services.traefik.volumes." + crowdsecLogVolume})
+		}
+	}
+	if volumes := yamlMapValue(root, "volumes"); volumes != nil && volumes.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(volumes.Content); {
+			if name := volumes.Content[i].Value; strings.Contains(name, "crowdsec") {
+				volumes.Content = append(volumes.Content[:i], volumes.Content[i+2:]...)
+				changes = append(changes, configChange{Kind: "removed", Key: "volumes." + name})
+				continue
+			}
+			i += 2
+		}
+	}
+	return changes
+}
+
+func removeCrowdsecStatic(root *yaml.Node) []configChange {
+	var changes []configChange
+	if plugins := yamlMapValue(yamlMapValue(root, "experimental"), "plugins"); plugins != nil && yamlDeleteKey(plugins, "crowdsec") != nil {
+		changes = append(changes, configChange{Kind: "removed", Key: "experimental.plugins.crowdsec"})
+	}
+	if entryPoints := yamlMapValue(root, "entryPoints"); entryPoints != nil {
+		for i := 0; i+1 < len(entryPoints.Content); i += 2 {
+			name, http := entryPoints.Content[i].Value, yamlMapValue(entryPoints.Content[i+1], "http")
+			middlewares := yamlMapValue(http, "middlewares")
+			if middlewares == nil || !removeCrowdsecReferences(middlewares) {
+				continue
+			}
+			changes = append(changes, configChange{Kind: "removed", Key: "entryPoints." + name + ".http.middlewares.crowdsec"})
+			if len(middlewares.Content) == 0 {
+				yamlDeleteKey(http, "middlewares")
+			}
+		}
+	}
+	// The access log only fed CrowdSec and its host mount is removed with it
+	if accessLog := yamlMapValue(root, "accessLog"); accessLog != nil {
+		if path := yamlMapValue(accessLog, "filePath"); path != nil && strings.HasPrefix(path.Value, "/var/log/traefik/") {
+			yamlDeleteKey(root, "accessLog")
+			changes = append(changes, configChange{Kind: "removed", Key: "accessLog"})
+		}
+	}
+	return changes
+}
+
+func removeCrowdsecDynamic(root *yaml.Node) []configChange {
+	var changes []configChange
+	http := yamlMapValue(root, "http")
+	var removed []string
+	if middlewares := yamlMapValue(http, "middlewares"); middlewares != nil {
+		for i := 0; i+1 < len(middlewares.Content); {
+			name := middlewares.Content[i].Value
+			if yamlMapValue(yamlMapValue(middlewares.Content[i+1], "plugin"), "crowdsec") != nil {
+				middlewares.Content = append(middlewares.Content[:i], middlewares.Content[i+2:]...)
+				removed = append(removed, name)
+				changes = append(changes, configChange{Kind: "removed", Key: "http.middlewares." + name})
+				continue
+			}
+			i += 2
+		}
+	}
+	if routers := yamlMapValue(http, "routers"); routers != nil {
+		for i := 0; i+1 < len(routers.Content); i += 2 {
+			name, router := routers.Content[i].Value, routers.Content[i+1]
+			middlewares := yamlMapValue(router, "middlewares")
+			if middlewares == nil || !removeCrowdsecReferences(middlewares) {
+				continue
+			}
+			changes = append(changes, configChange{Kind: "removed", Key: "http.routers." + name + ".middlewares.crowdsec"})
+			if len(middlewares.Content) == 0 {
+				yamlDeleteKey(router, "middlewares")
+			}
+		}
+	}
+	return changes
+}
+
+// removeYAMLEntry removes value from a list or key from a mapping
+func removeYAMLEntry(node *yaml.Node, value string) bool {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return yamlDeleteKey(node, value) != nil
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if item.Value == value {
+				node.Content = append(node.Content[:i], node.Content[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeCrowdsecReferences drops the CrowdSec middlewares from a middleware
+// list, with or without the @file provider suffix
+func removeCrowdsecReferences(list *yaml.Node) bool {
+	if list.Kind != yaml.SequenceNode {
+		return false
+	}
+	before := len(list.Content)
+	list.Content = slices.DeleteFunc(list.Content, func(item *yaml.Node) bool {
+		return strings.Contains(item.Value, "crowdsec")
+	})
+	return len(list.Content) != before
+}
+
+// installedBouncerKeys returns the LAPI keys of the CrowdSec middlewares so
+// the removal diff does not print them
+func installedBouncerKeys() []string {
+	data, err := os.ReadFile("config/traefik/dynamic_config.yml")
+	if err != nil {
+		return nil
+	}
+	var dynamic struct {
+		HTTP struct {
+			Middlewares map[string]struct {
+				Plugin struct {
+					Crowdsec struct {
+						LapiKey string `yaml:"crowdsecLapiKey"`
+					} `yaml:"crowdsec"`
+				} `yaml:"plugin"`
+			} `yaml:"middlewares"`
+		} `yaml:"http"`
+	}
+	if err := yaml.Unmarshal(data, &dynamic); err != nil {
+		return nil
+	}
+	var keys []string
+	for _, middleware := range dynamic.HTTP.Middlewares {
+		if key := middleware.Plugin.Crowdsec.LapiKey; key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// checkCrowdsecLayout refuses files the removal cannot edit with confidence
+func checkCrowdsecLayout() error {
+	for _, path := range []string{"docker-compose.yml", "config/traefik/traefik_config.yml", "config/traefik/dynamic_config.yml"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot read %s: %v", path, err)
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("cannot parse %s: %v", path, err)
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return fmt.Errorf("%s is not a YAML mapping", path)
+		}
+		if path == "docker-compose.yml" {
+			services := yamlMapValue(doc.Content[0], "services")
+			if services == nil || services.Kind != yaml.MappingNode {
+				return fmt.Errorf("%s has no services section", path)
+			}
+			if traefik := yamlMapValue(services, "traefik"); traefik != nil {
+				if dependsOn := yamlMapValue(traefik, "depends_on"); dependsOn != nil && dependsOn.Kind != yaml.MappingNode && dependsOn.Kind != yaml.SequenceNode {
+					return fmt.Errorf("services.traefik.depends_on in %s is neither a list nor a mapping", path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// crowdsecLeftovers lists the keys and values that still mention CrowdSec
+// after the removal, every one of them is a reference the removal did not
+// understand. The install directory dir is left out of the values, its name
+// may contain crowdsec.
+func crowdsecLeftovers(migrated map[string][]byte, dir string) []string {
+	var leftovers []string
+	for path, content := range migrated {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			leftovers = append(leftovers, path+": "+err.Error())
+			continue
+		}
+		var walk func(node *yaml.Node, key string)
+		walk = func(node *yaml.Node, key string) {
+			switch node.Kind {
+			case yaml.DocumentNode, yaml.SequenceNode:
+				for _, child := range node.Content {
+					walk(child, key)
+				}
+			case yaml.MappingNode:
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					childKey := strings.TrimPrefix(key+"."+node.Content[i].Value, ".")
+					if strings.Contains(strings.ToLower(node.Content[i].Value), "crowdsec") {
+						leftovers = append(leftovers, path+": "+childKey)
+						continue
+					}
+					walk(node.Content[i+1], childKey)
+				}
+			case yaml.ScalarNode:
+				value := node.Value
+				if dir != "" {
+					value = strings.ReplaceAll(value, dir, "")
+				}
+				if strings.Contains(strings.ToLower(value), "crowdsec") {
+					leftovers = append(leftovers, path+": "+key+" = "+node.Value)
+				}
+			}
+		}
+		walk(&doc, "")
+	}
+	slices.Sort(leftovers)
+	return leftovers
+}
+
+// runRemoveCrowdsec implements --remove-crowdsec on an existing install
+func runRemoveCrowdsec() {
+	dir, ok := locateExistingInstall()
+	if !ok {
+		fatalf("Error: no Pangolin installation found in the current directory or /opt/pangolin\n")
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+	openInstallLog(dir)
+	defer installLog.close()
+
+	infof("=== Remove CrowdSec (%s) ===\n", dir)
+	if err := checkCrowdsecLayout(); err != nil {
+		fatalf("Error: refusing to remove CrowdSec: %v\nRemove it by hand or restore the generated layout first.\n", err)
+	}
+	migrated, changes, err := planMigrationSet(crowdsecRemoval)
+	if err != nil {
+		fatalf("Error: refusing to remove CrowdSec: %v\n", err)
+	}
+	if len(changes) == 0 {
+		infoln("CrowdSec is not installed here, nothing to remove.")
+		return
+	}
+	if leftovers := crowdsecLeftovers(migrated, dir); len(leftovers) > 0 {
+		errorf("Error: refusing to remove CrowdSec, these references are not understood by the removal:\n")
+		for _, leftover := range leftovers {
+			errorf("  %s\n", leftover)
+		}
+		fatalf("Remove them by hand, then run --remove-crowdsec again.\n")
+	}
+
+	printConfigChanges(changes, nil)
+	secrets := append(installedSecrets(), installedBouncerKeys()...)
+	for _, path := range []string{"docker-compose.yml", "config/traefik/traefik_config.yml", "config/traefik/dynamic_config.yml"} {
+		if content, ok := migrated[path]; ok {
+			old, _ := os.ReadFile(path)
+			infof("\n%s", renderFileDiff(path, old, content, secrets))
+		}
+	}
+	if dryRun {
+		exitDryRun(true)
+	}
+	if !readConfirmation("confirm_remove_crowdsec", tr("prompt.confirm_remove_crowdsec"), removeCrowdsecPhrase) {
+		infoln("Nothing was changed.")
+		return
+	}
+
+	if err := backupConfig(); err != nil {
+		fatalf("Error: backup failed: %v\n", err)
+	}
+	infoln("Backed up docker-compose.yml and the config directory (config.tar.gz).")
+	for path, content := range migrated {
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			fatalf("Error writing %s: %v\n", path, err)
+		}
+		report.fileWritten(path)
+	}
+
+	containerType := detectContainerType()
+	if containerType == Undefined {
+		infoln("Neither Docker nor Podman is running. Remove the crowdsec container and run compose up -d to apply the change.")
+		return
+	}
+	if err := runCmd(exec.Command(string(containerType), "rm", "-f", "crowdsec")); err != nil {
+		warnf("Warning: could not remove the crowdsec container: %v\n", err)
+	}
+	if err := startContainers(containerType); err != nil {
+		fatalf("Error: %v\n", err)
+	}
+	if err := restartContainer("traefik", containerType); err != nil {
+		fatalf("Error: %v\n", err)
+	}
+
+	domain := installedDashboardDomain()
+	if err := waitForStackHealthy(containerType, domain); err != nil {
+		fatalf("Error: Traefik is not healthy after removing CrowdSec: %v\nRestore the backup with: tar -xzf config.tar.gz\n", err)
+	}
+	_, httpsPort := installedEntrypointPorts("config/traefik/traefik_config.yml")
+	if _, err := handshakeLocal(domain, httpsPort); err != nil {
+		fatalf("Error: Traefik does not answer for %s after removing CrowdSec: %v\n", domain, err)
+	}
+	infof("CrowdSec was removed, Traefik serves %s.\n", domain)
+	infoln("The CrowdSec data in config/crowdsec was kept, delete it once you no longer need it.")
+}
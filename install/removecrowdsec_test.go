@@ -0,0 +1,30 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestCrowdsecLeftovers checks that references the removal left behind are
+// found, and that an install directory named after CrowdSec is not one
+func TestCrowdsecLeftovers(t *testing.T) {
+	const dir = "/opt/crowdsec-pangolin"
+	compose := []byte(`services:
+  pangolin:
+    volumes:
+      - /opt/crowdsec-pangolin/config:/app/config
+  traefik:
+    depends_on:
+      - crowdsec
+    volumes:
+      - /opt/crowdsec-pangolin/config/crowdsec_logs:/var/log
+`)
+	got := crowdsecLeftovers(map[string][]byte{"docker-compose.yml": compose}, dir)
+	want := []string{
+		"docker-compose.yml: services.traefik.depends_on = crowdsec",
+		"docker-compose.yml: services.traefik.volumes = /opt/crowdsec-pangolin/config/crowdsec_logs:/var/log",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
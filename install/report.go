@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	outputFlag *string
+	// redactSecretsFlag leaves the secrets out of the result document
+	redactSecretsFlag *bool
+)
+
+// redactedValue replaces a secret of the result document with --redact-secrets
+const redactedValue = "[redacted]"
+
+// consoleOut receives all human readable output. It is stderr when a machine
+// readable document is written to stdout.
+var consoleOut io.Writer = os.Stdout
+
+// installReport is the machine readable summary printed by --output json or
+// yaml
+type installReport struct {
+	mu sync.Mutex
+
+	Status        string            `json:"status"`
+	Error         string            `json:"error,omitempty"`
+	ExitCode      int               `json:"exitCode,omitempty"`
+	Config        *reportConfig     `json:"config,omitempty"`
+	FilesWritten  []string          `json:"filesWritten"`
+	Services      []reportService   `json:"services"`
+	Containers    []reportContainer `json:"containers"`
+	Ports         []reportPort      `json:"ports"`
+	Secrets       []reportSecret    `json:"secrets"`
+	ChecksSkipped []string          `json:"checksSkipped"`
+	Warnings      []string          `json:"warnings"`
+	Answers       []answerRecord    `json:"answers,omitempty"`
+	LogFile       string            `json:"logFile,omitempty"`
+	Admin         *reportAdmin      `json:"admin,omitempty"`
+}
+
+// reportAdmin is the admin account the installer created. Unlike the config
+// it carries a generated password and the API token, provisioning tools
+// sign in with them.
+type reportAdmin struct {
+	Email        string `json:"email"`
+	Created      bool   `json:"created"`
+	Password     string `json:"password,omitempty"`
+	APIToken     string `json:"apiToken,omitempty"`
+	APITokenFile string `json:"apiTokenFile,omitempty"`
+}
+
+// reportConfig is the chosen configuration with every secret omitted
+type reportConfig struct {
+	ContainerType   string   `json:"containerType,omitempty"`
+	PangolinVersion string   `json:"pangolinVersion,omitempty"`
+	GerbilVersion   string   `json:"gerbilVersion,omitempty"`
+	BadgerVersion   string   `json:"badgerVersion,omitempty"`
+	BaseDomain      string   `json:"baseDomain,omitempty"`
+	Domains         []string `json:"additionalDomains,omitempty"`
+	DashboardDomain string   `json:"dashboardDomain,omitempty"`
+	WildcardDomain  string   `json:"wildcardDomain,omitempty"`
+	TunnelEndpoint  string   `json:"tunnelEndpoint,omitempty"`
+	LetsEncrypt     string   `json:"letsEncryptEmail,omitempty"`
+	ACMEStaging     bool     `json:"acmeStaging,omitempty"`
+	Enterprise      bool     `json:"enterprise"`
+	PostgreSQL      bool     `json:"postgresql"`
+	PostgreSQLHost  string   `json:"postgresqlHost,omitempty"`
+	Redis           bool     `json:"redis"`
+	Gerbil          bool     `json:"gerbil"`
+	WireGuardPort   int      `json:"wireguardPort,omitempty"`
+	Email           bool     `json:"email"`
+	SMTPHost        string   `json:"smtpHost,omitempty"`
+	SMTPPort        int      `json:"smtpPort,omitempty"`
+	SMTPUser        string   `json:"smtpUser,omitempty"`
+	NoReply         string   `json:"noReplyEmail,omitempty"`
+	IPv6            bool     `json:"ipv6"`
+	MaxMind         bool     `json:"maxmind"`
+	CrowdSec        bool     `json:"crowdsec"`
+	GeoBlock        []string `json:"geoBlockCountries,omitempty"`
+	Telemetry       bool     `json:"telemetry"`
+}
+
+// reportService is a service of the generated compose file, named before
+// its container exists
+type reportService struct {
+	Name          string `json:"name"`
+	ContainerName string `json:"containerName,omitempty"`
+	Image         string `json:"image,omitempty"`
+}
+
+// reportPort is a port the stack publishes on the host
+type reportPort struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// reportSecret is a secret written to the generated files. Generated is set
+// for those the installer made up rather than asked for.
+type reportSecret struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	File      string `json:"file"`
+	Generated bool   `json:"generated"`
+}
+
+type reportContainer struct {
+	Name        string   `json:"name"`
+	Image       string   `json:"image"`
+	ImageID     string   `json:"imageId"`
+	RepoDigests []string `json:"repoDigests,omitempty"`
+	State       string   `json:"state"`
+}
+
+var report = &installReport{}
+
+// machineOutput reports whether a result document is printed to stdout
+func machineOutput() bool {
+	return outputFlag != nil && (*outputFlag == "json" || *outputFlag == "yaml")
+}
+
+func (r *installReport) setConfig(config Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Config = &reportConfig{
+		ContainerType:   string(config.InstallationContainerType),
+		PangolinVersion: config.PangolinVersion,
+		GerbilVersion:   config.GerbilVersion,
+		BadgerVersion:   config.BadgerVersion,
+		BaseDomain:      config.BaseDomain,
+		Domains:         config.AdditionalDomains,
+		DashboardDomain: config.DashboardDomain,
+		WildcardDomain:  config.WildcardDomain,
+		TunnelEndpoint:  config.TunnelEndpoint(),
+		LetsEncrypt:     config.LetsEncryptEmail,
+		ACMEStaging:     config.ACMEStaging,
+		Enterprise:      config.IsEnterprise,
+		PostgreSQL:      config.IsPostgreSQL,
+		PostgreSQLHost:  config.PostgreSQLHost,
+		Redis:           config.IsRedis,
+		Gerbil:          config.InstallGerbil,
+		WireGuardPort:   config.WireGuardPort,
+		Email:           config.EnableEmail,
+		SMTPHost:        config.EmailSMTPHost,
+		SMTPPort:        config.EmailSMTPPort,
+		SMTPUser:        config.EmailSMTPUser,
+		NoReply:         config.EmailNoReply,
+		IPv6:            config.EnableIPv6,
+		MaxMind:         config.EnableMaxMind,
+		CrowdSec:        config.DoCrowdsecInstall,
+		GeoBlock:        config.GeoBlockCountries,
+		Telemetry:       config.Telemetry,
+	}
+	r.Ports = nil
+	for _, port := range hostPorts(&config) {
+		// The config of an existing install is partial, it may lack the ports
+		if port.Number == 0 {
+			continue
+		}
+		r.Ports = append(r.Ports, reportPort{Name: port.Name, Port: port.Number, Protocol: port.Proto})
+	}
+	r.Secrets = reportSecrets(config)
+}
+
+// reportSecrets are the secrets of config and the files they are written
+// to, relative to the install directory
+func reportSecrets(config Config) []reportSecret {
+	var secrets []reportSecret
+	add := func(name, value, file string, generated bool) {
+		if value != "" {
+			secrets = append(secrets, reportSecret{Name: name, Value: value, File: file, Generated: generated})
+		}
+	}
+	add("server_secret", config.Secret, envFile, true)
+	add("smtp_password", config.EmailSMTPPass, envFile, false)
+	if config.OIDC != nil {
+		add("oidc_client_secret", config.OIDC.ClientSecret, envFile, false)
+	}
+	add("crowdsec_bouncer_key", config.TraefikBouncerKey, "config/traefik/dynamic_config.yml", true)
+	for _, secret := range config.composeSecrets() {
+		file := envFile
+		if config.DockerSecrets {
+			file = secret.Path()
+		}
+		add(secret.Name, secret.Value, file, false)
+	}
+	return secrets
+}
+
+// reportServices lists the services of the compose file with their
+// container names and images, nil when it cannot be read
+func reportServices(composePath string) []reportService {
+	data, err := os.ReadFile(composePath)
+	if err != nil {
+		return nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	services := yamlMapValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil
+	}
+	var list []reportService
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		service := reportService{Name: services.Content[i].Value}
+		if name := yamlMapValue(services.Content[i+1], "container_name"); name != nil {
+			service.ContainerName = name.Value
+		}
+		if image := yamlMapValue(services.Content[i+1], "image"); image != nil {
+			service.Image = image.Value
+		}
+		list = append(list, service)
+	}
+	return list
+}
+
+func (r *installReport) setAdmin(admin *reportAdmin) {
+	if admin.APITokenFile != "" {
+		if abs, err := filepath.Abs(admin.APITokenFile); err == nil {
+			admin.APITokenFile = abs
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Admin = admin
+}
+
+func (r *installReport) fileWritten(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(r.FilesWritten, path) {
+		r.FilesWritten = append(r.FilesWritten, path)
+	}
+}
+
+func (r *installReport) fileRemoved(path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FilesWritten = slices.DeleteFunc(r.FilesWritten, func(p string) bool { return p == path })
+}
+
+func (r *installReport) skip(check string) {
+	logf("INFO", "skipped: %s", check)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ChecksSkipped = append(r.ChecksSkipped, check)
+}
+
+func (r *installReport) warn(msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Warnings = append(r.Warnings, strings.TrimSpace(msg))
+}
+
+// collectContainers records the state and image of every Pangolin container
+func (r *installReport) collectContainers(containerType SupportedContainer) {
+	var containers []reportContainer
+	names, _ := stackContainers(containerType)
+	for _, name := range names {
+		out, err := outputCmd(exec.Command(string(containerType), "container", "inspect", "-f",
+			"{{.Config.Image}}|{{.Image}}|{{.State.Status}}", name))
+		if err != nil {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(string(out)), "|", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		c := reportContainer{Name: name, Image: fields[0], ImageID: fields[1], State: fields[2]}
+
+		if digests, err := outputCmd(exec.Command(string(containerType), "image", "inspect", "-f",
+			"{{range .RepoDigests}}{{.}} {{end}}", c.ImageID)); err == nil {
+			c.RepoDigests = strings.Fields(string(digests))
+		}
+		containers = append(containers, c)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Containers = containers
+}
+
+// fail emits the document of a run that exits with code
+func (r *installReport) fail(code int, err string) {
+	r.mu.Lock()
+	r.ExitCode = code
+	r.mu.Unlock()
+	r.emit("error", err)
+}
+
+// emit writes the report to stdout when --output json or yaml is set
+func (r *installReport) emit(status string, err string) {
+	if !machineOutput() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Status = status
+	r.Error = err
+	r.LogFile = installLog.path
+	r.Answers = answerRecords()
+	r.Services = reportServices("docker-compose.yml")
+	if r.FilesWritten == nil {
+		r.FilesWritten = []string{}
+	}
+	if r.Services == nil {
+		r.Services = []reportService{}
+	}
+	if r.Containers == nil {
+		r.Containers = []reportContainer{}
+	}
+	if r.Ports == nil {
+		r.Ports = []reportPort{}
+	}
+	if r.Secrets == nil {
+		r.Secrets = []reportSecret{}
+	}
+	if r.ChecksSkipped == nil {
+		r.ChecksSkipped = []string{}
+	}
+	if r.Warnings == nil {
+		r.Warnings = []string{}
+	}
+
+	if *redactSecretsFlag {
+		r.redact()
+	}
+
+	data, _ := json.MarshalIndent(r, "", "  ")
+	if *outputFlag == "yaml" {
+		var err error
+		if data, err = jsonToYAML(data); err != nil {
+			logf("WARN", "could not convert the result document to YAML: %v", err)
+			return
+		}
+	} else {
+		data = append(data, '\n')
+	}
+	os.Stdout.Write(data)
+}
+
+// redact replaces the secrets of the document with redactedValue
+func (r *installReport) redact() {
+	for i := range r.Secrets {
+		r.Secrets[i].Value = redactedValue
+	}
+	if r.Admin != nil {
+		if r.Admin.Password != "" {
+			r.Admin.Password = redactedValue
+		}
+		if r.Admin.APIToken != "" {
+			r.Admin.APIToken = redactedValue
+		}
+	}
+}
+
+// jsonToYAML converts a JSON document to block style YAML, keeping the order
+// of its keys
+func jsonToYAML(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var plain func(node *yaml.Node)
+	plain = func(node *yaml.Node) {
+		node.Style = 0
+		for _, child := range node.Content {
+			plain(child)
+		}
+	}
+	plain(&doc)
+	return MarshalYAMLWithIndent(&doc, 2)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The oldest releases the stack runs on. Compose 2.3.3 was the first to read
+// the top-level name of the compose file.
+const (
+	minDockerVersion  = "20.10.0"
+	minComposeVersion = "2.3.3"
+	minPodmanVersion  = "4.0.0"
+)
+
+// cgnatRange is the shared address space of carrier-grade NAT (RFC 6598)
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// forceRequirements is set by --force and turns unmet system requirements
+// into warnings
+var forceRequirements bool
+
+func addForceFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&forceRequirements, "force", false, "Continue when the system requirements are not met, warning instead of stopping")
+}
+
+// checkSystemRequirements checks the host before the first question: the
+// container runtime, memory, disk, WireGuard support, the default ports and
+// whether inbound connections can reach it at all. It exits on an unmet
+// requirement unless --force is set. A host that already runs Pangolin is not
+// checked, its stack holds the ports.
+func checkSystemRequirements() {
+	dir := defaultInstallDir
+	if preset, _, ok := presetValue("install_dir"); ok {
+		dir, _ = filepath.Abs(expandHome(preset))
+	}
+	cwd, _ := os.Getwd()
+	if hasExistingInstall(dir) || hasExistingInstall(cwd) {
+		report.skip("system requirements (existing installation)")
+		return
+	}
+
+	infoln("\n=== System Requirements ===")
+	ports := Config{
+		HTTPPort:      presetPort("http_port", defaultHTTPPort),
+		HTTPSPort:     presetPort("https_port", defaultHTTPSPort),
+		WireGuardPort: presetPort("wireguard_port", defaultWireGuardPort),
+		InstallGerbil: true,
+	}
+	checks := []preflightCheck{
+		checkFunc{"runtime", func(context.Context) checkResult { return checkRuntimeVersions() }},
+		checkFunc{"memory", func(context.Context) checkResult { return checkMemory() }},
+		checkFunc{"disk", func(context.Context) checkResult { return checkDiskSpace(existingAncestor(dir)) }},
+		checkFunc{"wireguard", func(context.Context) checkResult { return checkWireGuardSupport() }},
+		checkFunc{"ports", func(context.Context) checkResult { return checkHostPorts(ports) }},
+		checkFunc{"cgnat", func(ctx context.Context) checkResult { return checkCGNAT(ctx, ports) }},
+	}
+	results := runChecks(context.Background(), checks, preflightTimeout)
+	printCheckResults(checks, results)
+
+	var unmet []string
+	for i, result := range results {
+		if result.Status == checkFailed {
+			unmet = append(unmet, checks[i].Name())
+		}
+	}
+	switch {
+	case len(unmet) == 0:
+	case forceRequirements:
+		warnf("Warning: continuing with unmet requirements (%s) because of --force.\n", strings.Join(unmet, ", "))
+	default:
+		exitf(exitPreflight, "Error: this host does not meet the requirements (%s), nothing was changed. Fix the problems above, skip a check with --skip-check=<name>, or continue anyway with --force.\n", strings.Join(unmet, ", "))
+	}
+}
+
+// presetPort is the port answered for the prompt with key before it is
+// asked, defaultPort otherwise
+func presetPort(key string, defaultPort int) int {
+	if value, _, ok := presetValue(key); ok {
+		if port, err := strconv.Atoi(value); err == nil {
+			return port
+		}
+	}
+	return defaultPort
+}
+
+// existingAncestor is dir or its closest parent that exists, the filesystem
+// the install directory will be created on
+func existingAncestor(dir string) string {
+	for !pathExists(dir) && filepath.Dir(dir) != dir {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// checkRuntimeVersions checks the versions of Docker and Compose, or of
+// Podman. A host without either passes, the installer offers to install
+// Docker.
+func checkRuntimeVersions() checkResult {
+	versions := facts.toolVersions()
+	switch {
+	case facts.dockerInstalled():
+		server := versions["docker"]
+		if server == "" {
+			return failedCheck("the Docker daemon does not respond, start it with systemctl start docker")
+		}
+		compose := versions["docker compose"]
+		if compose == "" {
+			compose = versions["docker-compose"]
+		}
+		if compose == "" {
+			return failedCheck("Docker %s without Compose, install the docker-compose-plugin package", server)
+		}
+		if problem := tooOld("Docker", server, minDockerVersion); problem != "" {
+			return failedCheck("%s", problem)
+		}
+		if problem := tooOld("Compose", compose, minComposeVersion); problem != "" {
+			return failedCheck("%s", problem)
+		}
+		return passedCheck("Docker %s, Compose %s", server, strings.TrimPrefix(compose, "v"))
+	case facts.podmanInstalled():
+		podman := versions["podman"]
+		if podman == "" {
+			return failedCheck("podman does not respond")
+		}
+		if problem := tooOld("Podman", podman, minPodmanVersion); problem != "" {
+			return failedCheck("%s", problem)
+		}
+		return passedCheck("Podman %s with podman-compose", podman)
+	}
+	return skippedCheck("neither Docker nor Podman is installed yet, the installer offers to install Docker")
+}
+
+// tooOld describes why version of tool is older than minimum, "" when it is
+// not or cannot be told
+func tooOld(tool, version, minimum string) string {
+	have, ok := parseVersion(version)
+	want, _ := parseVersion(minimum)
+	if ok && versionLess(have, want) {
+		return fmt.Sprintf("%s %s is too old, at least %s is needed", tool, strings.TrimPrefix(version, "v"), minimum)
+	}
+	return ""
+}
+
+// checkWireGuardSupport checks that the kernel has WireGuard, Gerbil creates
+// its tunnel interface with it
+func checkWireGuardSupport() checkResult {
+	if facts.goos() != "linux" {
+		return skippedCheck("not a Linux host")
+	}
+	if !facts.wireguard() {
+		return failedCheck("the kernel has no WireGuard module, Gerbil cannot create the tunnel. Use a kernel of 5.6 or later, or pass --force if Gerbil is not installed")
+	}
+	return passedCheck("the kernel supports WireGuard")
+}
+
+// checkCGNAT fails when this server sits behind carrier-grade NAT, which no
+// port forward gets inbound connections through
+func checkCGNAT(ctx context.Context, ports Config) checkResult {
+	local := routeSource("udp4", "192.0.2.1:53")
+	switch {
+	case local == nil:
+		return skippedCheck("no IPv4 connectivity")
+	case cgnatRange.Contains(local):
+		return failedCheck("this server's address %s is behind carrier-grade NAT, Newt sites and browsers cannot reach it. Ask your provider for a public IPv4 address or install on a VPS", local)
+	case !local.IsPrivate() && platform.PublicAddress:
+		return passedCheck("%s is a public address", local)
+	}
+	forward := fmt.Sprintf("forward TCP %s and UDP %d and %d to this server", joinPorts(ports.EntryPointPorts()), ports.GerbilPorts()[0], ports.GerbilPorts()[1])
+	if network.allow("public IP detection") {
+		if public := detectPublicIP(ctx); public != nil {
+			return passedCheck("behind NAT, the internet sees %s: %s", public, forward)
+		}
+	}
+	return passedCheck("behind NAT (%s): %s", local, forward)
+}
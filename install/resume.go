@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// resumeStateFile records the progress of a fresh install in the install
+// directory, so that a failed run can continue where it stopped
+const resumeStateFile = ".pangolin-install-state.json"
+
+const resumeFormat = 1
+
+// installStage is a step of a fresh install, in the order they run
+type installStage string
+
+const (
+	stageAnswers installStage = "answers"
+	stageFiles   installStage = "files"
+	stageDocker  installStage = "docker"
+	stageImages  installStage = "images"
+	stageStack   installStage = "stack"
+)
+
+var installStages = []installStage{stageAnswers, stageFiles, stageDocker, stageImages, stageStack}
+
+func (s installStage) describe() string {
+	switch s {
+	case stageAnswers:
+		return "answers collected"
+	case stageFiles:
+		return "configuration files written"
+	case stageDocker:
+		return "Docker installed"
+	case stageImages:
+		return "container images pulled"
+	case stageStack:
+		return "stack started"
+	}
+	return string(s)
+}
+
+// action is what the stage still has to do
+func (s installStage) action() string {
+	switch s {
+	case stageAnswers:
+		return "collect the answers"
+	case stageFiles:
+		return "write the configuration files"
+	case stageDocker:
+		return "install Docker"
+	case stageImages:
+		return "pull the container images"
+	case stageStack:
+		return "start the stack"
+	}
+	return "finish the install"
+}
+
+// installProgress is the content of the resume state file. The answers hold
+// passwords and keys, so they are sealed with a key derived from the machine
+// ID and cannot be read on another host.
+type installProgress struct {
+	Format           int            `json:"format"`
+	InstallerVersion string         `json:"installerVersion"`
+	Done             []installStage `json:"done"`
+	// Answers is the sealed Config
+	Answers []byte `json:"answers,omitempty"`
+	// Wizard is the sealed list of questions answered so far, while the
+	// answers are still being collected
+	Wizard []byte `json:"wizard,omitempty"`
+
+	config Config
+	wizard []sessionAnswer
+}
+
+func (p *installProgress) done(stage installStage) bool {
+	return slices.Contains(p.Done, stage)
+}
+
+// next returns the first stage that has not completed
+func (p *installProgress) next() (installStage, bool) {
+	for _, stage := range installStages {
+		if !p.done(stage) {
+			return stage, true
+		}
+	}
+	return "", false
+}
+
+// complete marks stage as done and saves the progress with config. Failures
+// are logged but never fatal, the install only loses the ability to resume.
+// An interrupt after this keeps the work of the stage instead of undoing it.
+func (p *installProgress) complete(stage installStage, config Config) {
+	cleanups.commit()
+	if !p.done(stage) {
+		p.Done = append(p.Done, stage)
+	}
+	p.config = config
+	if stage == stageAnswers {
+		p.wizard = nil
+	}
+	if err := p.save(); err != nil {
+		logf("WARN", "could not save %s: %v", resumeStateFile, err)
+	}
+}
+
+// saveWizard saves the questions answered so far, it is called after each
+// answer of the wizard
+func (p *installProgress) saveWizard(answers []sessionAnswer) {
+	p.wizard = answers
+	if err := p.save(); err != nil {
+		logf("WARN", "could not save %s: %v", resumeStateFile, err)
+	}
+}
+
+// collectResumableInput is collectUserInput saving the answers to the resume
+// state file as they are given. A wizard that was interrupted continues after
+// the last question answered.
+func collectResumableInput(progress *installProgress) Config {
+	return runWizard(askUserInput, progress.wizard, progress.saveWizard)
+}
+
+// editResumableInput runs the wizard again to change the answer at index of
+// the saved answers, see editWizard
+func editResumableInput(progress *installProgress, index int) Config {
+	return editWizard(askUserInput, progress.wizard, index, progress.saveWizard)
+}
+
+func (p *installProgress) save() error {
+	key, err := resumeKey()
+	if err != nil {
+		return err
+	}
+	answers, err := json.Marshal(p.config)
+	if err != nil {
+		return err
+	}
+	if p.Answers, err = seal(key, answers); err != nil {
+		return err
+	}
+	p.Wizard = nil
+	if len(p.wizard) > 0 {
+		wizard, err := json.Marshal(p.wizard)
+		if err != nil {
+			return err
+		}
+		if p.Wizard, err = seal(key, wizard); err != nil {
+			return err
+		}
+	}
+	p.Format = resumeFormat
+	p.InstallerVersion = pangolinVersion
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(resumeStateFile, append(data, '\n'), 0600)
+}
+
+// loadInstallProgress reads the resume state file of the install directory in
+// the current working directory, nil when there is none
+func loadInstallProgress() (*installProgress, error) {
+	data, err := os.ReadFile(resumeStateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var progress installProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, fmt.Errorf("%s is not a resume state file: %v", resumeStateFile, err)
+	}
+	if progress.Format != resumeFormat {
+		return nil, fmt.Errorf("%s uses format %d, this installer supports format %d", resumeStateFile, progress.Format, resumeFormat)
+	}
+	if progress.InstallerVersion != pangolinVersion {
+		return nil, fmt.Errorf("%s was written by installer %s but this is installer %s", resumeStateFile, progress.InstallerVersion, pangolinVersion)
+	}
+	key, err := resumeKey()
+	if err != nil {
+		return nil, err
+	}
+	answers, err := unseal(key, progress.Answers)
+	if err != nil {
+		return nil, fmt.Errorf("the answers in %s cannot be decrypted on this machine", resumeStateFile)
+	}
+	if err := json.Unmarshal(answers, &progress.config); err != nil {
+		return nil, fmt.Errorf("%s: %v", resumeStateFile, err)
+	}
+	if len(progress.Wizard) > 0 {
+		wizard, err := unseal(key, progress.Wizard)
+		if err != nil {
+			return nil, fmt.Errorf("the answers in %s cannot be decrypted on this machine", resumeStateFile)
+		}
+		if err := json.Unmarshal(wizard, &progress.wizard); err != nil {
+			return nil, fmt.Errorf("%s: %v", resumeStateFile, err)
+		}
+	}
+	return &progress, nil
+}
+
+// clearInstallProgress removes the resume state file once the install
+// completed
+func clearInstallProgress() {
+	if err := os.Remove(resumeStateFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logf("WARN", "could not remove %s: %v", resumeStateFile, err)
+	}
+}
+
+// offerResume shows the progress of an interrupted install and asks whether to
+// continue it. It returns the progress to continue, or an empty one to start
+// over.
+func offerResume() *installProgress {
+	progress, err := loadInstallProgress()
+	if err != nil {
+		warnf("Warning: cannot resume the previous install: %v\n", err)
+		clearInstallProgress()
+		return &installProgress{}
+	}
+	if progress == nil || len(progress.Done) == 0 && len(progress.wizard) == 0 {
+		return &installProgress{}
+	}
+
+	infoln("\n=== Previous Install ===")
+	infoln("A previous install in this directory stopped before it completed. Already done:")
+	for _, stage := range progress.Done {
+		infof("  - %s\n", stage.describe())
+	}
+	next, _ := progress.next()
+	action := next.action()
+	if next == stageAnswers {
+		infof("  - %d questions answered\n", len(progress.wizard))
+		action = "continue with the next question"
+	}
+	choice := readChoice("resume_install", tr("prompt.resume_install", action), []string{"resume", "start-over"}, "resume")
+	if choice == "resume" {
+		logf("INFO", "resuming install, done: %s", strings.Join(stageNames(progress.Done), ", "))
+		return progress
+	}
+	clearInstallProgress()
+	return &installProgress{}
+}
+
+func stageNames(stages []installStage) []string {
+	names := make([]string, len(stages))
+	for i, stage := range stages {
+		names[i] = string(stage)
+	}
+	return names
+}
+
+// machineIDFiles hold the identifier systemd and D-Bus generate per host
+var machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// resumeKey derives the key that seals the answers from the machine ID
+func resumeKey() ([]byte, error) {
+	for _, path := range machineIDFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if id := strings.TrimSpace(string(data)); id != "" {
+			key := sha256.Sum256([]byte("pangolin-installer-resume:" + id))
+			return key[:], nil
+		}
+	}
+	return nil, fmt.Errorf("no machine ID found in %s", strings.Join(machineIDFiles, " or "))
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func unseal(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// installerNamePrefix namespaces every router, middleware and service the
+// installer writes to the Traefik file provider
+const installerNamePrefix = "installer-"
+
+// traefikAPIURL is the API on Traefik's internal entrypoint, only reachable
+// from inside the container
+const traefikAPIURL = "http://127.0.0.1:8080/api/http/routers?per_page=1000"
+
+// legacyTraefikNames maps names written by older installers to their
+// namespaced replacements, per dynamic config section
+var legacyTraefikNames = map[string]map[string]string{
+	"routers": {
+		"main-app-router-redirect": "installer-dashboard-redirect",
+		"next-router":              "installer-dashboard",
+		"api-router":               "installer-dashboard-api",
+		"ws-router":                "installer-dashboard-ws",
+	},
+	"middlewares": {
+		"badger":            "installer-badger",
+		"redirect-to-https": "installer-redirect-to-https",
+		"security-headers":  "installer-security-headers",
+		"default-whitelist": "installer-default-whitelist",
+		"crowdsec":          "installer-crowdsec",
+	},
+	"services": {
+		"next-service": "installer-next",
+		"api-service":  "installer-api",
+	},
+}
+
+// dashboardRouterPriorities are the explicit priorities of the dashboard
+// routers, see the comment in config/traefik/dynamic_config.yml
+var dashboardRouterPriorities = map[string]int{
+	"installer-dashboard-api":      1030,
+	"installer-dashboard":          1020,
+	"installer-dashboard-ws":       1010,
+	"installer-dashboard-redirect": 1000,
+}
+
+// namespaceTraefikNames renames the routers, middlewares and services of an
+// older dynamic config and updates every reference to them
+func namespaceTraefikNames(root *yaml.Node) []configChange {
+	var changes []configChange
+	if http := yamlMapValue(root, "http"); http != nil {
+		for _, section := range []string{"middlewares", "services", "routers"} {
+			changes = append(changes, renameKeys(http, "http."+section, legacyTraefikNames[section])...)
+		}
+		if routers := yamlMapValue(http, "routers"); routers != nil {
+			for i := 0; i+1 < len(routers.Content); i += 2 {
+				name, router := routers.Content[i].Value, routers.Content[i+1]
+				renameReferences(router, legacyTraefikNames["services"], legacyTraefikNames["middlewares"])
+				if priority, ok := dashboardRouterPriorities[name]; ok && yamlMapValue(router, "priority") == nil {
+					yamlSetKey(router, "priority", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(priority)})
+					changes = append(changes, configChange{Kind: "added", Key: "http.routers." + name + ".priority"})
+				}
+			}
+		}
+	}
+
+	if tcp := yamlMapValue(root, "tcp"); tcp != nil {
+		passthroughs := map[string]string{}
+		if routers := yamlMapValue(tcp, "routers"); routers != nil {
+			for i := 0; i < len(routers.Content); i += 2 {
+				if name := routers.Content[i].Value; strings.HasPrefix(name, "passthrough-") {
+					passthroughs[name] = installerNamePrefix + name
+				}
+			}
+		}
+		changes = append(changes, renameKeys(tcp, "tcp.routers", passthroughs)...)
+		changes = append(changes, renameKeys(tcp, "tcp.services", passthroughs)...)
+		if routers := yamlMapValue(tcp, "routers"); routers != nil {
+			for i := 1; i < len(routers.Content); i += 2 {
+				renameReferences(routers.Content[i], passthroughs, nil)
+			}
+		}
+	}
+	return changes
+}
+
+// namespaceEntrypointMiddlewares updates middlewares@file references in the
+// static config, e.g. the CrowdSec middleware on the websecure entrypoint
+func namespaceEntrypointMiddlewares(root *yaml.Node) []configChange {
+	var changes []configChange
+	entryPoints := yamlMapValue(root, "entryPoints")
+	if entryPoints == nil {
+		return nil
+	}
+	for i := 0; i+1 < len(entryPoints.Content); i += 2 {
+		name := entryPoints.Content[i].Value
+		middlewares := yamlMapValue(yamlMapValue(entryPoints.Content[i+1], "http"), "middlewares")
+		if middlewares == nil {
+			continue
+		}
+		for _, item := range middlewares.Content {
+			base, ok := strings.CutSuffix(item.Value, "@file")
+			if renamed, legacy := legacyTraefikNames["middlewares"][base]; ok && legacy {
+				path := "entryPoints." + name + ".http.middlewares."
+				changes = append(changes, configChange{Kind: "renamed", Key: path + item.Value, NewKey: path + renamed + "@file"})
+				item.Value = renamed + "@file"
+			}
+		}
+	}
+	return changes
+}
+
+// renameKeys renames the keys of the mapping at parent[section] found in names
+func renameKeys(parent *yaml.Node, path string, names map[string]string) []configChange {
+	_, section, _ := strings.Cut(path, ".")
+	node := yamlMapValue(parent, section)
+	if node == nil {
+		return nil
+	}
+	var changes []configChange
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if renamed, ok := names[key.Value]; ok && yamlMapValue(node, renamed) == nil {
+			changes = append(changes, configChange{Kind: "renamed", Key: path + "." + key.Value, NewKey: path + "." + renamed})
+			key.Value = renamed
+		}
+	}
+	return changes
+}
+
+// renameReferences updates the service and middlewares of a router
+func renameReferences(router *yaml.Node, services, middlewares map[string]string) {
+	if service := yamlMapValue(router, "service"); service != nil {
+		if renamed, ok := services[service.Value]; ok {
+			service.Value = renamed
+		}
+	}
+	if list := yamlMapValue(router, "middlewares"); list != nil {
+		for _, item := range list.Content {
+			if renamed, ok := middlewares[item.Value]; ok {
+				item.Value = renamed
+			}
+		}
+	}
+}
+
+// traefikRouter is a router as reported by the Traefik API
+type traefikRouter struct {
+	Name        string   `json:"name"`
+	Provider    string   `json:"provider"`
+	Rule        string   `json:"rule"`
+	Priority    int      `json:"priority"`
+	EntryPoints []string `json:"entryPoints"`
+	Status      string   `json:"status"`
+	Errors      []string `json:"error"`
+}
+
+// effectivePriority is the priority Traefik sorts by, the rule length when
+// none is set
+func (r traefikRouter) effectivePriority() int {
+	if r.Priority != 0 {
+		return r.Priority
+	}
+	return len(r.Rule)
+}
+
+func (r traefikRouter) baseName() string {
+	name, _, _ := strings.Cut(r.Name, "@")
+	return name
+}
+
+func (r traefikRouter) installerOwned() bool {
+	return r.Provider == "file" && strings.HasPrefix(r.baseName(), installerNamePrefix)
+}
+
+// fetchTraefikRouters queries the Traefik API from inside the container
+func fetchTraefikRouters(containerType SupportedContainer) ([]traefikRouter, error) {
+	out, err := outputCmd(exec.Command(string(containerType), "exec", "traefik", "wget", "-qO-", traefikAPIURL))
+	if err != nil {
+		return nil, fmt.Errorf("could not query the Traefik API: %v", err)
+	}
+	var routers []traefikRouter
+	if err := json.Unmarshal(out, &routers); err != nil {
+		return nil, fmt.Errorf("unexpected Traefik API response: %v", err)
+	}
+	return routers, nil
+}
+
+// routerConflicts reports installer routers for the dashboard host that are
+// broken, share a name with a router of another provider or can be shadowed
+// by a router with the same or a higher priority
+func routerConflicts(routers []traefikRouter, dashboardDomain string) []string {
+	hostRule := strings.ToLower("Host(`" + dashboardDomain + "`)")
+	var ours, others []traefikRouter
+	for _, router := range routers {
+		if !strings.Contains(strings.ToLower(router.Rule), hostRule) {
+			continue
+		}
+		if router.installerOwned() {
+			ours = append(ours, router)
+		} else {
+			others = append(others, router)
+		}
+	}
+
+	var conflicts []string
+	if len(ours) == 0 {
+		conflicts = append(conflicts, fmt.Sprintf("no installer router for %s is loaded, check config/traefik/dynamic_config.yml", dashboardDomain))
+	}
+	for _, router := range ours {
+		if router.Status != "" && router.Status != "enabled" {
+			conflicts = append(conflicts, fmt.Sprintf("%s is %s: %s", router.Name, router.Status, strings.Join(router.Errors, "; ")))
+		}
+	}
+	for _, other := range others {
+		for _, router := range ours {
+			if conflict := routerConflict(other, router); conflict != "" {
+				conflicts = append(conflicts, conflict)
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// routerConflict describes how other interferes with the installer router, or
+// returns "" when it does not
+func routerConflict(other, router traefikRouter) string {
+	switch {
+	case other.baseName() == router.baseName():
+		return fmt.Sprintf("%s has the same name as %s", other.Name, router.Name)
+	case !shareEntryPoint(other, router):
+		return ""
+	case other.Rule == router.Rule:
+		return fmt.Sprintf("%s duplicates the rule of %s", other.Name, router.Name)
+	case other.effectivePriority() >= router.effectivePriority():
+		return fmt.Sprintf("%s (priority %d) can shadow %s (priority %d)", other.Name, other.effectivePriority(), router.Name, router.effectivePriority())
+	}
+	return ""
+}
+
+func shareEntryPoint(a, b traefikRouter) bool {
+	for _, entryPoint := range a.EntryPoints {
+		if slices.Contains(b.EntryPoints, entryPoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyDashboardRouters checks the loaded routers for conflicts with the
+// dashboard host
+func verifyDashboardRouters(containerType SupportedContainer, dashboardDomain string) error {
+	infoln("\n=== Traefik Routers ===")
+	routers, err := fetchTraefikRouters(containerType)
+	if err != nil {
+		return err
+	}
+	conflicts := routerConflicts(routers, dashboardDomain)
+	for _, conflict := range conflicts {
+		errorf("%s\n", conflict)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("the routers for %s conflict, the dashboard may answer with 404", dashboardDomain)
+	}
+	infof("%d routers loaded, none conflict with the dashboard routers for %s.\n", len(routers), dashboardDomain)
+	return nil
+}
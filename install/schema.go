@@ -0,0 +1,957 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/dotenv"
+	"github.com/compose-spec/compose-go/v2/loader"
+	"github.com/compose-spec/compose-go/v2/types"
+	"gopkg.in/yaml.v3"
+)
+
+// templateError points at the part of a rendered template that docker
+// compose, Traefik or Pangolin would reject
+type templateError struct {
+	Template string
+	// Key is the dotted path of the offending key, "" when the file does not
+	// parse at all
+	Key     string
+	Line    int
+	Message string
+	// Template is "" for a file the user edited, Override is set when it
+	// came from --templates-dir
+	Override bool
+	content  []byte
+}
+
+func (e *templateError) Error() string {
+	var b strings.Builder
+	if e.Template != "" {
+		fmt.Fprintf(&b, "template %s", e.Template)
+		if e.Override {
+			b.WriteString(" (from --templates-dir)")
+		}
+		b.WriteString(" renders an invalid file")
+		if e.Key != "" {
+			b.WriteString(" at")
+		}
+	}
+	if e.Key != "" {
+		fmt.Fprintf(&b, " %s", e.Key)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " (line %d)", e.Line)
+	}
+	fmt.Fprintf(&b, ": %s", e.Message)
+	b.WriteString(renderSnippet(e.content, e.Line))
+	return strings.TrimPrefix(b.String(), " ")
+}
+
+// renderSnippet shows the rendered lines around line, marking it
+func renderSnippet(content []byte, line int) string {
+	if line <= 0 {
+		return ""
+	}
+	lines := strings.Split(string(content), "\n")
+	var b strings.Builder
+	for n := max(1, line-2); n <= min(len(lines), line+2); n++ {
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "\n  %s %4d | %s", marker, n, lines[n-1])
+	}
+	return b.String()
+}
+
+// fileSchema validates the rendered content of one template
+type fileSchema func(root *yaml.Node, content []byte) *templateError
+
+// templateSchemas are the schemas of the generated files by path. Partial
+// files that are merged into another one skip the checks of references to
+// services, middlewares and networks they do not define.
+var templateSchemas = map[string]fileSchema{
+	"config/docker-compose.yml":            func(root *yaml.Node, _ []byte) *templateError { return validateCompose(root, true) },
+	"config/crowdsec/docker-compose.yml":   func(root *yaml.Node, _ []byte) *templateError { return validateCompose(root, false) },
+	"config/config.yml":                    validatePangolinConfig,
+	"config/traefik/traefik_config.yml":    strictSchema[traefikStaticConfig](nil),
+	"config/crowdsec/traefik_config.yml":   strictSchema[traefikStaticConfig](nil),
+	"config/traefik/dynamic_config.yml":    strictSchema(checkDynamicReferences),
+	"config/crowdsec/dynamic_config.yml":   strictSchema[traefikDynamicConfig](nil),
+	"config/crowdsec/profiles.yaml":        nil,
+	"config/crowdsec/acquis.d/appsec.yaml": nil,
+}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+): `)
+
+// validateRenderedFiles checks every rendered YAML file against the schema of
+// the program reading it, so a broken template fails before anything is
+// written. overridden are the templates read from --templates-dir.
+func validateRenderedFiles(files []renderedFile, overridden []string) error {
+	var env []byte
+	if i := slices.IndexFunc(files, func(file renderedFile) bool { return file.Path == envFile }); i >= 0 {
+		env = files[i].Content
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Path, ".yml") && !strings.HasSuffix(file.Path, ".yaml") {
+			continue
+		}
+		err := checkRenderedFile(file.Path, file.Content)
+		if err == nil && file.Path == "config/docker-compose.yml" {
+			err = loadCompose(file.Content, env)
+		}
+		if err != nil {
+			err.Template = file.Path
+			err.Override = slices.Contains(overridden, file.Path)
+			err.content = file.Content
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRenderedFile checks content against the schema of the file at path
+func checkRenderedFile(path string, content []byte) *templateError {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return yamlError(err, nil)
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+	if schema := templateSchemas[path]; schema != nil {
+		return schema(&root, content)
+	}
+	return nil
+}
+
+// checkInstalledFiles checks the generated files of an install as read
+// returns them against their schema, one problem per invalid file. Files read
+// does not return are not checked.
+func checkInstalledFiles(read func(path string) ([]byte, bool)) []string {
+	var problems []string
+	for _, path := range []string{"docker-compose.yml", "config/config.yml", "config/traefik/traefik_config.yml", "config/traefik/dynamic_config.yml"} {
+		content, ok := read(path)
+		if !ok {
+			continue
+		}
+		schemaPath := path
+		if path == "docker-compose.yml" {
+			schemaPath = "config/docker-compose.yml"
+		}
+		err := checkRenderedFile(schemaPath, content)
+		if err == nil && path == "docker-compose.yml" {
+			env, _ := read(envFile)
+			err = loadCompose(content, env)
+		}
+		if err != nil {
+			err.content = content
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+	return problems
+}
+
+// loadCompose loads a complete compose file with the compose-go loader docker
+// compose is built on, interpolating the variables of env, the content of the
+// .env file. It catches what validateCompose does not model, such as the port,
+// duration and size syntax, depends_on conditions and unset variables. The
+// env_file and secret files are not read, they are written together with the
+// compose file.
+func loadCompose(content, env []byte) *templateError {
+	vars, err := dotenv.ParseWithLookup(bytes.NewReader(env), nil)
+	if err != nil {
+		return &templateError{Message: fmt.Sprintf("cannot read %s: %v", envFile, err)}
+	}
+	var unset []string
+	details := types.ConfigDetails{
+		WorkingDir:  ".",
+		ConfigFiles: []types.ConfigFile{{Filename: "docker-compose.yml", Content: content}},
+		Environment: vars,
+	}
+	_, err = loader.LoadWithContext(context.Background(), details, func(o *loader.Options) {
+		o.SetProjectName(installerStack, true)
+		o.SkipResolveEnvironment = true
+		o.SkipResolveLabels = true
+		// An unset variable fails here instead of a warning from compose
+		o.Interpolate.LookupValue = func(name string) (string, bool) {
+			value, ok := vars[name]
+			if !ok && !slices.Contains(unset, name) {
+				unset = append(unset, name)
+			}
+			return value, true
+		}
+	})
+	if err != nil {
+		return composeLoadError(content, err)
+	}
+	if len(unset) > 0 {
+		return &templateError{Message: fmt.Sprintf("%s does not set %s", envFile, strings.Join(unset, ", "))}
+	}
+	return nil
+}
+
+// composeKeyPattern finds the dotted key a compose-go error is about, after
+// composeIndexPattern turned services[name] into services.name
+var (
+	composeKeyPattern   = regexp.MustCompile(`\bservices(?:\.[\w-]+)+`)
+	composeIndexPattern = regexp.MustCompile(`\bservices\[([\w-]+)\]`)
+)
+
+// composeLoadError turns an error of the compose-go loader into a
+// templateError, at the deepest key of the error that content has
+func composeLoadError(content []byte, err error) *templateError {
+	msg := strings.Join(strings.Fields(err.Error()), " ")
+	msg = strings.TrimPrefix(msg, "validating docker-compose.yml: ")
+	msg = composeIndexPattern.ReplaceAllString(msg, "services.$1")
+	e := &templateError{Message: msg}
+	if m := yamlLinePattern.FindStringSubmatchIndex(msg); m != nil {
+		e.Line, _ = strconv.Atoi(msg[m[2]:m[3]])
+		e.Message = strings.TrimPrefix(msg[:m[0]]+msg[m[1]:], "yaml: ")
+		return e
+	}
+	var root yaml.Node
+	key := composeKeyPattern.FindString(msg)
+	if key == "" || yaml.Unmarshal(content, &root) != nil {
+		return e
+	}
+	for path := strings.Split(key, "."); len(path) > 0; path = path[:len(path)-1] {
+		if node := nodeAt(&root, path...); node != nil {
+			e.Key, e.Line = strings.Join(path, "."), node.Line
+			e.Message = strings.TrimPrefix(msg, key+" ")
+			break
+		}
+	}
+	return e
+}
+
+// yamlError turns a parse or decode error of yaml.v3 into a templateError,
+// with the key path found at its line in root
+func yamlError(err error, root *yaml.Node) *templateError {
+	msg := err.Error()
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		msg = typeErr.Errors[0]
+	}
+	msg = strings.TrimPrefix(msg, "yaml: ")
+	line := 0
+	if m := yamlLinePattern.FindStringSubmatchIndex(msg); m != nil {
+		line, _ = strconv.Atoi(msg[m[2]:m[3]])
+		msg = msg[:m[0]] + msg[m[1]:]
+	}
+	msg = unknownFieldPattern.ReplaceAllString(msg, "unknown key $1")
+	msg = strings.ReplaceAll(msg, " into main.", " into ")
+	e := &templateError{Line: line, Message: msg}
+	if root != nil {
+		e.Key = keyPathAt(root, line)
+	}
+	return e
+}
+
+var unknownFieldPattern = regexp.MustCompile(`field (\S+) not found in type .*$`)
+
+// keyPathAt returns the dotted path of the deepest key at or above line
+func keyPathAt(node *yaml.Node, line int) string {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			next := -1
+			if i+2 < len(node.Content) {
+				next = node.Content[i+2].Line
+			}
+			if line < key.Line || (next != -1 && line >= next) {
+				continue
+			}
+			if line == key.Line && value.Line == key.Line && value.Kind == yaml.ScalarNode {
+				return key.Value
+			}
+			if sub := keyPathAt(value, line); sub != "" {
+				if strings.HasPrefix(sub, "[") {
+					return key.Value + sub
+				}
+				return key.Value + "." + sub
+			}
+			return key.Value
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			next := -1
+			if i+1 < len(node.Content) {
+				next = node.Content[i+1].Line
+			}
+			if line < item.Line || (next != -1 && line >= next) {
+				continue
+			}
+			index := fmt.Sprintf("[%d]", i)
+			if sub := keyPathAt(item, line); sub != "" {
+				if strings.HasPrefix(sub, "[") {
+					return index + sub
+				}
+				return index + "." + sub
+			}
+			return index
+		}
+	}
+	return ""
+}
+
+// strictSchema decodes the file into T, rejecting unknown keys and values of
+// the wrong type, then runs check on the result when it is not nil
+func strictSchema[T any](check func(root *yaml.Node, value *T) *templateError) fileSchema {
+	return func(root *yaml.Node, content []byte) *templateError {
+		var value T
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&value); err != nil {
+			return yamlError(err, root)
+		}
+		if check != nil {
+			return check(root, &value)
+		}
+		return nil
+	}
+}
+
+// nodeAt returns the value at the dotted path in root, nil when it is missing
+func nodeAt(root *yaml.Node, path ...string) *yaml.Node {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, key := range path {
+		node = yamlMapValue(node, key)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// keyError reports a problem with the value at path
+func keyError(root *yaml.Node, format string, path []string, a ...any) *templateError {
+	e := &templateError{Key: strings.Join(path, "."), Message: fmt.Sprintf(format, a...)}
+	if node := nodeAt(root, path...); node != nil {
+		e.Line = node.Line
+	}
+	return e
+}
+
+// pangolinConfig mirrors the schema Pangolin validates config.yml with, plus
+// the sections only the Enterprise Edition reads
+type pangolinConfig struct {
+	App struct {
+		DashboardURL      string    `yaml:"dashboard_url"`
+		LogLevel          string    `yaml:"log_level"`
+		SaveLogs          bool      `yaml:"save_logs"`
+		LogFailedAttempts bool      `yaml:"log_failed_attempts"`
+		Telemetry         yaml.Node `yaml:"telemetry"`
+		Notifications     yaml.Node `yaml:"notifications"`
+	} `yaml:"app"`
+	Domains map[string]struct {
+		BaseDomain         string `yaml:"base_domain"`
+		CertResolver       string `yaml:"cert_resolver"`
+		PreferWildcardCert bool   `yaml:"prefer_wildcard_cert"`
+	} `yaml:"domains"`
+	Server struct {
+		IntegrationPort             int       `yaml:"integration_port"`
+		ExternalPort                int       `yaml:"external_port"`
+		InternalPort                int       `yaml:"internal_port"`
+		NextPort                    int       `yaml:"next_port"`
+		BadgerOverride              string    `yaml:"badger_override"`
+		InternalHostname            string    `yaml:"internal_hostname"`
+		SessionCookieName           string    `yaml:"session_cookie_name"`
+		ResourceAccessTokenParam    string    `yaml:"resource_access_token_param"`
+		ResourceAccessTokenHeaders  yaml.Node `yaml:"resource_access_token_headers"`
+		ResourceSessionRequestParam string    `yaml:"resource_session_request_param"`
+		DashboardSessionLengthHours float64   `yaml:"dashboard_session_length_hours"`
+		ResourceSessionLengthHours  float64   `yaml:"resource_session_length_hours"`
+		TrustProxy                  int       `yaml:"trust_proxy"`
+		Secret                      string    `yaml:"secret"`
+		MaxMindDBPath               string    `yaml:"maxmind_db_path"`
+		MaxMindASNPath              string    `yaml:"maxmind_asn_path"`
+		CORS                        struct {
+			Origins        []string `yaml:"origins"`
+			Methods        []string `yaml:"methods"`
+			AllowedHeaders []string `yaml:"allowed_headers"`
+			Credentials    bool     `yaml:"credentials"`
+		} `yaml:"cors"`
+	} `yaml:"server"`
+	Postgres     yaml.Node `yaml:"postgres"`
+	PostgresLogs yaml.Node `yaml:"postgres_logs"`
+	Redis        struct {
+		Host     string    `yaml:"host"`
+		Port     int       `yaml:"port"`
+		Password string    `yaml:"password"`
+		DB       int       `yaml:"db"`
+		TLS      yaml.Node `yaml:"tls"`
+	} `yaml:"redis"`
+	Traefik yaml.Node `yaml:"traefik"`
+	Gerbil  struct {
+		ExitNodeName     string `yaml:"exit_node_name"`
+		StartPort        int    `yaml:"start_port"`
+		ClientsStartPort int    `yaml:"clients_start_port"`
+		BaseEndpoint     string `yaml:"base_endpoint"`
+		UseSubdomain     bool   `yaml:"use_subdomain"`
+		SubnetGroup      string `yaml:"subnet_group"`
+		BlockSize        int    `yaml:"block_size"`
+		SiteBlockSize    int    `yaml:"site_block_size"`
+	} `yaml:"gerbil"`
+	Orgs       yaml.Node `yaml:"orgs"`
+	RateLimits yaml.Node `yaml:"rate_limits"`
+	Email      struct {
+		SMTPHost                  string `yaml:"smtp_host"`
+		SMTPPort                  int    `yaml:"smtp_port"`
+		SMTPUser                  string `yaml:"smtp_user"`
+		SMTPPass                  string `yaml:"smtp_pass"`
+		SMTPSecure                bool   `yaml:"smtp_secure"`
+		SMTPTLSRejectUnauthorized bool   `yaml:"smtp_tls_reject_unauthorized"`
+		NoReply                   string `yaml:"no_reply"`
+	} `yaml:"email"`
+	Flags             map[string]bool `yaml:"flags"`
+	DNS               yaml.Node       `yaml:"dns"`
+	IdentityProviders []struct {
+		Name             string `yaml:"name"`
+		Type             string `yaml:"type"`
+		Issuer           string `yaml:"issuer"`
+		ClientID         string `yaml:"client_id"`
+		ClientSecret     string `yaml:"client_secret"`
+		AuthorizationURL string `yaml:"authorization_url"`
+		TokenURL         string `yaml:"token_url"`
+		Scopes           string `yaml:"scopes"`
+		IdentifierPath   string `yaml:"identifier_path"`
+		EmailPath        string `yaml:"email_path"`
+		NamePath         string `yaml:"name_path"`
+	} `yaml:"identity_providers"`
+}
+
+var pangolinLogLevels = []string{"debug", "info", "warn", "error"}
+
+// validatePangolinConfig also applies the refinements Pangolin checks on
+// start: a dashboard URL, a server secret of 8 characters when set and at
+// least one domain
+func validatePangolinConfig(root *yaml.Node, content []byte) *templateError {
+	return strictSchema(func(root *yaml.Node, config *pangolinConfig) *templateError {
+		switch {
+		case config.App.DashboardURL == "":
+			return keyError(root, "the dashboard URL must be set", []string{"app", "dashboard_url"})
+		case config.App.LogLevel != "" && !slices.Contains(pangolinLogLevels, config.App.LogLevel):
+			return keyError(root, "%q is not one of %s", []string{"app", "log_level"}, config.App.LogLevel, strings.Join(pangolinLogLevels, ", "))
+		case config.Server.Secret != "" && len(config.Server.Secret) < 8:
+			// Without a secret Pangolin reads SERVER_SECRET from its environment
+			return keyError(root, "the server secret must be at least 8 characters", []string{"server", "secret"})
+		case len(config.Domains) == 0 && !config.Flags["disable_config_managed_domains"]:
+			return keyError(root, "at least one domain must be defined", []string{"domains"})
+		}
+		for name, domain := range config.Domains {
+			if domain.BaseDomain == "" {
+				return keyError(root, "base_domain must not be empty", []string{"domains", name, "base_domain"})
+			}
+		}
+		return nil
+	})(root, content)
+}
+
+// traefikStaticConfig lists the sections of Traefik's static configuration,
+// which Traefik refuses to start with unknown keys in. The sections the
+// installer writes are typed.
+type traefikStaticConfig struct {
+	Global              yaml.Node `yaml:"global"`
+	ServersTransport    yaml.Node `yaml:"serversTransport"`
+	TCPServersTransport yaml.Node `yaml:"tcpServersTransport"`
+	EntryPoints         map[string]struct {
+		Address          string    `yaml:"address"`
+		AllowACMEByPass  bool      `yaml:"allowACMEByPass"`
+		ReusePort        bool      `yaml:"reusePort"`
+		AsDefault        bool      `yaml:"asDefault"`
+		Transport        yaml.Node `yaml:"transport"`
+		ProxyProtocol    yaml.Node `yaml:"proxyProtocol"`
+		ForwardedHeaders yaml.Node `yaml:"forwardedHeaders"`
+		HTTP             struct {
+			Redirections          yaml.Node `yaml:"redirections"`
+			Middlewares           []string  `yaml:"middlewares"`
+			TLS                   yaml.Node `yaml:"tls"`
+			EncodeQuerySemicolons bool      `yaml:"encodeQuerySemicolons"`
+			EncodedCharacters     yaml.Node `yaml:"encodedCharacters"`
+			SanitizePath          *bool     `yaml:"sanitizePath"`
+			MaxHeaderBytes        int       `yaml:"maxHeaderBytes"`
+		} `yaml:"http"`
+		HTTP2 yaml.Node `yaml:"http2"`
+		HTTP3 struct {
+			AdvertisedPort int `yaml:"advertisedPort"`
+		} `yaml:"http3"`
+		UDP           yaml.Node `yaml:"udp"`
+		Observability yaml.Node `yaml:"observability"`
+	} `yaml:"entryPoints"`
+	Providers struct {
+		ProvidersThrottleDuration string `yaml:"providersThrottleDuration"`
+		HTTP                      struct {
+			Endpoint     string    `yaml:"endpoint"`
+			PollInterval string    `yaml:"pollInterval"`
+			PollTimeout  string    `yaml:"pollTimeout"`
+			Headers      yaml.Node `yaml:"headers"`
+			TLS          yaml.Node `yaml:"tls"`
+		} `yaml:"http"`
+		File struct {
+			Directory                 string `yaml:"directory"`
+			Watch                     *bool  `yaml:"watch"`
+			Filename                  string `yaml:"filename"`
+			DebugLogGeneratedTemplate bool   `yaml:"debugLogGeneratedTemplate"`
+		} `yaml:"file"`
+		Docker yaml.Node `yaml:"docker"`
+		Swarm  yaml.Node `yaml:"swarm"`
+		Plugin yaml.Node `yaml:"plugin"`
+		Redis  yaml.Node `yaml:"redis"`
+		Rest   yaml.Node `yaml:"rest"`
+	} `yaml:"providers"`
+	API struct {
+		Insecure           bool   `yaml:"insecure"`
+		Dashboard          bool   `yaml:"dashboard"`
+		Debug              bool   `yaml:"debug"`
+		DisableDashboardAd bool   `yaml:"disableDashboardAd"`
+		BasePath           string `yaml:"basePath"`
+	} `yaml:"api"`
+	Metrics yaml.Node `yaml:"metrics"`
+	Ping    struct {
+		EntryPoint            string `yaml:"entryPoint"`
+		ManualRouting         bool   `yaml:"manualRouting"`
+		TerminatingStatusCode int    `yaml:"terminatingStatusCode"`
+	} `yaml:"ping"`
+	Log struct {
+		Level      string `yaml:"level"`
+		Format     string `yaml:"format"`
+		NoColor    bool   `yaml:"noColor"`
+		FilePath   string `yaml:"filePath"`
+		MaxSize    int    `yaml:"maxSize"`
+		MaxAge     int    `yaml:"maxAge"`
+		MaxBackups int    `yaml:"maxBackups"`
+		Compress   bool   `yaml:"compress"`
+	} `yaml:"log"`
+	AccessLog             yaml.Node `yaml:"accessLog"`
+	Tracing               yaml.Node `yaml:"tracing"`
+	HostResolver          yaml.Node `yaml:"hostResolver"`
+	CertificatesResolvers map[string]struct {
+		ACME struct {
+			Email                       string    `yaml:"email"`
+			CAServer                    string    `yaml:"caServer"`
+			CACertificates              []string  `yaml:"caCertificates"`
+			CASystemCertPool            bool      `yaml:"caSystemCertPool"`
+			CAServerName                string    `yaml:"caServerName"`
+			PreferredChain              string    `yaml:"preferredChain"`
+			Profile                     string    `yaml:"profile"`
+			EmailAddresses              []string  `yaml:"emailAddresses"`
+			DisableCommonName           bool      `yaml:"disableCommonName"`
+			Storage                     string    `yaml:"storage"`
+			KeyType                     string    `yaml:"keyType"`
+			EAB                         yaml.Node `yaml:"eab"`
+			CertificatesDuration        int       `yaml:"certificatesDuration"`
+			ClientTimeout               string    `yaml:"clientTimeout"`
+			ClientResponseHeaderTimeout string    `yaml:"clientResponseHeaderTimeout"`
+			DNSChallenge                *struct {
+				Provider                string    `yaml:"provider"`
+				Resolvers               []string  `yaml:"resolvers"`
+				DelayBeforeCheck        string    `yaml:"delayBeforeCheck"`
+				DisablePropagationCheck bool      `yaml:"disablePropagationCheck"`
+				Propagation             yaml.Node `yaml:"propagation"`
+			} `yaml:"dnsChallenge"`
+			HTTPChallenge *struct {
+				EntryPoint string `yaml:"entryPoint"`
+				Delay      string `yaml:"delay"`
+			} `yaml:"httpChallenge"`
+			TLSChallenge yaml.Node `yaml:"tlsChallenge"`
+		} `yaml:"acme"`
+		Tailscale yaml.Node `yaml:"tailscale"`
+	} `yaml:"certificatesResolvers"`
+	Experimental struct {
+		Plugins map[string]struct {
+			ModuleName string    `yaml:"moduleName"`
+			Version    string    `yaml:"version"`
+			Settings   yaml.Node `yaml:"settings"`
+			Hash       string    `yaml:"hash"`
+		} `yaml:"plugins"`
+		LocalPlugins           yaml.Node `yaml:"localPlugins"`
+		AbortOnPluginFailure   bool      `yaml:"abortOnPluginFailure"`
+		FastProxy              yaml.Node `yaml:"fastProxy"`
+		OTLPLogs               bool      `yaml:"otlpLogs"`
+		KubernetesGateway      bool      `yaml:"kubernetesGateway"`
+		KubernetesIngressNGINX bool      `yaml:"kubernetesIngressNGINX"`
+	} `yaml:"experimental"`
+	Core   yaml.Node `yaml:"core"`
+	Spiffe yaml.Node `yaml:"spiffe"`
+	OCSP   yaml.Node `yaml:"ocsp"`
+}
+
+// traefikRouterConfig is a router of the file provider
+type traefikRouterConfig struct {
+	Rule          string    `yaml:"rule"`
+	RuleSyntax    string    `yaml:"ruleSyntax"`
+	EntryPoints   []string  `yaml:"entryPoints"`
+	Middlewares   []string  `yaml:"middlewares"`
+	Service       string    `yaml:"service"`
+	Priority      int       `yaml:"priority"`
+	TLS           yaml.Node `yaml:"tls"`
+	Observability yaml.Node `yaml:"observability"`
+}
+
+// traefikDynamicConfig is the dynamic configuration of the file provider.
+// Middlewares and services are checked for their shape only, the options of
+// the plugins are free form.
+type traefikDynamicConfig struct {
+	HTTP struct {
+		Routers           map[string]traefikRouterConfig  `yaml:"routers"`
+		Middlewares       map[string]map[string]yaml.Node `yaml:"middlewares"`
+		Services          map[string]map[string]yaml.Node `yaml:"services"`
+		ServersTransports map[string]yaml.Node            `yaml:"serversTransports"`
+	} `yaml:"http"`
+	TCP struct {
+		Routers map[string]struct {
+			Rule        string    `yaml:"rule"`
+			RuleSyntax  string    `yaml:"ruleSyntax"`
+			EntryPoints []string  `yaml:"entryPoints"`
+			Middlewares []string  `yaml:"middlewares"`
+			Service     string    `yaml:"service"`
+			Priority    int       `yaml:"priority"`
+			TLS         yaml.Node `yaml:"tls"`
+		} `yaml:"routers"`
+		Middlewares       map[string]map[string]yaml.Node `yaml:"middlewares"`
+		Services          map[string]map[string]yaml.Node `yaml:"services"`
+		ServersTransports map[string]yaml.Node            `yaml:"serversTransports"`
+	} `yaml:"tcp"`
+	UDP yaml.Node `yaml:"udp"`
+	TLS yaml.Node `yaml:"tls"`
+}
+
+// checkDynamicReferences rejects routers pointing at middlewares or services
+// the file does not define. Names of other providers carry an @ suffix.
+func checkDynamicReferences(root *yaml.Node, config *traefikDynamicConfig) *templateError {
+	for _, section := range []struct {
+		name        string
+		middlewares map[string]map[string]yaml.Node
+		services    map[string]map[string]yaml.Node
+	}{
+		{"http", config.HTTP.Middlewares, config.HTTP.Services},
+		{"tcp", config.TCP.Middlewares, config.TCP.Services},
+	} {
+		for _, kind := range []string{"middlewares", "services"} {
+			defined := section.middlewares
+			if kind == "services" {
+				defined = section.services
+			}
+			for name, value := range defined {
+				if len(value) != 1 {
+					return keyError(root, "%s must have exactly one type, it has %d", []string{section.name, kind, name}, name, len(value))
+				}
+			}
+		}
+	}
+	for name, router := range config.HTTP.Routers {
+		if err := checkRouterReferences(root, "http", name, router.Middlewares, router.Service, config.HTTP.Middlewares, config.HTTP.Services); err != nil {
+			return err
+		}
+	}
+	for name, router := range config.TCP.Routers {
+		if err := checkRouterReferences(root, "tcp", name, router.Middlewares, router.Service, config.TCP.Middlewares, config.TCP.Services); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkRouterReferences(root *yaml.Node, section, router string, middlewares []string, service string, definedMiddlewares, definedServices map[string]map[string]yaml.Node) *templateError {
+	path := []string{section, "routers", router}
+	for _, middleware := range middlewares {
+		if _, ok := definedMiddlewares[middleware]; !ok && !strings.Contains(middleware, "@") {
+			return keyError(root, "middleware %q is not defined", append(path, "middlewares"), middleware)
+		}
+	}
+	if service == "" {
+		return keyError(root, "the router has no service", path)
+	}
+	if _, ok := definedServices[service]; !ok && !strings.Contains(service, "@") {
+		return keyError(root, "service %q is not defined", append(path, "service"), service)
+	}
+	return nil
+}
+
+// composeTopLevelKeys are the sections of a compose file
+var composeTopLevelKeys = []string{"version", "name", "services", "networks", "volumes", "secrets", "configs", "include", "models"}
+
+// composeShape is the YAML shape compose expects for the value of a key
+type composeShape int
+
+const (
+	shapeAny composeShape = iota
+	shapeScalar
+	shapeBool
+	shapeMapping
+	// shapeStrings is a sequence of scalars
+	shapeStrings
+	// shapeStringOrList is a scalar or a sequence of scalars
+	shapeStringOrList
+	// shapeMapOrList is a mapping of scalars or a sequence of scalars
+	shapeMapOrList
+	// shapeEntries is a sequence of scalars (short syntax) or mappings (long
+	// syntax)
+	shapeEntries
+)
+
+// composeServiceKeys are the keys of a service in the compose specification
+var composeServiceKeys = map[string]composeShape{
+	"annotations": shapeMapOrList, "attach": shapeBool, "blkio_config": shapeMapping, "build": shapeAny,
+	"cap_add": shapeStrings, "cap_drop": shapeStrings, "cgroup": shapeScalar, "cgroup_parent": shapeScalar,
+	"command": shapeStringOrList, "configs": shapeEntries, "container_name": shapeScalar,
+	"cpu_count": shapeScalar, "cpu_percent": shapeScalar, "cpu_period": shapeScalar, "cpu_quota": shapeScalar,
+	"cpu_rt_period": shapeScalar, "cpu_rt_runtime": shapeScalar, "cpu_shares": shapeScalar, "cpus": shapeScalar,
+	"cpuset": shapeScalar, "credential_spec": shapeMapping, "depends_on": shapeAny, "deploy": shapeMapping,
+	"develop": shapeMapping, "device_cgroup_rules": shapeStrings, "devices": shapeEntries,
+	"dns": shapeStringOrList, "dns_opt": shapeStrings, "dns_search": shapeStringOrList, "domainname": shapeScalar,
+	"driver_opts": shapeMapping, "entrypoint": shapeStringOrList, "env_file": shapeAny,
+	"environment": shapeMapOrList, "expose": shapeStrings, "extends": shapeAny, "external_links": shapeStrings,
+	"extra_hosts": shapeMapOrList, "gpus": shapeAny, "group_add": shapeStrings, "healthcheck": shapeMapping,
+	"hostname": shapeScalar, "image": shapeScalar, "init": shapeBool, "ipc": shapeScalar, "isolation": shapeScalar,
+	"labels": shapeMapOrList, "label_file": shapeStringOrList, "links": shapeStrings, "logging": shapeMapping,
+	"mac_address": shapeScalar, "mem_limit": shapeScalar, "mem_reservation": shapeScalar,
+	"mem_swappiness": shapeScalar, "memswap_limit": shapeScalar, "models": shapeAny, "network_mode": shapeScalar,
+	"networks": shapeAny, "oom_kill_disable": shapeBool, "oom_score_adj": shapeScalar, "pid": shapeScalar,
+	"pids_limit": shapeScalar, "platform": shapeScalar, "ports": shapeEntries, "post_start": shapeEntries,
+	"pre_stop": shapeEntries, "privileged": shapeBool, "profiles": shapeStrings, "provider": shapeMapping,
+	"pull_policy": shapeScalar, "read_only": shapeBool, "restart": shapeScalar, "runtime": shapeScalar,
+	"scale": shapeScalar, "secrets": shapeEntries, "security_opt": shapeStrings, "shm_size": shapeScalar,
+	"stdin_open": shapeBool, "stop_grace_period": shapeScalar, "stop_signal": shapeScalar,
+	"storage_opt": shapeMapping, "sysctls": shapeMapOrList, "tmpfs": shapeStringOrList, "tty": shapeBool,
+	"ulimits": shapeMapping, "use_api_socket": shapeBool, "user": shapeScalar, "userns_mode": shapeScalar,
+	"uts": shapeScalar, "volumes": shapeEntries, "volumes_from": shapeStrings, "working_dir": shapeScalar,
+}
+
+var composeHealthcheckKeys = []string{"test", "interval", "timeout", "retries", "start_period", "start_interval", "disable"}
+
+var composeDependsOnConditions = []string{"service_started", "service_healthy", "service_completed_successfully"}
+
+var composeRestartPattern = regexp.MustCompile(`^(no|always|unless-stopped|on-failure(:\d+)?)$`)
+
+// composePortPattern matches the short syntax [host_ip:][host_port:]container_port[/protocol]
+var composePortPattern = regexp.MustCompile(`^((\[[0-9a-fA-F:.]+\]|\d+\.\d+\.\d+\.\d+):)?(\d+(-\d+)?:)?\d+(-\d+)?(/(tcp|udp|sctp))?$`)
+
+// validateCompose checks the shape of a compose file, and with references
+// also that services, networks and secrets they name are defined
+func validateCompose(root *yaml.Node, references bool) *templateError {
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return &templateError{Line: doc.Line, Message: "a compose file must be a mapping"}
+	}
+	for i := 0; i < len(doc.Content); i += 2 {
+		key := doc.Content[i]
+		if !slices.Contains(composeTopLevelKeys, key.Value) && !strings.HasPrefix(key.Value, "x-") {
+			return &templateError{Key: key.Value, Line: key.Line, Message: fmt.Sprintf("unknown top-level key %q", key.Value)}
+		}
+	}
+	services := yamlMapValue(doc, "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return keyError(root, "a compose file needs a services mapping", []string{"services"})
+	}
+
+	containerNames := map[string]string{}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name, service := services.Content[i].Value, services.Content[i+1]
+		path := []string{"services", name}
+		if service.Kind != yaml.MappingNode {
+			return keyError(root, "a service must be a mapping", path)
+		}
+		for j := 0; j+1 < len(service.Content); j += 2 {
+			key, value := service.Content[j], service.Content[j+1]
+			shape, known := composeServiceKeys[key.Value]
+			if !known && !strings.HasPrefix(key.Value, "x-") {
+				return &templateError{Key: strings.Join(append(path, key.Value), "."), Line: key.Line, Message: fmt.Sprintf("unknown service key %q", key.Value)}
+			}
+			if err := checkComposeShape(value, shape); err != "" {
+				return &templateError{Key: strings.Join(append(path, key.Value), "."), Line: value.Line, Message: err}
+			}
+		}
+		if err := checkComposeService(root, doc, path, service, references); err != nil {
+			return err
+		}
+		if container := yamlMapValue(service, "container_name"); container != nil {
+			if other, ok := containerNames[container.Value]; ok {
+				return keyError(root, "container name %q is also used by service %s", append(path, "container_name"), container.Value, other)
+			}
+			containerNames[container.Value] = name
+		}
+	}
+	return nil
+}
+
+// checkComposeShape returns why value does not have shape, "" when it does
+func checkComposeShape(value *yaml.Node, shape composeShape) string {
+	scalars := func(node *yaml.Node) bool {
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return false
+			}
+		}
+		return true
+	}
+	switch shape {
+	case shapeScalar:
+		if value.Kind != yaml.ScalarNode {
+			return "expected a single value"
+		}
+	case shapeBool:
+		if value.Kind != yaml.ScalarNode || value.Tag != "!!bool" {
+			return fmt.Sprintf("expected true or false, got %q", value.Value)
+		}
+	case shapeMapping:
+		if value.Kind != yaml.MappingNode {
+			return "expected a mapping"
+		}
+	case shapeStrings:
+		if value.Kind != yaml.SequenceNode || !scalars(value) {
+			return "expected a list of values"
+		}
+	case shapeStringOrList:
+		if value.Kind != yaml.ScalarNode && (value.Kind != yaml.SequenceNode || !scalars(value)) {
+			return "expected a value or a list of values"
+		}
+	case shapeMapOrList:
+		switch value.Kind {
+		case yaml.SequenceNode:
+			if !scalars(value) {
+				return "expected a list of KEY=VALUE entries"
+			}
+		case yaml.MappingNode:
+			for i := 1; i < len(value.Content); i += 2 {
+				if value.Content[i].Kind != yaml.ScalarNode {
+					return fmt.Sprintf("the value of %s must be a single value", value.Content[i-1].Value)
+				}
+			}
+		default:
+			return "expected a mapping or a list of KEY=VALUE entries"
+		}
+	case shapeEntries:
+		if value.Kind != yaml.SequenceNode {
+			return "expected a list"
+		}
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode && item.Kind != yaml.MappingNode {
+				return "expected a list of values or mappings"
+			}
+		}
+	}
+	return ""
+}
+
+// checkComposeService validates the values of a service that compose parses
+// further: restart policy, ports, health check, logging and the services,
+// networks and secrets it refers to
+func checkComposeService(root, doc *yaml.Node, path []string, service *yaml.Node, references bool) *templateError {
+	at := func(key ...string) []string { return append(slices.Clone(path), key...) }
+
+	if restart := yamlMapValue(service, "restart"); restart != nil && !composeRestartPattern.MatchString(restart.Value) {
+		return keyError(root, "%q is not a restart policy (no, always, unless-stopped or on-failure[:N])", at("restart"), restart.Value)
+	}
+	if ports := yamlMapValue(service, "ports"); ports != nil {
+		for _, port := range ports.Content {
+			if port.Kind == yaml.ScalarNode && !composePortPattern.MatchString(port.Value) {
+				return &templateError{Key: strings.Join(at("ports"), "."), Line: port.Line, Message: fmt.Sprintf("%q is not a port mapping like 443:443 or 51820:51820/udp", port.Value)}
+			}
+		}
+	}
+	if healthcheck := yamlMapValue(service, "healthcheck"); healthcheck != nil {
+		for i := 0; i+1 < len(healthcheck.Content); i += 2 {
+			key, value := healthcheck.Content[i], healthcheck.Content[i+1]
+			if !slices.Contains(composeHealthcheckKeys, key.Value) {
+				return &templateError{Key: strings.Join(at("healthcheck", key.Value), "."), Line: key.Line, Message: fmt.Sprintf("unknown health check key %q", key.Value)}
+			}
+			if key.Value == "retries" && value.Tag != "!!int" {
+				return &templateError{Key: strings.Join(at("healthcheck", "retries"), "."), Line: value.Line, Message: fmt.Sprintf("retries must be a number, got %q", value.Value)}
+			}
+		}
+	}
+	if logging := yamlMapValue(service, "logging"); logging != nil {
+		for i := 0; i+1 < len(logging.Content); i += 2 {
+			if key := logging.Content[i]; key.Value != "driver" && key.Value != "options" {
+				return &templateError{Key: strings.Join(at("logging", key.Value), "."), Line: key.Line, Message: fmt.Sprintf("unknown logging key %q", key.Value)}
+			}
+		}
+	}
+
+	networkMode := yamlMapValue(service, "network_mode")
+	if networkMode != nil {
+		shared := strings.HasPrefix(networkMode.Value, "service:") || strings.HasPrefix(networkMode.Value, "container:")
+		if shared && yamlMapValue(service, "ports") != nil {
+			return keyError(root, "ports cannot be published from a service sharing the network of %s, publish them there", at("ports"), networkMode.Value)
+		}
+		if yamlMapValue(service, "networks") != nil {
+			return keyError(root, "network_mode and networks are mutually exclusive", at("networks"))
+		}
+	}
+	if !references {
+		return nil
+	}
+
+	services := yamlMapValue(doc, "services")
+	if networkMode != nil {
+		if target, ok := strings.CutPrefix(networkMode.Value, "service:"); ok && yamlMapValue(services, target) == nil {
+			return keyError(root, "service %q is not defined", at("network_mode"), target)
+		}
+	}
+	if dependsOn := yamlMapValue(service, "depends_on"); dependsOn != nil {
+		for _, dependency := range composeNames(dependsOn) {
+			if yamlMapValue(services, dependency.Value) == nil {
+				return &templateError{Key: strings.Join(at("depends_on"), "."), Line: dependency.Line, Message: fmt.Sprintf("service %q is not defined", dependency.Value)}
+			}
+			if dependsOn.Kind != yaml.MappingNode {
+				continue
+			}
+			if condition := yamlMapValue(yamlMapValue(dependsOn, dependency.Value), "condition"); condition != nil && !slices.Contains(composeDependsOnConditions, condition.Value) {
+				return &templateError{Key: strings.Join(at("depends_on", dependency.Value, "condition"), "."), Line: condition.Line, Message: fmt.Sprintf("%q is not one of %s", condition.Value, strings.Join(composeDependsOnConditions, ", "))}
+			}
+		}
+	}
+	if networks := yamlMapValue(service, "networks"); networks != nil {
+		for _, network := range composeNames(networks) {
+			if network.Value != "default" && yamlMapValue(yamlMapValue(doc, "networks"), network.Value) == nil {
+				return &templateError{Key: strings.Join(at("networks"), "."), Line: network.Line, Message: fmt.Sprintf("network %q is not defined in the top-level networks", network.Value)}
+			}
+		}
+	}
+	if secrets := yamlMapValue(service, "secrets"); secrets != nil {
+		for _, secret := range secrets.Content {
+			name := secret
+			if secret.Kind == yaml.MappingNode {
+				if name = yamlMapValue(secret, "source"); name == nil {
+					continue
+				}
+			}
+			if yamlMapValue(yamlMapValue(doc, "secrets"), name.Value) == nil {
+				return &templateError{Key: strings.Join(at("secrets"), "."), Line: name.Line, Message: fmt.Sprintf("secret %q is not defined in the top-level secrets", name.Value)}
+			}
+		}
+	}
+	return nil
+}
+
+// composeNames are the names in a list or the keys of a mapping, the two
+// syntaxes of depends_on and networks
+func composeNames(node *yaml.Node) []*yaml.Node {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return node.Content
+	case yaml.MappingNode:
+		var keys []*yaml.Node
+		for i := 0; i < len(node.Content); i += 2 {
+			keys = append(keys, node.Content[i])
+		}
+		return keys
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLoadCompose checks that the compose-go loader rejects compose files the
+// yaml.Node schema of validateCompose lets through
+func TestLoadCompose(t *testing.T) {
+	const service = "services:\n  pangolin:\n    image: fosrl/pangolin:1.12.0\n"
+	tests := []struct {
+		name    string
+		compose string
+		env     string
+		want    string
+	}{
+		{"valid", service + "    ports:\n      - 127.0.0.1:3001:3001\n", "", ""},
+		{"interpolated", service + "    environment:\n      SECRET: ${SECRET}\n", "SECRET='s3cret'\n", ""},
+		{"unset variable", service + "    environment:\n      SECRET: ${SECRET}\n", "OTHER=1\n", ".env does not set SECRET"},
+		{"bad interpolation", service + "    environment:\n      SECRET: ${SECRET\n", "", "invalid interpolation"},
+		{"port syntax", service + "    ports:\n      - 80:80:80:80\n", "", "Invalid ip address"},
+		{"depends_on condition", service + "    depends_on:\n      gerbil:\n        condition: service_ready\n  gerbil:\n    image: fosrl/gerbil:1.2.1\n", "", "condition"},
+		{"depends_on unknown service", service + "    depends_on:\n      - gerbil\n", "", "gerbil"},
+		{"duration", service + "    healthcheck:\n      test: [\"CMD\", \"true\"]\n      interval: soon\n", "", "interval"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadCompose([]byte(tt.compose), []byte(tt.env))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("rejected: %v", err)
+			case tt.want != "" && err == nil:
+				t.Errorf("accepted, want an error about %q", tt.want)
+			case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoadComposeKey checks that a loader error points at the key and line
+func TestLoadComposeKey(t *testing.T) {
+	compose := "services:\n  pangolin:\n    image: fosrl/pangolin:1.12.0\n    healthcheck:\n      test: [\"CMD\", \"true\"]\n      interval: soon\n"
+	err := loadCompose([]byte(compose), nil)
+	if err == nil || err.Key != "services.pangolin.healthcheck.interval" || err.Line != 6 {
+		t.Errorf("got %+v, want services.pangolin.healthcheck.interval at line 6", err)
+	}
+}
+
+// TestValidateRenderedCompose checks that the rendered compose file is loaded
+// with the variables of the rendered .env
+func TestValidateRenderedCompose(t *testing.T) {
+	compose := renderedFile{Path: "config/docker-compose.yml", Content: []byte("services:\n  pangolin:\n    image: fosrl/pangolin:1.12.0\n    environment:\n      SECRET: ${SECRET}\n")}
+	if err := validateRenderedFiles([]renderedFile{compose, {Path: envFile, Content: []byte("SECRET='s3cret'\n")}}, nil); err != nil {
+		t.Errorf("rejected with the variable in .env: %v", err)
+	}
+	err := validateRenderedFiles([]renderedFile{compose}, nil)
+	if err == nil || !strings.Contains(err.Error(), "template config/docker-compose.yml") {
+		t.Errorf("error %v, want one naming the template", err)
+	}
+}
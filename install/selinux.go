@@ -0,0 +1,263 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SELinux handling modes accepted by --selinux
+const (
+	selinuxIgnore = "ignore"
+	selinuxLabel  = "label"
+	selinuxChcon  = "chcon"
+)
+
+// resourceSELinuxContext is a persistent file context rule added with semanage
+const resourceSELinuxContext = "selinux-fcontext"
+
+var (
+	// selinuxFlag is the value of --selinux, empty means ask when enforcing
+	selinuxFlag string
+	// selinuxMode is resolved by checkSELinux during pre-flight
+	selinuxMode = selinuxIgnore
+)
+
+func addSELinuxFlag(fs *flag.FlagSet) {
+	fs.Func("selinux", "How to handle SELinux when it is enforcing: ignore, label (add :z/:Z to the volume mounts) or chcon (relabel the install directory)", func(value string) error {
+		switch value {
+		case selinuxIgnore, selinuxLabel, selinuxChcon:
+			selinuxFlag = value
+			return nil
+		}
+		return fmt.Errorf("expected ignore, label or chcon")
+	})
+}
+
+// selinuxEnforcing reports whether getenforce says SELinux is enforcing
+func selinuxEnforcing() bool {
+	return facts.selinux() == "Enforcing"
+}
+
+// checkSELinux decides how bind mounts are made readable when SELinux is
+// enforcing. Without it the containers fail with permission denied errors.
+func checkSELinux() {
+	if !platform.LinuxPreflight || !selinuxEnforcing() {
+		logf("INFO", "SELinux is not enforcing")
+		selinuxMode = selinuxIgnore
+		return
+	}
+
+	infoln("\n=== SELinux ===")
+	infoln("SELinux is enforcing. The containers cannot read bind-mounted files unless they carry the container_file_t label.")
+	switch {
+	case selinuxFlag != "":
+		selinuxMode = selinuxFlag
+		recordFlagAnswer("selinux", selinuxFlag)
+	case readBool("selinux_label", tr("prompt.selinux_label"), true):
+		selinuxMode = selinuxLabel
+	case readBool("selinux_chcon", tr("prompt.selinux_chcon"), false):
+		selinuxMode = selinuxChcon
+	default:
+		selinuxMode = selinuxIgnore
+	}
+	logf("INFO", "SELinux mode: %s", selinuxMode)
+
+	if selinuxMode == selinuxIgnore {
+		warnf("Warning: SELinux is enforcing and the volume mounts will not be relabeled. Containers may fail with permission denied errors.\n")
+		report.skip("SELinux labeling (ignored)")
+	}
+}
+
+// applySELinux makes the bind mounts of the compose file readable according to
+// the mode chosen in checkSELinux and prints what was changed. In chcon mode
+// the install directory and any separate data directory are relabeled.
+func applySELinux(composePath string, dirs ...string) {
+	switch selinuxMode {
+	case selinuxLabel:
+		changed, err := labelComposeVolumes(composePath)
+		if err != nil {
+			warnf("Warning: could not label the volume mounts in %s: %v\n", composePath, err)
+			return
+		}
+		if len(changed) == 0 {
+			return
+		}
+		infof("Added SELinux labels to the volume mounts in %s:\n", composePath)
+		for _, volume := range changed {
+			infof("  %s\n", volume)
+		}
+		report.fileWritten(composePath)
+	case selinuxChcon:
+		for _, dir := range dirs {
+			if dir == "" {
+				continue
+			}
+			if err := relabelInstallDir(dir); err != nil {
+				warnf("Warning: could not relabel %s: %v\n", dir, err)
+			}
+		}
+	}
+}
+
+// labelComposeVolumes appends an SELinux label to every bind mount that does
+// not have one yet. Paths shared between services (./config is mounted by
+// pangolin, gerbil and traefik) get the shared :z label, all others the
+// private :Z label. It returns the changed mounts as service: volume.
+func labelComposeVolumes(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	services := yamlMapValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("services section not found or invalid")
+	}
+
+	// Collect the bind mount host paths per service first to find shared ones
+	hostPaths := map[string][]string{}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name := services.Content[i].Value
+		for _, volume := range serviceVolumes(services.Content[i+1]) {
+			if host, ok := bindMountHost(volume.Value); ok {
+				hostPaths[name] = append(hostPaths[name], host)
+			}
+		}
+	}
+	shared := func(service, host string) bool {
+		for other, paths := range hostPaths {
+			if other == service {
+				continue
+			}
+			for _, path := range paths {
+				if pathsOverlap(host, path) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	var changed []string
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name := services.Content[i].Value
+		for _, volume := range serviceVolumes(services.Content[i+1]) {
+			host, ok := bindMountHost(volume.Value)
+			if !ok || hasSELinuxLabel(volume.Value) {
+				continue
+			}
+			label := "Z"
+			if shared(name, host) {
+				label = "z"
+			}
+			if strings.Count(volume.Value, ":") >= 2 {
+				volume.Value += "," + label
+			} else {
+				volume.Value += ":" + label
+			}
+			changed = append(changed, name+": "+volume.Value)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	out, err := MarshalYAMLWithIndent(&doc, 2)
+	if err != nil {
+		return nil, err
+	}
+	return changed, os.WriteFile(path, out, 0644)
+}
+
+// serviceVolumes returns the short syntax volume entries of a service
+func serviceVolumes(service *yaml.Node) []*yaml.Node {
+	volumes := yamlMapValue(service, "volumes")
+	if volumes == nil || volumes.Kind != yaml.SequenceNode {
+		return nil
+	}
+	var entries []*yaml.Node
+	for _, volume := range volumes.Content {
+		if volume.Kind == yaml.ScalarNode {
+			entries = append(entries, volume)
+		}
+	}
+	return entries
+}
+
+// bindMountHost returns the cleaned host path of a bind mount; named volumes
+// are managed by the runtime and need no label
+func bindMountHost(volume string) (string, bool) {
+	host, _, ok := strings.Cut(volume, ":")
+	if !ok || !(strings.HasPrefix(host, ".") || strings.HasPrefix(host, "/")) {
+		return "", false
+	}
+	return filepath.Clean(host), true
+}
+
+func hasSELinuxLabel(volume string) bool {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 3 {
+		return false
+	}
+	for _, option := range strings.Split(parts[len(parts)-1], ",") {
+		if option == "z" || option == "Z" {
+			return true
+		}
+	}
+	return false
+}
+
+// pathsOverlap reports whether a and b are the same path or one contains the other
+func pathsOverlap(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// relabelInstallDir gives the install directory the container_file_t type.
+// With semanage the rule survives a filesystem relabel, otherwise chcon is
+// used and the label is lost on the next restorecon.
+func relabelInstallDir(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := exec.LookPath("semanage"); err == nil {
+		pattern := dir + "(/.*)?"
+		if err := run("semanage", "fcontext", "-a", "-t", "container_file_t", pattern); err != nil {
+			// The rule may exist from an earlier run
+			if err := run("semanage", "fcontext", "-m", "-t", "container_file_t", pattern); err != nil {
+				return fmt.Errorf("semanage fcontext failed: %v", err)
+			}
+		}
+		recordExternal(externalResource{
+			Kind:        resourceSELinuxContext,
+			Description: "SELinux file context " + pattern,
+			Undo:        fmt.Sprintf("semanage fcontext -d '%s'", pattern),
+		})
+		if err := run("restorecon", "-R", dir); err != nil {
+			return fmt.Errorf("restorecon failed: %v", err)
+		}
+		infof("Added the SELinux file context rule %s (container_file_t) and relabeled %s.\n", pattern, dir)
+		return nil
+	}
+
+	if err := run("chcon", "-R", "-t", "container_file_t", dir); err != nil {
+		return fmt.Errorf("chcon failed: %v", err)
+	}
+	infof("Relabeled %s with container_file_t using chcon.\n", dir)
+	infoln("semanage is not installed, so a full filesystem relabel will reset this. Install policycoreutils-python-utils to make it persistent.")
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pangolinPorts are the ports Pangolin listens on inside its container, the
+// server.*_port keys of config.yml
+type pangolinPorts struct {
+	// External serves the API and WebSocket
+	External int `yaml:"external_port"`
+	// Internal serves Traefik's HTTP provider, Gerbil and the healthcheck
+	Internal int `yaml:"internal_port"`
+	// Next serves the dashboard
+	Next        int `yaml:"next_port"`
+	Integration int `yaml:"integration_port"`
+}
+
+var defaultPangolinPorts = pangolinPorts{External: 3000, Internal: 3001, Next: 3002, Integration: 3003}
+
+// orDefaults fills the unset ports with Pangolin's defaults
+func (p pangolinPorts) orDefaults() pangolinPorts {
+	def := defaultPangolinPorts
+	if p.External == 0 {
+		p.External = def.External
+	}
+	if p.Internal == 0 {
+		p.Internal = def.Internal
+	}
+	if p.Next == 0 {
+		p.Next = def.Next
+	}
+	if p.Integration == 0 {
+		p.Integration = def.Integration
+	}
+	return p
+}
+
+// Custom reports whether a port differs from Pangolin's defaults, config.yml
+// only lists the ports then
+func (p pangolinPorts) Custom() bool {
+	return p.orDefaults() != defaultPangolinPorts
+}
+
+// ServerPorts are the ports Pangolin listens on, the healthcheck, Traefik and
+// Gerbil are generated against them
+func (c Config) ServerPorts() pangolinPorts {
+	return c.PangolinPorts.orDefaults()
+}
+
+// collectPangolinPorts asks for the ports Pangolin listens on inside its
+// container, only needed when other software on the container network
+// already uses the defaults
+func collectPangolinPorts(config *Config) {
+	if !readBool("custom_server_ports", tr("prompt.custom_server_ports"), false) {
+		return
+	}
+	ports := config.ServerPorts()
+	fields := ports.fields()
+	for i, field := range fields {
+		*field.port = readIntValidated(field.key, tr("prompt."+field.key), *field.port, 1, 65535, func(port int) error {
+			// Only the ports answered before are final, the later ones can
+			// still change
+			return validateServerPorts(append(slices.Clone(fields[:i]), serverPortField{field.name, field.key, &port}))
+		})
+	}
+	config.PangolinPorts = ports
+}
+
+// serverPortField is one of the ports of pangolinPorts with its prompt key
+type serverPortField struct {
+	name string
+	key  string
+	port *int
+}
+
+func (p *pangolinPorts) fields() []serverPortField {
+	return []serverPortField{
+		{"API", "server_external_port", &p.External},
+		{"internal", "server_internal_port", &p.Internal},
+		{"dashboard", "server_next_port", &p.Next},
+		{"integration", "server_integration_port", &p.Integration},
+	}
+}
+
+// validateServerPorts rejects a port used for two of fields
+func validateServerPorts(fields []serverPortField) error {
+	seen := map[int]string{}
+	for _, field := range fields {
+		if other, ok := seen[*field.port]; ok {
+			return fmt.Errorf("the %s and %s ports are both %d", other, field.name, *field.port)
+		}
+		seen[*field.port] = field.name
+	}
+	return nil
+}
+
+// installedPangolinPorts reads the server ports from config.yml, falling back
+// to the defaults
+func installedPangolinPorts(configPath string) pangolinPorts {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return defaultPangolinPorts
+	}
+	var config struct {
+		Server pangolinPorts `yaml:"server"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return defaultPangolinPorts
+	}
+	return config.Server.orDefaults()
+}
+
+// pangolinURLPattern matches a URL of the pangolin container, as seen from
+// another container or from inside it
+var pangolinURLPattern = regexp.MustCompile(`(//(?:pangolin|localhost):)(\d+)`)
+
+// setURLPort points the pangolin URLs in node at port and reports whether it
+// changed anything
+func setURLPort(node *yaml.Node, port int) bool {
+	changed := false
+	for _, scalar := range yamlScalars(node) {
+		value := pangolinURLPattern.ReplaceAllString(scalar.Value, "${1}"+strconv.Itoa(port))
+		if value != scalar.Value {
+			scalar.Value = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+func yamlScalars(node *yaml.Node) []*yaml.Node {
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.ScalarNode {
+		return []*yaml.Node{node}
+	}
+	var scalars []*yaml.Node
+	for _, child := range node.Content {
+		scalars = append(scalars, yamlScalars(child)...)
+	}
+	return scalars
+}
+
+// serverPortMigrations point every reference to Pangolin's ports in the
+// compose file and the Traefik configs at ports
+func serverPortMigrations(ports pangolinPorts) []configMigration {
+	changed := func(key string, port int) configChange {
+		return configChange{Kind: "changed", Key: fmt.Sprintf("%s (port %d)", key, port)}
+	}
+	return []configMigration{
+		{Name: "server-ports", File: "docker-compose.yml", Apply: func(root *yaml.Node) []configChange {
+			var changes []configChange
+			services := yamlMapValue(root, "services")
+			pangolin := yamlMapValue(services, "pangolin")
+			if setURLPort(yamlMapValue(yamlMapValue(pangolin, "healthcheck"), "test"), ports.Internal) {
+				changes = append(changes, changed("services.pangolin.healthcheck.test", ports.Internal))
+			}
+			if setURLPort(yamlMapValue(yamlMapValue(services, "gerbil"), "command"), ports.Internal) {
+				changes = append(changes, changed("services.gerbil.command", ports.Internal))
+			}
+			// Behind an existing proxy the installer publishes the API and
+			// then the dashboard on localhost
+			if published := yamlMapValue(pangolin, "ports"); published != nil && published.Kind == yaml.SequenceNode && len(published.Content) == 2 {
+				for i, port := range []int{ports.External, ports.Next} {
+					item := published.Content[i]
+					i := strings.LastIndex(item.Value, ":")
+					host := item.Value[:max(i, 0)]
+					if !strings.HasPrefix(host, "127.0.0.1:") {
+						continue
+					}
+					if value := host + ":" + strconv.Itoa(port); value != item.Value {
+						item.Value = value
+						changes = append(changes, changed("services.pangolin.ports."+host, port))
+					}
+				}
+			}
+			return changes
+		}},
+		{Name: "server-ports", File: "config/traefik/traefik_config.yml", Restart: "traefik", Apply: func(root *yaml.Node) []configChange {
+			if setURLPort(yamlMapValue(yamlMapValue(yamlMapValue(root, "providers"), "http"), "endpoint"), ports.Internal) {
+				return []configChange{changed("providers.http.endpoint", ports.Internal)}
+			}
+			return nil
+		}},
+		{Name: "server-ports", File: "config/traefik/dynamic_config.yml", Apply: func(root *yaml.Node) []configChange {
+			var changes []configChange
+			services := yamlMapValue(yamlMapValue(root, "http"), "services")
+			for _, service := range []struct {
+				names []string
+				port  int
+			}{{[]string{"installer-api", "api-service"}, ports.External}, {[]string{"installer-next", "next-service"}, ports.Next}} {
+				for _, name := range service.names {
+					if setURLPort(yamlMapValue(services, name), service.port) {
+						changes = append(changes, changed("http.services."+name, service.port))
+					}
+				}
+			}
+			return changes
+		}},
+	}
+}
+
+// reconcileServerPorts detects compose and Traefik references to Pangolin
+// ports that disagree with config.yml, e.g. after the ports were changed by
+// hand, and offers to fix them
+func reconcileServerPorts(containerType SupportedContainer) {
+	ports := installedPangolinPorts("config/config.yml")
+	migrated, changes, err := planMigrationSet(serverPortMigrations(ports))
+	if err != nil {
+		warnf("Warning: could not check the Pangolin ports: %v\n", err)
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	infoln("\n=== Pangolin Ports ===")
+	warnf("Warning: config.yml sets Pangolin's ports to %d (API), %d (internal) and %d (dashboard), but these references still use other ports:\n", ports.External, ports.Internal, ports.Next)
+	printConfigChanges(changes, nil)
+	infoln("The healthcheck and Traefik cannot reach Pangolin until they match.")
+	if !readBool("reconcile_server_ports", tr("prompt.reconcile_server_ports"), true) {
+		report.skip("Pangolin port reconciliation (declined)")
+		return
+	}
+	if err := backupConfig(); err != nil {
+		errorf("Error: backup failed: %v\n", err)
+		return
+	}
+	for path, content := range migrated {
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			errorf("Error writing %s: %v\n", path, err)
+			return
+		}
+		report.fileWritten(path)
+	}
+	if containerType == Undefined {
+		infoln("Run compose up -d and restart Traefik to apply the change.")
+		return
+	}
+	if err := startContainers(containerType); err != nil {
+		errorf("Error: %v\n", err)
+		return
+	}
+	if _, ok := migrated["config/traefik/traefik_config.yml"]; ok {
+		if err := restartContainer("traefik", containerType); err != nil {
+			errorf("Error: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+)
+
+const (
+	// backKey returns to the previous question of the wizard
+	backKey = "esc"
+	// backAnswer does the same in accessible mode, typed as the answer
+	backAnswer = "<"
+	// goBackValue is the value of the Go back option of accessible selects
+	goBackValue = "\x00back"
+)
+
+// errGoBack is returned by runField when the user asked for the previous
+// question
+var errGoBack = errors.New("go back to the previous question")
+
+// sessionAnswer is a question the user answered during the wizard. Default
+// is set when the user took the default, which is derived again when the
+// answer is given again, e.g. after the domain it depends on was changed.
+type sessionAnswer struct {
+	Key     string
+	Prompt  string
+	Value   string
+	Default bool `json:",omitempty"`
+	Masked  bool `json:",omitempty"`
+	// edit marks the answer to ask again when editing
+	edit bool
+}
+
+// checkPrompts are only asked after a check, which does not run again while
+// answers are replayed. A question answered again after one, e.g. the domains
+// entered again after the DNS check, replaces its earlier answers instead of
+// following them.
+var checkPrompts = append([]string{"reenter_domain", "smtp_test", "smtp_test_recipient"}, decisionPrompts...)
+
+// wizardSession tracks the questions answered in collectUserInput, so the
+// user can return to the previous one. Going back unwinds the wizard and runs
+// it again from the start: the questions answered before the one gone back
+// to get their answers again without showing them, and that question is
+// asked with its old answer as the default. Everything depending on it,
+// including the defaults of later questions, is derived again.
+type wizardSession struct {
+	active bool
+	// answers are the questions answered so far in this pass, in order
+	answers []sessionAnswer
+	// replay are the answers before the question gone back to, by key in
+	// the order they were given
+	replay map[string][]sessionAnswer
+	// retake is the question gone back to
+	retake *sessionAnswer
+	// editing is set while one answer is changed after the wizard: the
+	// replay continues after it, asking only questions not answered before
+	editing bool
+	// backRequested is set by an accessible field answered with backAnswer
+	backRequested bool
+	// hidden is the console while a replay discards what it prints
+	hidden io.Writer
+	// save persists the answers after each question, nil when they are not
+	save func([]sessionAnswer)
+}
+
+var session wizardSession
+
+// goBack is the panic value that unwinds the wizard to runWizard
+type goBack struct{}
+
+// runWizard runs collect until it returns without the user going back.
+// resumed are the answers of an interrupted run, given again before the
+// first question they do not answer is asked. save is called with the
+// answers after each question.
+func runWizard(collect func() Config, resumed []sessionAnswer, save func([]sessionAnswer)) Config {
+	session = wizardSession{active: true, save: save}
+	defer session.close()
+	printBackHint()
+	if len(resumed) > 0 {
+		// The resumed answers are shown, unlike those replayed going back
+		infof("Resuming after %d answered questions.\n", len(resumed))
+		session.replay = replayQueues(resumed, "")
+	}
+	return session.run(collect)
+}
+
+// editWizard runs collect again to change the answer at index of answers.
+// The other answers are given again without showing them, only the question
+// edited and those the changed answer leads to are asked.
+func editWizard(collect func() Config, answers []sessionAnswer, index int, save func([]sessionAnswer)) Config {
+	session = wizardSession{active: true, save: save, editing: true}
+	defer session.close()
+	printBackHint()
+	edited := slices.Clone(answers)
+	edited[index].edit = true
+	session.replay = replayQueues(edited, "")
+	session.hide()
+	return session.run(collect)
+}
+
+// printBackHint tells how to return to the previous question
+func printBackHint() {
+	if acceptDefaults || nonInteractive {
+		return
+	}
+	if isAccessibleMode() {
+		infof("%s\n", tr("input.back_hint_accessible", backAnswer))
+	} else {
+		infof("%s\n", tr("input.back_hint"))
+	}
+}
+
+// run runs collect until it returns without the user going back
+func (s *wizardSession) run(collect func() Config) Config {
+	for {
+		if config, ok := s.pass(collect); ok {
+			return config
+		}
+	}
+}
+
+// close ends the session after the wizard
+func (s *wizardSession) close() {
+	s.endReplay()
+	*s = wizardSession{}
+}
+
+// pass runs collect once, reporting false when the user went back
+func (s *wizardSession) pass(collect func() Config) (config Config, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, back := r.(goBack); !back {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	return collect(), true
+}
+
+// canGoBack reports whether there is a question to go back to
+func (s *wizardSession) canGoBack() bool {
+	return s.active && len(s.answers) > 0
+}
+
+// answered notes a question the user answered
+func (s *wizardSession) answered(answer sessionAnswer) {
+	if !s.active {
+		return
+	}
+	if !s.editing {
+		s.endReplay()
+	}
+	if s.retake != nil && s.retake.Key == answer.Key {
+		// The old answer is the default of the question gone back to, only
+		// its own default is derived again
+		if answer.Value == s.retake.Value {
+			answer.Default = s.retake.Default
+		}
+		s.retake = nil
+	}
+	s.answers = append(s.answers, answer)
+	if s.save != nil {
+		s.save(s.answers)
+	}
+}
+
+// goBack returns to the last answered question
+func (s *wizardSession) goBack() {
+	s.backRequested = false
+	last := s.answers[len(s.answers)-1]
+	logf("INFO", "going back to prompt %s", last.Key)
+	fmt.Fprintf(consoleOut, "\n%s\n", tr("input.going_back", last.Prompt))
+	if s.editing {
+		s.editBack(last)
+	} else {
+		s.retake = &last
+		s.replay = replayQueues(s.answers[:len(s.answers)-1], last.Key)
+	}
+	s.answers = nil
+	if len(s.replay) == 0 {
+		s.replay = nil
+	} else {
+		s.hide()
+	}
+	panic(goBack{})
+}
+
+// editBack goes back to last while editing. The answers after last that were
+// not asked yet are still given again, and so is the question edited when it
+// was the one gone back from.
+func (s *wizardSession) editBack(last sessionAnswer) {
+	rest := s.replay
+	if s.retake != nil {
+		retake := *s.retake
+		retake.edit = true
+		rest[retake.Key] = append([]sessionAnswer{retake}, rest[retake.Key]...)
+		s.retake = nil
+	}
+	last.edit = true
+	s.replay = replayQueues(append(s.answers[:len(s.answers)-1:len(s.answers)-1], last), "")
+	for key, queued := range rest {
+		s.replay[key] = append(s.replay[key], queued...)
+	}
+}
+
+// replayQueues are the answers to give again by key, in the order they were
+// given. An answer given after one of checkPrompts replaces the earlier
+// answers of its question. The answers of retake, the question gone back to,
+// are dropped when it was asked again after a check, it is then asked where
+// it was first asked.
+func replayQueues(answers []sessionAnswer, retake string) map[string][]sessionAnswer {
+	replay := map[string][]sessionAnswer{}
+	checks := 0
+	checksBefore := map[string]int{}
+	for _, answer := range answers {
+		if slices.Contains(checkPrompts, answer.Key) {
+			checks++
+			continue
+		}
+		if checksBefore[answer.Key] != checks {
+			delete(replay, answer.Key)
+			checksBefore[answer.Key] = checks
+		}
+		replay[answer.Key] = append(replay[answer.Key], answer)
+	}
+	if retake != "" && checksBefore[retake] != checks {
+		delete(replay, retake)
+	}
+	return replay
+}
+
+// replayed is the answer to give again for the question with key, an answer
+// that took the default takes defaultValue now when there is one. The replay
+// ends at the question gone back to, or at one that was not answered before
+// because the wizard took another branch. Each answer is given once, a loop
+// asking more often than before is answered by the user. While editing the
+// replay continues after both, which are asked on the console.
+func (s *wizardSession) replayed(key, defaultValue string, hasDefault bool) (string, bool) {
+	if s.replay == nil {
+		return "", false
+	}
+	queued := s.replay[key]
+	if len(queued) == 0 || queued[0].edit {
+		if !s.editing {
+			s.endReplay()
+			return "", false
+		}
+		if len(queued) > 0 {
+			s.retake = &queued[0]
+			s.replay[key] = queued[1:]
+		}
+		s.show()
+		return "", false
+	}
+	answer := queued[0]
+	s.replay[key] = queued[1:]
+	if answer.Default && hasDefault {
+		answer.Value = defaultValue
+	}
+	s.answers = append(s.answers, answer)
+	if s.editing {
+		s.hide()
+	}
+	return answer.Value, true
+}
+
+// replaying reports whether answers are being given again, the checks they
+// triggered have run on the first pass
+func (s *wizardSession) replaying() bool {
+	return s.replay != nil
+}
+
+// endReplay stops giving answers again and shows the console
+func (s *wizardSession) endReplay() {
+	s.replay = nil
+	s.show()
+}
+
+// hide discards what is printed while answers are given again
+func (s *wizardSession) hide() {
+	if s.hidden == nil {
+		s.hidden, consoleOut = consoleOut, io.Discard
+	}
+}
+
+// show restores the console hidden by hide
+func (s *wizardSession) show() {
+	if s.hidden != nil {
+		consoleOut, s.hidden = s.hidden, nil
+	}
+}
+
+// previous is the old answer of the question gone back to, or of the one
+// edited when it is asked next
+func (s *wizardSession) previous(key string) (string, bool) {
+	if s.retake != nil && s.retake.Key == key {
+		return s.retake.Value, true
+	}
+	if queued := s.replay[key]; len(queued) > 0 && queued[0].edit {
+		return queued[0].Value, true
+	}
+	return "", false
+}
+
+// requestBack is called by accessible fields answered with backAnswer or the
+// Go back option, reporting whether going back is possible
+func (s *wizardSession) requestBack() bool {
+	if !s.canGoBack() {
+		return false
+	}
+	s.backRequested = true
+	return true
+}
+
+// backKeyModel runs a form and quits it when backKey is pressed
+type backKeyModel struct {
+	form *huh.Form
+	back bool
+}
+
+func (m *backKeyModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m *backKeyModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if key, ok := msg.(tea.KeyMsg); ok && key.String() == backKey {
+		// Esc clears the filter of a select that is filtering
+		filtering, ok := m.form.GetFocusedField().(interface{ GetFiltering() bool })
+		if !ok || !filtering.GetFiltering() {
+			m.back = true
+			return m, tea.Quit
+		}
+	}
+	form, cmd := m.form.Update(msg)
+	m.form = form.(*huh.Form)
+	return m, cmd
+}
+
+func (m *backKeyModel) View() string {
+	if m.back {
+		return ""
+	}
+	return m.form.View()
+}
+
+// runForm runs form on the console. During the wizard backKey returns
+// errGoBack once a question was answered.
+func runForm(form *huh.Form) error {
+	form = form.WithTheme(pangolinTheme).WithOutput(consoleOut)
+	if !session.canGoBack() {
+		return form.Run()
+	}
+	form.SubmitCmd = tea.Quit
+	form.CancelCmd = tea.Interrupt
+	model := &backKeyModel{form: form}
+	_, err := tea.NewProgram(model, tea.WithOutput(consoleOut)).Run()
+	switch {
+	case model.back:
+		return errGoBack
+	case form.State == huh.StateAborted || errors.Is(err, tea.ErrInterrupted):
+		return huh.ErrUserAborted
+	case err != nil:
+		return fmt.Errorf("huh: %w", err)
+	}
+	return nil
+}
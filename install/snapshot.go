@@ -0,0 +1,465 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotDir holds the snapshots taken before upgrades, in the
+	// installation directory
+	snapshotDir       = "snapshots"
+	snapshotRetention = 3
+	// snapshotMetadata is the first entry of every snapshot
+	snapshotMetadata = "snapshot.json"
+	// rollbackSuffix marks what a rollback replaced, kept until the rollback
+	// has proven itself
+	rollbackSuffix = ".pre-rollback"
+	// rollbackPhrase is typed (or passed with --confirm) to roll back
+	rollbackPhrase = "rollback"
+)
+
+// snapshotExcludes are left out of snapshots, they only grow
+var snapshotExcludes = []string{"config/logs", "config/traefik/logs"}
+
+// snapshotInfo describes a snapshot. Paths are absolute, the archive stores
+// them without the leading slash.
+type snapshotInfo struct {
+	Created          time.Time         `json:"created"`
+	Reason           string            `json:"reason"`
+	InstallerVersion string            `json:"installerVersion"`
+	Dir              string            `json:"dir"`
+	Images           map[string]string `json:"images"`
+	Paths            []string          `json:"paths"`
+}
+
+// snapshotPaths lists what a snapshot of the installation in dir holds: the
+// config directory, the compose file and its secrets, and the bind mounted
+// data directories of the stack such as the PostgreSQL data
+func snapshotPaths(dir string) []string {
+	var paths []string
+	for _, path := range []string{"config", "docker-compose.yml", envFile} {
+		if pathExists(path) {
+			paths = append(paths, filepath.Join(dir, path))
+		}
+	}
+	doc, err := readComposeDocument()
+	if err != nil {
+		return paths
+	}
+	services := yamlMapValue(doc.Content[0], "services")
+	if services == nil {
+		return paths
+	}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		volumes := yamlMapValue(services.Content[i+1], "volumes")
+		if volumes == nil {
+			continue
+		}
+		for _, volume := range volumes.Content {
+			source, _, _ := strings.Cut(volume.Value, ":")
+			if !filepath.IsAbs(source) {
+				continue
+			}
+			if info, err := os.Stat(source); err != nil || !info.IsDir() {
+				continue
+			}
+			source = filepath.Clean(source)
+			if !slices.ContainsFunc(paths, func(path string) bool { return pathsOverlap(path, source) }) {
+				paths = append(paths, source)
+			}
+		}
+	}
+	return paths
+}
+
+// takeSnapshot archives the installation in the current directory into
+// snapshotDir and removes the snapshots beyond snapshotRetention. The stack
+// is stopped first so the databases are copied consistently.
+func takeSnapshot(dir, reason string, containerType SupportedContainer) (string, error) {
+	info := snapshotInfo{
+		Created:          time.Now().UTC(),
+		Reason:           reason,
+		InstallerVersion: pangolinVersion,
+		Dir:              dir,
+		Images:           map[string]string{},
+		Paths:            snapshotPaths(dir),
+	}
+	for _, image := range composeServiceImages() {
+		info.Images[image[0]] = image[1]
+	}
+
+	if containerType != Undefined {
+		if err := runCompose(containerType, "docker-compose.yml", "stop"); err != nil {
+			return "", fmt.Errorf("failed to stop the stack for the snapshot: %v", err)
+		}
+	}
+	if err := os.MkdirAll(snapshotDir, 0700); err != nil {
+		return "", err
+	}
+	if err := ensureGitignored(snapshotDir + "/"); err != nil {
+		logf("WARN", "could not add %s to %s: %v", snapshotDir, gitignoreFile, err)
+	}
+	path := filepath.Join(snapshotDir, "pangolin-"+info.Created.Format("20060102-150405")+".tar.gz")
+	if err := writeSnapshot(path, info); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	snapshots, _ := listSnapshots()
+	for len(snapshots) > snapshotRetention {
+		if err := os.Remove(snapshots[0]); err != nil {
+			logf("WARN", "could not remove the old snapshot %s: %v", snapshots[0], err)
+		}
+		snapshots = snapshots[1:]
+	}
+	return path, nil
+}
+
+func writeSnapshot(path string, info snapshotInfo) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	compressed := gzip.NewWriter(file)
+	archive := tar.NewWriter(compressed)
+
+	metadata, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: snapshotMetadata, Mode: 0600, Size: int64(len(metadata)), ModTime: info.Created}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := archive.Write(metadata); err != nil {
+		return err
+	}
+
+	for _, root := range info.Paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if rel, err := filepath.Rel(info.Dir, path); err == nil && slices.Contains(snapshotExcludes, rel) {
+				return filepath.SkipDir
+			}
+			return addSnapshotEntry(archive, path, entry)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %v", root, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func addSnapshotEntry(archive *tar.Writer, path string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() && !info.IsDir() && info.Mode()&fs.ModeSymlink == 0 {
+		// sockets and pipes are recreated by their owners
+		return nil
+	}
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = strings.TrimPrefix(path, "/")
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(archive, file)
+	return err
+}
+
+// listSnapshots returns the snapshots of the installation in the current
+// directory, oldest first
+func listSnapshots() ([]string, error) {
+	snapshots, err := filepath.Glob(filepath.Join(snapshotDir, "pangolin-*.tar.gz"))
+	sort.Strings(snapshots)
+	return snapshots, err
+}
+
+// openSnapshot returns the reader of a snapshot positioned after its
+// metadata, and the metadata
+func openSnapshot(path string) (*tar.Reader, io.Closer, snapshotInfo, error) {
+	var info snapshotInfo
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, info, err
+	}
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, info, fmt.Errorf("%s is not a snapshot: %v", path, err)
+	}
+	archive := tar.NewReader(compressed)
+	header, err := archive.Next()
+	if err == nil && header.Name != snapshotMetadata {
+		err = errors.New("the metadata is missing")
+	}
+	if err == nil {
+		err = json.NewDecoder(archive).Decode(&info)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, info, fmt.Errorf("%s is not a snapshot: %v", path, err)
+	}
+	return archive, file, info, nil
+}
+
+// restoreSnapshot moves the paths of the snapshot aside and extracts it in
+// their place. The replaced paths keep rollbackSuffix.
+func restoreSnapshot(path string) error {
+	archive, closer, info, err := openSnapshot(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for _, root := range info.Paths {
+		aside := root + rollbackSuffix
+		if err := os.RemoveAll(aside); err != nil {
+			return err
+		}
+		if err := os.Rename(root, aside); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to move %s aside: %v", root, err)
+		}
+	}
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			restoreExcluded(info)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		target := "/" + filepath.Clean(header.Name)
+		if !slices.ContainsFunc(info.Paths, func(root string) bool { return pathsOverlap(root, target) }) {
+			return fmt.Errorf("%s holds %s, which is outside the snapshot", path, target)
+		}
+		if err := extractSnapshotEntry(archive, header, target); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", target, err)
+		}
+	}
+}
+
+// restoreExcluded moves the logs, which snapshots leave out, back from the
+// replaced config directory
+func restoreExcluded(info snapshotInfo) {
+	for _, rel := range snapshotExcludes {
+		target := filepath.Join(info.Dir, rel)
+		for _, root := range info.Paths {
+			inside, err := filepath.Rel(root, target)
+			if err != nil || strings.HasPrefix(inside, "..") {
+				continue
+			}
+			aside := filepath.Join(root+rollbackSuffix, inside)
+			if !pathExists(aside) || pathExists(target) {
+				continue
+			}
+			if err := os.Rename(aside, target); err != nil {
+				logf("WARN", "could not move %s back: %v", aside, err)
+			}
+		}
+	}
+}
+
+func extractSnapshotEntry(archive *tar.Reader, header *tar.Header, target string) error {
+	mode := fs.FileMode(header.Mode).Perm()
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return err
+		}
+		return os.Lchown(target, header.Uid, header.Gid)
+	case tar.TypeReg:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, archive); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	// Database directories belong to the user of their container
+	if isRoot() {
+		if err := os.Chown(target, header.Uid, header.Gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(target, mode)
+}
+
+// runRollback implements the rollback subcommand: it restores the newest
+// snapshot, or the one given, and starts the stack on the image tags it
+// recorded
+func runRollback(args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	dirFlag := fs.String("dir", "", "Installation directory to roll back (default: the current directory or /opt/pangolin)")
+	snapshotFlag := fs.String("snapshot", "", "Snapshot to restore (default: the newest one in the snapshots directory)")
+	yesFlag := fs.Bool("yes", false, "Roll back without asking for confirmation")
+	addDryRunFlag(fs, "Only show what the rollback restores, changing nothing")
+	addConfirmFlag(fs)
+	healthTimeoutFlag = fs.Duration("health-timeout", defaultHealthTimeout, "How long to wait for the stack to become healthy after the rollback")
+	addTerminalFlags(fs)
+	addLogFileFlag(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	dir := *dirFlag
+	if dir == "" {
+		var ok bool
+		if dir, ok = locateExistingInstall(); !ok {
+			fatalf("Error: no Pangolin installation found in the current directory or /opt/pangolin\n")
+		}
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		fatalf("Error resolving path: %v\n", err)
+	}
+	path := *snapshotFlag
+	if path != "" {
+		if path, err = filepath.Abs(path); err != nil {
+			fatalf("Error resolving path: %v\n", err)
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+	openInstallLog(dir)
+	defer installLog.close()
+
+	if path == "" {
+		snapshots, err := listSnapshots()
+		if err != nil || len(snapshots) == 0 {
+			fatalf("Error: %s has no snapshots, they are taken by the upgrade command\n", filepath.Join(dir, snapshotDir))
+		}
+		path = snapshots[len(snapshots)-1]
+	}
+	_, closer, info, err := openSnapshot(path)
+	if err != nil {
+		fatalf("Error: %v\n", err)
+	}
+	closer.Close()
+	if info.Dir != dir {
+		fatalf("Error: %s is a snapshot of %s, not of %s\n", path, info.Dir, dir)
+	}
+
+	infof("\n=== Rollback to %s ===\n", path)
+	infof("Taken %s, %s.\n", info.Created.Local().Format(time.RFC1123), info.Reason)
+	current := map[string]string{}
+	for _, image := range composeServiceImages() {
+		current[image[0]] = image[1]
+	}
+	infoln("\nImages:")
+	services := make([]string, 0, len(info.Images))
+	for service := range info.Images {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		if image := info.Images[service]; current[service] != image {
+			infof("  %-10s %s -> %s\n", service, orUnknown(current[service]), image)
+		} else {
+			infof("  %-10s %s (unchanged)\n", service, image)
+		}
+	}
+	infoln("\nRestored, the current versions are kept with the suffix " + rollbackSuffix + ":")
+	for _, root := range info.Paths {
+		infof("  %s\n", root)
+	}
+	if dryRun {
+		exitDryRun(true)
+	}
+
+	containerType := detectContainerType()
+	if containerType == Undefined {
+		exitf(exitContainers, "Error: neither Docker nor Podman is running\n")
+	}
+	if !*yesFlag && !readConfirmation("confirm_rollback", tr("prompt.confirm_rollback"), rollbackPhrase) {
+		infoln("Rollback cancelled, nothing was changed.")
+		return
+	}
+
+	infoln("\n=== Rolling Back ===")
+	if err := stopContainers(containerType); err != nil {
+		exitf(exitContainers, "Error: %v\n", err)
+	}
+	if err := restoreSnapshot(path); err != nil {
+		fatalf("Error: %v\nThe replaced files are kept with the suffix %s.\n", err, rollbackSuffix)
+	}
+	if err := startContainers(containerType); err != nil {
+		exitf(exitContainers, "Error: %v\n", err)
+	}
+	if err := waitForStackHealthy(containerType, installedDashboardDomain()); err != nil {
+		exitf(exitCodeOf(err, exitContainers), "Error: %v\n", err)
+	}
+	infof("\nRollback complete. Remove the %s copies once the stack works as before.\n", rollbackSuffix)
+}
+
+// composeServiceImages lists the service and image pairs of the compose file
+func composeServiceImages() [][2]string {
+	doc, err := readComposeDocument()
+	if err != nil {
+		return nil
+	}
+	services := yamlMapValue(doc.Content[0], "services")
+	var images [][2]string
+	for i := 0; services != nil && i+1 < len(services.Content); i += 2 {
+		if image := yamlMapValue(services.Content[i+1], "image"); image != nil {
+			images = append(images, [2]string{services.Content[i].Value, image.Value})
+		}
+	}
+	return images
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type stepDoneMsg struct {
+	err error
+}
+
+// stepModel renders a single spinner line while a step runs
+type stepModel struct {
+	spinner spinner.Model
+	title   string
+	done    bool
+	aborted bool
+}
+
+func (m stepModel) Init() tea.Cmd {
+	return m.spinner.Tick
+}
+
+func (m stepModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			m.aborted = true
+			return m, tea.Quit
+		}
+	case stepDoneMsg:
+		m.done = true
+		return m, tea.Quit
+	}
+
+	var cmd tea.Cmd
+	m.spinner, cmd = m.spinner.Update(msg)
+	return m, cmd
+}
+
+func (m stepModel) View() string {
+	if m.done || m.aborted {
+		return ""
+	}
+	return fmt.Sprintf("%s %s…", m.spinner.View(), m.title)
+}
+
+// runStep runs a long operation behind a spinner and finishes the line with a
+// check mark or a cross. fn must stop when ctx is cancelled; pressing Ctrl+C
+// cancels it and aborts the installation. In accessible, quiet and verbose
+// mode plain progress lines are printed instead.
+func runStep(ctx context.Context, title string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if isAccessibleMode() || isQuiet() || isVerbose() {
+		infof("%s…\n", title)
+		if err := fn(ctx); err != nil {
+			infof("Failed: %v\n", err)
+			return err
+		}
+		infoln("Done")
+		return nil
+	}
+
+	logf("INFO", "step: %s", title)
+	model := stepModel{
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(lipgloss.NewStyle().Foreground(colors.Primary)),
+		),
+		title: title,
+	}
+	program := tea.NewProgram(model, tea.WithOutput(consoleOut), tea.WithoutSignalHandler())
+
+	result := make(chan error, 1)
+	go func() {
+		err := fn(ctx)
+		result <- err
+		program.Send(stepDoneMsg{err: err})
+	}()
+
+	final, runErr := program.Run()
+	if runErr != nil {
+		logf("WARN", "spinner failed: %v", runErr)
+	}
+	if m, ok := final.(stepModel); ok && m.aborted {
+		cancel()
+		<-result
+		fmt.Fprintln(consoleOut, stepResult(title, huh.ErrUserAborted))
+		handleAbort(huh.ErrUserAborted)
+	}
+
+	err := <-result
+	if err != nil {
+		logf("ERROR", "step failed: %s: %v", title, err)
+		fmt.Fprintln(consoleOut, stepResult(title, err))
+		return err
+	}
+	logf("INFO", "step done: %s", title)
+	fmt.Fprintln(consoleOut, stepResult(title, nil))
+	return nil
+}
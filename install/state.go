@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// installStateFile records what the installer created outside the install
+// directory so that uninstall can clean it up. It lives in the install root.
+const installStateFile = "installer-state.json"
+
+const (
+	// resourceFile is a file the installer wrote outside the install directory
+	resourceFile = "file"
+	// resourcePackage is software the installer installed but never removes
+	resourcePackage = "package"
+)
+
+type externalResource struct {
+	Kind        string `json:"kind"`
+	Path        string `json:"path,omitempty"`
+	Description string `json:"description"`
+	// PreRemove is run through bash before the resource is removed (e.g. stop)
+	PreRemove string `json:"preRemove,omitempty"`
+	// Undo is run through bash after the resource was removed (e.g. reload)
+	Undo string `json:"undo,omitempty"`
+}
+
+func (r externalResource) label() string {
+	if r.Path != "" {
+		return r.Description + ": " + r.Path
+	}
+	return r.Description
+}
+
+type installState struct {
+	Resources []externalResource `json:"resources"`
+	// Edited are the generated files the user changed before they were
+	// written, with the hash of the edited content
+	Edited fileManifest `json:"edited,omitempty"`
+	// Generated are the hashes of the files as the last run rendered them
+	Generated fileManifest `json:"generated,omitempty"`
+	// DataDir holds the data volumes when they are outside the install
+	// directory, uninstall deletes it together with the install directory
+	DataDir string `json:"dataDir,omitempty"`
+	// Answers are the answers the install was made with and their sources,
+	// see config get
+	Answers []answerRecord `json:"answers,omitempty"`
+}
+
+func loadInstallState(dir string) (*installState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, installStateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &installState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state installState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s *installState) save(dir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, installStateFile), append(data, '\n'), 0600)
+}
+
+// recordExternal adds a resource to the state file of the install directory
+// in the current working directory. Failures are logged but never fatal.
+func recordExternal(resource externalResource) {
+	state, err := loadInstallState(".")
+	if err != nil {
+		logf("WARN", "could not read %s: %v", installStateFile, err)
+		return
+	}
+	for _, existing := range state.Resources {
+		if existing.Kind == resource.Kind && existing.Path == resource.Path && existing.Description == resource.Description {
+			return
+		}
+	}
+	state.Resources = append(state.Resources, resource)
+	if err := state.save("."); err != nil {
+		logf("WARN", "could not write %s: %v", installStateFile, err)
+		return
+	}
+	logf("INFO", "recorded external resource: %s %s", resource.Kind, resource.Description)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	secretsDir      = "config/secrets"
+	statusTokenFile = "config/secrets/status-api-token"
+	statusTokenName = "pangolin-status (read-only)"
+)
+
+// statusTokenActions are the read-only integration API actions granted to the
+// status token. Nothing in this list can modify state.
+var statusTokenActions = []string{
+	"listOrgs",
+	"getOrg",
+	"listSites",
+	"getSite",
+	"listResources",
+	"getResource",
+}
+
+// apiResponse is the envelope every Pangolin API response is wrapped in
+type apiResponse struct {
+	Data    json.RawMessage `json:"data"`
+	Success bool            `json:"success"`
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+}
+
+// offerStatusToken optionally creates a read-only integration API key that
+// external dashboards can use to poll health. Failures never fail the install.
+func offerStatusToken(dashboardURL string) {
+	infoln("\n=== Status API Token ===")
+	if _, err := os.Stat(statusTokenFile); err == nil {
+		infof("A status API token already exists at %s\n", statusTokenFile)
+		return
+	}
+
+	if !readBool("create_status_token", tr("prompt.create_status_token"), false) {
+		return
+	}
+
+	if !integrationAPIEnabled("config/config.yml") {
+		warnf("Warning: the integration API is not enabled. Set flags.enable_integration_api: true in config/config.yml\n")
+		warnf("and expose port %d of the pangolin container before using the token.\n", installedPangolinPorts("config/config.yml").Integration)
+	}
+
+	infoln("Creating a root API key requires the credentials of a server admin account.")
+	email := readEmail("status_token_admin_email", tr("prompt.status_token_admin_email"), "")
+	password := readPassword("status_token_admin_password", tr("prompt.status_token_admin_password"))
+
+	token, err := createStatusToken(dashboardURL, email, password)
+	if err != nil {
+		warnf("Warning: could not create the status API token: %v\n", err)
+		infoln("You can create a read-only API key manually in the dashboard under Server Admin > API Keys.")
+		return
+	}
+
+	if err := os.MkdirAll(secretsDir, 0700); err != nil {
+		warnf("Warning: could not create %s: %v\n", secretsDir, err)
+		return
+	}
+	if err := os.WriteFile(statusTokenFile, []byte(token+"\n"), 0600); err != nil {
+		warnf("Warning: could not write %s: %v\n", statusTokenFile, err)
+		return
+	}
+
+	infof("Status API token written to %s\n", statusTokenFile)
+	infoln("The token can read the following integration API endpoints:")
+	infoln("  GET /v1/                        health")
+	infoln("  GET /v1/orgs                    list organizations")
+	infoln("  GET /v1/org/{orgId}             organization details")
+	infoln("  GET /v1/org/{orgId}/sites       sites and their online state")
+	infoln("  GET /v1/org/{orgId}/resources   resources")
+	infoln("Check it from anywhere with:")
+	infof("  installer status --remote https://<integration-api-host> --token-file %s\n", statusTokenFile)
+}
+
+func integrationAPIEnabled(configPath string) bool {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return false
+	}
+	var cfg struct {
+		Flags struct {
+			EnableIntegrationAPI bool `yaml:"enable_integration_api"`
+		} `yaml:"flags"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return false
+	}
+	return cfg.Flags.EnableIntegrationAPI
+}
+
+// createStatusToken logs in as a server admin, creates a root API key and
+// restricts it to statusTokenActions. It returns the key in the
+// "<apiKeyId>.<secret>" form expected by the integration API.
+func createStatusToken(baseURL, email, password string) (string, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Jar: jar, Timeout: 15 * time.Second}
+	if err := loginAdmin(client, baseURL, email, password); err != nil {
+		return "", err
+	}
+	return createAPIKey(client, baseURL, statusTokenName, statusTokenActions)
+}
+
+// loginAdmin signs in as a server admin, the session is kept in the cookie
+// jar of client
+func loginAdmin(client *http.Client, baseURL, email, password string) error {
+	var login struct {
+		CodeRequested bool `json:"codeRequested"`
+	}
+	if err := apiRequest(client, http.MethodPost, baseURL+"/api/v1/auth/login", map[string]string{
+		"email":    email,
+		"password": password,
+	}, &login); err != nil {
+		return fmt.Errorf("login failed: %v", err)
+	}
+	if login.CodeRequested {
+		return fmt.Errorf("the admin account uses two-factor authentication, create the key in the dashboard instead")
+	}
+	return nil
+}
+
+// createAPIKey creates a root API key called name, limited to actions, with
+// the session of a server admin
+func createAPIKey(client *http.Client, baseURL, name string, actions []string) (string, error) {
+	var created struct {
+		APIKeyID string `json:"apiKeyId"`
+		APIKey   string `json:"apiKey"`
+	}
+	if err := apiRequest(client, http.MethodPut, baseURL+"/api/v1/api-key", map[string]string{
+		"name": name,
+	}, &created); err != nil {
+		return "", fmt.Errorf("creating API key failed: %v", err)
+	}
+
+	if err := apiRequest(client, http.MethodPost, baseURL+"/api/v1/api-key/"+created.APIKeyID+"/actions", map[string][]string{
+		"actionIds": actions,
+	}, nil); err != nil {
+		// Do not leave an unrestricted key behind
+		_ = apiRequest(client, http.MethodDelete, baseURL+"/api/v1/api-key/"+created.APIKeyID, nil, nil)
+		return "", fmt.Errorf("restricting API key failed: %v", err)
+	}
+
+	return created.APIKeyID + "." + created.APIKey, nil
+}
+
+// apiRequest performs a JSON request against the Pangolin API and decodes the
+// data field of the response into out (when non-nil).
+func apiRequest(client *http.Client, method, endpoint string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", "x-csrf-protection")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("unexpected response (%s): %v", resp.Status, err)
+	}
+	if resp.StatusCode >= 300 || envelope.Error {
+		return fmt.Errorf("%s: %s", resp.Status, envelope.Message)
+	}
+
+	if out != nil && len(envelope.Data) > 0 {
+		return json.Unmarshal(envelope.Data, out)
+	}
+	return nil
+}
+
+// runStatus implements the status subcommand
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	remote := fs.String("remote", "", "Base URL of a Pangolin integration API to query instead of local containers")
+	tokenFile := fs.String("token-file", "", "File containing a read-only API token (used with --remote)")
+	addTerminalFlags(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	if *remote != "" {
+		if err := printRemoteStatus(*remote, *tokenFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	dir, ok := locateExistingInstall()
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error: no Pangolin installation found in the current directory or /opt/pangolin")
+		os.Exit(1)
+	}
+	if err := os.Chdir(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error changing to installation directory: %v\n", err)
+		os.Exit(1)
+	}
+	printLocalStatus(dir)
+}
+
+// locateExistingInstall returns the directory of an existing installation in
+// the current directory or the default location without prompting.
+func locateExistingInstall() (string, bool) {
+	if cwd, err := os.Getwd(); err == nil && hasExistingInstall(cwd) {
+		return cwd, true
+	}
+	if hasExistingInstall(defaultInstallDir) {
+		return defaultInstallDir, true
+	}
+	return "", false
+}
+
+func printLocalStatus(dir string) {
+	containerType := detectContainerType()
+	if containerType == Undefined {
+		fmt.Fprintln(os.Stderr, "Error: neither Docker nor Podman is running")
+		os.Exit(1)
+	}
+
+	infof("Installation: %s (%s)\n", dir, containerType)
+	names, labeled := stackContainers(containerType)
+	if !labeled {
+		infoln("  (no labeled containers found, re-run the installer to label this install)")
+	}
+	healthy := true
+	for _, name := range names {
+		out, err := exec.Command(string(containerType), "container", "inspect", "-f",
+			"{{.State.Status}}{{if .State.Health}} ({{.State.Health.Status}}){{end}}", name).Output()
+		if err != nil {
+			// Optional components are simply not listed
+			continue
+		}
+		state := strings.TrimSpace(string(out))
+		if !strings.HasPrefix(state, "running") || strings.Contains(state, "unhealthy") {
+			healthy = false
+		}
+		infof("  %-10s %s\n", name, state)
+	}
+
+	if !healthy {
+		os.Exit(1)
+	}
+}
+
+func printRemoteStatus(baseURL, tokenFile string) error {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return fmt.Errorf("invalid --remote URL: %v", err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	if err := apiRequest(client, http.MethodGet, baseURL+"/v1/", nil, nil); err != nil {
+		return fmt.Errorf("health check failed: %v", err)
+	}
+	infof("Pangolin at %s: healthy\n", baseURL)
+
+	if tokenFile == "" {
+		return nil
+	}
+	content, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return fmt.Errorf("reading token file: %v", err)
+	}
+	token := strings.TrimSpace(string(content))
+
+	authed := &http.Client{Timeout: 10 * time.Second, Transport: bearerTransport{token: token}}
+
+	var orgs struct {
+		Orgs []struct {
+			OrgID string `json:"orgId"`
+			Name  string `json:"name"`
+		} `json:"orgs"`
+	}
+	if err := apiRequest(authed, http.MethodGet, baseURL+"/v1/orgs", nil, &orgs); err != nil {
+		return fmt.Errorf("listing organizations failed: %v", err)
+	}
+
+	for _, org := range orgs.Orgs {
+		var sites struct {
+			Sites []struct {
+				Name   string `json:"name"`
+				Type   string `json:"type"`
+				Online *bool  `json:"online"`
+			} `json:"sites"`
+		}
+		if err := apiRequest(authed, http.MethodGet, baseURL+"/v1/org/"+url.PathEscape(org.OrgID)+"/sites", nil, &sites); err != nil {
+			infof("  %s: could not list sites: %v\n", org.Name, err)
+			continue
+		}
+
+		online := 0
+		tracked := 0
+		for _, site := range sites.Sites {
+			// Local sites do not report an online state
+			if site.Online == nil {
+				continue
+			}
+			tracked++
+			if *site.Online {
+				online++
+			}
+		}
+		infof("  %s: %d/%d sites online\n", org.Name, online, tracked)
+	}
+	return nil
+}
+
+type bearerTransport struct {
+	token string
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	// storageBenchBudget bounds how long the storage benchmark may run
+	storageBenchBudget = 2 * time.Second
+	storageBenchBlock  = 256 << 10
+	storageBenchBlocks = 32
+	// slowFsyncThreshold is the average write+fsync latency of one block above
+	// which storage is considered slow. SD cards typically take 50ms or more.
+	slowFsyncThreshold = 20 * time.Millisecond
+	maxTimeoutScale    = 4
+)
+
+// timeoutScale stretches timeouts that depend on disk speed (health waits,
+// database migrations). It is raised by checkStorageSpeed on slow storage.
+var timeoutScale = 1.0
+
+func scaledTimeout(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * timeoutScale)
+}
+
+// checkStorageSpeed runs a short write/fsync benchmark inside dir and extends
+// timeouts when the storage is slow
+func checkStorageSpeed(dir string) {
+	latency, err := benchmarkStorage(dir)
+	if err != nil {
+		logf("WARN", "storage benchmark failed: %v", err)
+		report.skip("storage speed check")
+		return
+	}
+	logf("INFO", "storage benchmark: %s per %d KiB write+fsync", latency, storageBenchBlock>>10)
+
+	if latency <= slowFsyncThreshold {
+		return
+	}
+	timeoutScale = math.Min(math.Ceil(float64(latency)/float64(slowFsyncThreshold)), maxTimeoutScale)
+	warnf("Slow storage detected (%s per write+fsync), extending timeouts %.0fx.\n", latency.Round(time.Millisecond), timeoutScale)
+}
+
+// benchmarkStorage returns the average latency of writing and syncing one
+// block. The test file is created in dir and always removed.
+func benchmarkStorage(dir string) (time.Duration, error) {
+	file, err := os.CreateTemp(dir, ".storage-bench-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	block := make([]byte, storageBenchBlock)
+	if _, err := rand.Read(block); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	n := 0
+	for n < storageBenchBlocks && time.Since(start) < storageBenchBudget {
+		if _, err := file.Write(block); err != nil {
+			return 0, err
+		}
+		if err := file.Sync(); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return time.Since(start) / time.Duration(n), nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The choices of the summary shown before anything is written
+const (
+	summaryConfirm = "confirm"
+	summaryEdit    = "edit"
+	summaryCancel  = "cancel"
+)
+
+// summarySection is a titled group of settings in the install summary
+type summarySection struct {
+	Title string
+	Rows  []summaryRow
+}
+
+// summaryRow is one setting of a summarySection
+type summaryRow struct {
+	Label string
+	Value string
+}
+
+// add appends a row, rows without a value are left out
+func (s *summarySection) add(label, value string) {
+	if value != "" {
+		s.Rows = append(s.Rows, summaryRow{label, value})
+	}
+}
+
+// installSummary describes what config installs: its domains, email
+// settings, ports and the components enabled
+func installSummary(config Config) []summarySection {
+	domains := summarySection{Title: "Domains"}
+	domains.add("Dashboard", config.DashboardURL())
+	domains.add("Base domain", config.BaseDomain)
+	domains.add("Additional domains", strings.Join(config.AdditionalDomains, ", "))
+	if config.WildcardDomain != "" {
+		domains.add("Wildcard certificate", "*."+config.WildcardDomain)
+	}
+
+	accounts := summarySection{Title: "Accounts and certificates"}
+	accounts.add("Admin email", config.AdminEmail)
+	switch {
+	case config.CreateAdmin && config.AdminPasswordGenerated:
+		accounts.add("Admin account", "created, the password is generated")
+	case config.CreateAdmin:
+		accounts.add("Admin account", "created with the password entered")
+	case config.AdminEmail != "":
+		accounts.add("Admin account", "set up in the dashboard after the install")
+	}
+	switch {
+	case config.ExternalProxy:
+		// The existing proxy terminates TLS
+	case config.OwnCertificate():
+		for _, certificate := range config.OwnCertificates {
+			accounts.add("Certificate", fmt.Sprintf("%s, expires %s", strings.Join(certificate.Leaf.DNSNames, ", "), certificate.Leaf.NotAfter.Format(time.DateOnly)))
+		}
+	default:
+		accounts.add("Let's Encrypt email", config.LetsEncryptEmail)
+		challenge := strings.ToUpper(config.ACMEChallenge)
+		if config.DNSChallenge() && config.DNSProvider != "" {
+			challenge += " with " + config.DNSProvider
+		}
+		if config.ACMEStaging {
+			challenge += " (staging)"
+		}
+		accounts.add("Certificate challenge", challenge)
+	}
+
+	ports := summarySection{Title: "Ports"}
+	if config.ExternalProxy {
+		ports.add("Existing reverse proxy", fmt.Sprintf("dashboard %d/tcp, API %d/tcp on %s", config.ProxyDashboardPort, config.ProxyAPIPort, config.ProxyBind()))
+	} else {
+		if config.HTTPEnabled() {
+			ports.add("HTTP", fmt.Sprintf("%d/tcp (%s)", config.HTTPPort, config.HTTPMode))
+		}
+		ports.add("HTTPS", fmt.Sprintf("%d/tcp", config.HTTPSPort))
+	}
+	if config.InstallGerbil {
+		gerbil := config.GerbilPorts()
+		ports.add("WireGuard", fmt.Sprintf("%d/udp, clients %d/udp", gerbil[0], gerbil[1]))
+		ports.add("Tunnel endpoint", config.TunnelEndpoint())
+	}
+
+	email := summarySection{Title: "Email"}
+	if config.EnableEmail {
+		email.add("SMTP server", fmt.Sprintf("%s:%d (%s)", config.EmailSMTPHost, config.EmailSMTPPort, config.EmailSMTPSecurity))
+		email.add("SMTP user", config.EmailSMTPUser)
+		email.add("Sender", config.EmailNoReply)
+	} else {
+		email.add("SMTP", "disabled")
+	}
+
+	components := summarySection{Title: "Components"}
+	if config.IsEnterprise {
+		components.add("Edition", "Enterprise")
+	} else {
+		components.add("Edition", "Community")
+	}
+	switch {
+	case config.ExternalPostgreSQL:
+		components.add("Database", fmt.Sprintf("external PostgreSQL %s:%d/%s", config.PostgreSQLHost, config.PostgreSQLPort, config.PostgreSQLDatabase))
+	case config.IsPostgreSQL:
+		components.add("Database", "PostgreSQL container")
+	default:
+		components.add("Database", "SQLite")
+	}
+	components.add("Redis", enabledIf(config.IsRedis, ""))
+	components.add("Gerbil tunnels", enabledIf(config.InstallGerbil, ""))
+	components.add("MaxMind GeoLite2", enabledIf(config.EnableMaxMind, ""))
+	if len(config.GeoBlockCountries) > 0 {
+		components.add("Geo-blocking", strings.Join(config.GeoBlockCountries, ", "))
+	}
+	if !config.ExternalProxy {
+		components.add("Hardened HTTP", enabledIf(config.HardenedHTTP, fmt.Sprintf("rate limit %d/s, burst %d", config.RateLimitAverage, config.RateLimitBurst)))
+	}
+	if len(config.TLSPassthroughs) > 0 {
+		var snis []string
+		for _, passthrough := range config.TLSPassthroughs {
+			snis = append(snis, passthrough.SNI)
+		}
+		components.add("TLS passthrough", strings.Join(snis, ", "))
+	}
+	if config.OIDC != nil {
+		components.add("OIDC provider", config.OIDC.Name)
+	}
+	switch config.AutoUpdate {
+	case updateWatchtower:
+		components.add("Automatic updates", "Watchtower, "+config.UpdateSchedule)
+	case updateSchedule:
+		components.add("Automatic updates", "scheduled, "+config.UpdateSchedule)
+	default:
+		components.add("Automatic updates", "disabled")
+	}
+	components.add("Backups", enabledIf(config.Backups(), fmt.Sprintf("%s, %s", config.BackupDir, config.BackupSchedule)))
+	components.add("Docker secrets", enabledIf(config.DockerSecrets, ""))
+	components.add("IPv6", enabledIf(config.EnableIPv6, ""))
+	components.add("Telemetry", enabledIf(config.Telemetry, ""))
+
+	install := summarySection{Title: "Installation"}
+	install.add("Directory", config.InstallDir)
+	if config.SeparateDataDir() {
+		install.add("Data directory", config.DataDir)
+	}
+	install.add("Container runtime", string(config.InstallationContainerType))
+	install.add("Pangolin", config.PangolinVersion)
+	if config.InstallGerbil {
+		install.add("Gerbil", config.GerbilVersion)
+	}
+	install.add("Timezone", config.Timezone)
+
+	return []summarySection{domains, accounts, ports, email, components, install}
+}
+
+// enabledIf is the summary value of a component that is enabled or not,
+// detail describes an enabled one
+func enabledIf(enabled bool, detail string) string {
+	switch {
+	case !enabled:
+		return "disabled"
+	case detail != "":
+		return "enabled, " + detail
+	}
+	return "enabled"
+}
+
+// printInstallSummary prints the sections as a table, the labels aligned
+func printInstallSummary(sections []summarySection) {
+	width := 0
+	for _, section := range sections {
+		for _, row := range section.Rows {
+			width = max(width, len(row.Label))
+		}
+	}
+	title := lipgloss.NewStyle().Foreground(colors.Primary).Bold(true)
+	label := lipgloss.NewStyle().Foreground(colors.Muted)
+	infoln("\n=== Summary ===")
+	for _, section := range sections {
+		if len(section.Rows) == 0 {
+			continue
+		}
+		infoln("\n" + title.Render(section.Title))
+		for _, row := range section.Rows {
+			infof("  %s  %s\n", label.Render(fmt.Sprintf("%-*s", width, row.Label)), row.Value)
+			logf("INFO", "summary %s: %s", row.Label, row.Value)
+		}
+	}
+	infoln()
+}
+
+// confirmInstall shows what config installs before anything is written or
+// started, and asks to go ahead, change an answer or cancel. It returns the
+// index in answers of the answer to change, -1 to go ahead. Cancelling exits.
+func confirmInstall(config Config, answers []sessionAnswer) int {
+	printInstallSummary(installSummary(config))
+
+	options := []Option{{Value: summaryConfirm, Label: tr("summary.confirm")}}
+	editable := editableAnswers(answers)
+	if len(editable) > 0 {
+		options = append(options, Option{Value: summaryEdit, Label: tr("summary.edit")})
+	}
+	options = append(options, Option{Value: summaryCancel, Label: tr("summary.cancel")})
+	choice := readSelectValidated("install_confirm", tr("prompt.install_confirm"), options, summaryConfirm, func(value string) error {
+		if value == summaryEdit && (acceptDefaults || nonInteractive) {
+			return errors.New("changing an answer needs an interactive terminal")
+		}
+		return nil
+	})
+
+	switch choice {
+	case summaryEdit:
+		return pickAnswer(answers, editable)
+	case summaryCancel:
+		fmt.Fprintln(consoleOut, tr("input.cancelled"))
+		logf("INFO", "Installation cancelled at the summary")
+		report.emit("cancelled", "")
+		installLog.close()
+		os.Exit(0)
+	}
+	return -1
+}
+
+// editableAnswers are the indexes of the answers that are given again when
+// the wizard runs again. Answers to the questions after a check, and those
+// replaced by a later answer after one, are not.
+func editableAnswers(answers []sessionAnswer) []int {
+	queued := map[string]int{}
+	for _, queue := range replayQueues(answers, "") {
+		queued[queue[0].Key] = len(queue)
+	}
+	var editable []int
+	for i := len(answers) - 1; i >= 0; i-- {
+		if key := answers[i].Key; queued[key] > 0 {
+			queued[key]--
+			editable = append(editable, i)
+		}
+	}
+	slices.Reverse(editable)
+	return editable
+}
+
+// pickAnswer asks which of the editable answers to change
+func pickAnswer(answers []sessionAnswer, editable []int) int {
+	var options []Option
+	for _, i := range editable {
+		answer := answers[i]
+		value := answer.Value
+		switch {
+		case answer.Masked:
+			value = "********"
+		case value == "true":
+			value = tr("input.yes")
+		case value == "false":
+			value = tr("input.no")
+		}
+		options = append(options, Option{Value: strconv.Itoa(i), Label: answer.Prompt, Description: value})
+	}
+	index, _ := strconv.Atoi(readSelect("edit_answer", tr("prompt.edit_answer"), options, options[0].Value))
+	return index
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	systemdUnitName = "pangolin.service"
+	systemdUnitPath = "/etc/systemd/system/pangolin.service"
+)
+
+// isSystemdHost reports whether systemd is the running init system
+func isSystemdHost() bool {
+	return facts.systemd()
+}
+
+// offerSystemdUnit installs a unit that brings the compose stack up on boot
+func offerSystemdUnit(containerType SupportedContainer, installDir string) {
+	infoln("\n=== Start on Boot ===")
+	if !platform.SystemdUnit {
+		infof("Skipping the pangolin.service unit on %s, start the stack with compose up -d after a reboot.\n", platform.Name)
+		report.skip("systemd unit (" + platform.Name + ")")
+		return
+	}
+	if !isSystemdHost() {
+		infoln("This host does not run systemd, skipping the pangolin.service unit.")
+		infoln("Configure your init system to run the compose stack on boot if needed.")
+		report.skip("systemd unit (not a systemd host)")
+		return
+	}
+	if !isRoot() {
+		infoln("Skipping the pangolin.service unit: not running as root.")
+		report.skip("systemd unit (requires root)")
+		return
+	}
+	if _, err := os.Stat(systemdUnitPath); err == nil {
+		infof("%s already exists, leaving it unchanged.\n", systemdUnitPath)
+		return
+	}
+	if !readBool("install_systemd_unit", tr("prompt.install_systemd_unit"), true) {
+		return
+	}
+
+	unit, err := renderSystemdUnit(containerType, installDir)
+	if err != nil {
+		warnf("Warning: could not create the systemd unit: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(systemdUnitPath, []byte(unit), 0644); err != nil {
+		warnf("Warning: could not write %s: %v\n", systemdUnitPath, err)
+		return
+	}
+	recordExternal(externalResource{
+		Kind:        resourceFile,
+		Path:        systemdUnitPath,
+		Description: "systemd unit for the compose stack",
+		PreRemove:   "systemctl disable " + systemdUnitName,
+		Undo:        "systemctl daemon-reload",
+	})
+
+	if err := run("systemctl", "daemon-reload"); err != nil {
+		warnf("Warning: systemctl daemon-reload failed: %v\n", err)
+		return
+	}
+	if err := run("systemctl", "enable", "--now", systemdUnitName); err != nil {
+		warnf("Warning: could not enable %s: %v\n", systemdUnitName, err)
+		return
+	}
+	infof("Installed and enabled %s. Manage the stack with: systemctl start|stop|status pangolin\n", systemdUnitPath)
+}
+
+// renderSystemdUnit builds the unit file for the detected compose binary and
+// the absolute install path
+func renderSystemdUnit(containerType SupportedContainer, installDir string) (string, error) {
+	installDir, err := filepath.Abs(installDir)
+	if err != nil {
+		return "", err
+	}
+	composeFile := filepath.Join(installDir, "docker-compose.yml")
+
+	up, err := composeCommand(context.Background(), containerType, "-f", composeFile, "up", "-d")
+	if err != nil {
+		return "", err
+	}
+	down, err := composeCommand(context.Background(), containerType, "-f", composeFile, "down")
+	if err != nil {
+		return "", err
+	}
+	if up.Err != nil {
+		return "", fmt.Errorf("compose binary not found: %v", up.Err)
+	}
+
+	var dependency string
+	if containerType == Docker {
+		dependency = "Requires=docker.service\nAfter=docker.service network-online.target"
+	} else {
+		dependency = "After=network-online.target"
+	}
+
+	return fmt.Sprintf(`# Generated by the Pangolin installer
+[Unit]
+Description=Pangolin (%s compose stack in %s)
+%s
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+WorkingDirectory=%s
+ExecStart=%s %s
+ExecStop=%s %s
+TimeoutStartSec=0
+
+[Install]
+WantedBy=multi-user.target
+`, containerType, installDir, dependency, installDir,
+		up.Path, strings.Join(up.Args[1:], " "),
+		down.Path, strings.Join(down.Args[1:], " ")), nil
+}
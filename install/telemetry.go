@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// telemetryURL receives the install statistics of those who agreed to share
+// them. It is injected at build time with ldflags, see the Makefile. Without
+// it the installer shares nothing and only asks about Pangolin's own
+// anonymous usage reporting.
+var telemetryURL string
+
+// telemetryTimeout bounds the whole request, the install never waits longer
+const telemetryTimeout = 3 * time.Second
+
+// noTelemetry is set by --no-telemetry
+var noTelemetry bool
+
+func addNoTelemetryFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&noTelemetry, "no-telemetry", false, "Never share install statistics and turn off Pangolin's anonymous usage reporting, without asking")
+}
+
+// collectTelemetry asks whether the installer and Pangolin may report
+// anonymous usage statistics. The answer is written to config.yml as
+// app.telemetry.anonymous_usage, which used to be always on.
+func collectTelemetry(config *Config) {
+	if noTelemetry {
+		recordFlagAnswer("telemetry", "false")
+		config.Telemetry = false
+		return
+	}
+	prompt := tr("prompt.telemetry")
+	if telemetryURL == "" {
+		prompt = tr("prompt.telemetry_usage")
+	}
+	config.Telemetry = readBool("telemetry", prompt, false)
+}
+
+// telemetryReport is everything the installer shares, nothing identifies the
+// server or its owner
+type telemetryReport struct {
+	Version    string   `json:"version"`
+	OS         string   `json:"os"`
+	Arch       string   `json:"arch"`
+	Components []string `json:"components"`
+}
+
+// telemetryComponents lists the optional parts of the stack config selected
+func telemetryComponents(config Config) []string {
+	var components []string
+	for name, selected := range map[string]bool{
+		"enterprise":     config.IsEnterprise,
+		"gerbil":         config.InstallGerbil,
+		"crowdsec":       config.DoCrowdsecInstall,
+		"postgresql":     config.IsPostgreSQL,
+		"redis":          config.IsRedis,
+		"email":          config.EnableEmail,
+		"maxmind":        config.EnableMaxMind,
+		"geoblock":       len(config.GeoBlockCountries) > 0,
+		"oidc":           config.OIDC != nil,
+		"ipv6":           config.EnableIPv6,
+		"external-proxy": config.ExternalProxy,
+		"backups":        config.Backups(),
+		"auto-updates":   config.AutoUpdate != "" && config.AutoUpdate != updateNone,
+	} {
+		if selected {
+			components = append(components, name)
+		}
+	}
+	slices.Sort(components)
+	if config.InstallationContainerType != "" && config.InstallationContainerType != Undefined {
+		components = append([]string{string(config.InstallationContainerType)}, components...)
+	}
+	return components
+}
+
+// sendTelemetry posts the install statistics in the background when config
+// records the consent. Waiting on the returned channel takes at most
+// telemetryTimeout.
+func sendTelemetry(config Config) <-chan struct{} {
+	done := make(chan struct{})
+	if !config.Telemetry || noTelemetry || telemetryURL == "" || !network.allow("install statistics") {
+		close(done)
+		return done
+	}
+	body, err := json.Marshal(telemetryReport{
+		Version:    pangolinVersion,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Components: telemetryComponents(config),
+	})
+	if err != nil {
+		close(done)
+		return done
+	}
+	logf("INFO", "sending install statistics: %s", body)
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, telemetryURL, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			logf("INFO", "install statistics not sent: %v", err)
+			return
+		}
+		resp.Body.Close()
+		logf("INFO", "install statistics sent: %s", resp.Status)
+	}()
+	return done
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSendTelemetry checks that the statistics are only posted with consent
+// and a telemetry URL built in, and that they carry the selected components
+func TestSendTelemetry(t *testing.T) {
+	received := make(chan telemetryReport, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report telemetryReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Error(err)
+		}
+		received <- report
+	}))
+	defer server.Close()
+	savedURL, savedOffline, savedNoTelemetry := telemetryURL, network.offline, noTelemetry
+	t.Cleanup(func() { telemetryURL, network.offline, noTelemetry = savedURL, savedOffline, savedNoTelemetry })
+	network.offline, noTelemetry = false, false
+
+	tests := []struct {
+		name    string
+		url     string
+		consent bool
+		sent    bool
+	}{
+		{"no URL built in", "", true, false},
+		{"no consent", server.URL, false, false},
+		{"consent", server.URL, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			telemetryURL = tt.url
+			<-sendTelemetry(Config{Telemetry: tt.consent, IsPostgreSQL: true, InstallationContainerType: Docker})
+			select {
+			case report := <-received:
+				if !tt.sent {
+					t.Fatal("the statistics were sent")
+				}
+				if len(report.Components) != 2 || report.Components[1] != "postgresql" {
+					t.Errorf("components %q", report.Components)
+				}
+			default:
+				if tt.sent {
+					t.Fatal("the statistics were not sent")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// templatesDir is set by --templates-dir. A file there replaces the embedded
+// template at the same path below config, e.g. traefik/traefik_config.yml.
+var templatesDir string
+
+// addTemplatesFlag registers --templates-dir on fs. The path is made absolute
+// as the installer changes into the install directory before rendering.
+func addTemplatesFlag(fs *flag.FlagSet) {
+	fs.Func("templates-dir", "Directory with templates that replace the built-in ones of the same path, e.g. docker-compose.yml or traefik/traefik_config.yml (see --dump-templates)", func(dir string) error {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		templatesDir = abs
+		return nil
+	})
+}
+
+// readTemplate returns the template for path, an embedded path such as
+// config/docker-compose.yml, and whether it comes from --templates-dir
+func readTemplate(path string) ([]byte, bool, error) {
+	if templatesDir != "" {
+		override := filepath.Join(templatesDir, filepath.FromSlash(strings.TrimPrefix(path, "config/")))
+		content, err := os.ReadFile(override)
+		if err == nil {
+			return content, true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, false, fmt.Errorf("failed to read %s: %v", override, err)
+		}
+	}
+	content, err := configFiles.ReadFile(path)
+	return content, false, err
+}
+
+// printTemplateOverrides lists the templates --templates-dir replaced, and
+// the files there that match no built-in template
+func printTemplateOverrides(overridden []string) {
+	if templatesDir == "" {
+		return
+	}
+	infof("\nTemplates from %s:\n", templatesDir)
+	if len(overridden) == 0 {
+		infoln("  none, every file uses the built-in template")
+	}
+	for _, path := range overridden {
+		infof("  %s\n", strings.TrimPrefix(path, "config/"))
+	}
+	filepath.WalkDir(templatesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(templatesDir, path)
+		if err != nil {
+			return nil
+		}
+		if _, err := fs.Stat(configFiles, "config/"+filepath.ToSlash(rel)); err != nil {
+			warnf("Warning: %s matches no built-in template and is ignored\n", path)
+		}
+		return nil
+	})
+}
+
+// dumpTemplates writes the embedded templates to dir, as a starting point for
+// --templates-dir
+func dumpTemplates(dir string) error {
+	var count int
+	err := fs.WalkDir(configFiles, "config", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := configFiles.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(path, "config/")))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, 0644); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	infof("Wrote %d templates of installer %s to %s.\n", count, orUnknown(pangolinVersion), dir)
+	infof("Keep the files you want to change, delete the others, and pass --templates-dir %s.\n", dir)
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// answeredConfig answers every prompt of a fresh install like --yes with
+// flags and returns the configuration an offline install renders. The host's
+// time zone, route and the random secrets are fixed so the output is stable.
+func answeredConfig(t *testing.T, flags map[string]string) Config {
+	t.Helper()
+	answers := map[string]string{
+		"base_domain": "example.com",
+		"admin_email": "admin@example.com",
+		"timezone":    "UTC",
+	}
+	for key, value := range flags {
+		answers[key] = value
+	}
+	withAnswers(t, answers)
+	withVersions(t)
+	savedOffline, savedRoute, savedConsole := network.offline, routeSource, consoleOut
+	t.Cleanup(func() { network.offline, routeSource, consoleOut = savedOffline, savedRoute, savedConsole })
+	acceptDefaults, network.offline = true, true
+	routeSource = func(string, string) net.IP { return nil }
+	consoleOut = io.Discard
+
+	var config Config
+	if code := catchExit(t, func() { config = collectUserInput() }); code != -1 {
+		t.Fatalf("the prompts exited with %d", code)
+	}
+	config.InstallDir = "/opt/pangolin"
+	loadVersions(&config)
+	config.Secret = "0123456789abcdef0123456789abcdef"
+	return config
+}
+
+// checkGolden renders config and compares the files at paths with
+// testdata/golden/name, or rewrites them with -update
+func checkGolden(t *testing.T, name string, config Config, paths ...string) {
+	t.Helper()
+	_, files, err := renderConfigFiles(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := map[string][]byte{}
+	for _, file := range files {
+		rendered[file.Path] = file.Content
+	}
+	for _, path := range paths {
+		content, ok := rendered[path]
+		if !ok {
+			t.Errorf("%s was not rendered", path)
+			continue
+		}
+		golden := filepath.Join("testdata", "golden", name, filepath.FromSlash(path))
+		if *update {
+			if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(golden, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%v, run go test -update to create it", err)
+		}
+		if !bytes.Equal(content, want) {
+			t.Errorf("%s differs from %s, run go test -update and review the diff:\n%s", path, golden, renderFileDiff(path, want, content, nil))
+		}
+	}
+}
+
+// TestRenderCustomPorts renders an install with other entry point, WireGuard
+// and Pangolin ports than the defaults. Every published port, healthcheck,
+// entry point and service URL must follow them.
+func TestRenderCustomPorts(t *testing.T) {
+	files := []string{"config/docker-compose.yml", "config/traefik/traefik_config.yml", "config/traefik/dynamic_config.yml", "config/config.yml"}
+	t.Run("default ports", func(t *testing.T) {
+		checkGolden(t, "default-ports", answeredConfig(t, nil), files...)
+	})
+	t.Run("custom ports", func(t *testing.T) {
+		config := answeredConfig(t, map[string]string{
+			"custom_ports":            "true",
+			"http_port":               "8080",
+			"https_port":              "8443",
+			"wireguard_port":          "51830",
+			"acme_challenge":          "dns-01",
+			"cf_dns_api_token":        "token",
+			"custom_server_ports":     "true",
+			"server_external_port":    "4000",
+			"server_internal_port":    "4001",
+			"server_next_port":        "4002",
+			"server_integration_port": "4003",
+		})
+		checkGolden(t, "custom-ports", config, files...)
+	})
+}
+
+// TestRenderMatrix renders every HTTP mode with every ACME challenge, without
+// and with CrowdSec, and an install with the bundled Traefik or behind an
+// existing reverse proxy without and with IPv6. HTTP-01 needs port 80, it is
+// not offered with HTTP disabled. Every render also passes the compose-go
+// loader, see validateRenderedFiles.
+func TestRenderMatrix(t *testing.T) {
+	for _, crowdsec := range []bool{false, true} {
+		for _, httpMode := range []string{httpRedirect, httpServe, httpDisabled} {
+			for _, challenge := range []string{challengeHTTP, challengeTLSALPN, challengeDNS} {
+				if httpMode == httpDisabled && challenge == challengeHTTP {
+					continue
+				}
+				name := httpMode + "-" + challenge
+				files := []string{"config/docker-compose.yml", "config/traefik/traefik_config.yml", "config/traefik/dynamic_config.yml"}
+				if crowdsec {
+					name = "crowdsec-" + name
+					files = []string{"config/crowdsec/docker-compose.yml", "config/crowdsec/traefik_config.yml", "config/crowdsec/dynamic_config.yml"}
+				}
+				t.Run(name, func(t *testing.T) {
+					flags := map[string]string{"http_mode": httpMode, "acme_challenge": challenge}
+					if challenge == challengeDNS {
+						flags["cf_dns_api_token"] = "token"
+					}
+					config := answeredConfig(t, flags)
+					config.DoCrowdsecInstall = crowdsec
+					checkGolden(t, filepath.Join("matrix", name), config, files...)
+				})
+			}
+		}
+	}
+	for _, ipv6 := range []bool{false, true} {
+		for _, installType := range []string{installTypeTraefik, installTypeExistingProxy} {
+			name := installType
+			if ipv6 {
+				name = "ipv6-" + name
+			} else if installType == installTypeTraefik {
+				// the redirect-http-01 case above
+				continue
+			}
+			t.Run(name, func(t *testing.T) {
+				config := answeredConfig(t, map[string]string{"install_type": installType, "enable_ipv6": strconv.FormatBool(ipv6)})
+				if config.EnableIPv6 != ipv6 {
+					t.Fatalf("EnableIPv6 is %t", config.EnableIPv6)
+				}
+				checkGolden(t, filepath.Join("matrix", name), config, "config/docker-compose.yml", "config/config.yml")
+			})
+		}
+	}
+}
+
+// TestRenderAnonymousUsage checks that Pangolin's anonymous usage reporting
+// in config.yml follows the telemetry answer. It used to be always on, a
+// fresh install now leaves it off unless telemetry is accepted.
+func TestRenderAnonymousUsage(t *testing.T) {
+	for _, telemetry := range []bool{false, true} {
+		t.Run(strconv.FormatBool(telemetry), func(t *testing.T) {
+			config := answeredConfig(t, map[string]string{"telemetry": strconv.FormatBool(telemetry)})
+			_, files, err := renderConfigFiles(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range files {
+				if file.Path != "config/config.yml" {
+					continue
+				}
+				var content struct {
+					App struct {
+						Telemetry struct {
+							AnonymousUsage bool `yaml:"anonymous_usage"`
+						} `yaml:"telemetry"`
+					} `yaml:"app"`
+				}
+				if err := yaml.Unmarshal(file.Content, &content); err != nil {
+					t.Fatal(err)
+				}
+				if content.App.Telemetry.AnonymousUsage != telemetry {
+					t.Errorf("anonymous_usage is %t", content.App.Telemetry.AnonymousUsage)
+				}
+				return
+			}
+			t.Fatal("config.yml was not rendered")
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// terminalOptions is how the installer draws on the terminal. The flags fill
+// it and resolveTerminal folds in the environment once, after the flags were
+// parsed and a simulated fingerprint was loaded.
+type terminalOptions struct {
+	accessibleFlag bool
+	noColorFlag    bool
+	themeFlag      string
+	backgroundFlag string
+	langFlag       string
+	groupedFlag    bool
+
+	// accessible uses plain line-based prompts instead of the huh forms
+	accessible bool
+	// noColor prints everything without colors or styles
+	noColor bool
+	// grouped shows related questions on one screen, see formGroup
+	grouped bool
+}
+
+var terminal terminalOptions
+
+// addTerminalFlags registers --accessible, --no-color, --theme, --background
+// --lang and --grouped-forms on fs
+func addTerminalFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&terminal.accessibleFlag, "accessible", false, "Use plain line-based prompts, e.g. for screen readers (also set by the ACCESSIBLE environment variable)")
+	fs.BoolVar(&terminal.noColorFlag, "no-color", false, "Print without colors (also set by the NO_COLOR environment variable)")
+	fs.StringVar(&terminal.themeFlag, "theme", "", "Color theme: default, high-contrast or colorblind (default $PANGOLIN_THEME, else default)")
+	fs.StringVar(&terminal.backgroundFlag, "background", "", "Terminal background: dark, light or auto to detect it (default $PANGOLIN_BACKGROUND, else auto)")
+	fs.StringVar(&terminal.langFlag, "lang", "", "Language of the prompts and the summary: "+strings.Join(languages(), ", ")+" (default from LC_ALL, LC_MESSAGES or LANG, else en)")
+	fs.BoolVar(&terminal.groupedFlag, "grouped-forms", false, "Ask related questions, like all SMTP settings, on one screen instead of one per screen (also set by PANGOLIN_GROUPED_FORMS, ignored in accessible mode)")
+}
+
+// resolveTerminal decides the terminal options. Accessible mode is used for
+// --accessible, non-TTY stdin, TERM=dumb and ACCESSIBLE, colors are turned off
+// for --no-color and NO_COLOR for everything rendered through lipgloss, and
+// --theme or PANGOLIN_THEME pick the palette. The palette is drawn for the
+// background of --background or PANGOLIN_BACKGROUND, which lipgloss otherwise
+// guesses, often wrongly over SSH and inside tmux. --lang or the locale
+// environment pick the language of the prompts. --grouped-forms or
+// PANGOLIN_GROUPED_FORMS put related questions on one screen.
+func resolveTerminal() {
+	terminal.accessible = terminal.accessibleFlag ||
+		!facts.stdinTerminal() ||
+		facts.term() == "dumb" ||
+		os.Getenv("ACCESSIBLE") != ""
+	terminal.grouped = terminal.groupedFlag || os.Getenv("PANGOLIN_GROUPED_FORMS") != ""
+	terminal.noColor = terminal.noColorFlag || os.Getenv("NO_COLOR") != ""
+	if terminal.noColor {
+		lipgloss.SetColorProfile(termenv.Ascii)
+	}
+	dark, err := resolveBackground(terminal.backgroundFlag)
+	if err != nil {
+		exitf(exitInvalidInput, "Error: %v\n", err)
+	}
+	lipgloss.SetHasDarkBackground(dark)
+	if err := selectTheme(terminal.themeFlag, dark); err != nil {
+		exitf(exitInvalidInput, "Error: %v\n", err)
+	}
+	if err := selectLanguage(terminal.langFlag); err != nil {
+		exitf(exitInvalidInput, "Error: %v\n", err)
+	}
+}
+
+// resolveBackground reports whether the terminal background is dark
+func resolveBackground(name string) (bool, error) {
+	if name == "" {
+		name = os.Getenv("PANGOLIN_BACKGROUND")
+	}
+	switch name {
+	case "dark":
+		return true, nil
+	case "light":
+		return false, nil
+	case "", "auto":
+		return lipgloss.HasDarkBackground(), nil
+	}
+	return false, fmt.Errorf("unsupported background %q (expected dark, light or auto)", name)
+}
@@ -1,51 +1,166 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/lipgloss"
 )
 
+// palette is the set of colors and glyphs everything the installer styles is
+// drawn with
+type palette struct {
+	Primary lipgloss.TerminalColor
+	Muted   lipgloss.TerminalColor
+	Success lipgloss.TerminalColor
+	Error   lipgloss.TerminalColor
+	Normal  lipgloss.TerminalColor
+	// Button is the background of an unfocused button
+	Button lipgloss.TerminalColor
+
+	SuccessGlyph string
+	ErrorGlyph   string
+}
+
 // Pangolin brand colors (converted from oklch to hex)
-var (
+var defaultPalette = palette{
 	// Primary orange/amber - oklch(0.6717 0.1946 41.93)
-	primaryColor = lipgloss.AdaptiveColor{Light: "#D97706", Dark: "#F59E0B"}
-	// Muted foreground
-	mutedColor = lipgloss.AdaptiveColor{Light: "#737373", Dark: "#A3A3A3"}
-	// Success green
-	successColor = lipgloss.AdaptiveColor{Light: "#16A34A", Dark: "#22C55E"}
+	Primary: lipgloss.AdaptiveColor{Light: "#D97706", Dark: "#F59E0B"},
+	Muted:   lipgloss.AdaptiveColor{Light: "#737373", Dark: "#A3A3A3"},
+	Success: lipgloss.AdaptiveColor{Light: "#16A34A", Dark: "#22C55E"},
 	// Error red - oklch(0.577 0.245 27.325)
-	errorColor = lipgloss.AdaptiveColor{Light: "#DC2626", Dark: "#EF4444"}
-	// Normal text
-	normalFg = lipgloss.AdaptiveColor{Light: "#171717", Dark: "#FAFAFA"}
-)
+	Error:        lipgloss.AdaptiveColor{Light: "#DC2626", Dark: "#EF4444"},
+	Normal:       lipgloss.AdaptiveColor{Light: "#171717", Dark: "#FAFAFA"},
+	Button:       lipgloss.AdaptiveColor{Light: "#E5E5E5", Dark: "#404040"},
+	SuccessGlyph: "✓",
+	ErrorGlyph:   "✗",
+}
+
+// highContrastPalette keeps text at full black or white and uses the
+// saturated ends of each hue so muted text stays readable
+var highContrastPalette = palette{
+	Primary:      lipgloss.AdaptiveColor{Light: "#7C2D12", Dark: "#FFD60A"},
+	Muted:        lipgloss.AdaptiveColor{Light: "#262626", Dark: "#E5E5E5"},
+	Success:      lipgloss.AdaptiveColor{Light: "#14532D", Dark: "#4ADE80"},
+	Error:        lipgloss.AdaptiveColor{Light: "#7F1D1D", Dark: "#FF6B6B"},
+	Normal:       lipgloss.AdaptiveColor{Light: "#000000", Dark: "#FFFFFF"},
+	Button:       lipgloss.AdaptiveColor{Light: "#A3A3A3", Dark: "#525252"},
+	SuccessGlyph: "✓",
+	ErrorGlyph:   "✗",
+}
+
+// colorblindPalette tells success and failure apart by blue and orange from
+// the Okabe-Ito palette, and by glyphs that differ in shape
+var colorblindPalette = palette{
+	Primary:      lipgloss.AdaptiveColor{Light: "#0072B2", Dark: "#56B4E9"},
+	Muted:        lipgloss.AdaptiveColor{Light: "#737373", Dark: "#A3A3A3"},
+	Success:      lipgloss.AdaptiveColor{Light: "#0072B2", Dark: "#56B4E9"},
+	Error:        lipgloss.AdaptiveColor{Light: "#D55E00", Dark: "#E69F00"},
+	Normal:       lipgloss.AdaptiveColor{Light: "#171717", Dark: "#FAFAFA"},
+	Button:       lipgloss.AdaptiveColor{Light: "#E5E5E5", Dark: "#404040"},
+	SuccessGlyph: "✓",
+	ErrorGlyph:   "×",
+}
+
+// themes maps the --theme values to their palettes
+var themes = map[string]palette{
+	"default":       defaultPalette,
+	"high-contrast": highContrastPalette,
+	"colorblind":    colorblindPalette,
+}
+
+// colors is the palette of the selected theme resolved for the terminal
+// background, see selectTheme
+var colors = defaultPalette.on(true)
+
+// pangolinTheme is the huh theme of the selected palette
+var pangolinTheme = ThemePangolin(true)
+
+// on resolves the adaptive colors of p for a dark or light background
+func (p palette) on(dark bool) palette {
+	for _, c := range []*lipgloss.TerminalColor{&p.Primary, &p.Muted, &p.Success, &p.Error, &p.Normal, &p.Button} {
+		if adaptive, ok := (*c).(lipgloss.AdaptiveColor); ok {
+			if dark {
+				*c = lipgloss.Color(adaptive.Dark)
+			} else {
+				*c = lipgloss.Color(adaptive.Light)
+			}
+		}
+	}
+	return p
+}
+
+// selectTheme switches to the theme named by --theme or PANGOLIN_THEME, drawn
+// for a dark or light background
+func selectTheme(name string, dark bool) error {
+	if name == "" {
+		name = os.Getenv("PANGOLIN_THEME")
+	}
+	if name == "" {
+		name = "default"
+	}
+	p, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unsupported theme %q (expected default, high-contrast or colorblind)", name)
+	}
+	colors = p.on(dark)
+	pangolinTheme = themeFromPalette(p, dark)
+	return nil
+}
 
 // ThemePangolin returns a huh theme using Pangolin brand colors
-func ThemePangolin() *huh.Theme {
+func ThemePangolin(dark bool) *huh.Theme {
+	return themeFromPalette(defaultPalette, dark)
+}
+
+// ThemePangolinHighContrast returns a huh theme for terminals where the brand
+// colors are hard to read
+func ThemePangolinHighContrast(dark bool) *huh.Theme {
+	return themeFromPalette(highContrastPalette, dark)
+}
+
+// ThemePangolinColorblind returns a huh theme safe for red-green colorblind
+// users
+func ThemePangolinColorblind(dark bool) *huh.Theme {
+	return themeFromPalette(colorblindPalette, dark)
+}
+
+func themeFromPalette(p palette, dark bool) *huh.Theme {
+	p = p.on(dark)
 	t := huh.ThemeBase()
 
 	// Focused state styles
-	t.Focused.Base = t.Focused.Base.BorderForeground(primaryColor)
-	t.Focused.Title = t.Focused.Title.Foreground(primaryColor).Bold(true)
-	t.Focused.Description = t.Focused.Description.Foreground(mutedColor)
-	t.Focused.ErrorIndicator = t.Focused.ErrorIndicator.Foreground(errorColor)
-	t.Focused.ErrorMessage = t.Focused.ErrorMessage.Foreground(errorColor)
-	t.Focused.SelectSelector = t.Focused.SelectSelector.Foreground(primaryColor)
-	t.Focused.NextIndicator = t.Focused.NextIndicator.Foreground(primaryColor)
-	t.Focused.PrevIndicator = t.Focused.PrevIndicator.Foreground(primaryColor)
-	t.Focused.Option = t.Focused.Option.Foreground(normalFg)
-	t.Focused.SelectedOption = t.Focused.SelectedOption.Foreground(primaryColor)
-	t.Focused.SelectedPrefix = lipgloss.NewStyle().Foreground(successColor).SetString("✓ ")
-	t.Focused.UnselectedPrefix = lipgloss.NewStyle().Foreground(mutedColor).SetString("  ")
-	t.Focused.FocusedButton = t.Focused.FocusedButton.Foreground(lipgloss.Color("#FFFFFF")).Background(primaryColor)
-	t.Focused.BlurredButton = t.Focused.BlurredButton.Foreground(normalFg).Background(lipgloss.AdaptiveColor{Light: "#E5E5E5", Dark: "#404040"})
-	t.Focused.TextInput.Cursor = t.Focused.TextInput.Cursor.Foreground(primaryColor)
-	t.Focused.TextInput.Prompt = t.Focused.TextInput.Prompt.Foreground(primaryColor)
+	t.Focused.Base = t.Focused.Base.BorderForeground(p.Primary)
+	t.Focused.Title = t.Focused.Title.Foreground(p.Primary).Bold(true)
+	t.Focused.Description = t.Focused.Description.Foreground(p.Muted)
+	t.Focused.ErrorIndicator = t.Focused.ErrorIndicator.Foreground(p.Error)
+	t.Focused.ErrorMessage = t.Focused.ErrorMessage.Foreground(p.Error)
+	t.Focused.SelectSelector = t.Focused.SelectSelector.Foreground(p.Primary)
+	t.Focused.NextIndicator = t.Focused.NextIndicator.Foreground(p.Primary)
+	t.Focused.PrevIndicator = t.Focused.PrevIndicator.Foreground(p.Primary)
+	t.Focused.Option = t.Focused.Option.Foreground(p.Normal)
+	t.Focused.SelectedOption = t.Focused.SelectedOption.Foreground(p.Primary)
+	t.Focused.SelectedPrefix = lipgloss.NewStyle().Foreground(p.Success).SetString(p.SuccessGlyph + " ")
+	t.Focused.UnselectedPrefix = lipgloss.NewStyle().Foreground(p.Muted).SetString("  ")
+	t.Focused.FocusedButton = t.Focused.FocusedButton.Foreground(lipgloss.Color("#FFFFFF")).Background(p.Primary)
+	t.Focused.BlurredButton = t.Focused.BlurredButton.Foreground(p.Normal).Background(p.Button)
+	t.Focused.TextInput.Cursor = t.Focused.TextInput.Cursor.Foreground(p.Primary)
+	t.Focused.TextInput.Prompt = t.Focused.TextInput.Prompt.Foreground(p.Primary)
 
 	// Blurred state inherits from focused but with hidden border
 	t.Blurred = t.Focused
 	t.Blurred.Base = t.Focused.Base.BorderStyle(lipgloss.HiddenBorder())
-	t.Blurred.Title = t.Blurred.Title.Foreground(mutedColor).Bold(false)
-	t.Blurred.TextInput.Prompt = t.Blurred.TextInput.Prompt.Foreground(mutedColor)
+	t.Blurred.Title = t.Blurred.Title.Foreground(p.Muted).Bold(false)
+	t.Blurred.TextInput.Prompt = t.Blurred.TextInput.Prompt.Foreground(p.Muted)
 
 	return t
 }
+
+// stepResult renders the line printed when a step finishes
+func stepResult(title string, err error) string {
+	if err != nil {
+		return lipgloss.NewStyle().Foreground(colors.Error).Render(colors.ErrorGlyph + " " + title)
+	}
+	return lipgloss.NewStyle().Foreground(colors.Success).Render(colors.SuccessGlyph + " " + title)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// withBackground forces the background lipgloss detected and restores it and
+// the selected theme afterwards
+func withBackground(t *testing.T, dark bool) {
+	t.Helper()
+	savedDark, savedColors, savedTheme := lipgloss.HasDarkBackground(), colors, pangolinTheme
+	t.Cleanup(func() {
+		lipgloss.SetHasDarkBackground(savedDark)
+		colors, pangolinTheme = savedColors, savedTheme
+	})
+	lipgloss.SetHasDarkBackground(dark)
+}
+
+// TestResolveBackground checks that --background and PANGOLIN_BACKGROUND win
+// over the detected background, and that auto keeps the detected one
+func TestResolveBackground(t *testing.T) {
+	tests := []struct {
+		flag, env string
+		detected  bool
+		want      bool
+	}{
+		{"dark", "", false, true},
+		{"light", "", true, false},
+		{"", "dark", false, true},
+		{"", "light", true, false},
+		{"light", "dark", true, false},
+		{"auto", "", true, true},
+		{"auto", "", false, false},
+		{"", "", true, true},
+		{"", "", false, false},
+		{"auto", "light", true, true},
+	}
+	for _, tt := range tests {
+		withBackground(t, tt.detected)
+		t.Setenv("PANGOLIN_BACKGROUND", tt.env)
+		got, err := resolveBackground(tt.flag)
+		if err != nil || got != tt.want {
+			t.Errorf("--background=%q PANGOLIN_BACKGROUND=%q detected dark %t: got %t, %v, want %t", tt.flag, tt.env, tt.detected, got, err, tt.want)
+		}
+	}
+
+	t.Setenv("PANGOLIN_BACKGROUND", "")
+	if _, err := resolveBackground("dim"); err == nil {
+		t.Error("resolveBackground accepted dim")
+	}
+}
+
+// TestThemeBackground forces each background and checks that every theme is
+// drawn with the colors for the resolved one, not for whatever lipgloss
+// detected
+func TestThemeBackground(t *testing.T) {
+	for name, p := range themes {
+		for _, dark := range []bool{true, false} {
+			// The detected background is the opposite, the resolved one must win
+			withBackground(t, !dark)
+			if err := selectTheme(name, dark); err != nil {
+				t.Fatal(err)
+			}
+			pick := func(c lipgloss.TerminalColor) lipgloss.TerminalColor {
+				adaptive := c.(lipgloss.AdaptiveColor)
+				if dark {
+					return lipgloss.Color(adaptive.Dark)
+				}
+				return lipgloss.Color(adaptive.Light)
+			}
+			checks := []struct {
+				what      string
+				got, want lipgloss.TerminalColor
+			}{
+				{"primary", colors.Primary, pick(p.Primary)},
+				{"muted", colors.Muted, pick(p.Muted)},
+				{"success", colors.Success, pick(p.Success)},
+				{"error", colors.Error, pick(p.Error)},
+				{"normal", colors.Normal, pick(p.Normal)},
+				{"button", colors.Button, pick(p.Button)},
+				{"title", pangolinTheme.Focused.Title.GetForeground(), pick(p.Primary)},
+				{"description", pangolinTheme.Focused.Description.GetForeground(), pick(p.Muted)},
+				{"error message", pangolinTheme.Focused.ErrorMessage.GetForeground(), pick(p.Error)},
+				{"blurred button", pangolinTheme.Focused.BlurredButton.GetBackground(), pick(p.Button)},
+				{"blurred title", pangolinTheme.Blurred.Title.GetForeground(), pick(p.Muted)},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s theme, dark %t: %s is %v, want %v", name, dark, c.what, c.got, c.want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// zoneTables list the canonical IANA zones, zone1970.tab is preferred
+var zoneTables = []string{"/usr/share/zoneinfo/zone1970.tab", "/usr/share/zoneinfo/zone.tab"}
+
+// timezoneNames returns the IANA zones of the host's tz database, sorted
+func timezoneNames() []string {
+	for _, table := range zoneTables {
+		data, err := os.ReadFile(table)
+		if err != nil {
+			continue
+		}
+		var zones []string
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Split(line, "\t")
+			if strings.HasPrefix(line, "#") || len(fields) < 3 {
+				continue
+			}
+			zones = append(zones, fields[2])
+		}
+		slices.Sort(zones)
+		return append(zones, "UTC")
+	}
+	return []string{"UTC"}
+}
+
+// timezoneSuggestions are the zones followed by their city names. Suggestions
+// match by prefix, the city names let "berl" find Berlin, which
+// resolveTimezone turns into Europe/Berlin.
+func timezoneSuggestions(zones []string) []string {
+	suggestions := slices.Clone(zones)
+	for _, zone := range zones {
+		if city := path.Base(zone); city != zone {
+			suggestions = append(suggestions, strings.ReplaceAll(city, "_", " "))
+		}
+	}
+	return suggestions
+}
+
+// resolveTimezone turns an answer into a zone time.LoadLocation accepts. A
+// city name such as "berlin" or "new york" resolves to its zone.
+func resolveTimezone(input string, zones []string) (string, error) {
+	input = strings.TrimSpace(input)
+	for _, zone := range zones {
+		if strings.EqualFold(zone, input) || strings.EqualFold(strings.ReplaceAll(path.Base(zone), "_", " "), input) {
+			input = zone
+			break
+		}
+	}
+	if input == "" || input == "Local" {
+		return "", fmt.Errorf("enter an IANA time zone such as Europe/Berlin")
+	}
+	if _, err := time.LoadLocation(input); err != nil {
+		return "", fmt.Errorf("%q is not a known time zone, enter one such as Europe/Berlin", input)
+	}
+	return input, nil
+}
+
+// hostTimezone reads the host's zone from /etc/timezone or the target of the
+// /etc/localtime symlink
+func hostTimezone() string {
+	if data, err := os.ReadFile("/etc/timezone"); err == nil {
+		if zone := strings.TrimSpace(string(data)); zone != "" {
+			return zone
+		}
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		if _, zone, ok := strings.Cut(target, "zoneinfo/"); ok {
+			return zone
+		}
+	}
+	return ""
+}
+
+// collectTimezone asks for the time zone of the container logs, defaulting to
+// the host's
+func collectTimezone(config *Config) {
+	zones := timezoneNames()
+	def, err := resolveTimezone(facts.timezone(), zones)
+	if err != nil {
+		def = "UTC"
+	}
+	answer := readValidated("timezone", tr("prompt.timezone"), def, func(s string) error {
+		_, err := resolveTimezone(s, zones)
+		return err
+	}, withSuggestions(timezoneSuggestions(zones)))
+	config.Timezone, _ = resolveTimezone(answer, zones)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// tunnelTarget is what an SSH port-forward has to reach on the server
+type tunnelTarget struct {
+	Domain string
+	// Ports are the server ports to forward, on the server's loopback
+	Ports []int
+	// PlainHTTP is set when Pangolin is reached without Traefik's TLS
+	PlainHTTP bool
+}
+
+// runTunnel implements the tunnel subcommand
+func runTunnel(args []string) {
+	fs := flag.NewFlagSet("tunnel", flag.ExitOnError)
+	dirFlag := fs.String("dir", "", "Installation directory, when run on the server (default: the current directory or /opt/pangolin)")
+	host := fs.String("host", "", "SSH destination of the server, e.g. admin@203.0.113.10")
+	sshPort := fs.Int("ssh-port", 22, "SSH port of the server")
+	localPort := fs.Int("local-port", 0, "Local port to listen on (default: the server port)")
+	domain := fs.String("domain", "", "Dashboard domain, when run on a workstation")
+	remotePort := fs.Int("remote-port", defaultHTTPSPort, "HTTPS port of Traefik on the server, when run on a workstation")
+	connect := fs.Bool("connect", false, "Establish the port-forward with the local ssh binary (workstation only)")
+	addTerminalFlags(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	dir, onServer := *dirFlag, false
+	if dir != "" {
+		onServer = hasExistingInstall(dir)
+	} else {
+		dir, onServer = locateExistingInstall()
+	}
+
+	var target tunnelTarget
+	if onServer {
+		if err := os.Chdir(dir); err != nil {
+			fatalf("Error changing to installation directory: %v\n", err)
+		}
+		target = installedTunnelTarget()
+		if *host == "" {
+			*host = serverSSHDestination()
+		}
+		if *sshPort == 22 {
+			*sshPort = serverSSHPort()
+		}
+	} else {
+		if *domain == "" {
+			fatalf("Error: no installation found here, pass --domain (and --remote-port if Traefik does not listen on 443) to generate the command on a workstation\n")
+		}
+		target = tunnelTarget{Domain: *domain, Ports: []int{*remotePort}}
+	}
+
+	localPorts := make([]int, len(target.Ports))
+	for i, port := range target.Ports {
+		localPorts[i] = port
+		if *localPort != 0 {
+			localPorts[i] = *localPort + i
+		}
+	}
+	command := sshTunnelCommand(*host, *sshPort, localPorts, target.Ports)
+
+	infoln("=== SSH Tunnel ===")
+	if onServer {
+		infof("This is the server (installation in %s). Run this on your workstation:\n\n", dir)
+	} else {
+		infof("Run this on your workstation:\n\n")
+	}
+	infof("  %s\n\n", strings.Join(command, " "))
+	printTunnelInstructions(target, localPorts)
+
+	if !*connect {
+		return
+	}
+	if onServer {
+		fatalf("Error: --connect opens the tunnel from your workstation, run the installer there with --domain and --host\n")
+	}
+	if *host == "" {
+		fatalf("Error: --connect needs --host\n")
+	}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		fatalf("Error: ssh is not installed: %v\n", err)
+	}
+	infoln("Opening the tunnel, press Ctrl+C to close it.")
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	if err := execLogged(cmd, true); err != nil {
+		fatalf("Error: ssh exited: %v\n", err)
+	}
+}
+
+// installedTunnelTarget reads the ports to forward from the installation in
+// the current directory
+func installedTunnelTarget() tunnelTarget {
+	target := tunnelTarget{Domain: installedDashboardDomain()}
+	if installedBehindExistingProxy() {
+		// Pangolin publishes plain HTTP on localhost for the existing proxy
+		target.PlainHTTP = true
+		dashboardPort, apiPort := installedProxyPorts("docker-compose.yml")
+		target.Ports = []int{dashboardPort, apiPort}
+		return target
+	}
+	_, httpsPort := installedEntrypointPorts("config/traefik/traefik_config.yml")
+	target.Ports = []int{httpsPort}
+	return target
+}
+
+// installedProxyPorts returns the localhost dashboard and API ports of an
+// install behind an existing reverse proxy
+func installedProxyPorts(composePath string) (dashboardPort, apiPort int) {
+	ports := installedPangolinPorts("config/config.yml")
+	dashboardPort, apiPort = ports.Next, ports.External
+	if port, ok := installedProxyAPIPort(composePath); ok {
+		apiPort = port
+	}
+	if port, ok := installedProxyPort(composePath, ports.Next); ok {
+		dashboardPort = port
+	}
+	return dashboardPort, apiPort
+}
+
+// sshTunnelCommand builds the ssh invocation forwarding each local port to the
+// matching port on the server's loopback
+func sshTunnelCommand(host string, sshPort int, localPorts, remotePorts []int) []string {
+	command := []string{"ssh", "-N"}
+	if sshPort != 22 {
+		command = append(command, "-p", strconv.Itoa(sshPort))
+	}
+	for i, port := range remotePorts {
+		command = append(command, "-L", fmt.Sprintf("%d:127.0.0.1:%d", localPorts[i], port))
+	}
+	if host == "" {
+		host = "<user>@<server>"
+	}
+	return append(command, host)
+}
+
+func printTunnelInstructions(target tunnelTarget, localPorts []int) {
+	if target.PlainHTTP {
+		infof("The dashboard is then served over plain HTTP on http://127.0.0.1:%d and the API on port %d.\n", localPorts[0], localPorts[1])
+		infof("Reminder: Pangolin's session cookies are issued for %s, so logging in only works through the configured domain.\n", target.Domain)
+		infoln("Use the tunnel to check the stack while your reverse proxy, DNS or certificates are not ready yet.")
+		return
+	}
+
+	infof("Then map the dashboard domain to the tunnel by adding this line to /etc/hosts (C:\\Windows\\System32\\drivers\\etc\\hosts on Windows):\n\n  127.0.0.1 %s\n\n", target.Domain)
+	url := "https://" + target.Domain
+	if localPorts[0] != defaultHTTPSPort {
+		url = "https://" + net.JoinHostPort(target.Domain, strconv.Itoa(localPorts[0]))
+	}
+	infof("and open %s\n", url)
+	infof("Reminder: Pangolin's session cookies are issued for %s, so browse to the domain, not 127.0.0.1.\n", target.Domain)
+	if localPorts[0] != target.Ports[0] {
+		warnf("Warning: the local port differs from the server's, redirects to the configured dashboard URL leave the tunnel.\n")
+	}
+	if localPorts[0] < 1024 {
+		infoln("Listening on a port below 1024 needs root on Linux, run ssh with sudo or pass --local-port.")
+	}
+	infoln("Until Let's Encrypt has issued the certificate your browser warns about Traefik's default certificate.")
+}
+
+// serverSSHDestination guesses the SSH destination of this server from the
+// current SSH session
+func serverSSHDestination() string {
+	fields := strings.Fields(os.Getenv("SSH_CONNECTION"))
+	if len(fields) != 4 {
+		return ""
+	}
+	user := os.Getenv("SUDO_USER")
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	address := fields[2]
+	if user == "" {
+		return address
+	}
+	return user + "@" + address
+}
+
+func serverSSHPort() int {
+	fields := strings.Fields(os.Getenv("SSH_CONNECTION"))
+	if len(fields) == 4 {
+		if port, err := strconv.Atoi(fields[3]); err == nil {
+			return port
+		}
+	}
+	return 22
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// uninstallSummary collects what uninstall did and did not remove
+type uninstallSummary struct {
+	removed []string
+	kept    []string
+}
+
+func (s *uninstallSummary) remove(what string) {
+	s.removed = append(s.removed, what)
+}
+
+func (s *uninstallSummary) keep(what string, reason string) {
+	s.kept = append(s.kept, what+" ("+reason+")")
+}
+
+// Artifacts uninstall can keep when it deletes the installation
+const (
+	keepCertificates = "certificates"
+	keepDatabase     = "database"
+	keepLogs         = "logs"
+	keepSnapshots    = "snapshots"
+)
+
+var keepOptions = []string{keepCertificates, keepDatabase, keepLogs, keepSnapshots}
+
+func (s *uninstallSummary) failed(what string, err error) {
+	s.kept = append(s.kept, fmt.Sprintf("%s (failed: %v)", what, err))
+}
+
+// runUninstall implements the uninstall subcommand
+func runUninstall(args []string) {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	dirFlag := fs.String("dir", "", "Installation directory to remove (default: the current directory or /opt/pangolin)")
+	addConfirmFlag(fs)
+	keepFlag := fs.String("keep", "", "When deleting the installation, keep these artifacts, comma separated: "+strings.Join(keepOptions, ", "))
+	addDryRunFlag(fs, "List what would be removed without removing anything")
+	addTerminalFlags(fs)
+	addLogFileFlag(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	var keep []string
+	for _, artifact := range strings.Split(*keepFlag, ",") {
+		if artifact = strings.TrimSpace(artifact); artifact == "" {
+			continue
+		}
+		if !slices.Contains(keepOptions, artifact) {
+			exitf(exitInvalidInput, "Error: --keep %s is not one of %s\n", artifact, strings.Join(keepOptions, ", "))
+		}
+		keep = append(keep, artifact)
+	}
+
+	dir := *dirFlag
+	if dir == "" {
+		var ok bool
+		if dir, ok = locateExistingInstall(); !ok {
+			fmt.Fprintln(os.Stderr, "Error: no Pangolin installation found in the current directory or /opt/pangolin")
+			os.Exit(1)
+		}
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		fatalf("Error resolving path: %v\n", err)
+	}
+	if !hasExistingInstall(dir) {
+		fatalf("Error: %s does not contain a Pangolin installation\n", dir)
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+	openInstallLog(dir)
+	defer installLog.close()
+
+	var summary uninstallSummary
+	infof("=== Uninstall Pangolin from %s ===\n", dir)
+
+	// Stack and images
+	containerType := detectContainerType()
+	images := composeImages("docker-compose.yml")
+	switch {
+	case containerType == Undefined:
+		summary.keep("containers", "neither Docker nor Podman is running")
+	case readBool("uninstall_stack", tr("prompt.uninstall_stack"), true):
+		if err := runCompose(containerType, "docker-compose.yml", "down", "--remove-orphans"); err != nil {
+			summary.failed("containers", err)
+		} else {
+			summary.remove("containers and networks")
+		}
+		removeManagedResources(containerType, []string{"container", "network"}, &summary)
+
+		if len(images) > 0 && readBool("uninstall_images", tr("prompt.uninstall_images"), false) {
+			for _, image := range images {
+				if err := runCmd(exec.Command(string(containerType), "rmi", image)); err != nil {
+					summary.failed("image "+image, err)
+				} else {
+					summary.remove("image " + image)
+				}
+			}
+		} else {
+			summary.keep("container images", "not selected")
+		}
+	default:
+		summary.keep("containers", "not selected")
+	}
+
+	// Anything created outside the install directory
+	state, err := loadInstallState(".")
+	if err != nil {
+		warnf("Warning: could not read %s: %v\n", installStateFile, err)
+		state = &installState{}
+	}
+	removeExternalResources(state, &summary)
+
+	// Config and data are last and need the domain (or the stack name) typed out
+	phrase := installedDashboardDomain()
+	if phrase == "" {
+		phrase = "pangolin"
+	}
+	// A separate data directory goes together with the install directory
+	targets := []string{dir}
+	if state.DataDir != "" && !pathsOverlap(state.DataDir, dir) {
+		targets = append(targets, state.DataDir)
+	}
+	if readConfirmation("uninstall_delete_data", tr("prompt.uninstall_delete_data", strings.Join(targets, ", ")), phrase) {
+		if *keepFlag == "" {
+			keep = readMultiChoice("uninstall_keep", tr("prompt.uninstall_keep"), keepOptions, nil)
+		}
+		kept := keptPaths(dir, state.DataDir, keep)
+		deleteData := false
+		switch {
+		case slices.ContainsFunc(targets, isUnsafeRemovalTarget):
+			for _, target := range targets {
+				summary.keep(target, "refusing to delete a system directory")
+			}
+		case dryRun:
+			deleteData = true
+			for _, target := range targets {
+				summary.remove(target + keptNote(target, kept))
+			}
+			for _, path := range kept {
+				summary.keep(path, "selected to keep")
+			}
+		default:
+			deleteData = true
+			installLog.close()
+			if err := os.Chdir(filepath.Dir(dir)); err != nil {
+				summary.failed(dir, err)
+				break
+			}
+			for _, target := range targets {
+				if err := removeExcept(target, kept); err != nil {
+					summary.failed(target, err)
+				} else {
+					summary.remove(target + keptNote(target, kept))
+				}
+			}
+			for _, path := range kept {
+				summary.keep(path, "selected to keep")
+			}
+		}
+		// Named volumes hold data like the bind mounts do
+		if deleteData && containerType != Undefined && !slices.Contains(keep, keepDatabase) {
+			removeManagedResources(containerType, []string{"volume"}, &summary)
+		}
+	} else {
+		for _, target := range targets {
+			summary.keep(target, "not confirmed")
+		}
+	}
+
+	infoln("\n=== Uninstall Summary ===")
+	if dryRun {
+		infoln("Would remove:")
+	} else {
+		infoln("Removed:")
+	}
+	if len(summary.removed) == 0 {
+		infoln("  nothing")
+	}
+	for _, item := range summary.removed {
+		infof("  %s\n", item)
+	}
+	infoln("Not removed:")
+	if len(summary.kept) == 0 {
+		infoln("  nothing")
+	}
+	for _, item := range summary.kept {
+		infof("  %s\n", item)
+	}
+	if dryRun {
+		printDryRunCommands()
+		exitDryRun(len(summary.removed) > 0)
+	}
+}
+
+// keptPaths are the paths of the artifacts to keep in the installation in dir
+// and its data directory, those that exist
+func keptPaths(dir, dataDir string, keep []string) []string {
+	var paths []string
+	for _, artifact := range keep {
+		switch artifact {
+		case keepCertificates:
+			paths = append(paths, filepath.Join(dir, "config/letsencrypt"))
+		case keepDatabase:
+			paths = append(paths, filepath.Join(dir, "config/db"))
+			if dataDir != "" {
+				paths = append(paths, dataDir)
+			}
+			paths = append(paths, composeMountSources("postgres", "redis")...)
+		case keepLogs:
+			paths = append(paths, filepath.Join(dir, "config/logs"), filepath.Join(dir, "config/traefik/logs"), filepath.Join(dir, installLogName))
+		case keepSnapshots:
+			paths = append(paths, filepath.Join(dir, snapshotDir))
+		}
+	}
+	var existing []string
+	for _, path := range paths {
+		if path = filepath.Clean(path); pathExists(path) && !slices.Contains(existing, path) {
+			existing = append(existing, path)
+		}
+	}
+	return existing
+}
+
+// composeMountSources lists the host directories the services bind mount
+func composeMountSources(names ...string) []string {
+	doc, err := readComposeDocument()
+	if err != nil {
+		return nil
+	}
+	services := yamlMapValue(doc.Content[0], "services")
+	var sources []string
+	for _, name := range names {
+		volumes := yamlMapValue(yamlMapValue(services, name), "volumes")
+		if volumes == nil {
+			continue
+		}
+		for _, volume := range volumes.Content {
+			if source, _, _ := strings.Cut(volume.Value, ":"); filepath.IsAbs(source) {
+				sources = append(sources, source)
+			}
+		}
+	}
+	return sources
+}
+
+// removeExcept deletes path except for the paths in keep beneath it
+func removeExcept(path string, keep []string) error {
+	if slices.Contains(keep, path) {
+		return nil
+	}
+	if !slices.ContainsFunc(keep, func(kept string) bool { return strings.HasPrefix(kept, path+"/") }) {
+		return os.RemoveAll(path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := removeExcept(filepath.Join(path, entry.Name()), keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keptNote notes that target is only removed in part
+func keptNote(target string, kept []string) string {
+	if slices.ContainsFunc(kept, func(path string) bool { return pathsOverlap(path, target) }) {
+		return " (except what was selected to keep)"
+	}
+	return ""
+}
+
+// removeExternalResources offers to remove everything recorded in the state
+// file. Installed packages are only ever reported.
+func removeExternalResources(state *installState, summary *uninstallSummary) {
+	var removable []externalResource
+	for _, resource := range state.Resources {
+		if resource.Kind == resourcePackage {
+			summary.keep(resource.Description, "installed by the installer, remove it with your package manager")
+			continue
+		}
+		removable = append(removable, resource)
+	}
+	if len(removable) == 0 {
+		return
+	}
+
+	infoln("\nThe installer created the following outside the install directory:")
+	for _, resource := range removable {
+		infof("  %s\n", resource.label())
+	}
+	if !readBool("uninstall_external", tr("prompt.uninstall_external"), true) {
+		for _, resource := range removable {
+			summary.keep(resource.label(), "not selected")
+		}
+		return
+	}
+
+	for _, resource := range removable {
+		if resource.PreRemove != "" {
+			if err := run("bash", "-c", resource.PreRemove); err != nil {
+				warnf("Warning: %s failed: %v\n", resource.PreRemove, err)
+			}
+		}
+		if resource.Path != "" && !dryRun {
+			if err := os.Remove(resource.Path); err != nil && !os.IsNotExist(err) {
+				summary.failed(resource.label(), err)
+				continue
+			}
+		}
+		if resource.Undo != "" {
+			if err := run("bash", "-c", resource.Undo); err != nil {
+				summary.failed(fmt.Sprintf("%s (%s)", resource.label(), resource.Undo), err)
+				continue
+			}
+		}
+		summary.remove(resource.label())
+	}
+}
+
+// composeImages returns the images referenced by a compose file
+func composeImages(composePath string) []string {
+	var images []string
+	data, err := os.ReadFile(composePath)
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if image, ok := strings.CutPrefix(strings.TrimSpace(line), "image:"); ok {
+			image = strings.TrimSpace(image)
+			if image != "" && !slices.Contains(images, image) {
+				images = append(images, image)
+			}
+		}
+	}
+	return images
+}
+
+func installedDashboardDomain() string {
+	appConfig, err := ReadAppConfig("config/config.yml")
+	if err != nil {
+		return ""
+	}
+	parsedURL, err := url.Parse(appConfig.DashboardURL)
+	if err != nil {
+		return ""
+	}
+	return parsedURL.Hostname()
+}
+
+// installedDashboardURL is the dashboard_url from config.yml, including a
+// custom HTTPS port
+func installedDashboardURL() string {
+	appConfig, err := ReadAppConfig("config/config.yml")
+	if err != nil || appConfig.DashboardURL == "" {
+		return "https://" + installedDashboardDomain()
+	}
+	return strings.TrimSuffix(appConfig.DashboardURL, "/")
+}
+
+func isUnsafeRemovalTarget(dir string) bool {
+	home, _ := os.UserHomeDir()
+	return slices.Contains([]string{"/", "/opt", "/etc", "/usr", "/var", "/root", "/home", home}, filepath.Clean(dir))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	latestReleaseURL   = "https://api.github.com/repos/fosrl/pangolin/releases/latest"
+	updateCheckTimeout = 3 * time.Second
+)
+
+// githubRelease is the subset of the GitHub release API response we need
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name               string `json:"name"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+		Digest             string `json:"digest"`
+	} `json:"assets"`
+}
+
+// checkForInstallerUpdate compares the embedded build version against the
+// latest release and offers to replace and re-exec the running installer.
+// Any failure (offline, rate limited, dev build) is silently ignored.
+func checkForInstallerUpdate() {
+	current, ok := parseVersion(pangolinVersion)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+	defer cancel()
+
+	release, err := fetchLatestRelease(ctx)
+	if err != nil {
+		report.skip("installer update check (could not reach GitHub)")
+		return
+	}
+
+	latest, ok := parseVersion(release.TagName)
+	if !ok || !versionLess(current, latest) {
+		return
+	}
+
+	printUpdateNotice(pangolinVersion, release.TagName)
+	if !selfUpdateWanted() {
+		return
+	}
+
+	if err := selfUpdate(release); err != nil {
+		infof("Error updating installer: %v\n", err)
+		infoln("Continuing with the current installer.")
+	}
+}
+
+// selfUpdateWanted asks whether to replace the running installer. Never
+// underneath automation: with --non-interactive, --yes or any preset answer
+// only a preset self_update answer replaces it.
+func selfUpdateWanted() bool {
+	if _, _, preset := presetValue("self_update"); !preset && (nonInteractive || acceptDefaults || presetsActive()) {
+		report.skip("installer self-update (unattended run, pass --self-update to allow it)")
+		return false
+	}
+	return readBool("self_update", tr("prompt.self_update"), true)
+}
+
+func fetchLatestRelease(ctx context.Context) (*githubRelease, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, latestReleaseURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var release githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+func printUpdateNotice(current, latest string) {
+	style := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(colors.Primary).
+		Padding(0, 1)
+	title := lipgloss.NewStyle().Foreground(colors.Primary).Bold(true).Render("A new version of the installer is available!")
+	body := fmt.Sprintf("%s\nYou are running %s, the latest release is %s.", title, current, latest)
+	infoln(style.Render(body))
+}
+
+// selfUpdate downloads the release asset for this platform, verifies it against
+// the digest published by GitHub, swaps it in place of the running binary and
+// re-executes it with the same arguments.
+func selfUpdate(release *githubRelease) error {
+	assetName := fmt.Sprintf("installer_%s_%s", runtime.GOOS, runtime.GOARCH)
+
+	var downloadURL, digest string
+	for _, asset := range release.Assets {
+		if asset.Name == assetName {
+			downloadURL = asset.BrowserDownloadURL
+			digest = asset.Digest
+			break
+		}
+	}
+	if downloadURL == "" {
+		return fmt.Errorf("release %s has no asset named %s", release.TagName, assetName)
+	}
+
+	expected, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || expected == "" {
+		return fmt.Errorf("release %s does not publish a sha256 digest for %s", release.TagName, assetName)
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate running installer: %v", err)
+	}
+	exePath, err = filepath.EvalSymlinks(exePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve installer path: %v", err)
+	}
+
+	// Download next to the current binary so the final rename stays on one filesystem
+	tmpFile, err := os.CreateTemp(filepath.Dir(exePath), ".installer-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	infof("Downloading %s...\n", downloadURL)
+	sum, err := downloadTo(tmpFile, downloadURL)
+	if cerr := tmpFile.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download installer: %v", err)
+	}
+
+	if !strings.EqualFold(sum, expected) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, sum)
+	}
+	infoln("Checksum verified.")
+
+	if err := os.Chmod(tmpPath, 0755); err != nil {
+		return fmt.Errorf("failed to make installer executable: %v", err)
+	}
+	if err := os.Rename(tmpPath, exePath); err != nil {
+		return fmt.Errorf("failed to replace installer: %v", err)
+	}
+
+	infof("Installer updated to %s, restarting...\n\n", release.TagName)
+
+	// Do not check again in the new process
+	args := append([]string{exePath, "--no-update-check"}, os.Args[1:]...)
+	return syscall.Exec(exePath, args, os.Environ())
+}
+
+// downloadTo streams url into w and returns the hex sha256 of the content
+func downloadTo(w io.Writer, url string) (string, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// parseVersion parses "1.2.3" or "v1.2.3" (ignoring any pre-release suffix)
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i != -1 {
+		v = v[:i]
+	}
+
+	fields := strings.Split(v, ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+func versionLess(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
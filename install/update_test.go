@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+// TestSelfUpdateWanted checks that the installer only replaces itself under
+// --yes, --non-interactive or preset answers when self_update is preset too
+func TestSelfUpdateWanted(t *testing.T) {
+	savedConsole := consoleOut
+	t.Cleanup(func() { consoleOut = savedConsole })
+	consoleOut = io.Discard
+
+	tests := []struct {
+		name    string
+		answers map[string]string
+		env     string
+		file    string
+		yes     bool
+		nonInt  bool
+		want    bool
+	}{
+		{"--yes", nil, "", "", true, false, false},
+		{"--non-interactive", nil, "", "", false, true, false},
+		{"flag preset", map[string]string{"base_domain": "example.com"}, "", "", false, false, false},
+		{"environment preset", nil, "base_domain", "", false, false, false},
+		{"answers file", nil, "", "answers.yml", true, false, false},
+		{"--self-update with --yes", map[string]string{"self_update": "true"}, "", "", true, false, true},
+		{"--self-update=false", map[string]string{"self_update": "false"}, "", "", false, false, false},
+		{"environment self_update", nil, "self_update", "", false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAnswers(t, tt.answers)
+			acceptDefaults, nonInteractive, answersFile = tt.yes, tt.nonInt, tt.file
+			if tt.env != "" {
+				value := "example.com"
+				if tt.env == "self_update" {
+					value = "yes"
+				}
+				t.Setenv(answerEnvName(tt.env), value)
+			}
+			var got bool
+			if code := catchExit(t, func() { got = selfUpdateWanted() }); code != -1 {
+				t.Fatalf("exit code %d", code)
+			}
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const registryTimeout = 10 * time.Second
+
+// imageChange is the planned image of a compose service
+type imageChange struct {
+	Service       string `json:"service"`
+	CurrentImage  string `json:"currentImage"`
+	TargetImage   string `json:"targetImage"`
+	CurrentDigest string `json:"currentDigest,omitempty"`
+	TargetDigest  string `json:"targetDigest,omitempty"`
+
+	// missing is set when the registry has no such tag
+	missing bool
+}
+
+// changed reports whether pulling would replace the running image
+func (c imageChange) changed() bool {
+	if c.CurrentImage != c.TargetImage {
+		return true
+	}
+	return c.CurrentDigest != "" && c.TargetDigest != "" && c.CurrentDigest != c.TargetDigest
+}
+
+// upgradeReport is everything an upgrade will change, computed without
+// touching the network beyond registry lookups and without writing to disk
+type upgradeReport struct {
+	InstallerVersion string         `json:"installerVersion"`
+	TargetVersion    string         `json:"targetVersion"`
+	Dir              string         `json:"dir"`
+	Images           []imageChange  `json:"images"`
+	ConfigChanges    []configChange `json:"configChanges"`
+	Notes            []string       `json:"notes,omitempty"`
+	Files            []string       `json:"files"`
+	// InlineSecrets are secrets docker-compose.yml or config/config.yml hold
+	// instead of .env
+	InlineSecrets []string `json:"inlineSecrets,omitempty"`
+	// Problems are the failed pre-flight checks, the upgrade stops on them
+	Problems []string `json:"problems,omitempty"`
+
+	rendered []renderedFile
+}
+
+func (r upgradeReport) empty() bool {
+	for _, image := range r.Images {
+		if image.changed() {
+			return false
+		}
+	}
+	return len(r.Files) == 0 && len(r.InlineSecrets) == 0
+}
+
+// runUpgrade implements the upgrade subcommand
+func runUpgrade(args []string) {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	dirFlag := fs.String("dir", "", "Installation directory to upgrade (default: the current directory or /opt/pangolin)")
+	yesFlag := fs.Bool("yes", false, "Apply the upgrade without asking for confirmation")
+	noSnapshotFlag := fs.Bool("no-snapshot", false, "Skip the snapshot of the config and data that the rollback command restores")
+	versionFlag := fs.String("version", "", "Pangolin release to upgrade to, e.g. v1.12.0 (default: the release this installer was built with)")
+	addDryRunFlag(fs, "Only print the pre-upgrade report, pulling and changing nothing")
+	addConfirmFlag(fs)
+	output := fs.String("output", "text", "Report format: text, or json to print the pre-upgrade report to stdout")
+	addOfflineFlag(fs)
+	addParallelPullsFlag(fs)
+	healthTimeoutFlag = fs.Duration("health-timeout", defaultHealthTimeout, "How long to wait for the stack to become healthy after the upgrade")
+	addTerminalFlags(fs)
+	addLogFileFlag(fs)
+	fs.Parse(args)
+	resolveTerminal()
+
+	if *versionFlag != "" {
+		version, err := normalizeUpgradeVersion(*versionFlag)
+		if err != nil {
+			exitf(exitInvalidInput, "Error: %v\n", err)
+		}
+		upgradeVersion = version
+	}
+
+	switch *output {
+	case "text":
+	case "json":
+		consoleOut = os.Stderr
+	default:
+		fatalf("Error: unsupported --output format %q (expected text or json)\n", *output)
+	}
+
+	dir := *dirFlag
+	if dir == "" {
+		var ok bool
+		if dir, ok = locateExistingInstall(); !ok {
+			fatalf("Error: no Pangolin installation found in the current directory or /opt/pangolin\n")
+		}
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		fatalf("Error resolving path: %v\n", err)
+	}
+	if !hasExistingInstall(dir) {
+		fatalf("Error: %s does not contain a Pangolin installation\n", dir)
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatalf("Error changing to installation directory: %v\n", err)
+	}
+	openInstallLog(dir)
+	defer installLog.close()
+
+	containerType := detectContainerType()
+	upgrade, err := buildUpgradeReport(dir, containerType)
+	if err != nil {
+		fatalf("Error preparing the upgrade: %v\n", err)
+	}
+
+	if *output == "json" {
+		data, err := json.MarshalIndent(upgrade, "", "  ")
+		if err != nil {
+			fatalf("Error encoding the report: %v\n", err)
+		}
+		fmt.Fprintln(os.Stdout, string(data))
+	} else {
+		printUpgradeReport(upgrade)
+		warnEditedFiles(upgrade.rendered)
+	}
+	if len(upgrade.Problems) > 0 {
+		errorf("\nError: the upgrade to Pangolin %s cannot go ahead:\n", upgrade.TargetVersion)
+		for _, problem := range upgrade.Problems {
+			errorf("  %s\n", problem)
+		}
+		exitf(exitPreflight, "Nothing was changed.\n")
+	}
+
+	if upgrade.empty() {
+		infoln("\nPangolin is already up to date.")
+		return
+	}
+	if dryRun {
+		exitDryRun(true)
+	}
+	if containerType == Undefined {
+		exitf(exitContainers, "Error: neither Docker nor Podman is running\n")
+	}
+	if !*yesFlag && !readConfirmation("confirm_upgrade", tr("prompt.confirm_upgrade"), upgrade.TargetVersion) {
+		infoln("Upgrade cancelled, nothing was changed.")
+		return
+	}
+	moveSecrets := len(upgrade.InlineSecrets) > 0 && (*yesFlag || readBool("migrate_env_secrets", tr("prompt.migrate_env_secrets", envFile), true))
+
+	infoln("\n=== Upgrading ===")
+	if *noSnapshotFlag {
+		report.skip("pre-upgrade snapshot (--no-snapshot)")
+	} else {
+		snapshot, err := takeSnapshot(dir, "before the upgrade to Pangolin "+upgrade.TargetVersion, containerType)
+		if err != nil {
+			fatalf("Error: the snapshot failed, nothing was changed: %v\n", err)
+		}
+		infof("Snapshot written to %s, undo the upgrade with the rollback command.\n", snapshot)
+	}
+	if err := backupConfig(); err != nil {
+		fatalf("Error: backup failed: %v\n", err)
+	}
+	infoln("Backed up docker-compose.yml and the config directory (config.tar.gz).")
+	for _, file := range upgrade.rendered {
+		if err := os.WriteFile(file.Path, file.Content, 0644); err != nil {
+			fatalf("Error writing %s: %v\n", file.Path, err)
+		}
+	}
+	if moveSecrets {
+		if err := migrateInlineSecrets(); err != nil {
+			fatalf("Error moving the secrets to %s: %v\n", envFile, err)
+		}
+		infof("Moved %s from docker-compose.yml and config/config.yml to %s.\n", strings.Join(upgrade.InlineSecrets, ", "), envFile)
+	}
+	if err := pullContainers(containerType); err != nil {
+		exitf(exitCodeOf(err, exitContainers), "Error: %v\n", err)
+	}
+	if err := startContainers(containerType); err != nil {
+		exitf(exitContainers, "Error: %v\n", err)
+	}
+	for _, container := range migrationRestarts(upgrade.ConfigChanges) {
+		if err := restartContainer(container, containerType); err != nil {
+			exitf(exitContainers, "Error restarting %s: %v\n", container, err)
+		}
+	}
+	if err := waitForStackHealthy(containerType, installedDashboardDomain()); err != nil {
+		exitf(exitCodeOf(err, exitContainers), "Error: %v\n", err)
+	}
+	infoln("\nUpgrade complete.")
+}
+
+// buildUpgradeReport computes the image, config and file changes of an
+// upgrade to the versions this installer was built with
+func buildUpgradeReport(dir string, containerType SupportedContainer) (upgradeReport, error) {
+	upgrade := upgradeReport{InstallerVersion: pangolinVersion, TargetVersion: targetPangolinVersion(), Dir: dir, Images: []imageChange{}, ConfigChanges: []configChange{}, Files: []string{}}
+	if targetPangolinVersion() == "" || gerbilVersion == "" || badgerVersion == "" {
+		warnf("Warning: this installer was built without pinned versions, image tags are left unchanged.\n")
+	}
+
+	migrated, changes, err := planMigrations()
+	if err != nil {
+		return upgrade, err
+	}
+	upgrade.ConfigChanges = append(upgrade.ConfigChanges, changes...)
+	upgrade.Notes = migrationNotes(changes)
+	upgrade.InlineSecrets = installedInlineSecrets()
+
+	// Migrated files are the base for the version bumps below
+	compose, err := os.ReadFile("docker-compose.yml")
+	if err != nil {
+		return upgrade, err
+	}
+	current := compose
+	if content, ok := migrated["docker-compose.yml"]; ok {
+		current = content
+	}
+	newCompose, images, err := retagComposeImages(current)
+	if err != nil {
+		return upgrade, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+	lookupDigests := network.allow("registry digest lookup")
+	for i := range images {
+		if containerType != Undefined {
+			images[i].CurrentDigest = localImageDigest(containerType, images[i].CurrentImage)
+		}
+		if lookupDigests {
+			digest, err := registryDigest(ctx, images[i].TargetImage)
+			if err != nil {
+				logf("INFO", "could not look up %s: %v", images[i].TargetImage, err)
+			}
+			images[i].TargetDigest = digest
+			images[i].missing = errors.Is(err, errManifestNotFound) && images[i].TargetImage != images[i].CurrentImage
+		}
+	}
+	upgrade.Images = images
+	upgrade.addFile("docker-compose.yml", compose, newCompose)
+
+	traefikPath := "config/traefik/traefik_config.yml"
+	if traefik, err := os.ReadFile(traefikPath); err == nil && badgerVersion != "" {
+		current := traefik
+		if content, ok := migrated[traefikPath]; ok {
+			current = content
+		}
+		updated, err := setYAMLScalar(current, []string{"experimental", "plugins", "badger", "version"}, badgerVersion)
+		if err != nil {
+			logf("WARN", "could not update the badger version: %v", err)
+		} else {
+			upgrade.addFile(traefikPath, traefik, updated)
+		}
+	}
+
+	for _, migration := range configMigrations {
+		if content, ok := migrated[migration.File]; ok && !upgrade.hasFile(migration.File) {
+			old, _ := os.ReadFile(migration.File)
+			upgrade.addFile(migration.File, old, content)
+		}
+	}
+	upgrade.Problems = checkUpgradeCompatibility(upgrade)
+	return upgrade, nil
+}
+
+func (r *upgradeReport) addFile(path string, old, content []byte) {
+	if bytes.Equal(old, content) {
+		return
+	}
+	r.Files = append(r.Files, path)
+	r.rendered = append(r.rendered, renderedFile{Path: path, Content: content})
+}
+
+func (r *upgradeReport) hasFile(path string) bool {
+	for _, file := range r.rendered {
+		if file.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func printUpgradeReport(upgrade upgradeReport) {
+	infof("\n=== Upgrade Report (installer %s) ===\n", upgrade.InstallerVersion)
+	if upgrade.TargetVersion != upgrade.InstallerVersion {
+		infof("Target: Pangolin %s\n", upgrade.TargetVersion)
+	}
+	infof("Directory: %s\n", upgrade.Dir)
+
+	infoln("\nImages:")
+	for _, image := range upgrade.Images {
+		switch {
+		case image.CurrentImage != image.TargetImage:
+			infof("  %-10s %s -> %s\n", image.Service, image.CurrentImage, image.TargetImage)
+		case image.changed():
+			infof("  %-10s %s (new digest)\n", image.Service, image.CurrentImage)
+		default:
+			infof("  %-10s %s (unchanged)\n", image.Service, image.CurrentImage)
+		}
+		if image.CurrentDigest != "" || image.TargetDigest != "" {
+			infof("  %-10s digest %s -> %s\n", "", orUnknown(image.CurrentDigest), orUnknown(image.TargetDigest))
+		}
+	}
+
+	printConfigChanges(upgrade.ConfigChanges, upgrade.Notes)
+	if len(upgrade.InlineSecrets) > 0 {
+		infof("\nSecrets inline in docker-compose.yml or config/config.yml (can be moved to %s): %s\n", envFile, strings.Join(upgrade.InlineSecrets, ", "))
+	}
+
+	infoln("\nFiles:")
+	if len(upgrade.rendered) == 0 {
+		infoln("  none")
+	}
+	secrets := installedSecrets()
+	for _, file := range upgrade.rendered {
+		old, _ := os.ReadFile(file.Path)
+		infof("\n%s", renderFileDiff(file.Path, old, file.Content, secrets))
+	}
+}
+
+func printConfigChanges(changes []configChange, notes []string) {
+	infoln("\nConfig migrations:")
+	if len(changes) == 0 {
+		infoln("  none")
+	}
+	for _, change := range changes {
+		infof("  %s (%s)\n", change, change.Migration)
+	}
+	for _, note := range notes {
+		infof("  Note: %s\n", note)
+	}
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// installedSecrets lists the secret values of the current installation for
+// redaction, the answers that produced it are not available any more
+func installedSecrets() []string {
+	data, err := os.ReadFile("config/config.yml")
+	if err != nil {
+		return nil
+	}
+	var config struct {
+		Server struct {
+			Secret string `yaml:"secret"`
+		} `yaml:"server"`
+		Email struct {
+			SMTPPass string `yaml:"smtp_pass"`
+		} `yaml:"email"`
+		Postgres struct {
+			ConnectionString string `yaml:"connection_string"`
+		} `yaml:"postgres"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil
+	}
+	var secrets []string
+	for _, value := range []string{config.Server.Secret, config.Email.SMTPPass, config.Postgres.ConnectionString} {
+		if !envReference.MatchString(value) {
+			secrets = append(secrets, value)
+		}
+	}
+	vars, _ := readEnvFile()
+	for _, value := range vars {
+		secrets = append(secrets, value)
+	}
+	secrets = append(secrets, installedSecretFiles()...)
+	if doc, err := readComposeDocument(); err == nil {
+		for _, v := range inlineSecrets(doc.Content[0], false) {
+			secrets = append(secrets, v.Value)
+		}
+	}
+	return append(secrets, dnsSecretValues(installedDNSCredentials())...)
+}
+
+// tagPrefix matches the edition prefix of a Pangolin tag such as ee-postgresql-
+var tagPrefix = regexp.MustCompile(`^((?:[a-z]+-)*)`)
+
+// targetImage returns the image a service should run after the upgrade.
+// Images the installer does not pin keep their tag.
+func targetImage(image string) string {
+	repo, tag, ok := strings.Cut(image, ":")
+	if !ok {
+		return image
+	}
+	var version string
+	switch {
+	case strings.HasSuffix(repo, "fosrl/pangolin"):
+		version = targetPangolinVersion()
+	case strings.HasSuffix(repo, "fosrl/gerbil"):
+		version = gerbilVersion
+	}
+	if version == "" {
+		return image
+	}
+	return repo + ":" + tagPrefix.FindString(tag) + version
+}
+
+// retagComposeImages points the pinned images of the compose file at the new
+// versions, only touching the image lines
+func retagComposeImages(data []byte) ([]byte, []imageChange, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("error parsing docker-compose.yml: %v", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil, fmt.Errorf("docker-compose.yml is empty")
+	}
+	services := yamlMapValue(doc.Content[0], "services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("services section not found or invalid")
+	}
+
+	var changes []imageChange
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		image := yamlMapValue(services.Content[i+1], "image")
+		if image == nil {
+			continue
+		}
+		change := imageChange{Service: services.Content[i].Value, CurrentImage: image.Value, TargetImage: targetImage(image.Value)}
+		changes = append(changes, change)
+		if change.TargetImage != change.CurrentImage {
+			data = replaceOnLine(data, image.Line, image.Value, change.TargetImage)
+		}
+	}
+	return data, changes, nil
+}
+
+// setYAMLScalar replaces the scalar at path in place so the rest of the file
+// keeps its formatting and comments
+func setYAMLScalar(data []byte, path []string, value string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("document is empty")
+	}
+	node := doc.Content[0]
+	for _, key := range path {
+		if node = yamlMapValue(node, key); node == nil {
+			return nil, fmt.Errorf("%s not found", strings.Join(path, "."))
+		}
+	}
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("%s is not a scalar", strings.Join(path, "."))
+	}
+	return replaceOnLine(data, node.Line, node.Value, value), nil
+}
+
+// replaceOnLine replaces the first occurrence of old on the 1-based line
+func replaceOnLine(data []byte, line int, old, replacement string) []byte {
+	lines := bytes.Split(data, []byte("\n"))
+	if line < 1 || line > len(lines) {
+		return data
+	}
+	lines[line-1] = bytes.Replace(lines[line-1], []byte(old), []byte(replacement), 1)
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// localImageDigest returns the repo digest of a local image, or ""
+func localImageDigest(containerType SupportedContainer, image string) string {
+	out, err := outputCmd(exec.Command(string(containerType), "image", "inspect", "-f", "{{range .RepoDigests}}{{.}} {{end}}", image))
+	if err != nil {
+		return ""
+	}
+	for _, digest := range strings.Fields(string(out)) {
+		if _, sum, ok := strings.Cut(digest, "@"); ok {
+			return sum
+		}
+	}
+	return ""
+}
+
+// registryDigest asks the registry for the manifest digest of image using an
+// anonymous pull token, without pulling anything
+func registryDigest(ctx context.Context, image string) (string, error) {
+	registry, repo, tag := parseImageRef(image)
+	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repo, tag)
+
+	resp, err := manifestHead(ctx, url, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := registryToken(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = manifestHead(ctx, url, token); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errManifestNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+func manifestHead(ctx context.Context, url, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// registryToken follows a Bearer challenge to get an anonymous token
+func registryToken(ctx context.Context, challenge string) (string, error) {
+	params, ok := strings.CutPrefix(challenge, "Bearer ")
+	if !ok {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok {
+			values[key] = strings.Trim(value, `"`)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, values["realm"], nil)
+	if err != nil {
+		return "", err
+	}
+	query := req.URL.Query()
+	query.Set("service", values["service"])
+	query.Set("scope", values["scope"])
+	req.URL.RawQuery = query.Encode()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// parseImageRef splits an image reference into registry, repository and tag
+// with the Docker Hub defaults applied
+func parseImageRef(image string) (registry, repo, tag string) {
+	repo, tag = image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		repo, tag = image[:i], image[i+1:]
+	}
+	registry = "registry-1.docker.io"
+	if first, rest, ok := strings.Cut(repo, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		registry, repo = first, rest
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	if registry == "registry-1.docker.io" && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return registry, repo, tag
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// upgradeVersion is the Pangolin release set by upgrade --version, the
+// version this installer was built with otherwise
+var upgradeVersion string
+
+// errManifestNotFound is returned by registryDigest for a tag the registry
+// does not have
+var errManifestNotFound = errors.New("no such tag in the registry")
+
+// targetPangolinVersion is the Pangolin release an upgrade moves to
+func targetPangolinVersion() string {
+	if upgradeVersion != "" {
+		return upgradeVersion
+	}
+	return pangolinVersion
+}
+
+// normalizeUpgradeVersion turns v1.2.3 into the image tag 1.2.3
+func normalizeUpgradeVersion(version string) (string, error) {
+	if _, ok := parseVersion(version); !ok || strings.ContainsAny(version, "-+") {
+		return "", fmt.Errorf("--version %q is not a release such as v1.12.0", version)
+	}
+	return strings.TrimPrefix(strings.TrimSpace(version), "v"), nil
+}
+
+// installedPangolinVersion is the version in the Pangolin image tag of the
+// compose file, without the edition prefix
+func installedPangolinVersion() string {
+	doc, err := readComposeDocument()
+	if err != nil {
+		return ""
+	}
+	image := yamlMapValue(yamlMapValue(yamlMapValue(doc.Content[0], "services"), "pangolin"), "image")
+	if image == nil {
+		return ""
+	}
+	_, _, tag := parseImageRef(image.Value)
+	return strings.TrimPrefix(tag, tagPrefix.FindString(tag))
+}
+
+// checkUpgradeCompatibility is the pre-flight of an upgrade. The target must
+// not be older than the installed release, Pangolin only migrates its
+// database forward, and not newer than this installer: it only knows the
+// config schema of the releases up to the one it was built with. The config
+// files as the upgrade leaves them must pass that schema.
+func checkUpgradeCompatibility(upgrade upgradeReport) []string {
+	var problems []string
+	target, targetOK := parseVersion(targetPangolinVersion())
+	installed, installedOK := parseVersion(installedPangolinVersion())
+	supported, supportedOK := parseVersion(pangolinVersion)
+	if targetOK && installedOK && versionLess(target, installed) {
+		problems = append(problems, fmt.Sprintf("Pangolin %s is installed, downgrading to %s is not supported: its database was migrated forward", installedPangolinVersion(), targetPangolinVersion()))
+	}
+	if targetOK && supportedOK && (target[0] > supported[0] || target[0] == supported[0] && target[1] > supported[1]) {
+		problems = append(problems, fmt.Sprintf("this installer knows the config schema of Pangolin %d.%d and older, use the installer of %s to upgrade to it", supported[0], supported[1], targetPangolinVersion()))
+	}
+	for _, image := range upgrade.Images {
+		if image.missing {
+			problems = append(problems, fmt.Sprintf("%s is not published", image.TargetImage))
+		}
+	}
+
+	problems = append(problems, checkInstalledFiles(func(path string) ([]byte, bool) {
+		return upgradedContent(upgrade, path)
+	})...)
+	return problems
+}
+
+// upgradedContent is a file as the upgrade leaves it
+func upgradedContent(upgrade upgradeReport, path string) ([]byte, bool) {
+	for _, file := range upgrade.rendered {
+		if file.Path == path {
+			return file.Content, true
+		}
+	}
+	content, err := os.ReadFile(path)
+	return content, err == nil
+}